@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/snapsync/snapsync/internal/agent"
+	"github.com/spf13/cobra"
+)
+
+func agentCmd() *cobra.Command {
+	var (
+		socketPath string
+		timeout    time.Duration
+		forget     bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "agent",
+		Short: "Cache repository keys for later commands",
+		Long: `Runs a background agent that keeps derived repository keys in memory.
+Commands that need a repository key ask the agent first. The password is only
+prompted for, and Argon2 only run, when no key is cached. Keys are forgotten
+after --timeout.
+
+Set SNAPSYNC_AGENT_SOCK to use a socket other than the default.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if forget {
+				if err := agent.Forget(socketPath); err != nil {
+					return fmt.Errorf("failed to reach agent: %w", err)
+				}
+				fmt.Println("Cached keys forgotten")
+				return nil
+			}
+
+			listener, err := agent.Listen(socketPath)
+			if err != nil {
+				return err
+			}
+
+			// Remove the socket on shutdown
+			sigs := make(chan os.Signal, 1)
+			signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+			go func() {
+				<-sigs
+				listener.Close()
+			}()
+
+			fmt.Printf("Agent listening on %s (keys expire after %s)\n", socketPath, timeout)
+			agent.NewServer(timeout).Serve(listener)
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&socketPath, "socket", agent.DefaultSocketPath(), "Agent socket path")
+	cmd.Flags().DurationVar(&timeout, "timeout", agent.DefaultTimeout, "How long cached keys stay valid")
+	cmd.Flags().BoolVar(&forget, "forget", false, "Tell the running agent to drop all cached keys")
+
+	return cmd
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/snapsync/snapsync/internal/crypto"
+	"github.com/snapsync/snapsync/internal/snapshot"
+	"github.com/spf13/cobra"
+)
+
+func analyzeCmd() *cobra.Command {
+	var (
+		opts       snapshot.AnalyzeOptions
+		minSize    string
+		jsonOutput bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "analyze",
+		Short: "Suggest exclusions for directories that churn without deduplicating",
+		Long: `Compares the recent snapshots of each source and finds directories whose
+files change in most backups while sharing little data with what is already
+stored, such as browser caches and build output. Each suggestion comes with
+the exclusion pattern to add and the space and time it would save per backup.
+
+Sizes are uncompressed and estimated from chunk counts; time is the share of
+each backup's duration spent on the directory's new data.`,
+		Example: `  snapsync analyze --repo /path/to/repo
+  snapsync analyze --repo /path/to/repo --snapshots 30 --min-size 10M --json`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if repoPath == "" {
+				return fmt.Errorf("repository path required (use --repo)")
+			}
+			if opts.Snapshots < 1 {
+				return fmt.Errorf("--snapshots must be at least 1")
+			}
+
+			var err error
+			if opts.MinBytes, err = parseSize(minSize); err != nil {
+				return fmt.Errorf("invalid --min-size: %w", err)
+			}
+
+			return runAnalyze(repoPath, opts, jsonOutput)
+		},
+	}
+
+	cmd.Flags().IntVar(&opts.Snapshots, "snapshots", 10, "Recent backups of each source to compare")
+	cmd.Flags().StringVar(&minSize, "min-size", "1M", "Ignore directories adding less new data per backup")
+	cmd.Flags().Float64Var(&opts.MinChurn, "min-churn", 0.5, "Share of a directory's files changed per backup, 0 to 1")
+	cmd.Flags().Float64Var(&opts.MaxDedup, "max-dedup", 0.5, "Share of changed data already stored, 0 to 1")
+	cmd.Flags().BoolVar(&jsonOutput, "json", false, "Output in JSON format")
+
+	return cmd
+}
+
+func runAnalyze(repoPath string, opts snapshot.AnalyzeOptions, jsonOutput bool) error {
+	// File names are only readable with the key when they are encrypted
+	var encryptor *crypto.Encryptor
+	if namesEncrypted(repoPath) {
+		var err error
+		encryptor, err = openEncryptor(repoPath, loadRepoConfig(repoPath), "Enter repository password: ")
+		if err != nil {
+			return err
+		}
+	}
+
+	mgr, err := snapshot.NewManager(repoPath, nil, encryptor)
+	if err != nil {
+		return fmt.Errorf("failed to open repository: %w", err)
+	}
+
+	suggestions, err := mgr.Analyze(opts)
+	if err != nil {
+		return fmt.Errorf("failed to analyze snapshots: %w", err)
+	}
+
+	if jsonOutput {
+		if suggestions == nil {
+			suggestions = []snapshot.ChurnDir{}
+		}
+		output, _ := json.MarshalIndent(suggestions, "", "  ")
+		fmt.Println(string(output))
+		return nil
+	}
+
+	if len(suggestions) == 0 {
+		fmt.Println("No exclusion suggestions")
+		return nil
+	}
+
+	var space int64
+	for _, d := range suggestions {
+		space += d.SpaceSaved
+
+		fmt.Printf("%s\n", d.Path)
+		fmt.Printf("  Source:     %s\n", d.Root)
+		fmt.Printf("  Contents:   %d files, %s\n", d.Files, formatBytes(d.Size))
+		fmt.Printf("  Churn:      %.0f%% of files changed per backup, %.0f%% deduplicated\n", 100*d.Churn(), 100*d.Dedup())
+		fmt.Printf("  Saves:      about %s and %s per backup\n", formatBytes(d.SpaceSaved), d.TimeSaved)
+		fmt.Printf("  Exclude:    %s\n", d.Pattern)
+		fmt.Println()
+	}
+
+	fmt.Printf("%d suggestions, about %s of new data per backup\n", len(suggestions), formatBytes(space))
+	fmt.Println("Add patterns with --exclude or under exclusions in the configuration;")
+	fmt.Println("check them first with snapsync exclude-test.")
+	return nil
+}
@@ -6,62 +6,104 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"syscall"
 	"time"
 
+	"github.com/snapsync/snapsync/internal/appdata"
+	"github.com/snapsync/snapsync/internal/chunker"
 	"github.com/snapsync/snapsync/internal/compress"
 	"github.com/snapsync/snapsync/internal/config"
 	"github.com/snapsync/snapsync/internal/crypto"
+	"github.com/snapsync/snapsync/internal/fssnap"
+	"github.com/snapsync/snapsync/internal/progress"
 	"github.com/snapsync/snapsync/internal/snapshot"
+	"github.com/snapsync/snapsync/internal/tuning"
+	"github.com/snapsync/snapsync/pkg/models"
 	"github.com/spf13/cobra"
 	"golang.org/x/crypto/ssh/terminal"
 )
 
 func backupCmd() *cobra.Command {
 	var (
-		description string
-		encrypt     bool
-		noCompress  bool
-		exclude     []string
+		noCompress bool
+		retries    int
+		opts       models.BackupOptions
 	)
 
 	cmd := &cobra.Command{
 		Use:   "backup [source]",
 		Short: "Create a backup snapshot",
-		Long:  "Creates a new snapshot of the source directory in the repository.",
-		Args:  cobra.ExactArgs(1),
-		RunE: func(cmd *cobra.Command, args []string) error {
-			sourcePath := args[0]
+		Long: `Creates a new snapshot of the source directory or block device in the repository.
 
+The source can also be an S3 or Google Cloud Storage bucket, given as
+s3://bucket/prefix or gs://bucket/prefix. Its objects are backed up as files,
+and only objects whose ETag or size changed since the last backup of the
+bucket are downloaded.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
 			if repoPath == "" {
 				return fmt.Errorf("repository path required (use --repo)")
 			}
 
-			return runBackup(sourcePath, repoPath, description, encrypt, !noCompress, exclude)
+			opts.SourcePath = args[0]
+			opts.RepoPath = repoPath
+			opts.Compress = !noCompress
+			if cmd.Flags().Changed("retries") {
+				opts.Retries = &retries
+			}
+
+			_, err := runBackup(opts)
+			return err
 		},
 	}
 
-	cmd.Flags().StringVarP(&description, "description", "d", "", "Snapshot description")
-	cmd.Flags().BoolVarP(&encrypt, "encrypt", "e", false, "Enable encryption")
+	cmd.Flags().StringVarP(&opts.Description, "description", "d", "", "Snapshot description")
+	cmd.Flags().BoolVarP(&opts.Encrypt, "encrypt", "e", false, "Enable encryption")
 	cmd.Flags().BoolVar(&noCompress, "no-compress", false, "Disable compression")
-	cmd.Flags().StringArrayVarP(&exclude, "exclude", "x", nil, "Exclude patterns")
+	cmd.Flags().StringArrayVarP(&opts.ExcludePattern, "exclude", "x", nil, "Exclude patterns")
+	cmd.Flags().StringArrayVar(&opts.ExcludePresets, "exclude-preset", nil, "Built-in exclusion preset: "+strings.Join(config.PresetNames(), ", ")+" (repeatable)")
+	cmd.Flags().StringArrayVar(&opts.AppPresets, "app-preset", nil, "App data preset for live application data: "+strings.Join(appdata.Names(), ", ")+" (repeatable)")
+	cmd.Flags().BoolVar(&opts.MMap, "mmap", false, "Read source files through memory mappings")
+	cmd.Flags().StringArrayVar(&opts.Tags, "tag", nil, "Tag to record on the snapshot (repeatable)")
+	cmd.Flags().IntVar(&retries, "retries", 0, "Passes over busy or vanished files before leaving them out (default from config)")
+	cmd.Flags().IntVar(&opts.ScanWorkers, "scan-workers", 0, "Directories listed and files hashed at once while scanning (default from config)")
+	cmd.Flags().IntVar(&opts.ScanRate, "scan-rate", 0, "Cap scanning at this many stat/readdir/open calls per second (default from config)")
+	cmd.Flags().IntVar(&opts.Parallel, "parallel", 0, "Workers for each stage: files chunked, chunks encoded and chunks written at once (default from config)")
+	cmd.Flags().StringVar(&opts.Expire, "expire", "", "Remove the snapshot automatically after a date or duration (e.g. 30d)")
+	cmd.Flags().StringVar(&opts.RetainUntil, "retain-until", "", "Lock the snapshot against deletion until a date or for a duration (e.g. 7y)")
+	cmd.Flags().StringVar(&opts.Tier, "tier", "", "Pin the snapshot to a retention tier (e.g. monthly)")
+	cmd.Flags().BoolVar(&opts.FSSnapshot, "fs-snapshot", false, "Back up from a temporary btrfs/ZFS/APFS snapshot")
+	cmd.Flags().BoolVar(&opts.LVMSnapshot, "lvm-snapshot", false, "Back up from a temporary read-only LVM snapshot")
+	cmd.Flags().BoolVar(&opts.MetadataOnly, "metadata-only", false, "Record paths, sizes and hashes without storing file contents")
+	cmd.Flags().StringVar(&opts.LVMSnapshotSize, "lvm-snapshot-size", fssnap.DefaultLVMSnapshotSize, "Copy-on-write space for the LVM snapshot")
 
 	return cmd
 }
 
-func runBackup(sourcePath, repoPath, description string, encrypt, compressEnabled bool, exclude []string) error {
+func runBackup(opts models.BackupOptions) (*models.Snapshot, error) {
 	startTime := time.Now()
+	repoPath := opts.RepoPath
 
-	// Resolve source path
-	sourcePath, err := filepath.Abs(sourcePath)
-	if err != nil {
-		return fmt.Errorf("invalid source path: %w", err)
-	}
+	// Buckets are listed and read in place rather than from the file system
+	bucketSource := isBucketSource(opts.SourcePath)
+	sourcePath := strings.TrimSuffix(opts.SourcePath, "/")
+	if bucketSource {
+		if opts.LVMSnapshot || opts.FSSnapshot || opts.MetadataOnly {
+			return nil, fmt.Errorf("snapshots and metadata-only backups are not available for bucket sources")
+		}
+	} else {
+		// Resolve source path
+		var err error
+		if sourcePath, err = filepath.Abs(opts.SourcePath); err != nil {
+			return nil, fmt.Errorf("invalid source path: %w", err)
+		}
 
-	// Check source exists
-	if _, err := os.Stat(sourcePath); err != nil {
-		return fmt.Errorf("source not found: %w", err)
+		// Check source exists
+		if _, err := os.Stat(sourcePath); err != nil {
+			return nil, fmt.Errorf("source not found: %w", err)
+		}
 	}
 
 	// Load or create config
@@ -71,27 +113,37 @@ func runBackup(sourcePath, repoPath, description string, encrypt, compressEnable
 		cfg = loadedCfg
 	}
 
-	// Merge exclusions
-	exclusions := append(cfg.Exclusions, exclude...)
+	// Backups can run side by side, but not while a prune deletes objects
+	lock, err := lockRepo(repoPath, "backup", false)
+	if err != nil {
+		return nil, err
+	}
+	defer lock.Unlock()
+
+	// Merge exclusions with presets and the source's profile
+	exclusions, err := cfg.ExclusionsFor(sourcePath, opts.ExcludePresets, opts.ExcludePattern)
+	if err != nil {
+		return nil, err
+	}
+	apps, err := appdata.New(cfg.AppPresetsFor(sourcePath, opts.AppPresets))
+	if err != nil {
+		return nil, err
+	}
 
 	// Setup compression
 	var compressor *compress.Compressor
-	if compressEnabled {
-		compressor, err = compress.New(compress.AlgorithmZstd, cfg.Compression.Level)
+	if opts.Compress {
+		compressor, err = compress.New(compress.AlgorithmZstd, cfg.Compression.Level, compressOptions()...)
 		if err != nil {
-			return fmt.Errorf("failed to create compressor: %w", err)
+			return nil, fmt.Errorf("failed to create compressor: %w", err)
 		}
 		defer compressor.Close()
 	}
 
 	// Setup encryption
 	var encryptor *crypto.Encryptor
-	if encrypt || cfg.Encryption.Enabled {
-		passphrase, err := promptPassword("Enter backup password: ")
-		if err != nil {
-			return fmt.Errorf("failed to read password: %w", err)
-		}
-
+	var header *crypto.EncryptionHeader
+	if opts.Encrypt || cfg.Encryption.Enabled {
 		// Check for existing salt
 		saltPath := filepath.Join(repoPath, "config", "salt")
 		var salt []byte
@@ -102,18 +154,116 @@ func runBackup(sourcePath, repoPath, description string, encrypt, compressEnable
 			os.WriteFile(saltPath, []byte(hex.EncodeToString(salt)), 0600)
 		}
 
-		encryptor, err = crypto.NewEncryptor(passphrase, salt)
+		encryptor, header, err = unlockRepo(repoPath, cfg.Encryption, "Enter backup password: ", salt)
 		if err != nil {
-			return fmt.Errorf("failed to create encryptor: %w", err)
+			return nil, err
 		}
 	}
 
 	// Create snapshot manager
 	mgr, err := snapshot.NewManager(repoPath, compressor, encryptor)
 	if err != nil {
-		return fmt.Errorf("failed to create snapshot manager: %w", err)
+		return nil, fmt.Errorf("failed to create snapshot manager: %w", err)
 	}
 	mgr.SetExclusions(exclusions)
+	mgr.SetAppData(apps, opts.FSSnapshot || opts.LVMSnapshot)
+
+	// Live databases and app data are copied into the runtime directory, on
+	// disk next to the repository and cleaned up after a crash
+	rt, err := openRuntime(repoPath)
+	if err != nil {
+		return nil, err
+	}
+	tempDir, err := rt.TempDir()
+	if err != nil {
+		return nil, err
+	}
+	mgr.SetTempDir(tempDir)
+
+	// Network shares are scanned gently when configured to spare the server
+	scanWorkers, scanRate := cfg.Scan.Workers, cfg.Scan.OpsPerSecond
+	if opts.ScanWorkers > 0 {
+		scanWorkers = opts.ScanWorkers
+	}
+	if opts.ScanRate > 0 {
+		scanRate = opts.ScanRate
+	}
+	mgr.SetScanLimits(scanWorkers, scanRate)
+
+	// One --parallel sets every stage; the configuration can set each
+	parallel := snapshot.Parallelism{
+		Files:    cfg.Backup.Parallel.Files,
+		Encoders: cfg.Backup.Parallel.Encoders,
+		Writers:  cfg.Backup.Parallel.Writers,
+	}
+	if opts.Parallel > 0 {
+		parallel = snapshot.Parallelism{Files: opts.Parallel, Encoders: opts.Parallel, Writers: opts.Parallel}
+	}
+	mgr.SetParallelism(parallel)
+
+	splitter, err := chunker.NewSplitter(cfg.Chunking.Algorithm, cfg.Chunking.MinSize,
+		cfg.Chunking.AvgSize, cfg.Chunking.MaxSize, cfg.Chunking.ImageProfile)
+	if err != nil {
+		return nil, err
+	}
+	mgr.SetChunker(splitter)
+	mgr.SetMmap(opts.MMap || cfg.Chunking.MMap)
+	mgr.SetPathIndex(cfg.Repository.PathIndex)
+
+	limiter := tuning.NewLimiter(cfg.Concurrency.Max)
+	if cfg.Concurrency.Adaptive {
+		limiter = tuning.NewAdaptive(cfg.Concurrency.Min, cfg.Concurrency.Max, tuning.DefaultInterval)
+		defer limiter.Stop()
+	}
+	mgr.SetLimiter(limiter)
+	mgr.SetMemoryBudget(tuning.NewBudget(memoryLimit))
+	mgr.SetTags(opts.Tags)
+	mgr.SetTier(opts.Tier)
+	if opts.RetainUntil != "" {
+		retainUntil, err := parseRetention(opts.RetainUntil, time.Now())
+		if err != nil {
+			return nil, err
+		}
+		mgr.SetRetainUntil(retainUntil)
+	}
+	if expire := opts.Expire; expire != "" || cfg.Repository.Expire != "" {
+		if expire == "" {
+			expire = cfg.Repository.Expire
+		}
+		expiresAt, err := parseRetention(expire, time.Now())
+		if err != nil {
+			return nil, fmt.Errorf("invalid expiry: %w", err)
+		}
+		if !expiresAt.After(time.Now()) {
+			return nil, fmt.Errorf("expiry %s is in the past", expiresAt.Format(time.RFC3339))
+		}
+		mgr.SetExpiry(expiresAt)
+	}
+	mgr.SetEncryptedNames(header != nil && header.EncryptedNames)
+	mgr.SetMetadataOnly(opts.MetadataOnly)
+
+	retries := cfg.Backup.Retries
+	if opts.Retries != nil {
+		retries = *opts.Retries
+	}
+	retryDelay := time.Second
+	if cfg.Backup.RetryDelay != "" {
+		if retryDelay, err = time.ParseDuration(cfg.Backup.RetryDelay); err != nil {
+			return nil, fmt.Errorf("invalid retry delay: %w", err)
+		}
+	}
+	mgr.SetRetries(retries, retryDelay)
+
+	// Publish live status for snapsync top; a backup runs fine without it
+	tracker := progress.New("backup", sourcePath, os.Getpid())
+	mgr.SetProgress(tracker)
+	if server, err := progress.Listen(tracker); err != nil {
+		if verbose {
+			fmt.Fprintf(os.Stderr, "Warning: status socket unavailable: %v\n", err)
+		}
+	} else {
+		defer server.Close()
+	}
 
 	// Get parent snapshot for incremental backup
 	var parentID string
@@ -121,11 +271,34 @@ func runBackup(sourcePath, repoPath, description string, encrypt, compressEnable
 		parentID = latest.ID
 	}
 
+	// Back up from a frozen view of the source if requested
+	scanPath := sourcePath
+	if opts.LVMSnapshot || opts.FSSnapshot {
+		fsSnap, err := openFSSnapshot(sourcePath, opts)
+		if err != nil {
+			return nil, err
+		}
+		defer func() {
+			if err := fsSnap.Close(); err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: failed to remove filesystem snapshot: %v\n", err)
+			}
+		}()
+		scanPath = fsSnap.Path()
+		fmt.Printf("Using filesystem snapshot at %s\n", scanPath)
+	}
+
 	// Create snapshot
 	fmt.Printf("Backing up %s...\n", sourcePath)
-	snap, err := mgr.Create(sourcePath, description, parentID)
+	var snap *models.Snapshot
+	if bucketSource {
+		snap, err = backupBucket(mgr, cfg, sourcePath, opts.Description)
+	} else if scanPath != sourcePath {
+		snap, err = mgr.CreateFromView(sourcePath, scanPath, opts.Description, parentID)
+	} else {
+		snap, err = mgr.Create(sourcePath, opts.Description, parentID)
+	}
 	if err != nil {
-		return fmt.Errorf("backup failed: %w", err)
+		return nil, fmt.Errorf("backup failed: %w", err)
 	}
 
 	// Print summary
@@ -136,26 +309,94 @@ func runBackup(sourcePath, repoPath, description string, encrypt, compressEnable
 	fmt.Printf("  Files:          %d\n", snap.Tree.FileCount)
 	fmt.Printf("  Total size:     %s\n", formatBytes(snap.Stats.TotalSize))
 	fmt.Printf("  Stored size:    %s\n", formatBytes(snap.Stats.StoredSize))
-	fmt.Printf("  Dedup savings:  %s\n", formatBytes(snap.Stats.DeduplicatedSize))
-	fmt.Printf("  New chunks:     %d\n", snap.Stats.NewChunks)
+	if snap.MetadataOnly {
+		fmt.Println("  Contents:       not stored (metadata only)")
+	} else {
+		fmt.Printf("  Dedup savings:  %s\n", formatBytes(snap.Stats.DeduplicatedSize))
+		printChunkStats(snap.Stats)
+	}
 	fmt.Printf("  Duration:       %s\n", duration.Round(time.Millisecond))
+	if snap.ExpiresAt != nil {
+		fmt.Printf("  Expires:        %s\n", snap.ExpiresAt.Format(time.RFC3339))
+	}
+	if failed := mgr.Failed(); len(failed) > 0 {
+		fmt.Printf("  Failed:         %d files left out\n", len(failed))
+		for _, f := range failed {
+			fmt.Fprintf(os.Stderr, "Warning: %s: %v\n", f.Path, f.Err)
+		}
+	}
 
 	if parentID != "" {
 		fmt.Printf("  Added:          %d files\n", snap.Stats.FilesAdded)
 		fmt.Printf("  Modified:       %d files\n", snap.Stats.FilesModified)
 		fmt.Printf("  Unchanged:      %d files\n", snap.Stats.FilesUnchanged)
+		if snap.Stats.FilesRenamed > 0 {
+			fmt.Printf("  Renamed:        %d files\n", snap.Stats.FilesRenamed)
+		}
+	}
+
+	// The local snapshot is complete; cloud storage gets a copy of it
+	if cfg.Cloud.Enabled {
+		objects, sent, err := uploadSnapshot(repoPath, cfg, mgr, snap)
+		if err != nil {
+			return snap, fmt.Errorf("snapshot %s saved locally, but the upload failed: %w", snap.ID, err)
+		}
+		fmt.Printf("  Uploaded:       %d objects (%s)\n", objects, formatBytes(sent))
+	}
+
+	return snap, nil
+}
+
+// printChunkStats shows where a backup's chunks came from: carried over
+// from the parent, found elsewhere in the repository, or newly stored
+func printChunkStats(stats models.SnapshotStats) {
+	fmt.Printf("  New chunks:     %d (%s)\n", stats.NewChunks, formatBytes(stats.NewChunkSize))
+	fmt.Printf("  From parent:    %d (%s)\n", stats.ParentChunks, formatBytes(stats.ParentSize))
+	fmt.Printf("  Deduplicated:   %d (%s)\n", stats.ReusedChunks, formatBytes(stats.ReusedSize))
+
+	// Reused chunks are data that never had to be stored or sent
+	saved := stats.ParentSize + stats.ReusedSize
+	if total := saved + stats.NewChunkSize; total > 0 {
+		fmt.Printf("  Transfer saved: %s of %s (%.1f%%)\n", formatBytes(saved), formatBytes(total),
+			100*float64(saved)/float64(total))
 	}
+}
 
-	return nil
+// openFSSnapshot creates the filesystem snapshot requested by the backup options
+func openFSSnapshot(sourcePath string, opts models.BackupOptions) (fssnap.Snapshot, error) {
+	if opts.LVMSnapshot {
+		lvmSnap, err := fssnap.NewLVMSnapshot(sourcePath, opts.LVMSnapshotSize)
+		if err != nil {
+			return nil, err
+		}
+		return lvmSnap, nil
+	}
+	return fssnap.New(sourcePath)
 }
 
 func promptPassword(prompt string) (string, error) {
-	fmt.Print(prompt)
+	// Non-interactive sources take precedence
+	if passwordFile != "" {
+		data, err := os.ReadFile(passwordFile)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimSpace(string(data)), nil
+	}
+	if password := os.Getenv("SNAPSYNC_PASSWORD"); password != "" {
+		return password, nil
+	}
+	if service := os.Getenv("SNAPSYNC_KEYCHAIN"); service != "" {
+		return keychainPassword(service)
+	}
+
+	// The prompt goes to stderr so it never mixes with piped output
+	fmt.Fprint(os.Stderr, prompt)
 
 	// Try to read password without echo
 	if terminal.IsTerminal(int(syscall.Stdin)) {
 		password, err := terminal.ReadPassword(int(syscall.Stdin))
-		fmt.Println()
+		fmt.Fprintln(os.Stderr)
 		if err != nil {
 			return "", err
 		}
@@ -183,3 +424,24 @@ func formatBytes(bytes int64) string {
 	}
 	return fmt.Sprintf("%.1f %cB", float64(bytes)/float64(div), "KMGTPE"[exp])
 }
+
+// parseSize parses a byte size such as "512M", "1.5G" or "1048576"
+func parseSize(s string) (int64, error) {
+	s = strings.TrimSpace(strings.ToUpper(s))
+	s = strings.TrimSuffix(strings.TrimSuffix(s, "IB"), "B")
+	if s == "" {
+		return 0, fmt.Errorf("empty size")
+	}
+
+	multiplier := int64(1)
+	if idx := strings.IndexAny(s, "KMGTPE"); idx >= 0 && idx == len(s)-1 {
+		multiplier = int64(1) << (10 * (strings.IndexByte("KMGTPE", s[idx]) + 1))
+		s = s[:idx]
+	}
+
+	value, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("invalid size: %q", s)
+	}
+	return int64(value * float64(multiplier)), nil
+}
@@ -0,0 +1,419 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/snapsync/snapsync/internal/chunker"
+	"github.com/snapsync/snapsync/internal/compress"
+	"github.com/snapsync/snapsync/internal/config"
+	"github.com/spf13/cobra"
+)
+
+func benchCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "bench",
+		Short: "Benchmark backup stages on sample data",
+	}
+
+	cmd.AddCommand(benchChunkerCmd())
+	cmd.AddCommand(benchCompressCmd())
+
+	return cmd
+}
+
+// chunkerRun is one chunking parameter set and its results
+type chunkerRun struct {
+	Algorithm string         `json:"algorithm"`
+	MinSize   int            `json:"min_size"`
+	AvgSize   int            `json:"avg_size"`
+	MaxSize   int            `json:"max_size"`
+	Current   bool           `json:"current"` // Matches the repository configuration
+	Stats     *chunker.Stats `json:"stats"`
+}
+
+func benchChunkerCmd() *cobra.Command {
+	var (
+		sizes      []string
+		limit      string
+		jsonOutput bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "chunker [path]",
+		Short: "Compare chunking algorithms and sizes on sample data",
+		Long: `Reads sample data from a file or directory into memory, then chunks it with
+each available algorithm at each average chunk size and reports throughput,
+the chunk size distribution and the deduplication ratio within the sample.
+
+Content-defined (rabin and buzhash) runs use a minimum of half and a maximum
+of four times the average size, like the defaults. The parameters configured for the
+repository given with --repo, or the defaults, are always included and
+marked with *. Choose a sample that resembles the data being backed up, such
+as two copies of a directory taken a day apart.`,
+		Example: `  snapsync bench chunker /srv/data
+  snapsync bench chunker /srv/data --sizes 64K,256K,1M --limit 1G --repo /path/to/repo`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			maxBytes, err := parseSize(limit)
+			if err != nil {
+				return fmt.Errorf("invalid --limit: %w", err)
+			}
+
+			var avgSizes []int
+			for _, s := range sizes {
+				size, err := parseSize(s)
+				if err != nil || size < 64 {
+					return fmt.Errorf("invalid chunk size: %q", s)
+				}
+				avgSizes = append(avgSizes, int(size))
+			}
+
+			cfg := config.DefaultConfig()
+			if repoPath != "" {
+				cfg = loadRepoConfig(repoPath)
+			}
+
+			return runBenchChunker(args[0], maxBytes, avgSizes, cfg.Chunking, jsonOutput)
+		},
+	}
+
+	cmd.Flags().StringSliceVar(&sizes, "sizes", []string{"256K", "512K", "1M", "2M", "4M"}, "Average chunk sizes to try")
+	cmd.Flags().StringVar(&limit, "limit", "64M", "Most sample data to read into memory")
+	cmd.Flags().BoolVar(&jsonOutput, "json", false, "Output in JSON format")
+
+	return cmd
+}
+
+func runBenchChunker(path string, limit int64, avgSizes []int, current config.ChunkingConfig, jsonOutput bool) error {
+	samples, total, err := loadSamples(path, limit)
+	if err != nil {
+		return err
+	}
+	if total == 0 {
+		return fmt.Errorf("no data to sample in %s", path)
+	}
+
+	var runs []*chunkerRun
+	for _, avg := range avgSizes {
+		runs = append(runs,
+			&chunkerRun{Algorithm: "rabin", MinSize: avg / 2, AvgSize: avg, MaxSize: avg * 4},
+			&chunkerRun{Algorithm: "buzhash", MinSize: avg / 2, AvgSize: avg, MaxSize: avg * 4},
+			&chunkerRun{Algorithm: "fixed", AvgSize: avg},
+		)
+	}
+
+	algorithm := current.Algorithm
+	if algorithm == "" {
+		algorithm = "rabin"
+	}
+	configured := &chunkerRun{Algorithm: algorithm, AvgSize: current.AvgSize}
+	if algorithm != "fixed" {
+		configured.MinSize, configured.MaxSize = current.MinSize, current.MaxSize
+	}
+	found := false
+	for _, run := range runs {
+		if *run == *configured {
+			run.Current, found = true, true
+		}
+	}
+	if !found {
+		configured.Current = true
+		runs = append(runs, configured)
+	}
+
+	if !jsonOutput {
+		fmt.Printf("Sample: %s in %d files\n\n", formatBytes(total), len(samples))
+		fmt.Printf("  %-7s  %-26s  %10s  %8s  %9s  %9s  %9s  %9s  %6s\n",
+			"ALGO", "MIN/AVG/MAX", "SPEED", "CHUNKS", "P10", "P50", "P90", "MAX", "DEDUP")
+	}
+
+	for _, run := range runs {
+		splitter, err := chunker.NewSplitter(run.Algorithm, run.MinSize, run.AvgSize, run.MaxSize, false)
+		if err != nil {
+			return err
+		}
+		if run.Stats, err = chunker.Measure(splitter, samples); err != nil {
+			return fmt.Errorf("failed to chunk sample: %w", err)
+		}
+
+		if !jsonOutput {
+			mark := " "
+			if run.Current {
+				mark = "*"
+			}
+			params := formatBytes(int64(run.AvgSize))
+			if run.Algorithm != "fixed" {
+				params = formatBytes(int64(run.MinSize)) + "/" + params + "/" + formatBytes(int64(run.MaxSize))
+			}
+			fmt.Printf("%s %-7s  %-26s  %8s/s  %8d  %9s  %9s  %9s  %9s  %5.2fx\n",
+				mark, run.Algorithm, params,
+				formatBytes(int64(run.Stats.Throughput())),
+				run.Stats.Chunks,
+				formatBytes(run.Stats.P10),
+				formatBytes(run.Stats.P50),
+				formatBytes(run.Stats.P90),
+				formatBytes(run.Stats.Max),
+				run.Stats.DedupRatio(),
+			)
+		}
+	}
+
+	if jsonOutput {
+		output, _ := json.MarshalIndent(runs, "", "  ")
+		fmt.Println(string(output))
+		return nil
+	}
+
+	// Smaller chunks find more duplicates but cost an object and index entry each
+	best, fastest := runs[0], runs[0]
+	for _, run := range runs[1:] {
+		if run.Stats.DedupRatio() > best.Stats.DedupRatio() {
+			best = run
+		}
+		if run.Stats.Throughput() > fastest.Stats.Throughput() {
+			fastest = run
+		}
+	}
+
+	fmt.Println("\n* current configuration")
+	fmt.Printf("Best dedup:  %s at %s average (%.2fx, %d chunks)\n",
+		best.Algorithm, formatBytes(int64(best.AvgSize)), best.Stats.DedupRatio(), best.Stats.Chunks)
+	fmt.Printf("Fastest:     %s at %s average (%s/s)\n",
+		fastest.Algorithm, formatBytes(int64(fastest.AvgSize)), formatBytes(int64(fastest.Stats.Throughput())))
+	fmt.Println("\nSet the chosen parameters under chunking in config/snapsync.yaml:")
+	fmt.Println("  chunking:")
+	fmt.Printf("    algorithm: %s\n", best.Algorithm)
+	if best.Algorithm != "fixed" {
+		fmt.Printf("    min_size: %d\n", best.MinSize)
+	}
+	fmt.Printf("    avg_size: %d\n", best.AvgSize)
+	if best.Algorithm != "fixed" {
+		fmt.Printf("    max_size: %d\n", best.MaxSize)
+	}
+	return nil
+}
+
+// compressRun is one compression setting and its results
+type compressRun struct {
+	Algorithm string          `json:"algorithm"`
+	Level     int             `json:"level,omitempty"`
+	Setting   string          `json:"setting,omitempty"` // Encoder setting the level maps to
+	Current   bool            `json:"current"`
+	Stats     *compress.Stats `json:"stats"`
+}
+
+func benchCompressCmd() *cobra.Command {
+	var (
+		levels     []int
+		limit      string
+		minSpeed   string
+		jsonOutput bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "compress [path]",
+		Short: "Compare compression levels on sample data",
+		Long: `Reads sample data from a file or directory into memory, splits it into chunks
+with the configured chunking parameters, and compresses and decompresses
+every chunk at each zstd level, as a backup and a restore would. Reports the
+compression ratio and single-core throughput of each level and recommends a
+compression setting for this data on this machine.
+
+The recommendation is the level with the smallest output that still
+compresses at least --min-speed per core, or no compression if the data
+barely shrinks. zstd groups levels into four encoder settings (fastest,
+default, better, best), so levels within a group perform alike. The lz4 mode
+is zstd's fastest setting in this build, and xz is not supported by the
+repository format, so neither is listed separately.`,
+		Example: `  snapsync bench compress /srv/data
+  snapsync bench compress /srv/data --levels 1,3,9,19 --min-speed 200M --repo /path/to/repo`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			maxBytes, err := parseSize(limit)
+			if err != nil {
+				return fmt.Errorf("invalid --limit: %w", err)
+			}
+			speed, err := parseSize(minSpeed)
+			if err != nil {
+				return fmt.Errorf("invalid --min-speed: %w", err)
+			}
+
+			cfg := config.DefaultConfig()
+			if repoPath != "" {
+				cfg = loadRepoConfig(repoPath)
+			}
+
+			return runBenchCompress(args[0], maxBytes, levels, float64(speed), cfg, jsonOutput)
+		},
+	}
+
+	cmd.Flags().IntSliceVar(&levels, "levels", []int{1, 3, 6, 10}, "zstd levels to try")
+	cmd.Flags().StringVar(&limit, "limit", "64M", "Most sample data to read into memory")
+	cmd.Flags().StringVar(&minSpeed, "min-speed", "50M", "Slowest acceptable compression per core, per second")
+	cmd.Flags().BoolVar(&jsonOutput, "json", false, "Output in JSON format")
+
+	return cmd
+}
+
+func runBenchCompress(path string, limit int64, levels []int, minSpeed float64, cfg *config.Config, jsonOutput bool) error {
+	samples, total, err := loadSamples(path, limit)
+	if err != nil {
+		return err
+	}
+	if total == 0 {
+		return fmt.Errorf("no data to sample in %s", path)
+	}
+
+	// Chunks are compressed one at a time, so measure on chunks
+	splitter, err := chunker.NewSplitter(cfg.Chunking.Algorithm, cfg.Chunking.MinSize,
+		cfg.Chunking.AvgSize, cfg.Chunking.MaxSize, cfg.Chunking.ImageProfile)
+	if err != nil {
+		return err
+	}
+	var blocks [][]byte
+	for _, sample := range samples {
+		// Files below the minimum chunk size are always a single chunk
+		if len(sample) <= cfg.Chunking.MinSize {
+			blocks = append(blocks, sample)
+			continue
+		}
+		chunks, err := splitter.Chunk(bytes.NewReader(sample))
+		if err != nil {
+			return fmt.Errorf("failed to chunk sample: %w", err)
+		}
+		for _, chunk := range chunks {
+			blocks = append(blocks, chunk.Data)
+		}
+	}
+
+	runs := []*compressRun{{Algorithm: string(compress.AlgorithmNone), Current: !cfg.Compression.Enabled}}
+	for _, level := range levels {
+		runs = append(runs, &compressRun{
+			Algorithm: string(compress.AlgorithmZstd),
+			Level:     level,
+			Setting:   compress.LevelName(level),
+			Current:   cfg.Compression.Enabled && level == cfg.Compression.Level,
+		})
+	}
+
+	if !jsonOutput {
+		fmt.Printf("Sample: %s in %d chunks\n\n", formatBytes(total), len(blocks))
+		fmt.Printf("  %-18s  %10s  %7s  %12s  %12s\n", "ALGORITHM", "SIZE", "RATIO", "COMPRESS", "DECOMPRESS")
+	}
+
+	for _, run := range runs {
+		compressor, err := compress.New(compress.Algorithm(run.Algorithm), run.Level)
+		if err != nil {
+			return err
+		}
+		run.Stats, err = compress.Measure(compressor, blocks)
+		compressor.Close()
+		if err != nil {
+			return fmt.Errorf("failed to compress sample: %w", err)
+		}
+
+		if !jsonOutput {
+			mark := " "
+			if run.Current {
+				mark = "*"
+			}
+			name := run.Algorithm
+			if run.Setting != "" {
+				name = fmt.Sprintf("zstd %d (%s)", run.Level, run.Setting)
+			}
+			speed := func(v float64) string {
+				if run.Algorithm == string(compress.AlgorithmNone) {
+					return "-"
+				}
+				return formatBytes(int64(v)) + "/s"
+			}
+			fmt.Printf("%s %-18s  %10s  %6.1f%%  %12s  %12s\n",
+				mark, name,
+				formatBytes(run.Stats.CompressedBytes),
+				100*run.Stats.Ratio(),
+				speed(run.Stats.CompressSpeed()),
+				speed(run.Stats.DecompressSpeed()),
+			)
+		}
+	}
+
+	if jsonOutput {
+		output, _ := json.MarshalIndent(runs, "", "  ")
+		fmt.Println(string(output))
+		return nil
+	}
+
+	// Smallest output among the levels fast enough, else the fastest level
+	var best, fastest *compressRun
+	for _, run := range runs[1:] {
+		if fastest == nil || run.Stats.CompressSpeed() > fastest.Stats.CompressSpeed() {
+			fastest = run
+		}
+		if run.Stats.CompressSpeed() >= minSpeed &&
+			(best == nil || run.Stats.CompressedBytes < best.Stats.CompressedBytes) {
+			best = run
+		}
+	}
+	if best == nil {
+		best = fastest
+	}
+
+	fmt.Println("\n* current configuration")
+	fmt.Println("\nRecommended settings for config/snapsync.yaml:")
+	fmt.Println("  compression:")
+	if best == nil || best.Stats.Ratio() > 0.98 {
+		fmt.Println("    enabled: false    # the data barely compresses")
+		return nil
+	}
+	fmt.Println("    enabled: true")
+	fmt.Println("    algorithm: zstd")
+	fmt.Printf("    level: %d\n", best.Level)
+	return nil
+}
+
+// loadSamples reads regular files under path into memory until limit bytes
+// have been read, truncating the last file
+func loadSamples(path string, limit int64) ([][]byte, int64, error) {
+	var samples [][]byte
+	var total int64
+
+	err := filepath.Walk(path, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if total >= limit {
+			return filepath.SkipAll
+		}
+		if !info.Mode().IsRegular() || info.Size() == 0 {
+			return nil
+		}
+
+		f, err := os.Open(file)
+		if err != nil {
+			// Unreadable files are simply not part of the sample
+			if os.IsPermission(err) {
+				return nil
+			}
+			return err
+		}
+		defer f.Close()
+
+		data, err := io.ReadAll(io.LimitReader(f, limit-total))
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", file, err)
+		}
+		samples = append(samples, data)
+		total += int64(len(data))
+		return nil
+	})
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to read sample: %w", err)
+	}
+
+	return samples, total, nil
+}
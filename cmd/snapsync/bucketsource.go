@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/snapsync/snapsync/internal/backend"
+	"github.com/snapsync/snapsync/internal/config"
+	"github.com/snapsync/snapsync/internal/snapshot"
+	"github.com/snapsync/snapsync/pkg/models"
+)
+
+// bucketEndpoints are the S3 endpoints serving each bucket source scheme;
+// Google Cloud Storage is read through its S3-compatible API with HMAC keys
+var bucketEndpoints = map[string]string{
+	"s3": "",
+	"gs": "https://storage.googleapis.com",
+}
+
+// isBucketSource reports whether a backup source names a bucket, as
+// s3://bucket/prefix or gs://bucket/prefix
+func isBucketSource(source string) bool {
+	scheme, _, ok := strings.Cut(source, "://")
+	_, known := bucketEndpoints[scheme]
+	return ok && known
+}
+
+// openBucketSource connects to the bucket of a source URL with the settings
+// of its source profile, falling back to the S3 cloud storage's keys
+func openBucketSource(cfg *config.Config, source string) (backend.Backend, error) {
+	scheme, rest, _ := strings.Cut(source, "://")
+	bucket, prefix, _ := strings.Cut(rest, "/")
+	if bucket == "" {
+		return nil, fmt.Errorf("invalid bucket source %q (use %s://bucket/prefix)", source, scheme)
+	}
+
+	profile, _ := cfg.SourceProfile(source)
+	if profile.AccessKey == "" && (cfg.Cloud.Provider == "" || cfg.Cloud.Provider == "s3") {
+		profile.AccessKey, profile.SecretKey = cfg.Cloud.AccessKey, cfg.Cloud.SecretKey
+		if profile.Region == "" {
+			profile.Region = cfg.Cloud.Region
+		}
+	}
+	if profile.AccessKey == "" || profile.SecretKey == "" {
+		return nil, fmt.Errorf("no keys configured for %s (set access_key and secret_key under sources)", source)
+	}
+	if profile.Endpoint == "" {
+		profile.Endpoint = bucketEndpoints[scheme]
+	}
+	if profile.Region == "" {
+		profile.Region = "us-east-1"
+		if scheme == "gs" {
+			profile.Region = "auto"
+		}
+	}
+
+	b, err := backend.NewS3Backend(backend.S3Config{
+		Bucket:    bucket,
+		Region:    profile.Region,
+		Endpoint:  profile.Endpoint,
+		AccessKey: profile.AccessKey,
+		SecretKey: profile.SecretKey,
+		Prefix:    strings.Trim(prefix, "/"),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", source, err)
+	}
+	return b, nil
+}
+
+// backupBucket backs up a bucket source on top of the latest snapshot of the
+// same bucket, whose ETags tell which objects need downloading
+func backupBucket(mgr *snapshot.Manager, cfg *config.Config, source, description string) (*models.Snapshot, error) {
+	b, err := openBucketSource(cfg, source)
+	if err != nil {
+		return nil, err
+	}
+	defer b.Close()
+
+	var parentID string
+	records, err := mgr.ListRecords()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list snapshots: %w", err)
+	}
+	for _, record := range records {
+		if record.Tree != nil && record.Tree.Root != nil && record.Tree.Root.Path == source {
+			parentID = record.ID
+			break
+		}
+	}
+
+	return mgr.CreateFromBucket(b, source, description, parentID)
+}
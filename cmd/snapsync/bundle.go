@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/snapsync/snapsync/internal/bundle"
+	"github.com/snapsync/snapsync/internal/compress"
+	"github.com/snapsync/snapsync/internal/restore"
+	"github.com/snapsync/snapsync/internal/snapshot"
+	"github.com/spf13/cobra"
+)
+
+func bundleCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "bundle",
+		Short: "Move snapshots between repositories offline",
+		Long: `Packs snapshots into a single file that can be carried to an air-gapped
+machine and applied to its repository.`,
+	}
+
+	cmd.AddCommand(bundleCreateCmd())
+	cmd.AddCommand(bundleApplyCmd())
+
+	return cmd
+}
+
+func bundleCreateCmd() *cobra.Command {
+	var (
+		since  string
+		output string
+		filter bundle.Filter
+	)
+
+	cmd := &cobra.Command{
+		Use:   "create",
+		Short: "Write a bundle of new snapshots",
+		Long: `Writes the snapshots taken after --since, together with every chunk and tree
+object they need that --since does not already reference. Without --since the
+whole repository is bundled.
+
+--tag limits the bundle to snapshots with one of the given tags. --path
+narrows each snapshot to the files matching one of the given patterns ("**"
+matches any number of directories), and only the chunks of those files are
+bundled; snapshots without matching files are left out. Narrowed snapshots
+are applied as partial copies. Use the same --tag and --path for every
+bundle sent to one repository, since --since assumes the receiving side got
+what the same filter selected before.`,
+		Example: `  snapsync bundle create -o offsite.bundle --repo /path/to/repo
+  snapsync bundle create -o db.bundle --tag db --path 'databases/**' --repo /path/to/repo
+  snapsync bundle create -o db.bundle --since 17921759 --tag db --path 'databases/**' --repo /path/to/repo`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if repoPath == "" {
+				return fmt.Errorf("repository path required (use --repo)")
+			}
+			if output == "" {
+				return fmt.Errorf("output file required (use --output)")
+			}
+
+			// Walking encrypted tree objects needs the key
+			encryptor, err := openEncryptor(repoPath, loadRepoConfig(repoPath), "Enter repository password: ")
+			if err != nil {
+				return err
+			}
+
+			mgr, err := snapshot.NewManager(repoPath, nil, encryptor)
+			if err != nil {
+				return fmt.Errorf("failed to open repository: %w", err)
+			}
+
+			if since != "" {
+				if since, err = mgr.ResolveID(since); err != nil {
+					return err
+				}
+			}
+
+			f, err := os.Create(output)
+			if err != nil {
+				return fmt.Errorf("failed to create bundle: %w", err)
+			}
+
+			manifest, err := bundle.Create(f, repoPath, mgr, since, filter)
+			if err != nil {
+				f.Close()
+				os.Remove(output)
+				return err
+			}
+			if err := f.Close(); err != nil {
+				return fmt.Errorf("failed to write bundle: %w", err)
+			}
+
+			fmt.Printf("Bundle written to %s\n", output)
+			fmt.Printf("  Snapshots: %d\n", len(manifest.Snapshots))
+			fmt.Printf("  Objects:   %d (%s)\n", manifest.Objects, formatBytes(manifest.Bytes))
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&since, "since", "", "Snapshot the receiving repository already has")
+	cmd.Flags().StringVarP(&output, "output", "o", "", "Bundle file to write")
+	cmd.Flags().StringArrayVar(&filter.Tags, "tag", nil, "Only bundle snapshots with this tag (repeatable)")
+	cmd.Flags().StringArrayVar(&filter.Paths, "path", nil, "Only bundle files matching this pattern, e.g. databases/** (repeatable)")
+
+	return cmd
+}
+
+func bundleApplyCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "apply [bundle-file]",
+		Short: "Import a bundle into the repository",
+		Long: `Imports the chunks, tree objects and snapshots of a bundle. Objects and
+snapshots the repository already has are left untouched.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if repoPath == "" {
+				return fmt.Errorf("repository path required (use --repo)")
+			}
+
+			cfg := loadRepoConfig(repoPath)
+
+			f, err := os.Open(args[0])
+			if err != nil {
+				return fmt.Errorf("failed to open bundle: %w", err)
+			}
+			defer f.Close()
+
+			// Chunks are checked by decoding them, with the key from the
+			// bundle's key files when the repository has none yet
+			var compressor *compress.Compressor
+			defer func() {
+				if compressor != nil {
+					compressor.Close()
+				}
+			}()
+			openDecoder := func() (bundle.Decoder, error) {
+				if cfg.Compression.Enabled {
+					var err error
+					compressor, err = compress.New(compress.AlgorithmZstd, cfg.Compression.Level, compressOptions()...)
+					if err != nil {
+						return nil, fmt.Errorf("failed to create compressor: %w", err)
+					}
+				}
+				encryptor, err := openEncryptor(repoPath, cfg, "Enter repository password: ")
+				if err != nil {
+					return nil, err
+				}
+				return restore.NewRestorer(nil, compressor, encryptor).DecodeObject, nil
+			}
+
+			manifest, err := bundle.Apply(f, repoPath, cfg.Encryption.Enabled, openDecoder)
+			if err != nil {
+				return fmt.Errorf("failed to apply bundle: %w", err)
+			}
+
+			fmt.Printf("Bundle applied to %s\n", repoPath)
+			fmt.Printf("  Snapshots: %d\n", len(manifest.Snapshots))
+			fmt.Printf("  Objects:   %d (%s)\n", manifest.Objects, formatBytes(manifest.Bytes))
+			return nil
+		},
+	}
+
+	return cmd
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/snapsync/snapsync/internal/compress"
+	"github.com/snapsync/snapsync/internal/restore"
+	"github.com/snapsync/snapsync/internal/snapshot"
+	"github.com/spf13/cobra"
+)
+
+func catCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "cat [snapshot:path]",
+		Short: "Write a file from a snapshot to stdout",
+		Long: `Streams one file from a snapshot to standard output without restoring it.
+The snapshot is any snapshot reference (see snapsync list --help); the path is
+relative to the backup root or absolute under it. Device snapshots take an
+empty path.`,
+		Example: `  snapsync cat latest:etc/nginx.conf --repo /path/to/repo | diff - /etc/nginx.conf`,
+		Args:    cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if repoPath == "" {
+				return fmt.Errorf("repository path required (use --repo)")
+			}
+
+			ref, path, ok := strings.Cut(args[0], ":")
+			if !ok || ref == "" {
+				return fmt.Errorf("expected snapshot:path, got %q", args[0])
+			}
+
+			return runCat(repoPath, ref, path)
+		},
+	}
+
+	return cmd
+}
+
+func runCat(repoPath, ref, path string) error {
+	cfg := loadRepoConfig(repoPath)
+
+	var compressor *compress.Compressor
+	if cfg.Compression.Enabled {
+		var err error
+		compressor, err = compress.New(compress.AlgorithmZstd, cfg.Compression.Level, compressOptions()...)
+		if err != nil {
+			return fmt.Errorf("failed to create compressor: %w", err)
+		}
+		defer compressor.Close()
+	}
+
+	encryptor, err := openEncryptor(repoPath, cfg, "Enter repository password: ")
+	if err != nil {
+		return err
+	}
+
+	mgr, err := snapshot.NewManager(repoPath, compressor, encryptor)
+	if err != nil {
+		return fmt.Errorf("failed to open repository: %w", err)
+	}
+
+	snap, err := mgr.Resolve(ref)
+	if err != nil {
+		return err
+	}
+
+	if path == "" {
+		path = "."
+	}
+	node := findNode(snap, path)
+	if node == nil {
+		return fmt.Errorf("path not found in snapshot %s: %s", snap.ID, path)
+	}
+	if node.IsDir {
+		return fmt.Errorf("%s is a directory", path)
+	}
+
+	restorer := restore.NewRestorer(mgr.CAS(), compressor, encryptor)
+
+	out := bufio.NewWriterSize(os.Stdout, 1<<20)
+	if err := restorer.RestoreToWriter(node, out); err != nil {
+		return fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	return out.Flush()
+}
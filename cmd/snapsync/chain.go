@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/snapsync/snapsync/internal/snapshot"
+	"github.com/spf13/cobra"
+)
+
+// anchorPrefix starts every anchor line
+const anchorPrefix = "snapsync-anchor"
+
+func chainCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "chain",
+		Short: "Verify and anchor the snapshot history",
+		Long: `Every snapshot commits to the hash of the snapshot before it, so removing or
+editing a snapshot breaks the chain. Publishing the head hash somewhere the
+repository cannot reach also makes a rewrite of the whole chain detectable.`,
+	}
+
+	cmd.AddCommand(chainVerifyCmd())
+	cmd.AddCommand(chainAnchorCmd())
+
+	return cmd
+}
+
+func chainVerifyCmd() *cobra.Command {
+	var anchor string
+
+	cmd := &cobra.Command{
+		Use:   "verify",
+		Short: "Check that no snapshot was removed or altered",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if repoPath == "" {
+				return fmt.Errorf("repository path required (use --repo)")
+			}
+
+			mgr, err := snapshot.NewManager(repoPath, nil, nil)
+			if err != nil {
+				return fmt.Errorf("failed to open repository: %w", err)
+			}
+
+			report, err := mgr.VerifyChain()
+			if err != nil {
+				return fmt.Errorf("failed to verify chain: %w", err)
+			}
+
+			if anchor != "" {
+				if problem, err := checkAnchor(mgr, anchor); err != nil {
+					return err
+				} else if problem != "" {
+					report.Problems = append(report.Problems, problem)
+				}
+			}
+
+			fmt.Printf("Snapshots checked: %d\n", report.Checked)
+			if report.Unchained > 0 {
+				fmt.Printf("Unchained:         %d (created before chaining)\n", report.Unchained)
+			}
+			if report.HeadID != "" {
+				fmt.Printf("Head:              %s %s\n", report.HeadID, report.HeadHash)
+			}
+
+			if !report.OK() {
+				fmt.Println("\nProblems:")
+				for _, problem := range report.Problems {
+					fmt.Printf("  %s\n", problem)
+				}
+				return fmt.Errorf("snapshot chain verification failed: %d problems", len(report.Problems))
+			}
+
+			fmt.Println("\nChain OK")
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&anchor, "anchor", "", "Also check the latest head published to this file or URL")
+
+	return cmd
+}
+
+func chainAnchorCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "anchor [file-or-url]",
+		Short: "Publish the head hash outside the repository",
+		Long: `Appends the newest snapshot and its chain hash to a file, or POSTs it to an
+HTTP(S) URL. Keep the anchor on storage the backup host cannot rewrite.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if repoPath == "" {
+				return fmt.Errorf("repository path required (use --repo)")
+			}
+
+			mgr, err := snapshot.NewManager(repoPath, nil, nil)
+			if err != nil {
+				return fmt.Errorf("failed to open repository: %w", err)
+			}
+
+			id, hash, err := mgr.ChainHead()
+			if err != nil {
+				return err
+			}
+
+			line := fmt.Sprintf("%s %s %s %s\n", anchorPrefix, id, hash, time.Now().UTC().Format(time.RFC3339))
+			if err := publishAnchor(args[0], line); err != nil {
+				return fmt.Errorf("failed to publish anchor: %w", err)
+			}
+
+			fmt.Printf("Anchored %s %s\n", id, hash)
+			return nil
+		},
+	}
+
+	return cmd
+}
+
+// isURL reports whether an anchor target is an HTTP(S) URL
+func isURL(target string) bool {
+	return strings.HasPrefix(target, "http://") || strings.HasPrefix(target, "https://")
+}
+
+// publishAnchor appends line to a file or POSTs it to a URL
+func publishAnchor(target, line string) error {
+	if isURL(target) {
+		resp, err := http.Post(target, "text/plain", strings.NewReader(line))
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode/100 != 2 {
+			return fmt.Errorf("%s returned %s", target, resp.Status)
+		}
+		return nil
+	}
+
+	f, err := os.OpenFile(target, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteString(line); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// fetchAnchor reads the anchor lines from a file or URL
+func fetchAnchor(target string) ([]byte, error) {
+	if isURL(target) {
+		resp, err := http.Get(target)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode/100 != 2 {
+			return nil, fmt.Errorf("%s returned %s", target, resp.Status)
+		}
+		return io.ReadAll(resp.Body)
+	}
+
+	return os.ReadFile(target)
+}
+
+// checkAnchor compares the latest published head with the repository
+// Returns a problem description when the anchored snapshot is gone or differs.
+func checkAnchor(mgr *snapshot.Manager, target string) (string, error) {
+	data, err := fetchAnchor(target)
+	if err != nil {
+		return "", fmt.Errorf("failed to read anchor: %w", err)
+	}
+
+	var id, hash string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 3 && fields[0] == anchorPrefix {
+			id, hash = fields[1], fields[2]
+		}
+	}
+	if id == "" {
+		return "", fmt.Errorf("no anchor found in %s", target)
+	}
+
+	current, err := mgr.ChainHash(id)
+	if err != nil {
+		return fmt.Sprintf("anchored snapshot %s is missing", id), nil
+	}
+	if current != hash {
+		return fmt.Sprintf("anchored snapshot %s does not match the published hash", id), nil
+	}
+	return "", nil
+}
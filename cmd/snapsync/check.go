@@ -0,0 +1,305 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/snapsync/snapsync/internal/backend"
+	"github.com/snapsync/snapsync/internal/compress"
+	"github.com/snapsync/snapsync/internal/config"
+	"github.com/snapsync/snapsync/internal/restore"
+	"github.com/snapsync/snapsync/internal/snapshot"
+	"github.com/snapsync/snapsync/pkg/models"
+	"github.com/spf13/cobra"
+)
+
+// checkReport is the result of a repository check, printed with --json
+type checkReport struct {
+	OK          bool                    `json:"ok"`
+	Snapshots   int                     `json:"snapshots"`
+	Referenced  int                     `json:"objects_referenced"`
+	ObjectsRead int                     `json:"objects_read,omitempty"`
+	ReadData    bool                    `json:"read_data"`
+	Remote      []remoteReport          `json:"remote,omitempty"`
+	Problems    []snapshot.CheckProblem `json:"problems"`
+	Duration    string                  `json:"duration"`
+}
+
+// remoteReport is the comparison with one backend, or mirror replica
+type remoteReport struct {
+	Name        string `json:"name"`
+	Snapshots   int    `json:"snapshots"`
+	NotUploaded int    `json:"snapshots_not_uploaded"`
+	Objects     int    `json:"objects_compared"`
+}
+
+func checkCmd() *cobra.Command {
+	var (
+		readData   bool
+		remote     bool
+		jsonOutput bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "check",
+		Short: "Check repository integrity",
+		Long: `Validates the repository layout, reads every snapshot and walks its tree, and
+confirms that every chunk and tree object the snapshots reference is present.
+With --read-data every object in the store is also read back and re-hashed,
+which finds silent corruption at the cost of reading the whole repository.
+
+With --remote the listing of the configured cloud storage, and of each
+replica of a mirror, is compared with the repository: every object of an
+uploaded snapshot must be there with the size of the local copy, and with
+the same MD5 (S3) or SHA1 (B2) where the listing has one. This finds objects
+that bucket lifecycle rules or other tools deleted or changed before a
+restore depends on them, without downloading anything.
+
+The command exits non-zero if any problem is found. --json prints the report
+in a machine-readable form.`,
+		Example: `  snapsync check --repo /path/to/repo
+  snapsync check --repo /path/to/repo --read-data --json
+  snapsync check --repo /path/to/repo --remote`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if repoPath == "" {
+				return fmt.Errorf("repository path required (use --repo)")
+			}
+
+			// Problems are reported in the output; usage would only bury them
+			cmd.SilenceUsage = true
+			return runCheck(repoPath, readData, remote, jsonOutput)
+		},
+	}
+
+	cmd.Flags().BoolVar(&readData, "read-data", false, "Read and re-hash every stored object")
+	cmd.Flags().BoolVar(&remote, "remote", false, "Compare the cloud storage listing with the repository")
+	cmd.Flags().BoolVar(&jsonOutput, "json", false, "Output the report in JSON format")
+
+	return cmd
+}
+
+func runCheck(repoPath string, readData, remote, jsonOutput bool) error {
+	startTime := time.Now()
+	report := &checkReport{ReadData: readData, Problems: []snapshot.CheckProblem{}}
+
+	// Layout first: opening the store would create missing directories
+	report.Problems = append(report.Problems, checkStructure(repoPath)...)
+	if _, err := os.Stat(filepath.Join(repoPath, "repo.json")); err != nil {
+		return finishCheck(report, startTime, jsonOutput)
+	}
+
+	cfg := loadRepoConfig(repoPath)
+
+	var compressor *compress.Compressor
+	if cfg.Compression.Enabled {
+		var err error
+		compressor, err = compress.New(compress.AlgorithmZstd, cfg.Compression.Level, compressOptions()...)
+		if err != nil {
+			return fmt.Errorf("failed to create compressor: %w", err)
+		}
+		defer compressor.Close()
+	}
+
+	// Encrypted trees and chunks can only be checked with the key
+	encryptor, err := openEncryptor(repoPath, cfg, "Enter repository password: ")
+	if err != nil {
+		return err
+	}
+
+	mgr, err := snapshot.NewManager(repoPath, compressor, encryptor)
+	if err != nil {
+		return fmt.Errorf("failed to open repository: %w", err)
+	}
+
+	if !jsonOutput {
+		fmt.Println("Checking snapshots...")
+	}
+	result, err := mgr.Check()
+	if err != nil {
+		return fmt.Errorf("failed to check snapshots: %w", err)
+	}
+	report.Snapshots = result.Snapshots
+	report.Referenced = len(result.Referenced)
+	report.Problems = append(report.Problems, result.Problems...)
+
+	if readData {
+		cas := mgr.CAS()
+		hashes, err := cas.List()
+		if err != nil {
+			return fmt.Errorf("failed to list objects: %w", err)
+		}
+		if !jsonOutput {
+			fmt.Printf("Reading %d objects...\n", len(hashes))
+		}
+
+		restorer := restore.NewRestorer(cas, compressor, encryptor)
+		corrupted, err := cas.Verify(restorer.DecodeObject)
+		if err != nil {
+			return fmt.Errorf("failed to read objects: %w", err)
+		}
+		report.ObjectsRead = len(hashes)
+
+		for _, hash := range corrupted {
+			// Only a complete walk shows an object is unused
+			message := "content does not match hash"
+			if !result.Incomplete && !result.Referenced[hash] {
+				message += " (unreferenced)"
+			}
+			report.Problems = append(report.Problems, snapshot.CheckProblem{
+				Kind: "corrupt", Object: hash, Message: message,
+			})
+		}
+	}
+
+	if remote {
+		if !cfg.Cloud.Enabled {
+			return fmt.Errorf("no cloud storage configured")
+		}
+		if err := checkRemote(repoPath, cfg, mgr, report, jsonOutput); err != nil {
+			return err
+		}
+	}
+
+	kind := "check"
+	if readData {
+		kind += " --read-data"
+	}
+	if remote {
+		kind += " --remote"
+	}
+	if err := mgr.RecordVerification(snapshot.Verification{
+		Kind: kind, Time: time.Now(), OK: len(report.Problems) == 0, Problems: len(report.Problems),
+	}); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to record result: %v\n", err)
+	}
+
+	return finishCheck(report, startTime, jsonOutput)
+}
+
+// checkRemote compares the cloud storage, or each replica of a mirror, with
+// the repository
+func checkRemote(repoPath string, cfg *config.Config, mgr *snapshot.Manager, report *checkReport, jsonOutput bool) error {
+	remote, err := openCloudBackend(repoPath, cfg)
+	if err != nil {
+		return err
+	}
+	defer remote.Close()
+
+	// The storage itself is compared, not the local cache of it
+	if cached, ok := remote.(*backend.CacheBackend); ok {
+		remote = cached.Unwrap()
+	}
+	replicas := []backend.Replica{{Name: replicaName(cfg.Cloud), Backend: remote}}
+	if mirror, ok := remote.(*backend.MirrorBackend); ok {
+		replicas = mirror.Replicas()
+	}
+
+	for _, replica := range replicas {
+		if !jsonOutput {
+			fmt.Printf("Comparing %s...\n", replica.Name)
+		}
+		result, err := mgr.CheckRemote(replica.Backend, replica.Name)
+		if err != nil {
+			return err
+		}
+		report.Remote = append(report.Remote, remoteReport{
+			Name:        replica.Name,
+			Snapshots:   result.Records,
+			NotUploaded: result.NotUploaded,
+			Objects:     result.Objects,
+		})
+		report.Problems = append(report.Problems, result.Problems...)
+	}
+	return nil
+}
+
+// checkStructure validates the files and directories every repository has
+func checkStructure(repoPath string) []snapshot.CheckProblem {
+	var problems []snapshot.CheckProblem
+	structural := func(format string, args ...interface{}) {
+		problems = append(problems, snapshot.CheckProblem{Kind: "structure", Message: fmt.Sprintf(format, args...)})
+	}
+
+	data, err := os.ReadFile(filepath.Join(repoPath, "repo.json"))
+	if err != nil {
+		structural("repository info unreadable: %v", err)
+		return problems
+	}
+	var info models.RepositoryInfo
+	if err := json.Unmarshal(data, &info); err != nil {
+		structural("invalid repository info: %v", err)
+	}
+
+	for _, dir := range []string{"objects", "snapshots", "config"} {
+		if st, err := os.Stat(filepath.Join(repoPath, dir)); err != nil || !st.IsDir() {
+			structural("missing directory: %s", dir)
+		}
+	}
+
+	configPath := filepath.Join(repoPath, "config", "snapsync.yaml")
+	cfg, err := config.Load(configPath)
+	if err != nil {
+		structural("invalid configuration: %v", err)
+		return problems
+	}
+	if cfg.Encryption.Enabled || info.Encrypted {
+		if _, err := os.Stat(filepath.Join(repoPath, "config", "salt")); err != nil {
+			structural("encrypted repository is missing its salt")
+		}
+	}
+
+	return problems
+}
+
+// finishCheck prints the report and fails if it lists any problem
+func finishCheck(report *checkReport, startTime time.Time, jsonOutput bool) error {
+	report.OK = len(report.Problems) == 0
+	report.Duration = time.Since(startTime).Round(time.Millisecond).String()
+
+	if jsonOutput {
+		output, _ := json.MarshalIndent(report, "", "  ")
+		fmt.Println(string(output))
+	} else {
+		for _, p := range report.Problems {
+			fmt.Printf("  %-9s  %s\n", p.Kind, describeProblem(p))
+		}
+		fmt.Println()
+		fmt.Printf("Snapshots checked:   %d\n", report.Snapshots)
+		fmt.Printf("Objects referenced:  %d\n", report.Referenced)
+		if report.ReadData {
+			fmt.Printf("Objects read:        %d\n", report.ObjectsRead)
+		}
+		for _, r := range report.Remote {
+			fmt.Printf("Remote %s: %d objects of %d snapshots compared", r.Name, r.Objects, r.Snapshots)
+			if r.NotUploaded > 0 {
+				fmt.Printf(", %d snapshots not uploaded", r.NotUploaded)
+			}
+			fmt.Println()
+		}
+		fmt.Printf("Duration:            %s\n", report.Duration)
+	}
+
+	if !report.OK {
+		return fmt.Errorf("repository check found %d problems", len(report.Problems))
+	}
+	if !jsonOutput {
+		fmt.Println("No problems found")
+	}
+	return nil
+}
+
+// describeProblem formats a problem as one line of text
+func describeProblem(p snapshot.CheckProblem) string {
+	s := p.Message
+	if p.Object != "" {
+		s = p.Object + ": " + s
+	}
+	if p.SnapshotID != "" {
+		s = "snapshot " + p.SnapshotID + ": " + s
+	}
+	return s
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/snapsync/snapsync/internal/backend"
+	"github.com/snapsync/snapsync/internal/config"
+	"github.com/snapsync/snapsync/internal/rundir"
+	"github.com/snapsync/snapsync/internal/snapshot"
+	"github.com/snapsync/snapsync/pkg/models"
+)
+
+// openCloudBackend connects to the remote storage configured for the
+// repository
+func openCloudBackend(repoPath string, cfg *config.Config) (backend.Backend, error) {
+	rt, err := openRuntime(repoPath)
+	if err != nil {
+		return nil, err
+	}
+	remote, err := openBackend(rt, cfg.Cloud)
+	if err != nil || cfg.Cloud.CacheSize == "" {
+		return remote, err
+	}
+
+	size, err := parseSize(cfg.Cloud.CacheSize)
+	if err != nil {
+		remote.Close()
+		return nil, fmt.Errorf("invalid cache_size: %w", err)
+	}
+	dir, err := rt.Sub("cache")
+	if err != nil {
+		remote.Close()
+		return nil, err
+	}
+	cached, err := backend.NewCacheBackend(remote, dir, size)
+	if err != nil {
+		remote.Close()
+		return nil, err
+	}
+	return cached, nil
+}
+
+// openBackend connects to one configured storage backend, keeping its
+// checkpoints and spool files in the repository's runtime directory
+func openBackend(rt *rundir.Dir, cloud config.CloudConfig) (backend.Backend, error) {
+	switch cloud.Provider {
+	case "", "s3":
+		stateDir, err := rt.Sub("uploads")
+		if err != nil {
+			return nil, err
+		}
+		var partSize int64
+		if cloud.PartSize != "" {
+			if partSize, err = parseSize(cloud.PartSize); err != nil {
+				return nil, fmt.Errorf("invalid part_size: %w", err)
+			}
+		}
+		return backend.NewS3Backend(backend.S3Config{
+			Bucket:       cloud.Bucket,
+			Region:       cloud.Region,
+			Endpoint:     cloud.Endpoint,
+			AccessKey:    cloud.AccessKey,
+			SecretKey:    cloud.SecretKey,
+			MaxBandwidth: cloud.MaxBandwidth,
+			StateDir:     stateDir,
+			PartSize:     partSize,
+			Concurrency:  cloud.UploadConcurrency,
+
+			ServerSideEncryption: cloud.ServerSideEncryption,
+			KMSKeyID:             cloud.KMSKeyID,
+			DataStorageClass:     cloud.DataStorageClass,
+			MetadataStorageClass: cloud.MetadataStorageClass,
+			ObjectLockMode:       cloud.ObjectLockMode,
+			ObjectLockDays:       cloud.ObjectLockDays,
+			Immutable:            cloud.Immutable,
+		})
+	case "b2":
+		return backend.NewB2Backend(backend.B2Config{
+			Bucket:         cloud.Bucket,
+			KeyID:          cloud.AccessKey,
+			ApplicationKey: cloud.SecretKey,
+			Endpoint:       cloud.Endpoint,
+			MaxBandwidth:   cloud.MaxBandwidth,
+		})
+	case "http":
+		return backend.NewHTTPBackend(backend.HTTPConfig{
+			URL:          cloud.Endpoint,
+			Token:        cloud.Token,
+			MaxBandwidth: cloud.MaxBandwidth,
+		})
+	case "rclone":
+		return backend.NewRcloneBackend(backend.RcloneConfig{
+			Remote:       cloud.Remote,
+			MaxBandwidth: cloud.MaxBandwidth,
+		})
+	case "local":
+		if cloud.Path == "" {
+			return nil, fmt.Errorf("local storage needs a path")
+		}
+		return backend.NewLocalBackend(cloud.Path)
+	case "mirror":
+		return openMirror(rt, cloud.Replicas)
+	default:
+		return nil, fmt.Errorf("unsupported cloud provider: %s", cloud.Provider)
+	}
+}
+
+// openMirror connects to the replicas of a mirror; replicas that cannot be
+// reached are reported and left out, as long as one remains
+func openMirror(rt *rundir.Dir, configs []config.CloudConfig) (backend.Backend, error) {
+	var replicas []backend.Replica
+	for _, cloud := range configs {
+		name := replicaName(cloud)
+		if cloud.Provider == "mirror" {
+			return nil, fmt.Errorf("mirror replica %s cannot be a mirror", name)
+		}
+		b, err := openBackend(rt, cloud)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: replica %s unavailable: %v\n", name, err)
+			continue
+		}
+		replicas = append(replicas, backend.Replica{Name: name, Backend: b})
+	}
+	if len(replicas) == 0 {
+		return nil, fmt.Errorf("no mirror replica available")
+	}
+
+	// A replica that is down fails every operation; the first failure says why
+	var mu sync.Mutex
+	reported := make(map[string]bool)
+	mirror, err := backend.NewMirrorBackend(replicas, func(replica string, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if !reported[replica] {
+			reported[replica] = true
+			fmt.Fprintf(os.Stderr, "Warning: replica %s: %v (further failures not shown)\n", replica, err)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	spoolDir, err := rt.TempDir()
+	if err != nil {
+		mirror.Close()
+		return nil, err
+	}
+	mirror.SetSpoolDir(spoolDir)
+	return mirror, nil
+}
+
+// replicaName identifies a replica in messages, e.g. s3:my-bucket
+func replicaName(cloud config.CloudConfig) string {
+	provider := cloud.Provider
+	if provider == "" {
+		provider = "s3"
+	}
+	switch provider {
+	case "http":
+		return provider + ":" + cloud.Endpoint
+	case "rclone":
+		return provider + ":" + cloud.Remote
+	case "local":
+		return provider + ":" + cloud.Path
+	default:
+		return provider + ":" + cloud.Bucket
+	}
+}
+
+// uploadSnapshot copies the objects of a snapshot that the cloud storage
+// lacks, as stored, and then its record, so a record in the cloud never
+// refers to objects missing there; it returns the objects and bytes sent
+func uploadSnapshot(repoPath string, cfg *config.Config, mgr *snapshot.Manager, snap *models.Snapshot) (int, int64, error) {
+	remote, err := openCloudBackend(repoPath, cfg)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer remote.Close()
+
+	refs, err := mgr.References(snap)
+	if err != nil {
+		return 0, 0, err
+	}
+	hashes := make([]string, 0, len(refs))
+	keys := make([]string, 0, len(refs))
+	for hash := range refs {
+		hashes = append(hashes, hash)
+	}
+	sort.Strings(hashes)
+	for _, hash := range hashes {
+		keys = append(keys, backend.ObjectKey(hash))
+	}
+
+	// The storage itself is asked, not the local cache of it: an object
+	// fetched once may have been removed remotely since
+	stored := remote
+	if cached, ok := remote.(*backend.CacheBackend); ok {
+		stored = cached.Unwrap()
+	}
+	present, err := backend.ExistsMany(stored, keys)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to check remote objects: %w", err)
+	}
+
+	var objects int
+	var sent int64
+	var uploaded, reused []string
+	for i, key := range keys {
+		if present[key] {
+			reused = append(reused, key)
+			continue
+		}
+		data, err := mgr.CAS().GetChunk(hashes[i])
+		if err != nil {
+			return objects, sent, err
+		}
+		if err := remote.Put(key, bytes.NewReader(data), int64(len(data))); err != nil {
+			return objects, sent, err
+		}
+		objects++
+		sent += int64(len(data))
+		uploaded = append(uploaded, key)
+	}
+
+	// The record goes up only once all of its objects are locked too
+	if _, err := refreshObjectLocks(repoPath, cfg.Cloud, remote, uploaded, reused); err != nil {
+		return objects, sent, err
+	}
+
+	record, err := os.ReadFile(filepath.Join(repoPath, "snapshots", snap.ID+".json"))
+	if err != nil {
+		return objects, sent, fmt.Errorf("failed to read snapshot record: %w", err)
+	}
+	if err := remote.Put(backend.SnapshotKey(snap.ID), bytes.NewReader(record), int64(len(record))); err != nil {
+		return objects, sent, err
+	}
+	return objects, sent, nil
+}
+
+// forgetRemoteSnapshots deletes the uploaded records of snapshots removed
+// from the repository, so the storage does not keep listing snapshots whose
+// objects the next prune removes there
+// Immutable storage keeps them, as it keeps every object. A failure only
+// warns: the snapshots are gone locally, and prune removes the records.
+func forgetRemoteSnapshots(repoPath string, cfg *config.Config, ids []string) {
+	if !cfg.Cloud.Enabled || cfg.Cloud.Immutable || len(ids) == 0 {
+		return
+	}
+
+	remote, err := openCloudBackend(repoPath, cfg)
+	if err == nil {
+		err = backend.RemoveSnapshots(remote, ids)
+		remote.Close()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: snapshot records left in cloud storage: %v (prune removes them)\n", err)
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/snapsync/snapsync/internal/chunker"
+	"github.com/snapsync/snapsync/internal/compress"
+	"github.com/snapsync/snapsync/internal/crypto"
+	"github.com/snapsync/snapsync/internal/snapshot"
+	"github.com/spf13/cobra"
+)
+
+func copyCmd() *cobra.Command {
+	var from string
+
+	cmd := &cobra.Command{
+		Use:   "copy",
+		Short: "Copy snapshots from another repository",
+		Long: `Copies the snapshots of the repository given with --from that the repository
+given with --repo lacks, oldest first. The destination may use another key,
+compression and chunker settings: each source chunk is decoded, split again
+with the destination's chunker and stored under the destination's key.
+Snapshot IDs, times, tags and locks are kept, and the copies are linked into
+the destination's snapshot chain, so the destination should only receive
+copies.
+
+The destination keeps a map of the source chunks it has stored, and the
+destination chunks each became, in index/copy-map. Running the command again,
+for example to copy the snapshots taken since, reads and encrypts again only
+the chunks the map does not list. A listed chunk is decoded from the
+destination and checked against the source chunk before it is taken, so
+chunks removed by prune are simply copied again. The map is started over if
+the destination's key changes.
+
+A destination records the repository it copies from and refuses copies from
+any other. Both repositories are unlocked as for a backup; with
+--password-file both use the same password, so unlock the source with
+snapsync unlock first if it has another one.`,
+		Example: `  snapsync copy --from /path/to/repo --repo /path/to/offsite`,
+		Args:    cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if repoPath == "" {
+				return fmt.Errorf("repository path required (use --repo)")
+			}
+			if from == "" {
+				return fmt.Errorf("source repository required (use --from)")
+			}
+
+			return runCopy(from, repoPath)
+		},
+	}
+
+	cmd.Flags().StringVar(&from, "from", "", "Repository to copy snapshots from")
+
+	return cmd
+}
+
+func runCopy(from, dest string) error {
+	startTime := time.Now()
+
+	sourceID, err := repoIdentity(from)
+	if err != nil {
+		return fmt.Errorf("source %s: %w", from, err)
+	}
+	info, err := loadRepoInfo(dest)
+	if err != nil {
+		return fmt.Errorf("destination %s: %w", dest, err)
+	}
+	switch {
+	case info.ID == sourceID:
+		return fmt.Errorf("source and destination are the same repository")
+	case info.CopySource != "" && info.CopySource != sourceID:
+		return fmt.Errorf("%s holds copies from repository %s, not from %s (%s)", dest, info.CopySource, from, sourceID)
+	case info.CopySource == "":
+		// Recorded up front, so an interrupted first run already pins it
+		info.CopySource = sourceID
+		if err := saveRepoInfo(dest, info); err != nil {
+			return err
+		}
+	}
+
+	// Both sides run like a backup: side by side with others, not under
+	// a prune
+	srcLock, err := lockRepo(from, "copy", false)
+	if err != nil {
+		return err
+	}
+	defer srcLock.Unlock()
+	destLock, err := lockRepo(dest, "copy", false)
+	if err != nil {
+		return err
+	}
+	defer destLock.Unlock()
+
+	srcCfg := loadRepoConfig(from)
+	destCfg := loadRepoConfig(dest)
+
+	// Source chunks are decompressed as their snapshot records say, so
+	// the source always gets a decompressor
+	srcCompressor, err := compress.New(compress.AlgorithmZstd, srcCfg.Compression.Level, compressOptions()...)
+	if err != nil {
+		return fmt.Errorf("failed to create compressor: %w", err)
+	}
+	defer srcCompressor.Close()
+	srcEncryptor, err := openEncryptor(from, srcCfg, "Enter source repository password: ")
+	if err != nil {
+		return err
+	}
+	src, err := snapshot.NewManager(from, srcCompressor, srcEncryptor)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", from, err)
+	}
+
+	var destCompressor *compress.Compressor
+	if destCfg.Compression.Enabled {
+		destCompressor, err = compress.New(compress.AlgorithmZstd, destCfg.Compression.Level, compressOptions()...)
+		if err != nil {
+			return fmt.Errorf("failed to create compressor: %w", err)
+		}
+		defer destCompressor.Close()
+	}
+	var destEncryptor *crypto.Encryptor
+	var header *crypto.EncryptionHeader
+	if destCfg.Encryption.Enabled {
+		salt, err := repoSalt(dest)
+		if err != nil {
+			return err
+		}
+		destEncryptor, header, err = unlockRepo(dest, destCfg.Encryption, "Enter destination repository password: ", salt)
+		if err != nil {
+			return err
+		}
+	}
+	mgr, err := snapshot.NewManager(dest, destCompressor, destEncryptor)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", dest, err)
+	}
+	splitter, err := chunker.NewSplitter(destCfg.Chunking.Algorithm, destCfg.Chunking.MinSize,
+		destCfg.Chunking.AvgSize, destCfg.Chunking.MaxSize, destCfg.Chunking.ImageProfile)
+	if err != nil {
+		return err
+	}
+	mgr.SetChunker(splitter)
+	mgr.SetPathIndex(destCfg.Repository.PathIndex)
+	mgr.SetEncryptedNames(header != nil && header.EncryptedNames)
+
+	fmt.Printf("Copying snapshots from %s to %s...\n", from, dest)
+	result, err := mgr.CopyFrom(src, sourceID, func(p snapshot.CopyProgress) {
+		status := fmt.Sprintf("%d chunks converted, %d already copied", p.Converted, p.Mapped)
+		if !p.Copied {
+			status = "already in destination"
+		}
+		fmt.Printf("  [%d/%d] snapshot %s: %s\n", p.Index, p.Total, p.Snapshot, status)
+	})
+	if err != nil {
+		return fmt.Errorf("copy stopped, run the command again to resume: %w", err)
+	}
+
+	absDest, _ := filepath.Abs(dest)
+	fmt.Printf("\nCopied to %s\n", absDest)
+	fmt.Printf("  Snapshots:     %d copied, %d already there\n", result.Snapshots, result.Skipped)
+	fmt.Printf("  Source chunks: %d converted, %d already copied\n", result.Converted, result.Mapped)
+	fmt.Printf("  New chunks:    %d (%s)\n", result.NewChunks, formatBytes(result.Bytes))
+	fmt.Printf("  Duration:      %s\n", time.Since(startTime).Round(time.Millisecond))
+	return nil
+}
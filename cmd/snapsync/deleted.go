@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/snapsync/snapsync/internal/crypto"
+	"github.com/snapsync/snapsync/internal/snapshot"
+	"github.com/spf13/cobra"
+)
+
+func deletedCmd() *cobra.Command {
+	var since string
+
+	cmd := &cobra.Command{
+		Use:   "deleted",
+		Short: "List files deleted since earlier snapshots",
+		Long: `Lists files that older snapshots contain but the latest snapshot of the same
+source does not, like a recycle bin for accidental deletions. Each entry shows
+the last snapshot that still has the file and the command that restores it to
+its original location.
+
+--since limits the search to snapshots taken after a date (2006-01-02 or
+RFC 3339) or within an age such as 7d, 2w or 36h.`,
+		Example: `  snapsync deleted --repo /path/to/repo --since 7d
+  snapsync deleted --repo /path/to/repo --since 2024-06-01`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if repoPath == "" {
+				return fmt.Errorf("repository path required (use --repo)")
+			}
+
+			var cutoff time.Time
+			if since != "" {
+				var err error
+				if cutoff, err = parseSince(since, time.Now()); err != nil {
+					return err
+				}
+			}
+
+			return runDeleted(repoPath, cutoff)
+		},
+	}
+
+	cmd.Flags().StringVar(&since, "since", "", "Only search snapshots taken after this date or within this age")
+
+	return cmd
+}
+
+func runDeleted(repoPath string, since time.Time) error {
+	// File names are only readable with the key when they are encrypted
+	var encryptor *crypto.Encryptor
+	if namesEncrypted(repoPath) {
+		var err error
+		encryptor, err = openEncryptor(repoPath, loadRepoConfig(repoPath), "Enter repository password: ")
+		if err != nil {
+			return err
+		}
+	}
+
+	mgr, err := snapshot.NewManager(repoPath, nil, encryptor)
+	if err != nil {
+		return fmt.Errorf("failed to open repository: %w", err)
+	}
+
+	deleted, err := mgr.Deleted(since)
+	if err != nil {
+		return fmt.Errorf("failed to search snapshots: %w", err)
+	}
+	if len(deleted) == 0 {
+		fmt.Println("No deleted files found")
+		return nil
+	}
+
+	var total int64
+	for _, file := range deleted {
+		total += file.Node.Size
+
+		fmt.Printf("%s\n", file.Path)
+		fmt.Printf("  Last seen:  %s (%s)\n", file.SnapshotID, file.Timestamp.Format("2006-01-02 15:04:05"))
+		fmt.Printf("  Size:       %s, modified %s\n", formatBytes(file.Node.Size), file.Node.ModTime.Format("2006-01-02 15:04:05"))
+		fmt.Printf("  Restore:    snapsync restore %s %s --repo %s --include %s\n",
+			file.SnapshotID, shellQuote(file.Root), shellQuote(repoPath), shellQuote(file.Path))
+	}
+
+	fmt.Printf("\nTotal: %d deleted files (%s)\n", len(deleted), formatBytes(total))
+	return nil
+}
+
+// parseSince parses a point in the past, given as a date or as an age
+func parseSince(s string, now time.Time) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", s, time.Local); err == nil {
+		return t, nil
+	}
+
+	// Ages use the retention syntax, counted back from now
+	t, err := parseRetention(s, now)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time: %q", s)
+	}
+	return now.Add(-t.Sub(now)), nil
+}
+
+// shellQuote quotes an argument for a POSIX shell when it needs it
+func shellQuote(s string) string {
+	if s != "" && strings.Trim(s, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_./:@+=,") == "" {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
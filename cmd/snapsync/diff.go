@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/snapsync/snapsync/internal/crypto"
+	"github.com/snapsync/snapsync/internal/diff"
+	"github.com/snapsync/snapsync/internal/snapshot"
+	"github.com/snapsync/snapsync/pkg/models"
+	"github.com/spf13/cobra"
+)
+
+// diffEntry is one changed file in the diff output
+type diffEntry struct {
+	Path      string          `json:"path"`
+	OldPath   string          `json:"old_path,omitempty"`
+	Type      models.DiffType `json:"type"`
+	OldSize   int64           `json:"old_size"`
+	NewSize   int64           `json:"new_size"`
+	SizeDelta int64           `json:"size_delta"`
+}
+
+// diffReport is the diff between two snapshots, printed with --json
+type diffReport struct {
+	From      string      `json:"from"`
+	To        string      `json:"to"`
+	Changes   []diffEntry `json:"changes"`
+	Added     int         `json:"added"`
+	Modified  int         `json:"modified"`
+	Deleted   int         `json:"deleted"`
+	Renamed   int         `json:"renamed"`
+	Unchanged int         `json:"unchanged"`
+	SizeDelta int64       `json:"size_delta"`
+}
+
+func diffCmd() *cobra.Command {
+	var jsonOutput bool
+
+	cmd := &cobra.Command{
+		Use:   "diff [snapshot-a] [snapshot-b]",
+		Short: "Show the files that changed between two snapshots",
+		Long: `Compares two snapshots and lists the files added, modified, deleted and
+renamed between them, with the change in size of each. Snapshots are given
+by ID, a unique ID prefix, "latest", "latest~N" or "@<time>".
+
+Each line starts with the kind of change:
+
+  +  added      M  modified
+  -  deleted    R  renamed`,
+		Example: `  snapsync diff 17921759 latest --repo /path/to/repo
+  snapsync diff 17921759 17921812 --repo /path/to/repo --json`,
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if repoPath == "" {
+				return fmt.Errorf("repository path required (use --repo)")
+			}
+
+			return runDiff(repoPath, args[0], args[1], jsonOutput)
+		},
+	}
+
+	cmd.Flags().BoolVar(&jsonOutput, "json", false, "Output in JSON format")
+
+	return cmd
+}
+
+func runDiff(repoPath, refA, refB string, jsonOutput bool) error {
+	// File names are only readable with the key when they are encrypted
+	var encryptor *crypto.Encryptor
+	if namesEncrypted(repoPath) {
+		var err error
+		encryptor, err = openEncryptor(repoPath, loadRepoConfig(repoPath), "Enter repository password: ")
+		if err != nil {
+			return err
+		}
+	}
+
+	mgr, err := snapshot.NewManager(repoPath, nil, encryptor)
+	if err != nil {
+		return fmt.Errorf("failed to open repository: %w", err)
+	}
+
+	snapA, err := mgr.Resolve(refA)
+	if err != nil {
+		return err
+	}
+	snapB, err := mgr.Resolve(refB)
+	if err != nil {
+		return err
+	}
+	if snapA.Tree == nil || snapA.Tree.Files == nil || snapB.Tree == nil || snapB.Tree.Files == nil {
+		return fmt.Errorf("snapshot has no file list")
+	}
+
+	result := diff.New().Compare(snapA.Tree, snapB.Tree)
+	report := &diffReport{
+		From:      snapA.ID,
+		To:        snapB.ID,
+		Changes:   []diffEntry{},
+		Added:     len(result.Added),
+		Modified:  len(result.Modified),
+		Deleted:   len(result.Deleted),
+		Renamed:   len(result.Renamed),
+		Unchanged: len(result.Unchanged),
+	}
+	for _, group := range [][]*models.FileDiff{result.Added, result.Modified, result.Deleted, result.Renamed} {
+		for _, d := range group {
+			entry := diffEntry{
+				Path:      d.Path,
+				OldPath:   d.OldPath,
+				Type:      d.Type,
+				OldSize:   d.OldSize,
+				NewSize:   d.NewSize,
+				SizeDelta: d.NewSize - d.OldSize,
+			}
+			report.Changes = append(report.Changes, entry)
+			report.SizeDelta += entry.SizeDelta
+		}
+	}
+	sort.Slice(report.Changes, func(i, j int) bool {
+		return report.Changes[i].Path < report.Changes[j].Path
+	})
+
+	if jsonOutput {
+		output, _ := json.MarshalIndent(report, "", "  ")
+		fmt.Println(string(output))
+		return nil
+	}
+
+	for _, c := range report.Changes {
+		switch c.Type {
+		case models.DiffAdded:
+			fmt.Printf("+  %-50s  %10s\n", c.Path, formatDelta(c.SizeDelta))
+		case models.DiffModified:
+			fmt.Printf("M  %-50s  %10s  (%s -> %s)\n", c.Path, formatDelta(c.SizeDelta), formatBytes(c.OldSize), formatBytes(c.NewSize))
+		case models.DiffDeleted:
+			fmt.Printf("-  %-50s  %10s\n", c.Path, formatDelta(c.SizeDelta))
+		case models.DiffRenamed:
+			fmt.Printf("R  %-50s  %10s  (from %s)\n", c.Path, formatDelta(c.SizeDelta), c.OldPath)
+		}
+	}
+
+	if len(report.Changes) > 0 {
+		fmt.Println()
+	}
+	fmt.Printf("%d added, %d modified, %d deleted, %d renamed, %d unchanged (%s)\n",
+		report.Added, report.Modified, report.Deleted, report.Renamed, report.Unchanged, formatDelta(report.SizeDelta))
+	return nil
+}
+
+// formatDelta formats a change in size with its sign
+func formatDelta(delta int64) string {
+	if delta < 0 {
+		return "-" + formatBytes(-delta)
+	}
+	return "+" + formatBytes(delta)
+}
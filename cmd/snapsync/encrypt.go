@@ -0,0 +1,210 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/snapsync/snapsync/internal/config"
+	"github.com/snapsync/snapsync/internal/crypto"
+	"github.com/snapsync/snapsync/internal/snapshot"
+	"github.com/snapsync/snapsync/pkg/models"
+	"github.com/spf13/cobra"
+)
+
+func encryptRepoCmd() *cobra.Command {
+	var (
+		dest         string
+		encryptNames bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "encrypt-repo",
+		Short: "Convert an unencrypted repository to an encrypted one",
+		Long: `Encrypts an existing unencrypted repository, either in place or into a new
+repository given with --to, which is created with the same settings. The
+password is set as for the first backup of an encrypted repository.
+
+Snapshots are converted oldest first. Each snapshot's chunks are encrypted
+as stored, without decompressing or splitting them again, and its record and
+tree objects are rewritten with tiny files and, with --encrypt-names, file
+names encrypted. Snapshot IDs, times, tags and locks are kept, and the
+snapshot chain is relinked so chain verify still passes where it did before;
+published anchors must be renewed.
+
+The conversion can be interrupted and run again to resume. In place, the
+repository is only marked encrypted once every snapshot is converted, and the
+old tree objects are then deleted, so nothing else may use the repository
+until the command finishes. With --to the source is left untouched; use
+snapsync copy to add later snapshots to the encrypted copy.`,
+		Example: `  snapsync encrypt-repo --repo /path/to/repo
+  snapsync encrypt-repo --repo /path/to/repo --to /path/to/encrypted --encrypt-names`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if repoPath == "" {
+				return fmt.Errorf("repository path required (use --repo)")
+			}
+
+			return runEncryptRepo(repoPath, dest, encryptNames)
+		},
+	}
+
+	cmd.Flags().StringVar(&dest, "to", "", "Write the encrypted repository here instead of converting in place")
+	cmd.Flags().BoolVar(&encryptNames, "encrypt-names", false, "Also encrypt file names and directory structure")
+
+	return cmd
+}
+
+func runEncryptRepo(repoPath, dest string, encryptNames bool) error {
+	cfg := loadRepoConfig(repoPath)
+	if cfg.Encryption.Enabled {
+		return fmt.Errorf("repository is already encrypted")
+	}
+
+	// Converting in place rewrites every object under running backups
+	if dest == "" {
+		lock, err := lockRepo(repoPath, "encrypt", true)
+		if err != nil {
+			return err
+		}
+		defer lock.Unlock()
+	}
+
+	src, err := snapshot.NewManager(repoPath, nil, nil)
+	if err != nil {
+		return fmt.Errorf("failed to open repository: %w", err)
+	}
+
+	target := repoPath
+	if dest != "" {
+		if target, err = prepareEncryptedRepo(dest, cfg); err != nil {
+			return err
+		}
+	}
+
+	// Resuming keeps the names setting the first run chose
+	encCfg := cfg.Encryption
+	encCfg.EncryptNames = encryptNames
+	salt, err := repoSalt(target)
+	if err != nil {
+		return err
+	}
+	encryptor, header, err := unlockRepo(target, encCfg, "Enter new repository password: ", salt)
+	if err != nil {
+		return err
+	}
+
+	mgr, err := snapshot.NewManager(target, nil, encryptor)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", target, err)
+	}
+	mgr.SetEncryptedNames(header.EncryptedNames)
+	if dest == "" {
+		src = mgr
+	}
+
+	result, err := mgr.EncryptFrom(src, func(p snapshot.EncryptProgress) {
+		status := fmt.Sprintf("%d chunks encrypted", p.Chunks)
+		if !p.Converted {
+			status = "already converted"
+		}
+		fmt.Printf("  [%d/%d] snapshot %s: %s\n", p.Index, p.Total, p.Snapshot, status)
+	})
+	if err != nil {
+		return fmt.Errorf("conversion stopped, run the command again to resume: %w", err)
+	}
+
+	// Only a fully converted repository is marked encrypted
+	cfg.Encryption.Enabled = true
+	cfg.Encryption.EncryptNames = header.EncryptedNames
+	cfg.Repository.Path = target
+	if err := cfg.Save(filepath.Join(target, "config", "snapsync.yaml")); err != nil {
+		return fmt.Errorf("failed to save config: %w", err)
+	}
+	if err := markRepoEncrypted(target); err != nil {
+		return err
+	}
+
+	fmt.Printf("\nEncrypted %s\n", target)
+	fmt.Printf("  Snapshots:         %d converted, %d already done\n", result.Snapshots, result.Skipped)
+	fmt.Printf("  Chunks:            %d (%s)\n", result.Chunks, formatBytes(result.Bytes))
+	if dest == "" {
+		fmt.Printf("  Plaintext objects: %d removed\n", result.Removed)
+	} else {
+		fmt.Printf("  Source %s is unchanged; remove it once the new repository checks out\n", repoPath)
+	}
+	return nil
+}
+
+// prepareEncryptedRepo creates the repository an encrypted copy is written
+// to, with the settings of the source, or reuses it to resume
+func prepareEncryptedRepo(dest string, cfg *config.Config) (string, error) {
+	dest, err := filepath.Abs(dest)
+	if err != nil {
+		return "", err
+	}
+	if _, err := os.Stat(filepath.Join(dest, "repo.json")); err == nil {
+		if loadRepoConfig(dest).Encryption.Enabled {
+			return "", fmt.Errorf("%s is already an encrypted repository", dest)
+		}
+		fmt.Printf("Resuming conversion into %s\n", dest)
+		return dest, nil
+	}
+	if entries, err := os.ReadDir(dest); err == nil && len(entries) > 0 {
+		return "", fmt.Errorf("%s is not empty", dest)
+	}
+
+	if err := initRepository(dest, false); err != nil {
+		return "", err
+	}
+	copied := *cfg
+	copied.Repository.Path = dest
+	if err := copied.Save(filepath.Join(dest, "config", "snapsync.yaml")); err != nil {
+		return "", fmt.Errorf("failed to save config: %w", err)
+	}
+	return dest, nil
+}
+
+// repoSalt returns the repository's key derivation salt, creating it for a
+// repository that has none yet
+func repoSalt(repoPath string) ([]byte, error) {
+	saltPath := filepath.Join(repoPath, "config", "salt")
+	if data, err := os.ReadFile(saltPath); err == nil {
+		return hex.DecodeString(string(data))
+	}
+
+	salt, err := crypto.GenerateSalt()
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(saltPath), 0755); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(saltPath, []byte(hex.EncodeToString(salt)), 0600); err != nil {
+		return nil, fmt.Errorf("failed to write salt: %w", err)
+	}
+	return salt, nil
+}
+
+// markRepoEncrypted records in repo.json that the repository is encrypted
+func markRepoEncrypted(repoPath string) error {
+	infoPath := filepath.Join(repoPath, "repo.json")
+	info := models.RepositoryInfo{Version: 1}
+	if data, err := os.ReadFile(infoPath); err == nil {
+		if err := json.Unmarshal(data, &info); err != nil {
+			return fmt.Errorf("invalid repo info: %w", err)
+		}
+	}
+	info.Encrypted = true
+
+	data, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(infoPath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write repo info: %w", err)
+	}
+	return nil
+}
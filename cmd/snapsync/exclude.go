@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/snapsync/snapsync/internal/appdata"
+	"github.com/snapsync/snapsync/internal/config"
+	"github.com/snapsync/snapsync/internal/scanner"
+	"github.com/spf13/cobra"
+)
+
+func excludeTestCmd() *cobra.Command {
+	var (
+		patterns     []string
+		presets      []string
+		appPresets   []string
+		showIncluded bool
+		listPresets  bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "exclude-test [source]",
+		Short: "Show which paths a backup would skip and why",
+		Long: `Walks the source with the same exclusion rules as backup and prints every
+file and directory that would be skipped, with the pattern that matched and
+how it matched:
+
+  name  the pattern equals the file or directory name
+  glob  the pattern matches the name as a glob
+  path  the pattern occurs in the path relative to the source
+  root  the pattern starts with / and names a path from the source root
+  app   an app data preset leaves out an application's cache or lock file
+
+Patterns come from the repository config (--repo), or the built-in defaults
+without one, including its exclude presets and the profile for the source,
+plus any given with --exclude-preset and --exclude. App data presets are
+taken from the config and --app-preset. Nothing is read or stored.
+--list-presets shows the built-in presets.`,
+		Example: `  snapsync exclude-test ~/project --repo /path/to/repo
+  snapsync exclude-test ~/project -x "*.iso" --included
+  snapsync exclude-test ~ --exclude-preset macos-user --exclude-preset developer`,
+		Args: cobra.RangeArgs(0, 1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if listPresets {
+				runListPresets()
+				return nil
+			}
+			if len(args) == 0 {
+				return fmt.Errorf("source required")
+			}
+			return runExcludeTest(args[0], presets, appPresets, patterns, showIncluded)
+		},
+	}
+
+	cmd.Flags().StringArrayVarP(&patterns, "exclude", "x", nil, "Additional exclude patterns")
+	cmd.Flags().StringArrayVar(&presets, "exclude-preset", nil, "Built-in exclusion preset (repeatable)")
+	cmd.Flags().StringArrayVar(&appPresets, "app-preset", nil, "App data preset (repeatable)")
+	cmd.Flags().BoolVar(&listPresets, "list-presets", false, "List the built-in exclusion presets")
+	cmd.Flags().BoolVar(&showIncluded, "included", false, "Also list the paths that would be backed up")
+
+	return cmd
+}
+
+func runExcludeTest(source string, presets, appPresets, patterns []string, showIncluded bool) error {
+	if _, err := os.Stat(source); err != nil {
+		return fmt.Errorf("source not found: %w", err)
+	}
+
+	// Same pattern list as backup
+	cfg := config.DefaultConfig()
+	if repoPath != "" {
+		cfg = loadRepoConfig(repoPath)
+	}
+	exclusions, err := cfg.ExclusionsFor(source, presets, patterns)
+	if err != nil {
+		return err
+	}
+	apps, err := appdata.New(cfg.AppPresetsFor(source, appPresets))
+	if err != nil {
+		return err
+	}
+
+	var skippedFiles, skippedDirs, included int
+	s := scanner.New(exclusions, 1)
+	s.SetAppData(apps)
+	err = s.ExplainExclusions(source, func(relPath string, isDir bool, ex *scanner.Exclusion) {
+		display := relPath
+		if isDir {
+			display += "/"
+		}
+
+		if ex == nil {
+			if relPath != "." {
+				included++
+			}
+			if showIncluded && relPath != "." {
+				fmt.Printf("included  %s\n", display)
+			}
+			return
+		}
+
+		if isDir {
+			skippedDirs++
+		} else {
+			skippedFiles++
+		}
+		fmt.Printf("excluded  %-50s  %s %q\n", display, ex.Rule, ex.Pattern)
+	})
+	if err != nil {
+		return fmt.Errorf("scan failed: %w", err)
+	}
+
+	fmt.Printf("\n%d paths included, %d files and %d directories excluded\n", included, skippedFiles, skippedDirs)
+	return nil
+}
+
+func runListPresets() {
+	for _, name := range config.PresetNames() {
+		preset := config.ExcludePresets[name]
+		fmt.Printf("%s\n  %s\n  %s\n\n", name, preset.Description, strings.Join(preset.Patterns, " "))
+	}
+
+	fmt.Println("App data presets (--app-preset):")
+	fmt.Println()
+	for _, name := range appdata.Names() {
+		preset := appdata.Presets[name]
+		fmt.Printf("%s\n  %s\n", name, preset.Description)
+		if len(preset.Skip) > 0 {
+			fmt.Printf("  skip: %s\n", strings.Join(preset.Skip, ", "))
+		}
+		if len(preset.Capture) > 0 {
+			fmt.Printf("  capture: %s\n", strings.Join(preset.Capture, ", "))
+		}
+		fmt.Println()
+	}
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/snapsync/snapsync/internal/compress"
+	"github.com/snapsync/snapsync/internal/restore"
+	"github.com/snapsync/snapsync/internal/snapshot"
+	"github.com/spf13/cobra"
+)
+
+func exportCmd() *cobra.Command {
+	var (
+		output      string
+		format      string
+		compression string
+		prefix      string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "export [snapshot-id]",
+		Short: "Write a snapshot as a tar archive or SnapSync export",
+		Long: `Streams a snapshot into a single tar archive that standard tools can
+unpack, for handing a backup to someone without SnapSync. The snapshot is an
+ID, a unique ID prefix, "latest", "latest~N" or "@<time>".
+
+With --format snapsync, or an output name ending in .ssx (optionally .zst or
+.gz), the snapshot is written in the SnapSync export format instead: a JSON
+manifest of every file with its metadata and chunk list, followed by each
+chunk once. The format is versioned and specified in docs/export-format.md,
+so other tools can read it, and snapsync import brings it back with its
+deduplication intact.
+
+The archive is compressed according to the output name: .tar.zst or .tzst
+with zstd, .tar.gz or .tgz with gzip, and .tar not at all; --compression
+overrides this. Entries sit under a directory named after the backup root
+unless --prefix says otherwise (--prefix "" for none). A device snapshot is
+exported as a single image file.`,
+		Example: `  snapsync export latest --output backup.tar.zst --repo /path/to/repo
+  snapsync export 17921759 --output - --compression gzip --repo /path/to/repo | ssh host tar xzf -
+  snapsync export latest --output home.ssx.zst --repo /path/to/repo`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if repoPath == "" {
+				return fmt.Errorf("repository path required (use --repo)")
+			}
+			if output == "" {
+				return fmt.Errorf("output file required (use --output, - for stdout)")
+			}
+
+			if format == "" {
+				format = exportFormat(output)
+			}
+			if format != "tar" && format != "snapsync" {
+				return fmt.Errorf("unknown format %q (use tar or snapsync)", format)
+			}
+			if compression == "" {
+				compression = archiveCompression(output)
+			}
+			switch compression {
+			case "none", "gzip", "zstd":
+			default:
+				return fmt.Errorf("unknown compression %q (use none, gzip or zstd)", compression)
+			}
+
+			var p *string
+			if cmd.Flags().Changed("prefix") {
+				if format == "snapsync" {
+					return fmt.Errorf("--prefix applies to tar archives only")
+				}
+				p = &prefix
+			}
+
+			return runExport(repoPath, args[0], output, format, compression, p)
+		},
+	}
+
+	cmd.Flags().StringVarP(&output, "output", "o", "", "Archive to write, - for stdout")
+	cmd.Flags().StringVar(&format, "format", "", "tar or snapsync (default from the output name)")
+	cmd.Flags().StringVar(&compression, "compression", "", "none, gzip or zstd (default from the output name)")
+	cmd.Flags().StringVar(&prefix, "prefix", "", "Directory to put entries under (default the backup root's name)")
+
+	return cmd
+}
+
+func runExport(repoPath, ref, output, format, compression string, prefix *string) error {
+	cfg := loadRepoConfig(repoPath)
+
+	var compressor *compress.Compressor
+	if cfg.Compression.Enabled {
+		var err error
+		compressor, err = compress.New(compress.AlgorithmZstd, cfg.Compression.Level, compressOptions()...)
+		if err != nil {
+			return fmt.Errorf("failed to create compressor: %w", err)
+		}
+		defer compressor.Close()
+	}
+
+	encryptor, err := openEncryptor(repoPath, cfg, "Enter repository password: ")
+	if err != nil {
+		return err
+	}
+
+	mgr, err := snapshot.NewManager(repoPath, compressor, encryptor)
+	if err != nil {
+		return fmt.Errorf("failed to open repository: %w", err)
+	}
+
+	snap, err := mgr.Resolve(ref)
+	if err != nil {
+		return err
+	}
+
+	name := ""
+	if prefix != nil {
+		name = strings.Trim(filepath.ToSlash(*prefix), "/")
+	} else if root := snap.Tree.Root; root != nil && root.IsDir {
+		name = filepath.Base(root.Path)
+	}
+
+	// Write next to the output and rename once complete, so a failed export
+	// leaves no truncated archive behind
+	var out io.Writer = os.Stdout
+	var file *os.File
+	if output != "-" {
+		file, err = os.CreateTemp(filepath.Dir(output), "."+filepath.Base(output)+".tmp-*")
+		if err != nil {
+			return fmt.Errorf("failed to create output file: %w", err)
+		}
+		defer os.Remove(file.Name())
+		defer file.Close()
+		out = file
+	}
+
+	buffered := bufio.NewWriterSize(out, 1<<20)
+	archive, err := archiveWriter(buffered, compression)
+	if err != nil {
+		return err
+	}
+
+	restorer := restore.NewRestorer(mgr.CAS(), compressor, encryptor)
+	var result *restore.TarResult
+	if format == "snapsync" {
+		result, err = restorer.WriteExport(snap, archive)
+	} else {
+		result, err = restorer.WriteTar(snap, archive, name)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to export snapshot: %w", err)
+	}
+	if err := archive.Close(); err != nil {
+		return fmt.Errorf("failed to finish archive: %w", err)
+	}
+	if err := buffered.Flush(); err != nil {
+		return fmt.Errorf("failed to write archive: %w", err)
+	}
+
+	if file == nil {
+		fmt.Fprintf(os.Stderr, "Exported %d files, %d directories (%s) from snapshot %s\n",
+			result.Files, result.Dirs, formatBytes(result.Bytes), snap.ID)
+		return nil
+	}
+
+	if err := file.Sync(); err != nil {
+		return fmt.Errorf("failed to write archive: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("failed to write archive: %w", err)
+	}
+	if err := os.Chmod(file.Name(), 0644); err != nil {
+		return fmt.Errorf("failed to set archive permissions: %w", err)
+	}
+	if err := os.Rename(file.Name(), output); err != nil {
+		return fmt.Errorf("failed to create %s: %w", output, err)
+	}
+
+	info, _ := os.Stat(output)
+	fmt.Printf("Exported snapshot %s to %s\n", snap.ID, output)
+	fmt.Printf("  Files:      %d (%s)\n", result.Files, formatBytes(result.Bytes))
+	fmt.Printf("  Dirs:       %d\n", result.Dirs)
+	if info != nil {
+		fmt.Printf("  Archive:    %s (%s, %s)\n", formatBytes(info.Size()), format, compression)
+	}
+	return nil
+}
+
+// exportFormat picks the format an output name implies
+func exportFormat(name string) string {
+	if strings.Contains(strings.ToLower(filepath.Base(name)), ".ssx") {
+		return "snapsync"
+	}
+	return "tar"
+}
+
+// archiveCompression picks the compression an archive name implies
+func archiveCompression(name string) string {
+	lower := strings.ToLower(name)
+	switch {
+	case strings.HasSuffix(lower, ".zst"), strings.HasSuffix(lower, ".tzst"):
+		return "zstd"
+	case strings.HasSuffix(lower, ".gz"), strings.HasSuffix(lower, ".tgz"):
+		return "gzip"
+	default:
+		return "none"
+	}
+}
+
+// nopWriteCloser adds a no-op Close to an uncompressed archive
+type nopWriteCloser struct{ io.Writer }
+
+func (nopWriteCloser) Close() error { return nil }
+
+// archiveWriter wraps w in the archive compression
+func archiveWriter(w io.Writer, compression string) (io.WriteCloser, error) {
+	switch compression {
+	case "zstd":
+		enc, err := zstd.NewWriter(w)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create zstd writer: %w", err)
+		}
+		return enc, nil
+	case "gzip":
+		return gzip.NewWriter(w), nil
+	default:
+		return nopWriteCloser{w}, nil
+	}
+}
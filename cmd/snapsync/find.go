@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/snapsync/snapsync/internal/crypto"
+	"github.com/snapsync/snapsync/internal/snapshot"
+	"github.com/spf13/cobra"
+)
+
+// findEntry is one matching path, printed with --json
+type findEntry struct {
+	Snapshot  string    `json:"snapshot"`
+	Timestamp time.Time `json:"timestamp"`
+	Root      string    `json:"root"`
+	Path      string    `json:"path"`
+	IsDir     bool      `json:"is_dir"`
+	Size      int64     `json:"size"`
+	ModTime   time.Time `json:"mod_time"`
+}
+
+func findCmd() *cobra.Command {
+	var (
+		ignoreCase bool
+		jsonOutput bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "find [pattern]",
+		Short: "Search file paths across all snapshots",
+		Long: `Searches the file tree of every snapshot for paths matching a glob pattern and
+lists the snapshots that contain them, newest first, with each file's size and
+modification time.
+
+A pattern without a slash matches file and directory names anywhere in the
+tree (*.sql); a pattern with one matches the whole path relative to the backup
+root (var/lib/*/dump.sql). Quote the pattern so the shell does not expand it.`,
+		Example: `  snapsync find "*.sql" --repo /path/to/repo
+  snapsync find "home/*/Documents/*.pdf" --repo /path/to/repo --json`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if repoPath == "" {
+				return fmt.Errorf("repository path required (use --repo)")
+			}
+
+			return runFind(repoPath, args[0], ignoreCase, jsonOutput)
+		},
+	}
+
+	cmd.Flags().BoolVarP(&ignoreCase, "ignore-case", "i", false, "Match the pattern case-insensitively")
+	cmd.Flags().BoolVar(&jsonOutput, "json", false, "Output in JSON format")
+
+	return cmd
+}
+
+func runFind(repoPath, pattern string, ignoreCase, jsonOutput bool) error {
+	// File names are only readable with the key when they are encrypted
+	var encryptor *crypto.Encryptor
+	if namesEncrypted(repoPath) {
+		var err error
+		encryptor, err = openEncryptor(repoPath, loadRepoConfig(repoPath), "Enter repository password: ")
+		if err != nil {
+			return err
+		}
+	}
+
+	mgr, err := snapshot.NewManager(repoPath, nil, encryptor)
+	if err != nil {
+		return fmt.Errorf("failed to open repository: %w", err)
+	}
+
+	found, err := mgr.Find(pattern, ignoreCase)
+	if err != nil {
+		return fmt.Errorf("failed to search snapshots: %w", err)
+	}
+
+	if jsonOutput {
+		entries := []findEntry{}
+		for _, f := range found {
+			entries = append(entries, findEntry{
+				Snapshot:  f.SnapshotID,
+				Timestamp: f.Timestamp,
+				Root:      f.Root,
+				Path:      f.Path,
+				IsDir:     f.Node.IsDir,
+				Size:      f.Node.Size,
+				ModTime:   f.Node.ModTime,
+			})
+		}
+		output, _ := json.MarshalIndent(entries, "", "  ")
+		fmt.Println(string(output))
+		return nil
+	}
+
+	if len(found) == 0 {
+		fmt.Println("No matching paths found")
+		return nil
+	}
+
+	var snapshots int
+	for i, f := range found {
+		if i == 0 || f.SnapshotID != found[i-1].SnapshotID {
+			if i > 0 {
+				fmt.Println()
+			}
+			fmt.Printf("Snapshot %s  %s  %s\n", f.SnapshotID, f.Timestamp.Format("2006-01-02 15:04:05"), f.Root)
+			snapshots++
+		}
+
+		size := formatBytes(f.Node.Size)
+		if f.Node.IsDir {
+			size = "<dir>"
+		}
+		fmt.Printf("  %10s  %-20s  %s\n", size, f.Node.ModTime.Format("2006-01-02 15:04:05"), f.Path)
+	}
+
+	fmt.Printf("\nTotal: %d matches in %d snapshots\n", len(found), snapshots)
+	return nil
+}
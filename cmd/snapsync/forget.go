@@ -0,0 +1,209 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/snapsync/snapsync/internal/config"
+	"github.com/snapsync/snapsync/internal/crypto"
+	"github.com/snapsync/snapsync/internal/snapshot"
+	"github.com/snapsync/snapsync/pkg/models"
+	"github.com/spf13/cobra"
+)
+
+// forgetOptions holds the flags of the forget command
+type forgetOptions struct {
+	rules  config.KeepRules
+	tags   []string
+	dryRun bool
+	prune  bool
+}
+
+func forgetCmd() *cobra.Command {
+	var opts forgetOptions
+
+	cmd := &cobra.Command{
+		Use:   "forget",
+		Short: "Delete snapshots according to keep rules",
+		Long: `Applies keep rules to the snapshots of each backup source and deletes the
+snapshots no rule keeps. A snapshot is kept if any rule selects it:
+
+  --keep-last N      the N newest snapshots
+  --keep-hourly N    the newest snapshot of each of the last N hours with one
+  --keep-daily N     likewise for days, --keep-weekly for weeks,
+                     --keep-monthly for months and --keep-yearly for years
+  --keep-within D    everything taken within D of now (30d, 6w, 1y, 36h)
+
+Without --keep-* flags the rules under retention in the configuration are
+used. Snapshots pinned to a retention tier, or tagged for one, are judged by
+that tier's rules instead (see snapsync tier). Expired snapshots are deleted
+whatever the rules say, and snapshots under a retention lock are never
+deleted.
+
+Forgetting a snapshot only removes its record; --prune then deletes the
+data no remaining snapshot uses.`,
+		Example: `  snapsync forget --keep-daily 7 --keep-weekly 4 --keep-monthly 12 --repo /path/to/repo --dry-run
+  snapsync forget --keep-last 10 --tag nightly --prune --repo /path/to/repo`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if repoPath == "" {
+				return fmt.Errorf("repository path required (use --repo)")
+			}
+
+			return runForget(repoPath, opts)
+		},
+	}
+
+	cmd.Flags().IntVar(&opts.rules.KeepLast, "keep-last", 0, "Keep the N newest snapshots")
+	cmd.Flags().IntVar(&opts.rules.KeepHourly, "keep-hourly", 0, "Keep the newest snapshot of each of the last N hours")
+	cmd.Flags().IntVar(&opts.rules.KeepDaily, "keep-daily", 0, "Keep the newest snapshot of each of the last N days")
+	cmd.Flags().IntVar(&opts.rules.KeepWeekly, "keep-weekly", 0, "Keep the newest snapshot of each of the last N weeks")
+	cmd.Flags().IntVar(&opts.rules.KeepMonthly, "keep-monthly", 0, "Keep the newest snapshot of each of the last N months")
+	cmd.Flags().IntVar(&opts.rules.KeepYearly, "keep-yearly", 0, "Keep the newest snapshot of each of the last N years")
+	cmd.Flags().StringVar(&opts.rules.KeepWithin, "keep-within", "", "Keep every snapshot taken within this duration (e.g. 30d)")
+	cmd.Flags().StringArrayVar(&opts.tags, "tag", nil, "Only consider snapshots with this tag (repeatable)")
+	cmd.Flags().BoolVarP(&opts.dryRun, "dry-run", "n", false, "Show what would be deleted without deleting it")
+	cmd.Flags().BoolVar(&opts.prune, "prune", false, "Delete unreferenced objects afterwards")
+
+	return cmd
+}
+
+func runForget(repoPath string, opts forgetOptions) error {
+	cfg := loadRepoConfig(repoPath)
+
+	// Flags replace the configured rules for snapshots outside any tier
+	if opts.rules != (config.KeepRules{}) {
+		cfg.Retention.KeepRules = opts.rules
+	}
+	tiers, err := retentionTiers(cfg.Retention)
+	if err != nil {
+		return err
+	}
+	if tiers.Default.Empty() && len(tiers.Policies) == 0 {
+		return fmt.Errorf("no keep rules given (use --keep-* or retention in the configuration)")
+	}
+
+	// Roots are encrypted with the names; pruning walks encrypted trees
+	var encryptor *crypto.Encryptor
+	if namesEncrypted(repoPath) || opts.prune {
+		encryptor, err = openEncryptor(repoPath, cfg, "Enter repository password: ")
+		if err != nil {
+			return err
+		}
+	}
+
+	mgr, err := snapshot.NewManager(repoPath, nil, encryptor)
+	if err != nil {
+		return fmt.Errorf("failed to open repository: %w", err)
+	}
+
+	records, err := mgr.ListRecords()
+	if err != nil {
+		return fmt.Errorf("failed to list snapshots: %w", err)
+	}
+
+	// Rules apply to each backup source separately
+	groups, roots := sourceGroups(records, opts.tags)
+
+	now := time.Now()
+	var removed, locked int
+	var deleted []string
+	for _, root := range roots {
+		result := tiers.Apply(groups[root], now)
+		fmt.Printf("Source %s: %d snapshots\n", root, len(groups[root]))
+
+		remove := make(map[string]bool)
+		for _, snap := range result.Remove {
+			remove[snap.ID] = true
+		}
+
+		for _, snap := range groups[root] {
+			action, detail := "keep", strings.Join(result.Reasons[snap.ID], ", ")
+			if remove[snap.ID] || snap.Expired(now) {
+				if snap.RetentionLocked(now) {
+					action, detail = "locked", "retained until "+snap.RetainUntil.Format("2006-01-02")
+					locked++
+				} else {
+					action, detail = "remove", ""
+					if snap.Expired(now) {
+						detail = "expired"
+					}
+					if !opts.dryRun {
+						if err := mgr.Delete(snap.ID); err != nil {
+							forgetRemoteSnapshots(repoPath, cfg, deleted)
+							return fmt.Errorf("failed to delete snapshot %s: %w", snap.ID, err)
+						}
+						deleted = append(deleted, snap.ID)
+					}
+					removed++
+				}
+			}
+
+			fmt.Printf("  %-6s  %s  %s  %s\n", action, snap.ID, snap.Timestamp.Format("2006-01-02 15:04:05"), detail)
+		}
+		fmt.Println()
+	}
+
+	forgetRemoteSnapshots(repoPath, cfg, deleted)
+
+	if opts.dryRun {
+		fmt.Printf("%d snapshots would be deleted", removed)
+	} else {
+		fmt.Printf("%d snapshots deleted", removed)
+	}
+	if locked > 0 {
+		fmt.Printf(", %d kept by retention locks", locked)
+	}
+	fmt.Println()
+
+	if !opts.prune {
+		return nil
+	}
+	if opts.dryRun {
+		fmt.Println("Prune skipped in a dry run")
+		return nil
+	}
+	fmt.Println()
+	return pruneRepository(repoPath, cfg, mgr, false)
+}
+
+// sourceGroups groups the snapshots carrying every tag in tags by backup
+// source, and returns the sources in order
+func sourceGroups(records []*models.Snapshot, tags []string) (map[string][]*models.Snapshot, []string) {
+	groups := make(map[string][]*models.Snapshot)
+	for _, record := range records {
+		if !hasTags(record, tags) {
+			continue
+		}
+		root := ""
+		if record.Tree != nil && record.Tree.Root != nil {
+			root = record.Tree.Root.Path
+		}
+		groups[root] = append(groups[root], record)
+	}
+	roots := make([]string, 0, len(groups))
+	for root := range groups {
+		roots = append(roots, root)
+	}
+	sort.Strings(roots)
+	return groups, roots
+}
+
+// hasTags reports whether a snapshot carries every tag in tags
+func hasTags(snap *models.Snapshot, tags []string) bool {
+	for _, tag := range tags {
+		found := false
+		for _, have := range snap.Tags {
+			if have == tag {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/snapsync/snapsync/internal/chunker"
+	"github.com/snapsync/snapsync/internal/compress"
+	"github.com/snapsync/snapsync/internal/interchange"
+	"github.com/snapsync/snapsync/internal/snapshot"
+	"github.com/snapsync/snapsync/pkg/models"
+	"github.com/spf13/cobra"
+)
+
+// importOptions holds the flags of the import command
+type importOptions struct {
+	source      string
+	format      string
+	description string
+	strip       int
+	tags        []string
+	tier        string
+	noCompress  bool
+}
+
+func importCmd() *cobra.Command {
+	var opts importOptions
+
+	cmd := &cobra.Command{
+		Use:   "import [archive]",
+		Short: "Import a tar archive or SnapSync export as a snapshot",
+		Long: `Reads a tar archive, plain or compressed with gzip or zstd, and stores its
+contents as a new snapshot, chunked and deduplicated against everything
+already in the repository. Use it to migrate tarball backups; the inverse of
+snapsync export. Use - to read the archive from stdin.
+
+Regular files and directories keep their permissions and modification
+times. Symbolic links, hard links and special files are skipped and listed.
+The snapshot's source is named after the archive unless --source is given;
+--strip-components drops leading path components as tar does.
+
+With --format snapsync, or an archive name containing .ssx, the input is a
+SnapSync export written by snapsync export or another tool following
+docs/export-format.md. Its chunks are verified and stored as they are, and
+the snapshot keeps the exported source, description and tags unless
+--source or --description is given.`,
+		Example: `  snapsync import home-2019.tar.gz --repo /path/to/repo --strip-components 1
+  ssh host tar cf - /srv | snapsync import - --source host:/srv --repo /path/to/repo
+  snapsync import home.ssx.zst --repo /path/to/repo`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if repoPath == "" {
+				return fmt.Errorf("repository path required (use --repo)")
+			}
+			if opts.strip < 0 {
+				return fmt.Errorf("--strip-components cannot be negative")
+			}
+			if opts.format == "" {
+				opts.format = exportFormat(args[0])
+			}
+			switch opts.format {
+			case "tar":
+			case "snapsync":
+				if opts.strip > 0 {
+					return fmt.Errorf("--strip-components applies to tar archives only")
+				}
+				return runImport(repoPath, args[0], opts)
+			default:
+				return fmt.Errorf("unknown format %q (use tar or snapsync)", opts.format)
+			}
+			if opts.source == "" {
+				if args[0] == "-" {
+					return fmt.Errorf("source name required when reading stdin (use --source)")
+				}
+				opts.source = archiveSource(args[0])
+			}
+
+			return runImport(repoPath, args[0], opts)
+		},
+	}
+
+	cmd.Flags().StringVar(&opts.source, "source", "", "Source name recorded as the backup root (default the archive name)")
+	cmd.Flags().StringVar(&opts.format, "format", "", "tar or snapsync (default from the archive name)")
+	cmd.Flags().StringVarP(&opts.description, "description", "d", "", "Snapshot description")
+	cmd.Flags().IntVar(&opts.strip, "strip-components", 0, "Leading path components to drop from entries")
+	cmd.Flags().StringArrayVar(&opts.tags, "tag", nil, "Tag to record on the snapshot (repeatable)")
+	cmd.Flags().StringVar(&opts.tier, "tier", "", "Pin the snapshot to a retention tier (e.g. yearly)")
+	cmd.Flags().BoolVar(&opts.noCompress, "no-compress", false, "Disable compression")
+
+	return cmd
+}
+
+func runImport(repoPath, archive string, opts importOptions) error {
+	startTime := time.Now()
+	cfg := loadRepoConfig(repoPath)
+
+	in := os.Stdin
+	if archive != "-" {
+		file, err := os.Open(archive)
+		if err != nil {
+			return fmt.Errorf("failed to open archive: %w", err)
+		}
+		defer file.Close()
+		in = file
+	}
+
+	var compressor *compress.Compressor
+	if !opts.noCompress {
+		var err error
+		compressor, err = compress.New(compress.AlgorithmZstd, cfg.Compression.Level, compressOptions()...)
+		if err != nil {
+			return fmt.Errorf("failed to create compressor: %w", err)
+		}
+		defer compressor.Close()
+	}
+
+	encryptor, err := openEncryptor(repoPath, cfg, "Enter backup password: ")
+	if err != nil {
+		return err
+	}
+
+	mgr, err := snapshot.NewManager(repoPath, compressor, encryptor)
+	if err != nil {
+		return fmt.Errorf("failed to open repository: %w", err)
+	}
+
+	splitter, err := chunker.NewSplitter(cfg.Chunking.Algorithm, cfg.Chunking.MinSize,
+		cfg.Chunking.AvgSize, cfg.Chunking.MaxSize, cfg.Chunking.ImageProfile)
+	if err != nil {
+		return err
+	}
+	mgr.SetChunker(splitter)
+	mgr.SetPathIndex(cfg.Repository.PathIndex)
+	mgr.SetTags(opts.tags)
+	mgr.SetTier(opts.tier)
+	mgr.SetEncryptedNames(encryptor != nil && namesEncrypted(repoPath))
+
+	var snap *models.Snapshot
+	var skipped []string
+	if opts.format == "snapsync" {
+		export, err := interchange.NewReader(in)
+		if err != nil {
+			return err
+		}
+		mgr.SetTags(append(append([]string(nil), export.Manifest.Snapshot.Tags...), opts.tags...))
+
+		fmt.Printf("Importing %s (snapshot %s from %s)...\n", archive,
+			export.Manifest.Snapshot.ID, export.Manifest.Snapshot.Timestamp.Format("2006-01-02 15:04:05"))
+		snap, err = mgr.CreateFromExport(export, opts.source, opts.description)
+		if err != nil {
+			return fmt.Errorf("import failed: %w", err)
+		}
+		opts.source = snap.Tree.Root.Path
+	} else {
+		source, err := snapshot.NewTarSource(in, opts.strip)
+		if err != nil {
+			return err
+		}
+
+		description := opts.description
+		if description == "" && archive != "-" {
+			description = "Imported from " + filepath.Base(archive)
+		}
+
+		fmt.Printf("Importing %s...\n", archive)
+		snap, err = mgr.CreateFromStream(opts.source, source, description, "")
+		if err != nil {
+			return fmt.Errorf("import failed: %w", err)
+		}
+		skipped = source.Skipped
+	}
+
+	fmt.Println()
+	fmt.Println("Import complete!")
+	fmt.Printf("  Snapshot ID:    %s\n", snap.ID)
+	fmt.Printf("  Source:         %s\n", opts.source)
+	fmt.Printf("  Files:          %d\n", snap.Tree.FileCount)
+	fmt.Printf("  Directories:    %d\n", snap.Tree.DirCount)
+	fmt.Printf("  Total size:     %s\n", formatBytes(snap.Stats.TotalSize))
+	fmt.Printf("  Stored size:    %s\n", formatBytes(snap.Stats.StoredSize))
+	fmt.Printf("  Dedup savings:  %s\n", formatBytes(snap.Stats.DeduplicatedSize))
+	fmt.Printf("  New chunks:     %d\n", snap.Stats.NewChunks)
+	fmt.Printf("  Duration:       %s\n", time.Since(startTime).Round(time.Millisecond))
+	if len(skipped) > 0 {
+		fmt.Printf("  Skipped:        %d entries\n", len(skipped))
+		for _, entry := range skipped {
+			fmt.Fprintf(os.Stderr, "Warning: skipped %s\n", entry)
+		}
+	}
+
+	return nil
+}
+
+// archiveSource names an imported archive's source after the archive file
+func archiveSource(archive string) string {
+	name := filepath.Base(archive)
+	for _, ext := range []string{".gz", ".tgz", ".zst", ".tzst", ".tar", ".ssx"} {
+		name = strings.TrimSuffix(name, ext)
+	}
+	return name
+}
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"os"
@@ -57,9 +59,14 @@ func initRepository(path string, encrypt bool) error {
 	}
 
 	// Create repository info
+	id, err := newRepoID()
+	if err != nil {
+		return err
+	}
 	info := models.RepositoryInfo{
 		Version:   1,
 		Encrypted: encrypt,
+		ID:        id,
 	}
 
 	infoData, err := json.MarshalIndent(info, "", "  ")
@@ -79,3 +86,56 @@ func initRepository(path string, encrypt bool) error {
 
 	return nil
 }
+
+// newRepoID returns a random repository identity
+func newRepoID() (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", fmt.Errorf("failed to generate repository ID: %w", err)
+	}
+	return hex.EncodeToString(id), nil
+}
+
+// loadRepoInfo reads the repository's repo.json
+func loadRepoInfo(repoPath string) (*models.RepositoryInfo, error) {
+	data, err := os.ReadFile(filepath.Join(repoPath, "repo.json"))
+	if err != nil {
+		return nil, fmt.Errorf("not a SnapSync repository: %w", err)
+	}
+	var info models.RepositoryInfo
+	if err := json.Unmarshal(data, &info); err != nil {
+		return nil, fmt.Errorf("invalid repo info: %w", err)
+	}
+	return &info, nil
+}
+
+// saveRepoInfo writes the repository's repo.json
+func saveRepoInfo(repoPath string, info *models.RepositoryInfo) error {
+	data, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(repoPath, "repo.json"), data, 0644); err != nil {
+		return fmt.Errorf("failed to write repo info: %w", err)
+	}
+	return nil
+}
+
+// repoIdentity returns the repository's ID, giving one to a repository
+// created before repositories had IDs
+func repoIdentity(repoPath string) (string, error) {
+	info, err := loadRepoInfo(repoPath)
+	if err != nil {
+		return "", err
+	}
+	if info.ID != "" {
+		return info.ID, nil
+	}
+	if info.ID, err = newRepoID(); err != nil {
+		return "", err
+	}
+	if err := saveRepoInfo(repoPath, info); err != nil {
+		return "", err
+	}
+	return info.ID, nil
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/snapsync/snapsync/internal/k8s"
+	"github.com/snapsync/snapsync/pkg/models"
+	"github.com/spf13/cobra"
+)
+
+func k8sCmd() *cobra.Command {
+	var (
+		secretsDir string
+		podInfoDir string
+		webhook    string
+		events     bool
+		opts       models.BackupOptions
+	)
+
+	cmd := &cobra.Command{
+		Use:   "k8s [source]",
+		Short: "Back up a volume from inside a Kubernetes pod",
+		Long: `Runs a backup suited to a CronJob or sidecar container.
+
+The repository password is read from the "password" key of the mounted
+secret, and the repository path from its "repo" key when --repo and
+SNAPSYNC_REPO are unset. Pod name, namespace and labels from the downward
+API are recorded as snapshot tags. Completion status can be posted to a
+webhook and recorded as a Kubernetes Event on the pod.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runK8sBackup(args[0], secretsDir, podInfoDir, webhook, events, opts)
+		},
+	}
+
+	cmd.Flags().StringVar(&secretsDir, "secrets-dir", k8s.DefaultSecretsDir, "Directory where the SnapSync secret is mounted")
+	cmd.Flags().StringVar(&podInfoDir, "podinfo-dir", k8s.DefaultPodInfoDir, "Directory where the downward API volume is mounted")
+	cmd.Flags().StringVar(&webhook, "webhook", "", "URL to POST the completion status to")
+	cmd.Flags().BoolVar(&events, "events", false, "Record completion as a Kubernetes Event on the pod")
+	cmd.Flags().StringVarP(&opts.Description, "description", "d", "", "Snapshot description")
+	cmd.Flags().StringArrayVarP(&opts.ExcludePattern, "exclude", "x", nil, "Exclude patterns")
+	cmd.Flags().StringArrayVar(&opts.ExcludePresets, "exclude-preset", nil, "Built-in exclusion preset (repeatable)")
+	cmd.Flags().StringArrayVar(&opts.Tags, "tag", nil, "Additional snapshot tag (repeatable)")
+
+	return cmd
+}
+
+func runK8sBackup(source, secretsDir, podInfoDir, webhook string, events bool, opts models.BackupOptions) error {
+	startTime := time.Now()
+
+	// Resolve repository from flag, environment or secret
+	repo := repoPath
+	if repo == "" {
+		repo = os.Getenv("SNAPSYNC_REPO")
+	}
+	if repo == "" {
+		repo, _ = k8s.ReadSecret(secretsDir, "repo")
+	}
+	if repo == "" {
+		return fmt.Errorf("repository path required (use --repo, SNAPSYNC_REPO or a \"repo\" secret key)")
+	}
+
+	// Credentials come from the mounted secret
+	if passwordFile == "" {
+		secretPassword := filepath.Join(secretsDir, "password")
+		if _, err := os.Stat(secretPassword); err == nil {
+			passwordFile = secretPassword
+		}
+	}
+
+	pod, err := k8s.LoadPodInfo(podInfoDir)
+	if err != nil {
+		return err
+	}
+
+	opts.SourcePath = source
+	opts.RepoPath = repo
+	opts.Compress = true
+	opts.Tags = append(pod.Tags(), opts.Tags...)
+
+	snap, backupErr := runBackup(opts)
+
+	status := &k8s.Status{
+		Success:   backupErr == nil,
+		Source:    source,
+		Pod:       pod.Name,
+		Namespace: pod.Namespace,
+		Tags:      opts.Tags,
+		Duration:  time.Since(startTime).Round(time.Millisecond).String(),
+		Finished:  time.Now(),
+	}
+	if backupErr != nil {
+		status.Error = backupErr.Error()
+	} else {
+		status.SnapshotID = snap.ID
+		status.Files = snap.Tree.FileCount
+		status.TotalSize = snap.Stats.TotalSize
+		status.StoredSize = snap.Stats.StoredSize
+	}
+
+	// Reporting failures are logged; the backup result decides the exit code
+	if webhook != "" {
+		if err := k8s.PostWebhook(webhook, status); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to post webhook: %v\n", err)
+		}
+	}
+	if events {
+		if err := k8s.PostEvent(pod, status); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to record Kubernetes event: %v\n", err)
+		}
+	}
+
+	return backupErr
+}
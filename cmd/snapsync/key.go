@@ -0,0 +1,424 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/snapsync/snapsync/internal/crypto"
+	"github.com/spf13/cobra"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+func keyCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "key",
+		Short: "Manage repository keys",
+		Long: `Manages the passwords of an encrypted repository.
+
+Data is encrypted with a random master key. Each password unlocks a key slot
+holding a copy of that master key, so passwords can be added, removed and
+changed without re-encrypting anything. Repositories created before key slots
+derive their key from a single password; key add or key passwd converts them.`,
+	}
+
+	cmd.AddCommand(keyBenchmarkCmd())
+	cmd.AddCommand(keyHintCmd())
+	cmd.AddCommand(keyListCmd())
+	cmd.AddCommand(keyAddCmd())
+	cmd.AddCommand(keyRemoveCmd())
+	cmd.AddCommand(keyPasswdCmd())
+
+	return cmd
+}
+
+func keyBenchmarkCmd() *cobra.Command {
+	var (
+		target    time.Duration
+		kdfMemory string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "benchmark",
+		Short: "Recommend Argon2id settings for this machine",
+		Long: `Measures Argon2id key derivation on this machine and recommends the strongest
+settings that unlock the repository in about --target. Higher memory makes
+password guessing on GPUs expensive; more passes add time on top.
+
+The settings apply to repositories whose encryption header has not been
+written yet, i.e. before the first encrypted backup.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			maxMemory, err := parseSize(kdfMemory)
+			if err != nil {
+				return fmt.Errorf("invalid --kdf-memory: %w", err)
+			}
+			if maxMemory < 64<<20 || maxMemory > 64<<30 {
+				return fmt.Errorf("--kdf-memory must be between 64M and 64G")
+			}
+
+			fmt.Printf("Target: %s per unlock\n\n", target)
+			fmt.Printf("%10s  %6s  %8s  %10s\n", "MEMORY", "PASSES", "THREADS", "TIME")
+
+			best, trials := crypto.RecommendArgon2(target, uint32(maxMemory>>10))
+			for _, trial := range trials {
+				fmt.Printf("%10s  %6d  %8d  %10s\n",
+					formatBytes(int64(trial.Params.Memory)<<10),
+					trial.Params.Time,
+					trial.Params.Threads,
+					trial.Duration.Round(time.Millisecond),
+				)
+			}
+
+			fmt.Println("\nRecommended settings for config/snapsync.yaml:")
+			fmt.Println("  encryption:")
+			fmt.Printf("    argon2_time: %d\n", best.Time)
+			fmt.Printf("    argon2_memory: %d\n", best.Memory>>10)
+			fmt.Printf("    argon2_threads: %d\n", best.Threads)
+			return nil
+		},
+	}
+
+	cmd.Flags().DurationVar(&target, "target", time.Second, "Time one unlock may take")
+	cmd.Flags().StringVar(&kdfMemory, "kdf-memory", "1G", "Most memory to spend on key derivation")
+
+	return cmd
+}
+
+func keyHintCmd() *cobra.Command {
+	var clear bool
+
+	cmd := &cobra.Command{
+		Use:   "hint [text]",
+		Short: "Show or set the password hint",
+		Long: `Stores a reminder in the repository's encryption header that is shown when a
+wrong password is entered. The hint is stored unencrypted, so it must not
+give the password away. Setting or clearing it requires the password.`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if repoPath == "" {
+				return fmt.Errorf("repository path required (use --repo)")
+			}
+
+			if len(args) == 0 && !clear {
+				header, err := loadEncryptionHeader(repoPath)
+				if err != nil {
+					return err
+				}
+				if header == nil || header.Hint == "" {
+					fmt.Println("No password hint set")
+					return nil
+				}
+				fmt.Println(header.Hint)
+				return nil
+			}
+
+			cfg := loadRepoConfig(repoPath)
+
+			saltData, err := os.ReadFile(filepath.Join(repoPath, "config", "salt"))
+			if err != nil {
+				return fmt.Errorf("repository not encrypted or salt missing")
+			}
+			salt, _ := hex.DecodeString(string(saltData))
+
+			_, header, err := unlockRepo(repoPath, cfg.Encryption, "Enter repository password: ", salt)
+			if err != nil {
+				return err
+			}
+
+			header.Hint = ""
+			if len(args) > 0 {
+				header.Hint = args[0]
+			}
+			if err := saveEncryptionHeader(repoPath, header); err != nil {
+				return err
+			}
+
+			if header.Hint == "" {
+				fmt.Println("Password hint cleared")
+			} else {
+				fmt.Println("Password hint set")
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolVar(&clear, "clear", false, "Remove the password hint")
+
+	return cmd
+}
+
+func keyListCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "list",
+		Short: "List the key slots that can unlock the repository",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if repoPath == "" {
+				return fmt.Errorf("repository path required (use --repo)")
+			}
+
+			header, err := requireEncryptionHeader(repoPath)
+			if err != nil {
+				return err
+			}
+			if !header.HasKeySlots() {
+				fmt.Println("Single password: the key is derived from it directly")
+				fmt.Println("Use 'snapsync key add' or 'snapsync key passwd' to convert to key slots")
+				return nil
+			}
+
+			fmt.Printf("%-10s  %-20s  %-14s  %s\n", "ID", "CREATED", "KDF", "LABEL")
+			fmt.Println("------------------------------------------------------------")
+			for _, slot := range header.Keys {
+				fmt.Printf("%-10s  %-20s  %-14s  %s\n",
+					slot.ID,
+					slot.Created.Local().Format("2006-01-02 15:04:05"),
+					slot.KDF,
+					slot.Label,
+				)
+			}
+			return nil
+		},
+	}
+}
+
+func keyAddCmd() *cobra.Command {
+	var (
+		label           string
+		newPasswordFile string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "add",
+		Short: "Add a password that unlocks the repository",
+		Long: `Adds a key slot for a new password. The current password keeps working.
+Adding a key to a single-password repository converts it to key slots, with
+a slot labelled "original" for the current password.`,
+		Example: `  snapsync key add --repo /path/to/repo --label laptop
+  snapsync key add --repo /path/to/repo --password-file old.txt --new-password-file new.txt`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if repoPath == "" {
+				return fmt.Errorf("repository path required (use --repo)")
+			}
+
+			header, master, _, current, err := unlockKeys(repoPath)
+			if err != nil {
+				return err
+			}
+			password, err := readNewPassword(newPasswordFile)
+			if err != nil {
+				return err
+			}
+
+			converted := !header.HasKeySlots()
+			if converted {
+				// Only slots unlock a converted repository, so the current
+				// password needs one too
+				if _, err := header.AddKeySlot(master, current, "original"); err != nil {
+					return err
+				}
+			}
+			slot, err := header.AddKeySlot(master, password, label)
+			if err != nil {
+				return err
+			}
+			if err := saveEncryptionHeader(repoPath, header); err != nil {
+				return err
+			}
+
+			fmt.Printf("Added key %s\n", slot.ID)
+			if converted {
+				printConverted()
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&label, "label", "", "Label shown by key list")
+	cmd.Flags().StringVar(&newPasswordFile, "new-password-file", "", "Read the new password from a file")
+
+	return cmd
+}
+
+func keyRemoveCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "remove [key-id]",
+		Short: "Remove a password from the repository",
+		Long: `Removes a key slot, so its password no longer unlocks the repository. Any
+remaining password can remove any slot, including its own, but the last slot
+cannot be removed.
+
+Removing a password does not change the master key. Someone who copied the
+encryption header while the password was valid can still unlock that copy.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if repoPath == "" {
+				return fmt.Errorf("repository path required (use --repo)")
+			}
+
+			header, _, unlocked, _, err := unlockKeys(repoPath)
+			if err != nil {
+				return err
+			}
+			if !header.HasKeySlots() {
+				return fmt.Errorf("repository has a single password (use 'snapsync key passwd' to change it)")
+			}
+
+			removed, err := header.RemoveKeySlot(args[0])
+			if err != nil {
+				return err
+			}
+			if err := saveEncryptionHeader(repoPath, header); err != nil {
+				return err
+			}
+
+			fmt.Printf("Removed key %s\n", removed.ID)
+			if removed.ID == unlocked.ID {
+				fmt.Println("The password just entered no longer unlocks the repository")
+			}
+			return nil
+		},
+	}
+
+	return cmd
+}
+
+func keyPasswdCmd() *cobra.Command {
+	var newPasswordFile string
+
+	cmd := &cobra.Command{
+		Use:   "passwd",
+		Short: "Change a password",
+		Long: `Changes the password entered to a new one. Only that password's key slot is
+rewritten; other passwords and the data are left as they are. Changing the
+password of a single-password repository converts it to key slots.`,
+		Example: `  snapsync key passwd --repo /path/to/repo`,
+		Args:    cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if repoPath == "" {
+				return fmt.Errorf("repository path required (use --repo)")
+			}
+
+			header, master, slot, _, err := unlockKeys(repoPath)
+			if err != nil {
+				return err
+			}
+			password, err := readNewPassword(newPasswordFile)
+			if err != nil {
+				return err
+			}
+
+			converted := !header.HasKeySlots()
+			if converted {
+				slot, err = header.AddKeySlot(master, password, "")
+			} else {
+				err = slot.Rewrap(master, password)
+			}
+			if err != nil {
+				return err
+			}
+			if err := saveEncryptionHeader(repoPath, header); err != nil {
+				return err
+			}
+
+			fmt.Printf("Password of key %s changed\n", slot.ID)
+			if converted {
+				printConverted()
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&newPasswordFile, "new-password-file", "", "Read the new password from a file")
+
+	return cmd
+}
+
+// requireEncryptionHeader reads the encryption header, which exists once an
+// encrypted repository has been unlocked for the first time
+func requireEncryptionHeader(repoPath string) (*crypto.EncryptionHeader, error) {
+	header, err := loadEncryptionHeader(repoPath)
+	if err != nil {
+		return nil, err
+	}
+	if header == nil {
+		return nil, fmt.Errorf("repository has no encryption header (run an encrypted backup first)")
+	}
+	return header, nil
+}
+
+// unlockKeys asks for a current password and returns the encryption header,
+// the master key, the key slot the password opened (nil for single-password
+// repositories) and the password itself
+// Key changes always need a password, never a key cached by the agent.
+func unlockKeys(repoPath string) (*crypto.EncryptionHeader, []byte, *crypto.KeySlot, string, error) {
+	header, err := requireEncryptionHeader(repoPath)
+	if err != nil {
+		return nil, nil, nil, "", err
+	}
+	cfg := loadRepoConfig(repoPath)
+	if err := crypto.CheckPolicy(cfg.Encryption.Policy, header.Algorithm, header.KDF); err != nil {
+		return nil, nil, nil, "", fmt.Errorf("crypto policy violation: %w", err)
+	}
+
+	passphrase, err := promptPassword("Enter current password: ")
+	if err != nil {
+		return nil, nil, nil, "", fmt.Errorf("failed to read password: %w", err)
+	}
+	master, slot, err := header.Unlock(passphrase)
+	if err != nil {
+		return nil, nil, nil, "", unlockError(header, err)
+	}
+	return header, master, slot, passphrase, nil
+}
+
+// readNewPassword reads a new password from file, or asks for it twice
+func readNewPassword(file string) (string, error) {
+	var password string
+	switch {
+	case file != "":
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return "", err
+		}
+		password = strings.TrimSpace(string(data))
+	case terminal.IsTerminal(int(syscall.Stdin)):
+		fmt.Fprint(os.Stderr, "Enter new password: ")
+		first, err := terminal.ReadPassword(int(syscall.Stdin))
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprint(os.Stderr, "Repeat new password: ")
+		second, err := terminal.ReadPassword(int(syscall.Stdin))
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return "", err
+		}
+		if string(first) != string(second) {
+			return "", fmt.Errorf("passwords do not match")
+		}
+		password = string(first)
+	default:
+		return "", fmt.Errorf("new password required (use --new-password-file)")
+	}
+
+	if password == "" {
+		return "", fmt.Errorf("new password cannot be empty")
+	}
+	return password, nil
+}
+
+// printConverted explains what converting to key slots does not change
+func printConverted() {
+	fmt.Println()
+	fmt.Println("The repository now uses key slots. Its master key is still the key derived")
+	fmt.Println("from the original password, so anyone who knows that password and has a")
+	fmt.Println("copy of the repository salt can derive it. Repositories created with key")
+	fmt.Println("slots use a random master key.")
+}
@@ -0,0 +1,331 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// keychainAccount is the account name passwords are stored under
+const keychainAccount = "snapsync"
+
+// launchdOptions describes a scheduled backup job
+type launchdOptions struct {
+	Label       string
+	Source      string
+	Every       time.Duration
+	At          string // Daily start time, HH:MM
+	Daemon      bool   // System-wide LaunchDaemon instead of a LaunchAgent
+	Keychain    string // Keychain item holding the password
+	Caffeinate  bool   // Keep the machine awake while a backup runs
+	LowPriority bool   // Run throttled with low-priority I/O
+	Exclude     []string
+	Tags        []string
+}
+
+func installLaunchdCmd() *cobra.Command {
+	opts := launchdOptions{}
+	var printOnly, noLoad, storePassword bool
+
+	cmd := &cobra.Command{
+		Use:   "install-launchd [source]",
+		Short: "Schedule backups with launchd on macOS",
+		Long: `Writes a launchd job that backs up a source on a schedule and loads it.
+Without --daemon the job is a LaunchAgent that runs while you are logged in;
+with --daemon it is a LaunchDaemon that runs as root from boot.
+
+Backups run on an interval (--every) or daily at a time (--at). Runs missed
+while the Mac sleeps start after it wakes. By default caffeinate keeps the
+system from idle-sleeping during a backup.
+
+The repository password is read from the keychain item named by --keychain;
+--store-password prompts for it and saves it there first.`,
+		Example: `  snapsync install-launchd ~/Documents --repo /Volumes/Backup/repo --at 02:30 --keychain snapsync-docs --store-password
+  sudo snapsync install-launchd /Users --repo /Volumes/Backup/repo --daemon --every 6h
+  snapsync install-launchd ~/Documents --repo /Volumes/Backup/repo --print`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if repoPath == "" {
+				return fmt.Errorf("repository path required (use --repo)")
+			}
+			if opts.At != "" && cmd.Flags().Changed("every") {
+				return fmt.Errorf("--at and --every are mutually exclusive")
+			}
+			if opts.At == "" && opts.Every < time.Minute {
+				return fmt.Errorf("--every must be at least 1m")
+			}
+			if storePassword && opts.Keychain == "" {
+				return fmt.Errorf("--store-password requires --keychain")
+			}
+
+			var err error
+			if opts.Source, err = filepath.Abs(args[0]); err != nil {
+				return fmt.Errorf("invalid source path: %w", err)
+			}
+
+			plist, err := launchdPlist(opts)
+			if err != nil {
+				return err
+			}
+			if printOnly {
+				fmt.Print(plist)
+				return nil
+			}
+
+			if storePassword {
+				if err := storeKeychainPassword(opts.Keychain, opts.Daemon); err != nil {
+					return err
+				}
+			}
+
+			path, err := launchdPlistPath(opts)
+			if err != nil {
+				return err
+			}
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				return fmt.Errorf("failed to create %s: %w", filepath.Dir(path), err)
+			}
+			if err := os.WriteFile(path, []byte(plist), 0644); err != nil {
+				return fmt.Errorf("failed to write %s: %w", path, err)
+			}
+			fmt.Printf("Wrote %s\n", path)
+
+			// launchd does not create the directory for the job's output
+			logPath, err := launchdLogPath(opts)
+			if err != nil {
+				return err
+			}
+			if err := os.MkdirAll(filepath.Dir(logPath), 0755); err != nil {
+				return fmt.Errorf("failed to create %s: %w", filepath.Dir(logPath), err)
+			}
+
+			if noLoad {
+				return nil
+			}
+
+			// Reloading picks up changes to an existing job
+			exec.Command("launchctl", "unload", path).Run()
+			if out, err := exec.Command("launchctl", "load", "-w", path).CombinedOutput(); err != nil {
+				return fmt.Errorf("failed to load job: %v: %s", err, strings.TrimSpace(string(out)))
+			}
+			fmt.Printf("Loaded %s\n", opts.Label)
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&opts.Label, "label", "com.snapsync.backup", "Job label, also the plist name")
+	cmd.Flags().DurationVar(&opts.Every, "every", 24*time.Hour, "Interval between backups")
+	cmd.Flags().StringVar(&opts.At, "at", "", "Run daily at this time (HH:MM) instead of on an interval")
+	cmd.Flags().BoolVar(&opts.Daemon, "daemon", false, "Install a system-wide LaunchDaemon (requires root)")
+	cmd.Flags().StringVar(&opts.Keychain, "keychain", "", "Keychain item holding the repository password")
+	cmd.Flags().BoolVar(&storePassword, "store-password", false, "Prompt for the password and save it in the keychain")
+	cmd.Flags().BoolVar(&opts.Caffeinate, "caffeinate", true, "Prevent idle sleep while a backup runs")
+	cmd.Flags().BoolVar(&opts.LowPriority, "low-priority", false, "Run as a throttled background job with low-priority I/O")
+	cmd.Flags().StringArrayVarP(&opts.Exclude, "exclude", "x", nil, "Exclude patterns")
+	cmd.Flags().StringArrayVar(&opts.Tags, "tag", nil, "Tag to record on each snapshot (repeatable)")
+	cmd.Flags().BoolVar(&printOnly, "print", false, "Print the plist instead of installing it")
+	cmd.Flags().BoolVar(&noLoad, "no-load", false, "Write the plist without loading it")
+
+	return cmd
+}
+
+// launchdPlistPath returns where the job's plist is installed
+func launchdPlistPath(opts launchdOptions) (string, error) {
+	if opts.Daemon {
+		return filepath.Join("/Library/LaunchDaemons", opts.Label+".plist"), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to find home directory: %w", err)
+	}
+	return filepath.Join(home, "Library", "LaunchAgents", opts.Label+".plist"), nil
+}
+
+// launchdLogPath returns where the job's output is written
+func launchdLogPath(opts launchdOptions) (string, error) {
+	if opts.Daemon {
+		return filepath.Join("/Library/Logs/snapsync", opts.Label+".log"), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to find home directory: %w", err)
+	}
+	return filepath.Join(home, "Library", "Logs", "snapsync", opts.Label+".log"), nil
+}
+
+var plistTemplate = template.Must(template.New("plist").Funcs(template.FuncMap{
+	"esc": func(s string) (string, error) {
+		var buf bytes.Buffer
+		err := xml.EscapeText(&buf, []byte(s))
+		return buf.String(), err
+	},
+}).Parse(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>Label</key>
+	<string>{{esc .Label}}</string>
+	<key>ProgramArguments</key>
+	<array>
+{{- range .Args}}
+		<string>{{esc .}}</string>
+{{- end}}
+	</array>
+{{- if .Env}}
+	<key>EnvironmentVariables</key>
+	<dict>
+{{- range $k, $v := .Env}}
+		<key>{{esc $k}}</key>
+		<string>{{esc $v}}</string>
+{{- end}}
+	</dict>
+{{- end}}
+{{- if .Daily}}
+	<key>StartCalendarInterval</key>
+	<dict>
+		<key>Hour</key>
+		<integer>{{.Hour}}</integer>
+		<key>Minute</key>
+		<integer>{{.Minute}}</integer>
+	</dict>
+{{- else}}
+	<key>StartInterval</key>
+	<integer>{{.Interval}}</integer>
+{{- end}}
+	<key>ProcessType</key>
+	<string>{{.ProcessType}}</string>
+{{- if .LowPriority}}
+	<key>LowPriorityIO</key>
+	<true/>
+	<key>Nice</key>
+	<integer>10</integer>
+{{- end}}
+	<key>StandardOutPath</key>
+	<string>{{esc .Log}}</string>
+	<key>StandardErrorPath</key>
+	<string>{{esc .Log}}</string>
+</dict>
+</plist>
+`))
+
+// launchdPlist renders the job definition
+func launchdPlist(opts launchdOptions) (string, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return "", fmt.Errorf("failed to locate executable: %w", err)
+	}
+	repo, err := filepath.Abs(repoPath)
+	if err != nil {
+		return "", fmt.Errorf("invalid repository path: %w", err)
+	}
+	logPath, err := launchdLogPath(opts)
+	if err != nil {
+		return "", err
+	}
+
+	var args []string
+	if opts.Caffeinate {
+		// -i holds off idle sleep only while the backup runs
+		args = append(args, "/usr/bin/caffeinate", "-i")
+	}
+	args = append(args, exe, "backup", opts.Source, "--repo", repo,
+		"--description", "scheduled backup")
+	if passwordFile != "" {
+		pw, err := filepath.Abs(passwordFile)
+		if err != nil {
+			return "", fmt.Errorf("invalid password file: %w", err)
+		}
+		args = append(args, "--password-file", pw)
+	}
+	if maxMemory != "" {
+		args = append(args, "--max-memory", maxMemory)
+	}
+	for _, pattern := range opts.Exclude {
+		args = append(args, "--exclude", pattern)
+	}
+	for _, tag := range opts.Tags {
+		args = append(args, "--tag", tag)
+	}
+
+	data := struct {
+		Label, ProcessType, Log string
+		Args                    []string
+		Env                     map[string]string
+		Interval, Hour, Minute  int
+		Daily, LowPriority      bool
+	}{
+		Label:       opts.Label,
+		ProcessType: "Standard",
+		Log:         logPath,
+		Args:        args,
+		Interval:    int(opts.Every.Seconds()),
+		LowPriority: opts.LowPriority,
+	}
+
+	// Background jobs are throttled by App Nap and run with reduced CPU
+	if opts.LowPriority {
+		data.ProcessType = "Background"
+	}
+	if opts.Keychain != "" {
+		data.Env = map[string]string{"SNAPSYNC_KEYCHAIN": opts.Keychain}
+	}
+	if opts.At != "" {
+		at, err := time.Parse("15:04", opts.At)
+		if err != nil {
+			return "", fmt.Errorf("invalid --at %q: expected HH:MM", opts.At)
+		}
+		data.Daily, data.Hour, data.Minute = true, at.Hour(), at.Minute()
+	}
+
+	var buf bytes.Buffer
+	if err := plistTemplate.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// storeKeychainPassword prompts for the repository password and saves it as
+// a generic keychain item, in the System keychain for daemons
+func storeKeychainPassword(service string, system bool) error {
+	password, err := promptPassword("Enter repository password: ")
+	if err != nil {
+		return err
+	}
+
+	// Commands piped to security -i keep the password out of the process list
+	command := fmt.Sprintf("add-generic-password -U -s %s -a %s -w %s",
+		securityQuote(service), keychainAccount, securityQuote(password))
+	if system {
+		command += " /Library/Keychains/System.keychain"
+	}
+
+	cmd := exec.Command("security", "-i")
+	cmd.Stdin = strings.NewReader(command + "\n")
+	if out, err := cmd.CombinedOutput(); err != nil || len(bytes.TrimSpace(out)) > 0 {
+		return fmt.Errorf("failed to store password in keychain: %v %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// keychainPassword reads the password saved by storeKeychainPassword
+func keychainPassword(service string) (string, error) {
+	out, err := exec.Command("security", "find-generic-password",
+		"-s", service, "-a", keychainAccount, "-w").Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to read password from keychain item %s: %w", service, err)
+	}
+	return strings.TrimRight(string(out), "\n"), nil
+}
+
+// securityQuote quotes an argument for the security tool's interactive mode
+func securityQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
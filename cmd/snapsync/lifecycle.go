@@ -0,0 +1,173 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/snapsync/snapsync/internal/lifecycle"
+	"github.com/spf13/cobra"
+)
+
+func lifecycleCmd() *cobra.Command {
+	var (
+		format string
+		bucket string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "lifecycle",
+		Short: "Recommend lifecycle rules for the cloud bucket",
+		Long: `Measures how the repository writes and keeps data and recommends lifecycle
+rules for the S3 bucket it is uploaded to: when chunk and tree objects under
+objects/ move to STANDARD_IA and on to GLACIER_IR, when unfinished multipart
+uploads are aborted, and, with Object Lock, when versions prune deleted are
+removed once their lock runs out.
+
+A move is recommended when the retention rules keep data long enough to pay
+for the storage class's minimum stay and, once the repository is old enough
+to tell, when enough of the referenced data has in fact lived that long.
+Objects under 128 KB, which infrequent-access classes bill as 128 KB, stay
+in STANDARD, as do snapshot records. Current objects are never expired by a
+rule; prune deletes them when no snapshot references them.
+
+The output is the reasoning (text), a configuration for
+aws s3api put-bucket-lifecycle-configuration (json), or an
+aws_s3_bucket_lifecycle_configuration resource (terraform). Nothing is
+changed in the bucket.`,
+		Example: `  snapsync lifecycle --repo /path/to/repo
+  snapsync lifecycle --format json --repo /path/to/repo > lifecycle.json
+  snapsync lifecycle --format terraform --bucket my-backups --repo /path/to/repo`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if repoPath == "" {
+				return fmt.Errorf("repository path required (use --repo)")
+			}
+
+			switch format {
+			case "text", "json", "terraform":
+			default:
+				return fmt.Errorf("unknown format %q (use text, json or terraform)", format)
+			}
+			return runLifecycle(repoPath, format, bucket)
+		},
+	}
+
+	cmd.Flags().StringVar(&format, "format", "text", "Output format: text, json or terraform")
+	cmd.Flags().StringVar(&bucket, "bucket", "", "Bucket to write the rules for (default cloud.bucket)")
+
+	return cmd
+}
+
+func runLifecycle(repoPath, format, bucket string) error {
+	cfg := loadRepoConfig(repoPath)
+	if cfg.Cloud.Enabled && cfg.Cloud.Provider != "" && cfg.Cloud.Provider != "s3" {
+		return fmt.Errorf("lifecycle rules are for S3 buckets, but cloud.provider is %s", cfg.Cloud.Provider)
+	}
+	if bucket == "" {
+		bucket = cfg.Cloud.Bucket
+	}
+	if format == "terraform" && bucket == "" {
+		return fmt.Errorf("no bucket configured (use --bucket)")
+	}
+
+	tiers, err := retentionTiers(cfg.Retention)
+	if err != nil {
+		return err
+	}
+
+	mgr, err := openStatsRepo(repoPath)
+	if err != nil {
+		return err
+	}
+	profile, err := mgr.StorageProfile(lifecycle.SmallObjectSize)
+	if err != nil {
+		return fmt.Errorf("failed to measure repository: %w", err)
+	}
+
+	// Without two backups of a source to go by, assume they run daily
+	interval := profile.BackupInterval
+	if interval == 0 {
+		interval = 24 * time.Hour
+	}
+	horizon, bounded := tiers.Horizon(interval)
+
+	advice := lifecycle.Advise(lifecycle.Input{
+		Profile:   profile,
+		Horizon:   horizon,
+		Unbounded: !bounded,
+		DataClass: cfg.Cloud.DataStorageClass,
+		LockMode:  cfg.Cloud.ObjectLockMode,
+		LockDays:  cfg.Cloud.ObjectLockDays,
+		Immutable: cfg.Cloud.Immutable,
+	})
+
+	switch format {
+	case "json":
+		output, err := advice.JSON()
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(output))
+		return nil
+	case "terraform":
+		fmt.Print(advice.Terraform(bucket))
+		return nil
+	}
+
+	fmt.Println("Repository")
+	fmt.Printf("  Snapshots:        %d", profile.Snapshots)
+	if profile.Snapshots > 0 {
+		fmt.Printf(" (%s to %s)", profile.Oldest.Format("2006-01-02"), profile.Newest.Format("2006-01-02"))
+	}
+	fmt.Println()
+	if profile.BackupInterval > 0 {
+		fmt.Printf("  Backup interval:  %s\n", profile.BackupInterval.Round(time.Minute))
+	}
+	fmt.Printf("  Referenced data:  %s in %d objects\n", formatBytes(profile.Size), profile.Objects)
+	for _, band := range profile.Ages {
+		fmt.Printf("  Older than %3dd:  %s in %d objects\n", band.Days, formatBytes(band.Size), band.Objects)
+	}
+
+	fmt.Println()
+	fmt.Println("Reasoning")
+	for _, note := range advice.Notes {
+		fmt.Printf("  - %s\n", note)
+	}
+
+	fmt.Println()
+	if bucket == "" {
+		bucket = "(no bucket configured)"
+	}
+	fmt.Printf("Rules for %s\n", bucket)
+	for _, rule := range advice.Rules {
+		fmt.Printf("  %s\n", rule.ID)
+		scope := rule.Prefix
+		if scope == "" {
+			scope = "whole bucket"
+		}
+		if rule.MinSize > 0 {
+			scope += fmt.Sprintf(", objects over %s", formatBytes(rule.MinSize))
+		}
+		fmt.Printf("    Applies to:     %s\n", scope)
+		if len(rule.Transitions) > 0 {
+			var moves []string
+			for _, t := range rule.Transitions {
+				moves = append(moves, fmt.Sprintf("%s after %d days", t.StorageClass, t.Days))
+			}
+			fmt.Printf("    Transitions:    %s\n", strings.Join(moves, ", "))
+		}
+		if rule.AbortDays > 0 {
+			fmt.Printf("    Abort uploads:  unfinished after %d days\n", rule.AbortDays)
+		}
+		if rule.NoncurrentDays > 0 {
+			fmt.Printf("    Old versions:   deleted %d days after they are replaced\n", rule.NoncurrentDays)
+		}
+		if rule.ExpiredDeleteMark {
+			fmt.Println("    Delete markers: removed once no versions remain")
+		}
+	}
+	fmt.Println()
+	fmt.Println("Apply with --format json or --format terraform.")
+	return nil
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"sort"
+	"strings"
 	"time"
 
 	"github.com/snapsync/snapsync/internal/snapshot"
@@ -19,8 +20,18 @@ func listCmd() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "list [snapshot-id]",
 		Short: "List snapshots or files in a snapshot",
-		Long:  "Lists all snapshots in the repository, or files in a specific snapshot.",
-		Args:  cobra.MaximumNArgs(1),
+		Long: `Lists all snapshots in the repository, or files in a specific snapshot.
+
+Commands that take a snapshot accept any of these references:
+
+  <id>          a full snapshot ID
+  <prefix>      the start of an ID, as long as it matches only one snapshot
+  latest        the newest snapshot; latest~1 is the one before it, and so on
+  oldest        the oldest snapshot
+  @<time>       the newest snapshot taken at or before a time: @2026-10-01
+                (the end of that day), @"2026-10-01 14:30", an RFC 3339 time,
+                or an age such as @12h, @3d or @2w`,
+		Args: cobra.MaximumNArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
 			if repoPath == "" {
 				return fmt.Errorf("repository path required (use --repo)")
@@ -47,7 +58,9 @@ func listSnapshots(repoPath string) error {
 		return fmt.Errorf("failed to open repository: %w", err)
 	}
 
-	snapshots, err := mgr.List()
+	// Records alone hold everything listed, and degraded snapshots whose
+	// trees cannot be read still show up
+	snapshots, err := mgr.ListRecords()
 	if err != nil {
 		return fmt.Errorf("failed to list snapshots: %w", err)
 	}
@@ -63,6 +76,15 @@ func listSnapshots(repoPath string) error {
 
 	for _, snap := range snapshots {
 		desc := snap.Description
+		if snap.Degraded != nil {
+			desc = "[degraded] " + desc
+		}
+		if snap.MetadataOnly {
+			desc = "[metadata] " + desc
+		}
+		if len(snap.PartialPaths) > 0 {
+			desc = "[partial] " + desc
+		}
 		if len(desc) > 30 {
 			desc = desc[:27] + "..."
 		}
@@ -86,19 +108,9 @@ func listSnapshotContents(repoPath, snapshotID string, showTree, showFiles bool,
 		return fmt.Errorf("failed to open repository: %w", err)
 	}
 
-	snap, err := mgr.Get(snapshotID)
+	snap, err := mgr.Resolve(snapshotID)
 	if err != nil {
-		// Try prefix match
-		snapshots, _ := mgr.List()
-		for _, s := range snapshots {
-			if len(snapshotID) >= 8 && len(s.ID) >= 8 && s.ID[:8] == snapshotID[:min(8, len(snapshotID))] {
-				snap = s
-				break
-			}
-		}
-		if snap == nil {
-			return fmt.Errorf("snapshot not found: %s", snapshotID)
-		}
+		return err
 	}
 
 	// Print snapshot info
@@ -107,6 +119,22 @@ func listSnapshotContents(repoPath, snapshotID string, showTree, showFiles bool,
 	if snap.Description != "" {
 		fmt.Printf("Desc:     %s\n", snap.Description)
 	}
+	if len(snap.Tags) > 0 {
+		fmt.Printf("Tags:     %s\n", strings.Join(snap.Tags, ", "))
+	}
+	if snap.ExpiresAt != nil {
+		fmt.Printf("Expires:  %s\n", snap.ExpiresAt.Format(time.RFC3339))
+	}
+	if d := snap.Degraded; d != nil {
+		fmt.Printf("Degraded: %d objects missing, %d files incomplete, %d directories lost (since %s)\n",
+			d.MissingObjects, d.Files, d.MissingTrees, d.Since.Format(time.RFC3339))
+	}
+	if snap.MetadataOnly {
+		fmt.Println("Contents: not stored (metadata-only snapshot)")
+	}
+	if len(snap.PartialPaths) > 0 {
+		fmt.Printf("Partial:  only %s\n", strings.Join(snap.PartialPaths, ", "))
+	}
 	fmt.Println()
 	fmt.Printf("Files:    %d\n", snap.Tree.FileCount)
 	fmt.Printf("Dirs:     %d\n", snap.Tree.DirCount)
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/snapsync/snapsync/internal/snapshot"
+	"github.com/spf13/cobra"
+)
+
+func lockCmd() *cobra.Command {
+	var until string
+
+	cmd := &cobra.Command{
+		Use:   "lock [snapshot-id]",
+		Short: "Lock a snapshot against deletion",
+		Long: `Places a compliance retention lock on a snapshot. Until the lock expires the
+snapshot cannot be deleted by any command. A lock can be extended but never
+shortened or removed.
+
+--until accepts a date (2031-01-01), an RFC 3339 time or a duration from now
+(90d, 6w, 7y, 36h).`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if repoPath == "" {
+				return fmt.Errorf("repository path required (use --repo)")
+			}
+			if until == "" {
+				return fmt.Errorf("retention time required (use --until)")
+			}
+
+			retainUntil, err := parseRetention(until, time.Now())
+			if err != nil {
+				return err
+			}
+
+			mgr, err := snapshot.NewManager(repoPath, nil, nil)
+			if err != nil {
+				return fmt.Errorf("failed to open repository: %w", err)
+			}
+
+			id, err := mgr.ResolveID(args[0])
+			if err != nil {
+				return err
+			}
+
+			if err := mgr.SetRetention(id, retainUntil); err != nil {
+				return fmt.Errorf("failed to lock snapshot: %w", err)
+			}
+
+			fmt.Printf("Snapshot %s retained until %s\n", id, retainUntil.Format(time.RFC3339))
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&until, "until", "", "Keep the snapshot until this date or for this duration")
+
+	return cmd
+}
+
+// parseRetention parses a retention time given as a date, an RFC 3339 time
+// or a duration from now with d, w or y units
+func parseRetention(s string, now time.Time) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", s, time.Local); err == nil {
+		return t, nil
+	}
+
+	if n := len(s); n > 1 {
+		if count, err := strconv.Atoi(s[:n-1]); err == nil && count > 0 {
+			switch s[n-1] {
+			case 'd':
+				return now.AddDate(0, 0, count), nil
+			case 'w':
+				return now.AddDate(0, 0, 7*count), nil
+			case 'y':
+				return now.AddDate(count, 0, 0), nil
+			}
+		}
+	}
+
+	if d, err := time.ParseDuration(s); err == nil && d > 0 {
+		return now.Add(d), nil
+	}
+
+	return time.Time{}, fmt.Errorf("invalid retention time: %q", s)
+}
@@ -3,7 +3,9 @@ package main
 import (
 	"fmt"
 	"os"
+	"runtime/debug"
 
+	"github.com/snapsync/snapsync/internal/compress"
 	"github.com/spf13/cobra"
 )
 
@@ -11,9 +13,14 @@ var (
 	version = "1.0.0"
 
 	// Global flags
-	repoPath   string
-	configPath string
-	verbose    bool
+	repoPath     string
+	configPath   string
+	passwordFile string
+	maxMemory    string
+	verbose      bool
+
+	// memoryLimit is maxMemory in bytes, 0 = unlimited
+	memoryLimit int64
 )
 
 func main() {
@@ -27,11 +34,16 @@ func main() {
   • S3-compatible cloud storage
   • Point-in-time recovery`,
 		Version: version,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			return applyMemoryLimit()
+		},
 	}
 
 	// Global flags
 	rootCmd.PersistentFlags().StringVarP(&repoPath, "repo", "r", "", "Repository path")
 	rootCmd.PersistentFlags().StringVarP(&configPath, "config", "c", "", "Config file path")
+	rootCmd.PersistentFlags().StringVar(&passwordFile, "password-file", "", "Read the repository password from a file")
+	rootCmd.PersistentFlags().StringVar(&maxMemory, "max-memory", "", "Memory budget, e.g. 512M or 1G (default unlimited)")
 	rootCmd.PersistentFlags().BoolVarP(&verbose, "verbose", "v", false, "Verbose output")
 
 	// Add commands
@@ -40,9 +52,69 @@ func main() {
 	rootCmd.AddCommand(restoreCmd())
 	rootCmd.AddCommand(listCmd())
 	rootCmd.AddCommand(statusCmd())
+	rootCmd.AddCommand(k8sCmd())
+	rootCmd.AddCommand(agentCmd())
+	rootCmd.AddCommand(lockCmd())
+	rootCmd.AddCommand(bundleCmd())
+	rootCmd.AddCommand(purgePathCmd())
+	rootCmd.AddCommand(chainCmd())
+	rootCmd.AddCommand(keyCmd())
+	rootCmd.AddCommand(versionsCmd())
+	rootCmd.AddCommand(catCmd())
+	rootCmd.AddCommand(topCmd())
+	rootCmd.AddCommand(serviceCmd())
+	rootCmd.AddCommand(installLaunchdCmd())
+	rootCmd.AddCommand(verifyCmd())
+	rootCmd.AddCommand(pruneCmd())
+	rootCmd.AddCommand(packCmd())
+	rootCmd.AddCommand(lifecycleCmd())
+	rootCmd.AddCommand(excludeTestCmd())
+	rootCmd.AddCommand(deletedCmd())
+	rootCmd.AddCommand(checkCmd())
+	rootCmd.AddCommand(diffCmd())
+	rootCmd.AddCommand(mountCmd())
+	rootCmd.AddCommand(tierCmd())
+	rootCmd.AddCommand(analyzeCmd())
+	rootCmd.AddCommand(findCmd())
+	rootCmd.AddCommand(benchCmd())
+	rootCmd.AddCommand(forgetCmd())
+	rootCmd.AddCommand(serveCmd())
+	rootCmd.AddCommand(exportCmd())
+	rootCmd.AddCommand(importCmd())
+	rootCmd.AddCommand(statsCmd())
+	rootCmd.AddCommand(repairCmd())
+	rootCmd.AddCommand(encryptRepoCmd())
+	rootCmd.AddCommand(copyCmd())
+	rootCmd.AddCommand(runCmd())
 
-	if err := rootCmd.Execute(); err != nil {
+	err := rootCmd.Execute()
+	closeRuntime()
+	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 		os.Exit(1)
 	}
 }
+
+// applyMemoryLimit parses --max-memory and sets the runtime's soft memory
+// limit so the garbage collector works to stay under the budget
+func applyMemoryLimit() error {
+	if maxMemory == "" {
+		return nil
+	}
+
+	limit, err := parseSize(maxMemory)
+	if err != nil {
+		return fmt.Errorf("invalid --max-memory: %w", err)
+	}
+	memoryLimit = limit
+	debug.SetMemoryLimit(limit)
+	return nil
+}
+
+// compressOptions returns compressor options suited to the memory budget
+func compressOptions() []compress.Option {
+	if memoryLimit > 0 {
+		return []compress.Option{compress.WithLowMemory()}
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+
+	"github.com/snapsync/snapsync/internal/compress"
+	"github.com/snapsync/snapsync/internal/mount"
+	"github.com/snapsync/snapsync/internal/restore"
+	"github.com/snapsync/snapsync/internal/snapshot"
+	"github.com/spf13/cobra"
+)
+
+func mountCmd() *cobra.Command {
+	var recoverTo string
+
+	cmd := &cobra.Command{
+		Use:   "mount [mountpoint]",
+		Short: "Browse snapshots as a read-only filesystem",
+		Long: `Mounts the repository with FUSE so every snapshot can be browsed as a
+directory, at snapshots/<id>/<path>. Files are read chunk by chunk as they
+are accessed, so copying out a single file does not restore the snapshot.
+
+The command runs until interrupted or until the filesystem is unmounted
+(fusermount -u on Linux, umount on macOS). Requires FUSE, or macFUSE on macOS.
+
+With --recover-to the mount also has restore/<id>/<path>, a copy of
+snapshots/ in which opening a file copies it to <dir>/<id>/<path>. Dragging
+files out of restore/ in a file manager therefore also leaves them in the
+recovery directory, with their permissions and modification times.`,
+		Example: `  snapsync mount /mnt/snapsync --repo /path/to/repo
+  cp /mnt/snapsync/snapshots/<id>/etc/hosts /tmp/hosts
+  snapsync mount /mnt/snapsync --repo /path/to/repo --recover-to ~/Recovered`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if repoPath == "" {
+				return fmt.Errorf("repository path required (use --repo)")
+			}
+
+			return runMount(repoPath, args[0], recoverTo)
+		},
+	}
+
+	cmd.Flags().StringVar(&recoverTo, "recover-to", "", "Copy files opened under restore/ into this directory")
+
+	return cmd
+}
+
+func runMount(repoPath, mountpoint, recoverTo string) error {
+	cfg := loadRepoConfig(repoPath)
+
+	var compressor *compress.Compressor
+	if cfg.Compression.Enabled {
+		var err error
+		compressor, err = compress.New(compress.AlgorithmZstd, cfg.Compression.Level, compressOptions()...)
+		if err != nil {
+			return fmt.Errorf("failed to create compressor: %w", err)
+		}
+		defer compressor.Close()
+	}
+
+	encryptor, err := openEncryptor(repoPath, cfg, "Enter repository password: ")
+	if err != nil {
+		return err
+	}
+
+	mgr, err := snapshot.NewManager(repoPath, compressor, encryptor)
+	if err != nil {
+		return fmt.Errorf("failed to open repository: %w", err)
+	}
+
+	restorer := restore.NewRestorer(mgr.CAS(), compressor, encryptor)
+	opts := mount.Options{}
+	if recoverTo != "" {
+		if opts.RecoverTo, err = filepath.Abs(recoverTo); err != nil {
+			return fmt.Errorf("invalid recovery directory: %w", err)
+		}
+		if err := os.MkdirAll(opts.RecoverTo, 0755); err != nil {
+			return fmt.Errorf("failed to create recovery directory: %w", err)
+		}
+		opts.OnRecover = func(path string, err error) {
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: failed to recover %s: %v\n", path, err)
+				return
+			}
+			fmt.Printf("Recovered %s\n", path)
+		}
+	}
+
+	server, err := mount.Mount(mountpoint, mgr, restorer, opts)
+	if err != nil {
+		return fmt.Errorf("failed to mount %s: %w", mountpoint, err)
+	}
+
+	// Unmount cleanly on Ctrl-C so the mountpoint is not left dangling
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sigs
+		if err := server.Unmount(); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to unmount: %v\n", err)
+		}
+	}()
+
+	fmt.Printf("Repository mounted at %s (Ctrl-C to unmount)\n", mountpoint)
+	if opts.RecoverTo != "" {
+		fmt.Printf("Files opened under %s are copied to %s\n", filepath.Join(mountpoint, "restore"), opts.RecoverTo)
+	}
+	server.Wait()
+	return nil
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/snapsync/snapsync/internal/backend"
+	"github.com/snapsync/snapsync/internal/config"
+)
+
+// lockRefreshWorkers is how many retention extensions are sent at once
+const lockRefreshWorkers = 8
+
+// objectLocks records until when each object in a bucket is known to be
+// locked, so reused objects are only relocked as their retention runs low
+type objectLocks struct {
+	path  string
+	until map[string]int64 // Unix seconds, by key
+}
+
+// loadObjectLocks reads the lock record of the configured bucket from the
+// runtime directory; a missing record is empty
+func loadObjectLocks(repoPath string, cloud config.CloudConfig) (*objectLocks, error) {
+	rt, err := openRuntime(repoPath)
+	if err != nil {
+		return nil, err
+	}
+	dir, err := rt.Sub("objectlock")
+	if err != nil {
+		return nil, err
+	}
+
+	locks := &objectLocks{
+		path:  filepath.Join(dir, cloud.Bucket+".json"),
+		until: make(map[string]int64),
+	}
+	data, err := os.ReadFile(locks.path)
+	if os.IsNotExist(err) {
+		return locks, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read object lock record: %w", err)
+	}
+	if err := json.Unmarshal(data, &locks.until); err != nil {
+		// Relocking everything once is the safe way to rebuild it
+		locks.until = make(map[string]int64)
+	}
+	return locks, nil
+}
+
+// save writes the lock record
+func (l *objectLocks) save() error {
+	data, err := json.Marshal(l.until)
+	if err != nil {
+		return err
+	}
+	tmp := l.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("failed to save object lock record: %w", err)
+	}
+	return os.Rename(tmp, l.path)
+}
+
+// refreshObjectLocks keeps a new snapshot's objects under Object Lock for
+// the configured number of days
+// Objects just uploaded were locked by the upload. Objects reused from
+// earlier backups keep the retention of their first upload, so those whose
+// lock ends within half the period are relocked; otherwise a snapshot could
+// depend on data whose protection ran out long ago.
+func refreshObjectLocks(repoPath string, cloud config.CloudConfig, remote backend.Backend, uploaded, reused []string) (int, error) {
+	if cloud.ObjectLockMode == "" {
+		return 0, nil
+	}
+	locker := retentionLocker(remote)
+	if locker == nil {
+		return 0, nil
+	}
+
+	locks, err := loadObjectLocks(repoPath, cloud)
+	if err != nil {
+		return 0, err
+	}
+
+	now := time.Now()
+	until := now.AddDate(0, 0, cloud.ObjectLockDays)
+	renewBefore := now.Add(until.Sub(now) / 2).Unix()
+	for _, key := range uploaded {
+		locks.until[key] = until.Unix()
+	}
+
+	var stale []string
+	for _, key := range reused {
+		if locks.until[key] < renewBefore {
+			stale = append(stale, key)
+		}
+	}
+
+	var (
+		mu       sync.Mutex
+		firstErr error
+		wg       sync.WaitGroup
+	)
+	keys := make(chan string)
+	for i := 0; i < lockRefreshWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for key := range keys {
+				err := locker.LockUntil(key, until)
+				mu.Lock()
+				if err == nil {
+					locks.until[key] = until.Unix()
+				} else if firstErr == nil {
+					firstErr = fmt.Errorf("failed to extend the lock of %s: %w", key, err)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, key := range stale {
+		keys <- key
+	}
+	close(keys)
+	wg.Wait()
+
+	// What was relocked is recorded even if some failed
+	if err := locks.save(); err != nil && firstErr == nil {
+		firstErr = err
+	}
+	return len(stale), firstErr
+}
+
+// retentionLocker returns the backend, or the one behind its cache, if it
+// supports Object Lock
+func retentionLocker(b backend.Backend) backend.RetentionLocker {
+	for {
+		if locker, ok := b.(backend.RetentionLocker); ok {
+			return locker
+		}
+		wrapper, ok := b.(interface{ Unwrap() backend.Backend })
+		if !ok {
+			return nil
+		}
+		b = wrapper.Unwrap()
+	}
+}
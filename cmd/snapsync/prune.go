@@ -0,0 +1,224 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/snapsync/snapsync/internal/backend"
+	"github.com/snapsync/snapsync/internal/config"
+	"github.com/snapsync/snapsync/internal/snapshot"
+	"github.com/spf13/cobra"
+)
+
+func pruneCmd() *cobra.Command {
+	var dryRun bool
+
+	cmd := &cobra.Command{
+		Use:   "prune",
+		Short: "Delete objects no snapshot references",
+		Long: `Runs a mark-and-sweep collection: walks every remaining snapshot to mark the
+chunks and tree objects still in use, then deletes all other objects from
+the repository and, when cloud storage is enabled, from the remote bucket.
+With --dry-run nothing is deleted; the statistics show what would be.
+The dry run also forecasts retention: it lists the snapshots that the keep
+rules in the configuration and expiry would remove (snapsync forget with
+--prune), the unique space each one frees on its own, and the total
+reclaimed once all of them and the data shared only among them are gone.
+
+Prune takes an exclusive lock on the repository: it fails while a backup is
+running, and backups started during a prune fail until it finishes.`,
+		Example: `  snapsync prune --repo /path/to/repo --dry-run
+  snapsync prune --repo /path/to/repo`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if repoPath == "" {
+				return fmt.Errorf("repository path required (use --repo)")
+			}
+
+			return runPrune(repoPath, dryRun)
+		},
+	}
+
+	cmd.Flags().BoolVarP(&dryRun, "dry-run", "n", false, "Report unreferenced objects without deleting them")
+
+	return cmd
+}
+
+func runPrune(repoPath string, dryRun bool) error {
+	cfg := loadRepoConfig(repoPath)
+
+	// Encrypted tree objects can only be walked with the key
+	encryptor, err := openEncryptor(repoPath, cfg, "Enter repository password: ")
+	if err != nil {
+		return err
+	}
+
+	mgr, err := snapshot.NewManager(repoPath, nil, encryptor)
+	if err != nil {
+		return fmt.Errorf("failed to open repository: %w", err)
+	}
+
+	if dryRun {
+		if err := printRetentionForecast(cfg, mgr); err != nil {
+			return err
+		}
+		fmt.Println()
+	}
+
+	return pruneRepository(repoPath, cfg, mgr, dryRun)
+}
+
+// printRetentionForecast lists the snapshots the configured keep rules and
+// expiry would remove, and the space removing them would reclaim
+func printRetentionForecast(cfg *config.Config, mgr *snapshot.Manager) error {
+	tiers, err := retentionTiers(cfg.Retention)
+	if err != nil {
+		return err
+	}
+
+	records, err := mgr.ListRecords()
+	if err != nil {
+		return fmt.Errorf("failed to list snapshots: %w", err)
+	}
+
+	now := time.Now()
+	groups, roots := sourceGroups(records, nil)
+	var remove []string
+	reasons := make(map[string]string)
+	sources := make(map[string]string)
+	locked := 0
+	for _, root := range roots {
+		removed := make(map[string]bool)
+		for _, snap := range tiers.Apply(groups[root], now).Remove {
+			removed[snap.ID] = true
+		}
+
+		for _, snap := range groups[root] {
+			if !removed[snap.ID] && !snap.Expired(now) {
+				continue
+			}
+			if snap.RetentionLocked(now) {
+				locked++
+				continue
+			}
+			reasons[snap.ID] = "keep rules"
+			if snap.Expired(now) {
+				reasons[snap.ID] = "expired"
+			}
+			sources[snap.ID] = root
+			remove = append(remove, snap.ID)
+		}
+	}
+
+	fmt.Println("Retention forecast")
+	if len(remove) == 0 {
+		fmt.Printf("  No snapshots would be removed (%d kept)\n", len(records))
+		if locked > 0 {
+			fmt.Printf("  Kept by locks:     %d\n", locked)
+		}
+		return nil
+	}
+
+	forecast, err := mgr.ForecastRemoval(remove)
+	if err != nil {
+		return fmt.Errorf("failed to forecast removal: %w", err)
+	}
+
+	fmt.Printf("  %-19s  %-19s  %-10s  %10s  %s\n", "SNAPSHOT", "TIMESTAMP", "REASON", "UNIQUE", "SOURCE")
+	for _, su := range forecast.Snapshots {
+		fmt.Printf("  %-19s  %-19s  %-10s  %10s  %s\n", su.ID, su.Timestamp.Format("2006-01-02 15:04:05"),
+			reasons[su.ID], formatBytes(su.UniqueSize), sources[su.ID])
+	}
+	fmt.Println()
+	fmt.Printf("  Snapshots removed: %d of %d\n", len(forecast.Snapshots), len(records))
+	if locked > 0 {
+		fmt.Printf("  Kept by locks:     %d\n", locked)
+	}
+	fmt.Printf("  Reclaimed:         %d objects (%s, of which %s shared only among removed snapshots)\n",
+		forecast.Objects, formatBytes(forecast.Size), formatBytes(forecast.SharedSize))
+	fmt.Printf("  With prune:        %s, adding data already unreferenced\n", formatBytes(forecast.Size+forecast.Garbage))
+	fmt.Println("  Run snapsync forget --prune to apply")
+	return nil
+}
+
+// pruneRepository deletes the objects no snapshot references, locally and
+// from the cloud bucket if one is configured, and prints what it removed
+func pruneRepository(repoPath string, cfg *config.Config, mgr *snapshot.Manager, dryRun bool) error {
+	if !dryRun {
+		lock, err := lockRepo(repoPath, "prune", true)
+		if err != nil {
+			return err
+		}
+		defer lock.Unlock()
+	}
+
+	stats, err := mgr.CollectGarbage(dryRun)
+	if err != nil {
+		return fmt.Errorf("failed to prune repository: %w", err)
+	}
+
+	outcome := "deleted"
+	if dryRun {
+		outcome = "would be deleted"
+		if verbose {
+			for _, hash := range stats.Swept {
+				fmt.Println(hash)
+			}
+		}
+		fmt.Printf("Prune dry run\n")
+	} else {
+		fmt.Printf("Prune completed\n")
+	}
+	fmt.Printf("  Objects scanned:   %d\n", stats.Scanned)
+	fmt.Printf("  Referenced:        %d (%s)\n", stats.Marked, formatBytes(stats.LiveBytes))
+	fmt.Printf("  Unreferenced:      %d (%s) %s\n", len(stats.Swept), formatBytes(stats.SweptBytes), outcome)
+	if stats.Skipped > 0 {
+		fmt.Printf("  Kept (new):        %d written during the run\n", stats.Skipped)
+	}
+	if stats.Repacked > 0 {
+		fmt.Printf("  Packs rewritten:   %d\n", stats.Repacked)
+	}
+	if missing := stats.Marked - (stats.Scanned - len(stats.Swept) - stats.Skipped); missing > 0 {
+		fmt.Printf("  Missing:           %d referenced objects not found\n", missing)
+	}
+	fmt.Printf("  Duration:          %s\n", stats.Duration.Round(time.Millisecond))
+
+	if !cfg.Cloud.Enabled {
+		return nil
+	}
+	if cfg.Cloud.Immutable {
+		fmt.Printf("  Remote:            skipped, the storage is immutable\n")
+		return nil
+	}
+
+	remote, err := openCloudBackend(repoPath, cfg)
+	if err != nil {
+		return err
+	}
+	defer remote.Close()
+
+	// Records of snapshots forgotten here go first, so no record left in
+	// the storage refers to the objects removed next; a damaged local
+	// record still keeps its remote copy
+	kept, err := mgr.SnapshotIDs()
+	if err != nil {
+		return err
+	}
+	stale, err := backend.RemoveStaleSnapshots(remote, kept, dryRun)
+	if err != nil {
+		return fmt.Errorf("failed to prune remote: %w", err)
+	}
+
+	live, err := mgr.LiveObjects()
+	if err != nil {
+		return err
+	}
+	objects, freed, err := backend.RemoveUnreferenced(remote, live, dryRun)
+	if err != nil {
+		return fmt.Errorf("failed to prune remote: %w", err)
+	}
+
+	fmt.Printf("  Remote:            %d (%s) %s\n", objects, formatBytes(freed), outcome)
+	fmt.Printf("  Remote records:    %d %s\n", stale, outcome)
+	return nil
+}
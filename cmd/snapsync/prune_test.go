@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/snapsync/snapsync/internal/backend"
+	"github.com/snapsync/snapsync/internal/config"
+	"github.com/snapsync/snapsync/internal/snapshot"
+	"github.com/snapsync/snapsync/pkg/models"
+)
+
+func TestForgetThenPruneRemote(t *testing.T) {
+	repo := filepath.Join(t.TempDir(), "repo")
+	remoteDir := t.TempDir()
+	src := t.TempDir()
+	captureStdout(t, func() error { return initRepository(repo, false) })
+	t.Cleanup(closeRuntime)
+
+	cfg := loadRepoConfig(repo)
+	cfg.Cloud.Enabled = true
+	cfg.Cloud.Provider = "local"
+	cfg.Cloud.Path = remoteDir
+	if err := cfg.Save(filepath.Join(repo, "config", "snapsync.yaml")); err != nil {
+		t.Fatal(err)
+	}
+
+	mgr, err := snapshot.NewManager(repo, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var snaps []*models.Snapshot
+	for _, content := range []string{"first version ", "second version "} {
+		// Large enough to be stored as a chunk rather than inline
+		writeFile(t, src, "data.txt", strings.Repeat(content, 100))
+		parent := ""
+		if len(snaps) > 0 {
+			parent = snaps[len(snaps)-1].ID
+		}
+		snap, err := mgr.Create(src, "", parent)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := uploadSnapshot(repo, cfg, mgr, snap); err != nil {
+			t.Fatal(err)
+		}
+		snaps = append(snaps, snap)
+	}
+	old, kept := snaps[0], snaps[1]
+	oldRefs, err := mgr.References(old)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keptRefs, err := mgr.References(kept)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	remote, err := backend.NewLocalBackend(remoteDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkRemote := func(when string) {
+		t.Helper()
+		records, err := remote.List(backend.SnapshotsPrefix)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := []string{backend.SnapshotKey(kept.ID)}; !reflect.DeepEqual(records, want) {
+			t.Errorf("%s: remote records %v, want %v", when, records, want)
+		}
+		for hash := range oldRefs {
+			exists, err := remote.Exists(backend.ObjectKey(hash))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if exists != keptRefs[hash] {
+				t.Errorf("%s: remote object %s exists = %v, want %v", when, hash[:12], exists, keptRefs[hash])
+			}
+		}
+	}
+
+	captureStdout(t, func() error {
+		return runForget(repo, forgetOptions{rules: config.KeepRules{KeepLast: 1}, prune: true})
+	})
+	checkRemote("after forget --prune")
+
+	// A record left behind, say by a forget that could not reach the
+	// storage, goes with the next prune
+	record := []byte(`{"id": "` + old.ID + `"}`)
+	if err := remote.Put(backend.SnapshotKey(old.ID), bytes.NewReader(record), int64(len(record))); err != nil {
+		t.Fatal(err)
+	}
+	captureStdout(t, func() error { return pruneRepository(repo, cfg, mgr, false) })
+	checkRemote("after prune")
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/snapsync/snapsync/internal/snapshot"
+	"github.com/spf13/cobra"
+)
+
+func purgePathCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "purge-path [pattern...]",
+		Short: "Remove matching files from every snapshot",
+		Long: `Rewrites every snapshot without the files matching the patterns, then deletes
+the chunks and tree objects no snapshot references any more. Use it when data
+must be removed from the whole backup history, such as leaked credentials or
+a GDPR erasure request.
+
+Patterns are relative to the backup root; ** matches any number of
+directories and a pattern without a slash matches file names at any depth.
+Snapshots under a retention lock that contain matching files stop the purge.`,
+		Example: `  snapsync purge-path 'secrets/**' --repo /path/to/repo`,
+		Args:    cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if repoPath == "" {
+				return fmt.Errorf("repository path required (use --repo)")
+			}
+
+			return runPurgePath(repoPath, args)
+		},
+	}
+
+	return cmd
+}
+
+func runPurgePath(repoPath string, patterns []string) error {
+	// Rewriting encrypted tree objects needs the key
+	encryptor, err := openEncryptor(repoPath, loadRepoConfig(repoPath), "Enter repository password: ")
+	if err != nil {
+		return err
+	}
+
+	mgr, err := snapshot.NewManager(repoPath, nil, encryptor)
+	if err != nil {
+		return fmt.Errorf("failed to open repository: %w", err)
+	}
+
+	snapshots, err := mgr.List()
+	if err != nil {
+		return fmt.Errorf("failed to list snapshots: %w", err)
+	}
+
+	match := func(relPath string) bool {
+		for _, pattern := range patterns {
+			if snapshot.MatchPath(pattern, relPath) {
+				return true
+			}
+		}
+		return false
+	}
+
+	total := 0
+	for _, snap := range snapshots {
+		removed, err := mgr.Purge(snap.ID, match)
+		if err != nil {
+			return fmt.Errorf("failed to purge snapshot %s: %w", snap.ID, err)
+		}
+		if removed > 0 {
+			fmt.Printf("Snapshot %s: removed %d entries\n", snap.ID, removed)
+			total += removed
+		}
+	}
+
+	if total == 0 {
+		fmt.Println("No matching files found")
+		return nil
+	}
+
+	objects, freed, err := mgr.RemoveUnreferenced()
+	if err != nil {
+		return fmt.Errorf("failed to remove unreferenced objects: %w", err)
+	}
+
+	fmt.Printf("\nPurge completed\n")
+	fmt.Printf("  Entries removed: %d\n", total)
+	fmt.Printf("  Objects deleted: %d (%s)\n", objects, formatBytes(freed))
+	return nil
+}
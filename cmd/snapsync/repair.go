@@ -0,0 +1,194 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/snapsync/snapsync/internal/chunker"
+	"github.com/snapsync/snapsync/internal/compress"
+	"github.com/snapsync/snapsync/internal/snapshot"
+	"github.com/spf13/cobra"
+)
+
+func repairCmd() *cobra.Command {
+	var (
+		opts       snapshot.RepairOptions
+		jsonOutput bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "repair",
+		Short: "Rebuild damaged snapshot metadata and mark lost data",
+		Long: `Brings a damaged repository back to a consistent state.
+
+Snapshot records that can no longer be parsed are rebuilt around their tree
+object when its hash can still be read from them; otherwise they are set
+aside as <id>.json.damaged. With --recover-orphans, root tree objects that
+no record points to become new snapshots named recovered-<hash>. This also
+brings back deleted snapshots whose data has not been pruned yet.
+
+Snapshots with missing chunks or tree objects are marked degraded, and list
+shows the mark. With --from-source, files with missing chunks are re-chunked
+from their live source and any chunk that still matches is stored again;
+data that changed since the backup is never stored in place of the original.
+A snapshot whose objects are all present again loses its mark.
+
+Finally the file index drops entries that use missing chunks and the filename
+index is rebuilt. Rebuilt and recovered records are outside the snapshot
+chain, so snapsync chain verify reports the break. Run with --dry-run first.`,
+		Example: `  snapsync repair --repo /path/to/repo --dry-run
+  snapsync repair --repo /path/to/repo --from-source
+  snapsync repair --repo /path/to/repo --recover-orphans`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if repoPath == "" {
+				return fmt.Errorf("repository path required (use --repo)")
+			}
+
+			// Lost data is reported in the output; usage would only bury it
+			cmd.SilenceUsage = true
+			return runRepair(repoPath, opts, jsonOutput)
+		},
+	}
+
+	cmd.Flags().BoolVar(&opts.DryRun, "dry-run", false, "Report the damage and what would be done without changing anything")
+	cmd.Flags().BoolVar(&opts.FromSource, "from-source", false, "Re-chunk files with missing chunks from their live source")
+	cmd.Flags().BoolVar(&opts.RecoverOrphans, "recover-orphans", false, "Create snapshots for tree objects no snapshot points to")
+	cmd.Flags().BoolVar(&jsonOutput, "json", false, "Output the result in JSON format")
+
+	return cmd
+}
+
+func runRepair(repoPath string, opts snapshot.RepairOptions, jsonOutput bool) error {
+	cfg := loadRepoConfig(repoPath)
+
+	var compressor *compress.Compressor
+	if cfg.Compression.Enabled {
+		var err error
+		compressor, err = compress.New(compress.AlgorithmZstd, cfg.Compression.Level, compressOptions()...)
+		if err != nil {
+			return fmt.Errorf("failed to create compressor: %w", err)
+		}
+		defer compressor.Close()
+	}
+
+	// Encrypted trees can only be walked, and chunks only stored, with the key
+	encryptor, err := openEncryptor(repoPath, cfg, "Enter repository password: ")
+	if err != nil {
+		return err
+	}
+
+	mgr, err := snapshot.NewManager(repoPath, compressor, encryptor)
+	if err != nil {
+		return fmt.Errorf("failed to open repository: %w", err)
+	}
+
+	// Chunks match the missing ones only if split the way the backup did
+	splitter, err := chunker.NewSplitter(cfg.Chunking.Algorithm, cfg.Chunking.MinSize,
+		cfg.Chunking.AvgSize, cfg.Chunking.MaxSize, cfg.Chunking.ImageProfile)
+	if err != nil {
+		return err
+	}
+	mgr.SetChunker(splitter)
+	mgr.SetPathIndex(cfg.Repository.PathIndex)
+
+	result, err := mgr.Repair(opts)
+	if err != nil {
+		return fmt.Errorf("repair failed: %w", err)
+	}
+
+	degraded := 0
+	for _, d := range result.Damaged {
+		if d.Degraded {
+			degraded++
+		}
+	}
+
+	if jsonOutput {
+		output, _ := json.MarshalIndent(result, "", "  ")
+		fmt.Println(string(output))
+	} else {
+		printRepair(result, opts)
+	}
+
+	if degraded > 0 {
+		return fmt.Errorf("%d snapshots are missing data that could not be restored", degraded)
+	}
+	return nil
+}
+
+// printRepair describes what repair found and changed
+func printRepair(result *snapshot.RepairResult, opts snapshot.RepairOptions) {
+	verb := func(done, planned string) string {
+		if opts.DryRun {
+			return planned
+		}
+		return done
+	}
+
+	if opts.DryRun {
+		fmt.Println("Dry run: nothing will be changed")
+		fmt.Println()
+	}
+
+	for _, id := range result.Salvaged {
+		fmt.Printf("  %-9s  snapshot %s: record %s from its tree\n", "record", id, verb("rebuilt", "would be rebuilt"))
+	}
+	for _, id := range result.SetAside {
+		fmt.Printf("  %-9s  snapshot %s: unreadable record %s as %s.json.damaged\n", "record", id, verb("set aside", "would be set aside"), id)
+	}
+	for _, id := range result.Recovered {
+		fmt.Printf("  %-9s  snapshot %s: %s from an orphaned tree\n", "recovered", id, verb("created", "would be created"))
+	}
+
+	for _, d := range result.Damaged {
+		for _, path := range d.Restored {
+			fmt.Printf("  %-9s  snapshot %s: %s %s from the source\n", "restored", d.ID, path, verb("restored", "can be restored"))
+		}
+		for _, path := range d.Files {
+			fmt.Printf("  %-9s  snapshot %s: %s is missing data\n", "degraded", d.ID, path)
+		}
+		if d.MissingTrees > 0 {
+			fmt.Printf("  %-9s  snapshot %s: %d directories cannot be read; their contents are lost\n", "degraded", d.ID, d.MissingTrees)
+		}
+		for _, msg := range d.SourceErrors {
+			fmt.Printf("  %-9s  snapshot %s: %s\n", "source", d.ID, msg)
+		}
+	}
+	for _, id := range result.Cleared {
+		fmt.Printf("  %-9s  snapshot %s: complete again, degraded mark %s\n", "cleared", id, verb("removed", "would be removed"))
+	}
+
+	degraded := 0
+	for _, d := range result.Damaged {
+		if d.Degraded {
+			degraded++
+		}
+	}
+
+	fmt.Println()
+	fmt.Printf("Snapshots:           %d\n", result.Snapshots)
+	fmt.Printf("Records rebuilt:     %d\n", len(result.Salvaged))
+	fmt.Printf("Records set aside:   %d\n", len(result.SetAside))
+	if opts.RecoverOrphans {
+		fmt.Printf("Recovered:           %d\n", len(result.Recovered))
+	}
+	fmt.Printf("Degraded snapshots:  %d\n", degraded)
+	if opts.FromSource {
+		fmt.Printf("Chunks restored:     %d\n", result.ChunksStored)
+	}
+	if !opts.DryRun {
+		fmt.Printf("Index entries:       %d dropped\n", result.IndexEntries)
+		if result.PathIndex != "" {
+			fmt.Printf("Filename index:      %s\n", result.PathIndex)
+		}
+	}
+	if len(result.SetAside) > 0 && !opts.RecoverOrphans {
+		fmt.Println()
+		fmt.Println("Records set aside no longer name their tree; --recover-orphans brings")
+		fmt.Println("their trees back as new snapshots.")
+	}
+	if degraded == 0 && len(result.Damaged) == 0 && len(result.Salvaged) == 0 && len(result.SetAside) == 0 {
+		fmt.Println("No damage found")
+	}
+}
@@ -1,10 +1,14 @@
 package main
 
 import (
+	"bufio"
 	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/snapsync/snapsync/internal/compress"
@@ -20,17 +24,50 @@ import (
 func restoreCmd() *cobra.Command {
 	var (
 		include      []string
+		includeFrom  string
 		exclude      []string
 		overwrite    bool
+		deleteExtra  bool
 		dryRun       bool
+		jsonOutput   bool
 		preservePerm bool
+		preHooks     []string
+		postHooks    []string
+		noHooks      bool
+		limits       restoreLimits
 	)
 
 	cmd := &cobra.Command{
 		Use:   "restore [snapshot-id] [target]",
 		Short: "Restore files from a snapshot",
-		Long:  "Restores files from a snapshot to the target directory.",
-		Args:  cobra.RangeArgs(1, 2),
+		Long: `Restores files from a snapshot to the target directory.
+Block device snapshots restore to a device or image file instead.
+
+Hooks run shell commands around the restore, from hooks.pre_restore and
+hooks.post_restore in the configuration and from --pre-hook and --post-hook.
+A failing pre-restore hook aborts the restore before anything is written.
+Post-restore hooks run even if the restore fails, so a stopped service is
+started again; if one fails the command exits non-zero. Hooks see
+SNAPSYNC_HOOK, SNAPSYNC_SNAPSHOT_ID and SNAPSYNC_TARGET, and post-restore
+hooks also SNAPSYNC_RESTORE_STATUS (success, partial or failed) and
+SNAPSYNC_FILES_RESTORED.
+
+With --delete, files and directories in the target that the snapshot does
+not contain are moved to .snapsync-trash/<time> in the target rather than
+removed; include and exclude patterns limit what counts as extra. --dry-run
+lists every path with what the restore would do to it (create, overwrite,
+skip or delete) and the data it would read from the repository.
+
+On a production host, --read-limit and --write-limit cap the bytes per
+second read from the repository (which may sit on a network share) and
+written to disk, and --low-priority runs the restore at the lowest CPU and
+I/O priority, so the services there keep their share. Defaults come from the
+restore section of the configuration. Hooks inherit the lowered priority.`,
+		Example: `  snapsync restore 17921759 /var/lib/app --repo /path/to/repo --overwrite \
+    --pre-hook "systemctl stop app" --post-hook "systemctl start app" --post-hook "/usr/local/bin/app-smoke-test"
+  snapsync restore 17921759 /srv/www --repo /path/to/repo --overwrite --delete --dry-run
+  snapsync restore latest /srv/data --repo /mnt/nfs/repo --read-limit 40MB --write-limit 20MB --low-priority`,
+		Args: cobra.RangeArgs(1, 2),
 		RunE: func(cmd *cobra.Command, args []string) error {
 			snapshotID := args[0]
 			targetPath := "."
@@ -42,30 +79,57 @@ func restoreCmd() *cobra.Command {
 				return fmt.Errorf("repository path required (use --repo)")
 			}
 
+			var includePaths []string
+			if includeFrom != "" {
+				paths, err := readPathList(includeFrom)
+				if err != nil {
+					return err
+				}
+				if len(paths) == 0 {
+					return fmt.Errorf("no paths listed in %s", includeFrom)
+				}
+				includePaths = paths
+			}
+
 			opts := models.RestoreOptions{
 				SnapshotID:     snapshotID,
 				TargetPath:     targetPath,
 				IncludePattern: include,
 				ExcludePattern: exclude,
+				IncludePaths:   includePaths,
 				Overwrite:      overwrite,
+				Delete:         deleteExtra,
 				PreservePerms:  preservePerm,
 				DryRun:         dryRun,
+				PreHooks:       preHooks,
+				PostHooks:      postHooks,
+			}
+
+			if jsonOutput && !dryRun {
+				return fmt.Errorf("--json requires --dry-run")
 			}
 
-			return runRestore(repoPath, opts)
+			return runRestore(repoPath, opts, limits, noHooks, jsonOutput)
 		},
 	}
 
 	cmd.Flags().StringArrayVarP(&include, "include", "i", nil, "Include patterns (glob)")
+	cmd.Flags().StringVar(&includeFrom, "include-from", "", "Restore the paths listed in a file, one per line or as find/diff --json output (- for stdin)")
 	cmd.Flags().StringArrayVarP(&exclude, "exclude", "x", nil, "Exclude patterns (glob)")
 	cmd.Flags().BoolVarP(&overwrite, "overwrite", "f", false, "Overwrite existing files")
-	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "Show what would be restored")
+	cmd.Flags().BoolVar(&deleteExtra, "delete", false, "Move target files the snapshot does not contain to the target's trash")
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "Show the restore plan without changing anything")
+	cmd.Flags().BoolVar(&jsonOutput, "json", false, "Output the dry-run plan in JSON format")
 	cmd.Flags().BoolVarP(&preservePerm, "preserve-perms", "p", true, "Preserve file permissions")
+	cmd.Flags().StringArrayVar(&preHooks, "pre-hook", nil, "Shell command to run before restoring (repeatable)")
+	cmd.Flags().StringArrayVar(&postHooks, "post-hook", nil, "Shell command to run after restoring (repeatable)")
+	cmd.Flags().BoolVar(&noHooks, "no-hooks", false, "Skip the hooks in the configuration")
+	addRestoreLimitFlags(cmd, &limits)
 
 	return cmd
 }
 
-func runRestore(repoPath string, opts models.RestoreOptions) error {
+func runRestore(repoPath string, opts models.RestoreOptions, limits restoreLimits, noHooks, jsonOutput bool) error {
 	startTime := time.Now()
 
 	// Resolve target path
@@ -82,10 +146,21 @@ func runRestore(repoPath string, opts models.RestoreOptions) error {
 		cfg = loadedCfg
 	}
 
+	// Configured hooks run before the ones given on the command line
+	if !noHooks {
+		opts.PreHooks = append(append([]string(nil), cfg.Hooks.PreRestore...), opts.PreHooks...)
+		opts.PostHooks = append(append([]string(nil), cfg.Hooks.PostRestore...), opts.PostHooks...)
+	}
+	if cfg.Hooks.Timeout != "" {
+		if opts.HookTimeout, err = time.ParseDuration(cfg.Hooks.Timeout); err != nil {
+			return fmt.Errorf("invalid hook timeout: %w", err)
+		}
+	}
+
 	// Setup compression
 	var compressor *compress.Compressor
 	if cfg.Compression.Enabled {
-		compressor, err = compress.New(compress.AlgorithmZstd, cfg.Compression.Level)
+		compressor, err = compress.New(compress.AlgorithmZstd, cfg.Compression.Level, compressOptions()...)
 		if err != nil {
 			return fmt.Errorf("failed to create compressor: %w", err)
 		}
@@ -95,11 +170,6 @@ func runRestore(repoPath string, opts models.RestoreOptions) error {
 	// Setup encryption
 	var encryptor *crypto.Encryptor
 	if cfg.Encryption.Enabled {
-		passphrase, err := promptPassword("Enter restore password: ")
-		if err != nil {
-			return fmt.Errorf("failed to read password: %w", err)
-		}
-
 		// Load salt
 		saltPath := filepath.Join(repoPath, "config", "salt")
 		saltData, err := os.ReadFile(saltPath)
@@ -108,9 +178,9 @@ func runRestore(repoPath string, opts models.RestoreOptions) error {
 		}
 		salt, _ := hex.DecodeString(string(saltData))
 
-		encryptor, err = crypto.NewEncryptor(passphrase, salt)
+		encryptor, _, err = unlockRepo(repoPath, cfg.Encryption, "Enter restore password: ", salt)
 		if err != nil {
-			return fmt.Errorf("failed to create encryptor: %w", err)
+			return err
 		}
 	}
 
@@ -120,18 +190,20 @@ func runRestore(repoPath string, opts models.RestoreOptions) error {
 		return fmt.Errorf("failed to open repository: %w", err)
 	}
 
-	snap, err := mgr.Get(opts.SnapshotID)
+	snap, err := mgr.Resolve(opts.SnapshotID)
 	if err != nil {
-		// Try to find by prefix
-		snapshots, _ := mgr.List()
-		for _, s := range snapshots {
-			if len(opts.SnapshotID) >= 8 && s.ID[:8] == opts.SnapshotID[:8] {
-				snap = s
-				break
+		return err
+	}
+
+	// Listed absolute paths are taken relative to the backup root
+	if root := snap.Tree.Root; root != nil {
+		for i, p := range opts.IncludePaths {
+			if !filepath.IsAbs(p) {
+				continue
+			}
+			if rel, err := filepath.Rel(root.Path, p); err == nil {
+				opts.IncludePaths[i] = rel
 			}
-		}
-		if snap == nil {
-			return fmt.Errorf("snapshot not found: %s", opts.SnapshotID)
 		}
 	}
 
@@ -143,10 +215,25 @@ func runRestore(repoPath string, opts models.RestoreOptions) error {
 
 	// Create restorer
 	restorer := restore.NewRestorer(cas, compressor, encryptor)
+	if err := limits.apply(cfg.Restore, restorer); err != nil {
+		return err
+	}
 
 	if opts.DryRun {
-		fmt.Println("Dry run - no files will be restored")
-		fmt.Println()
+		plan, err := restorer.Plan(snap, opts)
+		if err != nil {
+			return fmt.Errorf("failed to plan restore: %w", err)
+		}
+		if jsonOutput {
+			output, _ := json.MarshalIndent(plan, "", "  ")
+			fmt.Println(string(output))
+		} else {
+			printRestorePlan(snap, opts, plan)
+		}
+		if len(plan.Missing) > 0 {
+			return fmt.Errorf("%d listed paths not found in snapshot", len(plan.Missing))
+		}
+		return nil
 	}
 
 	// Perform restore
@@ -165,6 +252,12 @@ func runRestore(repoPath string, opts models.RestoreOptions) error {
 	fmt.Println("Restore complete!")
 	fmt.Printf("  Files restored: %d\n", result.FilesRestored)
 	fmt.Printf("  Bytes restored: %s\n", formatBytes(result.BytesRestored))
+	if result.FilesDeleted > 0 {
+		fmt.Printf("  Moved to trash: %d (%s)\n", result.FilesDeleted, result.Trash)
+	}
+	if result.AttrsDenied > 0 {
+		fmt.Printf("  Without attrs:  %d files (capabilities or immutable/append flags need root)\n", result.AttrsDenied)
+	}
 	fmt.Printf("  Duration:       %s\n", duration.Round(time.Millisecond))
 
 	if len(result.Errors) > 0 {
@@ -174,5 +267,183 @@ func runRestore(repoPath string, opts models.RestoreOptions) error {
 		}
 	}
 
+	// A script asking for exact paths must learn when some are missing
+	if len(result.Missing) > 0 {
+		fmt.Printf("\nNot in snapshot (%d):\n", len(result.Missing))
+		for _, p := range result.Missing {
+			fmt.Printf("  %s\n", p)
+		}
+	}
+
+	// The files are in place, but the application may not be running
+	if result.PostHookError != nil {
+		return fmt.Errorf("restore complete but %w", result.PostHookError)
+	}
+	if len(result.Missing) > 0 {
+		return fmt.Errorf("%d listed paths not found in snapshot", len(result.Missing))
+	}
+
 	return nil
 }
+
+// printRestorePlan lists what a restore would do to each path, then totals
+func printRestorePlan(snap *models.Snapshot, opts models.RestoreOptions, plan *restore.Plan) {
+	fmt.Printf("Restore plan for snapshot %s\n", snap.ID[:8])
+	fmt.Printf("  Created: %s\n", snap.Timestamp.Format(time.RFC3339))
+	fmt.Printf("  Target:  %s\n", opts.TargetPath)
+	fmt.Println()
+
+	if len(plan.Entries) > 0 {
+		fmt.Printf("  %-9s  %10s  %s\n", "ACTION", "SIZE", "PATH")
+		for _, entry := range plan.Entries {
+			path := entry.Path
+			if entry.IsDir {
+				path += string(filepath.Separator)
+			}
+			fmt.Printf("  %-9s  %10s  %s\n", entry.Action, formatBytes(entry.Size), path)
+		}
+		fmt.Println()
+	}
+
+	fmt.Printf("Create:      %d files (%s)\n", plan.Create, formatBytes(plan.CreateBytes))
+	fmt.Printf("Overwrite:   %d files (%s)\n", plan.Overwrite, formatBytes(plan.OverwriteBytes))
+	fmt.Printf("Skip:        %d files (already present; --overwrite replaces them)\n", plan.Skip)
+	if plan.Delete > 0 {
+		fmt.Printf("Delete:      %d paths (%s), moved to %s\n", plan.Delete, formatBytes(plan.DeleteBytes), plan.Trash)
+	} else if opts.Delete {
+		fmt.Println("Delete:      0 paths")
+	}
+	fmt.Printf("To download: %s in %d chunks\n", formatBytes(plan.Download), plan.Chunks)
+
+	if len(plan.Missing) > 0 {
+		fmt.Printf("\nNot in snapshot (%d):\n", len(plan.Missing))
+		for _, p := range plan.Missing {
+			fmt.Printf("  %s\n", p)
+		}
+	}
+}
+
+// readPathList reads the paths to restore from a file or stdin
+// Plain text lists one path per line; blank lines and # comments are
+// skipped. JSON input, such as the output of find or diff --json or JSON
+// lines with a "path" field, contributes the paths of its entries.
+func readPathList(name string) ([]string, error) {
+	var r io.Reader = os.Stdin
+	if name != "-" {
+		f, err := os.Open(name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open path list: %w", err)
+		}
+		defer f.Close()
+		r = f
+	}
+
+	br := bufio.NewReader(r)
+	first, err := firstByte(br)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read path list: %w", err)
+	}
+	if first == '[' || first == '{' {
+		return readJSONPaths(br)
+	}
+
+	var paths []string
+	scanner := bufio.NewScanner(br)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		paths = append(paths, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read path list: %w", err)
+	}
+
+	return paths, nil
+}
+
+// firstByte returns the first byte of r that is not white space, without
+// consuming it, or 0 if there is none
+func firstByte(r *bufio.Reader) (byte, error) {
+	for {
+		b, err := r.Peek(1)
+		if err == io.EOF {
+			return 0, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		switch b[0] {
+		case ' ', '\t', '\r', '\n':
+			r.ReadByte()
+		default:
+			return b[0], nil
+		}
+	}
+}
+
+// pathEntry is the part of a find or diff --json entry naming its path
+type pathEntry struct {
+	Path string `json:"path"`
+}
+
+// readJSONPaths reads a stream of JSON values: arrays of entries as find
+// --json prints them, diff reports with their changes, or single entries
+// Paths listed more than once, such as a file found in several snapshots,
+// are kept once.
+func readJSONPaths(r io.Reader) ([]string, error) {
+	var paths []string
+	seen := make(map[string]bool)
+	add := func(entries ...pathEntry) error {
+		for _, entry := range entries {
+			if entry.Path == "" {
+				return fmt.Errorf("invalid entry in path list: no path")
+			}
+			if !seen[entry.Path] {
+				seen[entry.Path] = true
+				paths = append(paths, entry.Path)
+			}
+		}
+		return nil
+	}
+
+	dec := json.NewDecoder(r)
+	for {
+		var value json.RawMessage
+		if err := dec.Decode(&value); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("invalid path list: %w", err)
+		}
+
+		if value[0] == '[' {
+			var entries []pathEntry
+			if err := json.Unmarshal(value, &entries); err != nil {
+				return nil, fmt.Errorf("invalid path list: %w", err)
+			}
+			if err := add(entries...); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		var object struct {
+			pathEntry
+			Changes *[]pathEntry `json:"changes"`
+		}
+		if err := json.Unmarshal(value, &object); err != nil {
+			return nil, fmt.Errorf("invalid path list: %w", err)
+		}
+		entries := []pathEntry{object.pathEntry}
+		if object.Changes != nil {
+			entries = *object.Changes
+		}
+		if err := add(entries...); err != nil {
+			return nil, err
+		}
+	}
+
+	return paths, nil
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/snapsync/snapsync/internal/snapshot"
+)
+
+// captureStdout returns what fn prints to stdout
+func captureStdout(t *testing.T, fn func() error) []byte {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+
+	out := make(chan []byte)
+	go func() {
+		data, _ := io.ReadAll(r)
+		out <- data
+	}()
+	err = fn()
+	w.Close()
+	data := <-out
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// writeFile writes a file under dir, creating its parent directories
+func writeFile(t *testing.T, dir, name, content string) {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// pathList writes data to a file and reads it back as a path list
+func pathList(t *testing.T, data []byte) []string {
+	t.Helper()
+	name := filepath.Join(t.TempDir(), "paths")
+	if err := os.WriteFile(name, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	paths, err := readPathList(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return paths
+}
+
+func TestReadPathListFromFindAndDiff(t *testing.T) {
+	repo := filepath.Join(t.TempDir(), "repo")
+	src := t.TempDir()
+	captureStdout(t, func() error { return initRepository(repo, false) })
+
+	writeFile(t, src, "a.txt", "first")
+	writeFile(t, src, "docs/b.txt", "second")
+	writeFile(t, src, "docs/c.md", "third")
+
+	mgr, err := snapshot.NewManager(repo, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, err := mgr.Create(src, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeFile(t, src, "a.txt", "first, changed")
+	writeFile(t, src, "docs/d.txt", "fourth")
+	if err := os.Remove(filepath.Join(src, "docs", "c.md")); err != nil {
+		t.Fatal(err)
+	}
+	second, err := mgr.Create(src, "", first.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Files found in both snapshots are listed once
+	found := captureStdout(t, func() error { return runFind(repo, "*.txt", false, true) })
+	want := []string{"a.txt", filepath.Join("docs", "b.txt"), filepath.Join("docs", "d.txt")}
+	if got := pathList(t, found); !reflect.DeepEqual(got, want) {
+		t.Errorf("find --json paths %q, want %q", got, want)
+	}
+
+	changes := captureStdout(t, func() error { return runDiff(repo, first.ID, second.ID, true) })
+	want = []string{"a.txt", filepath.Join("docs", "c.md"), filepath.Join("docs", "d.txt")}
+	if got := pathList(t, changes); !reflect.DeepEqual(got, want) {
+		t.Errorf("diff --json paths %q, want %q", got, want)
+	}
+}
+
+func TestReadPathList(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  []string
+	}{
+		{"plain lines", "# restore these\na.txt\n\ndocs/b.txt\r\n", []string{"a.txt", "docs/b.txt"}},
+		{"json lines", "{\"path\": \"a.txt\"}\n{\"path\": \"b.txt\"}\n", []string{"a.txt", "b.txt"}},
+		{"empty json array", "  []\n", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := pathList(t, []byte(tt.input)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("paths %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/snapsync/snapsync/internal/config"
+	"github.com/snapsync/snapsync/internal/crypto"
+	"github.com/snapsync/snapsync/internal/snapshot"
+	"github.com/snapsync/snapsync/pkg/models"
+	"github.com/spf13/cobra"
+)
+
+// runOptions holds the flags of the run command
+type runOptions struct {
+	plan    string
+	sources []string
+	force   bool
+	dryRun  bool
+}
+
+func runCmd() *cobra.Command {
+	var opts runOptions
+
+	cmd := &cobra.Command{
+		Use:   "run",
+		Short: "Run the backups of a backup plan",
+		Long: `Runs the backups a plan file describes for a whole machine: the repositories
+to write to, and for each source its path, target repositories, schedule,
+excludes, tags and retention.
+
+  repos:
+    local:
+      path: /backups/main
+      prune: true
+    offsite:
+      path: /mnt/usb/snapsync
+      password_file: /etc/snapsync/offsite.pw
+  sources:
+    - name: home
+      path: /home
+      repos: [local, offsite]
+      every: 6h
+      exclude: ["*.tmp"]
+      exclude_presets: [dev]
+      tags: [home]
+      retention:
+        keep_daily: 7
+        keep_weekly: 4
+    - name: etc
+      path: /etc
+      repos: [local]
+      every: 1d
+
+A source is backed up to a repository when its newest snapshot there is
+older than every, less a tenth for scheduling jitter, so run can be called
+from cron or a timer more often than any source is due. Sources without
+every are backed up on each run; --force backs up everything.
+
+After a source's backup its retention rules are applied to its snapshots in
+that repository, with the repository's retention tiers as in snapsync forget.
+Repositories with prune set are pruned once at the end if anything was
+deleted. A failing source does not stop the others; run exits with an error
+if any failed.`,
+		Example: `  snapsync run --plan /etc/snapsync/plan.yaml
+  snapsync run --plan plan.yaml --source home --force
+  snapsync run --plan plan.yaml --dry-run`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runPlan(opts)
+		},
+	}
+
+	cmd.Flags().StringVar(&opts.plan, "plan", "", "Backup plan file")
+	cmd.Flags().StringArrayVar(&opts.sources, "source", nil, "Only run this source (repeatable)")
+	cmd.Flags().BoolVar(&opts.force, "force", false, "Back up every source whether due or not")
+	cmd.Flags().BoolVarP(&opts.dryRun, "dry-run", "n", false, "Show which backups are due without running them")
+	cmd.MarkFlagRequired("plan")
+
+	return cmd
+}
+
+func runPlan(opts runOptions) error {
+	plan, err := config.LoadPlan(opts.plan)
+	if err != nil {
+		return err
+	}
+
+	sources, err := planSources(plan, opts.sources)
+	if err != nil {
+		return err
+	}
+
+	// The global password file is swapped per repository
+	defaultPasswordFile := passwordFile
+	defer func() { passwordFile = defaultPasswordFile }()
+
+	now := time.Now()
+	var ran, failed int
+	// Repositories to prune, in the order they were first written
+	var pruneRepos []string
+	pruned := make(map[string]bool)
+	for _, source := range sources {
+		sourcePath, err := filepath.Abs(source.Path)
+		if err != nil {
+			return fmt.Errorf("source %s: %w", source.Name, err)
+		}
+
+		for _, name := range source.Repos {
+			repo := plan.Repos[name]
+			passwordFile = defaultPasswordFile
+			if repo.PasswordFile != "" {
+				passwordFile = repo.PasswordFile
+			}
+
+			due, last, err := backupDue(repo.Path, sourcePath, source.Every, now)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %s -> %s: %v\n", source.Name, name, err)
+				failed++
+				continue
+			}
+			if !due && !opts.force {
+				fmt.Printf("%s -> %s: not due, last backup %s\n", source.Name, name, last.Format("2006-01-02 15:04:05"))
+				continue
+			}
+			if opts.dryRun {
+				fmt.Printf("%s -> %s: due\n", source.Name, name)
+				continue
+			}
+
+			fmt.Printf("%s -> %s\n", source.Name, name)
+			ran++
+			removed, err := runPlanSource(source, sourcePath, repo)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %s -> %s: %v\n", source.Name, name, err)
+				failed++
+			}
+			if removed > 0 && repo.Prune && !pruned[name] {
+				pruned[name] = true
+				pruneRepos = append(pruneRepos, name)
+			}
+			fmt.Println()
+		}
+	}
+
+	for _, name := range pruneRepos {
+		repo := plan.Repos[name]
+		passwordFile = defaultPasswordFile
+		if repo.PasswordFile != "" {
+			passwordFile = repo.PasswordFile
+		}
+
+		fmt.Printf("Pruning %s\n", name)
+		if err := runPrune(repo.Path, false); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: prune %s: %v\n", name, err)
+			failed++
+		}
+		fmt.Println()
+	}
+
+	if opts.dryRun {
+		return nil
+	}
+	fmt.Printf("Plan complete: %d backups run\n", ran)
+	if failed > 0 {
+		return fmt.Errorf("%d steps failed", failed)
+	}
+	return nil
+}
+
+// planSources returns the plan's sources, or the named ones in plan order
+func planSources(plan *config.Plan, names []string) ([]config.PlanSource, error) {
+	if len(names) == 0 {
+		return plan.Sources, nil
+	}
+
+	wanted := make(map[string]bool, len(names))
+	for _, name := range names {
+		wanted[name] = true
+	}
+	var sources []config.PlanSource
+	for _, source := range plan.Sources {
+		if wanted[source.Name] {
+			sources = append(sources, source)
+			delete(wanted, source.Name)
+		}
+	}
+	for name := range wanted {
+		return nil, fmt.Errorf("no source named %s in the plan", name)
+	}
+	return sources, nil
+}
+
+// backupDue reports whether a source needs a backup in a repository, and
+// when its newest snapshot there was taken
+func backupDue(repoPath, sourcePath, every string, now time.Time) (bool, time.Time, error) {
+	// An unmounted disk must not get a new repository in its mountpoint
+	if _, err := os.Stat(filepath.Join(repoPath, "repo.json")); err != nil {
+		return false, time.Time{}, fmt.Errorf("no repository at %s (run snapsync init)", repoPath)
+	}
+	if every == "" {
+		return true, time.Time{}, nil
+	}
+	next, err := parseRetention(every, now)
+	if err != nil {
+		return false, time.Time{}, fmt.Errorf("invalid every: %w", err)
+	}
+	interval := next.Sub(now)
+
+	mgr, err := openPlanRepo(repoPath)
+	if err != nil {
+		return false, time.Time{}, err
+	}
+	records, err := mgr.ListRecords()
+	if err != nil {
+		return false, time.Time{}, fmt.Errorf("failed to list snapshots: %w", err)
+	}
+
+	// Records are newest first
+	groups, _ := sourceGroups(records, nil)
+	snaps := groups[sourcePath]
+	if len(snaps) == 0 {
+		return true, time.Time{}, nil
+	}
+	last := snaps[0].Timestamp
+	return now.Sub(last) >= interval-interval/10, last, nil
+}
+
+// runPlanSource backs up one source to one repository and applies the
+// source's retention rules, returning the number of snapshots deleted
+func runPlanSource(source config.PlanSource, sourcePath string, repo config.PlanRepo) (int, error) {
+	description := source.Description
+	if description == "" {
+		description = "snapsync run: " + source.Name
+	}
+
+	_, err := runBackup(models.BackupOptions{
+		SourcePath:     sourcePath,
+		RepoPath:       repo.Path,
+		Description:    description,
+		ExcludePattern: source.Exclude,
+		ExcludePresets: source.ExcludePresets,
+		AppPresets:     source.AppPresets,
+		Tags:           source.Tags,
+		Compress:       !source.NoCompress,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	if source.Retention == (config.KeepRules{}) {
+		return 0, nil
+	}
+	return applyPlanRetention(repo.Path, sourcePath, source.Retention)
+}
+
+// applyPlanRetention deletes the snapshots of a source that its keep rules,
+// or the repository's tier rules, do not keep
+func applyPlanRetention(repoPath, sourcePath string, rules config.KeepRules) (int, error) {
+	cfg := loadRepoConfig(repoPath)
+	tiers, err := retentionTiers(config.RetentionConfig{KeepRules: rules, Tiers: cfg.Retention.Tiers})
+	if err != nil {
+		return 0, err
+	}
+
+	mgr, err := openPlanRepo(repoPath)
+	if err != nil {
+		return 0, err
+	}
+	records, err := mgr.ListRecords()
+	if err != nil {
+		return 0, fmt.Errorf("failed to list snapshots: %w", err)
+	}
+	groups, _ := sourceGroups(records, nil)
+
+	now := time.Now()
+	result := tiers.Apply(groups[sourcePath], now)
+	var deleted []string
+	for _, snap := range result.Remove {
+		if snap.RetentionLocked(now) {
+			continue
+		}
+		if err := mgr.Delete(snap.ID); err != nil {
+			forgetRemoteSnapshots(repoPath, cfg, deleted)
+			return len(deleted), fmt.Errorf("failed to delete snapshot %s: %w", snap.ID, err)
+		}
+		fmt.Printf("  Forgot %s from %s\n", snap.ID, snap.Timestamp.Format("2006-01-02 15:04:05"))
+		deleted = append(deleted, snap.ID)
+	}
+	forgetRemoteSnapshots(repoPath, cfg, deleted)
+	return len(deleted), nil
+}
+
+// openPlanRepo opens a repository to read snapshot records, unlocking it
+// when the source roots are encrypted with the names
+func openPlanRepo(repoPath string) (*snapshot.Manager, error) {
+	var encryptor *crypto.Encryptor
+	if namesEncrypted(repoPath) {
+		var err error
+		encryptor, err = openEncryptor(repoPath, loadRepoConfig(repoPath), "Enter repository password: ")
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	mgr, err := snapshot.NewManager(repoPath, nil, encryptor)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open repository: %w", err)
+	}
+	return mgr, nil
+}
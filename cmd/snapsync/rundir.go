@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/snapsync/snapsync/internal/rundir"
+)
+
+// runtimeDirs are the runtime directories this process has opened, by path
+var (
+	runtimeMu   sync.Mutex
+	runtimeDirs = make(map[string]*rundir.Dir)
+)
+
+// legacyRuntime maps runtime state kept in the repository by earlier
+// versions to its subdirectory of the runtime directory
+var legacyRuntime = map[string]string{
+	filepath.Join("index", "uploads"): "uploads",
+	"cache":                           "cache",
+}
+
+// openRuntime opens the runtime directory of a repository: the configured
+// repository.runtime_dir, relative to the repository, or <repo>/run
+// Opening it removes what crashed processes left behind, so it is done once
+// per process.
+func openRuntime(repoPath string) (*rundir.Dir, error) {
+	path := loadRepoConfig(repoPath).Repository.RuntimeDir
+	if path == "" {
+		path = "run"
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(repoPath, path)
+	}
+
+	runtimeMu.Lock()
+	defer runtimeMu.Unlock()
+	if dir, ok := runtimeDirs[path]; ok {
+		return dir, nil
+	}
+
+	dir, err := rundir.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	migrateRuntime(repoPath, dir)
+	runtimeDirs[path] = dir
+	return dir, nil
+}
+
+// migrateRuntime moves runtime state from where earlier versions kept it
+// State that cannot be moved, e.g. to another file system, is dropped: a
+// cache refills and an upload without its checkpoint starts over.
+func migrateRuntime(repoPath string, dir *rundir.Dir) {
+	for old, sub := range legacyRuntime {
+		oldPath := filepath.Join(repoPath, old)
+		if _, err := os.Stat(oldPath); err != nil {
+			continue
+		}
+		newPath := filepath.Join(dir.Path(), sub)
+		if _, err := os.Stat(newPath); os.IsNotExist(err) && os.Rename(oldPath, newPath) == nil {
+			continue
+		}
+		if err := os.RemoveAll(oldPath); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to remove %s: %v\n", oldPath, err)
+		}
+	}
+}
+
+// lockRepo takes a lock on a repository for the rest of an operation
+func lockRepo(repoPath, kind string, exclusive bool) (*rundir.Lock, error) {
+	dir, err := openRuntime(repoPath)
+	if err != nil {
+		return nil, err
+	}
+	return dir.Lock(kind, exclusive)
+}
+
+// closeRuntime removes this process's temporary files from every runtime
+// directory it opened
+func closeRuntime() {
+	runtimeMu.Lock()
+	defer runtimeMu.Unlock()
+	for _, dir := range runtimeDirs {
+		dir.Close()
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/snapsync/snapsync/internal/server"
+	"github.com/snapsync/snapsync/internal/snapshot"
+	"github.com/spf13/cobra"
+)
+
+func serveCmd() *cobra.Command {
+	var (
+		listen      string
+		tokenFile   string
+		cacheTTL    time.Duration
+		allowDelete bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "serve",
+		Short: "Serve the repository over HTTP",
+		Long: `Runs an HTTP API for the repository, so monitoring tools can poll its
+statistics and clients can store backups in it with the http cloud provider.
+Endpoints:
+
+  GET /v1/stats                    object counts, stored and logical size,
+                                   the space each snapshot holds on its own,
+                                   and the latest check and verify results
+  GET, HEAD, PUT, DELETE
+    /v1/objects/<xx>/<hash>        one stored object
+    /v1/snapshots/<name>           one snapshot record
+  GET /v1/objects/?prefix=<key>    list keys, likewise for /v1/snapshots/
+
+The server is append-only unless --allow-delete is given: existing objects
+and snapshot records cannot be replaced or deleted, so a compromised client
+can add backups but not destroy them. Run prune on the server itself.
+
+Statistics walk every snapshot, so they are cached for --cache-ttl; add
+?refresh=1 to recompute them. Requests must carry the token from
+--token-file or SNAPSYNC_API_TOKEN as "Authorization: Bearer <token>",
+which is required when listening beyond localhost.`,
+		Example: `  snapsync serve --repo /path/to/repo --token-file /etc/snapsync/api-token
+  curl -H "Authorization: Bearer $(cat /etc/snapsync/api-token)" http://127.0.0.1:8420/v1/stats
+  snapsync serve --listen :8000 --repo /srv/repo --token-file /etc/snapsync/api-token`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if repoPath == "" {
+				return fmt.Errorf("repository path required (use --repo)")
+			}
+
+			token := os.Getenv("SNAPSYNC_API_TOKEN")
+			if tokenFile != "" {
+				data, err := os.ReadFile(tokenFile)
+				if err != nil {
+					return fmt.Errorf("failed to read token file: %w", err)
+				}
+				token = strings.TrimSpace(string(data))
+			}
+
+			return runServe(repoPath, listen, token, cacheTTL, allowDelete)
+		},
+	}
+
+	cmd.Flags().StringVar(&listen, "listen", "127.0.0.1:8420", "Address to listen on")
+	cmd.Flags().StringVar(&tokenFile, "token-file", "", "File holding the bearer token clients must send")
+	cmd.Flags().DurationVar(&cacheTTL, "cache-ttl", time.Minute, "How long to reuse computed statistics")
+	cmd.Flags().BoolVar(&allowDelete, "allow-delete", false, "Let clients replace and delete objects and snapshot records")
+
+	return cmd
+}
+
+func runServe(repoPath, listen, token string, cacheTTL time.Duration, allowDelete bool) error {
+	// Statistics walk snapshot trees, which are encrypted with the repository
+	encryptor, err := openEncryptor(repoPath, loadRepoConfig(repoPath), "Enter repository password: ")
+	if err != nil {
+		return err
+	}
+
+	mgr, err := snapshot.NewManager(repoPath, nil, encryptor)
+	if err != nil {
+		return fmt.Errorf("failed to open repository: %w", err)
+	}
+
+	// Clients can write to the repository, so only local ones go without a token
+	if token == "" && !loopback(listen) {
+		return fmt.Errorf("refusing to serve on %s without a token (use --token-file or SNAPSYNC_API_TOKEN)", listen)
+	}
+
+	listener, err := net.Listen("tcp", listen)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", listen, err)
+	}
+
+	httpServer := &http.Server{
+		Handler:           server.New(mgr, repoPath, token, cacheTTL, allowDelete).Handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	// Finish in-flight requests on Ctrl-C
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		<-sigs
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := httpServer.Shutdown(ctx); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to shut down: %v\n", err)
+		}
+	}()
+
+	mode := "append-only"
+	if allowDelete {
+		mode = "deletes allowed"
+	}
+	fmt.Printf("Serving %s on http://%s, %s (Ctrl-C to stop)\n", repoPath, listener.Addr(), mode)
+	if err := httpServer.Serve(listener); err != nil && err != http.ErrServerClosed {
+		return fmt.Errorf("server failed: %w", err)
+	}
+	<-done
+	return nil
+}
+
+// loopback reports whether a listen address only accepts local connections
+func loopback(listen string) bool {
+	host, _, err := net.SplitHostPort(listen)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
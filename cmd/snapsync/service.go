@@ -0,0 +1,219 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/snapsync/snapsync/internal/snapshot"
+	"github.com/snapsync/snapsync/pkg/models"
+	"github.com/spf13/cobra"
+)
+
+// serviceOptions describes the scheduled backup a service runs
+type serviceOptions struct {
+	Name       string
+	Source     string
+	Every      time.Duration
+	Exclude    []string
+	Tags       []string
+	NoCompress bool
+}
+
+func serviceCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "service",
+		Short: "Run scheduled backups as a system service",
+		Long: `Installs SnapSync as a Windows service that backs up a source on a fixed
+interval. The service starts with the system, restarts after failures and
+reports each backup to the Windows event log.
+
+Encrypted repositories need --password-file, since a service cannot prompt.`,
+	}
+
+	cmd.AddCommand(serviceInstallCmd())
+	cmd.AddCommand(serviceUninstallCmd())
+	cmd.AddCommand(serviceRunCmd())
+	return cmd
+}
+
+func serviceInstallCmd() *cobra.Command {
+	opts := serviceOptions{}
+
+	cmd := &cobra.Command{
+		Use:     "install [source]",
+		Short:   "Register the backup service",
+		Example: `  snapsync service install C:\Users --repo D:\backups --every 6h --password-file C:\snapsync\pw`,
+		Args:    cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if repoPath == "" {
+				return fmt.Errorf("repository path required (use --repo)")
+			}
+			if opts.Every < time.Minute {
+				return fmt.Errorf("--every must be at least 1m")
+			}
+
+			var err error
+			if opts.Source, err = filepath.Abs(args[0]); err != nil {
+				return fmt.Errorf("invalid source path: %w", err)
+			}
+			if err := installService(opts); err != nil {
+				return err
+			}
+
+			fmt.Printf("Installed service %s: backs up %s every %s\n", opts.Name, opts.Source, opts.Every)
+			return nil
+		},
+	}
+
+	addServiceFlags(cmd, &opts)
+	return cmd
+}
+
+func serviceUninstallCmd() *cobra.Command {
+	var name string
+
+	cmd := &cobra.Command{
+		Use:   "uninstall",
+		Short: "Stop and remove the backup service",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := removeService(name); err != nil {
+				return err
+			}
+			fmt.Printf("Removed service %s\n", name)
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&name, "name", "SnapSync", "Service name")
+	return cmd
+}
+
+func serviceRunCmd() *cobra.Command {
+	opts := serviceOptions{}
+
+	cmd := &cobra.Command{
+		Use:    "run [source]",
+		Short:  "Run the backup schedule (started by the service manager)",
+		Hidden: true,
+		Args:   cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if repoPath == "" {
+				return fmt.Errorf("repository path required (use --repo)")
+			}
+			opts.Source = args[0]
+			return runService(opts)
+		},
+	}
+
+	addServiceFlags(cmd, &opts)
+	return cmd
+}
+
+// addServiceFlags registers the flags shared by install and run
+func addServiceFlags(cmd *cobra.Command, opts *serviceOptions) {
+	cmd.Flags().StringVar(&opts.Name, "name", "SnapSync", "Service name")
+	cmd.Flags().DurationVar(&opts.Every, "every", 24*time.Hour, "Interval between backups")
+	cmd.Flags().StringArrayVarP(&opts.Exclude, "exclude", "x", nil, "Exclude patterns")
+	cmd.Flags().StringArrayVar(&opts.Tags, "tag", nil, "Tag to record on each snapshot (repeatable)")
+	cmd.Flags().BoolVar(&opts.NoCompress, "no-compress", false, "Disable compression")
+}
+
+// serviceArgs returns the command line the service manager starts
+// Paths are made absolute because services do not start in the caller's
+// working directory.
+func serviceArgs(opts serviceOptions) ([]string, error) {
+	repo, err := filepath.Abs(repoPath)
+	if err != nil {
+		return nil, fmt.Errorf("invalid repository path: %w", err)
+	}
+
+	args := []string{"service", "run", opts.Source, "--repo", repo,
+		"--name", opts.Name, "--every", opts.Every.String()}
+	if passwordFile != "" {
+		pw, err := filepath.Abs(passwordFile)
+		if err != nil {
+			return nil, fmt.Errorf("invalid password file: %w", err)
+		}
+		args = append(args, "--password-file", pw)
+	}
+	if maxMemory != "" {
+		args = append(args, "--max-memory", maxMemory)
+	}
+	for _, pattern := range opts.Exclude {
+		args = append(args, "--exclude", pattern)
+	}
+	for _, tag := range opts.Tags {
+		args = append(args, "--tag", tag)
+	}
+	if opts.NoCompress {
+		args = append(args, "--no-compress")
+	}
+	return args, nil
+}
+
+// runSchedule backs up the source immediately and then every interval until
+// stop is closed, reporting each run through logf
+func runSchedule(opts serviceOptions, stop <-chan struct{}, logf func(failed bool, msg string)) {
+	ticker := time.NewTicker(opts.Every)
+	defer ticker.Stop()
+
+	for {
+		snap, err := runBackup(models.BackupOptions{
+			SourcePath:     opts.Source,
+			RepoPath:       repoPath,
+			Description:    "scheduled backup",
+			ExcludePattern: opts.Exclude,
+			Tags:           opts.Tags,
+			Compress:       !opts.NoCompress,
+		})
+		if err != nil {
+			logf(true, fmt.Sprintf("Backup of %s failed: %v", opts.Source, err))
+		} else {
+			logf(false, fmt.Sprintf("Backed up %s as snapshot %s (%d files, %s stored)",
+				opts.Source, snap.ID, snap.Tree.FileCount, formatBytes(snap.Stats.StoredSize)))
+		}
+
+		if removed, freed, err := removeExpired(repoPath); err != nil {
+			logf(true, fmt.Sprintf("Removing expired snapshots failed: %v", err))
+		} else if len(removed) > 0 {
+			logf(false, fmt.Sprintf("Removed %d expired snapshots (%s freed): %s",
+				len(removed), formatBytes(freed), strings.Join(removed, ", ")))
+		}
+
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// removeExpired deletes expired snapshots and the objects only they used
+func removeExpired(repoPath string) ([]string, int64, error) {
+	// Finding unreferenced objects reads encrypted trees
+	cfg := loadRepoConfig(repoPath)
+	encryptor, err := openEncryptor(repoPath, cfg, "Enter repository password: ")
+	if err != nil {
+		return nil, 0, err
+	}
+
+	mgr, err := snapshot.NewManager(repoPath, nil, encryptor)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to open repository: %w", err)
+	}
+
+	removed, err := mgr.RemoveExpired()
+	forgetRemoteSnapshots(repoPath, cfg, removed)
+	if err != nil || len(removed) == 0 {
+		return removed, 0, err
+	}
+
+	_, freed, err := mgr.RemoveUnreferenced()
+	if err != nil {
+		return removed, 0, fmt.Errorf("failed to remove unreferenced objects: %w", err)
+	}
+	return removed, freed, nil
+}
@@ -0,0 +1,34 @@
+//go:build !windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+func installService(opts serviceOptions) error {
+	return fmt.Errorf("service install is only supported on Windows")
+}
+
+func removeService(name string) error {
+	return fmt.Errorf("service uninstall is only supported on Windows")
+}
+
+// runService runs the schedule in the foreground until interrupted
+func runService(opts serviceOptions) error {
+	stop := make(chan struct{})
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sigs
+		close(stop)
+	}()
+
+	runSchedule(opts, stop, func(failed bool, msg string) {
+		fmt.Fprintln(os.Stderr, msg)
+	})
+	return nil
+}
@@ -0,0 +1,174 @@
+//go:build windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"time"
+
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/eventlog"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+// Event IDs written to the event log
+const (
+	eventStarted = 1
+	eventBackup  = 2
+	eventStopped = 3
+)
+
+// installService registers the backup schedule with the service manager
+func installService(opts serviceOptions) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to locate executable: %w", err)
+	}
+	args, err := serviceArgs(opts)
+	if err != nil {
+		return err
+	}
+
+	m, err := mgr.Connect()
+	if err != nil {
+		return fmt.Errorf("failed to connect to service manager (run as administrator): %w", err)
+	}
+	defer m.Disconnect()
+
+	if s, err := m.OpenService(opts.Name); err == nil {
+		s.Close()
+		return fmt.Errorf("service %s already exists", opts.Name)
+	}
+
+	s, err := m.CreateService(opts.Name, exe, mgr.Config{
+		DisplayName:      "SnapSync backup (" + opts.Name + ")",
+		Description:      fmt.Sprintf("Backs up %s every %s", opts.Source, opts.Every),
+		StartType:        mgr.StartAutomatic,
+		DelayedAutoStart: true,
+	}, args...)
+	if err != nil {
+		return fmt.Errorf("failed to create service: %w", err)
+	}
+	defer s.Close()
+
+	// Restart after crashes, backing off, and forget failures after a day
+	err = s.SetRecoveryActions([]mgr.RecoveryAction{
+		{Type: mgr.ServiceRestart, Delay: time.Minute},
+		{Type: mgr.ServiceRestart, Delay: 5 * time.Minute},
+		{Type: mgr.ServiceRestart, Delay: 15 * time.Minute},
+	}, uint32((24 * time.Hour).Seconds()))
+	if err != nil {
+		s.Delete()
+		return fmt.Errorf("failed to set recovery actions: %w", err)
+	}
+
+	if err := eventlog.InstallAsEventCreate(opts.Name, eventlog.Error|eventlog.Warning|eventlog.Info); err != nil {
+		s.Delete()
+		return fmt.Errorf("failed to register event source: %w", err)
+	}
+
+	if err := s.Start(); err != nil {
+		return fmt.Errorf("service installed but failed to start: %w", err)
+	}
+	return nil
+}
+
+// removeService stops and deletes the backup service
+func removeService(name string) error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return fmt.Errorf("failed to connect to service manager (run as administrator): %w", err)
+	}
+	defer m.Disconnect()
+
+	s, err := m.OpenService(name)
+	if err != nil {
+		return fmt.Errorf("service %s is not installed", name)
+	}
+	defer s.Close()
+
+	// A stopped service rejects the request; deleting it is what matters
+	s.Control(svc.Stop)
+
+	if err := s.Delete(); err != nil {
+		return fmt.Errorf("failed to delete service: %w", err)
+	}
+	eventlog.Remove(name)
+	return nil
+}
+
+// runService runs the schedule under the service manager, or in the
+// foreground when started from a console
+func runService(opts serviceOptions) error {
+	isService, err := svc.IsWindowsService()
+	if err != nil {
+		return fmt.Errorf("failed to detect service environment: %w", err)
+	}
+
+	if !isService {
+		stop := make(chan struct{})
+		sigs := make(chan os.Signal, 1)
+		signal.Notify(sigs, os.Interrupt)
+		go func() {
+			<-sigs
+			close(stop)
+		}()
+
+		runSchedule(opts, stop, func(failed bool, msg string) {
+			fmt.Fprintln(os.Stderr, msg)
+		})
+		return nil
+	}
+
+	elog, err := eventlog.Open(opts.Name)
+	if err != nil {
+		return fmt.Errorf("failed to open event log: %w", err)
+	}
+	defer elog.Close()
+
+	return svc.Run(opts.Name, &backupService{opts: opts, elog: elog})
+}
+
+// backupService answers the service manager while the schedule runs
+type backupService struct {
+	opts serviceOptions
+	elog *eventlog.Log
+}
+
+// Execute implements svc.Handler
+func (s *backupService) Execute(args []string, requests <-chan svc.ChangeRequest, changes chan<- svc.Status) (bool, uint32) {
+	changes <- svc.Status{State: svc.StartPending}
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		runSchedule(s.opts, stop, func(failed bool, msg string) {
+			if failed {
+				s.elog.Error(eventBackup, msg)
+			} else {
+				s.elog.Info(eventBackup, msg)
+			}
+		})
+	}()
+
+	changes <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+	s.elog.Info(eventStarted, fmt.Sprintf("Backing up %s every %s", s.opts.Source, s.opts.Every))
+
+	for req := range requests {
+		switch req.Cmd {
+		case svc.Interrogate:
+			changes <- req.CurrentStatus
+		case svc.Stop, svc.Shutdown:
+			// A backup in progress finishes before the service stops
+			changes <- svc.Status{State: svc.StopPending}
+			close(stop)
+			<-done
+			s.elog.Info(eventStopped, "Stopped")
+			return false, 0
+		}
+	}
+	return false, 0
+}
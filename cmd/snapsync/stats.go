@@ -0,0 +1,279 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/snapsync/snapsync/internal/crypto"
+	"github.com/snapsync/snapsync/internal/snapshot"
+	"github.com/spf13/cobra"
+)
+
+func statsCmd() *cobra.Command {
+	var (
+		top        int
+		by         string
+		jsonOutput bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "stats [snapshot-id]",
+		Short: "Show deduplication and compression statistics",
+		Long: `Walks every snapshot and reports how the repository's space is used:
+logical against stored size, chunks unique to one snapshot against chunks
+shared by several, what compression saves, the largest files, and for each
+snapshot the data it shares, the data only it holds and how it grew from the
+snapshot before it of the same source.
+
+With a snapshot reference (an ID or prefix, "latest" or "@<time>"), shows that snapshot in detail
+with its largest files.
+
+With --by host or --by tag, attributes the stored data to the machines the
+snapshots were taken on, or to their tags, for chargeback in shared
+repositories. Data only one group references is its own; data several groups
+reference is split evenly between them, so the attributions add up to the
+referenced size. A snapshot with several tags counts towards each.
+
+Sizes before compression are not recorded per chunk, so compression savings
+are estimated from file sizes.`,
+		Example: `  snapsync stats --repo /path/to/repo
+  snapsync stats latest --top 20 --repo /path/to/repo
+  snapsync stats --by host --repo /path/to/repo
+  snapsync stats --json --repo /path/to/repo`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if repoPath == "" {
+				return fmt.Errorf("repository path required (use --repo)")
+			}
+
+			ref := ""
+			if len(args) > 0 {
+				ref = args[0]
+			}
+			if by != "" {
+				if ref != "" {
+					return fmt.Errorf("--by reports on the whole repository, not one snapshot")
+				}
+				return runAccounting(repoPath, by, jsonOutput)
+			}
+			return runStats(repoPath, ref, top, jsonOutput)
+		},
+	}
+
+	cmd.Flags().IntVar(&top, "top", 10, "Number of largest files to list")
+	cmd.Flags().StringVar(&by, "by", "", "Attribute stored data to each host or tag")
+	cmd.Flags().BoolVar(&jsonOutput, "json", false, "Output in JSON format")
+
+	return cmd
+}
+
+func runStats(repoPath, ref string, top int, jsonOutput bool) error {
+	mgr, err := openStatsRepo(repoPath)
+	if err != nil {
+		return err
+	}
+
+	stats, err := mgr.Statistics(top)
+	if err != nil {
+		return fmt.Errorf("failed to compute statistics: %w", err)
+	}
+
+	if ref != "" {
+		return showSnapshotStats(mgr, stats, ref, top, jsonOutput)
+	}
+
+	if jsonOutput {
+		output, _ := json.MarshalIndent(stats, "", "  ")
+		fmt.Println(string(output))
+		return nil
+	}
+
+	fmt.Println("Repository")
+	fmt.Printf("  Snapshots:      %d\n", len(stats.Snapshots))
+	fmt.Printf("  Logical size:   %s (summed over snapshots)\n", formatBytes(stats.LogicalSize))
+	fmt.Printf("  Stored size:    %s in %d objects", formatBytes(stats.StoredSize), stats.Objects)
+	if unreferenced := stats.StoredSize - stats.ReferencedSize; unreferenced > 0 {
+		fmt.Printf(", %s unreferenced (snapsync prune)", formatBytes(unreferenced))
+	}
+	fmt.Println()
+	fmt.Printf("  Chunks:         %d distinct, %d unique (%s), %d shared (%s)\n",
+		stats.Chunks, stats.UniqueChunks, formatBytes(stats.UniqueChunkSize),
+		stats.SharedChunks, formatBytes(stats.SharedChunkSize))
+	if stats.ContentSize > 0 {
+		fmt.Printf("  Deduplication:  %.2fx (%s of distinct data)\n", stats.DedupRatio(), formatBytes(stats.ContentSize))
+		if stats.CompressionSavings > 0 {
+			fmt.Printf("  Compression:    saves about %s (%.0f%% of chunk data)\n",
+				formatBytes(stats.CompressionSavings), 100*(1-stats.CompressionRatio()))
+		} else {
+			fmt.Println("  Compression:    saves nothing; the data does not compress")
+		}
+	}
+
+	if len(stats.Largest) > 0 {
+		fmt.Println()
+		fmt.Println("Largest files")
+		printLargest(stats.Largest, true)
+	}
+
+	if len(stats.Snapshots) == 0 {
+		return nil
+	}
+
+	fmt.Println()
+	fmt.Printf("%-20s  %-19s  %7s  %10s  %10s  %10s  %10s  %10s\n",
+		"ID", "TIMESTAMP", "FILES", "LOGICAL", "GROWTH", "ADDED", "UNIQUE", "SHARED")
+	fmt.Println("--------------------------------------------------------------------------------------------------------------")
+	for _, s := range stats.Snapshots {
+		fmt.Printf("%-20s  %-19s  %7d  %10s  %10s  %10s  %10s  %10s\n",
+			s.ID[:16]+"...",
+			s.Timestamp.Format("2006-01-02 15:04:05"),
+			s.Files,
+			formatBytes(s.LogicalSize),
+			formatGrowth(s.Previous, s.LogicalGrowth),
+			formatBytes(s.AddedSize),
+			formatBytes(s.UniqueSize),
+			formatBytes(s.SharedSize),
+		)
+	}
+	fmt.Println()
+	fmt.Println("GROWTH is the change in logical size from the previous snapshot of the same")
+	fmt.Println("source; ADDED is the stored data first referenced by the snapshot; UNIQUE is")
+	fmt.Println("what deleting only that snapshot would free.")
+	return nil
+}
+
+func runAccounting(repoPath, by string, jsonOutput bool) error {
+	mgr, err := openStatsRepo(repoPath)
+	if err != nil {
+		return err
+	}
+
+	acct, err := mgr.Accounting(by)
+	if err != nil {
+		return fmt.Errorf("failed to compute accounting: %w", err)
+	}
+
+	if jsonOutput {
+		output, _ := json.MarshalIndent(acct, "", "  ")
+		fmt.Println(string(output))
+		return nil
+	}
+
+	fmt.Printf("%-24s  %9s  %10s  %10s  %10s  %10s  %6s\n",
+		strings.ToUpper(by), "SNAPSHOTS", "LOGICAL", "EXCLUSIVE", "SHARED", "ATTRIBUTED", "SHARE")
+	fmt.Println("------------------------------------------------------------------------------------------------")
+	for _, g := range acct.Groups {
+		var share float64
+		if acct.ReferencedSize > 0 {
+			share = 100 * float64(g.Attributed) / float64(acct.ReferencedSize)
+		}
+		fmt.Printf("%-24s  %9d  %10s  %10s  %10s  %10s  %5.1f%%\n",
+			g.Name, g.Snapshots,
+			formatBytes(g.LogicalSize),
+			formatBytes(g.ExclusiveSize),
+			formatBytes(g.SharedSize),
+			formatBytes(g.Attributed),
+			share,
+		)
+	}
+	fmt.Println()
+	fmt.Printf("Referenced: %s\n", formatBytes(acct.ReferencedSize))
+	fmt.Println("EXCLUSIVE is stored data only that group references; SHARED is its even")
+	fmt.Println("share of data other groups reference too.")
+	return nil
+}
+
+// openStatsRepo opens a repository for statistics, unlocking it when
+// encrypted names hide the trees the statistics walk
+func openStatsRepo(repoPath string) (*snapshot.Manager, error) {
+	var encryptor *crypto.Encryptor
+	if namesEncrypted(repoPath) {
+		var err error
+		encryptor, err = openEncryptor(repoPath, loadRepoConfig(repoPath), "Enter repository password: ")
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	mgr, err := snapshot.NewManager(repoPath, nil, encryptor)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open repository: %w", err)
+	}
+	return mgr, nil
+}
+
+func showSnapshotStats(mgr *snapshot.Manager, stats *snapshot.Statistics, ref string, top int, jsonOutput bool) error {
+	snap, err := mgr.Resolve(ref)
+	if err != nil {
+		return err
+	}
+
+	var s *snapshot.SnapshotStatistics
+	for i := range stats.Snapshots {
+		if stats.Snapshots[i].ID == snap.ID {
+			s = &stats.Snapshots[i]
+			break
+		}
+	}
+	if s == nil {
+		return fmt.Errorf("snapshot not found: %s", ref)
+	}
+	largest := snapshot.LargestFiles(snap, top)
+
+	if jsonOutput {
+		output, _ := json.MarshalIndent(struct {
+			*snapshot.SnapshotStatistics
+			Largest []snapshot.LargeFile `json:"largest"`
+		}{s, largest}, "", "  ")
+		fmt.Println(string(output))
+		return nil
+	}
+
+	fmt.Printf("Snapshot %s\n", s.ID)
+	fmt.Printf("  Taken:          %s\n", s.Timestamp.Format("2006-01-02 15:04:05"))
+	fmt.Printf("  Source:         %s\n", s.Source)
+	if s.Previous != "" {
+		fmt.Printf("  Previous:       %s\n", s.Previous)
+		fmt.Printf("  Files:          %d (%+d)\n", s.Files, s.FileGrowth)
+		fmt.Printf("  Logical size:   %s (%s)\n", formatBytes(s.LogicalSize), formatGrowth(s.Previous, s.LogicalGrowth))
+	} else {
+		fmt.Printf("  Files:          %d\n", s.Files)
+		fmt.Printf("  Logical size:   %s\n", formatBytes(s.LogicalSize))
+	}
+	fmt.Printf("  Stored size:    %s in %d objects\n", formatBytes(s.StoredSize), s.Objects)
+	fmt.Printf("  Chunks:         %d, %d unique to this snapshot\n", s.Chunks, s.UniqueChunks)
+	fmt.Printf("  Unique:         %s in %d objects, freed by deleting it\n", formatBytes(s.UniqueSize), s.UniqueObjects)
+	fmt.Printf("  Shared:         %s in %d objects\n", formatBytes(s.SharedSize), s.SharedObjects)
+	fmt.Printf("  Added:          %s not referenced by earlier snapshots\n", formatBytes(s.AddedSize))
+
+	if len(largest) > 0 {
+		fmt.Println()
+		fmt.Println("Largest files")
+		printLargest(largest, false)
+	}
+	return nil
+}
+
+// printLargest lists files by size, with their snapshot if they may differ
+func printLargest(files []snapshot.LargeFile, withSnapshot bool) {
+	for _, f := range files {
+		if withSnapshot {
+			fmt.Printf("  %10s  %s  %s\n", formatBytes(f.Size), f.SnapshotID[:8], f.Path)
+		} else {
+			fmt.Printf("  %10s  %s\n", formatBytes(f.Size), f.Path)
+		}
+	}
+}
+
+// formatGrowth formats a change in size with its sign, or "-" for a
+// snapshot with nothing before it
+func formatGrowth(previous string, delta int64) string {
+	if previous == "" {
+		return "-"
+	}
+	if delta < 0 {
+		return "-" + formatBytes(-delta)
+	}
+	return "+" + formatBytes(delta)
+}
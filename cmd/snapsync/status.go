@@ -5,7 +5,9 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"time"
 
+	"github.com/snapsync/snapsync/internal/progress"
 	"github.com/snapsync/snapsync/internal/snapshot"
 	"github.com/snapsync/snapsync/internal/store"
 	"github.com/snapsync/snapsync/pkg/models"
@@ -18,7 +20,13 @@ func statusCmd() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "status",
 		Short: "Show repository status",
-		Long:  "Displays information about the repository including statistics and health.",
+		Long: `Displays information about the repository including statistics and health,
+and the operations running on it.
+
+Status takes no lock, so it can be run while a backup or prune is working.
+Snapshots are counted from committed records only. Object counts include
+what running operations have written or not yet deleted and are marked as
+changing; running backups also show their progress.`,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			if repoPath == "" {
 				return fmt.Errorf("repository path required (use --repo)")
@@ -33,6 +41,59 @@ func statusCmd() *cobra.Command {
 	return cmd
 }
 
+// runningOperation is an operation holding a lock on the repository
+type runningOperation struct {
+	Kind      string           `json:"kind"`
+	PID       int              `json:"pid"`
+	Host      string           `json:"host"`
+	Exclusive bool             `json:"exclusive"`
+	Started   time.Time        `json:"started"`
+	Progress  *progress.Status `json:"progress,omitempty"` // Published by backups on this host
+}
+
+// runningOperations lists the operations holding a lock on the repository,
+// with the progress of those publishing it
+func runningOperations(repoPath string) ([]runningOperation, error) {
+	dir, err := openRuntime(repoPath)
+	if err != nil {
+		return nil, err
+	}
+	holders, err := dir.Holders()
+	if err != nil {
+		return nil, err
+	}
+	if len(holders) == 0 {
+		return nil, nil
+	}
+
+	// Status sockets are per user, not per repository; a lock's process
+	// ties one to this repository
+	byPID := make(map[int]*progress.Status)
+	if sockets, err := progress.Sockets(); err == nil {
+		for _, path := range sockets {
+			if status, err := progress.Query(path); err == nil {
+				byPID[status.PID] = status
+			}
+		}
+	}
+	host, _ := os.Hostname()
+
+	operations := make([]runningOperation, len(holders))
+	for i, owner := range holders {
+		operations[i] = runningOperation{
+			Kind:      owner.Kind,
+			PID:       owner.PID,
+			Host:      owner.Host,
+			Exclusive: owner.Exclusive,
+			Started:   owner.Started,
+		}
+		if owner.Host == host {
+			operations[i].Progress = byPID[owner.PID]
+		}
+	}
+	return operations, nil
+}
+
 func showStatus(repoPath string, jsonOutput bool) error {
 	// Load repository info
 	infoPath := filepath.Join(repoPath, "repo.json")
@@ -46,6 +107,13 @@ func showStatus(repoPath string, jsonOutput bool) error {
 		return fmt.Errorf("invalid repository info: %w", err)
 	}
 
+	// An operation running at either end of the reads below may have
+	// changed the store while it was counted
+	before, err := runningOperations(repoPath)
+	if err != nil {
+		return fmt.Errorf("failed to read locks: %w", err)
+	}
+
 	// Get storage stats
 	cas, err := store.NewCAS(repoPath)
 	if err != nil {
@@ -68,13 +136,23 @@ func showStatus(repoPath string, jsonOutput bool) error {
 		return fmt.Errorf("failed to list snapshots: %w", err)
 	}
 
+	operations, err := runningOperations(repoPath)
+	if err != nil {
+		return fmt.Errorf("failed to read locks: %w", err)
+	}
+	if operations == nil {
+		operations = []runningOperation{}
+	}
+
 	status := struct {
-		Version        int    `json:"version"`
-		Encrypted      bool   `json:"encrypted"`
-		SnapshotCount  int    `json:"snapshot_count"`
-		ObjectCount    int    `json:"object_count"`
-		TotalSize      int64  `json:"total_size"`
-		TotalSizeHuman string `json:"total_size_human"`
+		Version        int                `json:"version"`
+		Encrypted      bool               `json:"encrypted"`
+		SnapshotCount  int                `json:"snapshot_count"`
+		ObjectCount    int                `json:"object_count"`
+		TotalSize      int64              `json:"total_size"`
+		TotalSizeHuman string             `json:"total_size_human"`
+		Changing       bool               `json:"changing"` // Objects were being written or deleted while counted
+		Operations     []runningOperation `json:"operations"`
 	}{
 		Version:        repoInfo.Version,
 		Encrypted:      repoInfo.Encrypted,
@@ -82,6 +160,8 @@ func showStatus(repoPath string, jsonOutput bool) error {
 		ObjectCount:    objectCount,
 		TotalSize:      totalSize,
 		TotalSizeHuman: formatBytes(totalSize),
+		Changing:       len(before) > 0 || len(operations) > 0,
+		Operations:     operations,
 	}
 
 	if jsonOutput {
@@ -90,6 +170,11 @@ func showStatus(repoPath string, jsonOutput bool) error {
 		return nil
 	}
 
+	changing := ""
+	if status.Changing {
+		changing = " (changing)"
+	}
+
 	// Pretty print
 	fmt.Println("SnapSync Repository Status")
 	fmt.Println("==========================")
@@ -97,8 +182,8 @@ func showStatus(repoPath string, jsonOutput bool) error {
 	fmt.Printf("Version:    %d\n", status.Version)
 	fmt.Printf("Encrypted:  %v\n", status.Encrypted)
 	fmt.Printf("Snapshots:  %d\n", status.SnapshotCount)
-	fmt.Printf("Objects:    %d\n", status.ObjectCount)
-	fmt.Printf("Total Size: %s\n", status.TotalSizeHuman)
+	fmt.Printf("Objects:    %d%s\n", status.ObjectCount, changing)
+	fmt.Printf("Total Size: %s%s\n", status.TotalSizeHuman, changing)
 
 	if len(snapshots) > 0 {
 		fmt.Println()
@@ -109,5 +194,33 @@ func showStatus(repoPath string, jsonOutput bool) error {
 		fmt.Printf("  Files:   %d\n", latest.Tree.FileCount)
 	}
 
+	fmt.Println()
+	if len(operations) == 0 {
+		fmt.Println("Running:    none")
+		return nil
+	}
+	fmt.Println("Running:")
+	for _, op := range operations {
+		mode := "shared"
+		if op.Exclusive {
+			mode = "exclusive"
+		}
+		fmt.Printf("  %s (pid %d on %s, %s lock), running %s\n",
+			op.Kind, op.PID, op.Host, mode, time.Since(op.Started).Round(time.Second))
+
+		p := op.Progress
+		if p == nil {
+			continue
+		}
+		fmt.Printf("    Target:  %s\n", p.Target)
+		fmt.Printf("    Phase:   %s\n", p.Phase)
+		fmt.Printf("    Files:   %d / %d\n", p.FilesDone, p.FilesTotal)
+		fmt.Printf("    Data:    %s / %s", formatBytes(p.BytesDone), formatBytes(p.BytesTotal))
+		if p.BytesTotal > 0 {
+			fmt.Printf(" (%.1f%%)", float64(p.BytesDone)*100/float64(p.BytesTotal))
+		}
+		fmt.Println()
+	}
+
 	return nil
 }
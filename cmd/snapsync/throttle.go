@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/snapsync/snapsync/internal/config"
+	"github.com/snapsync/snapsync/internal/restore"
+	"github.com/snapsync/snapsync/internal/tuning"
+	"github.com/spf13/cobra"
+)
+
+// restoreLimits bound the load a restore or verify puts on the host; unset
+// flags fall back to the restore section of the configuration
+type restoreLimits struct {
+	readLimit   string
+	writeLimit  string
+	lowPriority bool
+}
+
+// addRestoreLimitFlags registers the flags shared by restore and verify
+func addRestoreLimitFlags(cmd *cobra.Command, limits *restoreLimits) {
+	cmd.Flags().StringVar(&limits.readLimit, "read-limit", "", "Repository data read per second, e.g. 50MB (default restore.read_limit)")
+	cmd.Flags().StringVar(&limits.writeLimit, "write-limit", "", "Restored data written per second, e.g. 20MB (default restore.write_limit)")
+	cmd.Flags().BoolVar(&limits.lowPriority, "low-priority", false, "Run at the lowest CPU and I/O priority")
+}
+
+// apply lowers the process priority if asked and sets the bandwidth limits
+// on the restorer
+func (l restoreLimits) apply(cfg config.RestoreConfig, restorer *restore.Restorer) error {
+	readLimit, writeLimit := cfg.ReadLimit, cfg.WriteLimit
+	if l.readLimit != "" {
+		readLimit = l.readLimit
+	}
+	if l.writeLimit != "" {
+		writeLimit = l.writeLimit
+	}
+
+	var read, write int64
+	var err error
+	if readLimit != "" {
+		if read, err = parseSize(readLimit); err != nil {
+			return fmt.Errorf("invalid read limit: %w", err)
+		}
+	}
+	if writeLimit != "" {
+		if write, err = parseSize(writeLimit); err != nil {
+			return fmt.Errorf("invalid write limit: %w", err)
+		}
+	}
+
+	if l.lowPriority || cfg.LowPriority {
+		if err := tuning.LowerPriority(); err != nil {
+			return err
+		}
+	}
+	restorer.SetLimits(tuning.NewBandwidth(read), tuning.NewBandwidth(write))
+	return nil
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/snapsync/snapsync/internal/config"
+	"github.com/snapsync/snapsync/internal/retention"
+	"github.com/snapsync/snapsync/internal/snapshot"
+	"github.com/spf13/cobra"
+)
+
+func tierCmd() *cobra.Command {
+	var clear bool
+
+	cmd := &cobra.Command{
+		Use:   "tier [snapshot-id] [tier]",
+		Short: "Pin snapshots to retention tiers",
+		Long: `Pins a snapshot to a retention tier such as "monthly" or "yearly". Each tier
+has its own keep rules, so milestone snapshots survive aggressive pruning of
+the daily ones. Snapshots also join a tier by carrying one of the tags the
+tier lists in the configuration; a pin takes precedence over tags.
+
+Tiers and their rules are configured under retention.tiers. A tier without
+keep rules keeps every snapshot in it.
+
+Without arguments, lists every snapshot with its tier and the rules that
+currently keep it.`,
+		Example: `  snapsync tier --repo /path/to/repo
+  snapsync tier 17921759 yearly --repo /path/to/repo
+  snapsync tier 17921759 --clear --repo /path/to/repo`,
+		Args: cobra.MaximumNArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if repoPath == "" {
+				return fmt.Errorf("repository path required (use --repo)")
+			}
+
+			switch {
+			case len(args) == 0:
+				return runTierList(repoPath)
+			case clear:
+				return runTierSet(repoPath, args[0], "")
+			case len(args) == 2:
+				return runTierSet(repoPath, args[0], args[1])
+			default:
+				return fmt.Errorf("tier name required (or use --clear)")
+			}
+		},
+	}
+
+	cmd.Flags().BoolVar(&clear, "clear", false, "Unpin the snapshot from its tier")
+
+	return cmd
+}
+
+func runTierSet(repoPath, ref, tier string) error {
+	mgr, err := snapshot.NewManager(repoPath, nil, nil)
+	if err != nil {
+		return fmt.Errorf("failed to open repository: %w", err)
+	}
+
+	// Resolving reads only records, so no key is needed for encrypted names
+	id, err := mgr.ResolveID(ref)
+	if err != nil {
+		return err
+	}
+
+	if err := mgr.SetSnapshotTier(id, tier); err != nil {
+		return fmt.Errorf("failed to set tier: %w", err)
+	}
+
+	if tier == "" {
+		fmt.Printf("Snapshot %s unpinned\n", id)
+		return nil
+	}
+	fmt.Printf("Snapshot %s pinned to tier %s\n", id, tier)
+	if _, ok := loadRepoConfig(repoPath).Retention.Tiers[tier]; !ok {
+		fmt.Printf("Note: tier %s has no keep rules configured; its snapshots are always kept\n", tier)
+	}
+	return nil
+}
+
+func runTierList(repoPath string) error {
+	tiers, err := retentionTiers(loadRepoConfig(repoPath).Retention)
+	if err != nil {
+		return err
+	}
+
+	mgr, err := snapshot.NewManager(repoPath, nil, nil)
+	if err != nil {
+		return fmt.Errorf("failed to open repository: %w", err)
+	}
+
+	records, err := mgr.ListRecords()
+	if err != nil {
+		return fmt.Errorf("failed to list snapshots: %w", err)
+	}
+	if len(records) == 0 {
+		fmt.Println("No snapshots found")
+		return nil
+	}
+
+	result := tiers.Apply(records, time.Now())
+
+	fmt.Printf("%-20s  %-20s  %-12s  %-7s  %s\n",
+		"ID", "TIMESTAMP", "TIER", "BY", "KEPT BY")
+	fmt.Println("------------------------------------------------------------------------------------")
+
+	for _, snap := range records {
+		tier, by := tiers.TierOf(snap), "-"
+		switch {
+		case snap.Tier != "":
+			by = "pin"
+		case tier != "":
+			by = "tag"
+		default:
+			tier = "-"
+		}
+
+		keptBy := "(would be removed)"
+		if reasons := result.Reasons[snap.ID]; len(reasons) > 0 {
+			keptBy = strings.Join(reasons, ", ")
+		}
+
+		fmt.Printf("%-20s  %-20s  %-12s  %-7s  %s\n",
+			snap.ID[:16]+"...",
+			snap.Timestamp.Format("2006-01-02 15:04:05"),
+			tier,
+			by,
+			keptBy,
+		)
+	}
+
+	fmt.Printf("\nTotal: %d snapshots, %d kept by the current rules\n", len(records), len(result.Keep))
+	return nil
+}
+
+// retentionTiers converts the retention configuration into the policies
+// the retention engine applies
+func retentionTiers(cfg config.RetentionConfig) (*retention.Tiers, error) {
+	now := time.Now()
+	tiers := &retention.Tiers{
+		Policies: make(map[string]retention.Policy),
+		Tags:     make(map[string][]string),
+	}
+
+	var err error
+	if tiers.Default, err = keepPolicy(cfg.KeepRules, now); err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(cfg.Tiers))
+	for name := range cfg.Tiers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		tier := cfg.Tiers[name]
+		policy, err := keepPolicy(tier.KeepRules, now)
+		if err != nil {
+			return nil, fmt.Errorf("tier %s: %w", name, err)
+		}
+		tiers.Policies[name] = policy
+		tiers.Tags[name] = tier.Tags
+	}
+
+	return tiers, nil
+}
+
+// keepPolicy converts configured keep rules into a retention policy
+func keepPolicy(rules config.KeepRules, now time.Time) (retention.Policy, error) {
+	policy := retention.Policy{
+		Last:    rules.KeepLast,
+		Hourly:  rules.KeepHourly,
+		Daily:   rules.KeepDaily,
+		Weekly:  rules.KeepWeekly,
+		Monthly: rules.KeepMonthly,
+		Yearly:  rules.KeepYearly,
+	}
+	if rules.KeepWithin != "" {
+		until, err := parseRetention(rules.KeepWithin, now)
+		if err != nil {
+			return policy, fmt.Errorf("invalid keep_within: %w", err)
+		}
+		policy.Within = until.Sub(now)
+	}
+	return policy, nil
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/snapsync/snapsync/internal/progress"
+	"github.com/spf13/cobra"
+)
+
+func topCmd() *cobra.Command {
+	var socket string
+	var interval time.Duration
+	var once bool
+
+	cmd := &cobra.Command{
+		Use:   "top",
+		Short: "Watch running backups",
+		Long: `Attaches to running backups through their status sockets and shows the
+current phase, throughput, queue depths, per-worker activity and recent
+errors, refreshing until interrupted.
+
+Each backup publishes its status in $XDG_RUNTIME_DIR/snapsync (or
+$SNAPSYNC_CONTROL_DIR when set).`,
+		Example: `  snapsync top
+  snapsync top --socket /run/user/1000/snapsync/backup-4242.sock --interval 500ms`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if interval <= 0 {
+				return fmt.Errorf("--interval must be positive")
+			}
+			return runTop(socket, interval, once)
+		},
+	}
+
+	cmd.Flags().StringVar(&socket, "socket", "", "Status socket of one backup (default all running)")
+	cmd.Flags().DurationVar(&interval, "interval", time.Second, "Refresh interval")
+	cmd.Flags().BoolVar(&once, "once", false, "Print the status once and exit")
+
+	return cmd
+}
+
+// topSample is the last status seen on a socket, for computing throughput
+type topSample struct {
+	at    time.Time
+	bytes int64
+}
+
+func runTop(socket string, interval time.Duration, once bool) error {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sigs)
+
+	samples := make(map[string]topSample)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		sockets := []string{socket}
+		if socket == "" {
+			var err error
+			if sockets, err = progress.Sockets(); err != nil {
+				return fmt.Errorf("failed to list status sockets: %w", err)
+			}
+		}
+
+		var out strings.Builder
+		if !once {
+			// Clear the screen and move to the top left
+			out.WriteString("\033[H\033[2J")
+		}
+		fmt.Fprintf(&out, "snapsync top - %s\n\n", time.Now().Format("15:04:05"))
+
+		shown := 0
+		for _, path := range sockets {
+			status, err := progress.Query(path)
+			if err != nil {
+				if socket != "" {
+					return fmt.Errorf("failed to attach to %s: %w", socket, err)
+				}
+				continue
+			}
+
+			now := time.Now()
+			rate := float64(status.BytesDone) / now.Sub(status.Started).Seconds()
+			if last, ok := samples[path]; ok && now.After(last.at) {
+				rate = float64(status.BytesDone-last.bytes) / now.Sub(last.at).Seconds()
+			}
+			samples[path] = topSample{at: now, bytes: status.BytesDone}
+
+			writeTopStatus(&out, status, rate)
+			shown++
+		}
+		if shown == 0 {
+			out.WriteString("No running backups\n")
+		}
+
+		fmt.Print(out.String())
+		if once {
+			return nil
+		}
+
+		select {
+		case <-sigs:
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// writeTopStatus renders one operation's status
+func writeTopStatus(out *strings.Builder, status *progress.Status, rate float64) {
+	elapsed := time.Since(status.Started).Round(time.Second)
+	fmt.Fprintf(out, "%s %s (pid %d) - %s, running %s\n",
+		status.Operation, status.Target, status.PID, status.Phase, elapsed)
+
+	fmt.Fprintf(out, "  Files:       %d / %d\n", status.FilesDone, status.FilesTotal)
+	fmt.Fprintf(out, "  Data:        %s / %s", formatBytes(status.BytesDone), formatBytes(status.BytesTotal))
+	if status.BytesTotal > 0 {
+		fmt.Fprintf(out, " (%.1f%%)", float64(status.BytesDone)*100/float64(status.BytesTotal))
+	}
+	out.WriteString("\n")
+	if rate < 0 {
+		rate = 0
+	}
+	fmt.Fprintf(out, "  Throughput:  %s/s\n", formatBytes(int64(rate)))
+
+	if len(status.Queues) > 0 {
+		names := make([]string, 0, len(status.Queues))
+		for name := range status.Queues {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		parts := make([]string, len(names))
+		for i, name := range names {
+			parts[i] = fmt.Sprintf("%s=%d", name, status.Queues[name])
+		}
+		fmt.Fprintf(out, "  Queues:      %s\n", strings.Join(parts, " "))
+	}
+
+	for _, worker := range status.Workers {
+		if worker.Path == "" {
+			fmt.Fprintf(out, "  Worker %-3d   idle\n", worker.ID)
+			continue
+		}
+		fmt.Fprintf(out, "  Worker %-3d   %s (%s)\n", worker.ID, worker.Path,
+			time.Since(worker.Since).Round(time.Millisecond))
+	}
+
+	if len(status.Errors) > 0 {
+		out.WriteString("  Recent errors:\n")
+		for _, e := range status.Errors {
+			fmt.Fprintf(out, "    %s  %s\n", e.Time.Format("15:04:05"), e.Message)
+		}
+	}
+	out.WriteString("\n")
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/snapsync/snapsync/internal/agent"
+	"github.com/snapsync/snapsync/internal/config"
+	"github.com/snapsync/snapsync/internal/crypto"
+)
+
+// unlockRepo returns the encryptor and encryption header for the repository
+// with salt, using a key cached by the agent when one is available and
+// prompting otherwise.
+// The key is checked against the repository's encryption header so a
+// mistyped password fails before any data is written or read, and the
+// repository's algorithms are checked against the configured crypto policy.
+func unlockRepo(repoPath string, encCfg config.EncryptionConfig, prompt string, salt []byte) (*crypto.Encryptor, *crypto.EncryptionHeader, error) {
+	header, err := loadEncryptionHeader(repoPath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// An existing header records how the repository's key was derived and
+	// how its data is padded
+	algorithm, kdf, padding := encCfg.Algorithm, encCfg.KDF, encCfg.Padding
+	params := crypto.Argon2Params{
+		Time:    encCfg.Argon2Time,
+		Memory:  encCfg.Argon2Memory * 1024,
+		Threads: encCfg.Argon2Threads,
+	}.WithDefaults()
+	if header != nil {
+		algorithm, kdf, padding = header.Algorithm, header.KDF, header.Padding
+		params = header.Argon2Params()
+	}
+	if kdf == "" {
+		kdf = crypto.KDFArgon2id
+	}
+	if err := crypto.CheckPolicy(encCfg.Policy, algorithm, kdf); err != nil {
+		return nil, nil, fmt.Errorf("crypto policy violation: %w", err)
+	}
+	if err := crypto.CheckPadding(padding); err != nil {
+		return nil, nil, err
+	}
+
+	// A cached key is only used if the header can vouch for it, so an
+	// agent cannot pick the key a new repository is encrypted under
+	socketPath := agent.DefaultSocketPath()
+	if key, _ := agent.GetKey(socketPath, salt); key != nil && header != nil && header.VerifyKey(key) {
+		encryptor, err := crypto.NewEncryptorFromKey(key, salt)
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := encryptor.SetPadding(padding); err != nil {
+			return nil, nil, err
+		}
+		return encryptor, header, nil
+	}
+
+	passphrase, err := promptPassword(prompt)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read password: %w", err)
+	}
+
+	var key []byte
+	if header == nil {
+		// First use: data is encrypted with a random master key, which the
+		// password unlocks, so passwords can change without touching data
+		if key, err = crypto.GenerateMasterKey(); err != nil {
+			return nil, nil, err
+		}
+		header = crypto.NewEncryptionHeaderFromKey(salt, key, kdf)
+		header.Padding = padding
+		header.EncryptedNames = encCfg.EncryptNames
+		if kdf == crypto.KDFArgon2id {
+			header.Argon2 = &params
+		}
+		if _, err := header.AddKeySlot(key, passphrase, ""); err != nil {
+			return nil, nil, err
+		}
+		if err := saveEncryptionHeader(repoPath, header); err != nil {
+			return nil, nil, err
+		}
+	} else if key, _, err = header.Unlock(passphrase); err != nil {
+		return nil, nil, unlockError(header, err)
+	}
+
+	encryptor, err := crypto.NewEncryptorFromKey(key, salt)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create encryptor: %w", err)
+	}
+	if err := encryptor.SetPadding(padding); err != nil {
+		return nil, nil, err
+	}
+
+	// Hand the key to the agent if one is running on a socket of our own
+	agent.PutKey(socketPath, salt, encryptor.Key())
+	return encryptor, header, nil
+}
+
+// unlockError adds the password hint to a wrong password error
+func unlockError(header *crypto.EncryptionHeader, err error) error {
+	if errors.Is(err, crypto.ErrWrongPassword) && header.Hint != "" {
+		return fmt.Errorf("%w (hint: %s)", err, header.Hint)
+	}
+	return err
+}
+
+// encryptionHeaderPath returns where the repository's encryption header lives
+func encryptionHeaderPath(repoPath string) string {
+	return filepath.Join(repoPath, "config", "encryption.json")
+}
+
+// loadEncryptionHeader reads the encryption header, returning nil if the
+// repository has none yet
+func loadEncryptionHeader(repoPath string) (*crypto.EncryptionHeader, error) {
+	data, err := os.ReadFile(encryptionHeaderPath(repoPath))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read encryption header: %w", err)
+	}
+
+	var header crypto.EncryptionHeader
+	if err := json.Unmarshal(data, &header); err != nil {
+		return nil, fmt.Errorf("invalid encryption header: %w", err)
+	}
+	return &header, nil
+}
+
+// saveEncryptionHeader writes the encryption header
+func saveEncryptionHeader(repoPath string, header *crypto.EncryptionHeader) error {
+	data, err := json.MarshalIndent(header, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// A header cut short would lock every key out, so replace it whole
+	path := encryptionHeaderPath(repoPath)
+	if err := os.WriteFile(path+".tmp", data, 0600); err != nil {
+		return fmt.Errorf("failed to write encryption header: %w", err)
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return fmt.Errorf("failed to write encryption header: %w", err)
+	}
+	return nil
+}
+
+// loadRepoConfig reads the repository's configuration, falling back to the
+// defaults when it has none
+func loadRepoConfig(repoPath string) *config.Config {
+	configPath := filepath.Join(repoPath, "config", "snapsync.yaml")
+	if cfg, err := config.Load(configPath); err == nil {
+		return cfg
+	}
+	return config.DefaultConfig()
+}
+
+// openEncryptor unlocks the repository if encryption is enabled, returning
+// nil for unencrypted repositories
+func openEncryptor(repoPath string, cfg *config.Config, prompt string) (*crypto.Encryptor, error) {
+	if !cfg.Encryption.Enabled {
+		return nil, nil
+	}
+
+	saltData, err := os.ReadFile(filepath.Join(repoPath, "config", "salt"))
+	if err != nil {
+		return nil, fmt.Errorf("repository not encrypted or salt missing")
+	}
+	salt, _ := hex.DecodeString(string(saltData))
+
+	encryptor, _, err := unlockRepo(repoPath, cfg.Encryption, prompt, salt)
+	return encryptor, err
+}
+
+// namesEncrypted reports whether browsing the repository's snapshots needs
+// the key
+func namesEncrypted(repoPath string) bool {
+	header, _ := loadEncryptionHeader(repoPath)
+	return header != nil && header.EncryptedNames
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/snapsync/snapsync/internal/crypto"
+	"github.com/snapsync/snapsync/internal/snapshot"
+	"github.com/snapsync/snapsync/pkg/models"
+	"github.com/spf13/cobra"
+)
+
+func versionsCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "versions [path]",
+		Short: "List every stored version of a file",
+		Long: `Lists the snapshots that contain a path, oldest first, with the file's size,
+modification time and hash, and marks the snapshots where its content changed.
+
+The path is relative to the backup root, or an absolute path under it.`,
+		Example: `  snapsync versions etc/nginx/nginx.conf --repo /path/to/repo`,
+		Args:    cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if repoPath == "" {
+				return fmt.Errorf("repository path required (use --repo)")
+			}
+
+			return runVersions(repoPath, args[0])
+		},
+	}
+
+	return cmd
+}
+
+func runVersions(repoPath, path string) error {
+	// File names are only readable with the key when they are encrypted
+	var encryptor *crypto.Encryptor
+	if namesEncrypted(repoPath) {
+		var err error
+		encryptor, err = openEncryptor(repoPath, loadRepoConfig(repoPath), "Enter repository password: ")
+		if err != nil {
+			return err
+		}
+	}
+
+	mgr, err := snapshot.NewManager(repoPath, nil, encryptor)
+	if err != nil {
+		return fmt.Errorf("failed to open repository: %w", err)
+	}
+
+	versions, err := mgr.Versions(path)
+	if err != nil {
+		return fmt.Errorf("failed to look up %s: %w", path, err)
+	}
+	if len(versions) == 0 {
+		return fmt.Errorf("path not found in any snapshot: %s", path)
+	}
+
+	fmt.Printf("%-20s  %-20s  %10s  %-20s  %-12s  %s\n",
+		"SNAPSHOT", "TIMESTAMP", "SIZE", "MODIFIED", "HASH", "CHANGE")
+
+	var lastHash string
+	for i, version := range versions {
+		node := version.Node
+		hash, size := node.Hash, formatBytes(node.Size)
+		if node.IsDir {
+			hash, size = "-", "<dir>"
+		}
+
+		change := ""
+		switch {
+		case i == 0:
+			change = "added"
+		case hash != lastHash:
+			change = "changed"
+		}
+		lastHash = hash
+
+		fmt.Printf("%-20s  %-20s  %10s  %-20s  %-12s  %s\n",
+			version.SnapshotID,
+			version.Timestamp.Format("2006-01-02 15:04:05"),
+			size,
+			node.ModTime.Format("2006-01-02 15:04:05"),
+			shortHash(hash),
+			change,
+		)
+	}
+
+	fmt.Printf("\nTotal: %d versions\n", len(versions))
+	return nil
+}
+
+// findNode looks up a path in a snapshot, given relative to the backup root
+// or as an absolute path under it
+func findNode(snap *models.Snapshot, path string) *models.FileNode {
+	if snap.Tree == nil || snap.Tree.Files == nil {
+		return nil
+	}
+
+	relPath := filepath.Clean(path)
+	if filepath.IsAbs(relPath) && snap.Tree.Root != nil {
+		rel, err := filepath.Rel(snap.Tree.Root.Path, relPath)
+		if err != nil || strings.HasPrefix(rel, "..") {
+			return nil
+		}
+		relPath = rel
+	}
+
+	return snap.Tree.Files[relPath]
+}
+
+// shortHash abbreviates a content hash for display
+func shortHash(hash string) string {
+	if len(hash) > 12 {
+		return hash[:12]
+	}
+	return hash
+}
@@ -0,0 +1,253 @@
+package agent
+
+import (
+	"bufio"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// DefaultTimeout is how long a cached key stays usable
+const DefaultTimeout = 15 * time.Minute
+
+// SocketEnv overrides the agent socket path
+const SocketEnv = "SNAPSYNC_AGENT_SOCK"
+
+// request is a single agent command, sent as one JSON line
+type request struct {
+	Op   string `json:"op"`             // "get", "put" or "forget"
+	Salt string `json:"salt,omitempty"` // Hex-encoded repository salt
+	Key  string `json:"key,omitempty"`  // Hex-encoded derived key
+}
+
+// response answers a request
+type response struct {
+	Key   string `json:"key,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// DefaultSocketPath returns the socket the agent listens on
+// Without a runtime directory the socket goes in a directory of the user's
+// own under the temporary directory, never directly in it.
+func DefaultSocketPath() string {
+	if path := os.Getenv(SocketEnv); path != "" {
+		return path
+	}
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		return filepath.Join(dir, "snapsync-agent.sock")
+	}
+	return filepath.Join(os.TempDir(), fmt.Sprintf("snapsync-agent-%d", os.Getuid()), "agent.sock")
+}
+
+// checkPrivate refuses a socket another local user could have put in place
+// or could connect to: the socket and its directory must belong to the
+// current user and be closed to group and others
+func checkPrivate(socketPath string) error {
+	for _, path := range []string{filepath.Dir(socketPath), socketPath} {
+		info, err := os.Lstat(path)
+		if err != nil {
+			return err
+		}
+		if err := checkOwner(path, info); err != nil {
+			return fmt.Errorf("refusing agent socket: %w", err)
+		}
+	}
+	return nil
+}
+
+// entry is a cached key and its expiry
+type entry struct {
+	key     []byte
+	expires time.Time
+}
+
+// Server caches derived repository keys in memory
+// Keys are indexed by repository salt, so each repository gets its own key.
+type Server struct {
+	timeout time.Duration
+	mu      sync.Mutex
+	keys    map[string]entry
+}
+
+// NewServer creates an agent that forgets keys after timeout
+func NewServer(timeout time.Duration) *Server {
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	return &Server{
+		timeout: timeout,
+		keys:    make(map[string]entry),
+	}
+}
+
+// Listen creates the agent socket, readable only by the current user
+func Listen(path string) (net.Listener, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create socket directory: %w", err)
+	}
+	dir := filepath.Dir(path)
+	if info, err := os.Lstat(dir); err != nil {
+		return nil, fmt.Errorf("failed to create socket directory: %w", err)
+	} else if err := checkOwner(dir, info); err != nil {
+		return nil, fmt.Errorf("unsafe socket directory: %w", err)
+	}
+
+	if conn, err := net.Dial("unix", path); err == nil {
+		conn.Close()
+		return nil, fmt.Errorf("agent already running at %s", path)
+	}
+	// Replace a socket left behind by an agent that did not shut down
+	os.Remove(path)
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", path, err)
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("failed to secure socket: %w", err)
+	}
+
+	return listener, nil
+}
+
+// Serve answers requests until the listener is closed
+func (s *Server) Serve(listener net.Listener) error {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		go s.handle(conn)
+	}
+}
+
+// handle answers the requests on one connection
+func (s *Server) handle(conn net.Conn) {
+	defer conn.Close()
+
+	scanner := bufio.NewScanner(conn)
+	encoder := json.NewEncoder(conn)
+	for scanner.Scan() {
+		var req request
+		if err := json.Unmarshal(scanner.Bytes(), &req); err != nil {
+			encoder.Encode(response{Error: "invalid request"})
+			return
+		}
+		if err := encoder.Encode(s.dispatch(req)); err != nil {
+			return
+		}
+	}
+}
+
+// dispatch executes a request
+func (s *Server) dispatch(req request) response {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch req.Op {
+	case "get":
+		e, ok := s.keys[req.Salt]
+		if !ok || time.Now().After(e.expires) {
+			delete(s.keys, req.Salt)
+			return response{Error: "no key cached"}
+		}
+		return response{Key: hex.EncodeToString(e.key)}
+
+	case "put":
+		key, err := hex.DecodeString(req.Key)
+		if err != nil || req.Salt == "" {
+			return response{Error: "invalid key"}
+		}
+		s.keys[req.Salt] = entry{key: key, expires: time.Now().Add(s.timeout)}
+		time.AfterFunc(s.timeout, s.expire)
+		return response{}
+
+	case "forget":
+		s.keys = make(map[string]entry)
+		return response{}
+
+	default:
+		return response{Error: fmt.Sprintf("unknown operation: %s", req.Op)}
+	}
+}
+
+// expire drops keys whose timeout has passed
+func (s *Server) expire() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for salt, e := range s.keys {
+		if now.After(e.expires) {
+			delete(s.keys, salt)
+		}
+	}
+}
+
+// GetKey asks the agent for the cached key of the repository with salt
+// Returns nil without error when no agent is running or no key is cached.
+func GetKey(socketPath string, salt []byte) ([]byte, error) {
+	resp, err := call(socketPath, request{Op: "get", Salt: hex.EncodeToString(salt)})
+	if err != nil || resp.Error != "" {
+		return nil, nil
+	}
+	return hex.DecodeString(resp.Key)
+}
+
+// PutKey hands a derived key to the agent
+func PutKey(socketPath string, salt, key []byte) error {
+	resp, err := call(socketPath, request{
+		Op:   "put",
+		Salt: hex.EncodeToString(salt),
+		Key:  hex.EncodeToString(key),
+	})
+	if err != nil {
+		return err
+	}
+	if resp.Error != "" {
+		return fmt.Errorf("agent: %s", resp.Error)
+	}
+	return nil
+}
+
+// Forget tells the agent to drop all cached keys
+func Forget(socketPath string) error {
+	resp, err := call(socketPath, request{Op: "forget"})
+	if err != nil {
+		return err
+	}
+	if resp.Error != "" {
+		return fmt.Errorf("agent: %s", resp.Error)
+	}
+	return nil
+}
+
+// call sends one request to the agent, provided its socket is private to
+// the current user
+func call(socketPath string, req request) (*response, error) {
+	if err := checkPrivate(socketPath); err != nil {
+		return nil, err
+	}
+	conn, err := net.DialTimeout("unix", socketPath, time.Second)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return nil, err
+	}
+
+	var resp response
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
@@ -0,0 +1,24 @@
+//go:build !windows
+
+package agent
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// checkOwner refuses a file another user owns or can reach
+func checkOwner(path string, info os.FileInfo) error {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fmt.Errorf("cannot determine the owner of %s", path)
+	}
+	if int(stat.Uid) != os.Getuid() {
+		return fmt.Errorf("%s is owned by another user", path)
+	}
+	if info.Mode().Perm()&0077 != 0 {
+		return fmt.Errorf("%s is accessible to other users", path)
+	}
+	return nil
+}
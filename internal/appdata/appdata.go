@@ -0,0 +1,201 @@
+package appdata
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Preset describes where a desktop application keeps its data and how its
+// files are backed up while the application may be running
+// Every pattern is slash-separated and matched element by element as a
+// glob. Store patterns match any run of elements of an absolute path, so
+// they find the application's data whether the source is a home directory,
+// /home or the whole system; their first element must be literal. Skip and
+// Capture patterns match from the start of a path inside the store, and
+// cover everything below what they match. SQLite databases need no
+// pattern, since every backup captures them consistently.
+type Preset struct {
+	Description string
+	Stores      []string // The application's data directories
+	Locks       []string // Store files present or locked while it runs
+	Skip        []string // Caches, locks and sockets it recreates
+	Capture     []string // Files it rewrites in place while running
+}
+
+// Presets are the built-in app data presets by name
+var Presets = map[string]*Preset{
+	"firefox": {
+		Description: "Firefox profiles: caches and locks skipped, session and site storage copied while it runs",
+		Stores: []string{
+			".mozilla/firefox/*",
+			"Library/Application Support/Firefox/Profiles/*",
+			"AppData/Roaming/Mozilla/Firefox/Profiles/*",
+		},
+		Locks: []string{"lock", ".parentlock", "parent.lock"},
+		Skip: []string{
+			"lock", ".parentlock", "parent.lock",
+			"cache2", "startupCache", "shader-cache", "thumbnails",
+			"crashes", "minidumps", "saved-telemetry-pings", "storage/temporary",
+		},
+		Capture: []string{"sessionstore-backups", "storage/default"},
+	},
+	"chromium": {
+		Description: "Chrome, Chromium, Edge and Brave profiles: caches and locks skipped, LevelDB stores copied while they run",
+		Stores: []string{
+			".config/google-chrome", ".config/chromium",
+			".config/microsoft-edge", ".config/BraveSoftware/Brave-Browser",
+			"Library/Application Support/Google/Chrome",
+			"Library/Application Support/Chromium",
+			"Library/Application Support/Microsoft Edge",
+			"Library/Application Support/BraveSoftware/Brave-Browser",
+			"AppData/Local/Google/Chrome/User Data",
+			"AppData/Local/Chromium/User Data",
+			"AppData/Local/Microsoft/Edge/User Data",
+			"AppData/Local/BraveSoftware/Brave-Browser/User Data",
+		},
+		Locks: []string{"SingletonLock", "lockfile"},
+		Skip: []string{
+			"SingletonLock", "SingletonSocket", "SingletonCookie", "lockfile",
+			"*/Cache", "*/Code Cache", "*/GPUCache", "*/DawnCache", "*/DawnGraphiteCache",
+			"*/Service Worker/CacheStorage", "*/Service Worker/ScriptCache",
+			"GrShaderCache", "ShaderCache", "GraphiteDawnCache", "Crashpad",
+			"component_crx_cache", "extensions_crx_cache",
+		},
+		Capture: []string{
+			"*/Local Storage", "*/Session Storage", "*/IndexedDB", "*/Sessions",
+			"*/Extension State", "*/Sync Data", "*/shared_proto_db",
+		},
+	},
+	"thunderbird": {
+		Description: "Thunderbird profiles: caches and locks skipped, mail folders copied while it runs",
+		Stores: []string{
+			".thunderbird/*",
+			"Library/Thunderbird/Profiles/*",
+			"AppData/Roaming/Thunderbird/Profiles/*",
+		},
+		Locks: []string{"lock", ".parentlock", "parent.lock"},
+		Skip: []string{
+			"lock", ".parentlock", "parent.lock",
+			"cache2", "startupCache", "crashes", "minidumps",
+		},
+		Capture: []string{"Mail", "ImapMail", "News", "session.json"},
+	},
+	"apple-mail": {
+		Description: "Apple Mail: mailboxes and mail data copied, since it keeps no lock file to tell whether it runs",
+		Stores:      []string{"Library/Mail"},
+		Capture:     []string{"V*"},
+	},
+}
+
+// Names returns the names of the built-in app data presets, sorted
+func Names() []string {
+	names := make([]string, 0, len(Presets))
+	for name := range Presets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Action is how a backup treats a file in an application's store
+type Action int
+
+const (
+	// Skip leaves the file out, as an exclusion would
+	Skip Action = iota + 1
+	// Capture reads the file from a steady copy while the application runs
+	Capture
+)
+
+// Match is a preset rule matching a path
+type Match struct {
+	Name    string // Name of the preset
+	Preset  *Preset
+	Store   string // The store the path is in
+	Action  Action
+	Pattern string // The Skip or Capture pattern that matched
+}
+
+// Rules applies a set of presets to the paths of a backup source
+// A nil *Rules matches nothing.
+type Rules struct {
+	stores map[string][]storePattern // By first element
+}
+
+// storePattern is a store pattern of a preset, split into elements
+type storePattern struct {
+	name   string
+	preset *Preset
+	elems  []string
+}
+
+// New returns the rules of the named presets
+func New(names []string) (*Rules, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+	r := &Rules{stores: make(map[string][]storePattern)}
+	for _, name := range names {
+		preset, ok := Presets[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown app preset %q (available: %s)", name, strings.Join(Names(), ", "))
+		}
+		for _, store := range preset.Stores {
+			elems := strings.Split(store, "/")
+			r.stores[elems[0]] = append(r.stores[elems[0]], storePattern{name: name, preset: preset, elems: elems})
+		}
+	}
+	return r, nil
+}
+
+// Match returns the rule for the file or directory at an absolute path, or
+// nil if the path is in no store or no rule of the store's preset matches
+func (r *Rules) Match(p string) *Match {
+	if r == nil {
+		return nil
+	}
+	elems := strings.Split(filepath.ToSlash(p), "/")
+	for i, elem := range elems {
+		for _, store := range r.stores[elem] {
+			end := i + len(store.elems)
+			if end > len(elems) || !matchElems(store.elems[1:], elems[i+1:end]) {
+				continue
+			}
+			rest := elems[end:]
+			dir := filepath.FromSlash(strings.Join(elems[:end], "/"))
+			if pattern, ok := matchAny(store.preset.Skip, rest); ok {
+				return &Match{Name: store.name, Preset: store.preset, Store: dir, Action: Skip, Pattern: pattern}
+			}
+			if pattern, ok := matchAny(store.preset.Capture, rest); ok {
+				return &Match{Name: store.name, Preset: store.preset, Store: dir, Action: Capture, Pattern: pattern}
+			}
+			return nil
+		}
+	}
+	return nil
+}
+
+// matchAny returns the first pattern matching the leading elements of
+// elems
+func matchAny(patterns, elems []string) (string, bool) {
+	for _, pattern := range patterns {
+		split := strings.Split(pattern, "/")
+		if len(split) <= len(elems) && matchElems(split, elems[:len(split)]) {
+			return pattern, true
+		}
+	}
+	return "", false
+}
+
+// matchElems matches elems against patterns of the same length
+func matchElems(patterns, elems []string) bool {
+	for i, pattern := range patterns {
+		if ok, _ := path.Match(pattern, elems[i]); !ok {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,8 @@
+//go:build !unix && !windows
+
+package appdata
+
+// held cannot tell lock holders apart on this system
+func held(path string) bool {
+	return false
+}
@@ -0,0 +1,26 @@
+//go:build unix
+
+package appdata
+
+import (
+	"io"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// held reports whether another process holds a POSIX lock on the file at
+// path, as Firefox does on .parentlock
+func held(path string) bool {
+	file, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+
+	lock := unix.Flock_t{Type: unix.F_WRLCK, Whence: io.SeekStart}
+	if err := unix.FcntlFlock(file.Fd(), unix.F_GETLK, &lock); err != nil {
+		return false
+	}
+	return lock.Type != unix.F_UNLCK
+}
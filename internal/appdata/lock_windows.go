@@ -0,0 +1,19 @@
+package appdata
+
+import (
+	"errors"
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// held reports whether another process has the file at path open without
+// sharing, as Firefox does with parent.lock and Chromium with lockfile
+func held(path string) bool {
+	file, err := os.Open(path)
+	if err != nil {
+		return errors.Is(err, windows.ERROR_SHARING_VIOLATION) || errors.Is(err, windows.ERROR_LOCK_VIOLATION)
+	}
+	file.Close()
+	return false
+}
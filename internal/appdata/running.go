@@ -0,0 +1,79 @@
+package appdata
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// copyAttempts bounds retries when a file changes mid-copy
+const copyAttempts = 5
+
+// ErrChanging is returned by Copy for a file that never held still
+var ErrChanging = errors.New("file kept changing during copy")
+
+// Running reports whether the application of a preset has the store at dir
+// open, judged by its lock files
+// Firefox and Chromium leave a symlink naming their host and process while
+// they run, or hold a lock on a file; a preset without lock files is
+// assumed to be running.
+func (p *Preset) Running(dir string) bool {
+	if len(p.Locks) == 0 {
+		return true
+	}
+	for _, name := range p.Locks {
+		lockPath := filepath.Join(dir, filepath.FromSlash(name))
+		info, err := os.Lstat(lockPath)
+		if err != nil {
+			continue
+		}
+		if info.Mode()&os.ModeSymlink != 0 || held(lockPath) {
+			return true
+		}
+	}
+	return false
+}
+
+// Copy copies the file at src to dst, retrying while the file changes
+// during the copy
+// A file whose size and modification time are the same before and after
+// the copy was not written to while it was read.
+func Copy(src, dst string) error {
+	for attempt := 0; attempt < copyAttempts; attempt++ {
+		before, err := os.Stat(src)
+		if err != nil {
+			return err
+		}
+		if err := copyFile(src, dst); err != nil {
+			return err
+		}
+		after, err := os.Stat(src)
+		if err != nil {
+			return err
+		}
+		if after.Size() == before.Size() && after.ModTime().Equal(before.ModTime()) {
+			return nil
+		}
+	}
+	os.Remove(dst)
+	return ErrChanging
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
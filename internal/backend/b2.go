@@ -0,0 +1,693 @@
+package backend
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// b2AuthURL is where B2 accounts are authorized
+	b2AuthURL = "https://api.backblazeb2.com"
+
+	// b2MaxParts is the most parts B2 accepts for one large file
+	b2MaxParts = 10000
+
+	// b2UploadAttempts is how often an upload is tried with a fresh upload URL
+	b2UploadAttempts = 5
+)
+
+// B2Backend implements Backend with the native Backblaze B2 API
+// Objects larger than the recommended part size are uploaded as large files,
+// one part at a time, and every upload carries its SHA1 so B2 verifies it.
+type B2Backend struct {
+	client       *http.Client
+	authURL      string
+	keyID        string
+	appKey       string
+	bucket       string
+	maxBandwidth int64
+
+	mu       sync.Mutex
+	auth     b2Auth
+	bucketID string
+}
+
+// B2Config contains B2 connection configuration
+type B2Config struct {
+	Bucket         string
+	KeyID          string // Application key ID
+	ApplicationKey string
+	Endpoint       string // Authorization server, "" for Backblaze
+	MaxBandwidth   int64  // Bytes/sec, 0 = unlimited
+}
+
+// b2Auth is the result of b2_authorize_account
+type b2Auth struct {
+	AccountID           string `json:"accountId"`
+	Token               string `json:"authorizationToken"`
+	APIURL              string `json:"apiUrl"`
+	DownloadURL         string `json:"downloadUrl"`
+	RecommendedPartSize int64  `json:"recommendedPartSize"`
+	Allowed             struct {
+		BucketID   string `json:"bucketId"`
+		BucketName string `json:"bucketName"`
+	} `json:"allowed"`
+}
+
+// b2UploadURL is where one upload, or the parts of one large file, go
+type b2UploadURL struct {
+	URL   string `json:"uploadUrl"`
+	Token string `json:"authorizationToken"`
+}
+
+// b2File is a file or file version in a B2 listing
+type b2File struct {
+	FileID   string `json:"fileId"`
+	FileName string `json:"fileName"`
+	Action   string `json:"action"`
+}
+
+// b2Error is an error response from B2
+type b2Error struct {
+	Status  int    `json:"status"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *b2Error) Error() string {
+	return fmt.Sprintf("B2 %s: %s (%d)", e.Code, e.Message, e.Status)
+}
+
+// NewB2Backend authorizes with an application key and opens a bucket
+// A key restricted to one bucket must be restricted to this one.
+func NewB2Backend(cfg B2Config) (*B2Backend, error) {
+	if cfg.Bucket == "" || cfg.KeyID == "" || cfg.ApplicationKey == "" {
+		return nil, fmt.Errorf("B2 needs a bucket, key ID and application key")
+	}
+
+	authURL := b2AuthURL
+	if cfg.Endpoint != "" {
+		authURL = strings.TrimSuffix(cfg.Endpoint, "/")
+	}
+
+	b := &B2Backend{
+		client:       &http.Client{Timeout: 30 * time.Minute},
+		authURL:      authURL,
+		keyID:        cfg.KeyID,
+		appKey:       cfg.ApplicationKey,
+		bucket:       cfg.Bucket,
+		maxBandwidth: cfg.MaxBandwidth,
+	}
+	if err := b.authorize(); err != nil {
+		return nil, err
+	}
+	if err := b.findBucket(); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// authorize gets a new account authorization token
+func (b *B2Backend) authorize() error {
+	req, err := http.NewRequest(http.MethodGet, b.authURL+"/b2api/v2/b2_authorize_account", nil)
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(b.keyID, b.appKey)
+
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("B2 authorization failed: %w", err)
+	}
+	var auth b2Auth
+	if err := decodeB2(resp, &auth); err != nil {
+		return fmt.Errorf("B2 authorization failed: %w", err)
+	}
+
+	b.mu.Lock()
+	b.auth = auth
+	b.mu.Unlock()
+	return nil
+}
+
+// findBucket looks up the ID of the configured bucket
+func (b *B2Backend) findBucket() error {
+	if allowed := b.auth.Allowed; allowed.BucketID != "" {
+		if allowed.BucketName != b.bucket {
+			return fmt.Errorf("application key is restricted to bucket %s", allowed.BucketName)
+		}
+		b.bucketID = allowed.BucketID
+		return nil
+	}
+
+	var list struct {
+		Buckets []struct {
+			BucketID string `json:"bucketId"`
+		} `json:"buckets"`
+	}
+	err := b.api("b2_list_buckets", map[string]string{
+		"accountId":  b.auth.AccountID,
+		"bucketName": b.bucket,
+	}, &list)
+	if err != nil {
+		return err
+	}
+	if len(list.Buckets) == 0 {
+		return fmt.Errorf("B2 bucket not found: %s", b.bucket)
+	}
+	b.bucketID = list.Buckets[0].BucketID
+	return nil
+}
+
+// Put uploads data, as a large file in parts if it is bigger than one part
+func (b *B2Backend) Put(key string, data io.Reader, size int64) error {
+	if b.maxBandwidth > 0 {
+		data = newThrottledReader(data, b.maxBandwidth)
+	}
+
+	ps := b.partSize(size)
+	bufSize := ps
+	if size >= 0 && size < ps {
+		bufSize = size
+	}
+	buf := make([]byte, bufSize)
+	n, err := io.ReadFull(data, buf)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return fmt.Errorf("failed to read data: %w", err)
+	}
+	if err != nil {
+		return b.uploadFile(key, buf[:n])
+	}
+
+	// A large file needs at least two parts
+	var next [1]byte
+	if m, _ := io.ReadFull(data, next[:]); m == 0 {
+		return b.uploadFile(key, buf)
+	}
+	return b.uploadLarge(key, buf, io.MultiReader(bytes.NewReader(next[:]), data), size)
+}
+
+// partSize returns the part size for a large file, growing B2's
+// recommendation for files that would need more than b2MaxParts parts
+func (b *B2Backend) partSize(size int64) int64 {
+	b.mu.Lock()
+	ps := b.auth.RecommendedPartSize
+	b.mu.Unlock()
+	if ps <= 0 {
+		ps = 100 * 1024 * 1024
+	}
+	for size/ps >= b2MaxParts {
+		ps *= 2
+	}
+	return ps
+}
+
+// uploadFile uploads a small file in one request
+func (b *B2Backend) uploadFile(key string, data []byte) error {
+	sum := sha1.Sum(data)
+	headers := map[string]string{
+		"X-Bz-File-Name":    b2Name(key),
+		"Content-Type":      "b2/x-auto",
+		"X-Bz-Content-Sha1": hex.EncodeToString(sum[:]),
+	}
+
+	var target *b2UploadURL
+	err := b.send(&target, func() (*b2UploadURL, error) {
+		var t b2UploadURL
+		err := b.api("b2_get_upload_url", map[string]string{"bucketId": b.bucketID}, &t)
+		return &t, err
+	}, headers, data)
+	if err != nil {
+		return fmt.Errorf("B2 upload of %s failed: %w", key, err)
+	}
+	return nil
+}
+
+// uploadLarge uploads a large file part by part, starting with first
+// An unfinished large file of the same name and size left by an earlier
+// run is continued, skipping every part B2 already holds with the same SHA1.
+func (b *B2Backend) uploadLarge(key string, first []byte, rest io.Reader, size int64) error {
+	ps := int64(len(first))
+
+	fileID, done, err := b.resumeLarge(key, size, ps)
+	if err != nil {
+		return err
+	}
+	if fileID == "" {
+		var started b2File
+		err := b.api("b2_start_large_file", map[string]string{
+			"bucketId":    b.bucketID,
+			"fileName":    key,
+			"contentType": "b2/x-auto",
+		}, &started)
+		if err != nil {
+			return fmt.Errorf("B2 large file upload failed to start: %w", err)
+		}
+		fileID = started.FileID
+	}
+
+	getURL := func() (*b2UploadURL, error) {
+		var t b2UploadURL
+		err := b.api("b2_get_upload_part_url", map[string]string{"fileId": fileID}, &t)
+		return &t, err
+	}
+
+	var target *b2UploadURL
+	var sums []string
+	buf := first
+	for number := 1; ; number++ {
+		sum := sha1.Sum(buf)
+		hexSum := hex.EncodeToString(sum[:])
+		if done[number] != hexSum {
+			headers := map[string]string{
+				"X-Bz-Part-Number":  strconv.Itoa(number),
+				"X-Bz-Content-Sha1": hexSum,
+			}
+			if err := b.send(&target, getURL, headers, buf); err != nil {
+				return fmt.Errorf("B2 upload of part %d of %s failed: %w", number, key, err)
+			}
+		}
+		sums = append(sums, hexSum)
+
+		n, err := io.ReadFull(rest, first[:cap(first)])
+		if err == io.EOF {
+			break
+		}
+		if err != nil && err != io.ErrUnexpectedEOF {
+			return fmt.Errorf("failed to read data: %w", err)
+		}
+		buf = first[:n]
+	}
+
+	err = b.api("b2_finish_large_file", map[string]interface{}{
+		"fileId":        fileID,
+		"partSha1Array": sums,
+	}, nil)
+	if err != nil {
+		return fmt.Errorf("B2 large file upload failed to complete: %w", err)
+	}
+	return nil
+}
+
+// resumeLarge finds an unfinished large file to continue and the SHA1 of
+// each part it holds
+// Unfinished uploads of the key that cannot be continued are cancelled.
+func (b *B2Backend) resumeLarge(key string, size, ps int64) (string, map[int]string, error) {
+	var list struct {
+		Files []b2File `json:"files"`
+	}
+	err := b.api("b2_list_unfinished_large_files", map[string]interface{}{
+		"bucketId":     b.bucketID,
+		"namePrefix":   key,
+		"maxFileCount": 100,
+	}, &list)
+	if err != nil {
+		return "", nil, fmt.Errorf("B2 list of unfinished uploads failed: %w", err)
+	}
+
+	var fileID string
+	var done map[int]string
+	for _, file := range list.Files {
+		if file.FileName != key {
+			continue
+		}
+		if fileID == "" && size >= 0 {
+			if parts, ok := b.uploadedParts(file.FileID, size, ps); ok {
+				fileID, done = file.FileID, parts
+				continue
+			}
+		}
+		if err := b.api("b2_cancel_large_file", map[string]string{"fileId": file.FileID}, nil); err != nil {
+			return "", nil, fmt.Errorf("B2 cancel of unfinished upload failed: %w", err)
+		}
+	}
+	return fileID, done, nil
+}
+
+// uploadedParts lists the parts of an unfinished large file, and reports
+// whether they fit an upload of size bytes in parts of ps bytes
+func (b *B2Backend) uploadedParts(fileID string, size, ps int64) (map[int]string, bool) {
+	count := int((size + ps - 1) / ps)
+	parts := make(map[int]string)
+
+	start := 1
+	for {
+		var list struct {
+			Parts []struct {
+				PartNumber    int    `json:"partNumber"`
+				ContentLength int64  `json:"contentLength"`
+				ContentSha1   string `json:"contentSha1"`
+			} `json:"parts"`
+			NextPartNumber *int `json:"nextPartNumber"`
+		}
+		err := b.api("b2_list_parts", map[string]interface{}{
+			"fileId":          fileID,
+			"startPartNumber": start,
+			"maxPartCount":    1000,
+		}, &list)
+		if err != nil {
+			return nil, false
+		}
+
+		for _, p := range list.Parts {
+			want := ps
+			if p.PartNumber == count {
+				want = size - int64(count-1)*ps
+			}
+			if p.PartNumber > count || p.ContentLength != want {
+				return nil, false
+			}
+			parts[p.PartNumber] = p.ContentSha1
+		}
+		if list.NextPartNumber == nil {
+			return parts, true
+		}
+		start = *list.NextPartNumber
+	}
+}
+
+// send uploads data to an upload URL, fetching a new URL and trying again
+// when B2 asks for that; target is the URL to use, kept for the next call
+func (b *B2Backend) send(target **b2UploadURL, getURL func() (*b2UploadURL, error), headers map[string]string, data []byte) error {
+	var lastErr error
+	for attempt := 0; attempt < b2UploadAttempts; attempt++ {
+		if attempt > 0 {
+			time.Sleep(time.Duration(attempt) * time.Second)
+		}
+		if *target == nil {
+			t, err := getURL()
+			if err != nil {
+				return err
+			}
+			*target = t
+		}
+
+		req, err := http.NewRequest(http.MethodPost, (*target).URL, bytes.NewReader(data))
+		if err != nil {
+			return err
+		}
+		req.ContentLength = int64(len(data))
+		req.Header.Set("Authorization", (*target).Token)
+		for name, value := range headers {
+			req.Header.Set(name, value)
+		}
+
+		resp, err := b.client.Do(req)
+		if err == nil {
+			if err = decodeB2(resp, nil); err == nil {
+				return nil
+			}
+		}
+
+		// Upload URLs are busy, expire or fail; B2 wants a new one then
+		var apiErr *b2Error
+		if errors.As(err, &apiErr) && apiErr.Status != http.StatusUnauthorized &&
+			apiErr.Status != http.StatusRequestTimeout && apiErr.Status != http.StatusTooManyRequests &&
+			apiErr.Status < 500 {
+			return err
+		}
+		*target = nil
+		lastErr = err
+	}
+	return lastErr
+}
+
+// Get downloads data, checking its SHA1 at the end of the stream
+func (b *B2Backend) Get(key string) (io.ReadCloser, error) {
+	resp, err := b.download(http.MethodGet, key)
+	if err != nil {
+		return nil, fmt.Errorf("download failed: %w", err)
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		resp.Body.Close()
+		return nil, fmt.Errorf("key not found: %s", key)
+	default:
+		return nil, fmt.Errorf("download of %s failed: %w", key, decodeB2(resp, nil))
+	}
+
+	// Large files carry their SHA1 as file info, if the uploader set it
+	want := strings.TrimPrefix(resp.Header.Get("X-Bz-Content-Sha1"), "unverified:")
+	if want == "none" || want == "" {
+		want = resp.Header.Get("X-Bz-Info-Large_file_sha1")
+	}
+	if len(want) != 2*sha1.Size {
+		return resp.Body, nil
+	}
+	return &b2Reader{ReadCloser: resp.Body, hash: sha1.New(), want: want, key: key}, nil
+}
+
+// Delete removes every version of a key
+func (b *B2Backend) Delete(key string) error {
+	var list struct {
+		Files []b2File `json:"files"`
+	}
+	err := b.api("b2_list_file_versions", map[string]interface{}{
+		"bucketId":      b.bucketID,
+		"startFileName": key,
+		"prefix":        key,
+		"maxFileCount":  100,
+	}, &list)
+	if err != nil {
+		return fmt.Errorf("B2 delete failed: %w", err)
+	}
+
+	for _, file := range list.Files {
+		if file.FileName != key || file.Action == "start" {
+			continue
+		}
+		err := b.api("b2_delete_file_version", map[string]string{
+			"fileName": file.FileName,
+			"fileId":   file.FileID,
+		}, nil)
+		var apiErr *b2Error
+		if err != nil && !(errors.As(err, &apiErr) && apiErr.Status == http.StatusNotFound) {
+			return fmt.Errorf("B2 delete failed: %w", err)
+		}
+	}
+	return nil
+}
+
+// List returns all keys with the given prefix
+func (b *B2Backend) List(prefix string) ([]string, error) {
+	infos, err := b.ListInfo(prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, len(infos))
+	for i, info := range infos {
+		keys[i] = info.Key
+	}
+	return keys, nil
+}
+
+// ListInfo describes every file with the given prefix, with the SHA1 B2
+// keeps for files uploaded in one piece
+func (b *B2Backend) ListInfo(prefix string) ([]ObjectInfo, error) {
+	var infos []ObjectInfo
+	var start *string
+	for {
+		var list struct {
+			Files []struct {
+				b2File
+				ContentLength int64  `json:"contentLength"`
+				ContentSha1   string `json:"contentSha1"`
+			} `json:"files"`
+			NextFileName *string `json:"nextFileName"`
+		}
+		req := map[string]interface{}{
+			"bucketId":     b.bucketID,
+			"prefix":       prefix,
+			"maxFileCount": 10000,
+		}
+		if start != nil {
+			req["startFileName"] = *start
+		}
+		if err := b.api("b2_list_file_names", req, &list); err != nil {
+			return nil, fmt.Errorf("B2 list failed: %w", err)
+		}
+
+		for _, file := range list.Files {
+			if file.Action != "upload" {
+				continue
+			}
+			info := ObjectInfo{Key: file.FileName, Size: file.ContentLength}
+			if sum := strings.TrimPrefix(file.ContentSha1, "unverified:"); len(sum) == 2*sha1.Size {
+				info.Checksum = "sha1:" + sum
+			}
+			infos = append(infos, info)
+		}
+		if list.NextFileName == nil {
+			return infos, nil
+		}
+		start = list.NextFileName
+	}
+}
+
+// Exists checks if a key exists
+func (b *B2Backend) Exists(key string) (bool, error) {
+	resp, err := b.download(http.MethodHead, key)
+	if err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	default:
+		return false, fmt.Errorf("B2 returned %s", resp.Status)
+	}
+}
+
+// Size returns the size of an object
+func (b *B2Backend) Size(key string) (int64, error) {
+	resp, err := b.download(http.MethodHead, key)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("failed to stat %s: B2 returned %s", key, resp.Status)
+	}
+	return resp.ContentLength, nil
+}
+
+// Close releases idle connections
+func (b *B2Backend) Close() error {
+	b.client.CloseIdleConnections()
+	return nil
+}
+
+// api calls a B2 API operation, authorizing again if the token expired
+func (b *B2Backend) api(name string, body, out interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	for attempt := 0; ; attempt++ {
+		b.mu.Lock()
+		auth := b.auth
+		b.mu.Unlock()
+
+		req, err := http.NewRequest(http.MethodPost, auth.APIURL+"/b2api/v2/"+name, bytes.NewReader(data))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", auth.Token)
+
+		resp, err := b.client.Do(req)
+		if err != nil {
+			return fmt.Errorf("B2 %s failed: %w", name, err)
+		}
+		err = decodeB2(resp, out)
+		if attempt == 0 && expiredAuth(err) {
+			if err := b.authorize(); err != nil {
+				return err
+			}
+			continue
+		}
+		return err
+	}
+}
+
+// download requests a file by name, authorizing again if the token expired
+func (b *B2Backend) download(method, key string) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		b.mu.Lock()
+		auth := b.auth
+		b.mu.Unlock()
+
+		req, err := http.NewRequest(method, auth.DownloadURL+"/file/"+url.PathEscape(b.bucket)+"/"+b2Name(key), nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", auth.Token)
+
+		resp, err := b.client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if attempt == 0 && resp.StatusCode == http.StatusUnauthorized {
+			resp.Body.Close()
+			if err := b.authorize(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		return resp, nil
+	}
+}
+
+// b2Name percent-encodes a key for URLs and the X-Bz-File-Name header,
+// keeping its slashes
+func b2Name(key string) string {
+	segments := strings.Split(key, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+// decodeB2 reads a B2 response into out, or the error it reports
+func decodeB2(resp *http.Response, out interface{}) error {
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		apiErr := &b2Error{Status: resp.StatusCode}
+		if resp.Request.Method == http.MethodHead ||
+			json.NewDecoder(io.LimitReader(resp.Body, 1<<16)).Decode(apiErr) != nil || apiErr.Code == "" {
+			apiErr.Code, apiErr.Message = "error", resp.Status
+		}
+		return apiErr
+	}
+	if out == nil {
+		io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to parse B2 response: %w", err)
+	}
+	return nil
+}
+
+// expiredAuth reports whether B2 rejected a call for an expired token
+func expiredAuth(err error) bool {
+	var apiErr *b2Error
+	return errors.As(err, &apiErr) && apiErr.Status == http.StatusUnauthorized &&
+		(apiErr.Code == "expired_auth_token" || apiErr.Code == "bad_auth_token")
+}
+
+// b2Reader checks the SHA1 of a download when it reaches the end
+type b2Reader struct {
+	io.ReadCloser
+	hash hash.Hash
+	want string
+	key  string
+}
+
+func (r *b2Reader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.hash.Write(p[:n])
+	if err == io.EOF {
+		if got := hex.EncodeToString(r.hash.Sum(nil)); got != r.want {
+			return n, fmt.Errorf("SHA1 mismatch downloading %s: got %s, want %s", r.key, got, r.want)
+		}
+	}
+	return n, err
+}
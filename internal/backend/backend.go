@@ -1,7 +1,9 @@
 package backend
 
 import (
+	"errors"
 	"io"
+	"time"
 )
 
 // Backend defines the interface for storage backends
@@ -28,6 +30,42 @@ type Backend interface {
 	Close() error
 }
 
+// BatchExister is implemented by backends that can check many keys in one
+// round trip
+type BatchExister interface {
+	// ExistsMany reports which of the keys exist
+	ExistsMany(keys []string) (map[string]bool, error)
+}
+
+// ExistsMany checks many keys at once, falling back to one Exists call per
+// key for backends without batch support
+func ExistsMany(b Backend, keys []string) (map[string]bool, error) {
+	if batch, ok := b.(BatchExister); ok {
+		return batch.ExistsMany(keys)
+	}
+
+	result := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		exists, err := b.Exists(key)
+		if err != nil {
+			return nil, err
+		}
+		result[key] = exists
+	}
+	return result, nil
+}
+
+// ErrImmutable is returned by backends configured to refuse deletes
+var ErrImmutable = errors.New("storage is immutable")
+
+// RetentionLocker is implemented by backends that can lock objects against
+// deletion, such as S3 Object Lock in compliance mode
+type RetentionLocker interface {
+	// LockUntil prevents the object at key from being deleted or
+	// overwritten before until
+	LockUntil(key string, until time.Time) error
+}
+
 // ProgressCallback is called with upload/download progress
 type ProgressCallback func(bytesTransferred int64, totalBytes int64)
 
@@ -37,3 +75,46 @@ type BackendConfig struct {
 	OnProgress   ProgressCallback
 	Retries      int
 }
+
+// ObjectInfo describes an object in a listing
+type ObjectInfo struct {
+	Key  string
+	Size int64
+	// Checksum of the stored bytes as "md5:<hex>" or "sha1:<hex>", or ""
+	// where the backend keeps none that is usable
+	Checksum string
+	// Provider's tag for the object's current version, which changes
+	// whenever it is written, and when it was written; empty where the
+	// listing has neither
+	ETag    string
+	ModTime time.Time
+}
+
+// InfoLister is implemented by backends whose listings include object sizes
+// and, where available, checksums
+type InfoLister interface {
+	// ListInfo describes every object with the given prefix
+	ListInfo(prefix string) ([]ObjectInfo, error)
+}
+
+// ListInfo describes the objects with the given prefix, falling back to one
+// Size call per key for backends without InfoLister
+func ListInfo(b Backend, prefix string) ([]ObjectInfo, error) {
+	if lister, ok := b.(InfoLister); ok {
+		return lister.ListInfo(prefix)
+	}
+
+	keys, err := b.List(prefix)
+	if err != nil {
+		return nil, err
+	}
+	infos := make([]ObjectInfo, 0, len(keys))
+	for _, key := range keys {
+		size, err := b.Size(key)
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, ObjectInfo{Key: key, Size: size})
+	}
+	return infos, nil
+}
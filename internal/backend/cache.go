@@ -0,0 +1,327 @@
+package backend
+
+import (
+	"container/list"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CacheBackend keeps local copies of the objects read from another backend,
+// so objects fetched again are served from disk
+// The least recently used copies are evicted once the cache outgrows its
+// size limit. Writes and deletes go straight to the backend and drop the
+// cached copy.
+type CacheBackend struct {
+	inner   Backend
+	dir     string
+	maxSize int64
+
+	mu      sync.Mutex
+	lru     *list.List // Most recently used first
+	entries map[string]*list.Element
+	size    int64
+}
+
+// cacheEntry is one cached object
+type cacheEntry struct {
+	key  string
+	size int64
+}
+
+// NewCacheBackend wraps inner with a cache of up to maxSize bytes in dir
+// Copies left in dir by earlier runs are reused, oldest access first out.
+func NewCacheBackend(inner Backend, dir string, maxSize int64) (*CacheBackend, error) {
+	if maxSize <= 0 {
+		return nil, fmt.Errorf("cache size must be positive")
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create cache directory: %w", err)
+	}
+
+	c := &CacheBackend{
+		inner:   inner,
+		dir:     dir,
+		maxSize: maxSize,
+		lru:     list.New(),
+		entries: make(map[string]*list.Element),
+	}
+	if err := c.load(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// load rebuilds the LRU list from the files in the cache directory, using
+// their modification times, which are bumped on every hit
+func (c *CacheBackend) load() error {
+	type file struct {
+		key     string
+		size    int64
+		modTime time.Time
+	}
+	var files []file
+
+	err := filepath.Walk(c.dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		// Downloads cut short by a crash
+		if strings.HasPrefix(info.Name(), ".fetch-") {
+			os.Remove(path)
+			return nil
+		}
+		rel, err := filepath.Rel(c.dir, path)
+		if err != nil {
+			return err
+		}
+		files = append(files, file{filepath.ToSlash(rel), info.Size(), info.ModTime()})
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to read cache: %w", err)
+	}
+
+	sort.Slice(files, func(i, j int) bool { return files[i].modTime.After(files[j].modTime) })
+	for _, f := range files {
+		c.entries[f.key] = c.lru.PushBack(&cacheEntry{key: f.key, size: f.size})
+		c.size += f.size
+	}
+	c.mu.Lock()
+	c.evict()
+	c.mu.Unlock()
+	return nil
+}
+
+// Unwrap returns the backend behind the cache
+func (c *CacheBackend) Unwrap() Backend {
+	return c.inner
+}
+
+// Put stores data in the backend
+func (c *CacheBackend) Put(key string, data io.Reader, size int64) error {
+	c.drop(key)
+	return c.inner.Put(key, data, size)
+}
+
+// Get serves data from the cache, or reads it from the backend and keeps a
+// copy as it streams
+func (c *CacheBackend) Get(key string) (io.ReadCloser, error) {
+	path, err := c.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.hit(key) {
+		if file, err := os.Open(path); err == nil {
+			now := time.Now()
+			os.Chtimes(path, now, now)
+			return file, nil
+		}
+		// Removed behind our back
+		c.drop(key)
+	}
+
+	rc, err := c.inner.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return rc, nil
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".fetch-*")
+	if err != nil {
+		return rc, nil
+	}
+	return &cacheFill{ReadCloser: rc, cache: c, key: key, path: path, tmp: tmp}, nil
+}
+
+// Delete removes data from the backend and the cache
+func (c *CacheBackend) Delete(key string) error {
+	c.drop(key)
+	return c.inner.Delete(key)
+}
+
+// List returns the backend's keys with the given prefix
+func (c *CacheBackend) List(prefix string) ([]string, error) {
+	return c.inner.List(prefix)
+}
+
+// ListInfo describes the backend's objects with the given prefix
+func (c *CacheBackend) ListInfo(prefix string) ([]ObjectInfo, error) {
+	return ListInfo(c.inner, prefix)
+}
+
+// Exists reports cached keys as present without asking the backend
+func (c *CacheBackend) Exists(key string) (bool, error) {
+	if c.hit(key) {
+		return true, nil
+	}
+	return c.inner.Exists(key)
+}
+
+// ExistsMany asks the backend only about the keys that are not cached
+func (c *CacheBackend) ExistsMany(keys []string) (map[string]bool, error) {
+	result := make(map[string]bool, len(keys))
+	var uncached []string
+	for _, key := range keys {
+		if c.hit(key) {
+			result[key] = true
+		} else {
+			uncached = append(uncached, key)
+		}
+	}
+	if len(uncached) == 0 {
+		return result, nil
+	}
+
+	found, err := ExistsMany(c.inner, uncached)
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range uncached {
+		result[key] = found[key]
+	}
+	return result, nil
+}
+
+// Size returns the size of a cached object, or asks the backend
+func (c *CacheBackend) Size(key string) (int64, error) {
+	c.mu.Lock()
+	if el, ok := c.entries[key]; ok {
+		size := el.Value.(*cacheEntry).size
+		c.mu.Unlock()
+		return size, nil
+	}
+	c.mu.Unlock()
+	return c.inner.Size(key)
+}
+
+// Close closes the backend; the cache stays on disk for the next run
+func (c *CacheBackend) Close() error {
+	return c.inner.Close()
+}
+
+// path returns the cache file of a key
+func (c *CacheBackend) path(key string) (string, error) {
+	if key == "" || strings.Contains(key, "..") || strings.HasPrefix(key, "/") {
+		return "", fmt.Errorf("invalid key: %s", key)
+	}
+	return filepath.Join(c.dir, filepath.FromSlash(key)), nil
+}
+
+// hit reports whether key is cached and marks it most recently used
+func (c *CacheBackend) hit(key string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[key]
+	if ok {
+		c.lru.MoveToFront(el)
+	}
+	return ok
+}
+
+// add records a newly cached object and evicts what no longer fits
+func (c *CacheBackend) add(key string, size int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[key]; ok {
+		c.size -= el.Value.(*cacheEntry).size
+		c.lru.Remove(el)
+	}
+	c.entries[key] = c.lru.PushFront(&cacheEntry{key: key, size: size})
+	c.size += size
+	c.evict()
+}
+
+// drop removes a key from the cache
+func (c *CacheBackend) drop(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[key]
+	if !ok {
+		return
+	}
+	c.size -= el.Value.(*cacheEntry).size
+	c.lru.Remove(el)
+	delete(c.entries, key)
+	if path, err := c.path(key); err == nil {
+		os.Remove(path)
+	}
+}
+
+// evict removes least recently used objects until the cache fits; the
+// caller holds mu
+func (c *CacheBackend) evict() {
+	for c.size > c.maxSize && c.lru.Len() > 0 {
+		el := c.lru.Back()
+		entry := el.Value.(*cacheEntry)
+		c.lru.Remove(el)
+		delete(c.entries, entry.key)
+		c.size -= entry.size
+		if path, err := c.path(entry.key); err == nil {
+			os.Remove(path)
+		}
+	}
+}
+
+// cacheFill copies a download into the cache while it is read
+// Only a download read to the end is kept.
+type cacheFill struct {
+	io.ReadCloser
+	cache   *CacheBackend
+	key     string
+	path    string
+	tmp     *os.File
+	written int64
+	failed  bool
+	done    bool
+}
+
+func (f *cacheFill) Read(p []byte) (int, error) {
+	n, err := f.ReadCloser.Read(p)
+	if n > 0 && !f.failed {
+		if _, werr := f.tmp.Write(p[:n]); werr != nil {
+			f.failed = true
+		}
+		f.written += int64(n)
+	}
+	if err == io.EOF {
+		f.finish(true)
+	} else if err != nil {
+		f.finish(false)
+	}
+	return n, err
+}
+
+func (f *cacheFill) Close() error {
+	f.finish(false)
+	return f.ReadCloser.Close()
+}
+
+// finish moves a complete download into the cache or discards it
+func (f *cacheFill) finish(complete bool) {
+	if f.done {
+		return
+	}
+	f.done = true
+
+	name := f.tmp.Name()
+	if err := f.tmp.Close(); err != nil || !complete || f.failed || f.written > f.cache.maxSize {
+		os.Remove(name)
+		return
+	}
+	if err := os.Rename(name, f.path); err != nil {
+		os.Remove(name)
+		return
+	}
+	f.cache.add(f.key, f.written)
+}
@@ -0,0 +1,224 @@
+package backend
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// HTTPBackend implements Backend for a repository served by snapsync serve
+type HTTPBackend struct {
+	client       *http.Client
+	baseURL      string
+	token        string
+	maxBandwidth int64
+}
+
+// HTTPConfig contains the connection settings of a snapsync server
+type HTTPConfig struct {
+	URL          string // e.g. https://backup.example.com:8420
+	Token        string // Bearer token, if the server requires one
+	MaxBandwidth int64  // Bytes/sec, 0 = unlimited
+}
+
+// storageRoots are the key prefixes the server stores
+var storageRoots = []string{"objects/", "snapshots/"}
+
+// NewHTTPBackend creates a backend for a snapsync server
+func NewHTTPBackend(cfg HTTPConfig) (*HTTPBackend, error) {
+	u, err := url.Parse(cfg.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid server URL: %q", cfg.URL)
+	}
+
+	return &HTTPBackend{
+		client:       &http.Client{Timeout: 30 * time.Minute},
+		baseURL:      strings.TrimSuffix(cfg.URL, "/") + "/v1/",
+		token:        cfg.Token,
+		maxBandwidth: cfg.MaxBandwidth,
+	}, nil
+}
+
+// Put uploads data to the server
+// An append-only server refuses to replace a key that already exists.
+func (h *HTTPBackend) Put(key string, data io.Reader, size int64) error {
+	if h.maxBandwidth > 0 {
+		data = newThrottledReader(data, h.maxBandwidth)
+	}
+	req, err := h.request(http.MethodPut, key, data)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+
+	resp, err := h.do(req)
+	if err != nil {
+		return fmt.Errorf("upload failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		return fmt.Errorf("upload of %s failed: %w", key, responseError(resp))
+	}
+	return nil
+}
+
+// Get downloads data from the server
+func (h *HTTPBackend) Get(key string) (io.ReadCloser, error) {
+	req, err := h.request(http.MethodGet, key, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := h.do(req)
+	if err != nil {
+		return nil, fmt.Errorf("download failed: %w", err)
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return resp.Body, nil
+	case http.StatusNotFound:
+		resp.Body.Close()
+		return nil, fmt.Errorf("key not found: %s", key)
+	default:
+		defer resp.Body.Close()
+		return nil, fmt.Errorf("download of %s failed: %w", key, responseError(resp))
+	}
+}
+
+// Delete removes data from the server, which must allow deletes
+func (h *HTTPBackend) Delete(key string) error {
+	req, err := h.request(http.MethodDelete, key, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := h.do(req)
+	if err != nil {
+		return fmt.Errorf("delete failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("delete of %s failed: %w", key, responseError(resp))
+	}
+	return nil
+}
+
+// List returns the keys with the given prefix
+// The server only stores objects and snapshot records, so other prefixes
+// have no keys.
+func (h *HTTPBackend) List(prefix string) ([]string, error) {
+	var keys []string
+	for _, root := range storageRoots {
+		if !strings.HasPrefix(prefix, root) && !strings.HasPrefix(root, prefix) {
+			continue
+		}
+		rootKeys, err := h.list(root, prefix)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, rootKeys...)
+	}
+	return keys, nil
+}
+
+// list fetches the keys under one storage root
+func (h *HTTPBackend) list(root, prefix string) ([]string, error) {
+	req, err := http.NewRequest(http.MethodGet, h.baseURL+root+"?prefix="+url.QueryEscape(prefix), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := h.do(req)
+	if err != nil {
+		return nil, fmt.Errorf("list failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("list failed: %w", responseError(resp))
+	}
+
+	var list struct {
+		Keys []string `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return nil, fmt.Errorf("failed to parse key list: %w", err)
+	}
+	return list.Keys, nil
+}
+
+// Exists checks if a key exists
+func (h *HTTPBackend) Exists(key string) (bool, error) {
+	resp, err := h.head(key)
+	if err != nil {
+		return false, err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	default:
+		return false, responseError(resp)
+	}
+}
+
+// Size returns the size of an object
+func (h *HTTPBackend) Size(key string) (int64, error) {
+	resp, err := h.head(key)
+	if err != nil {
+		return 0, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("failed to stat %s: %w", key, responseError(resp))
+	}
+	return resp.ContentLength, nil
+}
+
+// Close releases idle connections
+func (h *HTTPBackend) Close() error {
+	h.client.CloseIdleConnections()
+	return nil
+}
+
+// head requests the headers of a key
+func (h *HTTPBackend) head(key string) (*http.Response, error) {
+	req, err := h.request(http.MethodHead, key, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := h.do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	resp.Body.Close()
+	return resp, nil
+}
+
+// request builds a request for a key
+func (h *HTTPBackend) request(method, key string, body io.Reader) (*http.Request, error) {
+	if strings.Contains(key, "..") || strings.HasPrefix(key, "/") {
+		return nil, fmt.Errorf("invalid key: %s", key)
+	}
+	return http.NewRequest(method, h.baseURL+key, body)
+}
+
+// do sends a request with the bearer token
+func (h *HTTPBackend) do(req *http.Request) (*http.Response, error) {
+	if h.token != "" {
+		req.Header.Set("Authorization", "Bearer "+h.token)
+	}
+	return h.client.Do(req)
+}
+
+// responseError turns an error response into an error, using the message
+// the server sent where there is one
+func responseError(resp *http.Response) error {
+	var body struct {
+		Error string `json:"error"`
+	}
+	if resp.Request.Method != http.MethodHead && json.NewDecoder(io.LimitReader(resp.Body, 1<<16)).Decode(&body) == nil && body.Error != "" {
+		return fmt.Errorf("%s (%s)", body.Error, resp.Status)
+	}
+	return fmt.Errorf("server returned %s", resp.Status)
+}
@@ -74,7 +74,21 @@ func (l *LocalBackend) Delete(key string) error {
 
 // List returns all keys with the given prefix
 func (l *LocalBackend) List(prefix string) ([]string, error) {
-	var keys []string
+	infos, err := l.ListInfo(prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, len(infos))
+	for i, info := range infos {
+		keys[i] = info.Key
+	}
+	return keys, nil
+}
+
+// ListInfo describes every file with the given prefix
+func (l *LocalBackend) ListInfo(prefix string) ([]ObjectInfo, error) {
+	var infos []ObjectInfo
 
 	prefixPath := l.keyToPath(prefix)
 	baseDir := l.basePath
@@ -94,13 +108,13 @@ func (l *LocalBackend) List(prefix string) ([]string, error) {
 
 		key := filepath.ToSlash(relPath)
 		if prefix == "" || strings.HasPrefix(path, prefixPath) {
-			keys = append(keys, key)
+			infos = append(infos, ObjectInfo{Key: key, Size: info.Size()})
 		}
 
 		return nil
 	})
 
-	return keys, err
+	return infos, err
 }
 
 // Exists checks if a key exists
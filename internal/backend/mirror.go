@@ -0,0 +1,357 @@
+package backend
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// mirrorSpoolSize is the largest Put buffered in memory; bigger objects
+	// are spooled to a temporary file that every replica reads
+	mirrorSpoolSize = 64 * 1024 * 1024
+
+	// mirrorRetryAfter is how long a failed replica is read from last
+	mirrorRetryAfter = time.Minute
+)
+
+// Replica is one backend of a mirror
+type Replica struct {
+	Name    string // Shown when the replica fails, e.g. s3:my-bucket
+	Backend Backend
+}
+
+// MirrorBackend implements Backend over several replicas holding the same
+// objects
+// Writes and deletes go to every replica at once and succeed if any replica
+// succeeds; each failing replica is passed to the error handler instead.
+// Reads are served by the fastest healthy replica and fall back to the
+// others.
+type MirrorBackend struct {
+	replicas []*mirrorReplica
+	onError  func(replica string, err error)
+	spoolDir string // Where large uploads are spooled, "" = system temp
+}
+
+// mirrorReplica tracks the health and read latency of a replica
+type mirrorReplica struct {
+	Replica
+
+	mu       sync.Mutex
+	latency  time.Duration // Moving average of successful reads
+	failedAt time.Time
+}
+
+// NewMirrorBackend creates a mirror over replicas; onError, if not nil, is
+// told about every operation a replica fails while the mirror succeeds
+func NewMirrorBackend(replicas []Replica, onError func(replica string, err error)) (*MirrorBackend, error) {
+	if len(replicas) == 0 {
+		return nil, fmt.Errorf("mirror needs at least one replica")
+	}
+
+	m := &MirrorBackend{onError: onError}
+	for _, r := range replicas {
+		m.replicas = append(m.replicas, &mirrorReplica{Replica: r})
+	}
+	return m, nil
+}
+
+// SetSpoolDir sets the directory large uploads are spooled to
+func (m *MirrorBackend) SetSpoolDir(dir string) {
+	m.spoolDir = dir
+}
+
+// Replicas returns the replicas of the mirror
+func (m *MirrorBackend) Replicas() []Replica {
+	replicas := make([]Replica, len(m.replicas))
+	for i, r := range m.replicas {
+		replicas[i] = r.Replica
+	}
+	return replicas
+}
+
+// Put writes data to every replica
+func (m *MirrorBackend) Put(key string, data io.Reader, size int64) error {
+	open, cleanup, err := spool(m.spoolDir, data, size)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	return m.each("upload of "+key, func(r *mirrorReplica) error {
+		reader, n := open()
+		return r.Backend.Put(key, reader, n)
+	})
+}
+
+// Get reads data from the fastest replica that has it
+func (m *MirrorBackend) Get(key string) (io.ReadCloser, error) {
+	var rc io.ReadCloser
+	err := m.read("download of "+key, func(r *mirrorReplica) error {
+		var err error
+		rc, err = r.Backend.Get(key)
+		return err
+	})
+	return rc, err
+}
+
+// Delete removes data from every replica
+func (m *MirrorBackend) Delete(key string) error {
+	return m.each("delete of "+key, func(r *mirrorReplica) error {
+		return r.Backend.Delete(key)
+	})
+}
+
+// List returns the keys any replica holds, so objects one replica missed
+// are still found
+func (m *MirrorBackend) List(prefix string) ([]string, error) {
+	var mu sync.Mutex
+	seen := make(map[string]bool)
+	err := m.each("list of "+prefix, func(r *mirrorReplica) error {
+		keys, err := r.Backend.List(prefix)
+		if err != nil {
+			return err
+		}
+		mu.Lock()
+		for _, key := range keys {
+			seen[key] = true
+		}
+		mu.Unlock()
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, 0, len(seen))
+	for key := range seen {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// Exists reports whether every reachable replica holds key, so that a copy
+// one replica lost is written again
+func (m *MirrorBackend) Exists(key string) (bool, error) {
+	found, err := m.ExistsMany([]string{key})
+	if err != nil {
+		return false, err
+	}
+	return found[key], nil
+}
+
+// ExistsMany reports which keys every reachable replica holds
+func (m *MirrorBackend) ExistsMany(keys []string) (map[string]bool, error) {
+	var mu sync.Mutex
+	var results []map[string]bool
+	err := m.each("existence check", func(r *mirrorReplica) error {
+		found, err := ExistsMany(r.Backend, keys)
+		if err != nil {
+			return err
+		}
+		mu.Lock()
+		results = append(results, found)
+		mu.Unlock()
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	all := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		all[key] = true
+		for _, found := range results {
+			if !found[key] {
+				all[key] = false
+				break
+			}
+		}
+	}
+	return all, nil
+}
+
+// Size returns the size of an object from the fastest replica that has it
+func (m *MirrorBackend) Size(key string) (int64, error) {
+	var size int64
+	err := m.read("size of "+key, func(r *mirrorReplica) error {
+		var err error
+		size, err = r.Backend.Size(key)
+		return err
+	})
+	return size, err
+}
+
+// Close closes every replica
+func (m *MirrorBackend) Close() error {
+	var errs []error
+	for _, r := range m.replicas {
+		if err := r.Backend.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", r.Name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// each runs op on every replica at once, reporting the replicas that fail,
+// and fails only if all of them do
+func (m *MirrorBackend) each(what string, op func(*mirrorReplica) error) error {
+	errs := make([]error, len(m.replicas))
+	var wg sync.WaitGroup
+	for i, r := range m.replicas {
+		wg.Add(1)
+		go func(i int, r *mirrorReplica) {
+			defer wg.Done()
+			if errs[i] = op(r); errs[i] != nil {
+				r.fail()
+			}
+		}(i, r)
+	}
+	wg.Wait()
+
+	var failed []error
+	for i, err := range errs {
+		if err != nil {
+			failed = append(failed, fmt.Errorf("%s: %w", m.replicas[i].Name, err))
+		}
+	}
+	if len(failed) == len(m.replicas) {
+		return fmt.Errorf("%s failed on every replica: %w", what, errors.Join(failed...))
+	}
+	for i, err := range errs {
+		if err != nil {
+			m.report(m.replicas[i].Name, err)
+		}
+	}
+	return nil
+}
+
+// read runs op on one replica at a time, fastest healthy replica first,
+// until one succeeds; replicas that failed before it are reported
+func (m *MirrorBackend) read(what string, op func(*mirrorReplica) error) error {
+	var tried []*mirrorReplica
+	var failed []error
+	for _, r := range m.byPreference() {
+		start := time.Now()
+		err := op(r)
+		if err == nil || isNotFound(err) {
+			// A replica missing the key still answered, and how fast counts
+			r.observe(time.Since(start))
+		} else {
+			r.fail()
+		}
+		if err == nil {
+			for i, t := range tried {
+				m.report(t.Name, failed[i])
+			}
+			return nil
+		}
+		tried = append(tried, r)
+		failed = append(failed, err)
+	}
+
+	for i, t := range tried {
+		failed[i] = fmt.Errorf("%s: %w", t.Name, failed[i])
+	}
+	return fmt.Errorf("%s failed on every replica: %w", what, errors.Join(failed...))
+}
+
+// byPreference orders the replicas for reading: healthy before recently
+// failed, then by read latency, with unmeasured replicas first so every
+// replica gets measured
+func (m *MirrorBackend) byPreference() []*mirrorReplica {
+	type ranked struct {
+		r       *mirrorReplica
+		healthy bool
+		latency time.Duration
+	}
+	now := time.Now()
+	order := make([]ranked, len(m.replicas))
+	for i, r := range m.replicas {
+		r.mu.Lock()
+		order[i] = ranked{r, now.Sub(r.failedAt) >= mirrorRetryAfter, r.latency}
+		r.mu.Unlock()
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		if order[i].healthy != order[j].healthy {
+			return order[i].healthy
+		}
+		return order[i].latency < order[j].latency
+	})
+
+	replicas := make([]*mirrorReplica, len(order))
+	for i, o := range order {
+		replicas[i] = o.r
+	}
+	return replicas
+}
+
+// report passes a replica failure to the error handler
+func (m *MirrorBackend) report(replica string, err error) {
+	if m.onError != nil {
+		m.onError(replica, err)
+	}
+}
+
+// observe records the latency of an answered read
+func (r *mirrorReplica) observe(latency time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.latency == 0 {
+		r.latency = latency
+	} else {
+		r.latency = (3*r.latency + latency) / 4
+	}
+	r.failedAt = time.Time{}
+}
+
+// fail marks a replica unhealthy for mirrorRetryAfter
+func (r *mirrorReplica) fail() {
+	r.mu.Lock()
+	r.failedAt = time.Now()
+	r.mu.Unlock()
+}
+
+// isNotFound reports whether a backend failed because the key is missing
+func isNotFound(err error) bool {
+	if err == nil {
+		return false
+	}
+	msg := err.Error()
+	return strings.Contains(msg, "key not found") || strings.Contains(msg, "NoSuchKey")
+}
+
+// spool makes data readable once per replica: in memory if it is small,
+// otherwise from a temporary file in dir; open returns a new reader and the
+// size
+func spool(dir string, data io.Reader, size int64) (open func() (io.Reader, int64), cleanup func(), err error) {
+	if size >= 0 && size <= mirrorSpoolSize {
+		buf := make([]byte, size)
+		if _, err := io.ReadFull(data, buf); err != nil {
+			return nil, nil, fmt.Errorf("failed to read data: %w", err)
+		}
+		return func() (io.Reader, int64) { return bytes.NewReader(buf), size }, func() {}, nil
+	}
+
+	tmp, err := os.CreateTemp(dir, "snapsync-mirror-*")
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to spool upload: %w", err)
+	}
+	cleanup = func() {
+		tmp.Close()
+		os.Remove(tmp.Name())
+	}
+	n, err := io.Copy(tmp, data)
+	if err != nil {
+		cleanup()
+		return nil, nil, fmt.Errorf("failed to spool upload: %w", err)
+	}
+	return func() (io.Reader, int64) { return io.NewSectionReader(tmp, 0, n), n }, cleanup, nil
+}
@@ -0,0 +1,98 @@
+package backend
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// ObjectsPrefix is where chunk and tree objects live, as objects/xx/hash
+const ObjectsPrefix = "objects/"
+
+// SnapshotsPrefix is where snapshot records live, as snapshots/id.json
+const SnapshotsPrefix = "snapshots/"
+
+// RemoveUnreferenced deletes every object under ObjectsPrefix whose hash is
+// not in live and returns how many objects and bytes were freed
+// A dry run only counts them.
+func RemoveUnreferenced(b Backend, live map[string]bool, dryRun bool) (int, int64, error) {
+	keys, err := b.List(ObjectsPrefix)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to list objects: %w", err)
+	}
+
+	var deleted int
+	var freed int64
+	for _, key := range keys {
+		hash := path.Base(key)
+		if live[hash] || !isObjectKey(key, hash) {
+			continue
+		}
+
+		size, _ := b.Size(key)
+		if !dryRun {
+			if err := b.Delete(key); err != nil {
+				return deleted, freed, fmt.Errorf("failed to delete %s: %w", key, err)
+			}
+		}
+		deleted++
+		freed += size
+	}
+	return deleted, freed, nil
+}
+
+// RemoveSnapshots deletes the records of the given snapshots; records that
+// were never uploaded are skipped
+func RemoveSnapshots(b Backend, ids []string) error {
+	for _, id := range ids {
+		if err := b.Delete(SnapshotKey(id)); err != nil {
+			return fmt.Errorf("failed to delete record of snapshot %s: %w", id, err)
+		}
+	}
+	return nil
+}
+
+// RemoveStaleSnapshots deletes every snapshot record whose ID is not in
+// live and returns how many it removed
+// Records go before the objects they refer to are pruned, so the storage
+// never lists a snapshot it cannot restore. A dry run only counts them.
+func RemoveStaleSnapshots(b Backend, live map[string]bool, dryRun bool) (int, error) {
+	keys, err := b.List(SnapshotsPrefix)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list snapshot records: %w", err)
+	}
+
+	var deleted int
+	for _, key := range keys {
+		id := strings.TrimSuffix(path.Base(key), ".json")
+		if live[id] || key != SnapshotKey(id) {
+			continue
+		}
+		if !dryRun {
+			if err := b.Delete(key); err != nil {
+				return deleted, fmt.Errorf("failed to delete %s: %w", key, err)
+			}
+		}
+		deleted++
+	}
+	return deleted, nil
+}
+
+// SnapshotKey returns the key of the record of the snapshot with the given ID
+func SnapshotKey(id string) string {
+	return SnapshotsPrefix + id + ".json"
+}
+
+// ObjectKey returns the key of the object with the given hash
+func ObjectKey(hash string) string {
+	return ObjectsPrefix + hash[:2] + "/" + hash
+}
+
+// isObjectKey reports whether key has the objects/xx/hash layout, so
+// unrelated keys sharing the prefix are never deleted
+func isObjectKey(key, hash string) bool {
+	if len(hash) != 64 || strings.Trim(hash, "0123456789abcdef") != "" {
+		return false
+	}
+	return key == ObjectKey(hash)
+}
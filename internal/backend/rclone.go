@@ -0,0 +1,283 @@
+package backend
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// rclone exit codes for a missing directory or file
+const (
+	rcloneDirNotFound  = 3
+	rcloneFileNotFound = 4
+)
+
+// RcloneBackend implements Backend by running rclone, so any remote rclone
+// is configured for can hold a repository
+type RcloneBackend struct {
+	binary string
+	remote string
+	flags  []string
+}
+
+// RcloneConfig contains rclone connection configuration
+type RcloneConfig struct {
+	Remote       string // Remote and path, e.g. gdrive:backups/snapsync
+	Binary       string // rclone executable, "" for rclone on the PATH
+	MaxBandwidth int64  // Bytes/sec, 0 = unlimited
+}
+
+// NewRcloneBackend creates a backend for an rclone remote
+// Remotes come from rclone's own configuration, including RCLONE_CONFIG and
+// RCLONE_* environment variables.
+func NewRcloneBackend(cfg RcloneConfig) (*RcloneBackend, error) {
+	if !strings.Contains(cfg.Remote, ":") {
+		return nil, fmt.Errorf("invalid rclone remote %q (expected name:path)", cfg.Remote)
+	}
+
+	binary := cfg.Binary
+	if binary == "" {
+		binary = "rclone"
+	}
+	if _, err := exec.LookPath(binary); err != nil {
+		return nil, fmt.Errorf("rclone not found: %w", err)
+	}
+
+	var flags []string
+	if cfg.MaxBandwidth > 0 {
+		flags = append(flags, "--bwlimit", strconv.FormatInt(cfg.MaxBandwidth, 10)+"B")
+	}
+
+	return &RcloneBackend{binary: binary, remote: cfg.Remote, flags: flags}, nil
+}
+
+// Put uploads data with rclone rcat
+func (r *RcloneBackend) Put(key string, data io.Reader, size int64) error {
+	args := []string{"rcat"}
+	if size >= 0 {
+		args = append(args, "--size", strconv.FormatInt(size, 10))
+	}
+	cmd := r.command(append(args, r.path(key))...)
+	cmd.Stdin = data
+
+	if _, err := r.run(cmd); err != nil {
+		return fmt.Errorf("rclone upload failed: %w", err)
+	}
+	return nil
+}
+
+// Get downloads data with rclone cat
+// The data is streamed; a failed download shows up as an error at the end
+// of the stream.
+func (r *RcloneBackend) Get(key string) (io.ReadCloser, error) {
+	exists, err := r.Exists(key)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, fmt.Errorf("key not found: %s", key)
+	}
+
+	cmd := r.command("cat", r.path(key))
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to run rclone: %w", err)
+	}
+	return &rcloneReader{ReadCloser: stdout, cmd: cmd, stderr: &stderr}, nil
+}
+
+// Delete removes data with rclone deletefile
+func (r *RcloneBackend) Delete(key string) error {
+	_, err := r.run(r.command("deletefile", r.path(key)))
+	if err != nil && !notFound(err) {
+		return fmt.Errorf("rclone delete failed: %w", err)
+	}
+	return nil
+}
+
+// List returns all keys with the given prefix
+// The directory holding the prefix is listed recursively and filtered.
+func (r *RcloneBackend) List(prefix string) ([]string, error) {
+	dir := listDir(prefix)
+
+	out, err := r.run(r.command("lsf", "-R", "--files-only", r.path(dir)))
+	if err != nil {
+		if notFound(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("rclone list failed: %w", err)
+	}
+
+	var keys []string
+	for _, line := range strings.Split(string(out), "\n") {
+		if line == "" {
+			continue
+		}
+		if key := dir + line; strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+// ListInfo describes every file with the given prefix
+// Hashes are left out: many remotes would have to read every file for them.
+func (r *RcloneBackend) ListInfo(prefix string) ([]ObjectInfo, error) {
+	dir := listDir(prefix)
+
+	out, err := r.run(r.command("lsjson", "-R", "--files-only", "--no-mimetype", "--no-modtime", r.path(dir)))
+	if err != nil {
+		if notFound(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("rclone list failed: %w", err)
+	}
+
+	var entries []struct {
+		Path string
+		Size int64
+	}
+	if err := json.Unmarshal(out, &entries); err != nil {
+		return nil, fmt.Errorf("failed to parse rclone output: %w", err)
+	}
+
+	var infos []ObjectInfo
+	for _, entry := range entries {
+		if key := dir + entry.Path; strings.HasPrefix(key, prefix) {
+			infos = append(infos, ObjectInfo{Key: key, Size: entry.Size})
+		}
+	}
+	return infos, nil
+}
+
+// listDir returns the directory part of a prefix, with its slash
+func listDir(prefix string) string {
+	if idx := strings.LastIndex(prefix, "/"); idx >= 0 {
+		return prefix[:idx+1]
+	}
+	return ""
+}
+
+// Exists checks if a key exists
+func (r *RcloneBackend) Exists(key string) (bool, error) {
+	_, err := r.Size(key)
+	if err == nil {
+		return true, nil
+	}
+	if notFound(err) {
+		return false, nil
+	}
+	return false, err
+}
+
+// Size returns the size of an object
+func (r *RcloneBackend) Size(key string) (int64, error) {
+	out, err := r.run(r.command("lsjson", "--stat", "--no-mimetype", "--no-modtime", r.path(key)))
+	if err != nil {
+		return 0, err
+	}
+
+	var entry struct {
+		Size  int64
+		IsDir bool
+	}
+	if err := json.Unmarshal(out, &entry); err != nil {
+		return 0, fmt.Errorf("failed to parse rclone output: %w", err)
+	}
+	if entry.IsDir {
+		return 0, fmt.Errorf("%s is a directory", key)
+	}
+	return entry.Size, nil
+}
+
+// Close releases resources
+func (r *RcloneBackend) Close() error {
+	return nil
+}
+
+// path returns the remote path of a key
+func (r *RcloneBackend) path(key string) string {
+	if strings.HasSuffix(r.remote, ":") {
+		return r.remote + key
+	}
+	return path.Join(r.remote, key)
+}
+
+// command builds an rclone invocation with the backend's flags
+func (r *RcloneBackend) command(args ...string) *exec.Cmd {
+	return exec.Command(r.binary, append(append([]string{}, r.flags...), args...)...)
+}
+
+// run runs rclone and returns its output, with its error message in the
+// error if it fails
+func (r *RcloneBackend) run(cmd *exec.Cmd) ([]byte, error) {
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, rcloneError(err, &stderr)
+	}
+	return out, nil
+}
+
+// rcloneError adds the last line rclone logged to a failed run
+func rcloneError(err error, stderr *bytes.Buffer) error {
+	lines := strings.Split(strings.TrimSpace(stderr.String()), "\n")
+	if msg := lines[len(lines)-1]; msg != "" {
+		return fmt.Errorf("%w: %s", err, msg)
+	}
+	return err
+}
+
+// notFound reports whether rclone failed because a file or directory does
+// not exist
+func notFound(err error) bool {
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return false
+	}
+	code := exitErr.ExitCode()
+	return code == rcloneDirNotFound || code == rcloneFileNotFound
+}
+
+// rcloneReader streams rclone cat output and reports its exit status at
+// the end of the stream
+type rcloneReader struct {
+	io.ReadCloser
+	cmd    *exec.Cmd
+	stderr *bytes.Buffer
+	done   bool
+}
+
+func (r *rcloneReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if err == io.EOF && !r.done {
+		r.done = true
+		if waitErr := r.cmd.Wait(); waitErr != nil {
+			return n, fmt.Errorf("rclone download failed: %w", rcloneError(waitErr, r.stderr))
+		}
+	}
+	return n, err
+}
+
+func (r *rcloneReader) Close() error {
+	r.ReadCloser.Close()
+	if !r.done {
+		r.done = true
+		// Killed when closed early, which is not a failure
+		r.cmd.Process.Kill()
+		r.cmd.Wait()
+	}
+	return nil
+}
@@ -3,6 +3,8 @@ package backend
 import (
 	"bytes"
 	"context"
+	"crypto/md5"
+	"encoding/base64"
 	"fmt"
 	"io"
 	"strings"
@@ -12,6 +14,7 @@ import (
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 )
 
 // S3Backend implements Backend for S3-compatible storage
@@ -20,6 +23,16 @@ type S3Backend struct {
 	bucket       string
 	prefix       string
 	maxBandwidth int64
+	stateDir     string
+	partSize     int64
+	concurrency  int
+	sse          types.ServerSideEncryption
+	kmsKeyID     string
+	dataClass    types.StorageClass
+	metaClass    types.StorageClass
+	lockMode     types.ObjectLockMode
+	lockDays     int
+	immutable    bool
 }
 
 // S3Config contains S3 connection configuration
@@ -31,12 +44,73 @@ type S3Config struct {
 	SecretKey    string
 	Prefix       string // Optional key prefix
 	MaxBandwidth int64  // Bytes/sec, 0 = unlimited
+	StateDir     string // Where multipart sessions are kept for resuming, optional
+	PartSize     int64  // Multipart part size, 0 = 16 MiB
+	Concurrency  int    // Parts uploaded at once, 0 = 4
+	// Server-side encryption: "AES256" (SSE-S3), "aws:kms" (SSE-KMS) or ""
+	// for the bucket default
+	ServerSideEncryption string
+	KMSKeyID             string // KMS key for aws:kms, "" = the account's default key
+	// Storage classes, e.g. GLACIER_IR, for chunk and tree objects and for
+	// everything else such as snapshot records; "" = the bucket default
+	DataStorageClass     string
+	MetadataStorageClass string
+	// Object Lock retention for new objects: "GOVERNANCE" or "COMPLIANCE"
+	// for ObjectLockDays days, "" = none
+	ObjectLockMode string
+	ObjectLockDays int
+	Immutable      bool // Refuse deletes
 }
 
 // NewS3Backend creates a new S3-compatible backend
 func NewS3Backend(cfg S3Config) (*S3Backend, error) {
 	ctx := context.Background()
 
+	if cfg.PartSize == 0 {
+		cfg.PartSize = defaultPartSize
+	}
+	if cfg.PartSize < minPartSize || cfg.PartSize > maxPartSize {
+		return nil, fmt.Errorf("S3 part size must be between 5 MiB and 5 GiB")
+	}
+	if cfg.Concurrency <= 0 {
+		cfg.Concurrency = defaultConcurrency
+	}
+	sse := types.ServerSideEncryption(cfg.ServerSideEncryption)
+	switch sse {
+	case "", types.ServerSideEncryptionAes256, types.ServerSideEncryptionAwsKms:
+	default:
+		return nil, fmt.Errorf("unknown S3 server-side encryption %q (use AES256 or aws:kms)", cfg.ServerSideEncryption)
+	}
+	if cfg.KMSKeyID != "" && sse != types.ServerSideEncryptionAwsKms {
+		return nil, fmt.Errorf("a KMS key requires aws:kms server-side encryption")
+	}
+	dataClass, err := parseStorageClass(cfg.DataStorageClass)
+	if err != nil {
+		return nil, err
+	}
+	metaClass, err := parseStorageClass(cfg.MetadataStorageClass)
+	if err != nil {
+		return nil, err
+	}
+	lockMode := types.ObjectLockMode(cfg.ObjectLockMode)
+	switch lockMode {
+	case "":
+		if cfg.ObjectLockDays != 0 {
+			return nil, fmt.Errorf("object lock days need an object lock mode (GOVERNANCE or COMPLIANCE)")
+		}
+	case types.ObjectLockModeGovernance, types.ObjectLockModeCompliance:
+		if cfg.ObjectLockDays <= 0 {
+			return nil, fmt.Errorf("object lock mode %s needs a positive number of days", lockMode)
+		}
+	default:
+		return nil, fmt.Errorf("unknown object lock mode %q (use GOVERNANCE or COMPLIANCE)", cfg.ObjectLockMode)
+	}
+	// Snapshot records are listed and read by every command that looks at
+	// the bucket, so they cannot wait hours for a restore from archive
+	if metaClass == types.StorageClassGlacier || metaClass == types.StorageClassDeepArchive {
+		return nil, fmt.Errorf("metadata storage class %s is not readable without a restore; use STANDARD or GLACIER_IR", metaClass)
+	}
+
 	// Build AWS config
 	awsCfg, err := config.LoadDefaultConfig(ctx,
 		config.WithRegion(cfg.Region),
@@ -66,6 +140,16 @@ func NewS3Backend(cfg S3Config) (*S3Backend, error) {
 		bucket:       cfg.Bucket,
 		prefix:       cfg.Prefix,
 		maxBandwidth: cfg.MaxBandwidth,
+		stateDir:     cfg.StateDir,
+		partSize:     cfg.PartSize,
+		concurrency:  cfg.Concurrency,
+		sse:          sse,
+		kmsKeyID:     cfg.KMSKeyID,
+		dataClass:    dataClass,
+		metaClass:    metaClass,
+		lockMode:     lockMode,
+		lockDays:     cfg.ObjectLockDays,
+		immutable:    cfg.Immutable,
 	}, nil
 }
 
@@ -75,6 +159,12 @@ func (s *S3Backend) Put(key string, data io.Reader, size int64) error {
 	defer cancel()
 
 	fullKey := s.prefixKey(key)
+	class := s.storageClass(key)
+
+	// Large objects go in parts so an interrupted upload can resume
+	if size >= MultipartThreshold {
+		return s.putMultipart(ctx, fullKey, class, data, size)
+	}
 
 	// Read all data (needed for ContentLength)
 	buf, err := io.ReadAll(data)
@@ -88,12 +178,21 @@ func (s *S3Backend) Put(key string, data io.Reader, size int64) error {
 		reader = newThrottledReader(bytes.NewReader(buf), s.maxBandwidth)
 	}
 
-	_, err = s.client.PutObject(ctx, &s3.PutObjectInput{
+	input := &s3.PutObjectInput{
 		Bucket:        aws.String(s.bucket),
 		Key:           aws.String(fullKey),
 		Body:          reader,
 		ContentLength: aws.Int64(int64(len(buf))),
-	})
+		StorageClass:  class,
+	}
+	input.ServerSideEncryption, input.SSEKMSKeyId = s.encryption()
+	if s.lockMode != "" {
+		// S3 requires a content checksum on uploads with a retention
+		input.ContentMD5 = contentMD5(buf)
+		input.ObjectLockMode = s.lockMode
+		input.ObjectLockRetainUntilDate = aws.Time(s.retainUntil())
+	}
+	_, err = s.client.PutObject(ctx, input)
 
 	if err != nil {
 		return fmt.Errorf("S3 upload failed: %w", err)
@@ -120,8 +219,12 @@ func (s *S3Backend) Get(key string) (io.ReadCloser, error) {
 	return resp.Body, nil
 }
 
-// Delete removes an object from S3
+// Delete removes an object from S3, unless the backend is immutable
 func (s *S3Backend) Delete(key string) error {
+	if s.immutable {
+		return fmt.Errorf("%w: refusing to delete %s", ErrImmutable, key)
+	}
+
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
 	defer cancel()
 
@@ -141,11 +244,28 @@ func (s *S3Backend) Delete(key string) error {
 
 // List returns all keys with the given prefix
 func (s *S3Backend) List(prefix string) ([]string, error) {
+	infos, err := s.ListInfo(prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, len(infos))
+	for i, info := range infos {
+		keys[i] = info.Key
+	}
+	return keys, nil
+}
+
+// ListInfo describes every object with the given prefix
+// The ETag is the MD5 of an object uploaded in one piece; multipart ETags,
+// which contain a dash, and the ETags of SSE-KMS objects are no checksum of
+// the content.
+func (s *S3Backend) ListInfo(prefix string) ([]ObjectInfo, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
 	defer cancel()
 
 	fullPrefix := s.prefixKey(prefix)
-	var keys []string
+	var infos []ObjectInfo
 
 	paginator := s3.NewListObjectsV2Paginator(s.client, &s3.ListObjectsV2Input{
 		Bucket: aws.String(s.bucket),
@@ -160,11 +280,20 @@ func (s *S3Backend) List(prefix string) ([]string, error) {
 
 		for _, obj := range page.Contents {
 			key := strings.TrimPrefix(*obj.Key, s.prefix)
-			keys = append(keys, strings.TrimPrefix(key, "/"))
+			info := ObjectInfo{
+				Key:     strings.TrimPrefix(key, "/"),
+				Size:    aws.ToInt64(obj.Size),
+				ETag:    strings.Trim(aws.ToString(obj.ETag), `"`),
+				ModTime: aws.ToTime(obj.LastModified),
+			}
+			if etag := info.ETag; s.sse != types.ServerSideEncryptionAwsKms && len(etag) == 32 && !strings.Contains(etag, "-") {
+				info.Checksum = "md5:" + etag
+			}
+			infos = append(infos, info)
 		}
 	}
 
-	return keys, nil
+	return infos, nil
 }
 
 // Exists checks if an object exists in S3
@@ -190,6 +319,73 @@ func (s *S3Backend) Exists(key string) (bool, error) {
 	return true, nil
 }
 
+// ExistsMany checks many keys with one listing per key directory
+// Object keys fan out over a few hundred directories, so listing each
+// directory once replaces a HEAD request per key.
+func (s *S3Backend) ExistsMany(keys []string) (map[string]bool, error) {
+	byDir := make(map[string][]string)
+	for _, key := range keys {
+		dir := ""
+		if idx := strings.LastIndex(key, "/"); idx >= 0 {
+			dir = key[:idx+1]
+		}
+		byDir[dir] = append(byDir[dir], key)
+	}
+
+	result := make(map[string]bool, len(keys))
+	for dir, dirKeys := range byDir {
+		// A single key is cheaper to HEAD than to find in a listing
+		if len(dirKeys) == 1 {
+			exists, err := s.Exists(dirKeys[0])
+			if err != nil {
+				return nil, err
+			}
+			result[dirKeys[0]] = exists
+			continue
+		}
+
+		listed, err := s.List(dir)
+		if err != nil {
+			return nil, err
+		}
+		present := make(map[string]bool, len(listed))
+		for _, key := range listed {
+			present[key] = true
+		}
+		for _, key := range dirKeys {
+			result[key] = present[key]
+		}
+	}
+
+	return result, nil
+}
+
+// LockUntil applies an Object Lock retention to an object, in the
+// configured mode or else compliance mode
+// The bucket must have Object Lock enabled.
+func (s *S3Backend) LockUntil(key string, until time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	mode := types.ObjectLockRetentionModeCompliance
+	if s.lockMode != "" {
+		mode = types.ObjectLockRetentionMode(s.lockMode)
+	}
+	_, err := s.client.PutObjectRetention(ctx, &s3.PutObjectRetentionInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.prefixKey(key)),
+		Retention: &types.ObjectLockRetention{
+			Mode:            mode,
+			RetainUntilDate: aws.Time(until),
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("S3 object lock failed: %w", err)
+	}
+
+	return nil
+}
+
 // Size returns the size of an object
 func (s *S3Backend) Size(key string) (int64, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
@@ -214,6 +410,48 @@ func (s *S3Backend) Close() error {
 	return nil
 }
 
+// parseStorageClass validates a configured storage class
+func parseStorageClass(name string) (types.StorageClass, error) {
+	if name == "" {
+		return "", nil
+	}
+	for _, class := range types.StorageClass("").Values() {
+		if string(class) == name {
+			return class, nil
+		}
+	}
+	return "", fmt.Errorf("unknown S3 storage class %q", name)
+}
+
+// retainUntil returns the end of the Object Lock retention of an object
+// uploaded now
+func (s *S3Backend) retainUntil() time.Time {
+	return time.Now().AddDate(0, 0, s.lockDays)
+}
+
+// contentMD5 returns the Content-MD5 header of data
+func contentMD5(data []byte) *string {
+	sum := md5.Sum(data)
+	return aws.String(base64.StdEncoding.EncodeToString(sum[:]))
+}
+
+// encryption returns the server-side encryption headers for new objects
+func (s *S3Backend) encryption() (types.ServerSideEncryption, *string) {
+	if s.kmsKeyID == "" {
+		return s.sse, nil
+	}
+	return s.sse, aws.String(s.kmsKeyID)
+}
+
+// storageClass returns the storage class for a key: the data class for
+// the chunk and tree objects, the metadata class for everything else
+func (s *S3Backend) storageClass(key string) types.StorageClass {
+	if strings.HasPrefix(key, ObjectsPrefix) {
+		return s.dataClass
+	}
+	return s.metaClass
+}
+
 // prefixKey adds the configured prefix to a key
 func (s *S3Backend) prefixKey(key string) string {
 	if s.prefix == "" {
@@ -0,0 +1,336 @@
+package backend
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+const (
+	// MultipartThreshold is the object size from which uploads go in parts
+	MultipartThreshold = 64 * 1024 * 1024
+
+	// defaultPartSize is the part size for objects up to maxParts parts
+	defaultPartSize = 16 * 1024 * 1024
+
+	// minPartSize and maxPartSize are the part sizes S3 accepts
+	minPartSize = 5 * 1024 * 1024
+	maxPartSize = 5 * 1024 * 1024 * 1024
+
+	// defaultConcurrency is how many parts are uploaded at once
+	defaultConcurrency = 4
+
+	// maxParts is the most parts S3 accepts for one object
+	maxParts = 10000
+)
+
+// uploadState is a multipart upload in progress, saved after every part so
+// an interrupted upload can continue in a later process
+type uploadState struct {
+	Bucket   string         `json:"bucket"`
+	Key      string         `json:"key"`
+	UploadID string         `json:"upload_id"`
+	Size     int64          `json:"size"`
+	PartSize int64          `json:"part_size"`
+	Parts    map[int32]part `json:"parts"`
+}
+
+// part is one uploaded part
+// The hash of its content decides whether a resumed upload can skip it.
+type part struct {
+	ETag   string `json:"etag"`
+	SHA256 string `json:"sha256"`
+	Size   int64  `json:"size"`
+}
+
+// partSize returns the part size for an object, growing the configured size
+// for objects that would otherwise need more than maxParts parts
+func partSize(size, configured int64) int64 {
+	ps := configured
+	for size/ps >= maxParts {
+		ps *= 2
+	}
+	return ps
+}
+
+// statePath returns the session file for an object
+func (s *S3Backend) statePath(fullKey string) string {
+	sum := sha256.Sum256([]byte(s.bucket + "/" + fullKey))
+	return filepath.Join(s.stateDir, hex.EncodeToString(sum[:16])+".json")
+}
+
+// loadUpload returns the saved session for an object of the given size, or
+// nil if there is none
+func (s *S3Backend) loadUpload(fullKey string, size int64) *uploadState {
+	if s.stateDir == "" {
+		return nil
+	}
+	data, err := os.ReadFile(s.statePath(fullKey))
+	if err != nil {
+		return nil
+	}
+
+	var state uploadState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil
+	}
+	if state.Bucket != s.bucket || state.Key != fullKey || state.Size != size || state.Parts == nil {
+		return nil
+	}
+	return &state
+}
+
+// saveUpload records the session so a later run can resume it
+func (s *S3Backend) saveUpload(state *uploadState) error {
+	if s.stateDir == "" {
+		return nil
+	}
+	if err := os.MkdirAll(s.stateDir, 0700); err != nil {
+		return fmt.Errorf("failed to create upload state directory: %w", err)
+	}
+
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	path := s.statePath(state.Key)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("failed to save upload state: %w", err)
+	}
+	return os.Rename(tmp, path)
+}
+
+// serverParts returns the ETags of the parts S3 holds for an upload
+func (s *S3Backend) serverParts(ctx context.Context, state *uploadState) (map[int32]string, error) {
+	etags := make(map[int32]string)
+	paginator := s3.NewListPartsPaginator(s.client, &s3.ListPartsInput{
+		Bucket:   aws.String(state.Bucket),
+		Key:      aws.String(state.Key),
+		UploadId: aws.String(state.UploadID),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range page.Parts {
+			etags[aws.ToInt32(p.PartNumber)] = aws.ToString(p.ETag)
+		}
+	}
+	return etags, nil
+}
+
+// putMultipart uploads a large object in parts
+// With a state directory the session survives the process: a failed upload
+// is left open and the next Put of the same key and size skips every part
+// that S3 already holds with identical content.
+func (s *S3Backend) putMultipart(ctx context.Context, fullKey string, class types.StorageClass, data io.Reader, size int64) error {
+	state := s.loadUpload(fullKey, size)
+
+	var onServer map[int32]string
+	if state != nil {
+		var err error
+		if onServer, err = s.serverParts(ctx, state); err != nil {
+			if !strings.Contains(err.Error(), "NoSuchUpload") {
+				return fmt.Errorf("S3 list parts failed: %w", err)
+			}
+			// The upload was completed, aborted or expired; start over
+			state = nil
+		}
+	}
+
+	if state == nil {
+		input := &s3.CreateMultipartUploadInput{
+			Bucket:       aws.String(s.bucket),
+			Key:          aws.String(fullKey),
+			StorageClass: class,
+		}
+		input.ServerSideEncryption, input.SSEKMSKeyId = s.encryption()
+		if s.lockMode != "" {
+			input.ObjectLockMode = s.lockMode
+			input.ObjectLockRetainUntilDate = aws.Time(s.retainUntil())
+		}
+		out, err := s.client.CreateMultipartUpload(ctx, input)
+		if err != nil {
+			return fmt.Errorf("S3 multipart upload failed to start: %w", err)
+		}
+		state = &uploadState{
+			Bucket:   s.bucket,
+			Key:      fullKey,
+			UploadID: aws.ToString(out.UploadId),
+			Size:     size,
+			PartSize: partSize(size, s.partSize),
+			Parts:    make(map[int32]part),
+		}
+		onServer = nil
+		if err := s.saveUpload(state); err != nil {
+			return err
+		}
+	}
+
+	if err := s.uploadParts(ctx, state, onServer, data); err != nil {
+		// Without saved state the parts can never be reused
+		if s.stateDir == "" {
+			s.client.AbortMultipartUpload(context.Background(), &s3.AbortMultipartUploadInput{
+				Bucket:   aws.String(state.Bucket),
+				Key:      aws.String(state.Key),
+				UploadId: aws.String(state.UploadID),
+			})
+		}
+		return err
+	}
+
+	completed := make([]types.CompletedPart, 0, len(state.Parts))
+	for number, p := range state.Parts {
+		completed = append(completed, types.CompletedPart{
+			ETag:       aws.String(p.ETag),
+			PartNumber: aws.Int32(number),
+		})
+	}
+	sort.Slice(completed, func(i, j int) bool {
+		return *completed[i].PartNumber < *completed[j].PartNumber
+	})
+
+	_, err := s.client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(state.Bucket),
+		Key:             aws.String(state.Key),
+		UploadId:        aws.String(state.UploadID),
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: completed},
+	})
+	if err != nil {
+		return fmt.Errorf("S3 multipart upload failed to complete: %w", err)
+	}
+
+	if s.stateDir != "" {
+		os.Remove(s.statePath(fullKey))
+	}
+	return nil
+}
+
+// uploadParts sends every part S3 does not already hold, up to
+// s.concurrency at a time
+// Parts are read in order, so memory use is bounded by one buffer per
+// upload in flight. The session is saved as each part completes.
+func (s *S3Backend) uploadParts(ctx context.Context, state *uploadState, onServer map[int32]string, data io.Reader) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// The bandwidth limit is shared out between the parallel uploads
+	bandwidth := s.maxBandwidth
+	if bandwidth > 0 {
+		bandwidth /= int64(s.concurrency)
+		if bandwidth == 0 {
+			bandwidth = 1
+		}
+	}
+
+	buffers := make(chan []byte, s.concurrency)
+	for i := 0; i < s.concurrency; i++ {
+		buffers <- nil
+	}
+
+	var (
+		mu       sync.Mutex
+		wg       sync.WaitGroup
+		firstErr error
+	)
+	fail := func(err error) {
+		mu.Lock()
+		if firstErr == nil {
+			firstErr = err
+			cancel()
+		}
+		mu.Unlock()
+	}
+	failed := func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return firstErr != nil
+	}
+
+	var total int64
+	for number := int32(1); total < state.Size && !failed(); number++ {
+		buf := <-buffers
+		if buf == nil {
+			buf = make([]byte, state.PartSize)
+		}
+
+		n, err := io.ReadFull(data, buf)
+		if err != nil && err != io.ErrUnexpectedEOF {
+			buffers <- buf
+			if err == io.EOF {
+				fail(fmt.Errorf("object shorter than %d bytes", state.Size))
+			} else {
+				fail(fmt.Errorf("failed to read data: %w", err))
+			}
+			break
+		}
+		total += int64(n)
+		chunk := buf[:n]
+
+		sum := sha256.Sum256(chunk)
+		hash := hex.EncodeToString(sum[:])
+
+		// Parts uploaded by an earlier run are reused if their content matches
+		mu.Lock()
+		p, ok := state.Parts[number]
+		mu.Unlock()
+		if ok && p.SHA256 == hash && onServer[number] == p.ETag {
+			buffers <- buf
+			continue
+		}
+
+		wg.Add(1)
+		go func(number int32, buf, chunk []byte, hash string) {
+			defer wg.Done()
+			defer func() { buffers <- buf }()
+
+			reader := io.Reader(bytes.NewReader(chunk))
+			if bandwidth > 0 {
+				reader = newThrottledReader(reader, bandwidth)
+			}
+
+			input := &s3.UploadPartInput{
+				Bucket:        aws.String(state.Bucket),
+				Key:           aws.String(state.Key),
+				UploadId:      aws.String(state.UploadID),
+				PartNumber:    aws.Int32(number),
+				Body:          reader,
+				ContentLength: aws.Int64(int64(len(chunk))),
+			}
+			if s.lockMode != "" {
+				input.ContentMD5 = contentMD5(chunk)
+			}
+			out, err := s.client.UploadPart(ctx, input)
+			if err != nil {
+				fail(fmt.Errorf("S3 upload of part %d failed: %w", number, err))
+				return
+			}
+
+			mu.Lock()
+			state.Parts[number] = part{ETag: aws.ToString(out.ETag), SHA256: hash, Size: int64(len(chunk))}
+			err = s.saveUpload(state)
+			mu.Unlock()
+			if err != nil {
+				fail(err)
+			}
+		}(number, buf, chunk, hash)
+	}
+
+	wg.Wait()
+	return firstErr
+}
@@ -0,0 +1,411 @@
+package bundle
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/snapsync/snapsync/internal/snapshot"
+	"github.com/snapsync/snapsync/internal/store"
+	"github.com/snapsync/snapsync/pkg/models"
+)
+
+// Version is the bundle format version
+const Version = 1
+
+// manifestName is the first entry of every bundle
+const manifestName = "bundle.json"
+
+// ErrRepositoryMismatch is returned when a bundle cannot be applied to a
+// repository because their encryption does not match
+var ErrRepositoryMismatch = errors.New("bundle does not match repository")
+
+// Manifest describes the contents of a bundle
+type Manifest struct {
+	Version   int       `json:"version"`
+	Created   time.Time `json:"created"`
+	Since     string    `json:"since,omitempty"` // Snapshot the receiving repository already has
+	Snapshots []string  `json:"snapshots"`
+	Objects   int       `json:"objects"`
+	Bytes     int64     `json:"bytes"`
+	Encrypted bool      `json:"encrypted"`
+	Tags      []string  `json:"tags,omitempty"`  // Only snapshots with one of these tags
+	Paths     []string  `json:"paths,omitempty"` // Only files matching these patterns
+}
+
+// Filter limits a bundle to some snapshots and files
+// Every bundle sent to one repository should use the same filter, since
+// objects are left out when an earlier bundle is assumed to have sent them.
+type Filter struct {
+	Tags  []string // Snapshots with one of these tags; empty = every snapshot
+	Paths []string // Files matching one of these patterns; empty = every file
+}
+
+// selects reports whether the filter takes a snapshot
+func (f Filter) selects(snap *models.Snapshot) bool {
+	if len(f.Tags) == 0 {
+		return true
+	}
+	for _, want := range f.Tags {
+		for _, tag := range snap.Tags {
+			if tag == want {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// configFiles are copied so an empty repository can read encrypted objects
+var configFiles = []string{
+	filepath.Join("config", "salt"),
+	filepath.Join("config", "encryption.json"),
+}
+
+// Create writes a self-contained bundle of the snapshots taken after since,
+// with every object they need that since does not already reference
+// An empty since bundles the whole repository. With path patterns in the
+// filter, each snapshot is narrowed to the matching files and only their
+// chunks are bundled; snapshots without matching files are left out.
+func Create(w io.Writer, repoPath string, mgr *snapshot.Manager, since string, filter Filter) (*Manifest, error) {
+	snapshots, err := mgr.List()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list snapshots: %w", err)
+	}
+
+	var base *models.Snapshot
+	if since != "" {
+		if base, err = mgr.Get(since); err != nil {
+			return nil, fmt.Errorf("failed to load snapshot %s: %w", since, err)
+		}
+	}
+
+	// Objects of the base snapshot and everything before it are assumed to
+	// be on the receiving side already
+	known := make(map[string]bool)
+	needed := make(map[string]bool)
+	manifest := &Manifest{
+		Version: Version,
+		Created: time.Now(),
+		Since:   since,
+		Tags:    filter.Tags,
+		Paths:   filter.Paths,
+	}
+
+	// Narrowed snapshots carry their own records and tree objects
+	records := make(map[string][]byte)
+	trees := make(map[string][]byte)
+
+	for _, snap := range snapshots {
+		if !filter.selects(snap) {
+			continue
+		}
+
+		var refs map[string]bool
+		if len(filter.Paths) > 0 {
+			narrowed, err := mgr.Narrow(snap.ID, filter.Paths)
+			if err != nil {
+				return nil, fmt.Errorf("failed to narrow snapshot %s: %w", snap.ID, err)
+			}
+			if !narrowed.Matched {
+				continue
+			}
+			refs = narrowed.Chunks
+			for hash, data := range narrowed.Trees {
+				refs[hash] = true
+				trees[hash] = data
+			}
+			records[snap.ID] = narrowed.Record
+		} else if refs, err = mgr.References(snap); err != nil {
+			return nil, fmt.Errorf("failed to walk snapshot %s: %w", snap.ID, err)
+		}
+
+		if base != nil && !snap.Timestamp.After(base.Timestamp) {
+			for hash := range refs {
+				known[hash] = true
+			}
+			continue
+		}
+
+		manifest.Snapshots = append(manifest.Snapshots, snap.ID)
+		for hash := range refs {
+			needed[hash] = true
+		}
+	}
+
+	var objects []string
+	for hash := range needed {
+		if !known[hash] {
+			objects = append(objects, hash)
+		}
+	}
+	sort.Strings(objects)
+	manifest.Objects = len(objects)
+
+	cas := mgr.CAS()
+	for _, hash := range objects {
+		if data, ok := trees[hash]; ok {
+			manifest.Bytes += int64(len(data))
+			continue
+		}
+		size, err := cas.Size(hash)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read object %s: %w", hash, err)
+		}
+		manifest.Bytes += size
+	}
+
+	if _, err := os.Stat(filepath.Join(repoPath, configFiles[0])); err == nil {
+		manifest.Encrypted = true
+	}
+
+	tw := tar.NewWriter(w)
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := writeEntry(tw, manifestName, data); err != nil {
+		return nil, err
+	}
+
+	for _, name := range configFiles {
+		data, err := os.ReadFile(filepath.Join(repoPath, name))
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, fmt.Errorf("failed to read %s: %w", name, err)
+		}
+		if err := writeEntry(tw, filepath.ToSlash(name), data); err != nil {
+			return nil, err
+		}
+	}
+
+	// Objects go before the records that reference them, so a truncated
+	// bundle never yields snapshots with missing data
+	for _, hash := range objects {
+		data, ok := trees[hash]
+		if !ok {
+			if data, err = cas.GetChunk(hash); err != nil {
+				return nil, fmt.Errorf("failed to read object: %w", err)
+			}
+		}
+		if err := writeEntry(tw, objectName(hash), data); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, id := range manifest.Snapshots {
+		data, ok := records[id]
+		if !ok {
+			if data, err = os.ReadFile(filepath.Join(repoPath, "snapshots", id+".json")); err != nil {
+				return nil, fmt.Errorf("failed to read snapshot %s: %w", id, err)
+			}
+		}
+		if err := writeEntry(tw, "snapshots/"+id+".json", data); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return nil, fmt.Errorf("failed to finish bundle: %w", err)
+	}
+
+	return manifest, nil
+}
+
+// Decoder decrypts and decompresses a stored chunk with the repository's
+// settings
+type Decoder func(data []byte) ([]byte, error)
+
+// Apply imports a bundle into the repository at repoPath
+// Objects and snapshots already present are kept as they are. Every object
+// is checked against its hash before it is stored, since a stored object is
+// never replaced; chunks are decoded for that with the decoder openDecoder
+// returns, which is called once the bundle's key files are in place.
+func Apply(r io.Reader, repoPath string, encrypted bool, openDecoder func() (Decoder, error)) (*Manifest, error) {
+	tr := tar.NewReader(r)
+
+	hdr, err := tr.Next()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read bundle: %w", err)
+	}
+	if hdr.Name != manifestName {
+		return nil, fmt.Errorf("not a snapsync bundle: missing %s", manifestName)
+	}
+
+	var manifest Manifest
+	if err := json.NewDecoder(tr).Decode(&manifest); err != nil {
+		return nil, fmt.Errorf("invalid bundle manifest: %w", err)
+	}
+	if manifest.Version != Version {
+		return nil, fmt.Errorf("unsupported bundle version: %d", manifest.Version)
+	}
+	if manifest.Encrypted != encrypted {
+		if manifest.Encrypted {
+			return nil, fmt.Errorf("%w: bundle is encrypted but the repository is not", ErrRepositoryMismatch)
+		}
+		return nil, fmt.Errorf("%w: repository is encrypted but the bundle is not", ErrRepositoryMismatch)
+	}
+
+	cas, err := store.NewCAS(repoPath)
+	if err != nil {
+		return nil, err
+	}
+
+	snapshotsDir := filepath.Join(repoPath, "snapshots")
+	if err := os.MkdirAll(snapshotsDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create snapshots directory: %w", err)
+	}
+
+	// Records are held back until every object has been written
+	records := make(map[string][]byte)
+
+	// Only objects whose stored bytes are not their own hash need decoding
+	var decode Decoder
+	var openErr error
+	decodeObject := func(data []byte) ([]byte, error) {
+		if decode == nil && openErr == nil {
+			decode, openErr = openDecoder()
+		}
+		if openErr != nil {
+			return nil, openErr
+		}
+		return decode(data)
+	}
+
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read bundle: %w", err)
+		}
+
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", hdr.Name, err)
+		}
+
+		switch {
+		case strings.HasPrefix(hdr.Name, "objects/"):
+			hash := filepath.Base(hdr.Name)
+			if !isHash(hash) {
+				return nil, fmt.Errorf("invalid object in bundle: %s", hdr.Name)
+			}
+			if !store.Matches(hash, data, decodeObject) {
+				if openErr != nil {
+					return nil, fmt.Errorf("failed to open repository to check objects: %w", openErr)
+				}
+				return nil, fmt.Errorf("bundle is corrupt: object %s does not match its hash", hash)
+			}
+			if _, err := cas.PutChunk(hash, data); err != nil {
+				return nil, err
+			}
+
+		case strings.HasPrefix(hdr.Name, "snapshots/"):
+			id := strings.TrimSuffix(filepath.Base(hdr.Name), ".json")
+			if id == "" || strings.ContainsAny(id, `/\.`) {
+				return nil, fmt.Errorf("invalid snapshot in bundle: %s", hdr.Name)
+			}
+			records[id] = data
+
+		case hdr.Name == "config/salt" || hdr.Name == "config/encryption.json":
+			if err := applyConfig(repoPath, filepath.FromSlash(hdr.Name), data); err != nil {
+				return nil, err
+			}
+
+		default:
+			return nil, fmt.Errorf("unexpected entry in bundle: %s", hdr.Name)
+		}
+	}
+
+	for _, id := range manifest.Snapshots {
+		data, ok := records[id]
+		if !ok {
+			return nil, fmt.Errorf("bundle is truncated: snapshot %s missing", id)
+		}
+
+		path := filepath.Join(snapshotsDir, id+".json")
+		if _, err := os.Stat(path); err == nil {
+			continue
+		}
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			return nil, fmt.Errorf("failed to write snapshot %s: %w", id, err)
+		}
+	}
+
+	// The chunk filter is rebuilt from the object store on next use
+	os.Remove(filepath.Join(repoPath, "index", "chunks.bloom"))
+
+	return &manifest, nil
+}
+
+// applyConfig installs a key file from the bundle, or checks that the
+// repository already has the same one
+func applyConfig(repoPath, name string, data []byte) error {
+	path := filepath.Join(repoPath, name)
+
+	existing, err := os.ReadFile(path)
+	if err == nil {
+		if name == configFiles[0] && strings.TrimSpace(string(existing)) != strings.TrimSpace(string(data)) {
+			return fmt.Errorf("%w: encryption salt differs", ErrRepositoryMismatch)
+		}
+		return nil
+	}
+	if !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read %s: %w", name, err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create config directory: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return fmt.Errorf("failed to write %s: %w", name, err)
+	}
+	return nil
+}
+
+// writeEntry adds one file to the bundle
+func writeEntry(tw *tar.Writer, name string, data []byte) error {
+	hdr := &tar.Header{
+		Name:    name,
+		Mode:    0644,
+		Size:    int64(len(data)),
+		ModTime: time.Now(),
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return fmt.Errorf("failed to write bundle entry %s: %w", name, err)
+	}
+	if _, err := tw.Write(data); err != nil {
+		return fmt.Errorf("failed to write bundle entry %s: %w", name, err)
+	}
+	return nil
+}
+
+// objectName is the bundle path of an object, mirroring the repository layout
+func objectName(hash string) string {
+	return "objects/" + hash[:2] + "/" + hash
+}
+
+// isHash reports whether s looks like a hex SHA-256 digest
+func isHash(s string) bool {
+	if len(s) != 64 {
+		return false
+	}
+	for _, c := range s {
+		if !strings.ContainsRune("0123456789abcdef", c) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,139 @@
+package chunker
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"math/bits"
+
+	"github.com/snapsync/snapsync/pkg/models"
+)
+
+// buzhashWindow is the number of bytes the buzhash rolls over
+const buzhashWindow = 48
+
+// buzhashTable maps each byte value to a pseudo-random word, derived from a
+// fixed seed so boundaries are the same on every run and machine
+var buzhashTable = func() (table [256]uint32) {
+	state := uint64(0x5eed_b022_4a54_c4a1)
+	for i := range table {
+		// splitmix64
+		state += 0x9e3779b97f4a7c15
+		z := state
+		z = (z ^ z>>30) * 0xbf58476d1ce4e5b9
+		z = (z ^ z>>27) * 0x94d049bb133111eb
+		table[i] = uint32(z ^ z>>31)
+	}
+	return table
+}()
+
+// BuzhashChunker implements content-defined chunking with a buzhash (cyclic
+// polynomial) rolling hash
+// Rolling costs a table lookup, two rotations and two XORs per byte, and
+// the first minSize bytes of a chunk are skipped without hashing, so it
+// uses less CPU than Rabin fingerprinting. Its boundaries differ from
+// Rabin's, so switching algorithms stores the data anew once.
+type BuzhashChunker struct {
+	minSize int
+	maxSize int
+	mask    uint32
+}
+
+// NewBuzhash creates a buzhash chunker with specified size parameters
+func NewBuzhash(minSize, avgSize, maxSize int) *BuzhashChunker {
+	if minSize <= 0 {
+		minSize = DefaultMinSize
+	}
+	if avgSize <= 0 {
+		avgSize = DefaultAvgSize
+	}
+	if maxSize <= 0 {
+		maxSize = DefaultMaxSize
+	}
+	maxSize = max(maxSize, minSize)
+
+	// Boundaries are searched for after minSize, so cut every
+	// avgSize-minSize bytes from there on average, rounded to a power of two
+	spread := uint32(max(avgSize-minSize, 1))
+	mask := uint32(1)<<(bits.Len32(spread)-1) - 1
+
+	return &BuzhashChunker{
+		minSize: minSize,
+		maxSize: maxSize,
+		mask:    mask,
+	}
+}
+
+// Chunk reads from the reader and produces chunks using content-defined chunking
+func (bc *BuzhashChunker) Chunk(reader io.Reader) ([]*models.Chunk, error) {
+	return collect(bc.ChunkStream, reader)
+}
+
+// ChunkStream chunks reader like Chunk, passing each chunk to fn as soon as
+// it is cut
+func (bc *BuzhashChunker) ChunkStream(reader io.Reader, fn func(*models.Chunk) error) error {
+	buf := make([]byte, bc.maxSize)
+	buffered := 0
+	eof := false
+	var offset int64
+
+	for {
+		if !eof {
+			n, err := io.ReadFull(reader, buf[buffered:])
+			buffered += n
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				eof = true
+			} else if err != nil {
+				return err
+			}
+		}
+		if buffered == 0 {
+			return nil
+		}
+
+		size := bc.boundary(buf[:buffered])
+		hash := sha256.Sum256(buf[:size])
+		data := make([]byte, size)
+		copy(data, buf[:size])
+
+		chunk := &models.Chunk{
+			Hash:   hex.EncodeToString(hash[:]),
+			Size:   int64(size),
+			Offset: offset,
+			Data:   data,
+		}
+		if err := fn(chunk); err != nil {
+			return err
+		}
+
+		// Keep what follows the chunk for the next one
+		offset += int64(size)
+		buffered = copy(buf, buf[size:buffered])
+	}
+}
+
+// boundary returns the size of the chunk at the start of data, which holds
+// maxSize bytes unless the stream ends within them
+// The hash covers the window of bytes before each candidate cut, so only
+// the window before minSize needs hashing ahead of the first candidate.
+func (bc *BuzhashChunker) boundary(data []byte) int {
+	if len(data) <= bc.minSize {
+		return len(data)
+	}
+
+	start := max(bc.minSize-buzhashWindow, 0)
+	end := min(start+buzhashWindow, len(data))
+	var h uint32
+	for _, b := range data[start:end] {
+		h = bits.RotateLeft32(h, 1) ^ buzhashTable[b]
+	}
+
+	for i := end; i < len(data); i++ {
+		if i >= bc.minSize && h&bc.mask == 0 {
+			return i
+		}
+		out := bits.RotateLeft32(buzhashTable[data[i-buzhashWindow]], buzhashWindow)
+		h = bits.RotateLeft32(h, 1) ^ out ^ buzhashTable[data[i]]
+	}
+	return len(data)
+}
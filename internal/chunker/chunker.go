@@ -3,6 +3,7 @@ package chunker
 import (
 	"crypto/sha256"
 	"encoding/hex"
+	"fmt"
 	"io"
 
 	"github.com/chmduquesne/rollinghash/rabinkarp64"
@@ -58,8 +59,26 @@ func NewDefault() *Chunker {
 
 // Chunk reads from the reader and produces chunks using content-defined chunking
 func (c *Chunker) Chunk(reader io.Reader) ([]*models.Chunk, error) {
-	var chunks []*models.Chunk
-	var offset int64
+	return collect(c.ChunkStream, reader)
+}
+
+// ChunkStream chunks reader like Chunk, passing each chunk to fn as soon as
+// it is cut
+// Only the chunk being cut is buffered. An error from fn stops chunking and
+// is returned.
+func (c *Chunker) ChunkStream(reader io.Reader, fn func(*models.Chunk) error) error {
+	_, err := c.chunkStream(reader, 0, true, nil, fn)
+	return err
+}
+
+// chunkStream chunks reader, numbering offsets from base, and passes each
+// chunk to emit
+// Without withData chunks record only their extent, leaving reading and
+// hashing them to the caller. If stop returns true for the end offset of a
+// chunk, chunking halts after that chunk and stopped is set; otherwise the
+// whole stream is consumed.
+func (c *Chunker) chunkStream(reader io.Reader, base int64, withData bool, stop func(end int64) bool, emit func(*models.Chunk) error) (stopped bool, err error) {
+	offset := base
 
 	buf := make([]byte, c.maxSize)
 	window := make([]byte, 64) // Rolling hash window size
@@ -78,7 +97,7 @@ func (c *Chunker) Chunk(reader io.Reader) ([]*models.Chunk, error) {
 				break
 			}
 			if err != nil {
-				return nil, err
+				return false, err
 			}
 			continue
 		}
@@ -115,8 +134,9 @@ func (c *Chunker) Chunk(reader io.Reader) ([]*models.Chunk, error) {
 			}
 
 			if shouldSplit {
-				chunk := c.createChunk(currentChunk, offset)
-				chunks = append(chunks, chunk)
+				if err := emit(c.createChunk(currentChunk, offset, withData)); err != nil {
+					return false, err
+				}
 				offset += int64(chunkLen)
 				currentChunk = currentChunk[:0]
 
@@ -125,6 +145,10 @@ func (c *Chunker) Chunk(reader io.Reader) ([]*models.Chunk, error) {
 				hasher.Write(window)
 				windowFull = false
 				windowIdx = 0
+
+				if stop != nil && stop(offset) {
+					return true, nil
+				}
 			}
 		}
 
@@ -135,25 +159,28 @@ func (c *Chunker) Chunk(reader io.Reader) ([]*models.Chunk, error) {
 
 	// Handle remaining data
 	if len(currentChunk) > 0 {
-		chunk := c.createChunk(currentChunk, offset)
-		chunks = append(chunks, chunk)
+		if err := emit(c.createChunk(currentChunk, offset, withData)); err != nil {
+			return false, err
+		}
 	}
 
-	return chunks, nil
+	return false, nil
 }
 
-// createChunk creates a new chunk with computed hash
-func (c *Chunker) createChunk(data []byte, offset int64) *models.Chunk {
-	hash := sha256.Sum256(data)
-	dataCopy := make([]byte, len(data))
-	copy(dataCopy, data)
-
-	return &models.Chunk{
-		Hash:   hex.EncodeToString(hash[:]),
+// createChunk creates a new chunk, with its hash and a copy of data if
+// withData is set
+func (c *Chunker) createChunk(data []byte, offset int64, withData bool) *models.Chunk {
+	chunk := &models.Chunk{
 		Size:   int64(len(data)),
 		Offset: offset,
-		Data:   dataCopy,
 	}
+	if withData {
+		hash := sha256.Sum256(data)
+		chunk.Hash = hex.EncodeToString(hash[:])
+		chunk.Data = make([]byte, len(data))
+		copy(chunk.Data, data)
+	}
+	return chunk
 }
 
 // ChunkFile reads a file and returns its chunks
@@ -182,7 +209,12 @@ func NewFixed(chunkSize int) *FixedChunker {
 
 // Chunk splits data into fixed-size chunks
 func (fc *FixedChunker) Chunk(reader io.Reader) ([]*models.Chunk, error) {
-	var chunks []*models.Chunk
+	return collect(fc.ChunkStream, reader)
+}
+
+// ChunkStream splits data into fixed-size chunks, passing each to fn as
+// soon as it is read
+func (fc *FixedChunker) ChunkStream(reader io.Reader, fn func(*models.Chunk) error) error {
 	var offset int64
 
 	buf := make([]byte, fc.chunkSize)
@@ -194,12 +226,15 @@ func (fc *FixedChunker) Chunk(reader io.Reader) ([]*models.Chunk, error) {
 			data := make([]byte, n)
 			copy(data, buf[:n])
 
-			chunks = append(chunks, &models.Chunk{
+			chunk := &models.Chunk{
 				Hash:   hex.EncodeToString(hash[:]),
 				Size:   int64(n),
 				Offset: offset,
 				Data:   data,
-			})
+			}
+			if err := fn(chunk); err != nil {
+				return err
+			}
 			offset += int64(n)
 		}
 
@@ -207,9 +242,53 @@ func (fc *FixedChunker) Chunk(reader io.Reader) ([]*models.Chunk, error) {
 			break
 		}
 		if err != nil {
-			return nil, err
+			return err
 		}
 	}
 
+	return nil
+}
+
+// Splitter splits a stream into chunks
+type Splitter interface {
+	// Chunk returns all the chunks of reader, holding their data in memory
+	Chunk(reader io.Reader) ([]*models.Chunk, error)
+
+	// ChunkStream passes the chunks of reader to fn in order as they are
+	// cut, so memory use does not grow with the size of the stream
+	ChunkStream(reader io.Reader, fn func(*models.Chunk) error) error
+}
+
+// collect gathers the chunks a streaming splitter passes on, for Chunk
+func collect(stream func(io.Reader, func(*models.Chunk) error) error, reader io.Reader) ([]*models.Chunk, error) {
+	var chunks []*models.Chunk
+	err := stream(reader, func(chunk *models.Chunk) error {
+		chunks = append(chunks, chunk)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
 	return chunks, nil
 }
+
+// NewSplitter creates a chunker for the named algorithm (rabin, buzhash, fixed)
+// With imageProfile set, VM disk images are chunked on cluster boundaries
+func NewSplitter(algorithm string, minSize, avgSize, maxSize int, imageProfile bool) (Splitter, error) {
+	var base Splitter
+	switch algorithm {
+	case "rabin", "":
+		base = New(minSize, avgSize, maxSize)
+	case "buzhash":
+		base = NewBuzhash(minSize, avgSize, maxSize)
+	case "fixed":
+		base = NewFixed(avgSize)
+	default:
+		return nil, fmt.Errorf("unknown chunking algorithm: %s", algorithm)
+	}
+
+	if imageProfile {
+		return NewImageAware(base, minSize, avgSize, maxSize), nil
+	}
+	return base, nil
+}
@@ -1,8 +1,66 @@
 package chunker
 
-import "os"
+import (
+	"bytes"
+	"io"
+	"os"
+)
+
+// SourceFile is a file opened for chunking
+type SourceFile interface {
+	io.Reader
+	io.ReaderAt
+	io.Closer
+}
 
 // openFile opens a file for reading
 func openFile(path string) (*os.File, error) {
 	return os.Open(path)
 }
+
+// Open opens a file for chunking. With useMmap set, regular files are
+// memory-mapped to avoid read syscalls and copies; if mapping is not
+// possible the plain file is returned instead.
+//
+// A mapped file that is truncated by another process while being read
+// faults the reader, so mapping suits data that is quiescent or read from
+// a filesystem snapshot.
+func Open(path string, useMmap bool) (SourceFile, error) {
+	file, err := openFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if !useMmap {
+		return file, nil
+	}
+
+	info, err := file.Stat()
+	if err != nil || !info.Mode().IsRegular() || info.Size() == 0 || int64(int(info.Size())) != info.Size() {
+		return file, nil
+	}
+
+	data, err := mmap(file, int(info.Size()))
+	if err != nil {
+		return file, nil
+	}
+
+	// The mapping stays valid after the descriptor is closed
+	file.Close()
+	return &mappedFile{Reader: bytes.NewReader(data), data: data}, nil
+}
+
+// mappedFile is a read-only memory-mapped file
+type mappedFile struct {
+	*bytes.Reader
+	data []byte
+}
+
+// Close unmaps the file
+func (m *mappedFile) Close() error {
+	if m.data == nil {
+		return nil
+	}
+	err := munmap(m.data)
+	m.data = nil
+	return err
+}
@@ -0,0 +1,190 @@
+package chunker
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"hash/fnv"
+	"io"
+
+	"github.com/snapsync/snapsync/pkg/models"
+)
+
+const (
+	// Cluster/grain size assumed when an image header does not specify one
+	defaultImageAlignment = 64 * 1024
+
+	// Raw disks are aligned to the common 4K sector/filesystem block size
+	rawImageAlignment = 4096
+
+	// imageHeaderSize is how much of a stream is inspected to detect images
+	imageHeaderSize = 1024
+)
+
+// DetectImageAlignment inspects the start of a file and returns the block
+// size of a VM disk image, or 0 if the data is not a recognized image
+func DetectImageAlignment(header []byte) int {
+	switch {
+	case len(header) >= 24 && bytes.Equal(header[:4], []byte{'Q', 'F', 'I', 0xfb}):
+		// qcow2: cluster_bits is a big-endian uint32 at offset 20
+		bits := binary.BigEndian.Uint32(header[20:24])
+		if bits >= 9 && bits <= 21 {
+			return 1 << bits
+		}
+		return defaultImageAlignment
+
+	case len(header) >= 28 && bytes.Equal(header[:4], []byte("KDMV")):
+		// VMDK sparse extent: grainSize in sectors, little-endian uint64 at 20
+		grain := binary.LittleEndian.Uint64(header[20:28])
+		if grain > 0 && grain <= 4096 {
+			return int(grain) * 512
+		}
+		return defaultImageAlignment
+
+	case len(header) >= 520 && bytes.Equal(header[512:520], []byte("EFI PART")):
+		// Raw disk with a GPT partition table
+		return rawImageAlignment
+
+	case len(header) >= 512 && header[510] == 0x55 && header[511] == 0xaa:
+		// Raw disk with an MBR boot signature
+		return rawImageAlignment
+	}
+
+	return 0
+}
+
+// ImageAwareChunker chunks VM disk images on cluster boundaries and hands
+// everything else to a general-purpose chunker
+type ImageAwareChunker struct {
+	base    Splitter
+	minSize int
+	avgSize int
+	maxSize int
+}
+
+// NewImageAware wraps base with disk image detection
+func NewImageAware(base Splitter, minSize, avgSize, maxSize int) *ImageAwareChunker {
+	return &ImageAwareChunker{
+		base:    base,
+		minSize: minSize,
+		avgSize: avgSize,
+		maxSize: maxSize,
+	}
+}
+
+// Chunk detects disk images from the stream header and chunks accordingly
+func (ic *ImageAwareChunker) Chunk(reader io.Reader) ([]*models.Chunk, error) {
+	return collect(ic.ChunkStream, reader)
+}
+
+// ChunkStream detects disk images from the stream header and streams the
+// chunks of the matching chunker to fn
+func (ic *ImageAwareChunker) ChunkStream(reader io.Reader, fn func(*models.Chunk) error) error {
+	br := bufio.NewReaderSize(reader, imageHeaderSize)
+	header, _ := br.Peek(imageHeaderSize)
+
+	if align := DetectImageAlignment(header); align > 0 {
+		return NewAligned(align, ic.minSize, ic.avgSize, ic.maxSize).ChunkStream(br, fn)
+	}
+	return ic.base.ChunkStream(br, fn)
+}
+
+// AlignedChunker places chunk boundaries only on multiples of a block size
+// Boundaries are still content-defined, chosen by hashing whole blocks, so
+// unchanged clusters of an image dedup across snapshots even when data
+// elsewhere in the image moves.
+type AlignedChunker struct {
+	align   int
+	minSize int
+	maxSize int
+	mask    uint64
+}
+
+// NewAligned creates a chunker whose boundaries fall on multiples of align
+func NewAligned(align, minSize, avgSize, maxSize int) *AlignedChunker {
+	if align <= 0 {
+		align = rawImageAlignment
+	}
+	if avgSize <= 0 {
+		avgSize = DefaultAvgSize
+	}
+
+	// Round the size limits to whole blocks
+	minSize = max(minSize/align, 1) * align
+	maxSize = max(maxSize/align, minSize/align) * align
+
+	// One boundary every avgSize/align blocks on average
+	blocks := uint64(max(avgSize/align, 1))
+	mask := uint64(1)
+	for mask < blocks {
+		mask <<= 1
+	}
+
+	return &AlignedChunker{
+		align:   align,
+		minSize: minSize,
+		maxSize: maxSize,
+		mask:    mask - 1,
+	}
+}
+
+// Chunk splits data into block-aligned chunks
+func (ac *AlignedChunker) Chunk(reader io.Reader) ([]*models.Chunk, error) {
+	return collect(ac.ChunkStream, reader)
+}
+
+// ChunkStream splits data into block-aligned chunks, passing each to fn as
+// soon as it is cut
+func (ac *AlignedChunker) ChunkStream(reader io.Reader, fn func(*models.Chunk) error) error {
+	var offset int64
+
+	block := make([]byte, ac.align)
+	current := make([]byte, 0, ac.maxSize)
+
+	emit := func() error {
+		hash := sha256.Sum256(current)
+		data := make([]byte, len(current))
+		copy(data, current)
+
+		chunk := &models.Chunk{
+			Hash:   hex.EncodeToString(hash[:]),
+			Size:   int64(len(data)),
+			Offset: offset,
+			Data:   data,
+		}
+		offset += int64(len(data))
+		current = current[:0]
+		return fn(chunk)
+	}
+
+	for {
+		n, err := io.ReadFull(reader, block)
+		if n > 0 {
+			current = append(current, block[:n]...)
+
+			h := fnv.New64a()
+			h.Write(block[:n])
+
+			if len(current) >= ac.maxSize || (len(current) >= ac.minSize && h.Sum64()&ac.mask == 0) {
+				if err := emit(); err != nil {
+					return err
+				}
+			}
+		}
+
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(current) > 0 {
+		return emit()
+	}
+
+	return nil
+}
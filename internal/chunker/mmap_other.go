@@ -0,0 +1,17 @@
+//go:build !unix
+
+package chunker
+
+import (
+	"errors"
+	"os"
+)
+
+// mmap is not supported on this platform; callers fall back to reads
+func mmap(file *os.File, size int) ([]byte, error) {
+	return nil, errors.New("mmap not supported")
+}
+
+func munmap(data []byte) error {
+	return nil
+}
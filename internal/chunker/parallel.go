@@ -0,0 +1,180 @@
+package chunker
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/snapsync/snapsync/internal/tuning"
+	"github.com/snapsync/snapsync/pkg/models"
+)
+
+const (
+	// ParallelThreshold is the file size above which files are chunked by
+	// several workers at once
+	ParallelThreshold = 256 * 1024 * 1024 // 256 MB
+
+	// minSegmentSize keeps segments large relative to stitching work
+	minSegmentSize = 64 * 1024 * 1024 // 64 MB
+)
+
+// ParallelSplitter is implemented by chunkers that can split one large
+// file across several workers
+type ParallelSplitter interface {
+	ChunkParallel(r io.ReaderAt, size int64, limiter *tuning.Limiter, fn func(*models.Chunk) error) error
+}
+
+// ChunkParallel splits r into segments chunked concurrently, as many at a
+// time as the limiter allows, then stitches
+// the segments so the result is identical to chunking r serially, and
+// passes the chunks to fn in order.
+//
+// After a boundary the rolling hash state depends only on the bytes just
+// before it, so once the serial boundary sequence meets a boundary that a
+// segment worker also found, the two agree from there on. Stitching
+// re-chunks serially from the last trusted boundary until it meets such a
+// boundary, then adopts that worker's remaining chunks.
+//
+// Segments are chunked for their boundaries only, so memory use does not
+// grow with the file. Each chunk is read again to be hashed and passed on.
+func (c *Chunker) ChunkParallel(r io.ReaderAt, size int64, limiter *tuning.Limiter, fn func(*models.Chunk) error) error {
+	workers := limiter.Ceiling()
+	segSize := max(size/int64(max(workers, 1)), minSegmentSize, int64(c.maxSize)*4)
+	if workers <= 1 || size <= segSize {
+		return c.ChunkStream(io.NewSectionReader(r, 0, size), fn)
+	}
+
+	// Chunk each segment independently
+	var starts []int64
+	for start := int64(0); start < size; start += segSize {
+		starts = append(starts, start)
+	}
+
+	segments := make([][]*models.Chunk, len(starts))
+	errs := make([]error, len(starts))
+	var wg sync.WaitGroup
+
+	for i, start := range starts {
+		wg.Add(1)
+		go func(i int, start int64) {
+			defer wg.Done()
+			limiter.Acquire()
+			began := time.Now()
+
+			length := min(segSize, size-start)
+			_, errs[i] = c.chunkStream(io.NewSectionReader(r, start, length), start, false, nil, func(chunk *models.Chunk) error {
+				segments[i] = append(segments[i], chunk)
+				return nil
+			})
+			limiter.Release(length, time.Since(began))
+		}(i, start)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+
+	// Boundaries found by workers, excluding each segment's final cut,
+	// which only marks where the segment was truncated
+	syncPoints := make(map[int64]int)
+	for i, seg := range segments {
+		for j := 0; j < len(seg)-1; j++ {
+			syncPoints[seg[j].Offset+seg[j].Size] = i
+		}
+	}
+
+	// The first segment is exact up to its truncated last chunk
+	first := segments[0]
+	result := first[:len(first)-1]
+	pos := first[len(first)-1].Offset
+
+	for pos < size {
+		var stitched []*models.Chunk
+		stopped, err := c.chunkStream(io.NewSectionReader(r, pos, size-pos), pos, false, func(end int64) bool {
+			_, ok := syncPoints[end]
+			return ok
+		}, func(chunk *models.Chunk) error {
+			stitched = append(stitched, chunk)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		result = append(result, stitched...)
+		if !stopped {
+			break // Serial chunking reached the end of the file
+		}
+
+		// Adopt the worker's chunks after the sync point
+		last := stitched[len(stitched)-1]
+		pos = last.Offset + last.Size
+		seg := segments[syncPoints[pos]]
+		isLastSegment := syncPoints[pos] == len(segments)-1
+
+		for j, chunk := range seg {
+			if chunk.Offset < pos {
+				continue
+			}
+			if j == len(seg)-1 && !isLastSegment {
+				break
+			}
+			result = append(result, chunk)
+		}
+
+		tail := result[len(result)-1]
+		pos = tail.Offset + tail.Size
+		if !isLastSegment {
+			// Resume from the start of the segment's truncated last chunk
+			pos = seg[len(seg)-1].Offset
+		}
+	}
+
+	return readChunks(r, result, workers, fn)
+}
+
+// readChunks reads and hashes the chunks cut from r, workers at a time, and
+// passes them to fn in order
+func readChunks(r io.ReaderAt, cuts []*models.Chunk, workers int, fn func(*models.Chunk) error) error {
+	for len(cuts) > 0 {
+		batch := cuts[:min(workers, len(cuts))]
+		cuts = cuts[len(batch):]
+
+		chunks := make([]*models.Chunk, len(batch))
+		errs := make([]error, len(batch))
+		var wg sync.WaitGroup
+		for i, cut := range batch {
+			wg.Add(1)
+			go func(i int, cut *models.Chunk) {
+				defer wg.Done()
+				data := make([]byte, cut.Size)
+				if _, err := io.ReadFull(io.NewSectionReader(r, cut.Offset, cut.Size), data); err != nil {
+					errs[i] = err
+					return
+				}
+				hash := sha256.Sum256(data)
+				chunks[i] = &models.Chunk{
+					Hash:   hex.EncodeToString(hash[:]),
+					Size:   cut.Size,
+					Offset: cut.Offset,
+					Data:   data,
+				}
+			}(i, cut)
+		}
+		wg.Wait()
+
+		for i, chunk := range chunks {
+			if errs[i] != nil {
+				return errs[i]
+			}
+			if err := fn(chunk); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
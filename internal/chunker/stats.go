@@ -0,0 +1,81 @@
+package chunker
+
+import (
+	"bytes"
+	"sort"
+	"time"
+)
+
+// Stats describes how a splitter chunked a data set
+type Stats struct {
+	Bytes       int64         `json:"bytes"`
+	Chunks      int           `json:"chunks"`
+	UniqueBytes int64         `json:"unique_bytes"` // Bytes left after deduplicating chunks
+	Duration    time.Duration `json:"duration"`
+	Min         int64         `json:"min"`
+	P10         int64         `json:"p10"`
+	P50         int64         `json:"p50"`
+	P90         int64         `json:"p90"`
+	Max         int64         `json:"max"`
+	Mean        int64         `json:"mean"`
+}
+
+// Throughput returns the bytes chunked per second
+func (s *Stats) Throughput() float64 {
+	if s.Duration <= 0 {
+		return 0
+	}
+	return float64(s.Bytes) / s.Duration.Seconds()
+}
+
+// DedupRatio returns the data size over the deduplicated size
+func (s *Stats) DedupRatio() float64 {
+	if s.UniqueBytes == 0 {
+		return 1
+	}
+	return float64(s.Bytes) / float64(s.UniqueBytes)
+}
+
+// Measure chunks each sample with the splitter and reports chunk sizes,
+// deduplication across all samples and the time spent chunking
+// Only chunking and hashing are timed; the samples are already in memory.
+func Measure(s Splitter, samples [][]byte) (*Stats, error) {
+	stats := &Stats{}
+	seen := make(map[string]bool)
+	var sizes []int64
+
+	for _, sample := range samples {
+		start := time.Now()
+		chunks, err := s.Chunk(bytes.NewReader(sample))
+		if err != nil {
+			return nil, err
+		}
+		stats.Duration += time.Since(start)
+		stats.Bytes += int64(len(sample))
+
+		for _, chunk := range chunks {
+			sizes = append(sizes, chunk.Size)
+			if !seen[chunk.Hash] {
+				seen[chunk.Hash] = true
+				stats.UniqueBytes += chunk.Size
+			}
+		}
+	}
+
+	stats.Chunks = len(sizes)
+	if len(sizes) == 0 {
+		return stats, nil
+	}
+
+	sort.Slice(sizes, func(i, j int) bool { return sizes[i] < sizes[j] })
+	percentile := func(p int) int64 {
+		return sizes[(len(sizes)-1)*p/100]
+	}
+	stats.Min = sizes[0]
+	stats.P10 = percentile(10)
+	stats.P50 = percentile(50)
+	stats.P90 = percentile(90)
+	stats.Max = sizes[len(sizes)-1]
+	stats.Mean = stats.Bytes / int64(len(sizes))
+	return stats, nil
+}
@@ -21,27 +21,49 @@ const (
 type Compressor struct {
 	algorithm Algorithm
 	level     int
+	lowMemory bool
 	encoder   *zstd.Encoder
 	decoder   *zstd.Decoder
 }
 
+// Option configures a Compressor
+type Option func(*Compressor)
+
+// WithLowMemory trades speed for a smaller footprint: a single encoder
+// goroutine, a smaller window and low-memory decoding
+func WithLowMemory() Option {
+	return func(c *Compressor) {
+		c.lowMemory = true
+	}
+}
+
 // New creates a new Compressor with the specified algorithm and level
-func New(algorithm Algorithm, level int) (*Compressor, error) {
+func New(algorithm Algorithm, level int, opts ...Option) (*Compressor, error) {
 	c := &Compressor{
 		algorithm: algorithm,
 		level:     level,
 	}
+	for _, opt := range opts {
+		opt(c)
+	}
 
 	if algorithm == AlgorithmZstd {
 		// Map level 1-19 to zstd levels
 		zstdLevel := zstd.EncoderLevelFromZstd(level)
-		encoder, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstdLevel))
+		encOpts := []zstd.EOption{zstd.WithEncoderLevel(zstdLevel)}
+		decOpts := []zstd.DOption{}
+		if c.lowMemory {
+			encOpts = append(encOpts, zstd.WithEncoderConcurrency(1), zstd.WithWindowSize(1<<20))
+			decOpts = append(decOpts, zstd.WithDecoderConcurrency(1), zstd.WithDecoderLowmem(true))
+		}
+
+		encoder, err := zstd.NewWriter(nil, encOpts...)
 		if err != nil {
 			return nil, fmt.Errorf("failed to create zstd encoder: %w", err)
 		}
 		c.encoder = encoder
 
-		decoder, err := zstd.NewReader(nil)
+		decoder, err := zstd.NewReader(nil, decOpts...)
 		if err != nil {
 			return nil, fmt.Errorf("failed to create zstd decoder: %w", err)
 		}
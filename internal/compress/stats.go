@@ -0,0 +1,77 @@
+package compress
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Stats describes how a compressor performed on a set of blocks
+type Stats struct {
+	Bytes           int64         `json:"bytes"`
+	CompressedBytes int64         `json:"compressed_bytes"`
+	CompressTime    time.Duration `json:"compress_time"`
+	DecompressTime  time.Duration `json:"decompress_time"`
+}
+
+// Ratio returns the compressed size as a fraction of the original
+func (s *Stats) Ratio() float64 {
+	if s.Bytes == 0 {
+		return 1
+	}
+	return float64(s.CompressedBytes) / float64(s.Bytes)
+}
+
+// CompressSpeed returns the bytes compressed per second
+func (s *Stats) CompressSpeed() float64 {
+	if s.CompressTime <= 0 {
+		return 0
+	}
+	return float64(s.Bytes) / s.CompressTime.Seconds()
+}
+
+// DecompressSpeed returns the bytes decompressed per second
+func (s *Stats) DecompressSpeed() float64 {
+	if s.DecompressTime <= 0 {
+		return 0
+	}
+	return float64(s.Bytes) / s.DecompressTime.Seconds()
+}
+
+// LevelName returns the encoder setting a zstd level maps to
+// Levels are grouped into four settings, so e.g. 6 and 9 behave the same.
+func LevelName(level int) string {
+	return zstd.EncoderLevelFromZstd(level).String()
+}
+
+// Measure compresses and decompresses each block on one goroutine, the way
+// chunks are stored, and checks that every block round-trips
+func Measure(c *Compressor, blocks [][]byte) (*Stats, error) {
+	stats := &Stats{}
+
+	for _, block := range blocks {
+		start := time.Now()
+		compressed, err := c.Compress(block)
+		if err != nil {
+			return nil, err
+		}
+		stats.CompressTime += time.Since(start)
+
+		start = time.Now()
+		decompressed, err := c.Decompress(compressed)
+		if err != nil {
+			return nil, err
+		}
+		stats.DecompressTime += time.Since(start)
+
+		if !bytes.Equal(decompressed, block) {
+			return nil, fmt.Errorf("%s level %d did not round-trip", c.algorithm, c.level)
+		}
+		stats.Bytes += int64(len(block))
+		stats.CompressedBytes += int64(len(compressed))
+	}
+
+	return stats, nil
+}
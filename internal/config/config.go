@@ -15,21 +15,62 @@ type Config struct {
 	Compression CompressionConfig `yaml:"compression" json:"compression"`
 	Cloud       CloudConfig       `yaml:"cloud" json:"cloud"`
 	Chunking    ChunkingConfig    `yaml:"chunking" json:"chunking"`
+	Concurrency ConcurrencyConfig `yaml:"concurrency" json:"concurrency"`
+	Backup      BackupConfig      `yaml:"backup" json:"backup"`
+	Scan        ScanConfig        `yaml:"scan" json:"scan"`
+	Restore     RestoreConfig     `yaml:"restore" json:"restore"`
+	Retention   RetentionConfig   `yaml:"retention" json:"retention"`
+	Hooks       HooksConfig       `yaml:"hooks" json:"hooks"`
 	Exclusions  []string          `yaml:"exclusions" json:"exclusions"`
+	// Built-in exclusion presets applied to every backup (see ExcludePresets)
+	ExcludePresets []string `yaml:"exclude_presets,omitempty" json:"exclude_presets,omitempty"`
+	// App data presets applied to every backup (see appdata.Presets)
+	AppPresets []string `yaml:"app_presets,omitempty" json:"app_presets,omitempty"`
+	// Exclusion profiles for particular backup sources, by path or bucket
+	// URL (s3://bucket/prefix, gs://bucket/prefix)
+	Sources map[string]SourceConfig `yaml:"sources,omitempty" json:"sources,omitempty"`
+}
+
+// SourceConfig defines the exclusions used when backing up one source
+type SourceConfig struct {
+	ExcludePresets []string `yaml:"exclude_presets,omitempty" json:"exclude_presets,omitempty"`
+	Exclusions     []string `yaml:"exclusions,omitempty" json:"exclusions,omitempty"`
+	AppPresets     []string `yaml:"app_presets,omitempty" json:"app_presets,omitempty"`
+	// Where and as whom a bucket source is read; without keys, those of
+	// the S3 cloud storage are used
+	Region    string `yaml:"region,omitempty" json:"region,omitempty"`
+	Endpoint  string `yaml:"endpoint,omitempty" json:"endpoint,omitempty"`
+	AccessKey string `yaml:"access_key,omitempty" json:"access_key,omitempty"`
+	SecretKey string `yaml:"secret_key,omitempty" json:"secret_key,omitempty"`
 }
 
 // RepositoryConfig defines repository settings
 type RepositoryConfig struct {
 	Path     string `yaml:"path" json:"path"`
 	AutoInit bool   `yaml:"auto_init" json:"auto_init"`
+	// Keep a filename index across snapshots for fast file history lookups
+	PathIndex bool `yaml:"path_index" json:"path_index"`
+	// Expire new snapshots after this long (e.g. 30d) unless backup sets --expire
+	Expire string `yaml:"expire,omitempty" json:"expire,omitempty"`
+	// Directory for locks, temporary files, upload checkpoints and the cache,
+	// relative to the repository (default run)
+	RuntimeDir string `yaml:"runtime_dir,omitempty" json:"runtime_dir,omitempty"`
 }
 
 // EncryptionConfig defines encryption settings
 type EncryptionConfig struct {
 	Enabled   bool   `yaml:"enabled" json:"enabled"`
 	Algorithm string `yaml:"algorithm" json:"algorithm"` // aes-256-gcm
-	KDF       string `yaml:"kdf" json:"kdf"`             // argon2id
+	KDF       string `yaml:"kdf" json:"kdf"`             // argon2id, pbkdf2-sha256
 	KeyFile   string `yaml:"key_file" json:"key_file"`   // Optional key file path
+	Policy    string `yaml:"policy" json:"policy"`       // default, fips
+	Padding   string `yaml:"padding" json:"padding"`     // none, padme
+	// Encrypt file names and directory structure in snapshot metadata
+	EncryptNames bool `yaml:"encrypt_names" json:"encrypt_names"`
+	// Argon2id cost for new repositories, 0 = default (see snapsync key benchmark)
+	Argon2Time    uint32 `yaml:"argon2_time" json:"argon2_time"`       // Passes
+	Argon2Memory  uint32 `yaml:"argon2_memory" json:"argon2_memory"`   // MiB
+	Argon2Threads uint8  `yaml:"argon2_threads" json:"argon2_threads"` // Lanes
 }
 
 // CompressionConfig defines compression settings
@@ -42,13 +83,35 @@ type CompressionConfig struct {
 // CloudConfig defines cloud storage settings
 type CloudConfig struct {
 	Enabled      bool   `yaml:"enabled" json:"enabled"`
-	Provider     string `yaml:"provider" json:"provider"` // s3, azure, gcs
+	Provider     string `yaml:"provider" json:"provider"` // s3, b2, http, rclone, local, mirror
 	Bucket       string `yaml:"bucket" json:"bucket"`
+	Remote       string `yaml:"remote" json:"remote"` // rclone remote and path, e.g. gdrive:backups
 	Region       string `yaml:"region" json:"region"`
-	Endpoint     string `yaml:"endpoint" json:"endpoint"` // For S3-compatible
+	Endpoint     string `yaml:"endpoint" json:"endpoint"` // For S3-compatible, or the server URL for http
 	AccessKey    string `yaml:"access_key" json:"access_key"`
 	SecretKey    string `yaml:"secret_key" json:"secret_key"`
-	MaxBandwidth int64  `yaml:"max_bandwidth" json:"max_bandwidth"` // bytes/sec, 0 = unlimited
+	Token        string `yaml:"token" json:"token"`                   // Bearer token for http
+	MaxBandwidth int64  `yaml:"max_bandwidth" json:"max_bandwidth"`   // bytes/sec, 0 = unlimited
+	Path         string `yaml:"path,omitempty" json:"path,omitempty"` // Directory for local
+	// Multipart uploads to S3: part size (e.g. 16M) and parts sent at once
+	PartSize          string `yaml:"part_size,omitempty" json:"part_size,omitempty"`
+	UploadConcurrency int    `yaml:"upload_concurrency,omitempty" json:"upload_concurrency,omitempty"`
+	// S3 storage classes for chunk and tree objects and for snapshot
+	// records and other metadata, e.g. GLACIER_IR and STANDARD
+	DataStorageClass     string `yaml:"data_storage_class,omitempty" json:"data_storage_class,omitempty"`
+	MetadataStorageClass string `yaml:"metadata_storage_class,omitempty" json:"metadata_storage_class,omitempty"`
+	// S3 server-side encryption (AES256 or aws:kms) and the KMS key to use
+	ServerSideEncryption string `yaml:"server_side_encryption,omitempty" json:"server_side_encryption,omitempty"`
+	KMSKeyID             string `yaml:"kms_key_id,omitempty" json:"kms_key_id,omitempty"`
+	// S3 Object Lock retention for uploads (GOVERNANCE or COMPLIANCE, for
+	// object_lock_days), and refusing deletes for append-only storage
+	ObjectLockMode string `yaml:"object_lock_mode,omitempty" json:"object_lock_mode,omitempty"`
+	ObjectLockDays int    `yaml:"object_lock_days,omitempty" json:"object_lock_days,omitempty"`
+	Immutable      bool   `yaml:"immutable,omitempty" json:"immutable,omitempty"`
+	// Keep objects read from the storage in <repo>/cache, up to this size (e.g. 2G)
+	CacheSize string `yaml:"cache_size,omitempty" json:"cache_size,omitempty"`
+	// Backends a mirror writes every object to
+	Replicas []CloudConfig `yaml:"replicas,omitempty" json:"replicas,omitempty"`
 }
 
 // ChunkingConfig defines content-defined chunking parameters
@@ -56,7 +119,82 @@ type ChunkingConfig struct {
 	MinSize   int    `yaml:"min_size" json:"min_size"`   // Minimum chunk size
 	AvgSize   int    `yaml:"avg_size" json:"avg_size"`   // Target average chunk size
 	MaxSize   int    `yaml:"max_size" json:"max_size"`   // Maximum chunk size
-	Algorithm string `yaml:"algorithm" json:"algorithm"` // rabin, fixed
+	Algorithm string `yaml:"algorithm" json:"algorithm"` // rabin, buzhash, fixed
+	// Chunk qcow2/vmdk/raw disk images on cluster boundaries
+	ImageProfile bool `yaml:"image_profile" json:"image_profile"`
+	// Read files through memory mappings where supported
+	MMap bool `yaml:"mmap" json:"mmap"`
+}
+
+// ConcurrencyConfig bounds worker parallelism
+type ConcurrencyConfig struct {
+	Adaptive bool `yaml:"adaptive" json:"adaptive"` // Tune workers from observed throughput
+	Min      int  `yaml:"min" json:"min"`           // Floor for adaptive tuning
+	Max      int  `yaml:"max" json:"max"`           // Ceiling, 0 = number of CPUs
+}
+
+// BackupConfig defines how a backup handles files it cannot read and how
+// many workers it runs
+type BackupConfig struct {
+	// Passes over files that failed transiently (busy, vanished, locked)
+	// before they are left out and reported
+	Retries    int            `yaml:"retries" json:"retries"`
+	RetryDelay string         `yaml:"retry_delay" json:"retry_delay"` // Wait before the first pass, doubled after each
+	Parallel   ParallelConfig `yaml:"parallel,omitempty" json:"parallel,omitempty"`
+}
+
+// ParallelConfig sets the workers of each backup stage, 0 = default
+type ParallelConfig struct {
+	Files    int `yaml:"files,omitempty" json:"files,omitempty"`       // Files read and chunked at once
+	Encoders int `yaml:"encoders,omitempty" json:"encoders,omitempty"` // Chunks compressed and encrypted at once
+	Writers  int `yaml:"writers,omitempty" json:"writers,omitempty"`   // Chunks written to the repository at once
+}
+
+// ScanConfig bounds the load a backup's scan puts on the source, for
+// NFS/SMB shares that other users depend on
+type ScanConfig struct {
+	Workers      int `yaml:"workers" json:"workers"`               // Directories listed and files hashed at once
+	OpsPerSecond int `yaml:"ops_per_second" json:"ops_per_second"` // stat/readdir/open calls, 0 = unlimited
+}
+
+// RestoreConfig bounds the load a restore or verify puts on the host, so
+// services running beside it keep their disk and CPU
+type RestoreConfig struct {
+	ReadLimit   string `yaml:"read_limit,omitempty" json:"read_limit,omitempty"`   // Repository data read per second, e.g. 50MB
+	WriteLimit  string `yaml:"write_limit,omitempty" json:"write_limit,omitempty"` // Restored data written per second
+	LowPriority bool   `yaml:"low_priority" json:"low_priority"`                   // Lowest CPU and I/O priority
+}
+
+// HooksConfig defines shell commands run around operations
+type HooksConfig struct {
+	PreRestore  []string `yaml:"pre_restore,omitempty" json:"pre_restore,omitempty"`   // e.g. systemctl stop app
+	PostRestore []string `yaml:"post_restore,omitempty" json:"post_restore,omitempty"` // e.g. systemctl start app
+	Timeout     string   `yaml:"timeout,omitempty" json:"timeout,omitempty"`           // Per hook, e.g. 5m
+}
+
+// KeepRules decide which snapshots survive pruning; zero means unset
+type KeepRules struct {
+	KeepLast    int    `yaml:"keep_last,omitempty" json:"keep_last,omitempty"`
+	KeepHourly  int    `yaml:"keep_hourly,omitempty" json:"keep_hourly,omitempty"`
+	KeepDaily   int    `yaml:"keep_daily,omitempty" json:"keep_daily,omitempty"`
+	KeepWeekly  int    `yaml:"keep_weekly,omitempty" json:"keep_weekly,omitempty"`
+	KeepMonthly int    `yaml:"keep_monthly,omitempty" json:"keep_monthly,omitempty"`
+	KeepYearly  int    `yaml:"keep_yearly,omitempty" json:"keep_yearly,omitempty"`
+	KeepWithin  string `yaml:"keep_within,omitempty" json:"keep_within,omitempty"` // e.g. 30d
+}
+
+// RetentionConfig defines keep rules for snapshots outside any tier, and
+// separate rules for each retention tier
+type RetentionConfig struct {
+	KeepRules `yaml:",inline"`
+	Tiers     map[string]TierConfig `yaml:"tiers,omitempty" json:"tiers,omitempty"`
+}
+
+// TierConfig defines a retention tier such as "monthly" or "yearly"
+// Snapshots join a tier when pinned to it or when they carry one of its tags.
+type TierConfig struct {
+	Tags      []string `yaml:"tags,omitempty" json:"tags,omitempty"`
+	KeepRules `yaml:",inline"`
 }
 
 // DefaultConfig returns a configuration with sensible defaults
@@ -70,6 +208,8 @@ func DefaultConfig() *Config {
 			Enabled:   false,
 			Algorithm: "aes-256-gcm",
 			KDF:       "argon2id",
+			Policy:    "default",
+			Padding:   "none",
 		},
 		Compression: CompressionConfig{
 			Enabled:   true,
@@ -86,6 +226,18 @@ func DefaultConfig() *Config {
 			MaxSize:   4 * 1024 * 1024, // 4 MB
 			Algorithm: "rabin",
 		},
+		Concurrency: ConcurrencyConfig{
+			Adaptive: false,
+			Min:      1,
+			Max:      0,
+		},
+		Backup: BackupConfig{
+			Retries:    3,
+			RetryDelay: "1s",
+		},
+		Scan: ScanConfig{
+			Workers: 4,
+		},
 		Exclusions: []string{
 			".git",
 			".svn",
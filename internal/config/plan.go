@@ -0,0 +1,95 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Plan describes the backups of a whole machine: the repositories, and
+// which sources go to which of them, how often and for how long
+type Plan struct {
+	Repos   map[string]PlanRepo `yaml:"repos" json:"repos"`
+	Sources []PlanSource        `yaml:"sources" json:"sources"`
+}
+
+// PlanRepo is a repository backups in a plan are written to
+type PlanRepo struct {
+	Path         string `yaml:"path" json:"path"`
+	PasswordFile string `yaml:"password_file,omitempty" json:"password_file,omitempty"`
+	// Delete unreferenced objects after retention removed snapshots
+	Prune bool `yaml:"prune,omitempty" json:"prune,omitempty"`
+}
+
+// PlanSource is one directory or device backed up by a plan
+type PlanSource struct {
+	Name           string    `yaml:"name" json:"name"`
+	Path           string    `yaml:"path" json:"path"`
+	Repos          []string  `yaml:"repos" json:"repos"`                     // Names from Plan.Repos
+	Every          string    `yaml:"every,omitempty" json:"every,omitempty"` // e.g. 6h, 1d; empty = every run
+	Description    string    `yaml:"description,omitempty" json:"description,omitempty"`
+	Exclude        []string  `yaml:"exclude,omitempty" json:"exclude,omitempty"`
+	ExcludePresets []string  `yaml:"exclude_presets,omitempty" json:"exclude_presets,omitempty"`
+	AppPresets     []string  `yaml:"app_presets,omitempty" json:"app_presets,omitempty"`
+	Tags           []string  `yaml:"tags,omitempty" json:"tags,omitempty"`
+	NoCompress     bool      `yaml:"no_compress,omitempty" json:"no_compress,omitempty"`
+	Retention      KeepRules `yaml:"retention,omitempty" json:"retention,omitempty"`
+}
+
+// LoadPlan reads and validates a backup plan
+func LoadPlan(path string) (*Plan, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read plan: %w", err)
+	}
+
+	var plan Plan
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&plan); err != nil {
+		return nil, fmt.Errorf("invalid plan %s: %w", path, err)
+	}
+	if err := plan.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid plan %s: %w", path, err)
+	}
+	return &plan, nil
+}
+
+// Validate checks that every source is named, has a path and only uses
+// repositories the plan defines
+func (p *Plan) Validate() error {
+	if len(p.Sources) == 0 {
+		return fmt.Errorf("no sources")
+	}
+	for name, repo := range p.Repos {
+		if repo.Path == "" {
+			return fmt.Errorf("repo %s has no path", name)
+		}
+	}
+
+	names := make(map[string]bool, len(p.Sources))
+	for _, source := range p.Sources {
+		if source.Name == "" {
+			return fmt.Errorf("source %s has no name", source.Path)
+		}
+		if names[source.Name] {
+			return fmt.Errorf("duplicate source name: %s", source.Name)
+		}
+		names[source.Name] = true
+
+		if source.Path == "" {
+			return fmt.Errorf("source %s has no path", source.Name)
+		}
+		if len(source.Repos) == 0 {
+			return fmt.Errorf("source %s has no repos", source.Name)
+		}
+		for _, repo := range source.Repos {
+			if _, ok := p.Repos[repo]; !ok {
+				return fmt.Errorf("source %s: unknown repo %s", source.Name, repo)
+			}
+		}
+	}
+	return nil
+}
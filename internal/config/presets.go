@@ -0,0 +1,131 @@
+package config
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ExcludePreset is a built-in set of exclusion patterns for a kind of source
+// Patterns starting with / are anchored at the backup source, so presets
+// meant for a home directory or a system root should be used with one.
+type ExcludePreset struct {
+	Description string
+	Patterns    []string
+}
+
+// ExcludePresets are the built-in exclusion presets by name
+var ExcludePresets = map[string]ExcludePreset{
+	"linux-system": {
+		Description: "Virtual filesystems, temporary files and swap when backing up /",
+		Patterns: []string{
+			"/proc", "/sys", "/dev", "/run", "/tmp", "/mnt", "/media",
+			"/var/tmp", "/var/cache", "/var/run", "/var/lock",
+			"/swapfile", "/swap.img",
+			"lost+found",
+		},
+	},
+	"macos-user": {
+		Description: "Caches, logs, trash and Finder metadata in a macOS home directory",
+		Patterns: []string{
+			"/Library/Caches", "/Library/Logs", "/.Trash",
+			"/Library/Developer/Xcode/DerivedData",
+			"/Library/Developer/CoreSimulator/Caches",
+			".DS_Store", ".Spotlight-V100", ".fseventsd", ".TemporaryItems",
+		},
+	},
+	"windows-user": {
+		Description: "Temporary files, browser caches and locked registry hives in a Windows profile",
+		Patterns: []string{
+			"/AppData/Local/Temp",
+			"/AppData/Local/CrashDumps",
+			"/AppData/Local/Microsoft/Windows/INetCache",
+			"/AppData/Local/Microsoft/Windows/Explorer",
+			"NTUSER.DAT*", "ntuser.dat*", "UsrClass.dat*",
+			"Thumbs.db", "$Recycle.Bin",
+			"pagefile.sys", "hiberfil.sys", "swapfile.sys",
+		},
+	},
+	"developer": {
+		Description: "Dependency directories, build caches and compiled files",
+		Patterns: []string{
+			"node_modules", "__pycache__", ".pytest_cache", ".mypy_cache",
+			".tox", ".venv", ".gradle", ".next", ".nuxt", ".parcel-cache",
+			".terraform", "*.pyc", "*.o", "*.class",
+			"/.cache", "/.npm", "/.cargo/registry", "/go/pkg/mod", "/.m2/repository",
+		},
+	},
+}
+
+// PresetNames returns the names of the built-in exclusion presets, sorted
+func PresetNames() []string {
+	names := make([]string, 0, len(ExcludePresets))
+	for name := range ExcludePresets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// PresetPatterns returns the patterns of the named presets, in order
+func PresetPatterns(names []string) ([]string, error) {
+	var patterns []string
+	for _, name := range names {
+		preset, ok := ExcludePresets[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown exclude preset %q (available: %s)", name, strings.Join(PresetNames(), ", "))
+		}
+		patterns = append(patterns, preset.Patterns...)
+	}
+	return patterns, nil
+}
+
+// ExclusionsFor returns every exclusion pattern for a backup of source:
+// the global exclusions and presets, then the profile configured for the
+// source, then the given presets and patterns
+func (c *Config) ExclusionsFor(source string, presets, patterns []string) ([]string, error) {
+	names := append([]string(nil), c.ExcludePresets...)
+	exclusions := append([]string(nil), c.Exclusions...)
+
+	if profile, ok := c.SourceProfile(source); ok {
+		names = append(names, profile.ExcludePresets...)
+		exclusions = append(exclusions, profile.Exclusions...)
+	}
+	names = append(names, presets...)
+
+	presetPatterns, err := PresetPatterns(names)
+	if err != nil {
+		return nil, err
+	}
+	exclusions = append(exclusions, presetPatterns...)
+	return append(exclusions, patterns...), nil
+}
+
+// AppPresetsFor returns the names of the app data presets for a backup of
+// source: the global ones, then the profile's, then the given ones
+func (c *Config) AppPresetsFor(source string, names []string) []string {
+	presets := append([]string(nil), c.AppPresets...)
+	if profile, ok := c.SourceProfile(source); ok {
+		presets = append(presets, profile.AppPresets...)
+	}
+	return append(presets, names...)
+}
+
+// SourceProfile finds the profile configured for a source path or bucket URL
+func (c *Config) SourceProfile(source string) (SourceConfig, bool) {
+	if strings.Contains(source, "://") {
+		profile, ok := c.Sources[source]
+		return profile, ok
+	}
+	abs, err := filepath.Abs(source)
+	if err != nil {
+		return SourceConfig{}, false
+	}
+	for path, profile := range c.Sources {
+		if p, err := filepath.Abs(path); err == nil && p == abs {
+			return profile, true
+		}
+	}
+	return SourceConfig{}, false
+}
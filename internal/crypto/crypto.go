@@ -5,9 +5,11 @@ import (
 	"crypto/cipher"
 	"crypto/rand"
 	"crypto/sha256"
+	"crypto/subtle"
 	"encoding/hex"
 	"fmt"
 	"io"
+	"sync"
 
 	"golang.org/x/crypto/argon2"
 )
@@ -28,13 +30,25 @@ const (
 
 // Encryptor handles encryption and decryption using AES-256-GCM
 type Encryptor struct {
-	key    []byte
-	salt   []byte
-	cipher cipher.AEAD
+	key     []byte
+	salt    []byte
+	cipher  cipher.AEAD
+	padding string
+
+	// Deterministic cipher for metadata, created on first use
+	detOnce sync.Once
+	det     *deterministic
+	detErr  error
 }
 
-// NewEncryptor creates a new Encryptor from a passphrase
+// NewEncryptor creates a new Encryptor from a passphrase using Argon2id
 func NewEncryptor(passphrase string, salt []byte) (*Encryptor, error) {
+	return NewEncryptorWithKDF(passphrase, salt, KDFArgon2id)
+}
+
+// NewEncryptorWithKDF creates a new Encryptor from a passphrase using the
+// given key derivation function
+func NewEncryptorWithKDF(passphrase string, salt []byte, kdf string) (*Encryptor, error) {
 	if len(salt) == 0 {
 		salt = make([]byte, saltSize)
 		if _, err := rand.Read(salt); err != nil {
@@ -42,15 +56,19 @@ func NewEncryptor(passphrase string, salt []byte) (*Encryptor, error) {
 		}
 	}
 
-	// Derive key using Argon2id
-	key := argon2.IDKey(
-		[]byte(passphrase),
-		salt,
-		argon2Time,
-		argon2Memory,
-		argon2Threads,
-		argon2KeyLen,
-	)
+	key, err := DeriveKey(passphrase, salt, kdf)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewEncryptorFromKey(key, salt)
+}
+
+// NewEncryptorFromKey creates an Encryptor from an already derived key
+func NewEncryptorFromKey(key, salt []byte) (*Encryptor, error) {
+	if len(key) != argon2KeyLen {
+		return nil, fmt.Errorf("invalid key length: %d", len(key))
+	}
 
 	// Create AES-GCM cipher
 	block, err := aes.NewCipher(key)
@@ -75,6 +93,28 @@ func (e *Encryptor) Salt() []byte {
 	return e.salt
 }
 
+// SetPadding sets the padding scheme applied to plaintext before
+// encryption, hiding exact chunk sizes
+func (e *Encryptor) SetPadding(scheme string) error {
+	if err := CheckPadding(scheme); err != nil {
+		return err
+	}
+	e.padding = scheme
+	return nil
+}
+
+// Key returns the derived encryption key
+func (e *Encryptor) Key() []byte {
+	return e.key
+}
+
+// Fingerprint identifies the key for label without revealing it: the hex
+// of a subkey derived for label, so fingerprints kept for different
+// purposes cannot be linked to each other or to the key
+func (e *Encryptor) Fingerprint(label string) string {
+	return hex.EncodeToString(subkey(e.key, label))
+}
+
 // Encrypt encrypts plaintext and returns ciphertext with prepended nonce
 func (e *Encryptor) Encrypt(plaintext []byte) ([]byte, error) {
 	nonce := make([]byte, nonceSize)
@@ -82,6 +122,10 @@ func (e *Encryptor) Encrypt(plaintext []byte) ([]byte, error) {
 		return nil, fmt.Errorf("failed to generate nonce: %w", err)
 	}
 
+	if e.padding == PaddingPadme {
+		plaintext = pad(plaintext)
+	}
+
 	// Seal prepends the ciphertext to the nonce
 	ciphertext := e.cipher.Seal(nonce, nonce, plaintext, nil)
 	return ciphertext, nil
@@ -101,6 +145,10 @@ func (e *Encryptor) Decrypt(ciphertext []byte) ([]byte, error) {
 		return nil, fmt.Errorf("decryption failed: %w", err)
 	}
 
+	if e.padding == PaddingPadme {
+		return unpad(plaintext)
+	}
+
 	return plaintext, nil
 }
 
@@ -177,10 +225,23 @@ type EncryptionHeader struct {
 	Version      int    `json:"version"`
 	Algorithm    string `json:"algorithm"`
 	KDF          string `json:"kdf"`
-	Salt         string `json:"salt"`          // Hex-encoded
-	PasswordHash string `json:"password_hash"` // For verification
+	Salt         string `json:"salt"`              // Hex-encoded
+	PasswordHash string `json:"password_hash"`     // For verification
+	Padding      string `json:"padding,omitempty"` // Padding scheme, fixed at creation
+	// File names and tree structure are encrypted, fixed at creation
+	EncryptedNames bool `json:"encrypted_names,omitempty"`
+	// Argon2id cost, fixed at creation; nil means the defaults
+	Argon2 *Argon2Params `json:"argon2,omitempty"`
+	// Optional reminder shown when unlocking fails
+	Hint string `json:"hint,omitempty"`
+	// Passphrases that unlock a random master key; without any, the key is
+	// derived from the single password directly (version 1)
+	Keys []*KeySlot `json:"keys,omitempty"`
 }
 
+// keySlotVersion is the header version of repositories with key slots
+const keySlotVersion = 2
+
 // NewEncryptionHeader creates header metadata
 func NewEncryptionHeader(salt []byte, passphrase string) *EncryptionHeader {
 	return &EncryptionHeader{
@@ -192,8 +253,40 @@ func NewEncryptionHeader(salt []byte, passphrase string) *EncryptionHeader {
 	}
 }
 
+// NewEncryptionHeaderFromKey creates header metadata from a key derived
+// with kdf, avoiding a second key derivation
+func NewEncryptionHeaderFromKey(salt, key []byte, kdf string) *EncryptionHeader {
+	hash := sha256.Sum256(key)
+	return &EncryptionHeader{
+		Version:      1,
+		Algorithm:    AlgorithmAESGCM,
+		KDF:          kdf,
+		Salt:         hex.EncodeToString(salt),
+		PasswordHash: hex.EncodeToString(hash[:]),
+	}
+}
+
 // VerifyPassword checks if the password is correct
 func (h *EncryptionHeader) VerifyPassword(passphrase string) bool {
 	salt, _ := hex.DecodeString(h.Salt)
-	return HashPassword(passphrase, salt) == h.PasswordHash
+	key, err := DeriveKeyWithParams(passphrase, salt, h.KDF, h.Argon2Params())
+	if err != nil {
+		return false
+	}
+	return h.VerifyKey(key)
+}
+
+// Argon2Params returns the Argon2id parameters the repository key was
+// derived with
+func (h *EncryptionHeader) Argon2Params() Argon2Params {
+	if h.Argon2 == nil {
+		return DefaultArgon2Params()
+	}
+	return h.Argon2.WithDefaults()
+}
+
+// VerifyKey checks if a key matches the stored hash of the repository key
+func (h *EncryptionHeader) VerifyKey(key []byte) bool {
+	hash := sha256.Sum256(key)
+	return subtle.ConstantTimeCompare([]byte(hex.EncodeToString(hash[:])), []byte(h.PasswordHash)) == 1
 }
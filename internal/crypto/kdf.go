@@ -0,0 +1,101 @@
+package crypto
+
+import (
+	"runtime"
+	"time"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// Argon2Params are the cost parameters of Argon2id
+type Argon2Params struct {
+	Time    uint32 `json:"time"`    // Passes over memory
+	Memory  uint32 `json:"memory"`  // KiB
+	Threads uint8  `json:"threads"` // Lanes computed in parallel
+}
+
+// DefaultArgon2Params returns the parameters used when none are configured
+func DefaultArgon2Params() Argon2Params {
+	return Argon2Params{
+		Time:    argon2Time,
+		Memory:  argon2Memory,
+		Threads: argon2Threads,
+	}
+}
+
+// WithDefaults fills unset parameters from the defaults
+func (p Argon2Params) WithDefaults() Argon2Params {
+	def := DefaultArgon2Params()
+	if p.Time == 0 {
+		p.Time = def.Time
+	}
+	if p.Memory == 0 {
+		p.Memory = def.Memory
+	}
+	if p.Threads == 0 {
+		p.Threads = def.Threads
+	}
+	return p
+}
+
+// deriveArgon2 derives a key with Argon2id
+func deriveArgon2(passphrase string, salt []byte, p Argon2Params) []byte {
+	return argon2.IDKey([]byte(passphrase), salt, p.Time, p.Memory, p.Threads, argon2KeyLen)
+}
+
+// BenchmarkArgon2 measures one key derivation with the given parameters
+func BenchmarkArgon2(p Argon2Params) time.Duration {
+	salt := make([]byte, saltSize)
+	start := time.Now()
+	deriveArgon2("snapsync-benchmark", salt, p)
+	return time.Since(start)
+}
+
+// Argon2Trial is one measured parameter set
+type Argon2Trial struct {
+	Params   Argon2Params
+	Duration time.Duration
+}
+
+// RecommendArgon2 picks the strongest parameters that derive a key in about
+// target on this machine, using at most maxMemory KiB
+// Memory is raised first, since it is what makes attacks on GPUs and ASICs
+// expensive, then the number of passes fills the remaining time. Every
+// measurement is returned along with the recommendation.
+func RecommendArgon2(target time.Duration, maxMemory uint32) (Argon2Params, []Argon2Trial) {
+	threads := runtime.NumCPU()
+	if threads > 8 {
+		threads = 8
+	}
+
+	var trials []Argon2Trial
+	best := Argon2Params{Time: 1, Memory: argon2Memory, Threads: uint8(threads)}
+	var bestDuration time.Duration
+
+	for memory := uint32(argon2Memory); memory <= maxMemory; memory *= 2 {
+		p := Argon2Params{Time: 1, Memory: memory, Threads: uint8(threads)}
+		d := BenchmarkArgon2(p)
+		trials = append(trials, Argon2Trial{Params: p, Duration: d})
+		if d > target && memory > argon2Memory {
+			break
+		}
+		best, bestDuration = p, d
+		if d > target {
+			break
+		}
+	}
+
+	if bestDuration > 0 && bestDuration < target {
+		best.Time = uint32(target / bestDuration)
+	}
+	if best.Memory == argon2Memory && best.Time < argon2Time {
+		// Never go below the default cost
+		best.Time = argon2Time
+	}
+
+	if best.Time > 1 {
+		trials = append(trials, Argon2Trial{Params: best, Duration: BenchmarkArgon2(best)})
+	}
+
+	return best, trials
+}
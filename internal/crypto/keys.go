@@ -0,0 +1,209 @@
+package crypto
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrWrongPassword is returned when no key slot opens with a passphrase
+var ErrWrongPassword = errors.New("incorrect password")
+
+// KeySlot is one passphrase that unlocks the repository: the master key
+// encrypted with a key derived from the passphrase
+// Changing or removing a passphrase rewrites its slot only; the master key,
+// and with it every chunk, stays the same.
+type KeySlot struct {
+	ID      string        `json:"id"`
+	Label   string        `json:"label,omitempty"`
+	Created time.Time     `json:"created"`
+	KDF     string        `json:"kdf"`
+	Argon2  *Argon2Params `json:"argon2,omitempty"`
+	Salt    string        `json:"salt"`    // Hex-encoded, unique to the slot
+	Wrapped string        `json:"wrapped"` // Hex-encoded nonce and sealed master key
+}
+
+// GenerateMasterKey returns a random key for encrypting repository data
+func GenerateMasterKey() ([]byte, error) {
+	key := make([]byte, argon2KeyLen)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("failed to generate master key: %w", err)
+	}
+	return key, nil
+}
+
+// NewKeySlot wraps the master key with a key derived from passphrase
+func NewKeySlot(master []byte, passphrase, label, kdf string, params Argon2Params) (*KeySlot, error) {
+	id := make([]byte, 4)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	slot := &KeySlot{ID: hex.EncodeToString(id), Label: label, Created: time.Now().UTC()}
+	if err := slot.wrap(master, passphrase, kdf, params); err != nil {
+		return nil, err
+	}
+	return slot, nil
+}
+
+// Rewrap wraps the master key again under a new passphrase, keeping the
+// slot's ID and label
+func (s *KeySlot) Rewrap(master []byte, passphrase string) error {
+	return s.wrap(master, passphrase, s.KDF, s.Argon2Params())
+}
+
+// Unwrap derives the slot's key from passphrase and returns the master key
+func (s *KeySlot) Unwrap(passphrase string) ([]byte, error) {
+	salt, err := hex.DecodeString(s.Salt)
+	if err != nil {
+		return nil, fmt.Errorf("invalid salt in key slot %s", s.ID)
+	}
+	wrapped, err := hex.DecodeString(s.Wrapped)
+	if err != nil || len(wrapped) < nonceSize {
+		return nil, fmt.Errorf("invalid key slot %s", s.ID)
+	}
+
+	kek, err := DeriveKeyWithParams(passphrase, salt, s.KDF, s.Argon2Params())
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newKeyWrap(kek)
+	if err != nil {
+		return nil, err
+	}
+
+	master, err := aead.Open(nil, wrapped[:nonceSize], wrapped[nonceSize:], []byte(s.ID))
+	if err != nil {
+		return nil, ErrWrongPassword
+	}
+	return master, nil
+}
+
+// Argon2Params returns the Argon2id parameters of the slot's key
+func (s *KeySlot) Argon2Params() Argon2Params {
+	if s.Argon2 == nil {
+		return DefaultArgon2Params()
+	}
+	return s.Argon2.WithDefaults()
+}
+
+// wrap seals the master key with a key derived from passphrase and a fresh
+// salt; the slot ID is authenticated so wrapped keys cannot be swapped
+func (s *KeySlot) wrap(master []byte, passphrase, kdf string, params Argon2Params) error {
+	salt, err := GenerateSalt()
+	if err != nil {
+		return fmt.Errorf("failed to generate salt: %w", err)
+	}
+	kek, err := DeriveKeyWithParams(passphrase, salt, kdf, params)
+	if err != nil {
+		return err
+	}
+	aead, err := newKeyWrap(kek)
+	if err != nil {
+		return err
+	}
+
+	nonce := make([]byte, nonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return fmt.Errorf("failed to generate nonce: %w", err)
+	}
+
+	s.KDF = kdf
+	s.Argon2 = nil
+	if kdf == KDFArgon2id || kdf == "" {
+		s.Argon2 = &params
+	}
+	s.Salt = hex.EncodeToString(salt)
+	s.Wrapped = hex.EncodeToString(aead.Seal(nonce, nonce, master, []byte(s.ID)))
+	return nil
+}
+
+// newKeyWrap returns the AES-GCM cipher that wraps master keys
+func newKeyWrap(kek []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create AES cipher: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// HasKeySlots reports whether the repository key is wrapped in key slots
+// rather than derived from a single password
+func (h *EncryptionHeader) HasKeySlots() bool {
+	return len(h.Keys) > 0
+}
+
+// Unlock returns the master key and the slot that opened with passphrase
+// Repositories without key slots derive the key from the password directly
+// and return a nil slot.
+func (h *EncryptionHeader) Unlock(passphrase string) ([]byte, *KeySlot, error) {
+	if !h.HasKeySlots() {
+		salt, _ := hex.DecodeString(h.Salt)
+		key, err := DeriveKeyWithParams(passphrase, salt, h.KDF, h.Argon2Params())
+		if err != nil {
+			return nil, nil, err
+		}
+		if !h.VerifyKey(key) {
+			return nil, nil, ErrWrongPassword
+		}
+		return key, nil, nil
+	}
+
+	for _, slot := range h.Keys {
+		master, err := slot.Unwrap(passphrase)
+		if errors.Is(err, ErrWrongPassword) {
+			continue
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		if !h.VerifyKey(master) {
+			return nil, nil, fmt.Errorf("key slot %s holds a different key", slot.ID)
+		}
+		return master, slot, nil
+	}
+	return nil, nil, ErrWrongPassword
+}
+
+// AddKeySlot adds a passphrase that unlocks the master key
+func (h *EncryptionHeader) AddKeySlot(master []byte, passphrase, label string) (*KeySlot, error) {
+	if !h.VerifyKey(master) {
+		return nil, fmt.Errorf("master key does not match the repository")
+	}
+
+	slot, err := NewKeySlot(master, passphrase, label, h.KDF, h.Argon2Params())
+	if err != nil {
+		return nil, err
+	}
+	h.Keys = append(h.Keys, slot)
+	h.Version = keySlotVersion
+	return slot, nil
+}
+
+// RemoveKeySlot removes the slot with the given ID or ID prefix
+// The last slot cannot be removed, since the repository could no longer
+// be unlocked.
+func (h *EncryptionHeader) RemoveKeySlot(id string) (*KeySlot, error) {
+	index := -1
+	for i, slot := range h.Keys {
+		if slot.ID == id || (len(id) >= 4 && len(id) < len(slot.ID) && slot.ID[:len(id)] == id) {
+			if index >= 0 {
+				return nil, fmt.Errorf("key ID is ambiguous: %s", id)
+			}
+			index = i
+		}
+	}
+	if index < 0 {
+		return nil, fmt.Errorf("no key with ID %s", id)
+	}
+	if len(h.Keys) == 1 {
+		return nil, fmt.Errorf("cannot remove the last key")
+	}
+
+	removed := h.Keys[index]
+	h.Keys = append(h.Keys[:index], h.Keys[index+1:]...)
+	return removed, nil
+}
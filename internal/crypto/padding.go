@@ -0,0 +1,59 @@
+package crypto
+
+import (
+	"fmt"
+	"math/bits"
+)
+
+// Padding schemes applied to plaintext before encryption
+const (
+	PaddingNone  = "none"
+	PaddingPadme = "padme" // Padmé: leaks O(log log n) bits of the size
+)
+
+// paddingMarker separates data from the zero padding that follows it
+const paddingMarker = 0x80
+
+// CheckPadding verifies that a padding scheme is known
+func CheckPadding(scheme string) error {
+	switch scheme {
+	case PaddingNone, PaddingPadme, "":
+		return nil
+	default:
+		return fmt.Errorf("unknown padding scheme: %s", scheme)
+	}
+}
+
+// padmeLength returns the Padmé padded length for n bytes
+func padmeLength(n int) int {
+	if n < 2 {
+		return n
+	}
+	e := bits.Len(uint(n)) - 1   // floor(log2(n))
+	s := bits.Len(uint(e))       // floor(log2(e)) + 1
+	mask := (1 << uint(e-s)) - 1 // Low bits that may be rounded away
+	return (n + mask) &^ mask
+}
+
+// pad appends a marker byte and zeros up to the Padmé length
+func pad(data []byte) []byte {
+	n := padmeLength(len(data) + 1)
+	padded := make([]byte, n)
+	copy(padded, data)
+	padded[len(data)] = paddingMarker
+	return padded
+}
+
+// unpad strips the marker byte and zeros added by pad
+func unpad(data []byte) ([]byte, error) {
+	for i := len(data) - 1; i >= 0; i-- {
+		switch data[i] {
+		case 0:
+			continue
+		case paddingMarker:
+			return data[:i], nil
+		}
+		break
+	}
+	return nil, fmt.Errorf("invalid padding")
+}
@@ -0,0 +1,73 @@
+package crypto
+
+import (
+	"crypto/sha256"
+	"fmt"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// Supported algorithms and key derivation functions
+const (
+	AlgorithmAESGCM = "aes-256-gcm"
+	KDFArgon2id     = "argon2id"
+	KDFPBKDF2       = "pbkdf2-sha256"
+)
+
+// Crypto policies
+const (
+	PolicyDefault = "default"
+	PolicyFIPS    = "fips" // FIPS 140 approved algorithms only
+)
+
+// pbkdf2Iterations follows the OWASP recommendation for PBKDF2-HMAC-SHA256
+const pbkdf2Iterations = 600000
+
+// DeriveKey derives an encryption key from a passphrase with the given KDF
+// An empty KDF means Argon2id, the default for repositories created before
+// the KDF was configurable.
+func DeriveKey(passphrase string, salt []byte, kdf string) ([]byte, error) {
+	return DeriveKeyWithParams(passphrase, salt, kdf, DefaultArgon2Params())
+}
+
+// DeriveKeyWithParams derives a key like DeriveKey with custom Argon2id
+// parameters; they are ignored by other KDFs
+func DeriveKeyWithParams(passphrase string, salt []byte, kdf string, params Argon2Params) ([]byte, error) {
+	switch kdf {
+	case KDFArgon2id, "":
+		return deriveArgon2(passphrase, salt, params.WithDefaults()), nil
+	case KDFPBKDF2:
+		return pbkdf2.Key([]byte(passphrase), salt, pbkdf2Iterations, argon2KeyLen, sha256.New), nil
+	default:
+		return nil, fmt.Errorf("unsupported key derivation function: %s", kdf)
+	}
+}
+
+// CheckPolicy verifies that an algorithm and KDF are allowed by a policy
+func CheckPolicy(policy, algorithm, kdf string) error {
+	if algorithm == "" {
+		algorithm = AlgorithmAESGCM
+	}
+	if kdf == "" {
+		kdf = KDFArgon2id
+	}
+
+	if algorithm != AlgorithmAESGCM {
+		return fmt.Errorf("unsupported encryption algorithm: %s", algorithm)
+	}
+
+	switch policy {
+	case PolicyDefault, "":
+		if kdf != KDFArgon2id && kdf != KDFPBKDF2 {
+			return fmt.Errorf("unsupported key derivation function: %s", kdf)
+		}
+	case PolicyFIPS:
+		if kdf != KDFPBKDF2 {
+			return fmt.Errorf("key derivation function %s is not allowed by the fips policy (use %s)", kdf, KDFPBKDF2)
+		}
+	default:
+		return fmt.Errorf("unknown crypto policy: %s", policy)
+	}
+
+	return nil
+}
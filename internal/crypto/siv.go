@@ -0,0 +1,122 @@
+package crypto
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+)
+
+// Subkey labels, so deterministic encryption never reuses the data key
+const (
+	sivMacLabel = "snapsync-siv-mac"
+	sivEncLabel = "snapsync-siv-enc"
+)
+
+// deterministic holds the subkeys for deterministic encryption
+type deterministic struct {
+	macKey []byte
+	cipher cipher.AEAD
+}
+
+// newDeterministic derives the deterministic encryption subkeys from key
+func newDeterministic(key []byte) (*deterministic, error) {
+	block, err := aes.NewCipher(subkey(key, sivEncLabel))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create AES cipher: %w", err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create GCM: %w", err)
+	}
+	return &deterministic{macKey: subkey(key, sivMacLabel), cipher: gcm}, nil
+}
+
+// subkey derives an independent key for label
+func subkey(key []byte, label string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(label))
+	return mac.Sum(nil)
+}
+
+// syntheticNonce derives the nonce from the plaintext, SIV style
+func (d *deterministic) syntheticNonce(plaintext []byte) []byte {
+	mac := hmac.New(sha256.New, d.macKey)
+	mac.Write(plaintext)
+	return mac.Sum(nil)[:nonceSize]
+}
+
+// SealDeterministic encrypts plaintext so equal inputs give equal outputs
+// Used for metadata that must stay deduplicated or searchable by the key
+// holder; it reveals only whether two plaintexts are equal.
+func (e *Encryptor) SealDeterministic(plaintext []byte) ([]byte, error) {
+	d, err := e.deterministic()
+	if err != nil {
+		return nil, err
+	}
+
+	if e.padding == PaddingPadme {
+		plaintext = pad(plaintext)
+	}
+
+	nonce := d.syntheticNonce(plaintext)
+	return d.cipher.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+// OpenDeterministic decrypts data sealed with SealDeterministic
+func (e *Encryptor) OpenDeterministic(data []byte) ([]byte, error) {
+	d, err := e.deterministic()
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < nonceSize {
+		return nil, fmt.Errorf("ciphertext too short")
+	}
+
+	nonce := data[:nonceSize]
+	plaintext, err := d.cipher.Open(nil, nonce, data[nonceSize:], nil)
+	if err != nil {
+		return nil, fmt.Errorf("decryption failed: %w", err)
+	}
+	if subtle.ConstantTimeCompare(nonce, d.syntheticNonce(plaintext)) != 1 {
+		return nil, fmt.Errorf("decryption failed: nonce mismatch")
+	}
+
+	if e.padding == PaddingPadme {
+		return unpad(plaintext)
+	}
+	return plaintext, nil
+}
+
+// EncryptName deterministically encrypts a file name or path
+func (e *Encryptor) EncryptName(name string) (string, error) {
+	sealed, err := e.SealDeterministic([]byte(name))
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+// DecryptName reverses EncryptName
+func (e *Encryptor) DecryptName(encrypted string) (string, error) {
+	sealed, err := base64.RawURLEncoding.DecodeString(encrypted)
+	if err != nil {
+		return "", fmt.Errorf("invalid encrypted name: %w", err)
+	}
+	name, err := e.OpenDeterministic(sealed)
+	if err != nil {
+		return "", err
+	}
+	return string(name), nil
+}
+
+// deterministic returns the deterministic cipher, creating it on first use
+func (e *Encryptor) deterministic() (*deterministic, error) {
+	e.detOnce.Do(func() {
+		e.det, e.detErr = newDeterministic(e.key)
+	})
+	return e.det, e.detErr
+}
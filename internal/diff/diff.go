@@ -18,6 +18,7 @@ type DiffResult struct {
 	Modified      []*models.FileDiff
 	Deleted       []*models.FileDiff
 	Unchanged     []*models.FileDiff
+	Renamed       []*models.FileDiff
 	TotalAdded    int64
 	TotalDeleted  int64
 	TotalModified int64
@@ -98,9 +99,63 @@ func (d *Differ) Compare(oldTree, newTree *models.FileTree) *DiffResult {
 		}
 	}
 
+	d.detectRenames(result)
+
 	return result
 }
 
+// detectRenames pairs added files with deleted files of the same content
+// Each deleted file is matched at most once; further copies stay added.
+func (d *Differ) detectRenames(result *DiffResult) {
+	if len(result.Added) == 0 || len(result.Deleted) == 0 {
+		return
+	}
+
+	deletedByHash := make(map[string][]*models.FileDiff)
+	for _, del := range result.Deleted {
+		if del.OldHash != "" {
+			deletedByHash[del.OldHash] = append(deletedByHash[del.OldHash], del)
+		}
+	}
+
+	matched := make(map[*models.FileDiff]bool)
+	var added []*models.FileDiff
+	for _, add := range result.Added {
+		candidates := deletedByHash[add.NewHash]
+		if len(candidates) == 0 {
+			added = append(added, add)
+			continue
+		}
+
+		del := candidates[0]
+		deletedByHash[add.NewHash] = candidates[1:]
+		matched[del] = true
+
+		result.Renamed = append(result.Renamed, &models.FileDiff{
+			Path:      add.Path,
+			OldPath:   del.Path,
+			Type:      models.DiffRenamed,
+			OldHash:   del.OldHash,
+			NewHash:   add.NewHash,
+			OldSize:   del.OldSize,
+			NewSize:   add.NewSize,
+			OldChunks: del.OldChunks,
+			NewChunks: add.NewChunks,
+		})
+		result.TotalAdded -= add.NewSize
+		result.TotalDeleted -= del.OldSize
+	}
+	result.Added = added
+
+	var deleted []*models.FileDiff
+	for _, del := range result.Deleted {
+		if !matched[del] {
+			deleted = append(deleted, del)
+		}
+	}
+	result.Deleted = deleted
+}
+
 // ChunkDiff identifies which chunks need to be stored
 type ChunkDiff struct {
 	NewChunks      []string // Chunks that don't exist in CAS
@@ -129,6 +184,7 @@ func (r *DiffResult) Stats() *models.SnapshotStats {
 		FilesModified:  len(r.Modified),
 		FilesDeleted:   len(r.Deleted),
 		FilesUnchanged: len(r.Unchanged),
+		FilesRenamed:   len(r.Renamed),
 	}
 }
 
@@ -0,0 +1,81 @@
+package fssnap
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// APFSSnapshot is a Time Machine local snapshot mounted read-only
+type APFSSnapshot struct {
+	date     string // Snapshot date as reported by tmutil
+	mountDir string
+	path     string
+}
+
+// NewAPFSSnapshot creates a local snapshot with tmutil and mounts the
+// snapshot of the volume containing the source
+func NewAPFSSnapshot(mount *Mount) (*APFSSnapshot, error) {
+	if runtime.GOOS != "darwin" {
+		return nil, fmt.Errorf("APFS snapshots are only supported on macOS")
+	}
+
+	// Output: "Created local snapshot with date: 2024-01-02-030405"
+	out, err := run("tmutil", "localsnapshot")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create APFS snapshot: %w", err)
+	}
+	idx := strings.LastIndex(out, ":")
+	if idx < 0 {
+		return nil, fmt.Errorf("unexpected tmutil output: %q", out)
+	}
+
+	snap := &APFSSnapshot{date: strings.TrimSpace(out[idx+1:])}
+
+	snap.mountDir, err = makeMountDir()
+	if err != nil {
+		snap.Close()
+		return nil, fmt.Errorf("failed to create mount directory: %w", err)
+	}
+
+	name := "com.apple.TimeMachine." + snap.date + ".local"
+	if _, err := run("mount_apfs", "-o", "ro", "-s", name, mount.Target, snap.mountDir); err != nil {
+		os.Remove(snap.mountDir)
+		snap.mountDir = ""
+		snap.Close()
+		return nil, fmt.Errorf("failed to mount APFS snapshot: %w", err)
+	}
+
+	snap.path = filepath.Join(snap.mountDir, mount.RelPath)
+	return snap, nil
+}
+
+// Path returns the source path inside the mounted snapshot
+func (s *APFSSnapshot) Path() string {
+	return s.path
+}
+
+// Close unmounts and deletes the local snapshot
+func (s *APFSSnapshot) Close() error {
+	var firstErr error
+
+	if s.mountDir != "" {
+		if _, err := run("umount", s.mountDir); err != nil {
+			firstErr = err
+		} else {
+			os.Remove(s.mountDir)
+		}
+		s.mountDir = ""
+	}
+
+	if s.date != "" {
+		if _, err := run("tmutil", "deletelocalsnapshots", s.date); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		s.date = ""
+	}
+
+	return firstErr
+}
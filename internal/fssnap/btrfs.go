@@ -0,0 +1,113 @@
+package fssnap
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// btrfsSnapshotPrefix starts the names of snapshots in the top-level subvolume
+const btrfsSnapshotPrefix = ".snapsync-"
+
+// BtrfsSnapshot is a read-only btrfs snapshot of the subvolume holding the
+// source. Subvolumes nested under the source are not part of it.
+type BtrfsSnapshot struct {
+	mountDir string // Top-level subvolume, mounted for the snapshot's lifetime
+	snapDir  string
+	path     string
+}
+
+// NewBtrfsSnapshot creates a read-only snapshot of the subvolume holding the
+// source. The snapshot goes in the file system's top-level subvolume, which
+// is mounted on a directory of its own, so it does not show up under the
+// source's mount unless that mount is the top-level subvolume itself.
+func NewBtrfsSnapshot(mount *Mount) (*BtrfsSnapshot, error) {
+	subvol, err := subvolumeRoot(mount)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find the subvolume of %s: %w", mount.SourcePath, err)
+	}
+	rel, err := filepath.Rel(subvol, mount.SourcePath)
+	if err != nil {
+		return nil, err
+	}
+
+	snap := &BtrfsSnapshot{}
+	snap.mountDir, err = makeMountDir()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create mount directory: %w", err)
+	}
+
+	// findmnt reports a subvolume mount as device[/subvolume]
+	device, _, _ := strings.Cut(mount.Source, "[")
+	if _, err := run("mount", "-t", "btrfs", "-o", "subvolid=5", device, snap.mountDir); err != nil {
+		os.Remove(snap.mountDir)
+		return nil, fmt.Errorf("failed to mount btrfs top-level subvolume: %w", err)
+	}
+
+	snapDir := filepath.Join(snap.mountDir, fmt.Sprintf("%s%d", btrfsSnapshotPrefix, time.Now().Unix()))
+	if _, err := run("btrfs", "subvolume", "snapshot", "-r", subvol, snapDir); err != nil {
+		snap.Close()
+		return nil, fmt.Errorf("failed to create btrfs snapshot: %w", err)
+	}
+
+	snap.snapDir = snapDir
+	snap.path = filepath.Join(snapDir, rel)
+	return snap, nil
+}
+
+// subvolumeRoot returns the root of the subvolume holding the source: the
+// highest directory above it, up to its mount point, on the same device.
+// It is the mount point unless the source is in a nested subvolume.
+func subvolumeRoot(mount *Mount) (string, error) {
+	dev, err := deviceOf(mount.SourcePath)
+	if err != nil {
+		return "", err
+	}
+
+	root := mount.SourcePath
+	for root != mount.Target {
+		parent := filepath.Dir(root)
+		if parent == root {
+			break
+		}
+		parentDev, err := deviceOf(parent)
+		if err != nil {
+			return "", err
+		}
+		if parentDev != dev {
+			break
+		}
+		root = parent
+	}
+	return root, nil
+}
+
+// Path returns the source path inside the snapshot
+func (s *BtrfsSnapshot) Path() string {
+	return s.path
+}
+
+// Close deletes the snapshot subvolume and unmounts the top-level subvolume
+func (s *BtrfsSnapshot) Close() error {
+	var firstErr error
+
+	if s.snapDir != "" {
+		if _, err := run("btrfs", "subvolume", "delete", s.snapDir); err != nil {
+			firstErr = err
+		}
+		s.snapDir = ""
+	}
+
+	if s.mountDir != "" {
+		if _, err := run("umount", s.mountDir); err != nil && firstErr == nil {
+			firstErr = err
+		} else if err == nil {
+			os.Remove(s.mountDir)
+		}
+		s.mountDir = ""
+	}
+
+	return firstErr
+}
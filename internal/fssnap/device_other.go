@@ -0,0 +1,19 @@
+//go:build !linux && !darwin
+
+package fssnap
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+)
+
+// deviceOf returns the device number of the file system holding path
+func deviceOf(path string) (uint64, error) {
+	return 0, fmt.Errorf("device numbers are not supported on %s", runtime.GOOS)
+}
+
+// infoDevice returns the device number recorded in info
+func infoDevice(info os.FileInfo) (uint64, error) {
+	return 0, fmt.Errorf("device numbers are not supported on %s", runtime.GOOS)
+}
@@ -0,0 +1,28 @@
+//go:build linux || darwin
+
+package fssnap
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// deviceOf returns the device number of the file system holding path
+// Every btrfs subvolume and ZFS dataset has a device number of its own.
+func deviceOf(path string) (uint64, error) {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return 0, err
+	}
+	return infoDevice(info)
+}
+
+// infoDevice returns the device number recorded in info
+func infoDevice(info os.FileInfo) (uint64, error) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, fmt.Errorf("no device number for %s", info.Name())
+	}
+	return uint64(st.Dev), nil
+}
@@ -0,0 +1,84 @@
+package fssnap
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// Snapshot is a read-only, point-in-time view of a backup source
+type Snapshot interface {
+	// Path returns the location to back up from
+	Path() string
+
+	// Close tears the snapshot down
+	Close() error
+}
+
+// Mount describes the filesystem a path lives on
+type Mount struct {
+	Source     string // Device or dataset backing the filesystem
+	Target     string // Mount point
+	FSType     string // Filesystem type (ext4, xfs, btrfs, zfs, ...)
+	RelPath    string // Path of the source relative to the mount point
+	SourcePath string // Absolute source path
+}
+
+// New creates a filesystem-level snapshot for sourcePath, choosing the
+// mechanism from the type of filesystem it lives on
+func New(sourcePath string) (Snapshot, error) {
+	mount, err := FindMount(sourcePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find mount for %s: %w", sourcePath, err)
+	}
+
+	// A snapshot covers one subvolume or dataset; the ones nested under
+	// the source would come out empty
+	if mount.FSType == "btrfs" || mount.FSType == "zfs" {
+		nested, err := nestedVolumes(mount)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan %s for nested volumes: %w", sourcePath, err)
+		}
+		if len(nested) > 0 {
+			return nil, fmt.Errorf("%s contains other %s volumes a snapshot would leave empty: %s; back them up as sources of their own",
+				sourcePath, mount.FSType, strings.Join(nested, ", "))
+		}
+	}
+
+	switch mount.FSType {
+	case "btrfs":
+		return NewBtrfsSnapshot(mount)
+	case "zfs":
+		return NewZFSSnapshot(mount)
+	case "apfs":
+		return NewAPFSSnapshot(mount)
+	default:
+		return nil, fmt.Errorf("filesystem snapshots are not supported on %s (%s)", mount.FSType, mount.Target)
+	}
+}
+
+// run executes a command and returns its trimmed stdout
+// Failures include stderr so the user can see why the tool refused
+func run(name string, args ...string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(name, args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		msg := strings.TrimSpace(stderr.String())
+		if msg == "" {
+			return "", fmt.Errorf("%s failed: %w", name, err)
+		}
+		return "", fmt.Errorf("%s failed: %w: %s", name, err, msg)
+	}
+
+	return strings.TrimSpace(stdout.String()), nil
+}
+
+// makeMountDir creates a temporary directory to mount a snapshot on
+func makeMountDir() (string, error) {
+	return os.MkdirTemp("", "snapsync-snap-")
+}
@@ -0,0 +1,104 @@
+package fssnap
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// DefaultLVMSnapshotSize is the copy-on-write space reserved for changes
+// made to the origin volume while the backup runs
+const DefaultLVMSnapshotSize = "1G"
+
+// LVMSnapshot is a mounted LVM snapshot of a logical volume
+type LVMSnapshot struct {
+	device   string // /dev/<vg>/<snapshot>
+	mountDir string
+	path     string
+}
+
+// NewLVMSnapshot snapshots the logical volume containing sourcePath,
+// mounts it read-only and returns the snapshot view of sourcePath
+func NewLVMSnapshot(sourcePath, size string) (*LVMSnapshot, error) {
+	if runtime.GOOS != "linux" {
+		return nil, fmt.Errorf("LVM snapshots are only supported on Linux")
+	}
+	if size == "" {
+		size = DefaultLVMSnapshotSize
+	}
+
+	mount, err := FindMount(sourcePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find mount for %s: %w", sourcePath, err)
+	}
+
+	// Confirm the filesystem sits on a logical volume
+	out, err := run("lvs", "--noheadings", "-o", "vg_name,lv_name", mount.Source)
+	if err != nil {
+		return nil, fmt.Errorf("%s is not an LVM logical volume: %w", mount.Source, err)
+	}
+	fields := strings.Fields(out)
+	if len(fields) < 2 {
+		return nil, fmt.Errorf("unexpected lvs output: %q", out)
+	}
+	vg, lv := fields[0], fields[1]
+
+	name := fmt.Sprintf("%s-snapsync-%d", lv, time.Now().Unix())
+	if _, err := run("lvcreate", "--snapshot", "--name", name, "--size", size, vg+"/"+lv); err != nil {
+		return nil, fmt.Errorf("failed to create LVM snapshot: %w", err)
+	}
+
+	snap := &LVMSnapshot{device: filepath.Join("/dev", vg, name)}
+
+	snap.mountDir, err = makeMountDir()
+	if err != nil {
+		snap.Close()
+		return nil, fmt.Errorf("failed to create mount directory: %w", err)
+	}
+
+	// XFS refuses to mount a snapshot alongside its origin without nouuid
+	opts := "ro"
+	if mount.FSType == "xfs" {
+		opts += ",nouuid"
+	}
+	if _, err := run("mount", "-o", opts, snap.device, snap.mountDir); err != nil {
+		os.Remove(snap.mountDir)
+		snap.mountDir = ""
+		snap.Close()
+		return nil, fmt.Errorf("failed to mount LVM snapshot: %w", err)
+	}
+
+	snap.path = filepath.Join(snap.mountDir, mount.RelPath)
+	return snap, nil
+}
+
+// Path returns the source path inside the mounted snapshot
+func (s *LVMSnapshot) Path() string {
+	return s.path
+}
+
+// Close unmounts and removes the snapshot volume
+func (s *LVMSnapshot) Close() error {
+	var firstErr error
+
+	if s.mountDir != "" {
+		if _, err := run("umount", s.mountDir); err != nil {
+			firstErr = err
+		} else {
+			os.Remove(s.mountDir)
+		}
+		s.mountDir = ""
+	}
+
+	if s.device != "" {
+		if _, err := run("lvremove", "-f", s.device); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		s.device = ""
+	}
+
+	return firstErr
+}
@@ -0,0 +1,45 @@
+package fssnap
+
+import (
+	"path/filepath"
+	"syscall"
+)
+
+// FindMount resolves the filesystem that contains path
+func FindMount(path string) (*Mount, error) {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(absPath, &st); err != nil {
+		return nil, err
+	}
+
+	target := cString(st.Mntonname[:])
+	rel, err := filepath.Rel(target, absPath)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Mount{
+		Source:     cString(st.Mntfromname[:]),
+		Target:     target,
+		FSType:     cString(st.Fstypename[:]),
+		RelPath:    rel,
+		SourcePath: absPath,
+	}, nil
+}
+
+// cString converts a NUL-terminated statfs field to a string
+func cString(b []int8) string {
+	buf := make([]byte, 0, len(b))
+	for _, c := range b {
+		if c == 0 {
+			break
+		}
+		buf = append(buf, byte(c))
+	}
+	return string(buf)
+}
@@ -0,0 +1,38 @@
+package fssnap
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// FindMount resolves the filesystem that contains path
+func FindMount(path string) (*Mount, error) {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := run("findmnt", "-n", "-o", "SOURCE,TARGET,FSTYPE", "--target", absPath)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := strings.Fields(out)
+	if len(fields) < 3 {
+		return nil, fmt.Errorf("unexpected findmnt output: %q", out)
+	}
+
+	rel, err := filepath.Rel(fields[1], absPath)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Mount{
+		Source:     fields[0],
+		Target:     fields[1],
+		FSType:     fields[2],
+		RelPath:    rel,
+		SourcePath: absPath,
+	}, nil
+}
@@ -0,0 +1,13 @@
+//go:build !linux && !darwin
+
+package fssnap
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// FindMount resolves the filesystem that contains path
+func FindMount(path string) (*Mount, error) {
+	return nil, fmt.Errorf("filesystem snapshots are not supported on %s", runtime.GOOS)
+}
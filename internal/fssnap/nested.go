@@ -0,0 +1,62 @@
+package fssnap
+
+import (
+	"io/fs"
+	"path/filepath"
+	"strings"
+)
+
+// nestedVolumes returns the directories under the source that are roots of
+// other volumes of the source's file system type: btrfs subvolumes, nested
+// or mounted, and ZFS child datasets. A snapshot of the source's own volume
+// holds each of them as an empty directory. Mounts of other file system
+// types are not descended into.
+func nestedVolumes(mount *Mount) ([]string, error) {
+	rootDev, err := deviceOf(mount.SourcePath)
+	if err != nil {
+		return nil, err
+	}
+
+	var nested []string
+	err = filepath.WalkDir(mount.SourcePath, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// The backup itself reports what it cannot read
+			if path == mount.SourcePath {
+				return err
+			}
+			return nil
+		}
+		if !d.IsDir() || path == mount.SourcePath {
+			return nil
+		}
+		if ownSnapshotDir(mount, path, d.Name()) {
+			return filepath.SkipDir
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		if dev, err := infoDevice(info); err != nil || dev == rootDev {
+			return nil
+		}
+		if inner, err := FindMount(path); err == nil && inner.FSType == mount.FSType {
+			nested = append(nested, path)
+		}
+		return filepath.SkipDir
+	})
+	return nested, err
+}
+
+// ownSnapshotDir reports whether path holds snapshots rather than data:
+// the .zfs directory of a dataset, or a snapshot another backup took of a
+// btrfs file system mounted at its top-level subvolume
+func ownSnapshotDir(mount *Mount, path, name string) bool {
+	switch mount.FSType {
+	case "zfs":
+		return name == ".zfs"
+	case "btrfs":
+		return strings.HasPrefix(name, btrfsSnapshotPrefix) && filepath.Dir(path) == mount.Target
+	}
+	return false
+}
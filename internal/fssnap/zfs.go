@@ -0,0 +1,43 @@
+package fssnap
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+)
+
+// ZFSSnapshot is a ZFS snapshot of the dataset containing the source,
+// read through the dataset's .zfs/snapshot directory
+type ZFSSnapshot struct {
+	name string // dataset@snapshot
+	path string
+}
+
+// NewZFSSnapshot snapshots the dataset mounted at the source's mount point
+func NewZFSSnapshot(mount *Mount) (*ZFSSnapshot, error) {
+	snapName := fmt.Sprintf("snapsync-%d", time.Now().Unix())
+	name := mount.Source + "@" + snapName
+	if _, err := run("zfs", "snapshot", name); err != nil {
+		return nil, fmt.Errorf("failed to create ZFS snapshot: %w", err)
+	}
+
+	return &ZFSSnapshot{
+		name: name,
+		path: filepath.Join(mount.Target, ".zfs", "snapshot", snapName, mount.RelPath),
+	}, nil
+}
+
+// Path returns the source path inside the snapshot
+func (s *ZFSSnapshot) Path() string {
+	return s.path
+}
+
+// Close destroys the snapshot
+func (s *ZFSSnapshot) Close() error {
+	if s.name == "" {
+		return nil
+	}
+	_, err := run("zfs", "destroy", s.name)
+	s.name = ""
+	return err
+}
@@ -0,0 +1,76 @@
+package hooks
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+	"time"
+)
+
+// Hook is a shell command run around an operation, such as stopping a
+// service before a restore and starting it again afterwards
+type Hook struct {
+	Name    string        // Stage, e.g. "pre-restore"
+	Command string        // Run with sh -c, or cmd /C on Windows
+	Env     []string      // Extra KEY=value pairs
+	Timeout time.Duration // 0 = no limit
+}
+
+// Error reports a hook that failed
+type Error struct {
+	Name    string
+	Command string
+	Err     error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s hook %q failed: %v", e.Name, e.Command, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Run runs a hook with its output sent to stdout and stderr
+// The hook's environment is the caller's plus SNAPSYNC_HOOK and Env.
+func Run(hook Hook, stdout, stderr io.Writer) error {
+	ctx := context.Background()
+	if hook.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, hook.Timeout)
+		defer cancel()
+	}
+
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", hook.Command)
+	} else {
+		cmd = exec.CommandContext(ctx, "sh", "-c", hook.Command)
+	}
+	cmd.Env = append(os.Environ(), "SNAPSYNC_HOOK="+hook.Name)
+	cmd.Env = append(cmd.Env, hook.Env...)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+
+	err := cmd.Run()
+	if ctx.Err() == context.DeadlineExceeded {
+		err = fmt.Errorf("timed out after %s", hook.Timeout)
+	}
+	if err != nil {
+		return &Error{Name: hook.Name, Command: hook.Command, Err: err}
+	}
+	return nil
+}
+
+// RunAll runs hooks in order, stopping at the first that fails
+func RunAll(hooks []Hook, stdout, stderr io.Writer) error {
+	for _, hook := range hooks {
+		if err := Run(hook, stdout, stderr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
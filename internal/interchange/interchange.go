@@ -0,0 +1,288 @@
+// Package interchange reads and writes the SnapSync export format: a tar
+// stream holding a JSON manifest of one snapshot's files, followed by the
+// plaintext chunks their contents are made of. docs/export-format.md
+// specifies the format for tools other than SnapSync.
+package interchange
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	// FormatName identifies the format in the manifest
+	FormatName = "snapsync-export"
+
+	// Version is the format version written; readers reject newer ones
+	Version = 1
+
+	// ManifestName is the first entry of every export
+	ManifestName = "snapsync-export.json"
+
+	// chunkPrefix is the directory holding chunk entries, named by hash
+	chunkPrefix = "chunks/"
+
+	// maxChunkSize bounds a chunk entry read into memory
+	maxChunkSize = 64 << 20
+)
+
+// File types in the manifest
+const (
+	TypeFile = "file"
+	TypeDir  = "dir"
+)
+
+// Manifest describes the snapshot in an export
+type Manifest struct {
+	Format   string       `json:"format"`
+	Version  int          `json:"version"`
+	Created  time.Time    `json:"created"`
+	Hash     string       `json:"hash"` // Chunk and file hash algorithm, always sha256
+	Snapshot SnapshotInfo `json:"snapshot"`
+	Files    []File       `json:"files"`
+}
+
+// SnapshotInfo records where an exported snapshot came from
+type SnapshotInfo struct {
+	ID          string    `json:"id"`
+	Timestamp   time.Time `json:"timestamp"`
+	Source      string    `json:"source"` // Backup root or stream source name
+	Description string    `json:"description,omitempty"`
+	Tags        []string  `json:"tags,omitempty"`
+}
+
+// File is one file or directory of an exported snapshot
+// A file's content is its chunks concatenated in order.
+type File struct {
+	Path    string    `json:"path"` // Relative to the snapshot root, / separated; "." is the root
+	Type    string    `json:"type"`
+	Mode    string    `json:"mode"` // Octal permission bits, e.g. "0644"
+	ModTime time.Time `json:"mtime"`
+	Size    int64     `json:"size,omitempty"`
+	Hash    string    `json:"hash,omitempty"`
+	Chunks  []string  `json:"chunks,omitempty"`
+}
+
+// FormatMode renders permission bits for the manifest
+func FormatMode(perm uint32) string {
+	return fmt.Sprintf("%04o", perm&0777)
+}
+
+// ParseMode reads manifest permission bits
+func ParseMode(mode string) (uint32, error) {
+	perm, err := strconv.ParseUint(mode, 8, 32)
+	if err != nil || perm > 0777 {
+		return 0, fmt.Errorf("invalid mode %q", mode)
+	}
+	return uint32(perm), nil
+}
+
+// Validate checks that a manifest can be read by this version
+func (m *Manifest) Validate() error {
+	if m.Format != FormatName {
+		return fmt.Errorf("not a SnapSync export (format %q)", m.Format)
+	}
+	if m.Version < 1 || m.Version > Version {
+		return fmt.Errorf("unsupported export version %d (this build reads up to %d)", m.Version, Version)
+	}
+	if m.Hash != "sha256" {
+		return fmt.Errorf("unsupported hash algorithm %q", m.Hash)
+	}
+
+	seen := make(map[string]bool, len(m.Files))
+	for _, f := range m.Files {
+		clean := path.Clean(f.Path)
+		if f.Path == "" || clean != f.Path || path.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, "../") {
+			return fmt.Errorf("invalid path in manifest: %q", f.Path)
+		}
+		if seen[f.Path] {
+			return fmt.Errorf("duplicate path in manifest: %s", f.Path)
+		}
+		seen[f.Path] = true
+		if f.Type != TypeFile && f.Type != TypeDir {
+			return fmt.Errorf("unknown type %q for %s", f.Type, f.Path)
+		}
+		if _, err := ParseMode(f.Mode); err != nil {
+			return fmt.Errorf("%s: %w", f.Path, err)
+		}
+	}
+	return nil
+}
+
+// Writer writes an export: the manifest, then each chunk once
+type Writer struct {
+	tw      *tar.Writer
+	created time.Time
+	written map[string]bool
+	Chunks  int   // Chunk entries written
+	Bytes   int64 // Chunk data written
+}
+
+// NewWriter writes the manifest to w and returns a writer for the chunks
+func NewWriter(w io.Writer, manifest *Manifest) (*Writer, error) {
+	manifest.Format = FormatName
+	manifest.Version = Version
+	manifest.Hash = "sha256"
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode manifest: %w", err)
+	}
+
+	tw := tar.NewWriter(w)
+	hdr := &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     ManifestName,
+		Mode:     0644,
+		Size:     int64(len(data)),
+		ModTime:  manifest.Created,
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return nil, fmt.Errorf("failed to write manifest: %w", err)
+	}
+	if _, err := tw.Write(data); err != nil {
+		return nil, fmt.Errorf("failed to write manifest: %w", err)
+	}
+
+	return &Writer{tw: tw, created: manifest.Created, written: make(map[string]bool)}, nil
+}
+
+// Written reports whether a chunk has been written already
+func (w *Writer) Written(hash string) bool {
+	return w.written[hash]
+}
+
+// WriteChunk writes the plaintext data of the chunk with the given hash
+func (w *Writer) WriteChunk(hash string, data []byte) error {
+	if w.written[hash] {
+		return nil
+	}
+
+	hdr := &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     chunkPrefix + hash,
+		Mode:     0644,
+		Size:     int64(len(data)),
+		ModTime:  w.created,
+	}
+	if err := w.tw.WriteHeader(hdr); err != nil {
+		return fmt.Errorf("failed to write chunk %s: %w", hash, err)
+	}
+	if _, err := w.tw.Write(data); err != nil {
+		return fmt.Errorf("failed to write chunk %s: %w", hash, err)
+	}
+
+	w.written[hash] = true
+	w.Chunks++
+	w.Bytes += int64(len(data))
+	return nil
+}
+
+// Close finishes the tar stream without closing the underlying writer
+func (w *Writer) Close() error {
+	if err := w.tw.Close(); err != nil {
+		return fmt.Errorf("failed to finish export: %w", err)
+	}
+	return nil
+}
+
+// Reader reads an export, uncompressed or compressed as a whole with gzip
+// or zstd
+type Reader struct {
+	tr       *tar.Reader
+	close    func()
+	Manifest *Manifest
+}
+
+// NewReader reads and validates the manifest at the start of r
+func NewReader(r io.Reader) (*Reader, error) {
+	br := bufio.NewReaderSize(r, 1<<20)
+	magic, _ := br.Peek(4)
+
+	rd := &Reader{close: func() {}}
+	switch {
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read gzip stream: %w", err)
+		}
+		rd.tr = tar.NewReader(zr)
+	case bytes.Equal(magic, []byte{0x28, 0xb5, 0x2f, 0xfd}):
+		zr, err := zstd.NewReader(br)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read zstd stream: %w", err)
+		}
+		rd.tr = tar.NewReader(zr)
+		rd.close = zr.Close
+	default:
+		rd.tr = tar.NewReader(br)
+	}
+
+	hdr, err := rd.tr.Next()
+	if err != nil {
+		rd.close()
+		return nil, fmt.Errorf("failed to read export: %w", err)
+	}
+	if hdr.Name != ManifestName {
+		rd.close()
+		return nil, fmt.Errorf("not a SnapSync export: first entry is %s, not %s", hdr.Name, ManifestName)
+	}
+
+	manifest := &Manifest{}
+	if err := json.NewDecoder(rd.tr).Decode(manifest); err != nil {
+		rd.close()
+		return nil, fmt.Errorf("failed to parse manifest: %w", err)
+	}
+	if err := manifest.Validate(); err != nil {
+		rd.close()
+		return nil, err
+	}
+	rd.Manifest = manifest
+	return rd, nil
+}
+
+// Next returns the next chunk, verified against its hash, or io.EOF
+// Entries other than chunks are skipped, so later versions can add them.
+func (r *Reader) Next() (string, []byte, error) {
+	for {
+		hdr, err := r.tr.Next()
+		if err == io.EOF {
+			r.close()
+			return "", nil, io.EOF
+		}
+		if err != nil {
+			return "", nil, fmt.Errorf("failed to read export: %w", err)
+		}
+		if hdr.Typeflag != tar.TypeReg || !strings.HasPrefix(hdr.Name, chunkPrefix) {
+			continue
+		}
+
+		hash := strings.TrimPrefix(hdr.Name, chunkPrefix)
+		if hdr.Size > maxChunkSize {
+			return "", nil, fmt.Errorf("chunk %s is too large (%d bytes)", hash, hdr.Size)
+		}
+		data := make([]byte, hdr.Size)
+		if _, err := io.ReadFull(r.tr, data); err != nil {
+			return "", nil, fmt.Errorf("failed to read chunk %s: %w", hash, err)
+		}
+
+		sum := sha256.Sum256(data)
+		if hex.EncodeToString(sum[:]) != hash {
+			return "", nil, fmt.Errorf("chunk %s does not match its hash", hash)
+		}
+		return hash, data, nil
+	}
+}
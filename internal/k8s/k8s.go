@@ -0,0 +1,238 @@
+package k8s
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultSecretsDir is where the CronJob mounts the SnapSync secret
+	DefaultSecretsDir = "/var/run/secrets/snapsync"
+
+	// DefaultPodInfoDir is where the downward API volume is mounted
+	DefaultPodInfoDir = "/etc/podinfo"
+
+	serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+)
+
+// PodInfo describes the pod the backup runs in
+type PodInfo struct {
+	Name      string
+	Namespace string
+	Node      string
+	Labels    map[string]string
+}
+
+// LoadPodInfo reads pod identity from the downward API
+// Name, namespace and node come from POD_NAME, POD_NAMESPACE and NODE_NAME;
+// labels come from the "labels" file of a downward API volume.
+func LoadPodInfo(podInfoDir string) (*PodInfo, error) {
+	info := &PodInfo{
+		Name:      os.Getenv("POD_NAME"),
+		Namespace: os.Getenv("POD_NAMESPACE"),
+		Node:      os.Getenv("NODE_NAME"),
+		Labels:    make(map[string]string),
+	}
+
+	if info.Namespace == "" {
+		if data, err := os.ReadFile(filepath.Join(serviceAccountDir, "namespace")); err == nil {
+			info.Namespace = strings.TrimSpace(string(data))
+		}
+	}
+	if info.Name == "" {
+		info.Name, _ = os.Hostname()
+	}
+
+	labels, err := parseLabels(filepath.Join(podInfoDir, "labels"))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read pod labels: %w", err)
+	}
+	if labels != nil {
+		info.Labels = labels
+	}
+
+	return info, nil
+}
+
+// Tags returns the pod identity as snapshot tags
+func (p *PodInfo) Tags() []string {
+	var tags []string
+	if p.Namespace != "" {
+		tags = append(tags, "namespace="+p.Namespace)
+	}
+	if p.Name != "" {
+		tags = append(tags, "pod="+p.Name)
+	}
+	if p.Node != "" {
+		tags = append(tags, "node="+p.Node)
+	}
+
+	keys := make([]string, 0, len(p.Labels))
+	for k := range p.Labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		tags = append(tags, k+"="+p.Labels[k])
+	}
+
+	return tags
+}
+
+// parseLabels reads a downward API labels file (key="value" per line)
+func parseLabels(path string) (map[string]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	labels := make(map[string]string)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(strings.TrimSpace(scanner.Text()), "=")
+		if !ok {
+			continue
+		}
+		if unquoted, err := strconv.Unquote(value); err == nil {
+			value = unquoted
+		}
+		labels[key] = value
+	}
+
+	return labels, scanner.Err()
+}
+
+// ReadSecret returns the trimmed contents of a key in the mounted secret
+func ReadSecret(secretsDir, key string) (string, error) {
+	data, err := os.ReadFile(filepath.Join(secretsDir, key))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// Status reports the outcome of a backup run
+type Status struct {
+	Success    bool      `json:"success"`
+	SnapshotID string    `json:"snapshot_id,omitempty"`
+	Source     string    `json:"source"`
+	Pod        string    `json:"pod,omitempty"`
+	Namespace  string    `json:"namespace,omitempty"`
+	Tags       []string  `json:"tags,omitempty"`
+	Files      int       `json:"files"`
+	TotalSize  int64     `json:"total_size"`
+	StoredSize int64     `json:"stored_size"`
+	Duration   string    `json:"duration"`
+	Error      string    `json:"error,omitempty"`
+	Finished   time.Time `json:"finished"`
+}
+
+// PostWebhook sends the status as JSON to a webhook URL
+func PostWebhook(url string, status *Status) error {
+	body, err := json.Marshal(status)
+	if err != nil {
+		return err
+	}
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("webhook request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// PostEvent records the status as a Kubernetes Event on the pod using the
+// in-cluster service account
+func PostEvent(pod *PodInfo, status *Status) error {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return fmt.Errorf("not running inside a Kubernetes cluster")
+	}
+
+	token, err := os.ReadFile(filepath.Join(serviceAccountDir, "token"))
+	if err != nil {
+		return fmt.Errorf("failed to read service account token: %w", err)
+	}
+	caCert, err := os.ReadFile(filepath.Join(serviceAccountDir, "ca.crt"))
+	if err != nil {
+		return fmt.Errorf("failed to read cluster CA: %w", err)
+	}
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(caCert)
+
+	eventType, reason := "Normal", "BackupSucceeded"
+	message := fmt.Sprintf("Snapshot %s of %s: %d files in %s", status.SnapshotID, status.Source, status.Files, status.Duration)
+	if !status.Success {
+		eventType, reason = "Warning", "BackupFailed"
+		message = fmt.Sprintf("Backup of %s failed: %s", status.Source, status.Error)
+	}
+
+	now := status.Finished.UTC().Format(time.RFC3339)
+	event := map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Event",
+		"metadata": map[string]interface{}{
+			"generateName": pod.Name + "-snapsync-",
+			"namespace":    pod.Namespace,
+		},
+		"involvedObject": map[string]interface{}{
+			"kind":      "Pod",
+			"name":      pod.Name,
+			"namespace": pod.Namespace,
+		},
+		"type":           eventType,
+		"reason":         reason,
+		"message":        message,
+		"firstTimestamp": now,
+		"lastTimestamp":  now,
+		"count":          1,
+		"source":         map[string]string{"component": "snapsync"},
+	}
+
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	url := fmt.Sprintf("https://%s/api/v1/namespaces/%s/events", net.JoinHostPort(host, port), pod.Namespace)
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	req.Header.Set("Content-Type", "application/json")
+
+	client := &http.Client{
+		Timeout:   30 * time.Second,
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}},
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("event request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("API server returned %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,306 @@
+// Package lifecycle recommends object lifecycle rules for the S3 bucket a
+// repository is uploaded to, from how the repository writes and keeps data
+package lifecycle
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/snapsync/snapsync/internal/backend"
+	"github.com/snapsync/snapsync/internal/snapshot"
+)
+
+const (
+	// SmallObjectSize is the size below which S3 bills infrequent-access
+	// classes as if objects were this large, so smaller ones are not moved
+	SmallObjectSize = 128 * 1024
+
+	// iaDays is the earliest S3 moves objects to STANDARD_IA, and the
+	// shortest stay it bills there
+	iaDays = 30
+
+	// glacierDays is when data moves on to GLACIER_IR, whose shortest
+	// billed stay is 90 days
+	glacierDays = 90
+
+	// minAbortDays is the shortest time an unfinished multipart upload is
+	// kept, so a backup that resumes it the next day still finds its parts
+	minAbortDays = 7
+
+	// survivalThreshold is the share of data that must outlive a transition
+	// for the move to pay for its request and minimum-stay charges
+	survivalThreshold = 0.25
+
+	day = 24 * time.Hour
+)
+
+// Input is what the advice is based on
+type Input struct {
+	Profile   *snapshot.StorageProfile
+	Horizon   time.Duration // Longest the retention rules keep a snapshot
+	Unbounded bool          // Some snapshots are kept indefinitely
+	DataClass string        // Storage class objects are uploaded with, "" = STANDARD
+	LockMode  string        // Object Lock mode, "" = none
+	LockDays  int
+	Immutable bool // Prune never deletes from the bucket
+}
+
+// Transition moves objects to a storage class some days after upload
+type Transition struct {
+	Days         int    `json:"Days"`
+	StorageClass string `json:"StorageClass"`
+}
+
+// Rule is one bucket lifecycle rule
+type Rule struct {
+	ID                string
+	Prefix            string
+	MinSize           int64 // Only objects larger than this, 0 = all
+	Transitions       []Transition
+	AbortDays         int // Abort multipart uploads unfinished after this long, 0 = never
+	NoncurrentDays    int // Delete versions this long after they are replaced or deleted, 0 = never
+	ExpiredDeleteMark bool
+}
+
+// Advice is a set of recommended rules with the reasoning behind them
+type Advice struct {
+	Rules []Rule
+	Notes []string // One per decision, in the order taken
+}
+
+// Advise recommends lifecycle rules for a repository's bucket
+// Data objects are write-once and only read back to restore, so they move
+// to cheaper classes as long as enough of them live past each class's
+// minimum stay; snapshot records, read by every list and check, stay in
+// STANDARD. Expiring current objects is left to prune, which knows what
+// snapshots still reference.
+func Advise(in Input) *Advice {
+	a := &Advice{}
+	p := in.Profile
+
+	span := time.Duration(0)
+	if p.Snapshots > 0 {
+		span = time.Since(p.Oldest)
+	}
+	if in.Unbounded {
+		a.note("Retention keeps some snapshots indefinitely.")
+	} else {
+		a.note("Retention keeps snapshots for up to %s.", days(in.Horizon))
+	}
+
+	data := Rule{ID: "snapsync-data", Prefix: backend.ObjectsPrefix}
+	switch class := strings.ToUpper(in.DataClass); class {
+	case "", "STANDARD", "REDUCED_REDUNDANCY":
+		if a.worthMoving(in, span, iaDays, 0, "STANDARD_IA") {
+			data.Transitions = append(data.Transitions, Transition{iaDays, "STANDARD_IA"})
+		}
+		if a.worthMoving(in, span, glacierDays, 1, "GLACIER_IR") {
+			data.Transitions = append(data.Transitions, Transition{glacierDays, "GLACIER_IR"})
+		}
+	case "STANDARD_IA", "ONEZONE_IA", "INTELLIGENT_TIERING":
+		a.note("Objects are uploaded as %s already; only the move to GLACIER_IR is considered.", class)
+		if a.worthMoving(in, span, glacierDays, 1, "GLACIER_IR") {
+			data.Transitions = append(data.Transitions, Transition{glacierDays, "GLACIER_IR"})
+		}
+	default:
+		a.note("Objects are uploaded as %s already; no transitions are added.", class)
+	}
+	if len(data.Transitions) > 0 {
+		data.MinSize = SmallObjectSize
+		if p.SmallObjects > 0 {
+			a.note("%d of %d referenced objects (%s) are under 128 KB and stay in STANDARD, where they cost less.",
+				p.SmallObjects, p.Objects, formatBytes(p.SmallSize))
+		}
+		a.Rules = append(a.Rules, data)
+	}
+	a.note("DEEP_ARCHIVE and GLACIER are never recommended: restores and checks need objects readable at once.")
+	a.note("Snapshot records under snapshots/ stay in STANDARD: they are small and read by every list and check.")
+
+	abort := Rule{ID: "snapsync-abort-incomplete-uploads", AbortDays: minAbortDays}
+	if p.BackupInterval > 0 {
+		if d := int(math.Ceil(2 * p.BackupInterval.Hours() / 24)); d > abort.AbortDays {
+			abort.AbortDays = d
+		}
+		a.note("Backups run about every %s, so unfinished multipart uploads are kept %d days for the next backup to resume.",
+			days(p.BackupInterval), abort.AbortDays)
+	} else {
+		a.note("Unfinished multipart uploads are kept %d days for a later backup to resume.", abort.AbortDays)
+	}
+	a.Rules = append(a.Rules, abort)
+
+	switch {
+	case in.LockMode != "" && in.Immutable:
+		a.note("The bucket is immutable, so prune never deletes from it and no old versions need removing.")
+	case in.LockMode != "":
+		a.Rules = append(a.Rules, Rule{
+			ID:                "snapsync-old-versions",
+			Prefix:            backend.ObjectsPrefix,
+			NoncurrentDays:    in.LockDays,
+			ExpiredDeleteMark: true,
+		})
+		a.note("Object Lock keeps versions prune deleted for %d days; they are removed once their lock has run out.", in.LockDays)
+	}
+	return a
+}
+
+// worthMoving decides whether data should move to class after the given
+// days, noting why; band indexes the profile's age band for those days
+func (a *Advice) worthMoving(in Input, span time.Duration, afterDays, band int, class string) bool {
+	after := time.Duration(afterDays) * day
+	// The data must stay at least as long again to cover the minimum stay
+	if !in.Unbounded && in.Horizon < 2*after {
+		a.note("No move to %s: retention drops data before it would pay for %d days there.", class, afterDays)
+		return false
+	}
+
+	p := in.Profile
+	if span < 2*after || p.Size == 0 || band >= len(p.Ages) {
+		a.note("Move to %s after %d days: retention keeps data long enough (the repository is too young to measure).", class, afterDays)
+		return true
+	}
+	share := float64(p.Ages[band].Size) / float64(p.Size)
+	if share < survivalThreshold {
+		a.note("No move to %s: only %.0f%% of the data lives past %d days; most is pruned first.", class, 100*share, afterDays)
+		return false
+	}
+	a.note("Move to %s after %d days: %.0f%% of the data (%s) is older than that.",
+		class, afterDays, 100*share, formatBytes(p.Ages[band].Size))
+	return true
+}
+
+// note records a decision
+func (a *Advice) note(format string, args ...interface{}) {
+	a.Notes = append(a.Notes, fmt.Sprintf(format, args...))
+}
+
+// s3Rule is a rule in the form of S3's PutBucketLifecycleConfiguration
+type s3Rule struct {
+	ID                             string          `json:"ID"`
+	Status                         string          `json:"Status"`
+	Filter                         s3Filter        `json:"Filter"`
+	Transitions                    []Transition    `json:"Transitions,omitempty"`
+	Expiration                     *s3Expiration   `json:"Expiration,omitempty"`
+	NoncurrentVersionExpiration    *s3Noncurrent   `json:"NoncurrentVersionExpiration,omitempty"`
+	AbortIncompleteMultipartUpload *s3AbortPending `json:"AbortIncompleteMultipartUpload,omitempty"`
+}
+
+type s3Filter struct {
+	Prefix *string `json:"Prefix,omitempty"`
+	And    *s3And  `json:"And,omitempty"`
+}
+
+type s3And struct {
+	Prefix                string `json:"Prefix"`
+	ObjectSizeGreaterThan int64  `json:"ObjectSizeGreaterThan"`
+}
+
+type s3Expiration struct {
+	ExpiredObjectDeleteMarker bool `json:"ExpiredObjectDeleteMarker"`
+}
+
+type s3Noncurrent struct {
+	NoncurrentDays int `json:"NoncurrentDays"`
+}
+
+type s3AbortPending struct {
+	DaysAfterInitiation int `json:"DaysAfterInitiation"`
+}
+
+// JSON returns the rules as a lifecycle configuration for
+// aws s3api put-bucket-lifecycle-configuration
+func (a *Advice) JSON() ([]byte, error) {
+	rules := make([]s3Rule, 0, len(a.Rules))
+	for _, r := range a.Rules {
+		rule := s3Rule{ID: r.ID, Status: "Enabled", Transitions: r.Transitions}
+		if r.MinSize > 0 {
+			rule.Filter.And = &s3And{Prefix: r.Prefix, ObjectSizeGreaterThan: r.MinSize}
+		} else {
+			prefix := r.Prefix
+			rule.Filter.Prefix = &prefix
+		}
+		if r.ExpiredDeleteMark {
+			rule.Expiration = &s3Expiration{ExpiredObjectDeleteMarker: true}
+		}
+		if r.NoncurrentDays > 0 {
+			rule.NoncurrentVersionExpiration = &s3Noncurrent{NoncurrentDays: r.NoncurrentDays}
+		}
+		if r.AbortDays > 0 {
+			rule.AbortIncompleteMultipartUpload = &s3AbortPending{DaysAfterInitiation: r.AbortDays}
+		}
+		rules = append(rules, rule)
+	}
+	return json.MarshalIndent(struct {
+		Rules []s3Rule `json:"Rules"`
+	}{rules}, "", "  ")
+}
+
+// Terraform returns the rules as an aws_s3_bucket_lifecycle_configuration
+// resource for the bucket
+func (a *Advice) Terraform(bucket string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "resource \"aws_s3_bucket_lifecycle_configuration\" \"snapsync\" {\n")
+	fmt.Fprintf(&b, "  bucket = %q\n", bucket)
+	for _, r := range a.Rules {
+		fmt.Fprintf(&b, "\n  rule {\n")
+		fmt.Fprintf(&b, "    id     = %q\n", r.ID)
+		fmt.Fprintf(&b, "    status = \"Enabled\"\n\n")
+		switch {
+		case r.MinSize > 0:
+			fmt.Fprintf(&b, "    filter {\n      and {\n")
+			fmt.Fprintf(&b, "        prefix                   = %q\n", r.Prefix)
+			fmt.Fprintf(&b, "        object_size_greater_than = %d\n", r.MinSize)
+			fmt.Fprintf(&b, "      }\n    }\n")
+		case r.Prefix != "":
+			fmt.Fprintf(&b, "    filter {\n      prefix = %q\n    }\n", r.Prefix)
+		default:
+			fmt.Fprintf(&b, "    filter {}\n")
+		}
+		for _, t := range r.Transitions {
+			fmt.Fprintf(&b, "\n    transition {\n")
+			fmt.Fprintf(&b, "      days          = %d\n", t.Days)
+			fmt.Fprintf(&b, "      storage_class = %q\n", t.StorageClass)
+			fmt.Fprintf(&b, "    }\n")
+		}
+		if r.ExpiredDeleteMark {
+			fmt.Fprintf(&b, "\n    expiration {\n      expired_object_delete_marker = true\n    }\n")
+		}
+		if r.NoncurrentDays > 0 {
+			fmt.Fprintf(&b, "\n    noncurrent_version_expiration {\n      noncurrent_days = %d\n    }\n", r.NoncurrentDays)
+		}
+		if r.AbortDays > 0 {
+			fmt.Fprintf(&b, "\n    abort_incomplete_multipart_upload {\n      days_after_initiation = %d\n    }\n", r.AbortDays)
+		}
+		fmt.Fprintf(&b, "  }\n")
+	}
+	fmt.Fprintf(&b, "}\n")
+	return b.String()
+}
+
+// days formats a duration in whole days, or hours when shorter than a day
+func days(d time.Duration) string {
+	if d < day {
+		return fmt.Sprintf("%.0f hours", d.Hours())
+	}
+	n := int(math.Round(d.Hours() / 24))
+	if n == 1 {
+		return "1 day"
+	}
+	return fmt.Sprintf("%d days", n)
+}
+
+// formatBytes formats a size with a binary unit
+func formatBytes(bytes int64) string {
+	const unit = 1024
+	if bytes < unit {
+		return fmt.Sprintf("%d B", bytes)
+	}
+	div, exp := int64(unit), 0
+	for n := bytes / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(bytes)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,98 @@
+//go:build linux || darwin
+
+package mount
+
+import (
+	"context"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+	"github.com/snapsync/snapsync/pkg/models"
+)
+
+// buildLayouts replaces latest, by-date and by-tag with links for the
+// given records, newest first
+// Links are relative, so scripts can use paths such as latest/etc/passwd
+// wherever the repository is mounted.
+func (r *rootDir) buildLayouts(ctx context.Context, records []*models.Snapshot) {
+	for _, name := range []string{"latest", "by-date", "by-tag"} {
+		r.RmChild(name)
+	}
+	if len(records) == 0 {
+		return
+	}
+
+	r.AddChild("latest", r.newLink(ctx, &r.Inode, "snapshots/"+records[0].ID, records[0].Timestamp), true)
+
+	// Oldest first, so a second snapshot in the same second gets the suffix
+	byDate := r.newDir(ctx, &r.Inode, "by-date")
+	for i := len(records) - 1; i >= 0; i-- {
+		record := records[i]
+		t := record.Timestamp.Local()
+		day := byDate
+		for _, part := range []string{t.Format("2006"), t.Format("01"), t.Format("02")} {
+			day = r.newDir(ctx, day, part)
+		}
+
+		name := t.Format("15:04:05")
+		if day.GetChild(name) != nil {
+			name += "-" + record.ID
+		}
+		day.AddChild(name, r.newLink(ctx, day, "../../../../snapshots/"+record.ID, record.Timestamp), false)
+	}
+
+	byTag := r.newDir(ctx, &r.Inode, "by-tag")
+	for _, record := range records {
+		for _, tag := range record.Tags {
+			if tag == "" || tag == "." || tag == ".." || strings.Contains(tag, "/") {
+				continue
+			}
+			dir := r.newDir(ctx, byTag, tag)
+			target := "../../snapshots/" + record.ID
+			if dir.GetChild("latest") == nil {
+				dir.AddChild("latest", r.newLink(ctx, dir, target, record.Timestamp), false)
+			}
+			dir.AddChild(record.ID, r.newLink(ctx, dir, target, record.Timestamp), false)
+		}
+	}
+}
+
+// newDir returns the directory name under parent, creating it if needed
+func (r *rootDir) newDir(ctx context.Context, parent *fs.Inode, name string) *fs.Inode {
+	if child := parent.GetChild(name); child != nil {
+		return child
+	}
+	dir := parent.NewPersistentInode(ctx, &dirNode{modTime: r.mounted, mode: 0555}, fs.StableAttr{Mode: fuse.S_IFDIR})
+	parent.AddChild(name, dir, false)
+	return dir
+}
+
+// newLink returns a symlink to target
+func (r *rootDir) newLink(ctx context.Context, parent *fs.Inode, target string, modTime time.Time) *fs.Inode {
+	return parent.NewPersistentInode(ctx, &linkNode{target: target, modTime: modTime}, fs.StableAttr{Mode: fuse.S_IFLNK})
+}
+
+// linkNode is a symlink in one of the layouts
+type linkNode struct {
+	fs.Inode
+	target  string
+	modTime time.Time
+}
+
+var _ = (fs.NodeReadlinker)((*linkNode)(nil))
+var _ = (fs.NodeGetattrer)((*linkNode)(nil))
+
+func (l *linkNode) Readlink(ctx context.Context) ([]byte, syscall.Errno) {
+	return []byte(l.target), 0
+}
+
+func (l *linkNode) Getattr(ctx context.Context, f fs.FileHandle, out *fuse.AttrOut) syscall.Errno {
+	out.Mode = fuse.S_IFLNK | 0777
+	out.Size = uint64(len(l.target))
+	out.Nlink = 1
+	out.SetTimes(nil, &l.modTime, &l.modTime)
+	return 0
+}
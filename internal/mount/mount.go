@@ -0,0 +1,417 @@
+//go:build linux || darwin
+
+package mount
+
+import (
+	"context"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+	"github.com/snapsync/snapsync/internal/restore"
+	"github.com/snapsync/snapsync/internal/snapshot"
+	"github.com/snapsync/snapsync/pkg/models"
+)
+
+// timeout is how long the kernel caches entries and attributes; snapshot
+// contents never change, only the set of snapshots does
+const timeout = time.Second
+
+// Server is a mounted repository
+type Server struct {
+	server *fuse.Server
+}
+
+// Mount exposes the repository's snapshots read-only at mountpoint:
+//
+//	snapshots/<id>/<path>            every snapshot
+//	latest                           link to the newest snapshot
+//	by-date/YYYY/MM/DD/<hh:mm:ss>    links by local backup time
+//	by-tag/<tag>/<id>, <tag>/latest  links by tag
+//	restore/<id>/<path>              with RecoverTo, copies files out on open
+//
+// Snapshot trees are loaded when first opened and file contents are read
+// chunk by chunk through the restorer.
+func Mount(mountpoint string, mgr *snapshot.Manager, restorer *restore.Restorer, opts Options) (*Server, error) {
+	root := &rootDir{mgr: mgr, restorer: restorer, opts: opts, mounted: time.Now()}
+
+	t := timeout
+	server, err := fs.Mount(mountpoint, root, &fs.Options{
+		MountOptions: fuse.MountOptions{
+			FsName:      "snapsync",
+			Name:        "snapsync",
+			Options:     []string{"ro"},
+			DirectMount: true,
+			Debug:       opts.Debug,
+		},
+		EntryTimeout: &t,
+		AttrTimeout:  &t,
+		UID:          uint32(os.Getuid()),
+		GID:          uint32(os.Getgid()),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &Server{server: server}, nil
+}
+
+// Wait blocks until the filesystem is unmounted
+func (s *Server) Wait() {
+	s.server.Wait()
+}
+
+// Unmount detaches the filesystem
+func (s *Server) Unmount() error {
+	return s.server.Unmount()
+}
+
+// rootDir is the top of the mount
+// Besides snapshots/ it holds the layouts built from snapshot records, which
+// are rebuilt whenever the set of snapshots changes.
+type rootDir struct {
+	fs.Inode
+	mgr      *snapshot.Manager
+	restorer *restore.Restorer
+	opts     Options
+	mounted  time.Time
+
+	mu        sync.Mutex
+	snapshots *fs.Inode
+	restore   *fs.Inode // nil unless files are recovered on open
+	refreshed time.Time
+	ids       string // Snapshot IDs the entries were built from
+}
+
+var _ = (fs.NodeOnAdder)((*rootDir)(nil))
+var _ = (fs.NodeLookuper)((*rootDir)(nil))
+var _ = (fs.NodeReaddirer)((*rootDir)(nil))
+var _ = (fs.NodeGetattrer)((*rootDir)(nil))
+
+func (r *rootDir) OnAdd(ctx context.Context) {
+	r.snapshots = r.NewPersistentInode(ctx, &snapshotsDir{root: r}, fs.StableAttr{Mode: fuse.S_IFDIR})
+	r.AddChild("snapshots", r.snapshots, false)
+	if r.opts.RecoverTo != "" {
+		r.restore = r.NewPersistentInode(ctx, &snapshotsDir{root: r}, fs.StableAttr{Mode: fuse.S_IFDIR})
+		r.AddChild("restore", r.restore, false)
+	}
+	r.refresh(ctx)
+}
+
+// refresh re-reads the snapshot records, at most once per timeout, and
+// updates the entries if snapshots were taken or deleted
+func (r *rootDir) refresh(ctx context.Context) syscall.Errno {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if time.Since(r.refreshed) < timeout {
+		return 0
+	}
+	records, err := r.mgr.ListRecords()
+	if err != nil {
+		return syscall.EIO
+	}
+	r.refreshed = time.Now()
+
+	ids := make([]string, len(records))
+	for i, record := range records {
+		ids[i] = record.ID
+	}
+	if joined := strings.Join(ids, ","); joined != r.ids {
+		r.ids = joined
+		r.updateSnapshots(ctx, records)
+		r.buildLayouts(ctx, records)
+	}
+	return 0
+}
+
+// updateSnapshots adds a directory for each new snapshot and drops deleted
+// ones, keeping the trees already loaded for the rest
+func (r *rootDir) updateSnapshots(ctx context.Context, records []*models.Snapshot) {
+	updateSnapshotDirs(ctx, r, r.snapshots, records, false)
+	if r.restore != nil {
+		updateSnapshotDirs(ctx, r, r.restore, records, true)
+	}
+}
+
+// updateSnapshotDirs brings the snapshot directories under parent in line
+// with records
+func updateSnapshotDirs(ctx context.Context, r *rootDir, parent *fs.Inode, records []*models.Snapshot, recovering bool) {
+	current := make(map[string]bool, len(records))
+	for _, record := range records {
+		current[record.ID] = true
+		if parent.GetChild(record.ID) != nil {
+			continue
+		}
+		dir := &snapshotDir{root: r, id: record.ID, timestamp: record.Timestamp, recovering: recovering}
+		parent.AddChild(record.ID, parent.NewPersistentInode(ctx, dir, fs.StableAttr{Mode: fuse.S_IFDIR}), false)
+	}
+	for name := range parent.Children() {
+		if !current[name] {
+			parent.RmChild(name)
+		}
+	}
+}
+
+func (r *rootDir) Lookup(ctx context.Context, name string, out *fuse.EntryOut) (*fs.Inode, syscall.Errno) {
+	if errno := r.refresh(ctx); errno != 0 {
+		return nil, errno
+	}
+	return lookupChild(ctx, &r.Inode, name, out)
+}
+
+func (r *rootDir) Readdir(ctx context.Context) (fs.DirStream, syscall.Errno) {
+	if errno := r.refresh(ctx); errno != 0 {
+		return nil, errno
+	}
+	return listChildren(&r.Inode), 0
+}
+
+func (r *rootDir) Getattr(ctx context.Context, f fs.FileHandle, out *fuse.AttrOut) syscall.Errno {
+	setDirAttr(&out.Attr, r.mounted)
+	return 0
+}
+
+// snapshotsDir lists every snapshot by ID
+type snapshotsDir struct {
+	fs.Inode
+	root *rootDir
+}
+
+var _ = (fs.NodeLookuper)((*snapshotsDir)(nil))
+var _ = (fs.NodeReaddirer)((*snapshotsDir)(nil))
+var _ = (fs.NodeGetattrer)((*snapshotsDir)(nil))
+
+func (d *snapshotsDir) Lookup(ctx context.Context, name string, out *fuse.EntryOut) (*fs.Inode, syscall.Errno) {
+	if errno := d.root.refresh(ctx); errno != 0 {
+		return nil, errno
+	}
+	return lookupChild(ctx, &d.Inode, name, out)
+}
+
+func (d *snapshotsDir) Readdir(ctx context.Context) (fs.DirStream, syscall.Errno) {
+	if errno := d.root.refresh(ctx); errno != 0 {
+		return nil, errno
+	}
+	return listChildren(&d.Inode), 0
+}
+
+func (d *snapshotsDir) Getattr(ctx context.Context, f fs.FileHandle, out *fuse.AttrOut) syscall.Errno {
+	setDirAttr(&out.Attr, d.root.mounted)
+	return 0
+}
+
+// snapshotDir is the root of one snapshot, populated on first access
+type snapshotDir struct {
+	fs.Inode
+	root       *rootDir
+	id         string
+	timestamp  time.Time
+	recovering bool // Under restore/, copying files out when opened
+
+	once  sync.Once
+	errno syscall.Errno
+}
+
+var _ = (fs.NodeLookuper)((*snapshotDir)(nil))
+var _ = (fs.NodeReaddirer)((*snapshotDir)(nil))
+var _ = (fs.NodeGetattrer)((*snapshotDir)(nil))
+
+// load reads the snapshot tree and builds its directories and files
+func (d *snapshotDir) load(ctx context.Context) syscall.Errno {
+	d.once.Do(func() {
+		snap, err := d.root.mgr.Get(d.id)
+		if err != nil || snap.Tree == nil || snap.Tree.Files == nil {
+			d.errno = syscall.EIO
+			return
+		}
+		var rec *recovery
+		if d.recovering {
+			rec = &recovery{dir: filepath.Join(d.root.opts.RecoverTo, d.id), notify: d.root.opts.OnRecover}
+		}
+		populate(ctx, &d.Inode, snap, d.root.restorer, rec)
+	})
+	return d.errno
+}
+
+func (d *snapshotDir) Lookup(ctx context.Context, name string, out *fuse.EntryOut) (*fs.Inode, syscall.Errno) {
+	if errno := d.load(ctx); errno != 0 {
+		return nil, errno
+	}
+	return lookupChild(ctx, &d.Inode, name, out)
+}
+
+func (d *snapshotDir) Readdir(ctx context.Context) (fs.DirStream, syscall.Errno) {
+	if errno := d.load(ctx); errno != 0 {
+		return nil, errno
+	}
+	return listChildren(&d.Inode), 0
+}
+
+func (d *snapshotDir) Getattr(ctx context.Context, f fs.FileHandle, out *fuse.AttrOut) syscall.Errno {
+	setDirAttr(&out.Attr, d.timestamp)
+	return 0
+}
+
+// populate adds every path of a snapshot below parent, with files copied
+// out when opened if rec is set
+func populate(ctx context.Context, parent *fs.Inode, snap *models.Snapshot, restorer *restore.Restorer, rec *recovery) {
+	paths := make([]string, 0, len(snap.Tree.Files))
+	for relPath := range snap.Tree.Files {
+		if relPath != "." {
+			paths = append(paths, relPath)
+		}
+	}
+	// Parents sort before their children
+	sort.Strings(paths)
+
+	dirs := map[string]*fs.Inode{".": parent}
+	var dirFor func(dir string) *fs.Inode
+	dirFor = func(dir string) *fs.Inode {
+		if inode, ok := dirs[dir]; ok {
+			return inode
+		}
+		// A directory the tree does not list, e.g. above an excluded path
+		p := dirFor(path.Dir(dir))
+		inode := p.NewPersistentInode(ctx, &dirNode{modTime: snap.Timestamp, mode: 0755}, fs.StableAttr{Mode: fuse.S_IFDIR})
+		p.AddChild(path.Base(dir), inode, false)
+		dirs[dir] = inode
+		return inode
+	}
+
+	for _, relPath := range paths {
+		node := snap.Tree.Files[relPath]
+		p := dirFor(path.Dir(relPath))
+
+		if node.IsDir {
+			inode := p.NewPersistentInode(ctx, &dirNode{modTime: node.ModTime, mode: node.Mode}, fs.StableAttr{Mode: fuse.S_IFDIR})
+			p.AddChild(path.Base(relPath), inode, false)
+			dirs[relPath] = inode
+			continue
+		}
+
+		file := &fileNode{node: node, restorer: restorer}
+		if rec != nil {
+			file.recovery = rec
+			file.dest = filepath.Join(rec.dir, relPath)
+		}
+		p.AddChild(path.Base(relPath), p.NewPersistentInode(ctx, file, fs.StableAttr{Mode: fuse.S_IFREG}), false)
+	}
+}
+
+// dirNode is a directory inside a snapshot
+type dirNode struct {
+	fs.Inode
+	modTime time.Time
+	mode    os.FileMode
+}
+
+var _ = (fs.NodeGetattrer)((*dirNode)(nil))
+
+func (d *dirNode) Getattr(ctx context.Context, f fs.FileHandle, out *fuse.AttrOut) syscall.Errno {
+	setDirAttr(&out.Attr, d.modTime)
+	out.Mode = fuse.S_IFDIR | readOnly(d.mode, 0555)
+	return 0
+}
+
+// fileNode is a file inside a snapshot
+type fileNode struct {
+	fs.Inode
+	node     *models.FileNode
+	restorer *restore.Restorer
+	recovery *recovery // Set under restore/
+	dest     string    // Where the file is recovered to
+
+	mu     sync.Mutex
+	reader *restore.FileReader
+}
+
+var _ = (fs.NodeGetattrer)((*fileNode)(nil))
+var _ = (fs.NodeOpener)((*fileNode)(nil))
+var _ = (fs.NodeReader)((*fileNode)(nil))
+
+func (n *fileNode) Getattr(ctx context.Context, f fs.FileHandle, out *fuse.AttrOut) syscall.Errno {
+	out.Mode = fuse.S_IFREG | readOnly(n.node.Mode, 0444)
+	out.Size = uint64(n.node.Size)
+	out.Nlink = 1
+	out.SetTimes(nil, &n.node.ModTime, &n.node.ModTime)
+	return 0
+}
+
+func (n *fileNode) Open(ctx context.Context, flags uint32) (fs.FileHandle, uint32, syscall.Errno) {
+	if flags&(syscall.O_WRONLY|syscall.O_RDWR|syscall.O_TRUNC|syscall.O_APPEND) != 0 {
+		return nil, 0, syscall.EROFS
+	}
+	if n.recovery != nil {
+		if errno := n.materialize(); errno != 0 {
+			return nil, 0, errno
+		}
+	}
+	// Stored content never changes, so the page cache stays valid
+	return nil, fuse.FOPEN_KEEP_CACHE, 0
+}
+
+func (n *fileNode) Read(ctx context.Context, f fs.FileHandle, dest []byte, off int64) (fuse.ReadResult, syscall.Errno) {
+	// One reader per file keeps its chunk boundaries across opens
+	n.mu.Lock()
+	if n.reader == nil {
+		n.reader = n.restorer.OpenFile(n.node)
+	}
+	reader := n.reader
+	n.mu.Unlock()
+
+	count, err := reader.ReadAt(dest, off)
+	if err != nil && count == 0 && off < reader.Size() {
+		return nil, syscall.EIO
+	}
+	return fuse.ReadResultData(dest[:count]), 0
+}
+
+// lookupChild returns an existing child with its attributes
+func lookupChild(ctx context.Context, parent *fs.Inode, name string, out *fuse.EntryOut) (*fs.Inode, syscall.Errno) {
+	child := parent.GetChild(name)
+	if child == nil {
+		return nil, syscall.ENOENT
+	}
+	if ga, ok := child.Operations().(fs.NodeGetattrer); ok {
+		var attr fuse.AttrOut
+		if errno := ga.Getattr(ctx, nil, &attr); errno == 0 {
+			out.Attr = attr.Attr
+		}
+	}
+	return child, 0
+}
+
+// listChildren lists the children of a directory
+func listChildren(dir *fs.Inode) fs.DirStream {
+	children := dir.Children()
+	entries := make([]fuse.DirEntry, 0, len(children))
+	for name, child := range children {
+		entries = append(entries, fuse.DirEntry{Name: name, Mode: child.StableAttr().Mode})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+	return fs.NewListDirStream(entries)
+}
+
+// setDirAttr fills in the attributes of a directory
+func setDirAttr(out *fuse.Attr, modTime time.Time) {
+	out.Mode = fuse.S_IFDIR | 0555
+	out.Nlink = 2
+	out.SetTimes(nil, &modTime, &modTime)
+}
+
+// readOnly returns the permission bits of mode without write access, or
+// fallback if none were recorded
+func readOnly(mode os.FileMode, fallback uint32) uint32 {
+	perm := uint32(mode.Perm()) &^ 0222
+	if perm == 0 {
+		return fallback
+	}
+	return perm
+}
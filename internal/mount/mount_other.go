@@ -0,0 +1,27 @@
+//go:build !linux && !darwin
+
+package mount
+
+import (
+	"fmt"
+	"runtime"
+
+	"github.com/snapsync/snapsync/internal/restore"
+	"github.com/snapsync/snapsync/internal/snapshot"
+)
+
+// Server is a mounted repository
+type Server struct{}
+
+// Mount is only available where FUSE is
+func Mount(mountpoint string, mgr *snapshot.Manager, restorer *restore.Restorer, opts Options) (*Server, error) {
+	return nil, fmt.Errorf("mounting is not supported on %s", runtime.GOOS)
+}
+
+// Wait blocks until the filesystem is unmounted
+func (s *Server) Wait() {}
+
+// Unmount detaches the filesystem
+func (s *Server) Unmount() error {
+	return nil
+}
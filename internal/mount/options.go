@@ -0,0 +1,14 @@
+package mount
+
+// Options configures a mount
+type Options struct {
+	// RecoverTo enables restore/<id>/<path>, which mirrors snapshots/ but
+	// copies each file to RecoverTo/<id>/<path> when it is opened
+	RecoverTo string
+
+	// OnRecover, if set, is called after each file is copied or fails to be
+	OnRecover func(path string, err error)
+
+	// Debug logs every FUSE request
+	Debug bool
+}
@@ -0,0 +1,76 @@
+//go:build linux || darwin
+
+package mount
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// recovery is where the files of one snapshot under restore/ are copied to
+type recovery struct {
+	dir    string
+	notify func(path string, err error)
+}
+
+// materialize copies the file to its recovery path unless an earlier open
+// already did
+// The copy is written to a temporary file and renamed, so the recovery
+// directory never holds a partial file, and is checked by size and
+// modification time so one changed since is copied again.
+func (n *fileNode) materialize() syscall.Errno {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if info, err := os.Stat(n.dest); err == nil && info.Mode().IsRegular() &&
+		info.Size() == n.node.Size && info.ModTime().Equal(n.node.ModTime) {
+		return 0
+	}
+
+	err := n.copyOut()
+	if n.recovery.notify != nil {
+		n.recovery.notify(n.dest, err)
+	}
+	if err != nil {
+		return syscall.EIO
+	}
+	return 0
+}
+
+// copyOut writes the file to its recovery path
+func (n *fileNode) copyOut() error {
+	if err := os.MkdirAll(filepath.Dir(n.dest), 0755); err != nil {
+		return fmt.Errorf("failed to create directories: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(n.dest), ".snapsync-recover-*")
+	if err != nil {
+		return fmt.Errorf("failed to create file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := n.restorer.RestoreToWriter(n.node, tmp); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write file: %w", err)
+	}
+
+	perm := n.node.Mode.Perm()
+	if perm == 0 {
+		perm = 0644
+	}
+	if err := os.Chmod(tmp.Name(), perm); err != nil {
+		return fmt.Errorf("failed to set permissions: %w", err)
+	}
+	if err := os.Chtimes(tmp.Name(), n.node.ModTime, n.node.ModTime); err != nil {
+		return fmt.Errorf("failed to set mtime: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), n.dest); err != nil {
+		return fmt.Errorf("failed to move file into place: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+package progress
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// SocketDirEnv overrides the directory holding control sockets
+const SocketDirEnv = "SNAPSYNC_CONTROL_DIR"
+
+// SocketDir returns the directory where running operations publish their
+// control sockets
+func SocketDir() string {
+	if dir := os.Getenv(SocketDirEnv); dir != "" {
+		return dir
+	}
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		return filepath.Join(dir, "snapsync")
+	}
+	return filepath.Join(os.TempDir(), fmt.Sprintf("snapsync-%d", os.Getuid()))
+}
+
+// Server publishes a tracker's status on a unix socket
+type Server struct {
+	listener net.Listener
+	path     string
+}
+
+// Listen publishes t on a control socket named after the process
+// Each connection receives one JSON status and is closed.
+func Listen(t *Tracker) (*Server, error) {
+	dir := SocketDir()
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create control directory: %w", err)
+	}
+
+	path := filepath.Join(dir, fmt.Sprintf("%s-%d.sock", t.Status().Operation, os.Getpid()))
+	os.Remove(path)
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", path, err)
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("failed to secure control socket: %w", err)
+	}
+
+	s := &Server{listener: listener, path: path}
+	go s.serve(t)
+	return s, nil
+}
+
+// serve answers connections until the listener is closed
+func (s *Server) serve(t *Tracker) {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		json.NewEncoder(conn).Encode(t.Status())
+		conn.Close()
+	}
+}
+
+// Close removes the control socket
+func (s *Server) Close() error {
+	if s == nil {
+		return nil
+	}
+	err := s.listener.Close()
+	os.Remove(s.path)
+	return err
+}
+
+// Sockets lists the control sockets of running operations
+// Sockets left behind by processes that exited are removed.
+func Sockets() ([]string, error) {
+	entries, err := os.ReadDir(SocketDir())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var sockets []string
+	for _, entry := range entries {
+		if !strings.HasSuffix(entry.Name(), ".sock") {
+			continue
+		}
+		path := filepath.Join(SocketDir(), entry.Name())
+
+		conn, err := net.DialTimeout("unix", path, time.Second)
+		if err != nil {
+			os.Remove(path)
+			continue
+		}
+		conn.Close()
+		sockets = append(sockets, path)
+	}
+	return sockets, nil
+}
+
+// Query fetches the status published on a control socket
+func Query(path string) (*Status, error) {
+	conn, err := net.DialTimeout("unix", path, time.Second)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	var status Status
+	if err := json.NewDecoder(conn).Decode(&status); err != nil {
+		return nil, fmt.Errorf("invalid status from %s: %w", path, err)
+	}
+	return &status, nil
+}
@@ -0,0 +1,150 @@
+package progress
+
+import (
+	"sync"
+	"time"
+)
+
+// maxErrors is how many recent errors a tracker keeps
+const maxErrors = 10
+
+// Tracker records the progress of a running operation
+// All methods are safe for concurrent use and on a nil Tracker, so callers
+// can report progress unconditionally.
+type Tracker struct {
+	mu     sync.Mutex
+	status Status
+}
+
+// Status is a point-in-time view of an operation
+type Status struct {
+	Operation  string         `json:"operation"` // backup, restore, ...
+	Target     string         `json:"target"`    // Source or target path
+	PID        int            `json:"pid"`
+	Started    time.Time      `json:"started"`
+	Phase      string         `json:"phase"`
+	FilesTotal int            `json:"files_total"`
+	FilesDone  int            `json:"files_done"`
+	BytesTotal int64          `json:"bytes_total"`
+	BytesDone  int64          `json:"bytes_done"`
+	Queues     map[string]int `json:"queues,omitempty"` // Items waiting per stage
+	Workers    []Worker       `json:"workers,omitempty"`
+	Errors     []Error        `json:"errors,omitempty"` // Most recent last
+}
+
+// Worker is what one worker is doing
+type Worker struct {
+	ID    int       `json:"id"`
+	Path  string    `json:"path,omitempty"` // Empty when idle
+	Since time.Time `json:"since"`
+}
+
+// Error is a recorded failure
+type Error struct {
+	Time    time.Time `json:"time"`
+	Message string    `json:"message"`
+}
+
+// New creates a tracker for an operation
+func New(operation, target string, pid int) *Tracker {
+	return &Tracker{status: Status{
+		Operation: operation,
+		Target:    target,
+		PID:       pid,
+		Started:   time.Now(),
+		Phase:     "starting",
+		Queues:    make(map[string]int),
+	}}
+}
+
+// SetPhase records the current phase
+func (t *Tracker) SetPhase(phase string) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.status.Phase = phase
+}
+
+// SetTotals records how much work the operation has
+func (t *Tracker) SetTotals(files int, bytes int64) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.status.FilesTotal = files
+	t.status.BytesTotal = bytes
+}
+
+// SetQueue records the number of items waiting in a stage
+func (t *Tracker) SetQueue(name string, depth int) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.status.Queues[name] = depth
+}
+
+// WorkerStarted records that a worker began on path
+func (t *Tracker) WorkerStarted(id int, path string) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for len(t.status.Workers) <= id {
+		t.status.Workers = append(t.status.Workers, Worker{ID: len(t.status.Workers)})
+	}
+	t.status.Workers[id] = Worker{ID: id, Path: path, Since: time.Now()}
+}
+
+// FileDone records a finished file and marks its worker idle
+func (t *Tracker) FileDone(id int, size int64) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.status.FilesDone++
+	t.status.BytesDone += size
+	if id < len(t.status.Workers) {
+		t.status.Workers[id] = Worker{ID: id, Since: time.Now()}
+	}
+}
+
+// Error records a failure, keeping only the most recent ones
+func (t *Tracker) Error(err error) {
+	if t == nil || err == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.status.Errors = append(t.status.Errors, Error{Time: time.Now(), Message: err.Error()})
+	if n := len(t.status.Errors); n > maxErrors {
+		t.status.Errors = t.status.Errors[n-maxErrors:]
+	}
+}
+
+// Status returns a copy of the current status
+func (t *Tracker) Status() Status {
+	if t == nil {
+		return Status{}
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	status := t.status
+	status.Queues = make(map[string]int, len(t.status.Queues))
+	for name, depth := range t.status.Queues {
+		status.Queues[name] = depth
+	}
+	status.Workers = append([]Worker(nil), t.status.Workers...)
+	status.Errors = append([]Error(nil), t.status.Errors...)
+	return status
+}
@@ -0,0 +1,82 @@
+//go:build linux
+
+package restore
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/snapsync/snapsync/pkg/models"
+	"golang.org/x/sys/unix"
+)
+
+// Inode flags from linux/fs.h
+const (
+	fsImmutableFlag = 0x10
+	fsAppendFlag    = 0x20
+)
+
+// restoreAttrs sets the file capabilities and chattr flags recorded for a
+// restored file; flags go last, as an immutable file cannot be changed
+// Setting either needs privileges (CAP_SETFCAP, CAP_LINUX_IMMUTABLE), so a
+// permission error is returned as os.ErrPermission for the caller to count.
+func restoreAttrs(path string, node *models.FileNode) error {
+	var denied bool
+	if len(node.Capability) > 0 {
+		if err := unix.Lsetxattr(path, "security.capability", node.Capability, 0); err != nil {
+			if !errors.Is(err, unix.EPERM) {
+				return fmt.Errorf("failed to set capabilities: %w", err)
+			}
+			denied = true
+		}
+	}
+
+	var set int
+	for _, flag := range node.Flags {
+		switch flag {
+		case "immutable":
+			set |= fsImmutableFlag
+		case "append":
+			set |= fsAppendFlag
+		}
+	}
+	if set != 0 {
+		if err := updateFlags(path, func(flags int) int { return flags | set }); err != nil {
+			if !errors.Is(err, unix.EPERM) {
+				return fmt.Errorf("failed to set flags: %w", err)
+			}
+			denied = true
+		}
+	}
+
+	if denied {
+		return os.ErrPermission
+	}
+	return nil
+}
+
+// clearFlags removes the immutable and append-only flags from a file, so an
+// earlier restore of it can be overwritten
+func clearFlags(path string) error {
+	return updateFlags(path, func(flags int) int { return flags &^ (fsImmutableFlag | fsAppendFlag) })
+}
+
+// updateFlags changes the inode flags of a file
+func updateFlags(path string, change func(int) int) error {
+	fd, err := unix.Open(path, unix.O_RDONLY|unix.O_NONBLOCK|unix.O_NOFOLLOW|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return err
+	}
+	defer unix.Close(fd)
+
+	flags, err := unix.IoctlGetUint32(fd, unix.FS_IOC_GETFLAGS)
+	if err != nil {
+		return err
+	}
+	updated := change(int(flags))
+	if updated == int(flags) {
+		return nil
+	}
+	return unix.IoctlSetPointerInt(fd, unix.FS_IOC_SETFLAGS, updated)
+}
@@ -0,0 +1,16 @@
+//go:build !linux
+
+package restore
+
+import "github.com/snapsync/snapsync/pkg/models"
+
+// restoreAttrs would set Linux file capabilities and flags, which other
+// systems do not have
+func restoreAttrs(path string, node *models.FileNode) error {
+	return nil
+}
+
+// clearFlags has no flags to clear outside Linux
+func clearFlags(path string) error {
+	return nil
+}
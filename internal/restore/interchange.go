@@ -0,0 +1,135 @@
+package restore
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/snapsync/snapsync/internal/interchange"
+	"github.com/snapsync/snapsync/pkg/models"
+)
+
+// WriteExport streams a snapshot to w in the SnapSync export format
+// Files are listed in path order, and each chunk follows the manifest once,
+// in the order the files first use it. Files stored inline become a single
+// chunk. A device snapshot becomes one file named after the device.
+func (r *Restorer) WriteExport(snapshot *models.Snapshot, w io.Writer) (*TarResult, error) {
+	if err := checkContents(snapshot); err != nil {
+		return nil, err
+	}
+	result := &TarResult{}
+	manifest := &interchange.Manifest{
+		Created: time.Now().UTC(),
+		Snapshot: interchange.SnapshotInfo{
+			ID:          snapshot.ID,
+			Timestamp:   snapshot.Timestamp,
+			Description: snapshot.Description,
+			Tags:        snapshot.Tags,
+		},
+	}
+
+	nodes := make(map[string]*models.FileNode)
+	if root := snapshot.Tree.Root; root != nil && root.IsBlockDevice() {
+		manifest.Snapshot.Source = root.Path
+		name := filepath.Base(root.Path) + ".img"
+		manifest.Files = append(manifest.Files, exportFile(name, root))
+		manifest.Files[0].Mode = interchange.FormatMode(0644)
+		nodes[name] = root
+	} else {
+		if root := snapshot.Tree.Root; root != nil {
+			manifest.Snapshot.Source = root.Path
+		}
+		paths := make([]string, 0, len(snapshot.Tree.Files))
+		for relPath := range snapshot.Tree.Files {
+			paths = append(paths, relPath)
+		}
+		sort.Strings(paths)
+
+		for _, relPath := range paths {
+			name := filepath.ToSlash(relPath)
+			node := snapshot.Tree.Files[relPath]
+			manifest.Files = append(manifest.Files, exportFile(name, node))
+			nodes[name] = node
+		}
+	}
+
+	ew, err := interchange.NewWriter(w, manifest)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, file := range manifest.Files {
+		if file.Type == interchange.TypeDir {
+			if file.Path != "." {
+				result.Dirs++
+			}
+			continue
+		}
+
+		node := nodes[file.Path]
+		if node.Inline != nil {
+			data, err := r.loadInline(node)
+			if err != nil {
+				return nil, fmt.Errorf("failed to export %s: %w", file.Path, err)
+			}
+			if err := ew.WriteChunk(node.ContentHash(), data); err != nil {
+				return nil, err
+			}
+		}
+		for _, hash := range node.Chunks {
+			if ew.Written(hash) {
+				continue
+			}
+			data, err := r.readChunk(hash)
+			if err != nil {
+				return nil, fmt.Errorf("failed to export %s: %w", file.Path, err)
+			}
+			if err := ew.WriteChunk(hash, data); err != nil {
+				return nil, err
+			}
+		}
+		result.Files++
+		result.Bytes += node.Size
+	}
+
+	if err := ew.Close(); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// exportFile describes a snapshot node in the manifest
+func exportFile(name string, node *models.FileNode) interchange.File {
+	file := interchange.File{
+		Path:    name,
+		Type:    interchange.TypeFile,
+		Mode:    interchange.FormatMode(uint32(node.Mode.Perm())),
+		ModTime: node.ModTime.UTC(),
+	}
+	if node.IsDir {
+		file.Type = interchange.TypeDir
+		return file
+	}
+
+	file.Size = node.Size
+	file.Hash = node.ContentHash()
+	file.Chunks = node.Chunks
+	if node.Inline != nil {
+		file.Chunks = []string{file.Hash}
+	}
+	return file
+}
+
+// readChunk reads one stored chunk and returns its verified plaintext
+func (r *Restorer) readChunk(hash string) ([]byte, error) {
+	data, err := r.getChunk(hash)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get chunk %s: %w", hash, err)
+	}
+	if data, err = r.decryptChunk(data); err != nil {
+		return nil, err
+	}
+	return r.decodeChunk(hash, data)
+}
@@ -0,0 +1,38 @@
+package restore
+
+import (
+	"sort"
+
+	"github.com/snapsync/snapsync/pkg/models"
+)
+
+// restoreOrder returns the file paths of a tree in the order they should be
+// restored. Files stored without chunks come first by path; the rest follow
+// the layout of their first chunk in the object store, which keeps reads
+// sequential and restores files sharing chunks back to back.
+func restoreOrder(files map[string]*models.FileNode) []string {
+	paths := make([]string, 0, len(files))
+	for relPath, node := range files {
+		if !node.IsDir {
+			paths = append(paths, relPath)
+		}
+	}
+
+	sort.Slice(paths, func(i, j int) bool {
+		a, b := locality(files[paths[i]]), locality(files[paths[j]])
+		if a != b {
+			return a < b
+		}
+		return paths[i] < paths[j]
+	})
+
+	return paths
+}
+
+// locality returns the sort key placing a file near its stored data
+func locality(node *models.FileNode) string {
+	if len(node.Chunks) == 0 {
+		return ""
+	}
+	return node.Chunks[0]
+}
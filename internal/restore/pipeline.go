@@ -0,0 +1,95 @@
+package restore
+
+import (
+	"fmt"
+	"io"
+)
+
+// pipelineDepth is the number of chunks buffered between restore stages
+const pipelineDepth = 4
+
+// stageItem carries one chunk through the restore pipeline
+type stageItem struct {
+	hash string
+	data []byte
+	err  error
+}
+
+// writeChunks restores chunks to w through a fetch → decrypt → decompress →
+// write pipeline, so storage reads, CPU work and disk writes overlap
+func (r *Restorer) writeChunks(chunks []string, w io.Writer) error {
+	if len(chunks) == 0 {
+		return nil
+	}
+
+	// Closing done stops the stages early if the writer fails
+	done := make(chan struct{})
+	defer close(done)
+
+	fetched := r.fetchStage(done, chunks)
+	decrypted := runStage(done, fetched, func(item stageItem) ([]byte, error) {
+		return r.decryptChunk(item.data)
+	})
+	decoded := runStage(done, decrypted, func(item stageItem) ([]byte, error) {
+		return r.decodeChunk(item.hash, item.data)
+	})
+
+	for item := range decoded {
+		if item.err != nil {
+			return fmt.Errorf("failed to get chunk %s: %w", item.hash, item.err)
+		}
+		r.writeLimit.Wait(len(item.data))
+		if _, err := w.Write(item.data); err != nil {
+			return fmt.Errorf("failed to write chunk: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// fetchStage reads stored chunks in order
+func (r *Restorer) fetchStage(done <-chan struct{}, chunks []string) <-chan stageItem {
+	out := make(chan stageItem, pipelineDepth)
+
+	go func() {
+		defer close(out)
+		for _, hash := range chunks {
+			data, err := r.getChunk(hash)
+			select {
+			case out <- stageItem{hash: hash, data: data, err: err}:
+			case <-done:
+				return
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	return out
+}
+
+// runStage applies fn to each item from in, passing errors through
+// untouched and stopping after the first one
+func runStage(done <-chan struct{}, in <-chan stageItem, fn func(stageItem) ([]byte, error)) <-chan stageItem {
+	out := make(chan stageItem, pipelineDepth)
+
+	go func() {
+		defer close(out)
+		for item := range in {
+			if item.err == nil {
+				item.data, item.err = fn(item)
+			}
+			select {
+			case out <- item:
+			case <-done:
+				return
+			}
+			if item.err != nil {
+				return
+			}
+		}
+	}()
+
+	return out
+}
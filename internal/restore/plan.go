@@ -0,0 +1,228 @@
+package restore
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/snapsync/snapsync/pkg/models"
+)
+
+// TrashDir is the directory in a restore target that receives the files a
+// restore with Delete takes out of the target
+const TrashDir = ".snapsync-trash"
+
+// Action is what a restore does with one path
+type Action string
+
+const (
+	ActionCreate    Action = "create"    // Not in the target, restored
+	ActionOverwrite Action = "overwrite" // In the target, replaced
+	ActionSkip      Action = "skip"      // In the target, kept without --overwrite
+	ActionDelete    Action = "delete"    // Not in the snapshot, moved to the trash
+)
+
+// PlanEntry is one path a restore acts on
+type PlanEntry struct {
+	Path   string `json:"path"`
+	Action Action `json:"action"`
+	Size   int64  `json:"size"` // Bytes restored, or held by a deleted path
+	IsDir  bool   `json:"is_dir,omitempty"`
+}
+
+// Plan lists what a restore does, in the order it does it
+type Plan struct {
+	Entries        []PlanEntry `json:"entries"`
+	Create         int         `json:"create"`
+	CreateBytes    int64       `json:"create_bytes"`
+	Overwrite      int         `json:"overwrite"`
+	OverwriteBytes int64       `json:"overwrite_bytes"`
+	Skip           int         `json:"skip"`
+	Delete         int         `json:"delete"`
+	DeleteBytes    int64       `json:"delete_bytes"`   // File data moved to the trash
+	Download       int64       `json:"download_bytes"` // Stored data read, shared chunks counted once
+	Chunks         int         `json:"chunks"`         // Distinct chunks read
+	Trash          string      `json:"trash,omitempty"`
+	Missing        []string    `json:"missing,omitempty"` // Listed paths the snapshot does not contain
+}
+
+// add records an entry and its totals
+func (p *Plan) add(entry PlanEntry) {
+	p.Entries = append(p.Entries, entry)
+	switch entry.Action {
+	case ActionCreate:
+		p.Create++
+		p.CreateBytes += entry.Size
+	case ActionOverwrite:
+		p.Overwrite++
+		p.OverwriteBytes += entry.Size
+	case ActionSkip:
+		p.Skip++
+	case ActionDelete:
+		p.Delete++
+		p.DeleteBytes += entry.Size
+	}
+}
+
+// Plan works out what restoring a snapshot with opts would do without
+// touching the target: which files are created, overwritten or skipped,
+// which are moved to the trash with Delete, and how much stored data has
+// to be read
+func (r *Restorer) Plan(snapshot *models.Snapshot, opts models.RestoreOptions) (*Plan, error) {
+	if err := checkContents(snapshot); err != nil {
+		return nil, err
+	}
+	if root := snapshot.Tree.Root; root != nil && root.IsBlockDevice() {
+		return r.planDevice(snapshot, opts)
+	}
+
+	plan := &Plan{}
+
+	listed := make(map[string]bool, len(opts.IncludePaths))
+	for _, p := range opts.IncludePaths {
+		p = filepath.Clean(p)
+		listed[p] = true
+		if _, ok := snapshot.Tree.Files[p]; !ok {
+			plan.Missing = append(plan.Missing, p)
+		}
+	}
+
+	// Extra paths leave the target first, so restored files never land in them
+	if opts.Delete {
+		plan.Trash = filepath.Join(opts.TargetPath, TrashDir, time.Now().Format("20060102-150405"))
+		if err := r.planDeletes(plan, snapshot, opts, listed); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, relPath := range restoreOrder(snapshot.Tree.Files) {
+		if !r.shouldRestore(relPath, listed, opts.IncludePattern, opts.ExcludePattern) {
+			continue
+		}
+
+		node := snapshot.Tree.Files[relPath]
+		action := ActionCreate
+		if _, err := os.Stat(filepath.Join(opts.TargetPath, relPath)); err == nil {
+			action = ActionSkip
+			if opts.Overwrite {
+				action = ActionOverwrite
+			}
+		}
+		plan.add(PlanEntry{Path: relPath, Action: action, Size: node.Size})
+	}
+
+	r.planDownload(plan, snapshot.Tree.Files)
+	return plan, nil
+}
+
+// planDeletes adds the selected paths in the target that the snapshot does
+// not contain; a directory missing from the snapshot is moved as a whole
+func (r *Restorer) planDeletes(plan *Plan, snapshot *models.Snapshot, opts models.RestoreOptions, listed map[string]bool) error {
+	if _, err := os.Stat(opts.TargetPath); os.IsNotExist(err) {
+		return nil
+	}
+
+	err := filepath.Walk(opts.TargetPath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		relPath, _ := filepath.Rel(opts.TargetPath, path)
+		if relPath == "." {
+			return nil
+		}
+		if relPath == TrashDir {
+			return filepath.SkipDir
+		}
+		if _, ok := snapshot.Tree.Files[relPath]; ok {
+			return nil
+		}
+		if !r.shouldRestore(relPath, listed, opts.IncludePattern, opts.ExcludePattern) {
+			return nil
+		}
+
+		entry := PlanEntry{Path: relPath, Action: ActionDelete, Size: info.Size(), IsDir: info.IsDir()}
+		if info.IsDir() {
+			entry.Size = treeSize(path)
+			plan.add(entry)
+			return filepath.SkipDir
+		}
+		plan.add(entry)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to scan target: %w", err)
+	}
+	return nil
+}
+
+// planDevice plans writing a block device snapshot to the target path
+func (r *Restorer) planDevice(snapshot *models.Snapshot, opts models.RestoreOptions) (*Plan, error) {
+	plan := &Plan{}
+	node, exists := snapshot.Tree.Files["."]
+	if !exists {
+		return nil, fmt.Errorf("device snapshot has no data node")
+	}
+
+	action := ActionCreate
+	if info, err := os.Stat(opts.TargetPath); err == nil {
+		if info.IsDir() {
+			return nil, fmt.Errorf("target is a directory: %s", opts.TargetPath)
+		}
+		action = ActionSkip
+		if opts.Overwrite {
+			action = ActionOverwrite
+		}
+	}
+	plan.add(PlanEntry{Path: opts.TargetPath, Action: action, Size: node.Size})
+
+	r.planDownload(plan, map[string]*models.FileNode{opts.TargetPath: node})
+	return plan, nil
+}
+
+// planDownload totals the stored data the planned files are restored from
+func (r *Restorer) planDownload(plan *Plan, files map[string]*models.FileNode) {
+	seen := make(map[string]bool)
+	for _, entry := range plan.Entries {
+		if entry.Action != ActionCreate && entry.Action != ActionOverwrite {
+			continue
+		}
+		node := files[entry.Path]
+		plan.Download += int64(len(node.Inline))
+		for _, hash := range node.Chunks {
+			if seen[hash] {
+				continue
+			}
+			seen[hash] = true
+			plan.Chunks++
+			if size, err := r.cas.Size(hash); err == nil {
+				plan.Download += size
+			}
+		}
+	}
+}
+
+// moveToTrash moves a path out of the target into the plan's trash
+// directory, keeping its place in the tree
+func moveToTrash(targetPath, trash, relPath string) error {
+	dest := filepath.Join(trash, relPath)
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return fmt.Errorf("failed to create trash directory: %w", err)
+	}
+	if err := os.Rename(filepath.Join(targetPath, relPath), dest); err != nil {
+		return fmt.Errorf("failed to move to trash: %w", err)
+	}
+	return nil
+}
+
+// treeSize returns the bytes held by the files under path
+func treeSize(path string) int64 {
+	var size int64
+	filepath.Walk(path, func(_ string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size
+}
@@ -0,0 +1,138 @@
+package restore
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+
+	"github.com/snapsync/snapsync/pkg/models"
+)
+
+// FileReader reads a stored file at arbitrary offsets, fetching and
+// decoding chunks on demand
+// Chunk sizes are not recorded, so the first read past a chunk decodes the
+// chunks before it; sequential reads decode each chunk once. The most
+// recently decoded chunk is kept for the reads that follow it.
+type FileReader struct {
+	r    *Restorer
+	node *models.FileNode
+
+	mu     sync.Mutex
+	ends   []int64 // End offset of each chunk decoded so far
+	cached int     // Index of the chunk held in data, -1 for none
+	data   []byte
+}
+
+// OpenFile returns a reader for a file in a snapshot
+func (r *Restorer) OpenFile(node *models.FileNode) *FileReader {
+	return &FileReader{r: r, node: node, cached: -1}
+}
+
+// Size returns the size of the file
+func (f *FileReader) Size() int64 {
+	return f.node.Size
+}
+
+// ReadAt implements io.ReaderAt
+func (f *FileReader) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("negative offset: %d", off)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if !hasContents(f.node) {
+		return 0, ErrMetadataOnly
+	}
+	if f.node.Inline != nil {
+		if f.data == nil {
+			data, err := f.r.loadInline(f.node)
+			if err != nil {
+				return 0, err
+			}
+			f.data = data
+		}
+		if off >= int64(len(f.data)) {
+			return 0, io.EOF
+		}
+		n := copy(p, f.data[off:])
+		if n < len(p) {
+			return n, io.EOF
+		}
+		return n, nil
+	}
+
+	var n int
+	for n < len(p) {
+		i, start, err := f.locate(off)
+		if err != nil {
+			return n, err
+		}
+		if i < 0 {
+			return n, io.EOF
+		}
+
+		copied := copy(p[n:], f.data[off-start:])
+		n += copied
+		off += int64(copied)
+	}
+	return n, nil
+}
+
+// locate decodes the chunk holding off and returns its index and start
+// offset, or -1 past the end of the file
+func (f *FileReader) locate(off int64) (int, int64, error) {
+	// Extend the known chunk boundaries until they cover off
+	for len(f.ends) < len(f.node.Chunks) && (len(f.ends) == 0 || f.ends[len(f.ends)-1] <= off) {
+		if err := f.load(len(f.ends)); err != nil {
+			return 0, 0, err
+		}
+	}
+
+	i := sort.Search(len(f.ends), func(i int) bool { return f.ends[i] > off })
+	if i == len(f.ends) {
+		return -1, 0, nil
+	}
+	if err := f.load(i); err != nil {
+		return 0, 0, err
+	}
+
+	var start int64
+	if i > 0 {
+		start = f.ends[i-1]
+	}
+	return i, start, nil
+}
+
+// load decodes chunk i into the cache, recording its end offset the first
+// time it is seen
+func (f *FileReader) load(i int) error {
+	if f.cached == i {
+		return nil
+	}
+
+	hash := f.node.Chunks[i]
+	data, err := f.r.getChunk(hash)
+	if err == nil {
+		data, err = f.r.decryptChunk(data)
+	}
+	if err == nil {
+		data, err = f.r.decodeChunk(hash, data)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get chunk %s: %w", hash, err)
+	}
+
+	if i == len(f.ends) {
+		var start int64
+		if i > 0 {
+			start = f.ends[i-1]
+		}
+		f.ends = append(f.ends, start+int64(len(data)))
+	}
+	f.cached = i
+	f.data = data
+	return nil
+}
@@ -2,15 +2,21 @@ package restore
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/snapsync/snapsync/internal/compress"
 	"github.com/snapsync/snapsync/internal/crypto"
+	"github.com/snapsync/snapsync/internal/hooks"
 	"github.com/snapsync/snapsync/internal/store"
+	"github.com/snapsync/snapsync/internal/tuning"
 	"github.com/snapsync/snapsync/pkg/models"
 )
 
@@ -19,6 +25,8 @@ type Restorer struct {
 	cas        *store.CAS
 	compressor *compress.Compressor
 	encryptor  *crypto.Encryptor
+	readLimit  *tuning.Bandwidth // Stored chunk data read, nil = unlimited
+	writeLimit *tuning.Bandwidth // Restored file data written, nil = unlimited
 }
 
 // NewRestorer creates a new Restorer
@@ -30,11 +38,45 @@ func NewRestorer(cas *store.CAS, compressor *compress.Compressor, encryptor *cry
 	}
 }
 
+// SetLimits caps the chunk data read from the repository and the file data
+// written per second, so a restore on a busy host leaves disk and network
+// bandwidth to the services running there; nil leaves either unlimited
+func (r *Restorer) SetLimits(read, write *tuning.Bandwidth) {
+	r.readLimit = read
+	r.writeLimit = write
+}
+
 // RestoreResult contains the result of a restore operation
 type RestoreResult struct {
 	FilesRestored int
 	BytesRestored int64
+	FilesDeleted  int    // Paths moved to the trash
+	Trash         string // Directory holding the deleted paths
 	Errors        []RestoreError
+	Missing       []string // Listed paths the snapshot does not contain
+	PostHookError error    // A post-restore hook failed after the files were restored
+	AttrsDenied   int      // Files restored without their capabilities or flags, for lack of privileges
+}
+
+// errAttrsDenied is returned by restoreFile for a file restored without the
+// capabilities or flags it had, for lack of privileges
+var errAttrsDenied = errors.New("file capabilities and flags need root to restore")
+
+// ErrMetadataOnly is returned for the files of snapshots that recorded the
+// file tree without its contents
+var ErrMetadataOnly = errors.New("file contents were not backed up (metadata-only snapshot)")
+
+// checkContents fails for a snapshot whose file contents were not stored
+func checkContents(snapshot *models.Snapshot) error {
+	if snapshot.MetadataOnly {
+		return fmt.Errorf("snapshot %s: %w", snapshot.ID, ErrMetadataOnly)
+	}
+	return nil
+}
+
+// hasContents reports whether a file's contents were stored
+func hasContents(node *models.FileNode) bool {
+	return node.Size == 0 || node.Inline != nil || len(node.Chunks) > 0
 }
 
 // RestoreError represents an error during restore
@@ -44,46 +86,119 @@ type RestoreError struct {
 }
 
 // Restore restores files from a snapshot
+// Pre-restore hooks run first and a failing one aborts the restore before
+// anything is written. Post-restore hooks run afterwards even if the restore
+// failed, so a stopped service is started again; their failure is reported
+// in the result. Dry runs skip hooks.
 func (r *Restorer) Restore(snapshot *models.Snapshot, opts models.RestoreOptions) (*RestoreResult, error) {
-	result := &RestoreResult{}
+	if err := checkContents(snapshot); err != nil {
+		return nil, err
+	}
+	if opts.DryRun {
+		return r.restore(snapshot, opts)
+	}
+
+	env := []string{
+		"SNAPSYNC_SNAPSHOT_ID=" + snapshot.ID,
+		"SNAPSYNC_TARGET=" + opts.TargetPath,
+	}
+	if err := hooks.RunAll(restoreHooks("pre-restore", opts.PreHooks, env, opts.HookTimeout), os.Stdout, os.Stderr); err != nil {
+		return nil, fmt.Errorf("restore aborted: %w", err)
+	}
+
+	result, err := r.restore(snapshot, opts)
+	if len(opts.PostHooks) == 0 {
+		return result, err
+	}
+
+	status, restored := "success", 0
+	switch {
+	case err != nil:
+		status = "failed"
+	case len(result.Errors) > 0 || len(result.Missing) > 0:
+		status, restored = "partial", result.FilesRestored
+	default:
+		restored = result.FilesRestored
+	}
+	env = append(env,
+		"SNAPSYNC_RESTORE_STATUS="+status,
+		fmt.Sprintf("SNAPSYNC_FILES_RESTORED=%d", restored),
+	)
+
+	hookErr := hooks.RunAll(restoreHooks("post-restore", opts.PostHooks, env, opts.HookTimeout), os.Stdout, os.Stderr)
+	if err != nil {
+		if hookErr != nil {
+			return nil, fmt.Errorf("%w (and %v)", err, hookErr)
+		}
+		return nil, err
+	}
+	result.PostHookError = hookErr
+	return result, nil
+}
+
+// restoreHooks builds the hooks for one stage of a restore
+func restoreHooks(name string, commands, env []string, timeout time.Duration) []hooks.Hook {
+	list := make([]hooks.Hook, len(commands))
+	for i, command := range commands {
+		list[i] = hooks.Hook{Name: name, Command: command, Env: env, Timeout: timeout}
+	}
+	return list
+}
+
+// restore restores the files of a snapshot following its plan
+func (r *Restorer) restore(snapshot *models.Snapshot, opts models.RestoreOptions) (*RestoreResult, error) {
+	// Device snapshots restore to a device or image file, not a directory
+	if root := snapshot.Tree.Root; root != nil && root.IsBlockDevice() {
+		return r.restoreDevice(snapshot, opts)
+	}
+
+	plan, err := r.Plan(snapshot, opts)
+	if err != nil {
+		return nil, err
+	}
+	result := &RestoreResult{Missing: plan.Missing}
 
 	// Create target directory
 	if err := os.MkdirAll(opts.TargetPath, 0755); err != nil {
 		return nil, fmt.Errorf("failed to create target directory: %w", err)
 	}
 
-	// Restore each file
-	for relPath, node := range snapshot.Tree.Files {
-		// Skip directories (they'll be created as needed)
-		if node.IsDir {
-			continue
-		}
-
-		// Check include/exclude patterns
-		if !r.shouldRestore(relPath, opts.IncludePattern, opts.ExcludePattern) {
+	// Files are restored in storage order; directories are created as needed
+	for _, entry := range plan.Entries {
+		switch entry.Action {
+		case ActionSkip:
 			continue
-		}
 
-		targetPath := filepath.Join(opts.TargetPath, relPath)
-
-		// Check if file exists
-		if !opts.Overwrite {
-			if _, err := os.Stat(targetPath); err == nil {
-				continue // Skip existing files
+		case ActionDelete:
+			if !opts.DryRun {
+				if err := moveToTrash(opts.TargetPath, plan.Trash, entry.Path); err != nil {
+					result.Errors = append(result.Errors, RestoreError{Path: entry.Path, Error: err})
+					continue
+				}
+			}
+			result.FilesDeleted++
+
+		default:
+			node := snapshot.Tree.Files[entry.Path]
+			err := r.restoreFile(node, filepath.Join(opts.TargetPath, entry.Path), opts)
+			if errors.Is(err, errAttrsDenied) {
+				result.AttrsDenied++
+				err = nil
+			}
+			if err != nil {
+				result.Errors = append(result.Errors, RestoreError{
+					Path:  entry.Path,
+					Error: err,
+				})
+				continue
 			}
-		}
 
-		// Restore the file
-		if err := r.restoreFile(node, targetPath, opts); err != nil {
-			result.Errors = append(result.Errors, RestoreError{
-				Path:  relPath,
-				Error: err,
-			})
-			continue
+			result.FilesRestored++
+			result.BytesRestored += node.Size
 		}
-
-		result.FilesRestored++
-		result.BytesRestored += node.Size
+	}
+	if result.FilesDeleted > 0 {
+		result.Trash = plan.Trash
 	}
 
 	return result, nil
@@ -94,45 +209,28 @@ func (r *Restorer) restoreFile(node *models.FileNode, targetPath string, opts mo
 	if opts.DryRun {
 		return nil
 	}
+	if !hasContents(node) {
+		return ErrMetadataOnly
+	}
 
 	// Create parent directories
 	if err := os.MkdirAll(filepath.Dir(targetPath), 0755); err != nil {
 		return fmt.Errorf("failed to create directories: %w", err)
 	}
 
-	// Create target file
+	// Create target file; one restored immutable before is unlocked first
 	file, err := os.Create(targetPath)
+	if errors.Is(err, os.ErrPermission) && clearFlags(targetPath) == nil {
+		file, err = os.Create(targetPath)
+	}
 	if err != nil {
 		return fmt.Errorf("failed to create file: %w", err)
 	}
 	defer file.Close()
 
-	// Restore chunks
-	for _, chunkHash := range node.Chunks {
-		data, err := r.cas.Get(chunkHash)
-		if err != nil {
-			return fmt.Errorf("failed to get chunk %s: %w", chunkHash, err)
-		}
-
-		// Decrypt if needed
-		if r.encryptor != nil {
-			data, err = r.encryptor.Decrypt(data)
-			if err != nil {
-				return fmt.Errorf("decryption failed: %w", err)
-			}
-		}
-
-		// Decompress if needed
-		if r.compressor != nil {
-			data, err = r.compressor.Decompress(data)
-			if err != nil {
-				return fmt.Errorf("decompression failed: %w", err)
-			}
-		}
-
-		if _, err := file.Write(data); err != nil {
-			return fmt.Errorf("failed to write chunk: %w", err)
-		}
+	// Restore contents
+	if err := r.RestoreToWriter(node, file); err != nil {
+		return err
 	}
 
 	// Restore permissions if requested
@@ -149,39 +247,158 @@ func (r *Restorer) restoreFile(node *models.FileNode, targetPath string, opts mo
 		fmt.Printf("Warning: failed to set mtime on %s: %v\n", targetPath, err)
 	}
 
+	// Capabilities and flags come last, since an immutable file is final
+	if opts.PreservePerms && (len(node.Capability) > 0 || len(node.Flags) > 0) {
+		file.Close()
+		if err := restoreAttrs(targetPath, node); errors.Is(err, os.ErrPermission) {
+			return errAttrsDenied
+		} else if err != nil {
+			fmt.Printf("Warning: %s: %v\n", targetPath, err)
+		}
+	}
+
 	return nil
 }
 
+// restoreDevice writes a block device snapshot to the target path
+// The target may be an existing block device or an image file to create
+func (r *Restorer) restoreDevice(snapshot *models.Snapshot, opts models.RestoreOptions) (*RestoreResult, error) {
+	result := &RestoreResult{}
+
+	node, exists := snapshot.Tree.Files["."]
+	if !exists {
+		return nil, fmt.Errorf("device snapshot has no data node")
+	}
+
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if info, err := os.Stat(opts.TargetPath); err == nil {
+		if info.IsDir() {
+			return nil, fmt.Errorf("target is a directory: %s", opts.TargetPath)
+		}
+		if !opts.Overwrite {
+			return result, nil
+		}
+		if info.Mode()&os.ModeDevice != 0 {
+			// Devices cannot be created or truncated
+			flags = os.O_WRONLY
+		}
+	}
+
+	if opts.DryRun {
+		result.FilesRestored = 1
+		result.BytesRestored = node.Size
+		return result, nil
+	}
+
+	if flags&os.O_CREATE != 0 {
+		if err := os.MkdirAll(filepath.Dir(opts.TargetPath), 0755); err != nil {
+			return nil, fmt.Errorf("failed to create directories: %w", err)
+		}
+	}
+
+	file, err := os.OpenFile(opts.TargetPath, flags, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open target: %w", err)
+	}
+	defer file.Close()
+
+	if err := r.RestoreToWriter(node, file); err != nil {
+		result.Errors = append(result.Errors, RestoreError{
+			Path:  opts.TargetPath,
+			Error: err,
+		})
+		return result, nil
+	}
+
+	if err := file.Sync(); err != nil {
+		return nil, fmt.Errorf("failed to flush target: %w", err)
+	}
+
+	result.FilesRestored = 1
+	result.BytesRestored = node.Size
+	return result, nil
+}
+
 // RestoreToWriter restores a file to an io.Writer
 func (r *Restorer) RestoreToWriter(node *models.FileNode, w io.Writer) error {
-	for _, chunkHash := range node.Chunks {
-		data, err := r.cas.Get(chunkHash)
+	if !hasContents(node) {
+		return ErrMetadataOnly
+	}
+	if node.Inline != nil {
+		data, err := r.loadInline(node)
 		if err != nil {
-			return fmt.Errorf("failed to get chunk: %w", err)
+			return err
 		}
+		r.writeLimit.Wait(len(data))
+		_, err = w.Write(data)
+		return err
+	}
 
-		// Decrypt if needed
-		if r.encryptor != nil {
-			data, err = r.encryptor.Decrypt(data)
-			if err != nil {
-				return fmt.Errorf("decryption failed: %w", err)
-			}
-		}
+	return r.writeChunks(node.Chunks, w)
+}
 
-		// Decompress if needed
-		if r.compressor != nil {
-			data, err = r.compressor.Decompress(data)
-			if err != nil {
-				return fmt.Errorf("decompression failed: %w", err)
-			}
+// getChunk reads a stored chunk within the read limit
+func (r *Restorer) getChunk(hash string) ([]byte, error) {
+	data, err := r.cas.GetChunk(hash)
+	if err != nil {
+		return nil, err
+	}
+	r.readLimit.Wait(len(data))
+	return data, nil
+}
+
+// decryptChunk decrypts stored chunk data if the repository is encrypted
+func (r *Restorer) decryptChunk(data []byte) ([]byte, error) {
+	if r.encryptor == nil {
+		return data, nil
+	}
+
+	data, err := r.encryptor.Decrypt(data)
+	if err != nil {
+		return nil, fmt.Errorf("decryption failed: %w", err)
+	}
+	return data, nil
+}
+
+// decodeChunk decompresses decrypted chunk data and verifies it against
+// the chunk hash
+func (r *Restorer) decodeChunk(hash string, data []byte) ([]byte, error) {
+	// Decompress if needed
+	if r.compressor != nil {
+		var err error
+		data, err = r.compressor.Decompress(data)
+		if err != nil {
+			return nil, fmt.Errorf("decompression failed: %w", err)
 		}
+	}
 
-		if _, err := w.Write(data); err != nil {
-			return err
+	// Verify hash
+	actualHash := sha256.Sum256(data)
+	if hex.EncodeToString(actualHash[:]) != hash {
+		return nil, fmt.Errorf("chunk corruption detected: %s", hash)
+	}
+
+	return data, nil
+}
+
+// loadInline decrypts the contents of a file stored in the tree and
+// verifies them against the file hash
+func (r *Restorer) loadInline(node *models.FileNode) ([]byte, error) {
+	data := node.Inline
+	if r.encryptor != nil {
+		var err error
+		data, err = r.encryptor.Decrypt(data)
+		if err != nil {
+			return nil, fmt.Errorf("decryption failed: %w", err)
 		}
 	}
 
-	return nil
+	actualHash := sha256.Sum256(data)
+	if hex.EncodeToString(actualHash[:]) != node.ContentHash() {
+		return nil, fmt.Errorf("inline data corruption detected: %s", node.Path)
+	}
+
+	return data, nil
 }
 
 // RestoreFile restores a single file by path from a snapshot
@@ -200,10 +417,16 @@ func (r *Restorer) RestoreFile(snapshot *models.Snapshot, filePath, targetPath s
 	return r.restoreFile(node, targetPath, opts)
 }
 
-// shouldRestore checks if a file should be restored based on patterns
-func (r *Restorer) shouldRestore(path string, includes, excludes []string) bool {
+// shouldRestore checks if a file should be restored based on listed paths
+// and patterns
+func (r *Restorer) shouldRestore(path string, listed map[string]bool, includes, excludes []string) bool {
 	// If no includes specified, include all
-	included := len(includes) == 0
+	included := len(includes) == 0 && len(listed) == 0
+
+	// Check listed paths and the directories containing them
+	for p := path; !included && p != "." && p != string(filepath.Separator); p = filepath.Dir(p) {
+		included = listed[p]
+	}
 
 	// Check include patterns
 	for _, pattern := range includes {
@@ -0,0 +1,64 @@
+package restore
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/snapsync/snapsync/pkg/models"
+)
+
+// VerifyFile restores one file from a snapshot into dir, checks the copy on
+// disk against the file's size and hash, and removes it again
+func (r *Restorer) VerifyFile(snapshot *models.Snapshot, relPath, dir string) error {
+	node, exists := snapshot.Tree.Files[relPath]
+	if !exists {
+		return fmt.Errorf("file not found in snapshot: %s", relPath)
+	}
+
+	targetPath := filepath.Join(dir, snapshot.ID, relPath)
+	defer os.Remove(targetPath)
+
+	// Permissions are left alone so the copy can always be read back
+	if err := r.restoreFile(node, targetPath, models.RestoreOptions{TargetPath: dir}); err != nil {
+		return err
+	}
+
+	file, err := os.Open(targetPath)
+	if err != nil {
+		return fmt.Errorf("failed to open restored file: %w", err)
+	}
+	defer file.Close()
+
+	hasher := sha256.New()
+	size, err := io.Copy(hasher, file)
+	if err != nil {
+		return fmt.Errorf("failed to read restored file: %w", err)
+	}
+
+	if size != node.Size {
+		return fmt.Errorf("restored %d bytes, expected %d", size, node.Size)
+	}
+	if expected := node.ContentHash(); expected != "" {
+		if hash := hex.EncodeToString(hasher.Sum(nil)); hash != expected {
+			return fmt.Errorf("restored content hash %s, expected %s", hash[:12], expected)
+		}
+	}
+	return nil
+}
+
+// DecodeObject decrypts and decompresses stored chunk data without checking
+// it, so callers can hash whole objects outside a restore
+func (r *Restorer) DecodeObject(data []byte) ([]byte, error) {
+	data, err := r.decryptChunk(data)
+	if err != nil {
+		return nil, err
+	}
+	if r.compressor != nil {
+		return r.compressor.Decompress(data)
+	}
+	return data, nil
+}
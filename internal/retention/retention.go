@@ -0,0 +1,141 @@
+// Package retention decides which snapshots to keep under keep rules such
+// as "the last 7 daily snapshots"
+package retention
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/snapsync/snapsync/pkg/models"
+)
+
+// Policy is a set of keep rules
+// A snapshot is kept if any rule selects it. Bucket rules keep the newest
+// snapshot of each of the last N hours, days, weeks, months or years that
+// have a snapshot at all.
+type Policy struct {
+	Last    int
+	Hourly  int
+	Daily   int
+	Weekly  int
+	Monthly int
+	Yearly  int
+	Within  time.Duration // Everything newer than this
+}
+
+// Empty reports whether the policy has no rules
+// An empty policy keeps every snapshot rather than none.
+func (p Policy) Empty() bool {
+	return p == Policy{}
+}
+
+// Result is the outcome of applying a policy
+type Result struct {
+	Keep    []*models.Snapshot  // Newest first
+	Remove  []*models.Snapshot  // Newest first
+	Reasons map[string][]string // Rules that kept each snapshot, by ID
+}
+
+// bucketRule keeps the newest snapshot of each period
+type bucketRule struct {
+	name  string
+	count int
+	key   func(t time.Time) string
+}
+
+// Apply decides which snapshots the policy keeps
+func (p Policy) Apply(snapshots []*models.Snapshot, now time.Time) *Result {
+	sorted := make([]*models.Snapshot, len(snapshots))
+	copy(sorted, snapshots)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Timestamp.After(sorted[j].Timestamp)
+	})
+
+	result := &Result{Reasons: make(map[string][]string)}
+	if p.Empty() {
+		for _, snap := range sorted {
+			result.Reasons[snap.ID] = []string{"no rules"}
+		}
+		result.Keep = sorted
+		return result
+	}
+
+	rules := []bucketRule{
+		{"hourly", p.Hourly, func(t time.Time) string { return t.Format("2006-01-02 15") }},
+		{"daily", p.Daily, func(t time.Time) string { return t.Format("2006-01-02") }},
+		{"weekly", p.Weekly, func(t time.Time) string {
+			year, week := t.ISOWeek()
+			return fmt.Sprintf("%d-%02d", year, week)
+		}},
+		{"monthly", p.Monthly, func(t time.Time) string { return t.Format("2006-01") }},
+		{"yearly", p.Yearly, func(t time.Time) string { return t.Format("2006") }},
+	}
+	lastKey := make([]string, len(rules))
+	kept := make([]int, len(rules))
+
+	for i, snap := range sorted {
+		t := snap.Timestamp.Local()
+		var reasons []string
+
+		if i < p.Last {
+			reasons = append(reasons, "last")
+		}
+		for r, rule := range rules {
+			if kept[r] >= rule.count {
+				continue
+			}
+			if key := rule.key(t); key != lastKey[r] {
+				lastKey[r] = key
+				kept[r]++
+				reasons = append(reasons, rule.name)
+			}
+		}
+		if p.Within > 0 && snap.Timestamp.After(now.Add(-p.Within)) {
+			reasons = append(reasons, "within")
+		}
+
+		if len(reasons) > 0 {
+			result.Keep = append(result.Keep, snap)
+			result.Reasons[snap.ID] = reasons
+		} else {
+			result.Remove = append(result.Remove, snap)
+		}
+	}
+
+	return result
+}
+
+// Horizon returns how long the policy keeps a snapshot when backups run
+// every interval, and false if it keeps snapshots indefinitely
+// A bucket rule spans at least one backup per period it keeps, so with
+// backups rarer than its period it reaches further back.
+func (p Policy) Horizon(interval time.Duration) (time.Duration, bool) {
+	if p.Empty() {
+		return 0, false
+	}
+
+	horizon := time.Duration(p.Last) * interval
+	for _, rule := range []struct {
+		count  int
+		period time.Duration
+	}{
+		{p.Hourly, time.Hour},
+		{p.Daily, 24 * time.Hour},
+		{p.Weekly, 7 * 24 * time.Hour},
+		{p.Monthly, 31 * 24 * time.Hour},
+		{p.Yearly, 366 * 24 * time.Hour},
+	} {
+		period := rule.period
+		if interval > period {
+			period = interval
+		}
+		if span := time.Duration(rule.count) * period; span > horizon {
+			horizon = span
+		}
+	}
+	if p.Within > horizon {
+		horizon = p.Within
+	}
+	return horizon, true
+}
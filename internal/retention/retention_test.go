@@ -0,0 +1,179 @@
+package retention
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/snapsync/snapsync/pkg/models"
+)
+
+// at returns a local time; bucket rules group snapshots by local time
+func at(year int, month time.Month, day, hour, min int) time.Time {
+	return time.Date(year, month, day, hour, min, 0, 0, time.Local)
+}
+
+// snap returns a snapshot taken at t
+func snap(id string, t time.Time) *models.Snapshot {
+	return &models.Snapshot{ID: id, Timestamp: t}
+}
+
+// ids lists the IDs of snapshots in order
+func ids(snapshots []*models.Snapshot) []string {
+	list := make([]string, 0, len(snapshots))
+	for _, s := range snapshots {
+		list = append(list, s.ID)
+	}
+	return list
+}
+
+func TestPolicyApply(t *testing.T) {
+	now := at(2024, time.January, 10, 12, 0)
+
+	tests := []struct {
+		name       string
+		policy     Policy
+		snapshots  []*models.Snapshot
+		wantKeep   []string
+		wantRemove []string
+	}{
+		{
+			name:   "empty policy keeps everything",
+			policy: Policy{},
+			snapshots: []*models.Snapshot{
+				snap("a", at(2024, time.January, 1, 12, 0)),
+				snap("b", at(2024, time.January, 2, 12, 0)),
+			},
+			wantKeep:   []string{"b", "a"},
+			wantRemove: []string{},
+		},
+		{
+			name:   "last",
+			policy: Policy{Last: 2},
+			snapshots: []*models.Snapshot{
+				snap("a", at(2024, time.January, 1, 12, 0)),
+				snap("d", at(2024, time.January, 4, 12, 0)),
+				snap("b", at(2024, time.January, 2, 12, 0)),
+				snap("c", at(2024, time.January, 3, 12, 0)),
+			},
+			wantKeep:   []string{"d", "c"},
+			wantRemove: []string{"b", "a"},
+		},
+		{
+			name:   "hourly keeps the newest of each hour",
+			policy: Policy{Hourly: 3},
+			snapshots: []*models.Snapshot{
+				snap("10:05", at(2024, time.January, 9, 10, 5)),
+				snap("10:40", at(2024, time.January, 9, 10, 40)),
+				snap("11:10", at(2024, time.January, 9, 11, 10)),
+				snap("12:30", at(2024, time.January, 9, 12, 30)),
+				snap("12:50", at(2024, time.January, 9, 12, 50)),
+			},
+			wantKeep:   []string{"12:50", "11:10", "10:40"},
+			wantRemove: []string{"12:30", "10:05"},
+		},
+		{
+			name:   "daily",
+			policy: Policy{Daily: 2},
+			snapshots: []*models.Snapshot{
+				snap("jan1", at(2024, time.January, 1, 12, 0)),
+				snap("jan2", at(2024, time.January, 2, 12, 0)),
+				snap("jan3-am", at(2024, time.January, 3, 9, 0)),
+				snap("jan3-pm", at(2024, time.January, 3, 18, 0)),
+			},
+			wantKeep:   []string{"jan3-pm", "jan2"},
+			wantRemove: []string{"jan3-am", "jan1"},
+		},
+		{
+			// 2020-12-31 and 2021-01-03 are both in ISO week 53 of 2020
+			name:   "weekly across the ISO year boundary",
+			policy: Policy{Weekly: 3},
+			snapshots: []*models.Snapshot{
+				snap("2020-w52", at(2020, time.December, 27, 12, 0)),
+				snap("2020-w53-thu", at(2020, time.December, 31, 12, 0)),
+				snap("2020-w53-sun", at(2021, time.January, 3, 12, 0)),
+				snap("2021-w01", at(2021, time.January, 4, 12, 0)),
+			},
+			wantKeep:   []string{"2021-w01", "2020-w53-sun", "2020-w52"},
+			wantRemove: []string{"2020-w53-thu"},
+		},
+		{
+			name:   "monthly",
+			policy: Policy{Monthly: 2},
+			snapshots: []*models.Snapshot{
+				snap("jan", at(2024, time.January, 5, 12, 0)),
+				snap("feb", at(2024, time.February, 10, 12, 0)),
+				snap("mar1", at(2024, time.March, 1, 12, 0)),
+				snap("mar15", at(2024, time.March, 15, 12, 0)),
+			},
+			wantKeep:   []string{"mar15", "feb"},
+			wantRemove: []string{"mar1", "jan"},
+		},
+		{
+			name:   "yearly",
+			policy: Policy{Yearly: 1},
+			snapshots: []*models.Snapshot{
+				snap("2023", at(2023, time.June, 1, 12, 0)),
+				snap("2024", at(2024, time.January, 2, 12, 0)),
+			},
+			wantKeep:   []string{"2024"},
+			wantRemove: []string{"2023"},
+		},
+		{
+			name:   "within",
+			policy: Policy{Within: 48 * time.Hour},
+			snapshots: []*models.Snapshot{
+				snap("old", at(2024, time.January, 8, 11, 0)),
+				snap("edge", at(2024, time.January, 8, 13, 0)),
+				snap("new", at(2024, time.January, 10, 8, 0)),
+			},
+			wantKeep:   []string{"new", "edge"},
+			wantRemove: []string{"old"},
+		},
+		{
+			name:   "rules combine",
+			policy: Policy{Last: 1, Monthly: 2},
+			snapshots: []*models.Snapshot{
+				snap("dec", at(2023, time.December, 20, 12, 0)),
+				snap("jan-early", at(2024, time.January, 2, 12, 0)),
+				snap("jan-late", at(2024, time.January, 9, 12, 0)),
+			},
+			wantKeep:   []string{"jan-late", "dec"},
+			wantRemove: []string{"jan-early"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := tt.policy.Apply(tt.snapshots, now)
+			if got := ids(result.Keep); !reflect.DeepEqual(got, tt.wantKeep) {
+				t.Errorf("kept %v, want %v", got, tt.wantKeep)
+			}
+			if got := ids(result.Remove); !reflect.DeepEqual(got, tt.wantRemove) {
+				t.Errorf("removed %v, want %v", got, tt.wantRemove)
+			}
+		})
+	}
+}
+
+func TestPolicyApplyReasons(t *testing.T) {
+	now := at(2024, time.January, 10, 12, 0)
+	snapshots := []*models.Snapshot{
+		snap("older", at(2024, time.January, 9, 12, 0)),
+		snap("newest", at(2024, time.January, 10, 11, 0)),
+	}
+
+	result := Policy{Last: 1, Daily: 2, Within: 2 * time.Hour}.Apply(snapshots, now)
+	want := map[string][]string{
+		"newest": {"last", "daily", "within"},
+		"older":  {"daily"},
+	}
+	if !reflect.DeepEqual(result.Reasons, want) {
+		t.Errorf("reasons %v, want %v", result.Reasons, want)
+	}
+
+	result = Policy{}.Apply(snapshots, now)
+	if got := result.Reasons["older"]; !reflect.DeepEqual(got, []string{"no rules"}) {
+		t.Errorf("empty policy reasons %v, want [no rules]", got)
+	}
+}
@@ -0,0 +1,98 @@
+package retention
+
+import (
+	"sort"
+	"time"
+
+	"github.com/snapsync/snapsync/pkg/models"
+)
+
+// Tiers applies separate policies to retention tiers such as "monthly" or
+// "yearly", so milestone snapshots survive aggressive pruning of the rest
+// A snapshot belongs to the tier recorded on it, else to the first tier, by
+// name, that claims one of its tags, else to the default tier.
+type Tiers struct {
+	Default  Policy
+	Policies map[string]Policy   // By tier name
+	Tags     map[string][]string // Tags that place a snapshot in each tier
+}
+
+// TierOf returns the tier a snapshot belongs to, "" for the default tier
+func (t *Tiers) TierOf(snap *models.Snapshot) string {
+	if snap.Tier != "" {
+		return snap.Tier
+	}
+
+	names := make([]string, 0, len(t.Tags))
+	for name := range t.Tags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		for _, tag := range t.Tags[name] {
+			for _, have := range snap.Tags {
+				if have == tag {
+					return name
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// Apply groups the snapshots by tier and applies each tier's policy
+// A tier without a policy keeps all of its snapshots.
+func (t *Tiers) Apply(snapshots []*models.Snapshot, now time.Time) *Result {
+	groups := make(map[string][]*models.Snapshot)
+	for _, snap := range snapshots {
+		tier := t.TierOf(snap)
+		groups[tier] = append(groups[tier], snap)
+	}
+
+	result := &Result{Reasons: make(map[string][]string)}
+	for tier, group := range groups {
+		policy := t.Default
+		if tier != "" {
+			policy = t.Policies[tier]
+		}
+
+		partial := policy.Apply(group, now)
+		result.Keep = append(result.Keep, partial.Keep...)
+		result.Remove = append(result.Remove, partial.Remove...)
+		for id, reasons := range partial.Reasons {
+			if tier != "" {
+				for i := range reasons {
+					reasons[i] = tier + ":" + reasons[i]
+				}
+			}
+			result.Reasons[id] = reasons
+		}
+	}
+
+	for _, list := range [][]*models.Snapshot{result.Keep, result.Remove} {
+		sort.SliceStable(list, func(i, j int) bool {
+			return list[i].Timestamp.After(list[j].Timestamp)
+		})
+	}
+	return result
+}
+
+// Horizon returns the longest any tier keeps a snapshot when backups run
+// every interval, and false if some tier keeps snapshots indefinitely
+func (t *Tiers) Horizon(interval time.Duration) (time.Duration, bool) {
+	longest, ok := t.Default.Horizon(interval)
+	if !ok {
+		return 0, false
+	}
+	for _, policy := range t.Policies {
+		horizon, ok := policy.Horizon(interval)
+		if !ok {
+			return 0, false
+		}
+		if horizon > longest {
+			longest = horizon
+		}
+	}
+	return longest, true
+}
@@ -0,0 +1,85 @@
+package retention
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/snapsync/snapsync/pkg/models"
+)
+
+func TestTiersTierOf(t *testing.T) {
+	tiers := &Tiers{
+		Tags: map[string][]string{
+			"yearly":  {"milestone", "release"},
+			"monthly": {"release"},
+		},
+	}
+
+	tests := []struct {
+		name string
+		snap *models.Snapshot
+		want string
+	}{
+		{"untagged goes to the default tier", &models.Snapshot{}, ""},
+		{"unclaimed tag goes to the default tier", &models.Snapshot{Tags: []string{"nightly"}}, ""},
+		{"tag selects its tier", &models.Snapshot{Tags: []string{"milestone"}}, "yearly"},
+		{"first tier by name wins a shared tag", &models.Snapshot{Tags: []string{"release"}}, "monthly"},
+		{"recorded tier wins over tags", &models.Snapshot{Tier: "weekly", Tags: []string{"milestone"}}, "weekly"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tiers.TierOf(tt.snap); got != tt.want {
+				t.Errorf("TierOf = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTiersApply(t *testing.T) {
+	now := at(2024, time.January, 10, 12, 0)
+	tiers := &Tiers{
+		Default:  Policy{Last: 1},
+		Policies: map[string]Policy{"yearly": {Last: 2}},
+		Tags:     map[string][]string{"yearly": {"milestone"}},
+	}
+
+	milestone := func(id string, t time.Time) *models.Snapshot {
+		s := snap(id, t)
+		s.Tags = []string{"milestone"}
+		return s
+	}
+	pinned := snap("pinned", at(2024, time.January, 1, 12, 0))
+	pinned.Tier = "archive"
+
+	snapshots := []*models.Snapshot{
+		snap("d1", at(2024, time.January, 7, 12, 0)),
+		snap("d2", at(2024, time.January, 8, 12, 0)),
+		snap("d3", at(2024, time.January, 9, 12, 0)),
+		milestone("m1", at(2024, time.January, 2, 12, 0)),
+		milestone("m2", at(2024, time.January, 3, 12, 0)),
+		milestone("m3", at(2024, time.January, 4, 12, 0)),
+		pinned,
+	}
+
+	result := tiers.Apply(snapshots, now)
+
+	if got, want := ids(result.Keep), []string{"d3", "m3", "m2", "pinned"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("kept %v, want %v", got, want)
+	}
+	if got, want := ids(result.Remove), []string{"d2", "d1", "m1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("removed %v, want %v", got, want)
+	}
+
+	// A tier without a policy keeps everything; reasons name the tier
+	wantReasons := map[string][]string{
+		"d3":     {"last"},
+		"m3":     {"yearly:last"},
+		"m2":     {"yearly:last"},
+		"pinned": {"archive:no rules"},
+	}
+	if !reflect.DeepEqual(result.Reasons, wantReasons) {
+		t.Errorf("reasons %v, want %v", result.Reasons, wantReasons)
+	}
+}
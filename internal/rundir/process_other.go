@@ -0,0 +1,20 @@
+//go:build !unix
+
+package rundir
+
+import "os"
+
+// processAlive reports whether a process with the given PID is running
+// On Windows finding a process opens it, which fails once it has exited;
+// elsewhere every process is assumed to be running.
+func processAlive(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	p.Release()
+	return true
+}
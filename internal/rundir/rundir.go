@@ -0,0 +1,321 @@
+// Package rundir manages a repository's runtime directory: the locks held
+// by running commands, their temporary spool files, resumable upload
+// checkpoints and caches. Whatever a crashed process leaves behind is
+// cleaned up by the next one to open the directory.
+package rundir
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// locksDir holds one file per lock held by a running process
+	locksDir = "locks"
+
+	// tmpDir holds one directory per running process for its spool files
+	tmpDir = "tmp"
+
+	// ownerFile records which process a temporary directory belongs to
+	ownerFile = "owner.json"
+
+	// orphanAge is how long a lock or temporary directory without a readable
+	// owner is left alone, in case its process is still writing it
+	orphanAge = time.Hour
+)
+
+// ErrLocked is returned when a lock conflicts with one another process holds
+var ErrLocked = errors.New("repository is locked")
+
+// Owner describes the process holding a lock or temporary directory
+type Owner struct {
+	PID       int       `json:"pid"`
+	Host      string    `json:"host"`
+	Kind      string    `json:"kind,omitempty"` // What the lock is for, e.g. backup
+	Exclusive bool      `json:"exclusive,omitempty"`
+	Started   time.Time `json:"started"`
+}
+
+// Dir is a runtime directory
+type Dir struct {
+	path string
+
+	mu  sync.Mutex
+	tmp string // This process's temporary directory, once created
+}
+
+// Open creates the runtime directory at path if needed and removes the
+// locks and temporary files of processes that are no longer running
+func Open(path string) (*Dir, error) {
+	if err := os.MkdirAll(path, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create runtime directory: %w", err)
+	}
+	d := &Dir{path: path}
+	if _, err := d.Clean(); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// Path returns the location of the runtime directory
+func (d *Dir) Path() string {
+	return d.path
+}
+
+// Sub returns a subdirectory for state kept across runs, such as upload
+// checkpoints or a cache, creating it if needed
+func (d *Dir) Sub(name string) (string, error) {
+	if name == locksDir || name == tmpDir {
+		return "", fmt.Errorf("runtime subdirectory %s is reserved", name)
+	}
+	path := filepath.Join(d.path, name)
+	if err := os.MkdirAll(path, 0700); err != nil {
+		return "", fmt.Errorf("failed to create %s: %w", path, err)
+	}
+	return path, nil
+}
+
+// TempDir returns this process's directory for temporary files, creating
+// it on first use
+// No other process writes to it, and it is removed by Close or, after a
+// crash, by the next Open.
+func (d *Dir) TempDir() (string, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.tmp != "" {
+		return d.tmp, nil
+	}
+
+	path := filepath.Join(d.path, tmpDir, fmt.Sprintf("%d-%s", os.Getpid(), randomSuffix()))
+	if err := os.MkdirAll(path, 0700); err != nil {
+		return "", fmt.Errorf("failed to create temporary directory: %w", err)
+	}
+	if err := writeOwner(filepath.Join(path, ownerFile), newOwner("", false), false); err != nil {
+		os.RemoveAll(path)
+		return "", err
+	}
+	d.tmp = path
+	return path, nil
+}
+
+// Close removes this process's temporary directory
+func (d *Dir) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.tmp == "" {
+		return nil
+	}
+	err := os.RemoveAll(d.tmp)
+	d.tmp = ""
+	return err
+}
+
+// Lock is a lock held on the repository
+type Lock struct {
+	path string
+}
+
+// Lock takes a lock of the given kind
+// Any number of shared locks can be held at once, but an exclusive lock
+// excludes every other lock, so a backup (shared) cannot run while a prune
+// (exclusive) deletes objects. Locks of processes that have exited on this
+// host are ignored and removed.
+func (d *Dir) Lock(kind string, exclusive bool) (*Lock, error) {
+	dir := filepath.Join(d.path, locksDir)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create lock directory: %w", err)
+	}
+
+	name := fmt.Sprintf("%s-%d-%s.json", kind, os.Getpid(), randomSuffix())
+	lock := &Lock{path: filepath.Join(dir, name)}
+	if err := writeOwner(lock.path, newOwner(kind, exclusive), true); err != nil {
+		return nil, fmt.Errorf("failed to take %s lock: %w", kind, err)
+	}
+
+	// Taking ours first and then looking means two processes racing for
+	// conflicting locks both back off rather than both going ahead
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		lock.Unlock()
+		return nil, fmt.Errorf("failed to read locks: %w", err)
+	}
+	for _, entry := range entries {
+		if entry.Name() == name || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		owner, err := readOwner(path)
+		if err != nil || d.stale(path, owner) {
+			continue
+		}
+		if exclusive || owner.Exclusive {
+			lock.Unlock()
+			return nil, fmt.Errorf("%w: %s since %s by process %d on %s (remove %s if that process is gone)",
+				ErrLocked, owner.Kind, owner.Started.Local().Format("2006-01-02 15:04:05"), owner.PID, owner.Host, path)
+		}
+	}
+	return lock, nil
+}
+
+// Unlock releases the lock
+func (l *Lock) Unlock() error {
+	if err := os.Remove(l.path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to release lock: %w", err)
+	}
+	return nil
+}
+
+// Holders returns the owners of the locks held by running processes, oldest
+// first
+// Locks whose owner cannot be read yet, because it is being written, are
+// left out.
+func (d *Dir) Holders() ([]*Owner, error) {
+	entries, err := os.ReadDir(filepath.Join(d.path, locksDir))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read locks: %w", err)
+	}
+
+	var owners []*Owner
+	for _, entry := range entries {
+		if !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		path := filepath.Join(d.path, locksDir, entry.Name())
+		owner, err := readOwner(path)
+		if err != nil || d.stale(path, owner) {
+			continue
+		}
+		owners = append(owners, owner)
+	}
+	sort.Slice(owners, func(i, j int) bool { return owners[i].Started.Before(owners[j].Started) })
+	return owners, nil
+}
+
+// Clean removes the locks and temporary directories of processes that are
+// no longer running on this host and returns how many it removed
+// Those of other hosts sharing the directory are never removed, since
+// their processes cannot be checked.
+func (d *Dir) Clean() (int, error) {
+	removed := 0
+
+	locks, err := os.ReadDir(filepath.Join(d.path, locksDir))
+	if err != nil && !os.IsNotExist(err) {
+		return 0, fmt.Errorf("failed to read locks: %w", err)
+	}
+	for _, entry := range locks {
+		path := filepath.Join(d.path, locksDir, entry.Name())
+		owner, err := readOwner(path)
+		if err != nil {
+			owner = nil
+		}
+		if d.stale(path, owner) && os.Remove(path) == nil {
+			removed++
+		}
+	}
+
+	dirs, err := os.ReadDir(filepath.Join(d.path, tmpDir))
+	if err != nil && !os.IsNotExist(err) {
+		return removed, fmt.Errorf("failed to read temporary files: %w", err)
+	}
+	d.mu.Lock()
+	own := d.tmp
+	d.mu.Unlock()
+	for _, entry := range dirs {
+		path := filepath.Join(d.path, tmpDir, entry.Name())
+		if path == own {
+			continue
+		}
+		owner, err := readOwner(filepath.Join(path, ownerFile))
+		if err != nil {
+			owner = nil
+		}
+		if d.stale(path, owner) && os.RemoveAll(path) == nil {
+			removed++
+		}
+	}
+	return removed, nil
+}
+
+// stale reports whether the lock or temporary directory at path belongs to
+// a process that is gone; without an owner, whether it has been abandoned
+func (d *Dir) stale(path string, owner *Owner) bool {
+	if owner == nil {
+		info, err := os.Stat(path)
+		return err == nil && time.Since(info.ModTime()) > orphanAge
+	}
+	if owner.Host != hostname() {
+		return false
+	}
+	return owner.PID != os.Getpid() && !processAlive(owner.PID)
+}
+
+// newOwner describes the current process
+func newOwner(kind string, exclusive bool) *Owner {
+	return &Owner{
+		PID:       os.Getpid(),
+		Host:      hostname(),
+		Kind:      kind,
+		Exclusive: exclusive,
+		Started:   time.Now(),
+	}
+}
+
+// writeOwner writes an owner record, failing if excl and path exists
+func writeOwner(path string, owner *Owner, excl bool) error {
+	data, err := json.Marshal(owner)
+	if err != nil {
+		return err
+	}
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if excl {
+		flags = os.O_WRONLY | os.O_CREATE | os.O_EXCL
+	}
+	file, err := os.OpenFile(path, flags, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		os.Remove(path)
+		return err
+	}
+	return file.Close()
+}
+
+// readOwner reads an owner record
+func readOwner(path string) (*Owner, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var owner Owner
+	if err := json.Unmarshal(data, &owner); err != nil {
+		return nil, err
+	}
+	return &owner, nil
+}
+
+// hostname returns the name of this host, or "" if it is unknown
+func hostname() string {
+	name, _ := os.Hostname()
+	return name
+}
+
+// randomSuffix tells apart the files of processes that reuse a PID
+func randomSuffix() string {
+	b := make([]byte, 4)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,34 @@
+//go:build linux
+
+package scanner
+
+import (
+	"github.com/snapsync/snapsync/pkg/models"
+	"golang.org/x/sys/unix"
+)
+
+// attrsSupported reports whether readAttrs records anything on this system
+const attrsSupported = true
+
+// readAttrs records the file capabilities and the immutable and append-only
+// flags of a regular file
+// Both are best effort: filesystems without them simply report none.
+func readAttrs(path string, node *models.FileNode) {
+	buf := make([]byte, 64)
+	if n, err := unix.Lgetxattr(path, "security.capability", buf); err == nil && n > 0 {
+		node.Capability = buf[:n]
+	}
+
+	// statx reports the flags without opening the file
+	var stx unix.Statx_t
+	if err := unix.Statx(unix.AT_FDCWD, path, unix.AT_SYMLINK_NOFOLLOW, 0, &stx); err != nil {
+		return
+	}
+	known := stx.Attributes_mask
+	if known&stx.Attributes&unix.STATX_ATTR_IMMUTABLE != 0 {
+		node.Flags = append(node.Flags, "immutable")
+	}
+	if known&stx.Attributes&unix.STATX_ATTR_APPEND != 0 {
+		node.Flags = append(node.Flags, "append")
+	}
+}
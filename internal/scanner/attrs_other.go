@@ -0,0 +1,12 @@
+//go:build !linux
+
+package scanner
+
+import "github.com/snapsync/snapsync/pkg/models"
+
+// attrsSupported reports whether readAttrs records anything on this system
+const attrsSupported = false
+
+// readAttrs records Linux file capabilities and flags, which other systems
+// do not have
+func readAttrs(path string, node *models.FileNode) {}
@@ -0,0 +1,39 @@
+package scanner
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/snapsync/snapsync/pkg/models"
+)
+
+// scanDevice builds a single-entry tree for a block device source
+func (s *Scanner) scanDevice(tree *models.FileTree, devicePath string) (*models.FileTree, error) {
+	size, err := deviceSize(devicePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to determine device size: %w", err)
+	}
+
+	node := *tree.Root
+	node.Size = size
+	tree.Root.Size = size
+
+	tree.Files["."] = &node
+	tree.FileCount = 1
+	tree.TotalSize = size
+
+	return tree, nil
+}
+
+// deviceSize returns the size of a block device
+// Stat reports zero for device nodes, so seek to the end instead
+func deviceSize(path string) (int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	return file.Seek(0, io.SeekEnd)
+}
@@ -0,0 +1,102 @@
+package scanner
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/snapsync/snapsync/pkg/models"
+)
+
+// hashBufferSize is the read size used when hashing; each worker holds one
+// buffer, so a pool uses the same memory whatever the size of the files
+const hashBufferSize = 256 * 1024
+
+var hashBuffers = sync.Pool{
+	New: func() interface{} {
+		buf := make([]byte, hashBufferSize)
+		return &buf
+	},
+}
+
+// hashFile computes SHA-256 hash of a file
+func (s *Scanner) hashFile(path string) (string, error) {
+	s.rate.Wait()
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	buf := hashBuffers.Get().(*[]byte)
+	defer hashBuffers.Put(buf)
+
+	// Hide the file's WriteTo so the copy reads into the pooled buffer
+	hasher := sha256.New()
+	if _, err := io.CopyBuffer(hasher, struct{ io.Reader }{file}, *buf); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// hashPool hashes files with the scanner's workers as they are queued
+// The queue holds one file per worker, so queueing blocks while the
+// workers are behind rather than letting files pile up.
+type hashPool struct {
+	s     *Scanner
+	nodes chan *models.FileNode
+	wg    sync.WaitGroup
+	mu    sync.Mutex
+	err   error // First file that could not be hashed
+}
+
+// startHashing starts the hashing workers; wait stops them
+func (s *Scanner) startHashing() *hashPool {
+	p := &hashPool{
+		s:     s,
+		nodes: make(chan *models.FileNode, s.workers),
+	}
+	p.wg.Add(s.workers)
+	for i := 0; i < s.workers; i++ {
+		go p.work()
+	}
+	return p
+}
+
+// add queues a node for hashing
+// Directories, pipes, sockets and devices are skipped, since opening a FIFO
+// or device would block or never end; symlinks are hashed as the file they
+// point to, which is what a backup stores for them.
+func (p *hashPool) add(node *models.FileNode) {
+	if node.IsDir || !node.Mode.IsRegular() && node.Mode&os.ModeSymlink == 0 {
+		return
+	}
+	p.nodes <- node
+}
+
+// wait hashes the queued files and returns the first error
+// The files that failed are left unhashed.
+func (p *hashPool) wait() error {
+	close(p.nodes)
+	p.wg.Wait()
+	return p.err
+}
+
+func (p *hashPool) work() {
+	defer p.wg.Done()
+	for node := range p.nodes {
+		hash, err := p.s.hashFile(node.Path)
+		if err != nil {
+			p.mu.Lock()
+			if p.err == nil {
+				p.err = err
+			}
+			p.mu.Unlock()
+			continue
+		}
+		node.Hash = hash
+	}
+}
@@ -1,22 +1,21 @@
 package scanner
 
 import (
-	"crypto/sha256"
-	"encoding/hex"
-	"io"
 	"os"
 	"path/filepath"
 	"strings"
-	"sync"
 
+	"github.com/snapsync/snapsync/internal/appdata"
+	"github.com/snapsync/snapsync/internal/tuning"
 	"github.com/snapsync/snapsync/pkg/models"
 )
 
 // Scanner walks a directory tree and builds a FileTree
 type Scanner struct {
 	exclusions []string
-	workers    int
-	mu         sync.Mutex
+	workers    int          // Directories listed and files hashed at once
+	rate       *tuning.Rate // Paces stat, readdir and open calls, nil = unlimited
+	apps       *appdata.Rules
 }
 
 // New creates a new Scanner
@@ -30,6 +29,24 @@ func New(exclusions []string, workers int) *Scanner {
 	}
 }
 
+// SetLimits sets how many directories are listed and files hashed at once,
+// and caps the file system operations a scan makes per second: every
+// directory listing, stat and file opened for reading counts as one
+// workers <= 0 keeps the current parallelism; opsPerSecond <= 0 removes
+// the cap.
+func (s *Scanner) SetLimits(workers, opsPerSecond int) {
+	if workers > 0 {
+		s.workers = workers
+	}
+	s.rate = tuning.NewRate(opsPerSecond)
+}
+
+// SetAppData makes scans leave out the caches and lock files of the
+// applications whose app data presets rules holds
+func (s *Scanner) SetAppData(rules *appdata.Rules) {
+	s.apps = rules
+}
+
 // ScanResult contains the result of a scan operation
 type ScanResult struct {
 	Tree  *models.FileTree
@@ -38,6 +55,12 @@ type ScanResult struct {
 
 // Scan walks the source directory and returns a FileTree
 func (s *Scanner) Scan(sourcePath string) (*models.FileTree, error) {
+	return s.scan(sourcePath, nil)
+}
+
+// scan walks the source directory, queueing files on hashes as they are
+// found if it is not nil
+func (s *Scanner) scan(sourcePath string, hashes *hashPool) (*models.FileTree, error) {
 	sourcePath, err := filepath.Abs(sourcePath)
 	if err != nil {
 		return nil, err
@@ -59,105 +82,118 @@ func (s *Scanner) Scan(sourcePath string) (*models.FileTree, error) {
 		},
 	}
 
-	// Walk the directory
-	err = filepath.Walk(sourcePath, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-
-		// Check exclusions
-		relPath, _ := filepath.Rel(sourcePath, path)
-		if s.shouldExclude(relPath, info.Name()) {
-			if info.IsDir() {
-				return filepath.SkipDir
-			}
-			return nil
-		}
-
-		node := &models.FileNode{
-			Path:    path,
-			Name:    info.Name(),
-			IsDir:   info.IsDir(),
-			Mode:    info.Mode(),
-			Size:    info.Size(),
-			ModTime: info.ModTime(),
-		}
-
-		if info.IsDir() {
-			tree.DirCount++
-		} else {
-			tree.FileCount++
-			tree.TotalSize += info.Size()
-		}
-
-		s.mu.Lock()
-		tree.Files[relPath] = node
-		s.mu.Unlock()
-
-		return nil
-	})
+	// Block devices are backed up as a single raw stream
+	if tree.Root.IsBlockDevice() {
+		return s.scanDevice(tree, sourcePath)
+	}
 
+	err = s.walk(sourcePath, tree, hashes)
 	return tree, err
 }
 
 // ScanWithHashes scans and computes file hashes
+// Regular files are hashed by the scanner's workers while the walk goes on.
+// A file that is busy or gone is left unhashed; storing it reports the
+// error, or hashes it if it can be read by then.
 func (s *Scanner) ScanWithHashes(sourcePath string) (*models.FileTree, error) {
-	tree, err := s.Scan(sourcePath)
+	hashes := s.startHashing()
+	tree, err := s.scan(sourcePath, hashes)
+	hashes.wait()
 	if err != nil {
 		return nil, err
 	}
+	return tree, nil
+}
 
-	// Compute hashes for all files
-	for relPath, node := range tree.Files {
-		if node.IsDir {
-			continue
-		}
-
-		hash, err := s.hashFile(node.Path)
-		if err != nil {
-			return nil, err
-		}
-		tree.Files[relPath].Hash = hash
-	}
+// Exclusion describes why a path is left out of a scan
+type Exclusion struct {
+	Pattern string // Exclusion pattern that matched
+	Rule    string // How it matched: name, glob, path, root or app
+}
 
-	return tree, nil
+// shouldExclude checks if a path should be excluded
+func (s *Scanner) shouldExclude(relPath, name string) bool {
+	return s.matchExclusion(relPath, name) != nil
 }
 
-// hashFile computes SHA-256 hash of a file
-func (s *Scanner) hashFile(path string) (string, error) {
-	file, err := os.Open(path)
-	if err != nil {
-		return "", err
+// Excluded reports whether a path or a directory above it is excluded, for
+// sources listed whole rather than walked directory by directory
+func (s *Scanner) Excluded(relPath string) bool {
+	for p := relPath; p != "." && p != string(filepath.Separator); p = filepath.Dir(p) {
+		if s.shouldExclude(p, filepath.Base(p)) {
+			return true
+		}
 	}
-	defer file.Close()
+	return false
+}
 
-	hasher := sha256.New()
-	if _, err := io.Copy(hasher, file); err != nil {
-		return "", err
+// matchAppData returns the app data preset rule leaving out the file or
+// directory at an absolute path, or nil
+func (s *Scanner) matchAppData(path string) *Exclusion {
+	match := s.apps.Match(path)
+	if match == nil || match.Action != appdata.Skip {
+		return nil
 	}
-
-	return hex.EncodeToString(hasher.Sum(nil)), nil
+	return &Exclusion{Pattern: match.Name + ": " + match.Pattern, Rule: "app"}
 }
 
-// shouldExclude checks if a path should be excluded
-func (s *Scanner) shouldExclude(relPath, name string) bool {
+// matchExclusion returns the first exclusion matching a path, or nil
+// Patterns starting with / match only at the source root.
+func (s *Scanner) matchExclusion(relPath, name string) *Exclusion {
+	slashed := filepath.ToSlash(relPath)
 	for _, pattern := range s.exclusions {
+		if anchored := strings.TrimPrefix(pattern, "/"); anchored != pattern {
+			if slashed == anchored || strings.HasPrefix(slashed, anchored+"/") {
+				return &Exclusion{Pattern: pattern, Rule: "root"}
+			}
+			continue
+		}
+
 		// Check exact name match
 		if pattern == name {
-			return true
+			return &Exclusion{Pattern: pattern, Rule: "name"}
 		}
 
 		// Check glob pattern
 		if matched, _ := filepath.Match(pattern, name); matched {
-			return true
+			return &Exclusion{Pattern: pattern, Rule: "glob"}
 		}
 
 		// Check path pattern
-		if strings.Contains(relPath, pattern) {
-			return true
+		if strings.Contains(slashed, pattern) {
+			return &Exclusion{Pattern: pattern, Rule: "path"}
 		}
 	}
-	return false
+	return nil
+}
+
+// ExplainExclusions walks sourcePath the way Scan does and calls visit for
+// every path it reaches, with the matching exclusion or nil if the path
+// would be backed up
+// Excluded directories are reported once; their contents are not visited.
+func (s *Scanner) ExplainExclusions(sourcePath string, visit func(relPath string, isDir bool, ex *Exclusion)) error {
+	sourcePath, err := filepath.Abs(sourcePath)
+	if err != nil {
+		return err
+	}
+
+	return filepath.Walk(sourcePath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		relPath, _ := filepath.Rel(sourcePath, path)
+		ex := s.matchExclusion(relPath, info.Name())
+		if ex == nil {
+			ex = s.matchAppData(path)
+		}
+		visit(relPath, info.IsDir(), ex)
+
+		if ex != nil && info.IsDir() {
+			return filepath.SkipDir
+		}
+		return nil
+	})
 }
 
 // QuickScan performs a fast scan using only mtime/size changes
@@ -192,13 +228,12 @@ func (s *Scanner) QuickScan(sourcePath string, previous *models.FileTree) (*mode
 	}
 
 	// Hash only changed files
+	hashes := s.startHashing()
 	for _, relPath := range changedFiles {
-		node := tree.Files[relPath]
-		hash, err := s.hashFile(node.Path)
-		if err != nil {
-			return nil, nil, err
-		}
-		tree.Files[relPath].Hash = hash
+		hashes.add(tree.Files[relPath])
+	}
+	if err := hashes.wait(); err != nil {
+		return nil, nil, err
 	}
 
 	return tree, changedFiles, nil
@@ -0,0 +1,152 @@
+package scanner
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/snapsync/snapsync/internal/sqlitesnap"
+	"github.com/snapsync/snapsync/pkg/models"
+)
+
+// walker lists a directory tree with a pool of workers taking directories
+// from a shared stack. Like filepath.Walk it does not follow symlinks and
+// stops at the first error.
+type walker struct {
+	s      *Scanner
+	root   string
+	tree   *models.FileTree
+	hashes *hashPool // Hashes files as they are found, nil = no hashing
+	mu     sync.Mutex
+	cond   *sync.Cond
+	stack  []string // Directories waiting to be listed, relative to root
+	active int      // Directories being listed
+	err    error
+}
+
+// walk adds every path under root that is not excluded to tree, and queues
+// the files on hashes if it is not nil
+func (s *Scanner) walk(root string, tree *models.FileTree, hashes *hashPool) error {
+	w := &walker{s: s, root: root, tree: tree, hashes: hashes}
+	w.cond = sync.NewCond(&w.mu)
+
+	s.rate.Wait()
+	info, err := os.Lstat(root)
+	if err != nil {
+		return err
+	}
+	if !w.visit(".", info) {
+		return nil
+	}
+	w.stack = append(w.stack, ".")
+
+	var wg sync.WaitGroup
+	for i := 0; i < s.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w.work()
+		}()
+	}
+	wg.Wait()
+
+	return w.err
+}
+
+// work lists directories until none are left or one fails
+func (w *walker) work() {
+	for {
+		w.mu.Lock()
+		for len(w.stack) == 0 && w.active > 0 && w.err == nil {
+			w.cond.Wait()
+		}
+		if len(w.stack) == 0 || w.err != nil {
+			w.mu.Unlock()
+			return
+		}
+		dir := w.stack[len(w.stack)-1]
+		w.stack = w.stack[:len(w.stack)-1]
+		w.active++
+		w.mu.Unlock()
+
+		subdirs, err := w.list(dir)
+
+		w.mu.Lock()
+		w.active--
+		if err != nil && w.err == nil {
+			w.err = err
+		}
+		w.stack = append(w.stack, subdirs...)
+		w.cond.Broadcast()
+		w.mu.Unlock()
+	}
+}
+
+// list visits the entries of one directory and returns the subdirectories
+// to descend into
+func (w *walker) list(dir string) ([]string, error) {
+	w.s.rate.Wait()
+	entries, err := os.ReadDir(filepath.Join(w.root, dir))
+	if err != nil {
+		return nil, err
+	}
+
+	var subdirs []string
+	for _, entry := range entries {
+		relPath := filepath.Join(dir, entry.Name())
+
+		w.s.rate.Wait()
+		info, err := os.Lstat(filepath.Join(w.root, relPath))
+		if err != nil {
+			return nil, err
+		}
+		if w.visit(relPath, info) && info.IsDir() {
+			subdirs = append(subdirs, relPath)
+		}
+	}
+	return subdirs, nil
+}
+
+// visit adds a path to the tree and reports whether it was kept
+func (w *walker) visit(relPath string, info os.FileInfo) bool {
+	if w.s.shouldExclude(relPath, info.Name()) {
+		return false
+	}
+
+	path := filepath.Join(w.root, relPath)
+	if w.s.matchAppData(path) != nil {
+		return false
+	}
+	node := &models.FileNode{
+		Path:    path,
+		Name:    info.Name(),
+		IsDir:   info.IsDir(),
+		Mode:    info.Mode(),
+		Size:    info.Size(),
+		ModTime: info.ModTime(),
+	}
+	if !info.IsDir() && info.Mode().IsRegular() && sqlitesnap.MaybeDatabase(info.Size()) {
+		w.s.rate.Wait()
+		node.SQLite = sqlitesnap.IsDatabase(path, info.Size())
+	}
+	if info.Mode().IsRegular() && attrsSupported {
+		w.s.rate.Wait()
+		readAttrs(path, node)
+	}
+
+	w.mu.Lock()
+	if info.IsDir() {
+		w.tree.DirCount++
+	} else {
+		w.tree.FileCount++
+		w.tree.TotalSize += info.Size()
+	}
+	w.tree.Files[relPath] = node
+	w.mu.Unlock()
+
+	// Queued outside the lock, since it waits while the hashers are behind
+	if w.hashes != nil {
+		w.hashes.add(node)
+	}
+	return true
+}
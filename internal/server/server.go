@@ -0,0 +1,139 @@
+// Package server exposes a repository over an HTTP API
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/snapsync/snapsync/internal/snapshot"
+)
+
+// Stats is the body of GET /v1/stats
+type Stats struct {
+	Generated           time.Time                `json:"generated"`
+	Snapshots           int                      `json:"snapshots"`
+	Objects             int                      `json:"objects"`
+	StoredSize          int64                    `json:"stored_size"`
+	ReferencedObjects   int                      `json:"referenced_objects"`
+	ReferencedSize      int64                    `json:"referenced_size"`
+	UnreferencedObjects int                      `json:"unreferenced_objects"`
+	UnreferencedSize    int64                    `json:"unreferenced_size"`
+	LogicalSize         int64                    `json:"logical_size"`
+	DedupRatio          float64                  `json:"dedup_ratio"` // Logical over referenced size
+	SnapshotUsage       []snapshot.SnapshotUsage `json:"snapshot_usage"`
+	Verifications       []snapshot.Verification  `json:"verifications"`
+}
+
+// Server answers API requests for one repository
+// Statistics walk every snapshot, so they are cached for cacheTTL and
+// computed by one request at a time.
+type Server struct {
+	mgr         *snapshot.Manager
+	repoPath    string
+	token       string
+	cacheTTL    time.Duration
+	allowDelete bool
+
+	mu    sync.Mutex
+	stats *Stats
+}
+
+// New creates a server for a repository
+// With a token, every request must carry it as a bearer token. Without
+// allowDelete, stored objects and snapshot records can only be added.
+func New(mgr *snapshot.Manager, repoPath, token string, cacheTTL time.Duration, allowDelete bool) *Server {
+	return &Server{mgr: mgr, repoPath: repoPath, token: token, cacheTTL: cacheTTL, allowDelete: allowDelete}
+}
+
+// Handler returns the HTTP handler serving the API
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/stats", s.handleStats)
+	mux.HandleFunc("/v1/objects/", s.handleStorage)
+	mux.HandleFunc("/v1/snapshots/", s.handleStorage)
+	return s.authenticate(mux)
+}
+
+// authenticate rejects requests without the server's bearer token
+func (s *Server) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.token != "" {
+			given := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if subtle.ConstantTimeCompare([]byte(given), []byte(s.token)) != 1 {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="snapsync"`)
+				writeError(w, http.StatusUnauthorized, "invalid or missing token")
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) handleStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	stats, err := s.repoStats(r.URL.Query().Get("refresh") == "1")
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, stats)
+}
+
+// repoStats returns the cached statistics, recomputing them once stale
+func (s *Server) repoStats(refresh bool) (*Stats, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.stats != nil && !refresh && time.Since(s.stats.Generated) < s.cacheTTL {
+		return s.stats, nil
+	}
+
+	usage, err := s.mgr.Usage()
+	if err != nil {
+		return nil, err
+	}
+	verifications, err := s.mgr.Verifications()
+	if err != nil {
+		return nil, err
+	}
+
+	stats := &Stats{
+		Generated:           time.Now().UTC(),
+		Snapshots:           len(usage.Snapshots),
+		Objects:             usage.Objects,
+		StoredSize:          usage.StoredSize,
+		ReferencedObjects:   usage.ReferencedObjects,
+		ReferencedSize:      usage.ReferencedSize,
+		UnreferencedObjects: usage.Objects - usage.ReferencedObjects,
+		UnreferencedSize:    usage.StoredSize - usage.ReferencedSize,
+		LogicalSize:         usage.LogicalSize,
+		SnapshotUsage:       usage.Snapshots,
+		Verifications:       verifications,
+	}
+	if usage.ReferencedSize > 0 {
+		stats.DedupRatio = float64(usage.LogicalSize) / float64(usage.ReferencedSize)
+	}
+
+	s.stats = stats
+	return stats, nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
@@ -0,0 +1,195 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// maxUpload bounds the body of one PUT
+const maxUpload = 1 << 30
+
+var (
+	objectKey   = regexp.MustCompile(`^objects/([0-9a-f]{2})/([0-9a-f]{64})$`)
+	snapshotKey = regexp.MustCompile(`^snapshots/[A-Za-z0-9_-][A-Za-z0-9._-]*$`)
+)
+
+// KeyList is the body of GET /v1/objects/ and GET /v1/snapshots/
+type KeyList struct {
+	Keys []string `json:"keys"`
+}
+
+// handleStorage serves the objects and snapshot records of the repository
+// by key, the path below /v1/:
+//
+//	GET, HEAD  objects/xx/<hash>, snapshots/<name>   read one
+//	PUT        objects/xx/<hash>, snapshots/<name>   store one
+//	DELETE     objects/xx/<hash>, snapshots/<name>   remove one
+//	GET        objects/, snapshots/ ?prefix=<key>    list keys
+//
+// Unless deletes are allowed the store is append-only: a key that exists
+// cannot be replaced or removed.
+func (s *Server) handleStorage(w http.ResponseWriter, r *http.Request) {
+	key := strings.TrimPrefix(r.URL.Path, "/v1/")
+	if key == "objects/" || key == "snapshots/" {
+		s.listKeys(w, r, key)
+		return
+	}
+	if !validKey(key) {
+		writeError(w, http.StatusNotFound, "invalid key")
+		return
+	}
+
+	path := filepath.Join(s.repoPath, filepath.FromSlash(key))
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		s.getKey(w, r, path)
+	case http.MethodPut:
+		s.putKey(w, r, path)
+	case http.MethodDelete:
+		s.deleteKey(w, path)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+func (s *Server) getKey(w http.ResponseWriter, r *http.Request, path string) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil || !info.Mode().IsRegular() {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.FormatInt(info.Size(), 10))
+	w.WriteHeader(http.StatusOK)
+	if r.Method == http.MethodGet {
+		_, _ = io.Copy(w, file)
+	}
+}
+
+// putKey stores the body through a temporary file, so a failed or
+// interrupted upload never leaves a partial object behind
+func (s *Server) putKey(w http.ResponseWriter, r *http.Request, path string) {
+	if _, err := os.Stat(path); err == nil && !s.allowDelete {
+		writeError(w, http.StatusConflict, "key exists and the server is append-only")
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".upload-*")
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer os.Remove(tmp.Name())
+
+	written, err := io.Copy(tmp, http.MaxBytesReader(w, r.Body, maxUpload))
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("failed to read upload: %v", err))
+		return
+	}
+	if r.ContentLength >= 0 && written != r.ContentLength {
+		writeError(w, http.StatusBadRequest, "upload is shorter than its Content-Length")
+		return
+	}
+
+	// Link rather than rename, so of two concurrent uploads only one lands
+	if !s.allowDelete {
+		if err := os.Link(tmp.Name(), path); err != nil {
+			if os.IsExist(err) {
+				writeError(w, http.StatusConflict, "key exists and the server is append-only")
+				return
+			}
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+	} else if err := os.Rename(tmp.Name(), path); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (s *Server) deleteKey(w http.ResponseWriter, path string) {
+	if !s.allowDelete {
+		writeError(w, http.StatusForbidden, "the server is append-only")
+		return
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// listKeys lists the keys under root, objects/ or snapshots/, that start
+// with the prefix query parameter
+func (s *Server) listKeys(w http.ResponseWriter, r *http.Request, root string) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	prefix := r.URL.Query().Get("prefix")
+
+	list := KeyList{Keys: []string{}}
+	base := filepath.Join(s.repoPath, strings.TrimSuffix(root, "/"))
+	err := filepath.Walk(base, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if !info.Mode().IsRegular() || strings.HasPrefix(info.Name(), ".") {
+			return nil
+		}
+		relPath, err := filepath.Rel(s.repoPath, path)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(relPath)
+		if strings.HasPrefix(key, prefix) && validKey(key) {
+			list.Keys = append(list.Keys, key)
+		}
+		return nil
+	})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	sort.Strings(list.Keys)
+	writeJSON(w, http.StatusOK, list)
+}
+
+// validKey reports whether key names an object, as objects/xx/<hash>, or a
+// snapshot record
+func validKey(key string) bool {
+	if m := objectKey.FindStringSubmatch(key); m != nil {
+		return m[1] == m[2][:2]
+	}
+	return snapshotKey.MatchString(key)
+}
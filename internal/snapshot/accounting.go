@@ -0,0 +1,117 @@
+package snapshot
+
+import (
+	"fmt"
+	"sort"
+)
+
+const (
+	// untagged groups the snapshots without tags in tag accounting
+	untagged = "(untagged)"
+
+	// unknownHost groups snapshots recorded before hostnames were
+	unknownHost = "(unknown)"
+)
+
+// GroupUsage is the stored data attributed to one host or tag
+type GroupUsage struct {
+	Name          string `json:"name"`
+	Snapshots     int    `json:"snapshots"`
+	LogicalSize   int64  `json:"logical_size"`   // Summed over its snapshots
+	Objects       int    `json:"objects"`        // Distinct objects its snapshots reference
+	ExclusiveSize int64  `json:"exclusive_size"` // Objects no other group references
+	SharedSize    int64  `json:"shared_size"`    // Its share of objects other groups also reference
+	Attributed    int64  `json:"attributed"`     // ExclusiveSize plus SharedSize
+}
+
+// Accounting attributes the repository's referenced data to hosts or tags
+// An object referenced by several groups is split evenly between them, so
+// the groups' Attributed sizes add up to ReferencedSize. A snapshot with
+// several tags counts towards each of them.
+type Accounting struct {
+	By             string       `json:"by"` // "host" or "tag"
+	ReferencedSize int64        `json:"referenced_size"`
+	Groups         []GroupUsage `json:"groups"` // Largest attribution first
+}
+
+// Accounting groups the snapshots by host or tag and attributes the stored
+// size of the objects they reference to each group
+func (m *Manager) Accounting(by string) (*Accounting, error) {
+	if by != "host" && by != "tag" {
+		return nil, fmt.Errorf("unknown accounting grouping %q (use host or tag)", by)
+	}
+
+	snapshots, err := m.records()
+	if err != nil {
+		return nil, err
+	}
+	usage, objects, err := m.usage(snapshots)
+	if err != nil {
+		return nil, err
+	}
+
+	groups := make(map[string]*GroupUsage)
+	groupObjects := make(map[string]map[string]bool)
+	objectGroups := make(map[string][]string)
+	for _, snap := range snapshots {
+		names := snap.Tags
+		if by == "host" {
+			names = []string{snap.Hostname}
+			if snap.Hostname == "" {
+				names = []string{unknownHost}
+			}
+		} else if len(names) == 0 {
+			names = []string{untagged}
+		}
+
+		for _, name := range names {
+			g, ok := groups[name]
+			if !ok {
+				g = &GroupUsage{Name: name}
+				groups[name] = g
+				groupObjects[name] = make(map[string]bool)
+			}
+			g.Snapshots++
+			g.LogicalSize += snap.Stats.TotalSize
+
+			for hash := range objects.refs[snap.ID] {
+				if !groupObjects[name][hash] {
+					groupObjects[name][hash] = true
+					objectGroups[hash] = append(objectGroups[hash], name)
+				}
+			}
+		}
+	}
+
+	for hash, names := range objectGroups {
+		size := objects.sizes[hash]
+		if len(names) == 1 {
+			groups[names[0]].ExclusiveSize += size
+			continue
+		}
+		// The remainder of an uneven split goes to the first names, so the
+		// shares add up to the object's size
+		sort.Strings(names)
+		share, rest := size/int64(len(names)), size%int64(len(names))
+		for i, name := range names {
+			groups[name].SharedSize += share
+			if int64(i) < rest {
+				groups[name].SharedSize++
+			}
+		}
+	}
+
+	acct := &Accounting{By: by, ReferencedSize: usage.ReferencedSize, Groups: []GroupUsage{}}
+	for name, g := range groups {
+		g.Objects = len(groupObjects[name])
+		g.Attributed = g.ExclusiveSize + g.SharedSize
+		acct.Groups = append(acct.Groups, *g)
+	}
+	sort.Slice(acct.Groups, func(i, j int) bool {
+		if acct.Groups[i].Attributed != acct.Groups[j].Attributed {
+			return acct.Groups[i].Attributed > acct.Groups[j].Attributed
+		}
+		return acct.Groups[i].Name < acct.Groups[j].Name
+	})
+	return acct, nil
+}
@@ -0,0 +1,240 @@
+package snapshot
+
+import (
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/snapsync/snapsync/pkg/models"
+)
+
+// AnalyzeOptions sets how many snapshots Analyze compares and what counts
+// as a directory worth excluding
+type AnalyzeOptions struct {
+	Snapshots int     // Recent backups of each source to compare
+	MinBytes  int64   // New data per backup below which a directory is ignored
+	MinChurn  float64 // Share of files changed per backup, 0 to 1
+	MaxDedup  float64 // Share of changed data already in the repository, 0 to 1
+}
+
+// ChurnDir is a directory whose files keep changing between backups without
+// deduplicating against earlier versions, such as a browser cache or a build
+// output directory
+// Byte counts are uncompressed. Chunk sizes are not recorded, so new data is
+// estimated from the share of a file's chunks that are new.
+type ChurnDir struct {
+	Root         string        `json:"root"`
+	Path         string        `json:"path"`    // Relative to the backup root
+	Pattern      string        `json:"pattern"` // Suggested exclusion pattern
+	Size         int64         `json:"size"`    // In the newest snapshot
+	Files        int           `json:"files"`
+	Stable       int           `json:"stable_files"` // Unchanged in every backup compared
+	Changes      int           `json:"changes"`      // Files added or modified, summed over backups
+	ChangedBytes int64         `json:"changed_bytes"`
+	NewBytes     int64         `json:"new_bytes"` // Changed data not found in earlier snapshots
+	Snapshots    int           `json:"snapshots"` // Backups compared
+	SpaceSaved   int64         `json:"space_saved"`
+	TimeSaved    time.Duration `json:"time_saved"`
+}
+
+// Churn returns the share of the directory's files changed per backup
+func (d *ChurnDir) Churn() float64 {
+	if d.Files == 0 || d.Snapshots == 0 {
+		return 0
+	}
+	return float64(d.Changes) / float64(d.Files*d.Snapshots)
+}
+
+// Dedup returns the share of changed data that was already stored
+func (d *ChurnDir) Dedup() float64 {
+	if d.ChangedBytes == 0 {
+		return 1
+	}
+	return 1 - float64(d.NewBytes)/float64(d.ChangedBytes)
+}
+
+// Analyze compares the recent snapshots of each source and returns the
+// directories that are worth excluding, largest saving first
+// SpaceSaved and TimeSaved are per backup. Time is attributed to a directory
+// by its share of the new data in each backup.
+func (m *Manager) Analyze(opts AnalyzeOptions) ([]ChurnDir, error) {
+	snapshots, err := m.List()
+	if err != nil {
+		return nil, err
+	}
+
+	// Snapshots are newest first; keep the baseline plus opts.Snapshots per source
+	bySource := make(map[string][]*models.Snapshot)
+	var roots []string
+	for _, snap := range snapshots {
+		if snap.Tree == nil || snap.Tree.Files == nil {
+			continue
+		}
+		root := ""
+		if snap.Tree.Root != nil {
+			root = snap.Tree.Root.Path
+		}
+		if _, ok := bySource[root]; !ok {
+			roots = append(roots, root)
+		}
+		if len(bySource[root]) <= opts.Snapshots {
+			bySource[root] = append(bySource[root], snap)
+		}
+	}
+	sort.Strings(roots)
+
+	var suggestions []ChurnDir
+	for _, root := range roots {
+		suggestions = append(suggestions, analyzeSource(root, bySource[root], opts)...)
+	}
+
+	sort.SliceStable(suggestions, func(i, j int) bool {
+		if suggestions[i].SpaceSaved != suggestions[j].SpaceSaved {
+			return suggestions[i].SpaceSaved > suggestions[j].SpaceSaved
+		}
+		return suggestions[i].Path < suggestions[j].Path
+	})
+	return suggestions, nil
+}
+
+// analyzeSource analyzes the snapshots of one source, given newest first
+func analyzeSource(root string, snapshots []*models.Snapshot, opts AnalyzeOptions) []ChurnDir {
+	if len(snapshots) < 2 {
+		return nil
+	}
+
+	dirs := make(map[string]*ChurnDir)
+	dir := func(path string) *ChurnDir {
+		d, ok := dirs[path]
+		if !ok {
+			d = &ChurnDir{Root: root, Path: path, Pattern: path, Snapshots: len(snapshots) - 1}
+			dirs[path] = d
+		}
+		return d
+	}
+
+	// Content of the oldest snapshot is the baseline for what is stored
+	seen := make(map[string]bool)
+	remember := func(files map[string]*models.FileNode) {
+		for _, node := range files {
+			seen["file:"+node.Hash] = true
+			for _, chunk := range node.Chunks {
+				seen[chunk] = true
+			}
+		}
+	}
+
+	oldest := len(snapshots) - 1
+	remember(snapshots[oldest].Tree.Files)
+	changed := make(map[string]bool)
+	timeSaved := make(map[string]float64)
+
+	for i := oldest - 1; i >= 0; i-- {
+		snap, prev := snapshots[i], snapshots[i+1].Tree.Files
+		dirNew := make(map[string]int64)
+		var snapNew int64
+
+		for relPath, node := range snap.Tree.Files {
+			if node.IsDir {
+				continue
+			}
+			if old := prev[relPath]; old != nil && !old.IsDir && old.Hash == node.Hash {
+				continue
+			}
+			changed[relPath] = true
+
+			var newBytes int64
+			switch {
+			case len(node.Chunks) > 0:
+				var newChunks int
+				for _, chunk := range node.Chunks {
+					if !seen[chunk] {
+						newChunks++
+					}
+				}
+				newBytes = node.Size * int64(newChunks) / int64(len(node.Chunks))
+			case !seen["file:"+node.Hash]:
+				newBytes = node.Size
+			}
+			snapNew += newBytes
+
+			for parent := filepath.Dir(relPath); parent != "." && parent != string(filepath.Separator); parent = filepath.Dir(parent) {
+				d := dir(parent)
+				d.Changes++
+				d.ChangedBytes += node.Size
+				d.NewBytes += newBytes
+				dirNew[parent] += newBytes
+			}
+		}
+
+		// Files within one snapshot are deduplicated against each other too,
+		// but in no particular order; count them against earlier snapshots only
+		remember(snap.Tree.Files)
+
+		if snapNew > 0 {
+			for path, newBytes := range dirNew {
+				timeSaved[path] += float64(snap.Stats.Duration) * float64(newBytes) / float64(snapNew)
+			}
+		}
+	}
+
+	for relPath, node := range snapshots[0].Tree.Files {
+		if node.IsDir {
+			continue
+		}
+		for parent := filepath.Dir(relPath); parent != "." && parent != string(filepath.Separator); parent = filepath.Dir(parent) {
+			d := dir(parent)
+			d.Size += node.Size
+			d.Files++
+			if !changed[relPath] {
+				d.Stable++
+			}
+		}
+	}
+
+	candidate := make(map[string]bool)
+	for path, d := range dirs {
+		d.SpaceSaved = d.NewBytes / int64(d.Snapshots)
+		d.TimeSaved = time.Duration(timeSaved[path] / float64(d.Snapshots)).Round(time.Millisecond)
+		if d.Files > 0 && d.SpaceSaved >= opts.MinBytes && d.Churn() >= opts.MinChurn && d.Dedup() <= opts.MaxDedup {
+			candidate[path] = true
+		}
+	}
+
+	// Prefer the outermost directory with nothing worth keeping in it, and
+	// otherwise the innermost one, so a home directory holding a cache is not
+	// suggested in place of the cache
+	var suggestions []ChurnDir
+	for path := range candidate {
+		if hasAncestor(path, func(p string) bool { return candidate[p] && dirs[p].Stable == 0 }) {
+			continue
+		}
+		if dirs[path].Stable > 0 && hasDescendant(path, candidate) {
+			continue
+		}
+		suggestions = append(suggestions, *dirs[path])
+	}
+	return suggestions
+}
+
+// hasAncestor reports whether match holds for a directory above path
+func hasAncestor(path string, match func(string) bool) bool {
+	for parent := filepath.Dir(path); parent != "." && parent != string(filepath.Separator); parent = filepath.Dir(parent) {
+		if match(parent) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasDescendant reports whether any directory in set lies below path
+func hasDescendant(path string, set map[string]bool) bool {
+	prefix := path + string(filepath.Separator)
+	for p := range set {
+		if strings.HasPrefix(p, prefix) {
+			return true
+		}
+	}
+	return false
+}
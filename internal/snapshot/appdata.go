@@ -0,0 +1,85 @@
+package snapshot
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/snapsync/snapsync/internal/appdata"
+	"github.com/snapsync/snapsync/pkg/models"
+)
+
+// SetAppData applies app data presets to new snapshots: their caches and
+// lock files are left out, and files a running application rewrites are
+// read from steady copies
+// frozen tells that the source is a filesystem snapshot, whose files
+// cannot change while they are read, so nothing needs copying.
+func (m *Manager) SetAppData(rules *appdata.Rules, frozen bool) {
+	m.appData = rules
+	m.frozen = frozen
+	m.scanner.SetAppData(rules)
+}
+
+// appCaptures holds steady copies of files of running applications
+type appCaptures struct {
+	paths map[string]string // Tree path -> copy to read instead of the live file
+	dir   string
+}
+
+// captureAppData copies the changed files that app data presets mark for
+// capture, if their application is running
+// A file that keeps changing is left out and reported as failed rather
+// than stored half-written. Databases are left to captureDatabases.
+func (m *Manager) captureAppData(tree *models.FileTree, filesToProcess map[string]*models.FileNode, databases *dbCaptures) (*appCaptures, error) {
+	ac := &appCaptures{paths: make(map[string]string)}
+	if m.appData == nil || m.frozen || m.bucket != nil {
+		return ac, nil
+	}
+
+	running := make(map[string]bool) // By store
+	for relPath, node := range filesToProcess {
+		if node.IsDir || !node.Mode.IsRegular() || node.SQLite {
+			continue
+		}
+		if _, captured := databases.paths[relPath]; captured {
+			continue
+		}
+		match := m.appData.Match(node.Path)
+		if match == nil || match.Action != appdata.Capture {
+			continue
+		}
+		live, checked := running[match.Store]
+		if !checked {
+			live = match.Preset.Running(match.Store)
+			running[match.Store] = live
+		}
+		if !live {
+			continue
+		}
+
+		if ac.dir == "" {
+			dir, err := os.MkdirTemp(m.tempDir, "snapsync-app-")
+			if err != nil {
+				return nil, fmt.Errorf("failed to create app data copy directory: %w", err)
+			}
+			ac.dir = dir
+		}
+		copyPath := filepath.Join(ac.dir, strconv.Itoa(len(ac.paths)))
+		if err := appdata.Copy(node.Path, copyPath); err != nil {
+			dropNode(tree, filesToProcess, relPath)
+			m.failed = append(m.failed, FailedFile{Path: relPath, Err: fmt.Errorf("%s is running: %w", match.Name, err)})
+			continue
+		}
+		ac.paths[relPath] = copyPath
+	}
+
+	return ac, nil
+}
+
+// Close removes all copies
+func (ac *appCaptures) Close() {
+	if ac.dir != "" {
+		os.RemoveAll(ac.dir)
+	}
+}
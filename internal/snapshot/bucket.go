@@ -0,0 +1,210 @@
+package snapshot
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/snapsync/snapsync/internal/backend"
+	"github.com/snapsync/snapsync/pkg/models"
+)
+
+// bucketSource is a bucket being backed up
+type bucketSource struct {
+	b   backend.Backend
+	url string // Recorded as the snapshot's source, e.g. s3://bucket/prefix
+}
+
+// CreateFromBucket creates a snapshot of the objects in a bucket, listed and
+// read through b, with url recorded as the source
+// Each object is a file at its key, under directories made of the key's
+// slashes. Change detection uses the listing alone: an object whose ETag
+// and size match the parent's object at the same key, or whose content MD5
+// matches any of the parent's objects, is carried over without being
+// downloaded. Only new and changed objects are read and hashed.
+func (m *Manager) CreateFromBucket(b backend.Backend, url, description, parentID string) (*models.Snapshot, error) {
+	if m.metadataOnly {
+		return nil, fmt.Errorf("metadata-only snapshots of buckets are not supported")
+	}
+
+	m.bucket = &bucketSource{b: b, url: strings.TrimSuffix(url, "/")}
+	defer func() { m.bucket = nil }()
+
+	return m.create(description, parentID, m.scanBucket)
+}
+
+// scanBucket builds the tree of the bucket's objects from its listing,
+// taking the content hashes of unchanged objects from parent
+// Keys that are no file path, such as ones with empty or .. elements, and
+// keys that are both an object and a folder of others are left out and
+// reported as failed.
+func (m *Manager) scanBucket(parent *models.FileTree) (*models.FileTree, error) {
+	src := m.bucket
+	infos, err := backend.ListInfo(src.b, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s: %w", src.url, err)
+	}
+
+	// A copied or moved object keeps its content MD5 as its ETag
+	var previous map[string]*models.FileNode
+	byETag := make(map[string]*models.FileNode)
+	if parent != nil {
+		previous = parent.Files
+		for _, node := range parent.Files {
+			if !node.IsDir && node.ETag != "" && node.Hash != "" {
+				byETag[node.ETag] = node
+			}
+		}
+	}
+
+	root := &models.FileNode{
+		Path:  src.url,
+		Name:  path.Base(src.url),
+		IsDir: true,
+		Mode:  os.ModeDir | 0755,
+	}
+	tree := &models.FileTree{
+		Root:  root,
+		Files: map[string]*models.FileNode{".": {Path: root.Path, Name: root.Name, IsDir: true, Mode: root.Mode}},
+	}
+	tree.DirCount++
+
+	// addDirs adds the directories down to relPath and reports false if
+	// one of them is an object
+	addDirs := func(relPath string) bool {
+		for dir := relPath; dir != "."; dir = filepath.Dir(dir) {
+			if node, ok := tree.Files[dir]; ok {
+				if !node.IsDir {
+					return false
+				}
+				break
+			}
+			tree.Files[dir] = &models.FileNode{
+				Path:  src.url + "/" + filepath.ToSlash(dir),
+				Name:  filepath.Base(dir),
+				IsDir: true,
+				Mode:  os.ModeDir | 0755,
+			}
+			tree.DirCount++
+		}
+		return true
+	}
+	fail := func(key, reason string) {
+		m.failed = append(m.failed, FailedFile{Path: key, Err: fmt.Errorf("object key %s", reason)})
+	}
+
+	for _, info := range infos {
+		// Zero-byte keys ending in a slash stand for folders
+		key := strings.TrimSuffix(info.Key, "/")
+		folder := key != info.Key
+		if folder && key == "" {
+			continue // The prefix itself
+		}
+		clean := path.Clean(key)
+		if key == "" || clean != key || clean == ".." || strings.HasPrefix(clean, "../") {
+			fail(info.Key, "is not a valid file path")
+			continue
+		}
+
+		relPath := filepath.FromSlash(key)
+		if m.scanner.Excluded(relPath) {
+			continue
+		}
+		if folder {
+			if !addDirs(relPath) {
+				fail(info.Key, "is inside an object of the same name")
+			}
+			continue
+		}
+		if existing, ok := tree.Files[relPath]; ok && existing.IsDir {
+			fail(info.Key, "is also a folder of other objects")
+			continue
+		}
+		if !addDirs(filepath.Dir(relPath)) {
+			fail(info.Key, "is inside an object of the same name")
+			continue
+		}
+
+		node := &models.FileNode{
+			Path:    src.url + "/" + key,
+			Name:    path.Base(key),
+			Mode:    0644,
+			Size:    info.Size,
+			ModTime: info.ModTime,
+			ETag:    info.ETag,
+		}
+		if prev := previous[relPath]; prev != nil && !prev.IsDir && info.ETag != "" && prev.ETag == info.ETag && prev.Size == info.Size {
+			node.Hash = prev.Hash
+		} else if md5 := strings.TrimPrefix(info.Checksum, "md5:"); md5 != info.Checksum {
+			if prev := byETag[md5]; prev != nil && prev.Size == info.Size {
+				node.Hash = prev.Hash
+			}
+		}
+
+		tree.Files[relPath] = node
+		tree.FileCount++
+		tree.TotalSize += node.Size
+	}
+	return tree, nil
+}
+
+// storeObject stores an object of the bucket being backed up, downloading
+// it unless the repository already holds its content
+// The object is hashed as it is chunked, and described as read, in case it
+// was replaced since the listing.
+func (m *Manager) storeObject(relPath string, node *models.FileNode) (*fileResult, error) {
+	result := &fileResult{}
+	if m.reuseIndexed(node, result) {
+		return result, nil
+	}
+
+	body, err := m.bucket.b.Get(filepath.ToSlash(relPath))
+	if err != nil {
+		return nil, fmt.Errorf("failed to download %s: %w", relPath, err)
+	}
+	defer body.Close()
+
+	hasher := sha256.New()
+	reader := io.TeeReader(body, hasher)
+	listedSize := node.Size
+
+	// Tiny objects live in the tree rather than in objects of their own
+	if node.Size > 0 && node.Size <= InlineThreshold {
+		data, err := io.ReadAll(io.LimitReader(reader, InlineThreshold+1))
+		if err != nil {
+			return nil, fmt.Errorf("failed to download %s: %w", relPath, err)
+		}
+		if len(data) <= InlineThreshold {
+			result.sizeDelta = int64(len(data)) - listedSize
+			return result, m.inlineData(node, data)
+		}
+		reader = io.MultiReader(bytes.NewReader(data), reader)
+	}
+
+	split := func(fn func(*models.Chunk) error) error {
+		var size int64
+		err := m.chunker.ChunkStream(reader, func(chunk *models.Chunk) error {
+			size += chunk.Size
+			return fn(chunk)
+		})
+		if err != nil {
+			return fmt.Errorf("failed to chunk %s: %w", relPath, err)
+		}
+
+		node.Size = size
+		node.Hash = hex.EncodeToString(hasher.Sum(nil))
+		result.sizeDelta = size - listedSize
+		return nil
+	}
+
+	if err := m.storeChunks(node, split, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
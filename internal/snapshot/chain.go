@@ -0,0 +1,190 @@
+package snapshot
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/snapsync/snapsync/pkg/models"
+)
+
+// ChainReport is the result of verifying the snapshot chain
+type ChainReport struct {
+	HeadID    string   // Newest snapshot
+	HeadHash  string   // Its chain hash
+	Checked   int      // Chained snapshots whose hash and link were checked
+	Unchained int      // Snapshots created before chaining was introduced
+	Problems  []string // Broken links, altered records and unreadable records
+}
+
+// OK reports whether the chain verified without problems
+func (r *ChainReport) OK() bool {
+	return len(r.Problems) == 0
+}
+
+// chainHash hashes a record as stored
+// The retention lock, tier and degradation are left out since they may be
+// changed later, and so is the hash itself. The record commits to its tree through TreeHash.
+func chainHash(record *models.Snapshot) (string, error) {
+	canonical := *record
+	canonical.RetainUntil = nil
+	canonical.Tier = ""
+	canonical.Degraded = nil
+	canonical.ChainHash = ""
+
+	data, err := json.Marshal(&canonical)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// linkChain points a new snapshot at the newest existing one
+func (m *Manager) linkChain(snapshot *models.Snapshot) error {
+	records, _, err := m.readRecords()
+	if err != nil {
+		return err
+	}
+	if len(records) == 0 {
+		return nil
+	}
+
+	prev := records[len(records)-1]
+	hash, err := chainHash(prev)
+	if err != nil {
+		return err
+	}
+	snapshot.ChainPrev = prev.ID
+	snapshot.ChainPrevHash = hash
+	snapshot.ChainPrevExpires = prev.ExpiresAt
+	return nil
+}
+
+// readRecords reads all snapshot records, oldest first, along with the IDs
+// of records that could not be parsed
+func (m *Manager) readRecords() ([]*models.Snapshot, []string, error) {
+	entries, err := os.ReadDir(filepath.Join(m.repoPath, "snapshots"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil, nil
+		}
+		return nil, nil, err
+	}
+
+	var records []*models.Snapshot
+	var bad []string
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".json" {
+			continue
+		}
+
+		id := entry.Name()[:len(entry.Name())-5]
+		record, err := m.readRecord(id)
+		if err != nil {
+			bad = append(bad, id)
+			continue
+		}
+		records = append(records, record)
+	}
+
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].Timestamp.Before(records[j].Timestamp)
+	})
+
+	return records, bad, nil
+}
+
+// VerifyChain checks that every chained record is unaltered and links to
+// the snapshot before it, so removed or modified history shows up
+func (m *Manager) VerifyChain() (*ChainReport, error) {
+	records, bad, err := m.readRecords()
+	if err != nil {
+		return nil, err
+	}
+
+	report := &ChainReport{}
+	now := time.Now()
+	for _, id := range bad {
+		report.Problems = append(report.Problems, fmt.Sprintf("snapshot %s: unreadable record", id))
+	}
+
+	hashes := make(map[string]string, len(records))
+	for i, record := range records {
+		hash, err := chainHash(record)
+		if err != nil {
+			return nil, err
+		}
+		hashes[record.ID] = hash
+		report.HeadID = record.ID
+		report.HeadHash = hash
+
+		if record.ChainHash == "" {
+			report.Unchained++
+			continue
+		}
+		report.Checked++
+
+		if record.ChainHash != hash {
+			report.Problems = append(report.Problems,
+				fmt.Sprintf("snapshot %s: record was altered", record.ID))
+		}
+
+		if record.ChainPrev == "" {
+			if i > 0 {
+				report.Problems = append(report.Problems,
+					fmt.Sprintf("snapshot %s: starts a new chain after %s", record.ID, records[i-1].ID))
+			}
+			continue
+		}
+
+		prevHash, ok := hashes[record.ChainPrev]
+		switch {
+		case !ok && record.ChainPrevExpires != nil && !now.Before(*record.ChainPrevExpires):
+			// The previous snapshot expired and was removed as intended
+		case !ok:
+			report.Problems = append(report.Problems,
+				fmt.Sprintf("snapshot %s: previous snapshot %s is missing", record.ID, record.ChainPrev))
+		case prevHash != record.ChainPrevHash:
+			report.Problems = append(report.Problems,
+				fmt.Sprintf("snapshot %s: previous snapshot %s does not match", record.ID, record.ChainPrev))
+		case records[i-1].ID != record.ChainPrev:
+			report.Problems = append(report.Problems,
+				fmt.Sprintf("snapshot %s: %s was inserted before it", record.ID, records[i-1].ID))
+		}
+	}
+
+	return report, nil
+}
+
+// ChainHead returns the newest snapshot and its chain hash
+func (m *Manager) ChainHead() (string, string, error) {
+	records, _, err := m.readRecords()
+	if err != nil {
+		return "", "", err
+	}
+	if len(records) == 0 {
+		return "", "", fmt.Errorf("repository has no snapshots")
+	}
+
+	head := records[len(records)-1]
+	hash, err := chainHash(head)
+	if err != nil {
+		return "", "", err
+	}
+	return head.ID, hash, nil
+}
+
+// ChainHash returns the chain hash of a snapshot record
+func (m *Manager) ChainHash(id string) (string, error) {
+	record, err := m.readRecord(id)
+	if err != nil {
+		return "", err
+	}
+	return chainHash(record)
+}
@@ -0,0 +1,94 @@
+package snapshot
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// CheckProblem is damage found by a repository check
+type CheckProblem struct {
+	Kind       string `json:"kind"` // "structure", "snapshot", "tree", "missing", "corrupt" or "remote"
+	SnapshotID string `json:"snapshot,omitempty"`
+	Object     string `json:"object,omitempty"`
+	Message    string `json:"message"`
+}
+
+// CheckResult summarizes a snapshot check
+type CheckResult struct {
+	Snapshots  int
+	Referenced map[string]bool // Objects referenced by the readable snapshots
+	Incomplete bool            // Some snapshot could not be walked, so Referenced is partial
+	Problems   []CheckProblem
+}
+
+// Check reads every snapshot record, walks its tree and confirms that every
+// object it references is in the store
+// Unlike the other readers it keeps going after damage, so one broken
+// snapshot does not hide problems in the rest.
+func (m *Manager) Check() (*CheckResult, error) {
+	entries, err := os.ReadDir(filepath.Join(m.repoPath, "snapshots"))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	result := &CheckResult{Referenced: make(map[string]bool)}
+	reported := make(map[string]bool)
+
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".json" {
+			continue
+		}
+		id := entry.Name()[:len(entry.Name())-5]
+		result.Snapshots++
+
+		record, err := m.readRecord(id)
+		if err != nil {
+			result.Problems = append(result.Problems, CheckProblem{
+				Kind: "snapshot", SnapshotID: id, Message: err.Error(),
+			})
+			result.Incomplete = true
+			continue
+		}
+
+		// Loading the tree decodes its directory objects
+		snap, err := m.Get(id)
+		if err != nil {
+			result.Problems = append(result.Problems, CheckProblem{
+				Kind: "tree", SnapshotID: id, Object: record.TreeHash, Message: err.Error(),
+			})
+			result.Incomplete = true
+			continue
+		}
+
+		refs, err := m.References(snap)
+		if err != nil {
+			result.Problems = append(result.Problems, CheckProblem{
+				Kind: "tree", SnapshotID: id, Object: snap.TreeHash, Message: err.Error(),
+			})
+			result.Incomplete = true
+			continue
+		}
+
+		hashes := make([]string, 0, len(refs))
+		for hash := range refs {
+			hashes = append(hashes, hash)
+			result.Referenced[hash] = true
+		}
+		sort.Strings(hashes)
+
+		present := m.cas.HasMany(hashes)
+		for _, hash := range hashes {
+			// Shared objects are reported once, against the first snapshot
+			if present[hash] || reported[hash] {
+				continue
+			}
+			reported[hash] = true
+			result.Problems = append(result.Problems, CheckProblem{
+				Kind: "missing", SnapshotID: id, Object: hash, Message: "referenced object not found",
+			})
+		}
+	}
+
+	return result, nil
+}
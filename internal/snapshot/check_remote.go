@@ -0,0 +1,145 @@
+package snapshot
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/snapsync/snapsync/internal/backend"
+)
+
+// RemoteCheck is the result of comparing a backend with the repository
+type RemoteCheck struct {
+	Records     int // Local snapshots the backend has a record of
+	NotUploaded int // Local snapshots the backend has no record of
+	Objects     int // Objects compared
+	Problems    []CheckProblem
+}
+
+// CheckRemote compares a backend's listing with the repository
+// Every object referenced by a snapshot whose record was uploaded must be
+// listed with the size, and the checksum where the backend reports one, of
+// the local copy. Records themselves are not compared, since locks and tiers
+// change them locally after the upload. Objects that lifecycle
+// rules or anything else deleted or changed behind SnapSync's back are
+// reported as problems of kind "remote", naming the backend.
+func (m *Manager) CheckRemote(remote backend.Backend, name string) (*RemoteCheck, error) {
+	recordKeys, err := remote.List("snapshots/")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list snapshot records on %s: %w", name, err)
+	}
+	remoteRecords := make(map[string]bool, len(recordKeys))
+	for _, key := range recordKeys {
+		if strings.HasSuffix(key, ".json") {
+			remoteRecords[strings.TrimSuffix(path.Base(key), ".json")] = true
+		}
+	}
+
+	entries, err := os.ReadDir(filepath.Join(m.repoPath, "snapshots"))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	result := &RemoteCheck{}
+	owner := make(map[string]string) // Object hash to the first snapshot using it
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".json" {
+			continue
+		}
+		id := strings.TrimSuffix(entry.Name(), ".json")
+
+		if !remoteRecords[id] {
+			result.NotUploaded++
+			continue
+		}
+		result.Records++
+
+		// Damaged local snapshots are reported by Check
+		snap, err := m.Get(id)
+		if err != nil {
+			continue
+		}
+		refs, err := m.References(snap)
+		if err != nil {
+			continue
+		}
+		for hash := range refs {
+			if _, ok := owner[hash]; !ok {
+				owner[hash] = id
+			}
+		}
+	}
+
+	objectInfos, err := backend.ListInfo(remote, backend.ObjectsPrefix)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list objects on %s: %w", name, err)
+	}
+	remoteObjects := make(map[string]backend.ObjectInfo, len(objectInfos))
+	for _, info := range objectInfos {
+		remoteObjects[info.Key] = info
+	}
+
+	hashes := make([]string, 0, len(owner))
+	for hash := range owner {
+		hashes = append(hashes, hash)
+	}
+	sort.Strings(hashes)
+
+	for _, hash := range hashes {
+		result.Objects++
+		info, ok := remoteObjects[backend.ObjectKey(hash)]
+		if !ok {
+			result.Problems = append(result.Problems, CheckProblem{
+				Kind: "remote", SnapshotID: owner[hash], Object: hash, Message: "missing from " + name,
+			})
+			continue
+		}
+
+		// Objects missing locally are reported by Check
+		data, err := m.cas.GetChunk(hash)
+		if err != nil {
+			continue
+		}
+		if diff := compareRemote(info, data); diff != "" {
+			result.Problems = append(result.Problems, CheckProblem{
+				Kind: "remote", SnapshotID: owner[hash], Object: hash, Message: fmt.Sprintf("copy on %s %s", name, diff),
+			})
+		}
+	}
+
+	return result, nil
+}
+
+// compareRemote describes how a listed object differs from the local bytes,
+// or returns "" if it matches as far as the listing shows
+func compareRemote(info backend.ObjectInfo, local []byte) string {
+	if info.Size != int64(len(local)) {
+		return fmt.Sprintf("is %d bytes, expected %d", info.Size, len(local))
+	}
+
+	kind, sum, ok := strings.Cut(info.Checksum, ":")
+	if !ok {
+		return ""
+	}
+	var want string
+	switch kind {
+	case "md5":
+		h := md5.Sum(local)
+		want = hex.EncodeToString(h[:])
+	case "sha1":
+		h := sha1.Sum(local)
+		want = hex.EncodeToString(h[:])
+	default:
+		return ""
+	}
+	if !strings.EqualFold(sum, want) {
+		return fmt.Sprintf("has %s %s, expected %s", kind, sum, want)
+	}
+	return ""
+}
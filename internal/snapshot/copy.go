@@ -0,0 +1,398 @@
+package snapshot
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/snapsync/snapsync/internal/store"
+	"github.com/snapsync/snapsync/pkg/models"
+)
+
+// copyMapName maps the chunks of the copy source to the chunks they were
+// stored as in the destination, kept between runs in its index directory
+const copyMapName = "copy-map"
+
+// copyMapLabel derives the destination key fingerprint kept in the map
+const copyMapLabel = "snapsync-copy-map"
+
+// CopyProgress reports one copied snapshot
+type CopyProgress struct {
+	Snapshot  string
+	Index     int // 1-based position, oldest first
+	Total     int
+	Converted int  // Source chunks stored anew for this snapshot
+	Mapped    int  // Source chunks an earlier copy already stored
+	Copied    bool // False if the destination already had it
+}
+
+// CopyResult summarizes a CopyFrom run
+type CopyResult struct {
+	Snapshots int   // Snapshots copied by this run
+	Skipped   int   // Snapshots the destination already had
+	Converted int   // Source chunks decoded and stored anew
+	Mapped    int   // Source chunks found stored by an earlier copy
+	NewChunks int   // Destination chunks written
+	Bytes     int64 // Chunk data written
+}
+
+// CopyFrom copies the snapshots of src that m lacks into m, oldest first,
+// re-encoding their data with m's chunker, compression and key
+// sourceID is the identity of src; the chunk map of an earlier copy from
+// another repository is refused. Each source chunk is decoded, split again
+// and stored, and the destination chunks it became are appended to the
+// chunk map, so a later run, for example one copying the snapshots added
+// since, neither reads nor re-encrypts it again. A mapped chunk is only
+// taken once its destination chunks decode to the source chunk; otherwise
+// it is converted anew. Snapshots keep their IDs, times, tags and locks and
+// are linked into m's chain, so they must be newer than m's own snapshots.
+func (m *Manager) CopyFrom(src *Manager, sourceID string, onSnapshot func(CopyProgress)) (*CopyResult, error) {
+	records, bad, err := src.readRecords()
+	if err != nil {
+		return nil, err
+	}
+	if len(bad) > 0 {
+		return nil, fmt.Errorf("unreadable snapshot records in source: %v (run snapsync repair first)", bad)
+	}
+	existing, _, err := m.readRecords()
+	if err != nil {
+		return nil, err
+	}
+	have := make(map[string]bool, len(existing))
+	for _, record := range existing {
+		have[record.ID] = true
+	}
+
+	cmap, err := m.openCopyMap(sourceID)
+	if err != nil {
+		return nil, err
+	}
+	defer cmap.Close()
+
+	if m.filter == nil {
+		if m.filter, err = store.LoadBloom(m.repoPath, m.cas); err != nil {
+			return nil, err
+		}
+	}
+	m.pipeline = m.startPipeline(m.parallel)
+	defer func() {
+		m.pipeline.close()
+		m.pipeline = nil
+	}()
+
+	result := &CopyResult{}
+	for i, record := range records {
+		progress := CopyProgress{Snapshot: record.ID, Index: i + 1, Total: len(records)}
+		if have[record.ID] {
+			result.Skipped++
+			if onSnapshot != nil {
+				onSnapshot(progress)
+			}
+			continue
+		}
+
+		// Copies are chained after the destination's newest snapshot
+		if n := len(existing); n > 0 && record.Timestamp.Before(existing[n-1].Timestamp) {
+			return nil, fmt.Errorf("snapshot %s is older than snapshot %s of the destination", record.ID, existing[n-1].ID)
+		}
+
+		snap, err := m.copySnapshot(src, record, cmap, &progress, result)
+		if err != nil {
+			return nil, fmt.Errorf("snapshot %s: %w", record.ID, err)
+		}
+		existing = append(existing, snap)
+
+		result.Snapshots++
+		progress.Copied = true
+		if onSnapshot != nil {
+			onSnapshot(progress)
+		}
+	}
+
+	if err := m.index.Save(); err != nil {
+		return nil, fmt.Errorf("failed to save file index: %w", err)
+	}
+	if err := m.filter.Save(); err != nil {
+		return nil, fmt.Errorf("failed to save chunk filter: %w", err)
+	}
+	return result, nil
+}
+
+// copySnapshot stores the data of one source snapshot in m and saves it
+func (m *Manager) copySnapshot(src *Manager, record *models.Snapshot, cmap *copyMap, progress *CopyProgress, result *CopyResult) (*models.Snapshot, error) {
+	if record.Encrypted && src.encryptor == nil {
+		return nil, fmt.Errorf("source is encrypted but no key was given")
+	}
+	snap, err := src.Get(record.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read snapshot: %w", err)
+	}
+	if snap.Tree == nil || (snap.EncryptedNames && src.encryptor == nil) {
+		return nil, fmt.Errorf("snapshot has no readable tree")
+	}
+
+	for _, node := range snap.Tree.Files {
+		if node.Inline != nil {
+			data := node.Inline
+			if snap.Encrypted {
+				if data, err = src.encryptor.Decrypt(data); err != nil {
+					return nil, fmt.Errorf("failed to decrypt %s: %w", node.Path, err)
+				}
+			}
+			if err := m.inlineData(node, data); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if len(node.Chunks) == 0 {
+			continue
+		}
+
+		chunks := make([]string, 0, len(node.Chunks))
+		for _, hash := range node.Chunks {
+			copied, err := m.copyChunk(src, hash, snap, cmap, progress, result)
+			if err != nil {
+				return nil, err
+			}
+			chunks = append(chunks, copied...)
+		}
+		node.Chunks = chunks
+		if node.Hash != "" {
+			m.index.Add(node.ContentHash(), chunks)
+		}
+	}
+
+	// The copy is a record of the destination: its own encoding, tree
+	// objects and place in the chain
+	if snap.Parent != "" && !m.hasRecord(snap.Parent) {
+		snap.Parent = ""
+	}
+	snap.Compressed = m.compressor != nil
+	snap.Encrypted = m.encryptor != nil
+	snap.EncryptedNames = m.encryptNames && m.encryptor != nil
+	snap.TreeHash = ""
+	snap.ChainHash = ""
+	snap.ChainPrev = ""
+	snap.ChainPrevHash = ""
+	snap.ChainPrevExpires = nil
+	if err := m.linkChain(snap); err != nil {
+		return nil, fmt.Errorf("failed to link snapshot chain: %w", err)
+	}
+	if err := m.saveSnapshot(snap); err != nil {
+		return nil, fmt.Errorf("failed to save snapshot: %w", err)
+	}
+
+	if m.indexPaths {
+		if err := m.updatePathIndex(snap); err != nil {
+			m.dropPathIndex()
+		}
+	}
+	return snap, nil
+}
+
+// copyChunk returns the destination chunks holding a source chunk of snap,
+// storing them unless the chunk map names chunks that still hold it
+func (m *Manager) copyChunk(src *Manager, hash string, snap *models.Snapshot, cmap *copyMap, progress *CopyProgress, result *CopyResult) ([]string, error) {
+	if chunks, ok := cmap.known[hash]; ok {
+		return chunks, nil
+	}
+	if chunks, ok := cmap.listed[hash]; ok && m.holdsChunk(hash, chunks) {
+		cmap.known[hash] = chunks
+		progress.Mapped++
+		result.Mapped++
+		return chunks, nil
+	}
+
+	data, err := src.decodeChunk(hash, snap.Encrypted, snap.Compressed)
+	if err != nil {
+		return nil, err
+	}
+
+	// Split on its own, the chunk always becomes the same destination
+	// chunks, which is what lets the map stand in for it
+	part := &models.FileNode{}
+	var stored fileResult
+	err = m.storeChunks(part, func(fn func(*models.Chunk) error) error {
+		return m.chunker.ChunkStream(bytes.NewReader(data), fn)
+	}, &stored)
+	if err != nil {
+		return nil, err
+	}
+	if err := cmap.Add(hash, part.Chunks); err != nil {
+		return nil, err
+	}
+
+	progress.Converted++
+	result.Converted++
+	result.NewChunks += stored.newChunks
+	result.Bytes += stored.storedSize
+	return part.Chunks, nil
+}
+
+// holdsChunk reports whether chunks are stored and decode, in order, to the
+// data of the source chunk hash
+func (m *Manager) holdsChunk(hash string, chunks []string) bool {
+	h := sha256.New()
+	for _, chunk := range chunks {
+		data, err := m.decodeChunk(chunk, m.encryptor != nil, m.compressor != nil)
+		if err != nil {
+			return false
+		}
+		h.Write(data)
+	}
+	return hex.EncodeToString(h.Sum(nil)) == hash
+}
+
+// decodeChunk reads a stored chunk, decrypts and decompresses it as given
+// and checks it against its hash
+func (m *Manager) decodeChunk(hash string, encrypted, compressed bool) ([]byte, error) {
+	data, err := m.cas.GetChunk(hash)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read chunk %s: %w", hash, err)
+	}
+	if encrypted {
+		if m.encryptor == nil {
+			return nil, fmt.Errorf("chunk %s is encrypted but no key was given", hash)
+		}
+		if data, err = m.encryptor.Decrypt(data); err != nil {
+			return nil, fmt.Errorf("failed to decrypt chunk %s: %w", hash, err)
+		}
+	}
+	if compressed {
+		if m.compressor == nil {
+			return nil, fmt.Errorf("chunk %s is compressed but no compressor was given", hash)
+		}
+		if data, err = m.compressor.Decompress(data); err != nil {
+			return nil, fmt.Errorf("failed to decompress chunk %s: %w", hash, err)
+		}
+	}
+
+	sum := sha256.Sum256(data)
+	if hex.EncodeToString(sum[:]) != hash {
+		return nil, fmt.Errorf("chunk corruption detected: %s", hash)
+	}
+	return data, nil
+}
+
+// hasRecord reports whether the repository has a snapshot record for id
+func (m *Manager) hasRecord(id string) bool {
+	_, err := os.Stat(filepath.Join(m.repoPath, "snapshots", id+".json"))
+	return err == nil
+}
+
+// copyMap is the destination's map from source chunks to the destination
+// chunks they were stored as
+// Its first line names the source repository and the destination key.
+// Entries are only appended; one whose chunks were removed since, for
+// example by prune, is found out when it is checked before use.
+type copyMap struct {
+	listed map[string][]string // Read from the file, not yet checked
+	known  map[string][]string // Stored or checked by this run
+	file   *os.File
+}
+
+// openCopyMap reads the chunk map for copies from sourceID and opens it for
+// appending
+// A map of copies from another repository is refused; one written under
+// another destination key, such as that of a repository that was deleted
+// and created again, is started over.
+func (m *Manager) openCopyMap(sourceID string) (*copyMap, error) {
+	if sourceID == "" {
+		return nil, fmt.Errorf("copy source has no repository ID")
+	}
+	key := "none"
+	if m.encryptor != nil {
+		key = m.encryptor.Fingerprint(copyMapLabel)
+	}
+	header := "source " + sourceID + " key " + key
+	cmap := &copyMap{listed: make(map[string][]string), known: make(map[string][]string)}
+
+	path := filepath.Join(m.repoPath, "index", copyMapName)
+	if f, err := os.Open(path); err == nil {
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(nil, 1<<20)
+		if scanner.Scan() {
+			fields := strings.Fields(scanner.Text())
+			if len(fields) == 4 && fields[0] == "source" && fields[1] != sourceID {
+				f.Close()
+				return nil, fmt.Errorf("%s maps chunks copied from repository %s, not %s", path, fields[1], sourceID)
+			}
+			if scanner.Text() == header {
+				for scanner.Scan() {
+					// A line cut short by a crash maps nothing
+					if hash, chunks, ok := parseCopyEntry(scanner.Text()); ok {
+						cmap.listed[hash] = chunks
+					}
+				}
+			}
+		}
+		err = scanner.Err()
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read chunk map: %w", err)
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read chunk map: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	flags := os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	if len(cmap.listed) == 0 {
+		flags |= os.O_TRUNC
+	}
+	f, err := os.OpenFile(path, flags, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open chunk map: %w", err)
+	}
+	cmap.file = f
+	if len(cmap.listed) == 0 {
+		if _, err := f.WriteString(header + "\n"); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("failed to write chunk map: %w", err)
+		}
+	}
+	return cmap, nil
+}
+
+// parseCopyEntry parses a chunk map line: the source chunk, then the
+// destination chunks separated by commas
+func parseCopyEntry(line string) (string, []string, bool) {
+	hash, list, ok := strings.Cut(line, " ")
+	if !ok || len(hash) != sha256.Size*2 || list == "" {
+		return "", nil, false
+	}
+	chunks := strings.Split(list, ",")
+	for _, chunk := range chunks {
+		if len(chunk) != sha256.Size*2 {
+			return "", nil, false
+		}
+	}
+	return hash, chunks, true
+}
+
+// Add records the destination chunks a source chunk was stored as
+func (c *copyMap) Add(hash string, chunks []string) error {
+	c.known[hash] = chunks
+	if len(chunks) == 0 {
+		return nil
+	}
+	if _, err := c.file.WriteString(hash + " " + strings.Join(chunks, ",") + "\n"); err != nil {
+		return fmt.Errorf("failed to write chunk map: %w", err)
+	}
+	return nil
+}
+
+// Close closes the map file
+func (c *copyMap) Close() {
+	if c.file != nil {
+		c.file.Close()
+		c.file = nil
+	}
+}
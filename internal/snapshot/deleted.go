@@ -0,0 +1,74 @@
+package snapshot
+
+import (
+	"sort"
+	"time"
+
+	"github.com/snapsync/snapsync/pkg/models"
+)
+
+// DeletedFile is a file that older snapshots have but the latest snapshot of
+// the same source does not
+type DeletedFile struct {
+	Path       string // Relative to the backup root
+	Root       string // Source path of the backup
+	SnapshotID string // Newest snapshot that still has the file
+	Timestamp  time.Time
+	Node       *models.FileNode
+}
+
+// Deleted lists the files present in snapshots taken at or after since but
+// missing from the newest snapshot of their source, sorted by path
+// Each entry points at the last snapshot the file was seen in.
+func (m *Manager) Deleted(since time.Time) ([]DeletedFile, error) {
+	snapshots, err := m.List()
+	if err != nil {
+		return nil, err
+	}
+
+	// Snapshots are newest first, so the first one of each source is its latest
+	latest := make(map[string]map[string]*models.FileNode)
+	seen := make(map[string]bool)
+	var deleted []DeletedFile
+
+	for _, snap := range snapshots {
+		if snap.Tree == nil || snap.Tree.Files == nil {
+			continue
+		}
+		root := ""
+		if snap.Tree.Root != nil {
+			root = snap.Tree.Root.Path
+		}
+
+		current, ok := latest[root]
+		if !ok {
+			latest[root] = snap.Tree.Files
+			continue
+		}
+		if snap.Timestamp.Before(since) {
+			continue
+		}
+
+		for relPath, node := range snap.Tree.Files {
+			if node.IsDir || current[relPath] != nil || seen[root+"\x00"+relPath] {
+				continue
+			}
+			seen[root+"\x00"+relPath] = true
+			deleted = append(deleted, DeletedFile{
+				Path:       relPath,
+				Root:       root,
+				SnapshotID: snap.ID,
+				Timestamp:  snap.Timestamp,
+				Node:       node,
+			})
+		}
+	}
+
+	sort.Slice(deleted, func(i, j int) bool {
+		if deleted[i].Root != deleted[j].Root {
+			return deleted[i].Root < deleted[j].Root
+		}
+		return deleted[i].Path < deleted[j].Path
+	})
+	return deleted, nil
+}
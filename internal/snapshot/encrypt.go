@@ -0,0 +1,291 @@
+package snapshot
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/snapsync/snapsync/internal/store"
+	"github.com/snapsync/snapsync/pkg/models"
+)
+
+// encryptStateName is the resume state of an interrupted EncryptFrom,
+// kept in the destination's index directory
+const encryptStateName = "encrypt-repo.json"
+
+// EncryptProgress reports one converted snapshot
+type EncryptProgress struct {
+	Snapshot  string
+	Index     int // 1-based position, oldest first
+	Total     int
+	Chunks    int  // Chunks encrypted for this snapshot
+	Converted bool // False if an earlier run already converted it
+}
+
+// EncryptResult summarizes an EncryptFrom run
+type EncryptResult struct {
+	Snapshots int   // Snapshots converted by this run
+	Skipped   int   // Snapshots an earlier run converted
+	Chunks    int   // Chunks encrypted
+	Bytes     int64 // Encrypted chunk data written
+	Removed   int   // Plaintext tree objects removed, in place only
+}
+
+// encryptState maps each converted snapshot to its chain hash before
+// conversion, so the snapshots after it can be relinked on resume
+type encryptState struct {
+	Source string            `json:"source"`
+	Chain  map[string]string `json:"chain"`
+}
+
+// EncryptFrom converts the snapshots of the unencrypted repository src into
+// encrypted snapshots of m, which must have an encryptor; src may be m
+// itself to convert in place
+// Snapshots are taken oldest first: the chunks each one references are
+// encrypted as stored, keeping their compression, and its record is
+// rewritten with inline data and tree objects encrypted. Every step can be
+// repeated, so an interrupted run resumes where it stopped: chunks that
+// already decrypt and records already marked encrypted are skipped. Chain
+// links between converted records are rewritten to the new hashes, but only
+// where they were intact before. In place, the old tree objects, which hold
+// file names and tiny files in plaintext, are removed at the end.
+func (m *Manager) EncryptFrom(src *Manager, onSnapshot func(EncryptProgress)) (*EncryptResult, error) {
+	if m.encryptor == nil {
+		return nil, fmt.Errorf("destination repository has no key")
+	}
+	inPlace := src.repoPath == m.repoPath
+
+	// Resuming from another working directory must find the same source
+	source, err := filepath.Abs(src.repoPath)
+	if err != nil {
+		return nil, err
+	}
+	state, err := m.loadEncryptState(source)
+	if err != nil {
+		return nil, err
+	}
+
+	records, bad, err := src.readRecords()
+	if err != nil {
+		return nil, err
+	}
+	if len(bad) > 0 {
+		return nil, fmt.Errorf("unreadable snapshot records: %v (run snapsync repair first)", bad)
+	}
+
+	if m.filter == nil {
+		if m.filter, err = store.LoadBloom(m.repoPath, m.cas); err != nil {
+			return nil, err
+		}
+	}
+
+	result := &EncryptResult{}
+	encrypted := make(map[string]bool)
+	for i, record := range records {
+		progress := EncryptProgress{Snapshot: record.ID, Index: i + 1, Total: len(records)}
+
+		if done, err := m.readRecord(record.ID); err == nil && done.Encrypted {
+			result.Skipped++
+			if onSnapshot != nil {
+				onSnapshot(progress)
+			}
+			continue
+		}
+		if record.Encrypted {
+			return nil, fmt.Errorf("snapshot %s is already encrypted", record.ID)
+		}
+
+		oldHash, err := chainHash(record)
+		if err != nil {
+			return nil, err
+		}
+
+		snap, err := src.Get(record.ID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read snapshot %s: %w", record.ID, err)
+		}
+		if snap.Tree == nil {
+			return nil, fmt.Errorf("snapshot %s has no tree", record.ID)
+		}
+
+		for _, node := range snap.Tree.Files {
+			for _, hash := range node.Chunks {
+				if encrypted[hash] {
+					continue
+				}
+				written, err := m.encryptChunk(src, hash)
+				if err != nil {
+					return nil, fmt.Errorf("snapshot %s: %w", record.ID, err)
+				}
+				encrypted[hash] = true
+				if written > 0 {
+					progress.Chunks++
+					result.Chunks++
+					result.Bytes += written
+				}
+			}
+			if node.Inline != nil {
+				if err := m.inlineData(node, node.Inline); err != nil {
+					return nil, err
+				}
+			}
+			if node.Hash != "" && len(node.Chunks) > 0 {
+				m.index.Add(node.ContentHash(), node.Chunks)
+			}
+		}
+
+		if err := m.relink(snap, state, src); err != nil {
+			return nil, err
+		}
+		snap.Encrypted = true
+		snap.EncryptedNames = m.encryptNames
+
+		// Record the old hash first, so a crash right after the rewrite
+		// still lets the next snapshot be relinked
+		state.Chain[record.ID] = oldHash
+		if err := m.saveEncryptState(state); err != nil {
+			return nil, err
+		}
+		if err := m.saveSnapshot(snap); err != nil {
+			return nil, fmt.Errorf("failed to rewrite snapshot %s: %w", record.ID, err)
+		}
+
+		result.Snapshots++
+		progress.Converted = true
+		if onSnapshot != nil {
+			onSnapshot(progress)
+		}
+	}
+
+	if err := m.index.Save(); err != nil {
+		return nil, fmt.Errorf("failed to save file index: %w", err)
+	}
+	if err := m.filter.Save(); err != nil {
+		return nil, fmt.Errorf("failed to save chunk filter: %w", err)
+	}
+
+	// The filename index was written in plaintext and is rebuilt on demand
+	m.dropPathIndex()
+
+	if inPlace {
+		stats, err := m.CollectGarbage(false)
+		if err != nil {
+			return nil, fmt.Errorf("failed to remove plaintext tree objects: %w", err)
+		}
+		result.Removed = len(stats.Swept)
+	}
+
+	if err := os.Remove(m.encryptStatePath()); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	return result, nil
+}
+
+// encryptChunk stores the encryption of a chunk of src in m and returns the
+// bytes written, or 0 if m already holds it encrypted
+func (m *Manager) encryptChunk(src *Manager, hash string) (int64, error) {
+	if m.cas.Has(hash) {
+		if data, err := m.cas.GetChunk(hash); err == nil {
+			if _, err := m.encryptor.Decrypt(data); err == nil {
+				return 0, nil
+			}
+		}
+	}
+
+	data, err := src.cas.GetChunk(hash)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read chunk %s: %w", hash, err)
+	}
+	if data, err = m.encryptor.Encrypt(data); err != nil {
+		return 0, fmt.Errorf("failed to encrypt chunk %s: %w", hash, err)
+	}
+	if err := m.cas.ReplaceChunk(hash, data); err != nil {
+		return 0, err
+	}
+	m.filter.Add(hash)
+	return int64(len(data)), nil
+}
+
+// relink points a snapshot at the converted record of the snapshot before
+// it, if its link to the unconverted record was intact
+func (m *Manager) relink(snap *models.Snapshot, state *encryptState, src *Manager) error {
+	if snap.ChainPrev == "" {
+		return nil
+	}
+
+	prevOld, ok := state.Chain[snap.ChainPrev]
+	if !ok {
+		prev, err := src.readRecord(snap.ChainPrev)
+		if err != nil {
+			// Removed after it expired; the link stays as it was
+			return nil
+		}
+		if prevOld, err = chainHash(prev); err != nil {
+			return err
+		}
+	}
+	if prevOld != snap.ChainPrevHash {
+		return nil
+	}
+
+	prev, err := m.readRecord(snap.ChainPrev)
+	if err != nil || !prev.Encrypted {
+		return nil
+	}
+	hash, err := chainHash(prev)
+	if err != nil {
+		return err
+	}
+	snap.ChainPrevHash = hash
+	return nil
+}
+
+// encryptStatePath returns where the resume state is kept
+func (m *Manager) encryptStatePath() string {
+	return filepath.Join(m.repoPath, "index", encryptStateName)
+}
+
+// loadEncryptState reads the state of an interrupted run from source, or
+// starts a new one
+func (m *Manager) loadEncryptState(source string) (*encryptState, error) {
+	state := &encryptState{Source: source, Chain: make(map[string]string)}
+
+	data, err := os.ReadFile(m.encryptStatePath())
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read conversion state: %w", err)
+	}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, fmt.Errorf("invalid conversion state: %w", err)
+	}
+	if state.Source != source {
+		return nil, fmt.Errorf("an interrupted conversion from %s must be finished first", state.Source)
+	}
+	if state.Chain == nil {
+		state.Chain = make(map[string]string)
+	}
+	return state, nil
+}
+
+// saveEncryptState writes the resume state
+func (m *Manager) saveEncryptState(state *encryptState) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	path := m.encryptStatePath()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(path+".tmp", data, 0644); err != nil {
+		return fmt.Errorf("failed to write conversion state: %w", err)
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return fmt.Errorf("failed to write conversion state: %w", err)
+	}
+	return nil
+}
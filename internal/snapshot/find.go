@@ -0,0 +1,81 @@
+package snapshot
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/snapsync/snapsync/pkg/models"
+)
+
+// FoundFile is a path in a snapshot that matches a find pattern
+type FoundFile struct {
+	SnapshotID string
+	Timestamp  time.Time
+	Root       string // Source path of the backup
+	Path       string // Relative to the backup root
+	Node       *models.FileNode
+}
+
+// Find searches every snapshot for paths matching a glob pattern, newest
+// snapshot first and sorted by path within each
+// A pattern without a path separator matches names, so *.sql finds SQL files
+// in any directory; one with a separator matches the path relative to the
+// backup root.
+func (m *Manager) Find(pattern string, ignoreCase bool) ([]FoundFile, error) {
+	if _, err := filepath.Match(pattern, ""); err != nil {
+		return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+	}
+	if ignoreCase {
+		pattern = strings.ToLower(pattern)
+	}
+	byPath := strings.ContainsRune(pattern, filepath.Separator) || strings.ContainsRune(pattern, '/')
+	pattern = filepath.FromSlash(pattern)
+
+	snapshots, err := m.List()
+	if err != nil {
+		return nil, err
+	}
+
+	var found []FoundFile
+	for _, snap := range snapshots {
+		if snap.Tree == nil || snap.Tree.Files == nil {
+			continue
+		}
+		root := ""
+		if snap.Tree.Root != nil {
+			root = snap.Tree.Root.Path
+		}
+
+		start := len(found)
+		for relPath, node := range snap.Tree.Files {
+			subject := filepath.Base(relPath)
+			if byPath {
+				subject = relPath
+			}
+			if ignoreCase {
+				subject = strings.ToLower(subject)
+			}
+			if matched, _ := filepath.Match(pattern, subject); !matched {
+				continue
+			}
+
+			found = append(found, FoundFile{
+				SnapshotID: snap.ID,
+				Timestamp:  snap.Timestamp,
+				Root:       root,
+				Path:       relPath,
+				Node:       node,
+			})
+		}
+
+		matches := found[start:]
+		sort.Slice(matches, func(i, j int) bool {
+			return matches[i].Path < matches[j].Path
+		})
+	}
+
+	return found, nil
+}
@@ -0,0 +1,235 @@
+package snapshot
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/snapsync/snapsync/internal/interchange"
+	"github.com/snapsync/snapsync/internal/store"
+	"github.com/snapsync/snapsync/pkg/models"
+)
+
+// CreateFromExport creates a snapshot from a SnapSync export
+// source names the snapshot's source, or "" to keep the exported one.
+// Chunks are stored as they were exported rather than split again, so they
+// deduplicate against data from the exporting repository; tiny files are
+// stored inline as in Create. Every chunk is checked against its hash, and
+// every file against its size, before the snapshot is saved.
+func (m *Manager) CreateFromExport(r *interchange.Reader, source, description string) (*models.Snapshot, error) {
+	startTime := time.Now()
+	manifest := r.Manifest
+	if source == "" {
+		source = manifest.Snapshot.Source
+	}
+	if source == "" {
+		return nil, fmt.Errorf("export names no source (use --source)")
+	}
+	if description == "" {
+		description = manifest.Snapshot.Description
+	}
+
+	// Tiny files are inlined, so only the chunks of larger files are stored
+	inline := make(map[string]bool)
+	needed := make(map[string]bool)
+	for _, f := range manifest.Files {
+		if f.Type != interchange.TypeFile || f.Size == 0 {
+			continue
+		}
+		for _, hash := range f.Chunks {
+			if f.Size <= InlineThreshold {
+				inline[hash] = true
+			} else {
+				needed[hash] = true
+			}
+		}
+	}
+
+	var err error
+	if m.filter == nil {
+		if m.filter, err = store.LoadBloom(m.repoPath, m.cas); err != nil {
+			return nil, err
+		}
+	}
+
+	m.progress.SetPhase("storing")
+	sizes := make(map[string]int64)
+	small := make(map[string][]byte)
+	var newChunks int
+	var storedSize int64
+	for {
+		hash, data, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if _, seen := sizes[hash]; seen {
+			continue
+		}
+		sizes[hash] = int64(len(data))
+		if inline[hash] {
+			small[hash] = data
+		}
+		if !needed[hash] || m.existingChunks([]string{hash})[hash] {
+			continue
+		}
+
+		encoded, err := m.encodeChunk(data)
+		if err != nil {
+			return nil, err
+		}
+		stored, err := m.cas.PutChunk(hash, encoded)
+		if err != nil {
+			return nil, fmt.Errorf("storage failed: %w", err)
+		}
+		if stored {
+			newChunks++
+			storedSize += int64(len(encoded))
+		}
+		m.filter.Add(hash)
+	}
+
+	tree := &models.FileTree{
+		Files: make(map[string]*models.FileNode),
+		Root: &models.FileNode{
+			Path:    source,
+			Name:    path.Base(source),
+			IsDir:   true,
+			Mode:    os.ModeDir | 0755,
+			ModTime: startTime,
+		},
+	}
+	tree.Files["."] = tree.Root
+	tree.DirCount = 1
+
+	var totalChunks int
+	for _, f := range manifest.Files {
+		perm, _ := interchange.ParseMode(f.Mode)
+		if f.Path == "." {
+			if f.Type != interchange.TypeDir {
+				return nil, fmt.Errorf("export root is not a directory")
+			}
+			tree.Root.Mode = os.ModeDir | os.FileMode(perm)
+			tree.Root.ModTime = f.ModTime
+			continue
+		}
+
+		relPath := filepath.FromSlash(f.Path)
+		if err := addStreamDirs(tree, relPath, startTime); err != nil {
+			return nil, err
+		}
+		existing := tree.Files[relPath]
+		if f.Type == interchange.TypeDir {
+			dir := &StreamFile{Path: f.Path, Mode: os.FileMode(perm), ModTime: f.ModTime, IsDir: true}
+			if err := addStreamDir(tree, relPath, dir, existing, startTime); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if existing != nil {
+			return nil, fmt.Errorf("path in export is both a file and a directory: %s", f.Path)
+		}
+
+		node, err := m.exportedFile(f, source, sizes, small)
+		if err != nil {
+			return nil, err
+		}
+		tree.Files[relPath] = node
+		tree.FileCount++
+		tree.TotalSize += node.Size
+		totalChunks += len(node.Chunks)
+	}
+
+	m.progress.SetPhase("saving")
+	if err := m.index.Save(); err != nil {
+		return nil, fmt.Errorf("failed to save file index: %w", err)
+	}
+	if err := m.filter.Save(); err != nil {
+		return nil, fmt.Errorf("failed to save chunk filter: %w", err)
+	}
+
+	snapshot, err := m.newSnapshot(tree, description, "")
+	if err != nil {
+		return nil, err
+	}
+	snapshot.Stats = models.SnapshotStats{
+		TotalSize:        tree.TotalSize,
+		StoredSize:       storedSize,
+		ChunkCount:       totalChunks,
+		NewChunks:        newChunks,
+		DeduplicatedSize: tree.TotalSize - storedSize,
+		FilesAdded:       tree.FileCount,
+		Duration:         time.Since(startTime),
+	}
+
+	if err := m.saveSnapshot(snapshot); err != nil {
+		return nil, fmt.Errorf("failed to save snapshot: %w", err)
+	}
+
+	if m.indexPaths {
+		if err := m.updatePathIndex(snapshot); err != nil {
+			m.dropPathIndex()
+		}
+	}
+
+	return snapshot, nil
+}
+
+// exportedFile builds the node of an exported file from its chunks, which
+// must all have arrived and add up to the file's size
+func (m *Manager) exportedFile(f interchange.File, source string, sizes map[string]int64, small map[string][]byte) (*models.FileNode, error) {
+	perm, _ := interchange.ParseMode(f.Mode)
+	node := &models.FileNode{
+		Path:    path.Join(source, f.Path),
+		Name:    path.Base(f.Path),
+		Mode:    os.FileMode(perm),
+		ModTime: f.ModTime,
+		Size:    f.Size,
+		Hash:    f.Hash,
+	}
+
+	var size int64
+	for _, hash := range f.Chunks {
+		chunkSize, ok := sizes[hash]
+		if !ok {
+			return nil, fmt.Errorf("chunk %s of %s is missing from the export", hash, f.Path)
+		}
+		size += chunkSize
+	}
+	if size != f.Size {
+		return nil, fmt.Errorf("chunks of %s add up to %d bytes, not %d", f.Path, size, f.Size)
+	}
+
+	if f.Size == 0 {
+		sum := sha256.Sum256(nil)
+		node.Hash = hex.EncodeToString(sum[:])
+		return node, nil
+	}
+	if f.Size <= InlineThreshold {
+		var data bytes.Buffer
+		for _, hash := range f.Chunks {
+			data.Write(small[hash])
+		}
+		if err := m.inlineData(node, data.Bytes()); err != nil {
+			return nil, err
+		}
+		if f.Hash != "" && node.Hash != f.Hash {
+			return nil, fmt.Errorf("content of %s does not match its hash", f.Path)
+		}
+		return node, nil
+	}
+
+	node.Chunks = f.Chunks
+	if node.Hash != "" && len(node.Chunks) > 0 {
+		m.index.Add(node.ContentHash(), node.Chunks)
+	}
+	return node, nil
+}
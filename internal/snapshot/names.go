@@ -0,0 +1,95 @@
+package snapshot
+
+import (
+	"fmt"
+
+	"github.com/snapsync/snapsync/pkg/models"
+)
+
+// sealNames returns a copy of the record tree with the paths and names of
+// the root and any embedded nodes encrypted
+func (m *Manager) sealNames(tree *models.FileTree) (*models.FileTree, error) {
+	sealed := *tree
+
+	if tree.Root != nil {
+		root, err := m.sealNode(tree.Root)
+		if err != nil {
+			return nil, err
+		}
+		sealed.Root = root
+	}
+
+	if tree.Files != nil {
+		sealed.Files = make(map[string]*models.FileNode, len(tree.Files))
+		for relPath, node := range tree.Files {
+			sealedNode, err := m.sealNode(node)
+			if err != nil {
+				return nil, err
+			}
+			sealedPath, err := m.encryptor.EncryptName(relPath)
+			if err != nil {
+				return nil, err
+			}
+			sealed.Files[sealedPath] = sealedNode
+		}
+	}
+
+	return &sealed, nil
+}
+
+// sealNode returns a copy of node with its path and name encrypted
+func (m *Manager) sealNode(node *models.FileNode) (*models.FileNode, error) {
+	sealed := *node
+
+	var err error
+	if sealed.Path, err = m.encryptor.EncryptName(node.Path); err != nil {
+		return nil, fmt.Errorf("failed to encrypt name: %w", err)
+	}
+	if sealed.Name, err = m.encryptor.EncryptName(node.Name); err != nil {
+		return nil, fmt.Errorf("failed to encrypt name: %w", err)
+	}
+
+	return &sealed, nil
+}
+
+// openNames decrypts in place the names sealed by sealNames
+func (m *Manager) openNames(tree *models.FileTree) error {
+	if tree == nil {
+		return nil
+	}
+
+	if tree.Root != nil {
+		if err := m.openNode(tree.Root); err != nil {
+			return err
+		}
+	}
+
+	if tree.Files != nil {
+		files := make(map[string]*models.FileNode, len(tree.Files))
+		for sealedPath, node := range tree.Files {
+			relPath, err := m.encryptor.DecryptName(sealedPath)
+			if err != nil {
+				return fmt.Errorf("failed to decrypt name: %w", err)
+			}
+			if err := m.openNode(node); err != nil {
+				return err
+			}
+			files[relPath] = node
+		}
+		tree.Files = files
+	}
+
+	return nil
+}
+
+// openNode decrypts the path and name of node in place
+func (m *Manager) openNode(node *models.FileNode) error {
+	var err error
+	if node.Path, err = m.encryptor.DecryptName(node.Path); err != nil {
+		return fmt.Errorf("failed to decrypt name: %w", err)
+	}
+	if node.Name, err = m.encryptor.DecryptName(node.Name); err != nil {
+		return fmt.Errorf("failed to decrypt name: %w", err)
+	}
+	return nil
+}
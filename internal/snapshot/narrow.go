@@ -0,0 +1,111 @@
+package snapshot
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+
+	"github.com/snapsync/snapsync/pkg/models"
+)
+
+// Narrowed is a snapshot cut down to the files matching some patterns, as
+// another repository receiving only those files would store it
+type Narrowed struct {
+	ID      string
+	Record  []byte            // The snapshot record
+	Trees   map[string][]byte // Tree objects of the narrowed tree, by hash
+	Chunks  map[string]bool   // Chunks of the files kept
+	Files   int
+	Size    int64
+	Matched bool // Some file matched; otherwise only the root is kept
+}
+
+// Narrow returns a copy of a snapshot holding only the files and
+// directories matching one of the patterns, with everything below a
+// matching directory and the directories above every match
+// Patterns are matched as by MatchPath. The copy is not chained, since the
+// snapshots before it may not be copied, and records the patterns it was
+// narrowed to. Nothing is written to this repository.
+func (m *Manager) Narrow(id string, patterns []string) (*Narrowed, error) {
+	record, err := m.readRecord(id)
+	if err != nil {
+		return nil, err
+	}
+	if record.EncryptedNames && m.encryptor == nil {
+		return nil, fmt.Errorf("snapshot %s has encrypted names: key required", id)
+	}
+
+	snap, err := m.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	narrowed := &Narrowed{
+		ID:     id,
+		Trees:  make(map[string][]byte),
+		Chunks: make(map[string]bool),
+	}
+
+	// Only directory snapshots have paths to match
+	tree := snap.Tree
+	if tree != nil && tree.Root != nil && tree.Root.IsDir && tree.Files != nil {
+		match := func(relPath string) bool {
+			for _, pattern := range patterns {
+				if MatchPath(pattern, relPath) {
+					return true
+				}
+			}
+			return false
+		}
+
+		keep := map[string]bool{".": true}
+		for relPath := range tree.Files {
+			if relPath == "." || !matchesOrParent(relPath, match) {
+				continue
+			}
+			for p := relPath; p != "."; p = filepath.Dir(p) {
+				keep[p] = true
+			}
+		}
+
+		files := tree.Files
+		tree.Files = make(map[string]*models.FileNode, len(keep))
+		tree.FileCount, tree.DirCount, tree.TotalSize = 0, 0, 0
+		for relPath := range keep {
+			node := files[relPath]
+			tree.Files[relPath] = node
+			if node.IsDir {
+				tree.DirCount++
+				continue
+			}
+			tree.FileCount++
+			tree.TotalSize += node.Size
+			for _, hash := range node.Chunks {
+				narrowed.Chunks[hash] = true
+			}
+		}
+		narrowed.Matched = len(keep) > 1
+		narrowed.Files = tree.FileCount
+		narrowed.Size = tree.TotalSize
+		snap.Stats.TotalSize = tree.TotalSize
+	}
+
+	snap.PartialPaths = patterns
+	snap.ChainPrev, snap.ChainPrevHash, snap.ChainPrevExpires, snap.ChainHash = "", "", nil, ""
+
+	// Tree objects are collected instead of stored
+	copyRecord, err := m.recordOf(snap, func(data []byte) (string, error) {
+		sum := sha256.Sum256(data)
+		hash := hex.EncodeToString(sum[:])
+		narrowed.Trees[hash] = data
+		return hash, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if narrowed.Record, err = json.MarshalIndent(copyRecord, "", "  "); err != nil {
+		return nil, err
+	}
+	return narrowed, nil
+}
@@ -0,0 +1,349 @@
+package snapshot
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/snapsync/snapsync/pkg/models"
+)
+
+// pathIndex lists every path of every snapshot so file history can be
+// answered without loading trees
+// Unchanged versions of a path in consecutive snapshots share one run, which
+// keeps the index small when most files never change.
+type pathIndex struct {
+	Snapshots []indexedSnapshot    `json:"snapshots"` // In backup order
+	Paths     map[string][]pathRun `json:"paths"`
+}
+
+// indexedSnapshot identifies a snapshot by its position in the index
+type indexedSnapshot struct {
+	ID        string    `json:"id"`
+	Timestamp time.Time `json:"timestamp"`
+	Root      string    `json:"root,omitempty"` // Source path of the backup
+}
+
+// pathRun is one version of a path, present from snapshot First to Last
+type pathRun struct {
+	First   int       `json:"first"`
+	Last    int       `json:"last"`
+	IsDir   bool      `json:"is_dir,omitempty"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+	Hash    string    `json:"hash,omitempty"`
+}
+
+// FileVersion is one snapshot's copy of a path
+type FileVersion struct {
+	SnapshotID string
+	Timestamp  time.Time
+	Node       *models.FileNode
+}
+
+// SetPathIndex enables the filename index, kept up to date by each backup
+func (m *Manager) SetPathIndex(enabled bool) {
+	m.indexPaths = enabled
+}
+
+// pathIndexPath returns the index file; encrypted indexes use their own
+// name so they are never mistaken for plaintext
+func (m *Manager) pathIndexPath(sealed bool) string {
+	if sealed {
+		return filepath.Join(m.repoPath, "index", "paths.json.enc")
+	}
+	return filepath.Join(m.repoPath, "index", "paths.json")
+}
+
+// loadPathIndex reads the filename index, returning nil if there is none
+func (m *Manager) loadPathIndex() (*pathIndex, error) {
+	data, err := os.ReadFile(m.pathIndexPath(false))
+	if os.IsNotExist(err) {
+		if data, err = os.ReadFile(m.pathIndexPath(true)); err == nil {
+			if m.encryptor == nil {
+				return nil, fmt.Errorf("filename index is encrypted: key required")
+			}
+			data, err = m.encryptor.Decrypt(data)
+		}
+	}
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read filename index: %w", err)
+	}
+
+	var idx pathIndex
+	if err := json.Unmarshal(data, &idx); err != nil {
+		return nil, fmt.Errorf("invalid filename index: %w", err)
+	}
+	if idx.Paths == nil {
+		idx.Paths = make(map[string][]pathRun)
+	}
+	return &idx, nil
+}
+
+// savePathIndex writes the filename index, encrypted when names are
+func (m *Manager) savePathIndex(idx *pathIndex, sealed bool) error {
+	data, err := json.Marshal(idx)
+	if err != nil {
+		return err
+	}
+	if sealed {
+		if data, err = m.encryptor.Encrypt(data); err != nil {
+			return fmt.Errorf("failed to encrypt filename index: %w", err)
+		}
+	}
+
+	path := m.pathIndexPath(sealed)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create index directory: %w", err)
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to write filename index: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to write filename index: %w", err)
+	}
+
+	// Never leave a plaintext copy next to an encrypted one
+	os.Remove(m.pathIndexPath(!sealed))
+	return nil
+}
+
+// dropPathIndex deletes the filename index so the next backup rebuilds it
+func (m *Manager) dropPathIndex() {
+	os.Remove(m.pathIndexPath(false))
+	os.Remove(m.pathIndexPath(true))
+}
+
+// add appends a snapshot's paths to the index
+func (idx *pathIndex) add(snap *models.Snapshot) {
+	pos := len(idx.Snapshots)
+	entry := indexedSnapshot{ID: snap.ID, Timestamp: snap.Timestamp}
+	if snap.Tree.Root != nil {
+		entry.Root = snap.Tree.Root.Path
+	}
+	idx.Snapshots = append(idx.Snapshots, entry)
+
+	for relPath, node := range snap.Tree.Files {
+		run := pathRun{
+			First:   pos,
+			Last:    pos,
+			IsDir:   node.IsDir,
+			Size:    node.Size,
+			ModTime: node.ModTime.UTC(),
+			Hash:    node.Hash,
+		}
+
+		runs := idx.Paths[relPath]
+		if n := len(runs); n > 0 {
+			last := &runs[n-1]
+			if last.Last == pos-1 && last.IsDir == run.IsDir && last.Size == run.Size &&
+				last.ModTime.Equal(run.ModTime) && last.Hash == run.Hash {
+				last.Last = pos
+				continue
+			}
+		}
+		idx.Paths[relPath] = append(runs, run)
+	}
+}
+
+// covers reports whether the index holds every snapshot in ids
+func (idx *pathIndex) covers(ids map[string]bool) bool {
+	indexed := make(map[string]bool, len(idx.Snapshots))
+	for _, s := range idx.Snapshots {
+		indexed[s.ID] = true
+	}
+	for id := range ids {
+		if !indexed[id] {
+			return false
+		}
+	}
+	return true
+}
+
+// SnapshotIDs returns the IDs of all snapshot records in the repository,
+// including records that cannot be read
+func (m *Manager) SnapshotIDs() (map[string]bool, error) {
+	entries, err := os.ReadDir(filepath.Join(m.repoPath, "snapshots"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return map[string]bool{}, nil
+		}
+		return nil, err
+	}
+
+	ids := make(map[string]bool, len(entries))
+	for _, entry := range entries {
+		if !entry.IsDir() && filepath.Ext(entry.Name()) == ".json" {
+			ids[strings.TrimSuffix(entry.Name(), ".json")] = true
+		}
+	}
+	return ids, nil
+}
+
+// updatePathIndex adds a new snapshot to the filename index, rebuilding the
+// index from all snapshots if it is missing earlier ones
+func (m *Manager) updatePathIndex(snap *models.Snapshot) error {
+	ids, err := m.SnapshotIDs()
+	if err != nil {
+		return err
+	}
+	delete(ids, snap.ID)
+
+	idx, err := m.loadPathIndex()
+	if err != nil || idx == nil || !idx.covers(ids) {
+		return m.RebuildPathIndex()
+	}
+
+	idx.add(snap)
+	return m.savePathIndex(idx, snap.EncryptedNames)
+}
+
+// RebuildPathIndex builds the filename index from every snapshot's tree
+func (m *Manager) RebuildPathIndex() error {
+	records, _, err := m.readRecords()
+	if err != nil {
+		return err
+	}
+
+	idx := &pathIndex{Paths: make(map[string][]pathRun)}
+	sealed := false
+	for _, record := range records {
+		if record.EncryptedNames && m.encryptor == nil {
+			return fmt.Errorf("snapshot %s has encrypted names: key required", record.ID)
+		}
+		sealed = sealed || record.EncryptedNames
+
+		snap, err := m.Get(record.ID)
+		if err != nil {
+			return fmt.Errorf("failed to load snapshot %s: %w", record.ID, err)
+		}
+		if snap.Tree != nil {
+			idx.add(snap)
+		}
+	}
+
+	return m.savePathIndex(idx, sealed)
+}
+
+// removeIndexedPaths drops purged paths from the filename index
+func (m *Manager) removeIndexedPaths(paths []string) error {
+	idx, err := m.loadPathIndex()
+	if err != nil || idx == nil {
+		return err
+	}
+
+	for _, relPath := range paths {
+		delete(idx.Paths, relPath)
+	}
+
+	_, statErr := os.Stat(m.pathIndexPath(true))
+	return m.savePathIndex(idx, statErr == nil)
+}
+
+// Versions returns every snapshot's copy of path, oldest first
+// The path is relative to the backup root or absolute under it. The filename
+// index answers when it covers all snapshots; otherwise each tree is loaded.
+func (m *Manager) Versions(path string) ([]FileVersion, error) {
+	ids, err := m.SnapshotIDs()
+	if err != nil {
+		return nil, err
+	}
+
+	if idx, err := m.loadPathIndex(); err == nil && idx != nil && idx.covers(ids) {
+		return idx.versions(path, ids), nil
+	}
+
+	snapshots, err := m.List()
+	if err != nil {
+		return nil, err
+	}
+
+	var versions []FileVersion
+	for i := len(snapshots) - 1; i >= 0; i-- {
+		snap := snapshots[i]
+		if snap.Tree == nil || snap.Tree.Files == nil {
+			continue
+		}
+
+		relPath, ok := relativeTo(snap.Tree.Root, path)
+		if !ok {
+			continue
+		}
+		if node, ok := snap.Tree.Files[relPath]; ok {
+			versions = append(versions, FileVersion{SnapshotID: snap.ID, Timestamp: snap.Timestamp, Node: node})
+		}
+	}
+
+	return versions, nil
+}
+
+// versions lists the indexed copies of path in snapshots that still exist
+func (idx *pathIndex) versions(path string, existing map[string]bool) []FileVersion {
+	var versions []FileVersion
+
+	// Snapshots of different sources may map an absolute path differently
+	lookups := make(map[string]bool)
+	for _, s := range idx.Snapshots {
+		if relPath, ok := relativeTo(&models.FileNode{Path: s.Root}, path); ok {
+			lookups[relPath] = true
+		}
+	}
+
+	for relPath := range lookups {
+		for _, run := range idx.Paths[relPath] {
+			for pos := run.First; pos <= run.Last && pos < len(idx.Snapshots); pos++ {
+				s := idx.Snapshots[pos]
+				if !existing[s.ID] {
+					continue
+				}
+				if rel, _ := relativeTo(&models.FileNode{Path: s.Root}, path); rel != relPath {
+					continue
+				}
+
+				versions = append(versions, FileVersion{
+					SnapshotID: s.ID,
+					Timestamp:  s.Timestamp,
+					Node: &models.FileNode{
+						Path:    filepath.Join(s.Root, relPath),
+						Name:    filepath.Base(relPath),
+						IsDir:   run.IsDir,
+						Size:    run.Size,
+						ModTime: run.ModTime,
+						Hash:    run.Hash,
+					},
+				})
+			}
+		}
+	}
+
+	sort.Slice(versions, func(i, j int) bool {
+		return versions[i].Timestamp.Before(versions[j].Timestamp)
+	})
+	return versions
+}
+
+// relativeTo converts a path given relative to the backup root, or
+// absolute under it, to a tree key
+func relativeTo(root *models.FileNode, path string) (string, bool) {
+	relPath := filepath.Clean(path)
+	if !filepath.IsAbs(relPath) {
+		return relPath, true
+	}
+	if root == nil || root.Path == "" {
+		return "", false
+	}
+
+	rel, err := filepath.Rel(root.Path, relPath)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	return rel, true
+}
@@ -0,0 +1,219 @@
+package snapshot
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+
+	"github.com/snapsync/snapsync/pkg/models"
+)
+
+const (
+	// pipelineDepth is the number of chunks buffered per worker between
+	// the encode and write stages
+	pipelineDepth = 4
+
+	// maxDefaultFiles caps the default file workers, each of which holds
+	// up to two chunks' worth of read buffers
+	maxDefaultFiles = 4
+
+	// defaultWriters is the default number of chunks written at once,
+	// enough to keep a high-latency store busy without using CPU
+	defaultWriters = 4
+)
+
+// Parallelism sets how many workers each stage of a backup runs
+// Zero leaves a stage at its default.
+type Parallelism struct {
+	Files    int // Files read and chunked at once
+	Encoders int // Chunks compressed and encrypted at once
+	Writers  int // Chunks written to the repository at once
+}
+
+// withDefaults fills in the stages left at zero
+func (p Parallelism) withDefaults() Parallelism {
+	if p.Files <= 0 {
+		p.Files = runtime.NumCPU()
+		if p.Files > maxDefaultFiles {
+			p.Files = maxDefaultFiles
+		}
+	}
+	if p.Encoders <= 0 {
+		p.Encoders = runtime.NumCPU()
+	}
+	if p.Writers <= 0 {
+		p.Writers = defaultWriters
+	}
+	return p
+}
+
+// SetParallelism sets the workers of each backup stage
+func (m *Manager) SetParallelism(p Parallelism) {
+	m.parallel = p
+}
+
+// chunkJob is one new chunk on its way through the encode and write stages
+type chunkJob struct {
+	chunk  *models.Chunk
+	data   []byte // Encoded, until written
+	size   int64  // Encoded size
+	stored bool   // Written by this job rather than found already stored
+	err    error
+	batch  *chunkBatch // The chunks of the job's file
+}
+
+// chunkBatch is the chunks of one file on their way through the pipeline
+type chunkBatch struct {
+	done sync.WaitGroup
+	mu   sync.Mutex
+	err  error // First chunk that failed
+}
+
+// finish marks a job of the batch done
+func (b *chunkBatch) finish(job *chunkJob) {
+	if job.err != nil {
+		b.mu.Lock()
+		if b.err == nil {
+			b.err = job.err
+		}
+		b.mu.Unlock()
+	}
+	b.done.Done()
+}
+
+// failure returns the first error of the batch's finished jobs
+func (b *chunkBatch) failure() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.err
+}
+
+// chunkPipeline compresses, encrypts and writes new chunks with a pool of
+// workers per stage, shared by every file of a backup
+type chunkPipeline struct {
+	m        *Manager
+	encode   chan *chunkJob
+	write    chan *chunkJob
+	encoders sync.WaitGroup
+	writers  sync.WaitGroup
+}
+
+// startPipeline starts the encode and write workers; close stops them
+func (m *Manager) startPipeline(p Parallelism) *chunkPipeline {
+	p = p.withDefaults()
+	pipe := &chunkPipeline{
+		m:      m,
+		encode: make(chan *chunkJob, pipelineDepth*p.Encoders),
+		write:  make(chan *chunkJob, pipelineDepth*p.Writers),
+	}
+
+	pipe.encoders.Add(p.Encoders)
+	for i := 0; i < p.Encoders; i++ {
+		go pipe.encodeWorker()
+	}
+	pipe.writers.Add(p.Writers)
+	for i := 0; i < p.Writers; i++ {
+		go pipe.writeWorker()
+	}
+	return pipe
+}
+
+// close waits for the queued chunks and stops the workers
+func (p *chunkPipeline) close() {
+	close(p.encode)
+	p.encoders.Wait()
+	close(p.write)
+	p.writers.Wait()
+}
+
+// encodeWorker compresses and encrypts chunks for the write stage
+func (p *chunkPipeline) encodeWorker() {
+	defer p.encoders.Done()
+	for job := range p.encode {
+		p.m.progress.SetQueue("encode", len(p.encode))
+		job.data, job.err = p.m.encodeChunk(job.chunk.Data)
+		job.chunk.Data = nil // Only the encoded copy is needed from here on
+		if job.err != nil {
+			job.batch.finish(job)
+			continue
+		}
+		p.write <- job
+	}
+}
+
+// writeWorker stores encoded chunks
+func (p *chunkPipeline) writeWorker() {
+	defer p.writers.Done()
+	for job := range p.write {
+		p.m.progress.SetQueue("write", len(p.write))
+		job.size = int64(len(job.data))
+		job.stored, job.err = p.m.cas.PutChunk(job.chunk.Hash, job.data)
+		if job.err != nil {
+			job.err = fmt.Errorf("storage failed: %w", job.err)
+		} else {
+			p.m.filter.Add(job.chunk.Hash)
+		}
+		job.data = nil
+		job.batch.finish(job)
+	}
+}
+
+// submit queues a chunk of a batch without waiting for it to be stored
+// The queues are bounded, so submit blocks while the workers are behind and
+// the chunks in flight stay within the queue depths.
+func (p *chunkPipeline) submit(batch *chunkBatch, chunk *models.Chunk) *chunkJob {
+	job := &chunkJob{chunk: chunk, batch: batch}
+	batch.done.Add(1)
+	p.encode <- job
+	return job
+}
+
+// storeFiles runs store for each path on the file stage's workers, counting
+// down the named progress queue as files are handed out
+// Transient failures are returned by path; any other error stops handing
+// out files and is returned once the running ones finish.
+func (m *Manager) storeFiles(paths []string, queue string, store func(worker int, relPath string) error) (map[string]error, error) {
+	var (
+		mu       sync.Mutex
+		failed   = make(map[string]error)
+		firstErr error
+		wg       sync.WaitGroup
+	)
+
+	files := make(chan string)
+	workers := m.parallel.withDefaults().Files
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func(worker int) {
+			defer wg.Done()
+			for relPath := range files {
+				err := store(worker, relPath)
+				if err == nil {
+					continue
+				}
+				mu.Lock()
+				if transient(err) {
+					failed[relPath] = err
+				} else if firstErr == nil {
+					firstErr = err
+				}
+				mu.Unlock()
+			}
+		}(i)
+	}
+
+	for i, relPath := range paths {
+		mu.Lock()
+		stop := firstErr != nil
+		mu.Unlock()
+		if stop {
+			break
+		}
+		m.progress.SetQueue(queue, len(paths)-i-1)
+		files <- relPath
+	}
+	close(files)
+	wg.Wait()
+
+	return failed, firstErr
+}
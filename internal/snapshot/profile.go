@@ -0,0 +1,102 @@
+package snapshot
+
+import (
+	"sort"
+	"time"
+)
+
+// profileAges are the ages, in days, StorageProfile reports data older than
+var profileAges = []int{30, 90, 180, 365}
+
+// AgeBand is the referenced data written at least Days ago
+type AgeBand struct {
+	Days    int   `json:"days"`
+	Objects int   `json:"objects"`
+	Size    int64 `json:"size"`
+}
+
+// StorageProfile describes how the repository's data is written and kept,
+// measured from its snapshots and the objects they reference
+type StorageProfile struct {
+	Snapshots      int           `json:"snapshots"`
+	Oldest         time.Time     `json:"oldest,omitempty"`
+	Newest         time.Time     `json:"newest,omitempty"`
+	BackupInterval time.Duration `json:"backup_interval"` // Median gap between backups of one source, 0 if unknown
+	LongestBackup  time.Duration `json:"longest_backup"`
+	Objects        int           `json:"objects"` // Referenced objects
+	Size           int64         `json:"size"`
+	SmallObjects   int           `json:"small_objects"` // Referenced objects below the small size
+	SmallSize      int64         `json:"small_size"`
+	Ages           []AgeBand     `json:"ages"` // Cumulative, youngest bound first
+}
+
+// StorageProfile measures the repository's snapshots and the objects they
+// reference; objects under smallSize bytes are counted apart
+// An object's age is the time since it was written to this repository.
+func (m *Manager) StorageProfile(smallSize int64) (*StorageProfile, error) {
+	snapshots, err := m.records()
+	if err != nil {
+		return nil, err
+	}
+	usage, objects, err := m.usage(snapshots)
+	if err != nil {
+		return nil, err
+	}
+
+	profile := &StorageProfile{
+		Snapshots: len(snapshots),
+		Objects:   usage.ReferencedObjects,
+		Size:      usage.ReferencedSize,
+		Ages:      make([]AgeBand, len(profileAges)),
+	}
+	for i, days := range profileAges {
+		profile.Ages[i].Days = days
+	}
+
+	bySource := make(map[string][]time.Time)
+	for _, snap := range snapshots {
+		if profile.Oldest.IsZero() || snap.Timestamp.Before(profile.Oldest) {
+			profile.Oldest = snap.Timestamp
+		}
+		if snap.Timestamp.After(profile.Newest) {
+			profile.Newest = snap.Timestamp
+		}
+		if snap.Stats.Duration > profile.LongestBackup {
+			profile.LongestBackup = snap.Stats.Duration
+		}
+		source := snapshotSource(snap)
+		bySource[source] = append(bySource[source], snap.Timestamp)
+	}
+
+	var gaps []time.Duration
+	for _, times := range bySource {
+		sort.Slice(times, func(i, j int) bool { return times[i].Before(times[j]) })
+		for i := 1; i < len(times); i++ {
+			gaps = append(gaps, times[i].Sub(times[i-1]))
+		}
+	}
+	if len(gaps) > 0 {
+		sort.Slice(gaps, func(i, j int) bool { return gaps[i] < gaps[j] })
+		profile.BackupInterval = gaps[len(gaps)/2]
+	}
+
+	now := time.Now()
+	for hash, size := range objects.sizes {
+		if size < smallSize {
+			profile.SmallObjects++
+			profile.SmallSize += size
+		}
+		written, err := m.cas.ModTime(hash)
+		if err != nil {
+			continue
+		}
+		age := now.Sub(written)
+		for i := range profile.Ages {
+			if age >= time.Duration(profile.Ages[i].Days)*24*time.Hour {
+				profile.Ages[i].Objects++
+				profile.Ages[i].Size += size
+			}
+		}
+	}
+	return profile, nil
+}
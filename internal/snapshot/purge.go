@@ -0,0 +1,129 @@
+package snapshot
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Purge rewrites a snapshot without the files for which match returns true
+// A matched directory takes everything below it along. Returns the number of
+// entries removed; the snapshot is left untouched when nothing matches, so
+// retention locks only block snapshots that actually hold matching files.
+func (m *Manager) Purge(id string, match func(relPath string) bool) (int, error) {
+	record, err := m.readRecord(id)
+	if err != nil {
+		return 0, err
+	}
+	if record.EncryptedNames && m.encryptor == nil {
+		return 0, fmt.Errorf("snapshot %s has encrypted names: key required", id)
+	}
+
+	snap, err := m.Get(id)
+	if err != nil {
+		return 0, err
+	}
+	tree := snap.Tree
+	if tree == nil {
+		return 0, nil
+	}
+
+	var matched []string
+	for relPath := range tree.Files {
+		if relPath != "." && matchesOrParent(relPath, match) {
+			matched = append(matched, relPath)
+		}
+	}
+	if len(matched) == 0 {
+		return 0, nil
+	}
+
+	if record.RetentionLocked(time.Now()) {
+		return 0, fmt.Errorf("%w until %s: %s", ErrRetentionLocked,
+			record.RetainUntil.Format(time.RFC3339), id)
+	}
+
+	for _, relPath := range matched {
+		node := tree.Files[relPath]
+		delete(tree.Files, relPath)
+
+		if node.IsDir {
+			tree.DirCount--
+			continue
+		}
+		tree.FileCount--
+		tree.TotalSize -= node.Size
+		if node.Hash != "" {
+			// The whole-file hash alone identifies the purged content
+			m.index.Remove(node.Hash)
+		}
+		if node.CopyHash != "" {
+			m.index.Remove(node.CopyHash)
+		}
+	}
+
+	if err := m.saveSnapshot(snap); err != nil {
+		return 0, fmt.Errorf("failed to rewrite snapshot %s: %w", id, err)
+	}
+	if err := m.index.Save(); err != nil {
+		return len(matched), fmt.Errorf("failed to save file index: %w", err)
+	}
+	if err := m.removeIndexedPaths(matched); err != nil {
+		// Purged names must not survive in the filename index
+		m.dropPathIndex()
+	}
+
+	return len(matched), nil
+}
+
+// matchesOrParent reports whether relPath or one of its parent directories
+// matches
+func matchesOrParent(relPath string, match func(string) bool) bool {
+	for p := relPath; p != "." && p != string(filepath.Separator); p = filepath.Dir(p) {
+		if match(p) {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchPath reports whether a slash-separated relative path matches a glob
+// pattern. "**" matches any number of directories; a pattern without a slash
+// matches the base name at any depth.
+func MatchPath(pattern, relPath string) bool {
+	relPath = filepath.ToSlash(relPath)
+	pattern = strings.Trim(filepath.ToSlash(pattern), "/")
+
+	if !strings.Contains(pattern, "/") && pattern != "**" {
+		matched, _ := filepath.Match(pattern, relPath[strings.LastIndex(relPath, "/")+1:])
+		return matched
+	}
+
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(relPath, "/"))
+}
+
+// matchSegments matches path segments against pattern segments
+func matchSegments(pattern, path []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			// Zero or more whole segments
+			for i := 0; i <= len(path); i++ {
+				if matchSegments(pattern[1:], path[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+
+		if len(path) == 0 {
+			return false
+		}
+		if matched, _ := filepath.Match(pattern[0], path[0]); !matched {
+			return false
+		}
+		pattern, path = pattern[1:], path[1:]
+	}
+
+	return len(path) == 0
+}
@@ -0,0 +1,165 @@
+package snapshot
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/snapsync/snapsync/internal/store"
+	"github.com/snapsync/snapsync/pkg/models"
+)
+
+// References returns the hashes of every object a snapshot needs: the
+// chunks of its files and, for tree-object snapshots, its directory objects
+func (m *Manager) References(snap *models.Snapshot) (map[string]bool, error) {
+	refs := make(map[string]bool)
+
+	if snap.TreeHash == "" {
+		if snap.Tree != nil {
+			for _, node := range snap.Tree.Files {
+				for _, hash := range node.Chunks {
+					refs[hash] = true
+				}
+			}
+		}
+		return refs, nil
+	}
+
+	if snap.EncryptedNames && m.encryptor == nil {
+		return nil, fmt.Errorf("snapshot %s has encrypted names: key required", snap.ID)
+	}
+	if err := m.dirReferences(snap.TreeHash, snap.EncryptedNames, refs); err != nil {
+		return nil, err
+	}
+	return refs, nil
+}
+
+// dirReferences adds a directory object, its subdirectories and their
+// chunks to refs
+func (m *Manager) dirReferences(hash string, sealed bool, refs map[string]bool) error {
+	if refs[hash] {
+		// Shared directory already visited
+		return nil
+	}
+	refs[hash] = true
+
+	obj, err := m.readTreeObject(hash, sealed)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range obj.Entries {
+		for _, chunk := range entry.Chunks {
+			refs[chunk] = true
+		}
+		if entry.Subtree != "" {
+			if err := m.dirReferences(entry.Subtree, sealed, refs); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// records reads every snapshot record in the repository
+// Unlike List it fails on unreadable records, so callers that delete data
+// never mistake a damaged snapshot for a missing one.
+func (m *Manager) records() ([]*models.Snapshot, error) {
+	entries, err := os.ReadDir(filepath.Join(m.repoPath, "snapshots"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var snapshots []*models.Snapshot
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".json" {
+			continue
+		}
+
+		id := entry.Name()[:len(entry.Name())-5]
+		snap, err := m.Get(id)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read snapshot %s: %w", id, err)
+		}
+		snapshots = append(snapshots, snap)
+	}
+
+	return snapshots, nil
+}
+
+// LiveObjects returns every object hash referenced by a remaining snapshot
+func (m *Manager) LiveObjects() (map[string]bool, error) {
+	snapshots, err := m.records()
+	if err != nil {
+		return nil, err
+	}
+
+	live := make(map[string]bool)
+	for _, snap := range snapshots {
+		refs, err := m.References(snap)
+		if err != nil {
+			return nil, fmt.Errorf("failed to walk snapshot %s: %w", snap.ID, err)
+		}
+		for hash := range refs {
+			live[hash] = true
+		}
+	}
+	return live, nil
+}
+
+// CollectGarbage marks every object reachable from a snapshot and sweeps
+// the rest from the store; a dry run only reports what would be deleted
+// It must not run while a backup is writing to the repository.
+func (m *Manager) CollectGarbage(dryRun bool) (*store.GCStats, error) {
+	snapshots, err := m.records()
+	if err != nil {
+		return nil, err
+	}
+
+	gc := store.NewGC(m.cas)
+	for _, snap := range snapshots {
+		refs, err := m.References(snap)
+		if err != nil {
+			return nil, fmt.Errorf("failed to walk snapshot %s: %w", snap.ID, err)
+		}
+		for hash := range refs {
+			gc.Mark(hash)
+		}
+	}
+
+	stats, err := gc.Sweep(dryRun)
+	if err != nil || dryRun || len(stats.Swept) == 0 {
+		return stats, err
+	}
+
+	// Neither cache may point at deleted chunks
+	deleted := make(map[string]bool, len(stats.Swept))
+	for _, hash := range stats.Swept {
+		deleted[hash] = true
+		delete(m.trees, hash)
+	}
+	m.index.RemoveChunks(deleted)
+	if err := m.index.Save(); err != nil {
+		return stats, fmt.Errorf("failed to save file index: %w", err)
+	}
+	m.filter = nil
+	if err := os.Remove(filepath.Join(m.repoPath, "index", "chunks.bloom")); err != nil && !os.IsNotExist(err) {
+		return stats, fmt.Errorf("failed to reset chunk filter: %w", err)
+	}
+
+	return stats, nil
+}
+
+// RemoveUnreferenced deletes every object that no snapshot references and
+// returns how many objects and bytes were freed
+func (m *Manager) RemoveUnreferenced() (int, int64, error) {
+	stats, err := m.CollectGarbage(false)
+	if stats == nil {
+		return 0, 0, err
+	}
+	return len(stats.Swept), stats.SweptBytes, err
+}
@@ -0,0 +1,642 @@
+package snapshot
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/snapsync/snapsync/internal/store"
+	"github.com/snapsync/snapsync/pkg/models"
+)
+
+// RepairOptions selects what Repair may change
+type RepairOptions struct {
+	DryRun         bool // Report the damage and what would be done, changing nothing
+	FromSource     bool // Re-chunk files with missing chunks from their live source
+	RecoverOrphans bool // Create snapshots for root tree objects no record points to
+}
+
+// DamagedSnapshot describes what a snapshot is missing after repair
+type DamagedSnapshot struct {
+	ID             string   `json:"id"`
+	Source         string   `json:"source"`
+	MissingObjects []string `json:"missing_objects"` // Still missing
+	MissingTrees   int      `json:"missing_trees"`   // Directory objects among them
+	Files          []string `json:"files"`           // Files that cannot be restored in full
+	Restored       []string `json:"restored"`        // Files complete again after chunks were restored
+	SourceErrors   []string `json:"source_errors,omitempty"`
+	Degraded       bool     `json:"degraded"`
+}
+
+// RepairResult reports what Repair found and changed
+type RepairResult struct {
+	Snapshots    int               `json:"snapshots"`
+	Salvaged     []string          `json:"salvaged"`  // Damaged records rebuilt around their tree
+	SetAside     []string          `json:"set_aside"` // Damaged records nothing could be rebuilt from
+	Recovered    []string          `json:"recovered"` // Snapshots created for orphaned trees
+	Damaged      []DamagedSnapshot `json:"damaged"`   // Snapshots that were missing objects
+	Cleared      []string          `json:"cleared"`   // Snapshots no longer marked degraded
+	ChunksStored int               `json:"chunks_stored"`
+	IndexEntries int               `json:"index_entries_dropped"` // File index entries using missing chunks
+	PathIndex    string            `json:"path_index,omitempty"`  // "rebuilt" or "dropped"
+}
+
+// Fields read back from a damaged record, which may be cut short or have
+// stray bytes anywhere in it
+var (
+	salvageTreeHash    = regexp.MustCompile(`"tree_hash":\s*"([0-9a-f]{64})"`)
+	salvageTimestamp   = regexp.MustCompile(`"timestamp":\s*"([^"]+)"`)
+	salvageDescription = regexp.MustCompile(`"description":\s*("(?:[^"\\]|\\.)*")`)
+	salvageRootPath    = regexp.MustCompile(`"root":\s*\{\s*"path":\s*("(?:[^"\\]|\\.)*")`)
+	salvageSealed      = regexp.MustCompile(`"encrypted_names":\s*true`)
+)
+
+// Repair brings a damaged repository back to a consistent state
+// Records that cannot be parsed are rebuilt around their tree object when
+// its hash can still be read from them, and otherwise set aside as
+// <id>.json.damaged. Snapshots with missing chunks or tree objects are
+// marked degraded, and files whose chunks are missing can be re-chunked
+// from the live source: only chunks whose content still hashes to a missing
+// chunk are stored, so a file that changed since the backup can never put
+// wrong data in the store. Finally the file index drops entries that use
+// missing chunks and the filename index is rebuilt.
+//
+// Rebuilt and recovered records are not part of the snapshot chain, and
+// recovering orphans also brings back deleted snapshots whose data has not
+// been pruned yet.
+func (m *Manager) Repair(opts RepairOptions) (*RepairResult, error) {
+	result := &RepairResult{
+		Salvaged:  []string{},
+		SetAside:  []string{},
+		Recovered: []string{},
+		Damaged:   []DamagedSnapshot{},
+		Cleared:   []string{},
+	}
+
+	records, bad, err := m.readRecords()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, id := range bad {
+		record, err := m.salvageRecord(id)
+		if err != nil {
+			result.SetAside = append(result.SetAside, id)
+		} else {
+			result.Salvaged = append(result.Salvaged, id)
+			records = append(records, record)
+		}
+		if opts.DryRun {
+			continue
+		}
+		if err := m.setAside(id); err != nil {
+			return nil, err
+		}
+		if record != nil {
+			if err := m.writeRecord(record); err != nil {
+				return nil, fmt.Errorf("failed to write snapshot %s: %w", id, err)
+			}
+		}
+	}
+
+	if opts.RecoverOrphans {
+		recovered, err := m.recoverOrphans(records)
+		if err != nil {
+			return nil, err
+		}
+		for _, record := range recovered {
+			if !opts.DryRun {
+				if err := m.writeRecord(record); err != nil {
+					return nil, fmt.Errorf("failed to write snapshot %s: %w", record.ID, err)
+				}
+			}
+			result.Recovered = append(result.Recovered, record.ID)
+			records = append(records, record)
+		}
+	}
+
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].Timestamp.Before(records[j].Timestamp)
+	})
+	result.Snapshots = len(records)
+
+	if m.filter == nil {
+		if m.filter, err = store.LoadBloom(m.repoPath, m.cas); err != nil {
+			return nil, err
+		}
+	}
+
+	// Every source is tried before any snapshot is judged, since a chunk
+	// restored for one snapshot completes the others that share it
+	restored := make(map[string]bool) // Chunks stored again, or that would be
+	damage := make([]*snapshotDamage, len(records))
+	for i, record := range records {
+		if damage[i], err = m.findDamage(record); err != nil {
+			return nil, err
+		}
+		if damage[i] != nil && opts.FromSource {
+			m.restoreDamage(damage[i], opts.DryRun, restored)
+		}
+	}
+
+	for i, record := range records {
+		var damaged *DamagedSnapshot
+		if damage[i] != nil {
+			damaged = damage[i].describe(restored)
+			result.Damaged = append(result.Damaged, *damaged)
+		}
+
+		cleared, err := m.markDegraded(record, damaged, opts.DryRun)
+		if err != nil {
+			return nil, err
+		}
+		if cleared {
+			result.Cleared = append(result.Cleared, record.ID)
+		}
+	}
+	result.ChunksStored = len(restored)
+
+	if opts.DryRun {
+		return result, nil
+	}
+
+	if err := m.filter.Save(); err != nil {
+		return nil, fmt.Errorf("failed to save chunk filter: %w", err)
+	}
+	result.IndexEntries = m.index.RemoveMissing(m.cas.HasMany)
+	if err := m.index.Save(); err != nil {
+		return nil, fmt.Errorf("failed to save file index: %w", err)
+	}
+
+	if m.indexPaths || m.hasPathIndex() {
+		// A snapshot whose tree cannot be read has no paths to index, so
+		// the index goes until a backup can rebuild it
+		result.PathIndex = "rebuilt"
+		if err := m.RebuildPathIndex(); err != nil {
+			m.dropPathIndex()
+			result.PathIndex = "dropped"
+		}
+	}
+
+	return result, nil
+}
+
+// snapshotDamage is what one snapshot is missing
+type snapshotDamage struct {
+	snap       *models.Snapshot
+	files      map[string]*models.FileNode
+	affected   map[string][]string // Missing chunks by relative path
+	unreadable []string            // Directory objects that cannot be read
+	errors     map[string]error    // Failures reading the source, by relative path
+}
+
+// findDamage walks what can be read of a snapshot and finds the objects it
+// is missing, returning nil if there are none
+func (m *Manager) findDamage(record *models.Snapshot) (*snapshotDamage, error) {
+	snap, err := m.openRecord(record)
+	if err != nil {
+		return nil, err
+	}
+	files, unreadable := m.looseFiles(snap)
+
+	var hashes []string
+	for _, node := range files {
+		hashes = append(hashes, node.Chunks...)
+	}
+	present := m.cas.HasMany(hashes)
+
+	affected := make(map[string][]string)
+	for relPath, node := range files {
+		for _, hash := range node.Chunks {
+			if !present[hash] {
+				affected[relPath] = append(affected[relPath], hash)
+			}
+		}
+	}
+	if len(affected) == 0 && len(unreadable) == 0 {
+		return nil, nil
+	}
+
+	return &snapshotDamage{
+		snap:       snap,
+		files:      files,
+		affected:   affected,
+		unreadable: unreadable,
+		errors:     make(map[string]error),
+	}, nil
+}
+
+// restoreDamage re-chunks the damaged files of a snapshot from its source
+// Sources that are not local paths, such as streams and imports, are skipped.
+func (m *Manager) restoreDamage(d *snapshotDamage, dryRun bool, restored map[string]bool) {
+	if !filepath.IsAbs(snapshotSource(d.snap)) {
+		return
+	}
+	for relPath, missing := range d.affected {
+		if err := m.restoreFromSource(d.files[relPath].Path, missing, dryRun, restored); err != nil {
+			d.errors[relPath] = err
+		}
+	}
+}
+
+// describe reports what a snapshot is still missing once the chunks in
+// restored are back
+func (d *snapshotDamage) describe(restored map[string]bool) *DamagedSnapshot {
+	damaged := &DamagedSnapshot{
+		ID:             d.snap.ID,
+		Source:         snapshotSource(d.snap),
+		MissingObjects: append([]string{}, d.unreadable...),
+		MissingTrees:   len(d.unreadable),
+		Files:          []string{},
+		Restored:       []string{},
+	}
+
+	paths := make([]string, 0, len(d.affected))
+	for relPath := range d.affected {
+		paths = append(paths, relPath)
+	}
+	sort.Strings(paths)
+
+	missing := make(map[string]bool)
+	for _, relPath := range paths {
+		complete := true
+		for _, hash := range d.affected[relPath] {
+			if !restored[hash] {
+				complete = false
+				missing[hash] = true
+			}
+		}
+		if complete {
+			damaged.Restored = append(damaged.Restored, relPath)
+			continue
+		}
+		damaged.Files = append(damaged.Files, relPath)
+		if err := d.errors[relPath]; err != nil {
+			damaged.SourceErrors = append(damaged.SourceErrors, fmt.Sprintf("%s: %v", relPath, err))
+		}
+	}
+
+	for hash := range missing {
+		damaged.MissingObjects = append(damaged.MissingObjects, hash)
+	}
+	sort.Strings(damaged.MissingObjects)
+	damaged.Degraded = len(damaged.MissingObjects) > 0
+	return damaged
+}
+
+// restoreFromSource re-chunks the live file at path and stores the chunks
+// that match missing ones, adding them to restored
+func (m *Manager) restoreFromSource(path string, missing []string, dryRun bool, restored map[string]bool) error {
+	wanted := make(map[string]bool, len(missing))
+	for _, hash := range missing {
+		wanted[hash] = true
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	err = m.chunker.ChunkStream(file, func(chunk *models.Chunk) error {
+		if !wanted[chunk.Hash] || restored[chunk.Hash] {
+			return nil
+		}
+		if !dryRun {
+			data, err := m.encodeChunk(chunk.Data)
+			if err != nil {
+				return err
+			}
+			if _, err := m.cas.PutChunk(chunk.Hash, data); err != nil {
+				return fmt.Errorf("storage failed: %w", err)
+			}
+			m.filter.Add(chunk.Hash)
+		}
+		restored[chunk.Hash] = true
+		return nil
+	})
+	file.Close()
+	if err != nil {
+		return err
+	}
+
+	for _, hash := range missing {
+		if !restored[hash] {
+			return fmt.Errorf("source no longer holds the missing data")
+		}
+	}
+	return nil
+}
+
+// markDegraded records on a snapshot whether it is still missing objects,
+// reporting whether an earlier mark was cleared
+func (m *Manager) markDegraded(record *models.Snapshot, damaged *DamagedSnapshot, dryRun bool) (bool, error) {
+	degraded := damaged != nil && damaged.Degraded
+	if !degraded && record.Degraded == nil {
+		return false, nil
+	}
+	if dryRun {
+		return !degraded, nil
+	}
+
+	// The record as stored, not the copy names were opened in
+	stored, err := m.readRecord(record.ID)
+	if err != nil {
+		return false, fmt.Errorf("failed to read snapshot %s: %w", record.ID, err)
+	}
+
+	if !degraded {
+		stored.Degraded = nil
+	} else {
+		mark := &models.Degradation{Since: time.Now()}
+		if stored.Degraded != nil {
+			mark.Since = stored.Degraded.Since
+		}
+		mark.MissingObjects = len(damaged.MissingObjects)
+		mark.MissingTrees = damaged.MissingTrees
+		mark.Files = len(damaged.Files)
+		stored.Degraded = mark
+	}
+
+	if err := m.writeRecord(stored); err != nil {
+		return false, fmt.Errorf("failed to write snapshot %s: %w", record.ID, err)
+	}
+	return !degraded, nil
+}
+
+// openRecord returns a copy of a record with its names decrypted
+func (m *Manager) openRecord(record *models.Snapshot) (*models.Snapshot, error) {
+	snap := *record
+	if record.Tree == nil {
+		return &snap, nil
+	}
+
+	tree := *record.Tree
+	if tree.Root != nil {
+		root := *tree.Root
+		tree.Root = &root
+	}
+	if tree.Files != nil {
+		tree.Files = make(map[string]*models.FileNode, len(record.Tree.Files))
+		for relPath, node := range record.Tree.Files {
+			copied := *node
+			tree.Files[relPath] = &copied
+		}
+	}
+	snap.Tree = &tree
+
+	if snap.EncryptedNames {
+		if m.encryptor == nil {
+			return nil, fmt.Errorf("snapshot %s has encrypted names: key required", snap.ID)
+		}
+		if err := m.openNames(snap.Tree); err != nil {
+			return nil, err
+		}
+	}
+	return &snap, nil
+}
+
+// looseFiles loads as much of a snapshot's tree as can be read and returns
+// its nodes by relative path, with the directory objects that could not be
+func (m *Manager) looseFiles(snap *models.Snapshot) (map[string]*models.FileNode, []string) {
+	if snap.TreeHash == "" || snap.Tree == nil {
+		return snapshotFiles(snap), nil
+	}
+
+	tree := &models.FileTree{Root: snap.Tree.Root, Files: make(map[string]*models.FileNode)}
+	if tree.Root != nil {
+		root := *tree.Root
+		tree.Files["."] = &root
+	}
+
+	var unreadable []string
+	m.looseDir(tree, snap.TreeHash, ".", snap.EncryptedNames, &unreadable)
+	return tree.Files, unreadable
+}
+
+// looseDir adds the entries of a directory object under dir, skipping the
+// subdirectories whose objects cannot be read
+func (m *Manager) looseDir(tree *models.FileTree, hash, dir string, sealed bool, unreadable *[]string) {
+	obj, err := m.readTreeObject(hash, sealed)
+	if err != nil {
+		*unreadable = append(*unreadable, hash)
+		return
+	}
+
+	var rootPath string
+	if tree.Root != nil {
+		rootPath = tree.Root.Path
+	}
+
+	for _, entry := range obj.Entries {
+		relPath := filepath.Join(dir, entry.Name)
+		tree.Files[relPath] = entryNode(entry, rootPath, relPath)
+		if entry.Subtree != "" {
+			m.looseDir(tree, entry.Subtree, relPath, sealed, unreadable)
+		}
+	}
+}
+
+// salvageRecord rebuilds a damaged record around the tree object it names
+func (m *Manager) salvageRecord(id string) (*models.Snapshot, error) {
+	data, err := os.ReadFile(filepath.Join(m.repoPath, "snapshots", id+".json"))
+	if err != nil {
+		return nil, err
+	}
+
+	match := salvageTreeHash.FindSubmatch(data)
+	if match == nil {
+		return nil, fmt.Errorf("no tree object named in record")
+	}
+	treeHash := string(match[1])
+	sealed := salvageSealed.Match(data)
+	if sealed && m.encryptor == nil {
+		return nil, fmt.Errorf("record has encrypted names: key required")
+	}
+
+	var timestamp time.Time
+	if match := salvageTimestamp.FindSubmatch(data); match != nil {
+		timestamp, _ = time.Parse(time.RFC3339Nano, string(match[1]))
+	}
+
+	var rootPath, description string
+	if match := salvageRootPath.FindSubmatch(data); match != nil {
+		if json.Unmarshal(match[1], &rootPath) == nil && sealed {
+			if rootPath, err = m.encryptor.DecryptName(rootPath); err != nil {
+				rootPath = ""
+			}
+		}
+	}
+	if rootPath == "" {
+		rootPath = "recovered-" + treeHash[:12]
+	}
+	if match := salvageDescription.FindSubmatch(data); match != nil {
+		_ = json.Unmarshal(match[1], &description)
+	}
+	if description == "" {
+		description = "Rebuilt by repair"
+	}
+
+	return m.treeRecord(id, treeHash, sealed, rootPath, timestamp, description)
+}
+
+// recoverOrphans creates records for root directory objects that neither
+// a record nor another directory object points to
+func (m *Manager) recoverOrphans(records []*models.Snapshot) ([]*models.Snapshot, error) {
+	hashes, err := m.cas.List()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list objects: %w", err)
+	}
+
+	referenced := make(map[string]bool)
+	for _, record := range records {
+		referenced[record.TreeHash] = true
+	}
+
+	sealed := make(map[string]bool) // Tree objects found, and whether encrypted
+	for _, hash := range hashes {
+		isTree, isSealed := m.probeTree(hash)
+		if !isTree {
+			continue
+		}
+		sealed[hash] = isSealed
+		obj, _ := m.readTreeObject(hash, isSealed)
+		for _, entry := range obj.Entries {
+			if entry.Subtree != "" {
+				referenced[entry.Subtree] = true
+			}
+		}
+	}
+
+	var recovered []*models.Snapshot
+	for hash, isSealed := range sealed {
+		obj, _ := m.readTreeObject(hash, isSealed)
+		if referenced[hash] || len(obj.Entries) == 0 {
+			continue
+		}
+
+		written, err := m.cas.ModTime(hash)
+		if err != nil {
+			return nil, err
+		}
+		description := fmt.Sprintf("Recovered by repair from tree %s", hash[:12])
+		record, err := m.treeRecord(generateID(), hash, isSealed, "recovered-"+hash[:12], written, description)
+		if err != nil {
+			return nil, err
+		}
+		recovered = append(recovered, record)
+	}
+
+	sort.Slice(recovered, func(i, j int) bool {
+		return recovered[i].Timestamp.Before(recovered[j].Timestamp)
+	})
+	return recovered, nil
+}
+
+// probeTree reports whether an object is a directory object and whether
+// it is encrypted
+// Plain directory objects are told apart by their first bytes; anything
+// else is only a tree if it opens with the key.
+func (m *Manager) probeTree(hash string) (bool, bool) {
+	prefix := []byte(`{"entries":`)
+
+	r, err := m.cas.GetReader(hash)
+	if err != nil {
+		return false, false
+	}
+	head := make([]byte, len(prefix))
+	n, _ := io.ReadFull(r, head)
+	r.Close()
+
+	if bytes.Equal(head[:n], prefix) {
+		_, err := m.readTreeObject(hash, false)
+		return err == nil, false
+	}
+	if m.encryptor == nil {
+		return false, false
+	}
+	_, err = m.readTreeObject(hash, true)
+	return err == nil, true
+}
+
+// treeRecord builds the record of a snapshot of the tree at treeHash,
+// counting what can still be read of it
+// The record is returned as stored, with names sealed if the tree is.
+func (m *Manager) treeRecord(id, treeHash string, sealed bool, rootPath string, timestamp time.Time, description string) (*models.Snapshot, error) {
+	if timestamp.IsZero() {
+		written, err := m.cas.ModTime(treeHash)
+		if err != nil {
+			return nil, fmt.Errorf("tree object %s: %w", treeHash, err)
+		}
+		timestamp = written
+	}
+
+	snap := &models.Snapshot{
+		ID:          id,
+		Timestamp:   timestamp,
+		Description: description,
+		TreeHash:    treeHash,
+		Tree: &models.FileTree{
+			Root: &models.FileNode{
+				Path:    rootPath,
+				Name:    filepath.Base(rootPath),
+				IsDir:   true,
+				Mode:    os.ModeDir | 0755,
+				ModTime: timestamp,
+			},
+		},
+		Compressed:     m.compressor != nil,
+		Encrypted:      m.encryptor != nil,
+		EncryptedNames: sealed,
+	}
+
+	files, unreadable := m.looseFiles(snap)
+	if len(unreadable) > 0 && unreadable[0] == treeHash {
+		return nil, fmt.Errorf("tree object %s cannot be read", treeHash)
+	}
+	for relPath, node := range files {
+		switch {
+		case relPath == ".":
+		case node.IsDir:
+			snap.Tree.DirCount++
+		default:
+			snap.Tree.FileCount++
+			snap.Tree.TotalSize += node.Size
+			snap.Stats.ChunkCount += len(node.Chunks)
+		}
+	}
+	snap.Tree.DirCount++ // The root
+	snap.Stats.TotalSize = snap.Tree.TotalSize
+
+	if sealed {
+		sealedTree, err := m.sealNames(snap.Tree)
+		if err != nil {
+			return nil, err
+		}
+		snap.Tree = sealedTree
+	}
+	return snap, nil
+}
+
+// setAside moves a damaged record out of the way, keeping it for inspection
+func (m *Manager) setAside(id string) error {
+	path := filepath.Join(m.repoPath, "snapshots", id+".json")
+	if err := os.Rename(path, path+".damaged"); err != nil {
+		return fmt.Errorf("failed to set aside snapshot %s: %w", id, err)
+	}
+	return nil
+}
+
+// hasPathIndex reports whether the repository keeps a filename index
+func (m *Manager) hasPathIndex() bool {
+	for _, sealed := range []bool{false, true} {
+		if _, err := os.Stat(m.pathIndexPath(sealed)); err == nil {
+			return true
+		}
+	}
+	return false
+}
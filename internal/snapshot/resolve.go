@@ -0,0 +1,177 @@
+package snapshot
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/snapsync/snapsync/pkg/models"
+)
+
+var (
+	// ErrSnapshotNotFound is returned when no snapshot matches a reference
+	ErrSnapshotNotFound = errors.New("snapshot not found")
+
+	// ErrAmbiguousSnapshot is returned when an ID prefix matches several
+	// snapshots
+	ErrAmbiguousSnapshot = errors.New("snapshot reference is ambiguous")
+)
+
+// timeLayouts are the forms accepted after @ in a snapshot reference
+var timeLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+// Resolve returns the snapshot a reference names (see ResolveID)
+func (m *Manager) Resolve(ref string) (*models.Snapshot, error) {
+	id, err := m.ResolveID(ref)
+	if err != nil {
+		return nil, err
+	}
+	return m.Get(id)
+}
+
+// ResolveID returns the ID of the snapshot a reference names
+// A reference is one of:
+//
+//	<id>        a full snapshot ID
+//	<prefix>    a prefix of exactly one snapshot ID, of any length
+//	latest      the newest snapshot; latest~N is the Nth one before it
+//	oldest      the oldest snapshot
+//	@<time>     the newest snapshot taken at or before a time, given as
+//	            2006-01-02, 2006-01-02 15:04[:05], RFC 3339, or as an age
+//	            such as @12h, @3d or @2w
+//
+// Only the snapshot records are read, so references resolve without the
+// key of a repository with encrypted names.
+func (m *Manager) ResolveID(ref string) (string, error) {
+	ref = strings.TrimSpace(ref)
+	if ref == "" {
+		return "", fmt.Errorf("empty snapshot reference")
+	}
+
+	ids, err := m.SnapshotIDs()
+	if err != nil {
+		return "", fmt.Errorf("failed to list snapshots: %w", err)
+	}
+	if ids[ref] {
+		return ref, nil
+	}
+
+	switch {
+	case ref == "latest" || strings.HasPrefix(ref, "latest~"):
+		back := 0
+		if ref != "latest" {
+			back, err = strconv.Atoi(ref[len("latest~"):])
+			if err != nil || back < 0 {
+				return "", fmt.Errorf("invalid snapshot reference %q: expected latest~N", ref)
+			}
+		}
+		records := m.timeline(ids)
+		if back >= len(records) {
+			return "", fmt.Errorf("%w: %s (repository has %d snapshots)", ErrSnapshotNotFound, ref, len(records))
+		}
+		return records[back].ID, nil
+
+	case ref == "oldest":
+		records := m.timeline(ids)
+		if len(records) == 0 {
+			return "", fmt.Errorf("%w: repository has no snapshots", ErrSnapshotNotFound)
+		}
+		return records[len(records)-1].ID, nil
+
+	case strings.HasPrefix(ref, "@"):
+		at, err := parseRefTime(ref[1:], time.Now())
+		if err != nil {
+			return "", fmt.Errorf("invalid snapshot reference %q: %w", ref, err)
+		}
+		records := m.timeline(ids)
+		for _, record := range records {
+			if !record.Timestamp.After(at) {
+				return record.ID, nil
+			}
+		}
+		return "", fmt.Errorf("%w: no snapshot taken at or before %s", ErrSnapshotNotFound, at.Format(time.RFC3339))
+	}
+
+	var matches []string
+	for id := range ids {
+		if strings.HasPrefix(id, ref) {
+			matches = append(matches, id)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return "", fmt.Errorf("%w: %s", ErrSnapshotNotFound, ref)
+	case 1:
+		return matches[0], nil
+	}
+
+	sort.Strings(matches)
+	const shown = 5
+	list := matches
+	if len(list) > shown {
+		list = list[:shown]
+	}
+	msg := strings.Join(list, ", ")
+	if len(matches) > shown {
+		msg += fmt.Sprintf(" and %d more", len(matches)-shown)
+	}
+	return "", fmt.Errorf("%w: %s matches %d snapshots (%s); give more of the ID",
+		ErrAmbiguousSnapshot, ref, len(matches), msg)
+}
+
+// timeline reads the records of ids, newest first, skipping unreadable ones
+// as ListRecords does
+func (m *Manager) timeline(ids map[string]bool) []*models.Snapshot {
+	records := make([]*models.Snapshot, 0, len(ids))
+	for id := range ids {
+		record, err := m.readRecord(id)
+		if err != nil {
+			continue
+		}
+		records = append(records, record)
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].Timestamp.After(records[j].Timestamp)
+	})
+	return records
+}
+
+// parseRefTime parses the time of an @ reference: a date or time in local
+// time, or an age in hours, days or weeks before now
+func parseRefTime(s string, now time.Time) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	for _, layout := range timeLayouts {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			// A bare date means the end of that day
+			if layout == "2006-01-02" {
+				t = t.AddDate(0, 0, 1).Add(-time.Nanosecond)
+			}
+			return t, nil
+		}
+	}
+
+	if len(s) >= 2 {
+		n, err := strconv.Atoi(s[:len(s)-1])
+		if err == nil && n >= 0 {
+			switch s[len(s)-1] {
+			case 'h':
+				return now.Add(-time.Duration(n) * time.Hour), nil
+			case 'd':
+				return now.AddDate(0, 0, -n), nil
+			case 'w':
+				return now.AddDate(0, 0, -7*n), nil
+			}
+		}
+	}
+	return time.Time{}, fmt.Errorf("expected a date, a time or an age such as 3d")
+}
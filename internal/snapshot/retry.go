@@ -0,0 +1,33 @@
+package snapshot
+
+import (
+	"errors"
+	"io/fs"
+	"syscall"
+	"time"
+)
+
+// FailedFile is a file left out of a snapshot because it could not be read
+// after every retry
+type FailedFile struct {
+	Path string // Relative to the backup root
+	Err  error
+}
+
+// SetRetries sets how many extra passes Create makes over files that failed
+// transiently, and the wait before the first pass, doubled after each
+func (m *Manager) SetRetries(retries int, delay time.Duration) {
+	m.retries = retries
+	m.retryDelay = delay
+}
+
+// Failed returns the files the last Create left out of its snapshot
+func (m *Manager) Failed() []FailedFile {
+	return m.failed
+}
+
+// transient reports whether a file error may clear up on a later attempt:
+// the file vanished, was busy, or another process held a lock on it
+func transient(err error) bool {
+	return errors.Is(err, fs.ErrNotExist) || errors.Is(err, syscall.EBUSY) || lockViolation(err)
+}
@@ -0,0 +1,8 @@
+//go:build !windows
+
+package snapshot
+
+// lockViolation is Windows-specific; other systems report busy files as EBUSY
+func lockViolation(err error) bool {
+	return false
+}
@@ -0,0 +1,13 @@
+package snapshot
+
+import (
+	"errors"
+
+	"golang.org/x/sys/windows"
+)
+
+// lockViolation reports whether another process has the file open without
+// sharing or holds a byte-range lock on it
+func lockViolation(err error) bool {
+	return errors.Is(err, windows.ERROR_SHARING_VIOLATION) || errors.Is(err, windows.ERROR_LOCK_VIOLATION)
+}
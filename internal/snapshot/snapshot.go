@@ -1,31 +1,74 @@
 package snapshot
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"sort"
+	"sync"
 	"time"
 
+	"github.com/snapsync/snapsync/internal/appdata"
 	"github.com/snapsync/snapsync/internal/chunker"
 	"github.com/snapsync/snapsync/internal/compress"
 	"github.com/snapsync/snapsync/internal/crypto"
 	"github.com/snapsync/snapsync/internal/diff"
+	"github.com/snapsync/snapsync/internal/progress"
 	"github.com/snapsync/snapsync/internal/scanner"
 	"github.com/snapsync/snapsync/internal/store"
+	"github.com/snapsync/snapsync/internal/tuning"
 	"github.com/snapsync/snapsync/pkg/models"
 )
 
+// ErrRetentionLocked is returned when deleting a snapshot under a
+// retention lock
+var ErrRetentionLocked = errors.New("snapshot is under a retention lock")
+
+// InlineThreshold is the largest file stored inside the snapshot tree
+// instead of as a chunk object
+const InlineThreshold = 256
+
 // Manager handles snapshot creation and management
 type Manager struct {
-	repoPath   string
-	cas        *store.CAS
-	compressor *compress.Compressor
-	encryptor  *crypto.Encryptor
-	chunker    *chunker.Chunker
-	scanner    *scanner.Scanner
-	differ     *diff.Differ
+	repoPath     string
+	cas          *store.CAS
+	index        *store.FileIndex
+	filter       *store.Bloom // Chunk existence filter, loaded on first backup
+	compressor   *compress.Compressor
+	encryptor    *crypto.Encryptor
+	chunker      chunker.Splitter
+	scanner      *scanner.Scanner
+	scanWorkers  int // Scanner parallelism, 0 = default
+	scanRate     int // Scanner operations per second, 0 = unlimited
+	differ       *diff.Differ
+	tags         []string
+	mmap         bool
+	limiter      *tuning.Limiter
+	budget       *tuning.Budget
+	trees        map[string]*treeObject // Decoded directory objects by hash
+	encryptNames bool                   // Encrypt names and tree objects
+	retainUntil  *time.Time             // Retention lock for new snapshots
+	tier         string                 // Retention tier for new snapshots
+	expiresAt    *time.Time             // Expiry of new snapshots
+	indexPaths   bool                   // Keep the filename index up to date
+	progress     *progress.Tracker      // Live status for snapsync top
+	retries      int                    // Passes over transiently failed files
+	retryDelay   time.Duration          // Wait before the first retry pass
+	failed       []FailedFile           // Files the last Create left out
+	parentChunks map[string]bool        // Chunks of the parent while Create runs
+	metadataOnly bool                   // Record new snapshots without contents
+	parallel     Parallelism            // Workers per backup stage
+	pipeline     *chunkPipeline         // Encode and write workers while Create runs
+	bucket       *bucketSource          // Bucket read while CreateFromBucket runs
+	appData      *appdata.Rules         // App data presets for new snapshots
+	frozen       bool                   // Sources are filesystem snapshots
+	viewRoot     string                 // Source recorded while CreateFromView runs
+	tempDir      string                 // Where live files are copied, "" = system temp
 }
 
 // NewManager creates a new snapshot manager
@@ -38,61 +81,176 @@ func NewManager(repoPath string, compressor *compress.Compressor, encryptor *cry
 	return &Manager{
 		repoPath:   repoPath,
 		cas:        cas,
+		index:      store.LoadFileIndex(repoPath),
 		compressor: compressor,
 		encryptor:  encryptor,
 		chunker:    chunker.NewDefault(),
 		scanner:    scanner.New(nil, 4),
 		differ:     diff.New(),
+		limiter:    tuning.NewLimiter(0),
+		trees:      make(map[string]*treeObject),
 	}, nil
 }
 
 // SetExclusions sets file exclusion patterns
 func (m *Manager) SetExclusions(patterns []string) {
 	m.scanner = scanner.New(patterns, 4)
+	m.scanner.SetLimits(m.scanWorkers, m.scanRate)
+	m.scanner.SetAppData(m.appData)
+}
+
+// SetScanLimits bounds the load scanning puts on the source file system:
+// workers directories listed and files hashed at once, and opsPerSecond
+// stat, readdir and open calls in total; zero leaves either at its default
+func (m *Manager) SetScanLimits(workers, opsPerSecond int) {
+	m.scanWorkers = workers
+	m.scanRate = opsPerSecond
+	m.scanner.SetLimits(workers, opsPerSecond)
+}
+
+// SetChunker sets the chunker used to split file contents
+func (m *Manager) SetChunker(c chunker.Splitter) {
+	m.chunker = c
+}
+
+// SetMemoryBudget bounds the file data buffered at once
+func (m *Manager) SetMemoryBudget(b *tuning.Budget) {
+	m.budget = b
+}
+
+// SetLimiter sets the limiter bounding concurrent chunking work
+func (m *Manager) SetLimiter(l *tuning.Limiter) {
+	m.limiter = l
+}
+
+// SetMmap enables memory-mapped reads when chunking files
+func (m *Manager) SetMmap(enabled bool) {
+	m.mmap = enabled
+}
+
+// SetEncryptedNames encrypts file names and tree objects in new snapshots
+// It has no effect without an encryptor.
+func (m *Manager) SetEncryptedNames(enabled bool) {
+	m.encryptNames = enabled
+}
+
+// SetRetainUntil locks new snapshots against deletion until t
+func (m *Manager) SetRetainUntil(t time.Time) {
+	m.retainUntil = &t
+}
+
+// SetProgress reports the progress of Create to t
+func (m *Manager) SetProgress(t *progress.Tracker) {
+	m.progress = t
+}
+
+// SetExpiry makes new snapshots expire at t
+func (m *Manager) SetExpiry(t time.Time) {
+	m.expiresAt = &t
+}
+
+// SetTier pins new snapshots to a retention tier
+func (m *Manager) SetTier(tier string) {
+	m.tier = tier
+}
+
+// SetTags sets the tags recorded on snapshots created by this manager
+func (m *Manager) SetTags(tags []string) {
+	m.tags = tags
+}
+
+// SetMetadataOnly makes new snapshots record the file tree, with sizes and
+// content hashes, without storing file contents
+func (m *Manager) SetMetadataOnly(enabled bool) {
+	m.metadataOnly = enabled
+}
+
+// SetTempDir sets where live databases and app data are copied before they
+// are stored
+// Empty uses the system temporary directory.
+func (m *Manager) SetTempDir(dir string) {
+	m.tempDir = dir
 }
 
 // Create creates a new snapshot of the source path
 func (m *Manager) Create(sourcePath, description string, parentID string) (*models.Snapshot, error) {
-	startTime := time.Now()
+	return m.create(description, parentID, func(*models.FileTree) (*models.FileTree, error) {
+		return m.scanner.ScanWithHashes(sourcePath)
+	})
+}
 
-	// Scan source directory
-	tree, err := m.scanner.ScanWithHashes(sourcePath)
+// CreateFromView creates a new snapshot of sourcePath, reading it from
+// viewPath, a frozen view of it such as a filesystem snapshot mount. The
+// snapshot records sourcePath as its root and the paths of its files, so
+// it is grouped and searched like a plain backup of sourcePath.
+func (m *Manager) CreateFromView(sourcePath, viewPath, description, parentID string) (*models.Snapshot, error) {
+	root, err := filepath.Abs(sourcePath)
 	if err != nil {
-		return nil, fmt.Errorf("scan failed: %w", err)
+		return nil, err
 	}
+	m.viewRoot = root
+	defer func() { m.viewRoot = "" }()
+	return m.create(description, parentID, func(*models.FileTree) (*models.FileTree, error) {
+		return m.scanner.ScanWithHashes(viewPath)
+	})
+}
+
+// create creates a snapshot of the tree scan builds, given the parent's
+// tree or nil
+func (m *Manager) create(description, parentID string, scan func(parent *models.FileTree) (*models.FileTree, error)) (*models.Snapshot, error) {
+	startTime := time.Now()
+	m.failed = nil
 
 	// Get parent snapshot for incremental backup
 	var parentTree *models.FileTree
+	reuseParent := false
 	if parentID != "" {
 		parent, err := m.Get(parentID)
 		if err == nil && parent != nil {
 			parentTree = parent.Tree
+			// A metadata-only parent has no contents to carry over, only
+			// the file list changes are counted against
+			reuseParent = !parent.MetadataOnly
 		}
 	}
 
+	// Scan source directory
+	m.progress.SetPhase("scanning")
+	tree, err := scan(parentTree)
+	if err != nil {
+		return nil, fmt.Errorf("scan failed: %w", err)
+	}
+
 	// Calculate diff if we have a parent
 	var diffResult *diff.DiffResult
 	if parentTree != nil {
 		diffResult = m.differ.Compare(parentTree, tree)
 	}
 
+	// Reused chunks are told apart by whether the parent had them
+	m.parentChunks = make(map[string]bool)
+	defer func() { m.parentChunks = nil }()
+	if reuseParent {
+		for _, node := range parentTree.Files {
+			for _, hash := range node.Chunks {
+				m.parentChunks[hash] = true
+			}
+		}
+	}
+
 	// Create snapshot
-	snapshot := &models.Snapshot{
-		ID:          generateID(),
-		Timestamp:   time.Now(),
-		Parent:      parentID,
-		Description: description,
-		Tree:        tree,
-		Compressed:  m.compressor != nil,
-		Encrypted:   m.encryptor != nil,
+	snapshot, err := m.newSnapshot(tree, description, parentID)
+	if err != nil {
+		return nil, err
 	}
 
 	// Process files and store chunks
-	var newChunks, totalChunks int
-	var storedSize int64
+	var totals fileResult
 
 	filesToProcess := tree.Files
-	if diffResult != nil {
+	if m.metadataOnly {
+		filesToProcess = make(map[string]*models.FileNode)
+	} else if diffResult != nil && reuseParent {
 		// Only process changed files
 		filesToProcess = make(map[string]*models.FileNode)
 		for _, d := range diffResult.AllChangedFiles() {
@@ -102,73 +260,154 @@ func (m *Manager) Create(sourcePath, description string, parentID string) (*mode
 		for _, d := range diffResult.Unchanged {
 			if node, exists := tree.Files[d.Path]; exists {
 				node.Chunks = parentTree.Files[d.Path].Chunks
+				node.Inline = parentTree.Files[d.Path].Inline
+				node.CopyHash = parentTree.Files[d.Path].CopyHash
+				totals.fromParent(node)
+			}
+		}
+		// Moved files keep the chunks stored under their old path
+		for _, d := range diffResult.Renamed {
+			if node, exists := tree.Files[d.Path]; exists {
+				node.Chunks = parentTree.Files[d.OldPath].Chunks
+				node.Inline = parentTree.Files[d.OldPath].Inline
+				node.CopyHash = parentTree.Files[d.OldPath].CopyHash
+				totals.fromParent(node)
 			}
 		}
 	}
 
-	for relPath, node := range filesToProcess {
-		if node.IsDir {
-			continue
+	if m.filter == nil {
+		if m.filter, err = store.LoadBloom(m.repoPath, m.cas); err != nil {
+			return nil, err
 		}
+	}
 
-		// Read and chunk file
-		file, err := os.Open(node.Path)
-		if err != nil {
-			return nil, fmt.Errorf("failed to open %s: %w", relPath, err)
+	var pending int
+	var pendingSize int64
+	for _, node := range filesToProcess {
+		if !node.IsDir {
+			pending++
+			pendingSize += node.Size
 		}
+	}
+	m.progress.SetTotals(pending, pendingSize)
 
-		chunks, err := m.chunker.Chunk(file)
-		file.Close()
-		if err != nil {
-			return nil, fmt.Errorf("failed to chunk %s: %w", relPath, err)
+	// Capture live SQLite databases consistently before chunking
+	m.progress.SetPhase("capturing databases")
+	databases := m.captureDatabases(tree, filesToProcess)
+	defer databases.Close()
+
+	// Copy what running applications rewrite in place
+	m.progress.SetPhase("capturing app data")
+	apps, err := m.captureAppData(tree, filesToProcess, databases)
+	if err != nil {
+		return nil, err
+	}
+	defer apps.Close()
+
+	m.progress.SetPhase("storing")
+	m.progress.SetQueue("files", pending)
+
+	// Files are read and chunked on several workers at once, feeding a
+	// shared pool of encode and write workers
+	m.pipeline = m.startPipeline(m.parallel)
+	defer func() {
+		m.pipeline.close()
+		m.pipeline = nil
+	}()
+
+	var mu sync.Mutex
+	store := func(worker int, relPath string) error {
+		node := filesToProcess[relPath]
+		m.progress.WorkerStarted(worker, relPath)
+
+		// Read from the file's consistent copy if it has one
+		readPath, captured := databases.paths[relPath]
+		if !captured {
+			readPath, captured = apps.paths[relPath]
+		}
+		if !captured {
+			readPath = node.Path
 		}
 
-		// Store chunks
-		var chunkHashes []string
-		for _, chunk := range chunks {
-			data := chunk.Data
+		reserved := m.budget.Reserve(node.Size)
+		var result *fileResult
+		var err error
+		if m.bucket != nil {
+			result, err = m.storeObject(relPath, node)
+		} else {
+			result, err = m.storeFile(relPath, node, readPath, captured)
+		}
+		m.budget.Release(reserved)
+		if err != nil {
+			m.progress.Error(err)
+			return err
+		}
+		m.progress.FileDone(worker, node.Size)
 
-			// Compress if enabled
-			if m.compressor != nil {
-				data, err = m.compressor.Compress(data)
-				if err != nil {
-					return nil, fmt.Errorf("compression failed: %w", err)
-				}
-			}
+		mu.Lock()
+		tree.TotalSize += result.sizeDelta
+		totals.add(result)
+		mu.Unlock()
+		return nil
+	}
 
-			// Encrypt if enabled
-			if m.encryptor != nil {
-				data, err = m.encryptor.Encrypt(data)
-				if err != nil {
-					return nil, fmt.Errorf("encryption failed: %w", err)
-				}
-			}
+	// Files that are busy or vanished are retried after the rest
+	paths := make([]string, 0, pending)
+	for relPath, node := range filesToProcess {
+		if !node.IsDir {
+			paths = append(paths, relPath)
+		}
+	}
+	retry, err := m.storeFiles(paths, "files", store)
+	if err != nil {
+		return nil, err
+	}
 
-			// Store in CAS
-			if !m.cas.Has(chunk.Hash) {
-				_, err = m.cas.Put(data)
-				if err != nil {
-					return nil, fmt.Errorf("storage failed: %w", err)
-				}
-				newChunks++
-				storedSize += int64(len(data))
-			}
+	delay := m.retryDelay
+	for pass := 0; pass < m.retries && len(retry) > 0; pass++ {
+		m.progress.SetPhase("retrying")
+		m.progress.SetQueue("retry", len(retry))
+		time.Sleep(delay)
+		delay *= 2
 
-			chunkHashes = append(chunkHashes, chunk.Hash)
-			totalChunks++
+		paths = paths[:0]
+		for relPath := range retry {
+			paths = append(paths, relPath)
+		}
+		if retry, err = m.storeFiles(paths, "retry", store); err != nil {
+			return nil, err
 		}
+	}
+
+	// Whatever still fails is left out of the snapshot and reported
+	for relPath, err := range retry {
+		node := tree.Files[relPath]
+		delete(tree.Files, relPath)
+		tree.FileCount--
+		tree.TotalSize -= node.Size
+		m.failed = append(m.failed, FailedFile{Path: relPath, Err: err})
+	}
+	sort.Slice(m.failed, func(i, j int) bool { return m.failed[i].Path < m.failed[j].Path })
+
+	// A frozen view was read in place of the source, which is recorded
+	if m.viewRoot != "" {
+		relocateTree(tree, m.viewRoot)
+	}
 
-		tree.Files[relPath].Chunks = chunkHashes
+	m.progress.SetPhase("saving")
+	if err := m.index.Save(); err != nil {
+		return nil, fmt.Errorf("failed to save file index: %w", err)
+	}
+	if err := m.filter.Save(); err != nil {
+		return nil, fmt.Errorf("failed to save chunk filter: %w", err)
 	}
 
 	// Update stats
-	snapshot.Stats = models.SnapshotStats{
-		TotalSize:        tree.TotalSize,
-		StoredSize:       storedSize,
-		ChunkCount:       totalChunks,
-		NewChunks:        newChunks,
-		DeduplicatedSize: tree.TotalSize - storedSize,
-		Duration:         time.Since(startTime),
+	snapshot.Stats = totals.stats(tree.TotalSize)
+	snapshot.Stats.Duration = time.Since(startTime)
+	if m.metadataOnly {
+		snapshot.Stats.DeduplicatedSize = 0
 	}
 
 	if diffResult != nil {
@@ -176,32 +415,411 @@ func (m *Manager) Create(sourcePath, description string, parentID string) (*mode
 		snapshot.Stats.FilesModified = len(diffResult.Modified)
 		snapshot.Stats.FilesDeleted = len(diffResult.Deleted)
 		snapshot.Stats.FilesUnchanged = len(diffResult.Unchanged)
+		snapshot.Stats.FilesRenamed = len(diffResult.Renamed)
 	} else {
 		snapshot.Stats.FilesAdded = tree.FileCount
 	}
+	snapshot.Stats.FilesFailed = len(m.failed)
 
 	// Save snapshot metadata
 	if err := m.saveSnapshot(snapshot); err != nil {
 		return nil, fmt.Errorf("failed to save snapshot: %w", err)
 	}
 
+	// The filename index is a cache; a stale one is rebuilt next time
+	if m.indexPaths {
+		if err := m.updatePathIndex(snapshot); err != nil {
+			m.dropPathIndex()
+		}
+	}
+
 	return snapshot, nil
 }
 
+// relocateTree moves the paths recorded in tree from its scanned root to
+// root, keeping each file's place under it
+func relocateTree(tree *models.FileTree, root string) {
+	scanned := tree.Root.Path
+	tree.Root.Path = root
+	tree.Root.Name = filepath.Base(root)
+	for _, node := range tree.Files {
+		if rel, err := filepath.Rel(scanned, node.Path); err == nil {
+			node.Path = filepath.Join(root, rel)
+		}
+	}
+}
+
+// newSnapshot creates the record of a snapshot of tree with the settings
+// for new snapshots, linked into the snapshot chain
+func (m *Manager) newSnapshot(tree *models.FileTree, description, parentID string) (*models.Snapshot, error) {
+	hostname, _ := os.Hostname()
+	snapshot := &models.Snapshot{
+		ID:          generateID(),
+		Timestamp:   time.Now(),
+		Parent:      parentID,
+		Description: description,
+		Hostname:    hostname,
+		Tags:        m.tags,
+		Tree:        tree,
+		Compressed:  m.compressor != nil,
+		Encrypted:   m.encryptor != nil,
+
+		EncryptedNames: m.encryptNames && m.encryptor != nil,
+		RetainUntil:    m.retainUntil,
+		Tier:           m.tier,
+		ExpiresAt:      m.expiresAt,
+		MetadataOnly:   m.metadataOnly,
+	}
+	if err := m.linkChain(snapshot); err != nil {
+		return nil, fmt.Errorf("failed to link snapshot chain: %w", err)
+	}
+	return snapshot, nil
+}
+
+// fileResult summarizes the chunks stored for one file, or for a whole
+// snapshot when added up
+type fileResult struct {
+	newChunks    int
+	newSize      int64 // Original size of the new chunks
+	parentChunks int   // Chunks the parent snapshot already had
+	parentSize   int64
+	reusedChunks int // Chunks already stored for other snapshots or files
+	reusedSize   int64
+	totalChunks  int
+	storedSize   int64
+	sizeDelta    int64 // Change in file size when a captured copy was stored
+}
+
+// add adds the chunks of another result
+func (r *fileResult) add(o *fileResult) {
+	r.newChunks += o.newChunks
+	r.newSize += o.newSize
+	r.parentChunks += o.parentChunks
+	r.parentSize += o.parentSize
+	r.reusedChunks += o.reusedChunks
+	r.reusedSize += o.reusedSize
+	r.totalChunks += o.totalChunks
+	r.storedSize += o.storedSize
+}
+
+// fromParent counts a file whose chunk list was copied from the parent
+func (r *fileResult) fromParent(node *models.FileNode) {
+	if len(node.Chunks) > 0 {
+		r.parentChunks += len(node.Chunks)
+		r.parentSize += node.Size
+	}
+}
+
+// reuse counts a chunk that is already stored, by whether the parent
+// snapshot has it
+func (r *fileResult) reuse(fromParent bool, size int64) {
+	if fromParent {
+		r.parentChunks++
+		r.parentSize += size
+	} else {
+		r.reusedChunks++
+		r.reusedSize += size
+	}
+}
+
+// stats returns the snapshot statistics of a whole snapshot's result
+func (r *fileResult) stats(totalSize int64) models.SnapshotStats {
+	return models.SnapshotStats{
+		TotalSize:        totalSize,
+		StoredSize:       r.storedSize,
+		ChunkCount:       r.totalChunks,
+		NewChunks:        r.newChunks,
+		NewChunkSize:     r.newSize,
+		ParentChunks:     r.parentChunks,
+		ParentSize:       r.parentSize,
+		ReusedChunks:     r.reusedChunks,
+		ReusedSize:       r.reusedSize,
+		DeduplicatedSize: totalSize - r.storedSize,
+	}
+}
+
+// storeFile chunks the file at readPath, stores new chunks and records the
+// chunk list on node
+func (m *Manager) storeFile(relPath string, node *models.FileNode, readPath string, captured bool) (*fileResult, error) {
+	result := &fileResult{}
+	if captured {
+		defer keepScanHash(node, node.Hash)
+	}
+
+	// Tiny files live in the tree rather than in objects of their own
+	if node.Size > 0 && node.Size <= InlineThreshold && !node.IsBlockDevice() {
+		scannedSize := node.Size
+		inlined, err := m.inlineFile(node, readPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", relPath, err)
+		}
+		if inlined {
+			result.sizeDelta = node.Size - scannedSize
+			return result, nil
+		}
+	}
+
+	// Content the repository already holds needs no chunking
+	if !captured && m.reuseIndexed(node, result) {
+		return result, nil
+	}
+
+	file, err := chunker.Open(readPath, m.mmap)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", relPath, err)
+	}
+	defer file.Close()
+
+	// Captured copies and files the scan could not read are hashed here
+	hashing := captured || node.Hash == ""
+	reader := io.Reader(file)
+	fileHasher := sha256.New()
+	if hashing {
+		reader = io.TeeReader(file, fileHasher)
+	}
+
+	split := func(fn func(*models.Chunk) error) error {
+		var size int64
+		count := func(chunk *models.Chunk) error {
+			size += chunk.Size
+			return fn(chunk)
+		}
+
+		var err error
+		if ps, ok := m.chunker.(chunker.ParallelSplitter); ok && !hashing && node.Size >= chunker.ParallelThreshold {
+			// Spread very large files across all cores
+			err = ps.ChunkParallel(file, node.Size, m.limiter, count)
+		} else {
+			err = m.chunker.ChunkStream(reader, count)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to chunk %s: %w", relPath, err)
+		}
+
+		// The copy is what gets stored, so describe it rather than the live file
+		if captured {
+			result.sizeDelta = size - node.Size
+			node.Size = size
+		}
+		if hashing {
+			node.Hash = hex.EncodeToString(fileHasher.Sum(nil))
+		}
+		return nil
+	}
+
+	if err := m.storeChunks(node, split, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// keepScanHash puts back the hash the scan took of a live file after its
+// captured copy was stored, keeping the copy's hash as CopyHash when they
+// differ, so the next backup compares against the live file
+func keepScanHash(node *models.FileNode, scanHash string) {
+	node.CopyHash = ""
+	if scanHash == "" || node.Hash == scanHash {
+		return
+	}
+	node.CopyHash = node.Hash
+	node.Hash = scanHash
+}
+
+// reuseIndexed records on node the chunks the file index lists for its
+// content, if all of them are stored, and reports whether it did
+func (m *Manager) reuseIndexed(node *models.FileNode, result *fileResult) bool {
+	if node.Hash == "" {
+		return false
+	}
+	chunks, ok := m.index.Lookup(node.Hash)
+	if !ok || !m.hasChunks(chunks) {
+		return false
+	}
+
+	node.Chunks = chunks
+	result.totalChunks = len(chunks)
+	// Chunk sizes are not indexed, so the file's size is shared out
+	for i, hash := range chunks {
+		size := node.Size * int64(i+1) / int64(len(chunks))
+		size -= node.Size * int64(i) / int64(len(chunks))
+		result.reuse(m.parentChunks[hash], size)
+	}
+	return true
+}
+
+// storeChunks stores the chunks split passes on that are not yet in the
+// repository, records the chunk list on node and adds the stored chunks to
+// result
+// New chunks go to the encode and write workers as soon as they are cut, so
+// a file's data is held only while its chunks are in the pipeline. A failed
+// chunk stops split. node.Hash is read once split returns, so split may set
+// it from the data it read.
+func (m *Manager) storeChunks(node *models.FileNode, split func(fn func(*models.Chunk) error) error, result *fileResult) error {
+	pipe := m.pipeline
+	if pipe == nil {
+		pipe = m.startPipeline(m.parallel)
+		defer pipe.close()
+	}
+
+	// Each chunk is recorded by hash and size alone, so its data is dropped
+	// once stored
+	type cut struct {
+		hash string
+		size int64
+		job  *chunkJob // Set if the chunk was handed to the workers
+	}
+	var (
+		cuts   []cut
+		batch  chunkBatch
+		queued = make(map[string]bool)
+	)
+	err := split(func(chunk *models.Chunk) error {
+		if err := batch.failure(); err != nil {
+			return err
+		}
+		c := cut{hash: chunk.Hash, size: chunk.Size}
+		if !queued[chunk.Hash] && !m.existingChunks([]string{chunk.Hash})[chunk.Hash] {
+			queued[chunk.Hash] = true
+			c.job = pipe.submit(&batch, chunk)
+		}
+		cuts = append(cuts, c)
+		return nil
+	})
+	batch.done.Wait()
+	if failed := batch.failure(); failed != nil {
+		return failed
+	}
+	if err != nil {
+		return err
+	}
+
+	chunkHashes := make([]string, 0, len(cuts))
+	for _, c := range cuts {
+		if c.job != nil && c.job.stored {
+			result.newChunks++
+			result.newSize += c.size
+			result.storedSize += c.job.size
+		} else {
+			result.reuse(m.parentChunks[c.hash], c.size)
+		}
+
+		chunkHashes = append(chunkHashes, c.hash)
+		result.totalChunks++
+	}
+
+	node.Chunks = chunkHashes
+	if node.Hash != "" {
+		m.index.Add(node.Hash, chunkHashes)
+	}
+	return nil
+}
+
+// encodeChunk compresses and encrypts chunk data as the repository stores it
+func (m *Manager) encodeChunk(data []byte) ([]byte, error) {
+	var err error
+
+	// Compress if enabled
+	if m.compressor != nil {
+		data, err = m.compressor.Compress(data)
+		if err != nil {
+			return nil, fmt.Errorf("compression failed: %w", err)
+		}
+	}
+
+	// Encrypt if enabled
+	if m.encryptor != nil {
+		data, err = m.encryptor.Encrypt(data)
+		if err != nil {
+			return nil, fmt.Errorf("encryption failed: %w", err)
+		}
+	}
+	return data, nil
+}
+
+// inlineFile stores the contents of a tiny file on its node
+// Returns false if the file grew past the threshold since it was scanned.
+func (m *Manager) inlineFile(node *models.FileNode, readPath string) (bool, error) {
+	data, err := os.ReadFile(readPath)
+	if err != nil {
+		return false, err
+	}
+	if len(data) > InlineThreshold {
+		return false, nil
+	}
+	return true, m.inlineData(node, data)
+}
+
+// inlineData stores data on node as the contents of a tiny file
+func (m *Manager) inlineData(node *models.FileNode, data []byte) error {
+	hash := sha256.Sum256(data)
+	node.Hash = hex.EncodeToString(hash[:])
+
+	// Compression gains nothing at this size, but the tree must not leak
+	// plaintext when the repository is encrypted
+	inline := data
+	if m.encryptor != nil {
+		var err error
+		inline, err = m.encryptor.Encrypt(data)
+		if err != nil {
+			return fmt.Errorf("encryption failed: %w", err)
+		}
+	}
+
+	node.Size = int64(len(data))
+	node.Inline = inline
+	node.Chunks = nil
+	return nil
+}
+
+// hasChunks reports whether every chunk in the list is stored
+func (m *Manager) hasChunks(chunks []string) bool {
+	existing := m.existingChunks(chunks)
+	for _, hash := range chunks {
+		if !existing[hash] {
+			return false
+		}
+	}
+	return true
+}
+
+// existingChunks reports which chunks are stored
+// The filter answers most new chunks without touching the store; the rest
+// are checked in a single batch.
+func (m *Manager) existingChunks(hashes []string) map[string]bool {
+	var candidates []string
+	for _, hash := range hashes {
+		if m.filter.MayContain(hash) {
+			candidates = append(candidates, hash)
+		}
+	}
+
+	return m.cas.HasMany(candidates)
+}
+
 // Get retrieves a snapshot by ID
 func (m *Manager) Get(id string) (*models.Snapshot, error) {
-	path := filepath.Join(m.repoPath, "snapshots", id+".json")
-	data, err := os.ReadFile(path)
+	snapshot, err := m.readRecord(id)
 	if err != nil {
 		return nil, err
 	}
 
-	var snapshot models.Snapshot
-	if err := json.Unmarshal(data, &snapshot); err != nil {
-		return nil, err
+	if snapshot.EncryptedNames {
+		// Without the key only the record's totals are readable
+		if m.encryptor == nil {
+			return snapshot, nil
+		}
+		if err := m.openNames(snapshot.Tree); err != nil {
+			return nil, err
+		}
 	}
 
-	return &snapshot, nil
+	if snapshot.TreeHash != "" && snapshot.Tree != nil {
+		if err := m.loadTree(snapshot.Tree, snapshot.TreeHash, snapshot.EncryptedNames); err != nil {
+			return nil, err
+		}
+	}
+
+	return snapshot, nil
 }
 
 // List returns all snapshots sorted by timestamp (newest first)
@@ -237,12 +855,139 @@ func (m *Manager) List() ([]*models.Snapshot, error) {
 	return snapshots, nil
 }
 
+// ListRecords returns the snapshot records newest first without loading
+// their trees, for callers that only need IDs, times, tags and roots
+// Unreadable records are skipped, as in List. Encrypted root names are
+// decrypted when the manager has the key.
+func (m *Manager) ListRecords() ([]*models.Snapshot, error) {
+	ids, err := m.SnapshotIDs()
+	if err != nil {
+		return nil, err
+	}
+
+	records := make([]*models.Snapshot, 0, len(ids))
+	for id := range ids {
+		record, err := m.readRecord(id)
+		if err != nil {
+			continue
+		}
+		if record.EncryptedNames && m.encryptor != nil {
+			if err := m.openNames(record.Tree); err != nil {
+				return nil, err
+			}
+		}
+		records = append(records, record)
+	}
+
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].Timestamp.After(records[j].Timestamp)
+	})
+	return records, nil
+}
+
 // Delete removes a snapshot
+// Snapshots under a retention lock cannot be deleted until it expires.
 func (m *Manager) Delete(id string) error {
+	record, err := m.readRecord(id)
+	if err != nil {
+		return err
+	}
+	if record.RetentionLocked(time.Now()) {
+		return fmt.Errorf("%w until %s: %s", ErrRetentionLocked,
+			record.RetainUntil.Format(time.RFC3339), id)
+	}
+
 	path := filepath.Join(m.repoPath, "snapshots", id+".json")
 	return os.Remove(path)
 }
 
+// RemoveExpired deletes snapshots whose expiry time has passed and returns
+// their IDs
+// Expired snapshots still under a retention lock are kept until it ends.
+// Their data stays in the store until unreferenced objects are removed.
+func (m *Manager) RemoveExpired() ([]string, error) {
+	records, _, err := m.readRecords()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	var removed []string
+	for _, record := range records {
+		if !record.Expired(now) || record.RetentionLocked(now) {
+			continue
+		}
+		if err := m.Delete(record.ID); err != nil {
+			return removed, fmt.Errorf("failed to delete snapshot %s: %w", record.ID, err)
+		}
+		removed = append(removed, record.ID)
+	}
+	return removed, nil
+}
+
+// SetRetention locks a snapshot against deletion until t
+// A lock can be extended but never shortened or removed.
+func (m *Manager) SetRetention(id string, t time.Time) error {
+	record, err := m.readRecord(id)
+	if err != nil {
+		return err
+	}
+	if record.RetainUntil != nil && t.Before(*record.RetainUntil) {
+		return fmt.Errorf("%w until %s: retention can only be extended", ErrRetentionLocked,
+			record.RetainUntil.Format(time.RFC3339))
+	}
+
+	record.RetainUntil = &t
+	return m.writeRecord(record)
+}
+
+// SetSnapshotTier pins a snapshot to a retention tier, or unpins it when
+// tier is empty
+func (m *Manager) SetSnapshotTier(id, tier string) error {
+	record, err := m.readRecord(id)
+	if err != nil {
+		return err
+	}
+
+	record.Tier = tier
+	return m.writeRecord(record)
+}
+
+// readRecord reads a snapshot record as stored, without loading its tree
+func (m *Manager) readRecord(id string) (*models.Snapshot, error) {
+	path := filepath.Join(m.repoPath, "snapshots", id+".json")
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var record models.Snapshot
+	if err := json.Unmarshal(data, &record); err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+// writeRecord writes a snapshot record as is
+func (m *Manager) writeRecord(record *models.Snapshot) error {
+	data, err := json.MarshalIndent(record, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// Renamed into place so a concurrent reader never sees half a record
+	path := filepath.Join(m.repoPath, "snapshots", record.ID+".json")
+	if err := os.WriteFile(path+".tmp", data, 0644); err != nil {
+		os.Remove(path + ".tmp")
+		return err
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		os.Remove(path + ".tmp")
+		return err
+	}
+	return nil
+}
+
 // Latest returns the most recent snapshot
 func (m *Manager) Latest() (*models.Snapshot, error) {
 	snapshots, err := m.List()
@@ -262,13 +1007,46 @@ func (m *Manager) saveSnapshot(snapshot *models.Snapshot) error {
 		return err
 	}
 
-	data, err := json.MarshalIndent(snapshot, "", "  ")
+	record, err := m.recordOf(snapshot, m.cas.Put)
+	if err != nil {
+		return err
+	}
+
+	hash, err := chainHash(record)
 	if err != nil {
 		return err
 	}
+	record.ChainHash = hash
+	snapshot.ChainHash = hash
 
-	path := filepath.Join(snapshotsDir, snapshot.ID+".json")
-	return os.WriteFile(path, data, 0644)
+	return m.writeRecord(record)
+}
+
+// recordOf returns the record of a snapshot as stored, writing its
+// directories with put as shared tree objects; the record keeps only the
+// root and the totals
+func (m *Manager) recordOf(snapshot *models.Snapshot, put putObject) (*models.Snapshot, error) {
+	record := *snapshot
+	if tree := snapshot.Tree; tree != nil && tree.Root != nil && tree.Root.IsDir {
+		hash, err := m.storeTree(tree, snapshot.EncryptedNames, put)
+		if err != nil {
+			return nil, err
+		}
+		treeCopy := *tree
+		treeCopy.Files = nil
+		record.Tree = &treeCopy
+		record.TreeHash = hash
+		snapshot.TreeHash = hash
+	}
+
+	if snapshot.EncryptedNames && record.Tree != nil {
+		sealedTree, err := m.sealNames(record.Tree)
+		if err != nil {
+			return nil, err
+		}
+		record.Tree = sealedTree
+	}
+	return &record, nil
 }
 
 // generateID creates a unique snapshot ID
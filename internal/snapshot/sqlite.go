@@ -0,0 +1,81 @@
+package snapshot
+
+import (
+	"fmt"
+
+	"github.com/snapsync/snapsync/internal/sqlitesnap"
+	"github.com/snapsync/snapsync/pkg/models"
+)
+
+// dbCaptures holds consistent copies of live SQLite databases
+type dbCaptures struct {
+	paths    map[string]string // Tree path -> copy to read instead of the live file
+	captures []*sqlitesnap.Capture
+}
+
+// captureDatabases copies every changed SQLite database, and every database
+// with a WAL, since WAL-mode writes do not touch the main file until a
+// checkpoint. Captured databases are added to filesToProcess.
+// A database that cannot be captured, e.g. because it keeps changing, is
+// left out with its WAL and reported as failed rather than stored torn.
+func (m *Manager) captureDatabases(tree *models.FileTree, filesToProcess map[string]*models.FileNode) *dbCaptures {
+	dc := &dbCaptures{paths: make(map[string]string)}
+
+	for relPath, node := range tree.Files {
+		if !node.SQLite {
+			continue
+		}
+
+		// The shared-memory index is rebuilt by SQLite on open
+		dropNode(tree, filesToProcess, relPath+sqlitesnap.SHMSuffix)
+
+		walRel := relPath + sqlitesnap.WALSuffix
+		_, hasWAL := tree.Files[walRel]
+		_, changed := filesToProcess[relPath]
+		if !changed && !hasWAL {
+			continue
+		}
+
+		capture, err := sqlitesnap.New(node.Path, m.tempDir)
+		if err != nil {
+			dropNode(tree, filesToProcess, relPath)
+			dropNode(tree, filesToProcess, walRel)
+			m.failed = append(m.failed, FailedFile{Path: relPath, Err: fmt.Errorf("failed to capture database: %w", err)})
+			continue
+		}
+		dc.captures = append(dc.captures, capture)
+
+		dc.paths[relPath] = capture.DBPath
+		filesToProcess[relPath] = node
+
+		if capture.WALPath != "" && hasWAL {
+			dc.paths[walRel] = capture.WALPath
+			filesToProcess[walRel] = tree.Files[walRel]
+		} else {
+			// The online backup folded the WAL into the copy
+			dropNode(tree, filesToProcess, walRel)
+		}
+	}
+
+	return dc
+}
+
+// Close removes all captured copies
+func (dc *dbCaptures) Close() {
+	for _, c := range dc.captures {
+		c.Close()
+	}
+}
+
+// dropNode removes a file from the tree and the processing set
+func dropNode(tree *models.FileTree, filesToProcess map[string]*models.FileNode, relPath string) {
+	node, exists := tree.Files[relPath]
+	if !exists {
+		return
+	}
+
+	delete(tree.Files, relPath)
+	delete(filesToProcess, relPath)
+	tree.FileCount--
+	tree.TotalSize -= node.Size
+}
@@ -0,0 +1,230 @@
+package snapshot
+
+import (
+	"sort"
+
+	"github.com/snapsync/snapsync/pkg/models"
+)
+
+// LargeFile is one of the largest files in a snapshot or the repository
+type LargeFile struct {
+	SnapshotID string `json:"snapshot_id"`
+	Path       string `json:"path"` // Relative to the backup root
+	Size       int64  `json:"size"`
+}
+
+// SnapshotStatistics extends a snapshot's storage usage with how its data
+// is shared and how it grew from the snapshot before it
+type SnapshotStatistics struct {
+	SnapshotUsage
+	Source        string `json:"source"`
+	Files         int    `json:"files"`
+	Chunks        int    `json:"chunks"`        // Distinct chunks referenced
+	UniqueChunks  int    `json:"unique_chunks"` // Referenced by no other snapshot
+	SharedObjects int    `json:"shared_objects"`
+	SharedSize    int64  `json:"shared_size"`
+	AddedSize     int64  `json:"added_size"`         // Stored size of objects no earlier snapshot references
+	Previous      string `json:"previous,omitempty"` // Previous snapshot of the source, if any
+	LogicalGrowth int64  `json:"logical_growth"`     // Change from the previous snapshot
+	FileGrowth    int    `json:"file_growth"`
+}
+
+// Statistics describes deduplication and compression across the repository
+// Chunk sizes before compression are not recorded, so ContentSize spreads
+// each file's size evenly over its chunks. Inline files live in tree
+// objects and are not counted as chunks.
+type Statistics struct {
+	Usage
+	Chunks             int                  `json:"chunks"`        // Distinct chunks referenced
+	UniqueChunks       int                  `json:"unique_chunks"` // Referenced by a single snapshot
+	SharedChunks       int                  `json:"shared_chunks"`
+	UniqueChunkSize    int64                `json:"unique_chunk_size"`
+	SharedChunkSize    int64                `json:"shared_chunk_size"`
+	ContentSize        int64                `json:"content_size"`        // Distinct chunk data before compression
+	ChunkStoredSize    int64                `json:"chunk_stored_size"`   // The same chunks as stored
+	CompressionSavings int64                `json:"compression_savings"` // ContentSize less ChunkStoredSize
+	Largest            []LargeFile          `json:"largest"`             // Distinct contents, newest snapshot holding each
+	Snapshots          []SnapshotStatistics `json:"snapshots"`           // Newest first
+}
+
+// DedupRatio returns the logical size of all snapshots over the size of the
+// distinct data they hold
+func (s *Statistics) DedupRatio() float64 {
+	if s.ContentSize == 0 {
+		return 0
+	}
+	return float64(s.LogicalSize) / float64(s.ContentSize)
+}
+
+// CompressionRatio returns the stored size of chunks over their size
+// before compression
+func (s *Statistics) CompressionRatio() float64 {
+	if s.ContentSize == 0 {
+		return 0
+	}
+	return float64(s.ChunkStoredSize) / float64(s.ContentSize)
+}
+
+// Statistics walks every snapshot and reports how its chunks are shared,
+// how much compression saves, the largest files and the growth between
+// consecutive snapshots of each source
+// top limits the largest files listed.
+func (m *Manager) Statistics(top int) (*Statistics, error) {
+	snapshots, err := m.records()
+	if err != nil {
+		return nil, err
+	}
+
+	usage, objects, err := m.usage(snapshots)
+	if err != nil {
+		return nil, err
+	}
+	stats := &Statistics{Usage: *usage, Snapshots: []SnapshotStatistics{}}
+
+	// Oldest first, so growth and added data compare with what came before
+	sort.Slice(snapshots, func(i, j int) bool {
+		return snapshots[i].Timestamp.Before(snapshots[j].Timestamp)
+	})
+
+	chunkOwners := make(map[string]int)
+	chunkContent := make(map[string]int64)
+	chunksBySnapshot := make(map[string]map[string]bool, len(snapshots))
+	largest := make(map[string]LargeFile) // By content hash
+	for _, snap := range snapshots {
+		chunks := make(map[string]bool)
+		for relPath, node := range snapshotFiles(snap) {
+			if node.IsDir {
+				continue
+			}
+			for _, hash := range node.Chunks {
+				chunks[hash] = true
+				if _, ok := chunkContent[hash]; !ok {
+					chunkContent[hash] = node.Size / int64(len(node.Chunks))
+				}
+			}
+			if node.Hash != "" {
+				largest[node.Hash] = LargeFile{SnapshotID: snap.ID, Path: relPath, Size: node.Size}
+			}
+		}
+		for hash := range chunks {
+			chunkOwners[hash]++
+		}
+		chunksBySnapshot[snap.ID] = chunks
+	}
+
+	for hash, owners := range chunkOwners {
+		size := objects.sizes[hash]
+		stats.Chunks++
+		stats.ContentSize += chunkContent[hash]
+		stats.ChunkStoredSize += size
+		if owners == 1 {
+			stats.UniqueChunks++
+			stats.UniqueChunkSize += size
+		} else {
+			stats.SharedChunks++
+			stats.SharedChunkSize += size
+		}
+	}
+	stats.CompressionSavings = stats.ContentSize - stats.ChunkStoredSize
+
+	stats.Largest = make([]LargeFile, 0, len(largest))
+	for _, file := range largest {
+		stats.Largest = append(stats.Largest, file)
+	}
+	sortLargest(stats.Largest)
+	if len(stats.Largest) > top {
+		stats.Largest = stats.Largest[:top]
+	}
+
+	byID := make(map[string]SnapshotUsage, len(usage.Snapshots))
+	for _, su := range usage.Snapshots {
+		byID[su.ID] = su
+	}
+
+	seen := make(map[string]bool)
+	previous := make(map[string]*models.Snapshot) // By source
+	for _, snap := range snapshots {
+		su := byID[snap.ID]
+		ss := SnapshotStatistics{
+			SnapshotUsage: su,
+			Source:        snapshotSource(snap),
+			Chunks:        len(chunksBySnapshot[snap.ID]),
+			SharedObjects: su.Objects - su.UniqueObjects,
+			SharedSize:    su.StoredSize - su.UniqueSize,
+		}
+		if snap.Tree != nil {
+			ss.Files = snap.Tree.FileCount
+		}
+		for hash := range chunksBySnapshot[snap.ID] {
+			if chunkOwners[hash] == 1 {
+				ss.UniqueChunks++
+			}
+		}
+		for hash := range objects.refs[snap.ID] {
+			if !seen[hash] {
+				seen[hash] = true
+				ss.AddedSize += objects.sizes[hash]
+			}
+		}
+		if prev := previous[ss.Source]; prev != nil {
+			ss.Previous = prev.ID
+			ss.LogicalGrowth = snap.Stats.TotalSize - prev.Stats.TotalSize
+			if prev.Tree != nil {
+				ss.FileGrowth = ss.Files - prev.Tree.FileCount
+			}
+		}
+		previous[ss.Source] = snap
+
+		stats.Snapshots = append(stats.Snapshots, ss)
+	}
+
+	sort.Slice(stats.Snapshots, func(i, j int) bool {
+		return stats.Snapshots[i].Timestamp.After(stats.Snapshots[j].Timestamp)
+	})
+	return stats, nil
+}
+
+// LargestFiles returns the n largest files in a snapshot
+func LargestFiles(snap *models.Snapshot, n int) []LargeFile {
+	var files []LargeFile
+	for relPath, node := range snapshotFiles(snap) {
+		if !node.IsDir {
+			files = append(files, LargeFile{SnapshotID: snap.ID, Path: relPath, Size: node.Size})
+		}
+	}
+	sortLargest(files)
+	if len(files) > n {
+		files = files[:n]
+	}
+	return files
+}
+
+// snapshotFiles returns a snapshot's files by relative path; a device
+// snapshot is its root node
+func snapshotFiles(snap *models.Snapshot) map[string]*models.FileNode {
+	if snap.Tree == nil {
+		return nil
+	}
+	if root := snap.Tree.Root; root != nil && root.IsBlockDevice() {
+		return map[string]*models.FileNode{".": root}
+	}
+	return snap.Tree.Files
+}
+
+// snapshotSource returns the backup root a snapshot was taken from
+func snapshotSource(snap *models.Snapshot) string {
+	if snap.Tree == nil || snap.Tree.Root == nil {
+		return ""
+	}
+	return snap.Tree.Root.Path
+}
+
+// sortLargest orders files by size, largest first, then by path
+func sortLargest(files []LargeFile) {
+	sort.Slice(files, func(i, j int) bool {
+		if files[i].Size != files[j].Size {
+			return files[i].Size > files[j].Size
+		}
+		return files[i].Path < files[j].Path
+	})
+}
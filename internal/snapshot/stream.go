@@ -0,0 +1,289 @@
+package snapshot
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/snapsync/snapsync/internal/store"
+	"github.com/snapsync/snapsync/pkg/models"
+)
+
+// StreamFile is a file whose content the caller supplies as a stream, such
+// as a database dump or data generated in memory
+// A directory needs no entry of its own unless its mode or time matter.
+type StreamFile struct {
+	Path    string      // Relative to the snapshot root, with / separators
+	Reader  io.Reader   // Read once to EOF, and closed if it is an io.Closer
+	Mode    os.FileMode // Permission bits, 0644 (0755 for directories) if zero
+	ModTime time.Time   // Time of the snapshot if zero
+	IsDir   bool        // A directory, with no reader
+}
+
+// StreamSource supplies the files of a snapshot one at a time
+// Next returns io.EOF once every file has been supplied.
+type StreamSource interface {
+	Next() (*StreamFile, error)
+}
+
+// StreamFiles returns a StreamSource supplying the given files in order
+func StreamFiles(files ...*StreamFile) StreamSource {
+	return &fileList{files: files}
+}
+
+type fileList struct {
+	files []*StreamFile
+}
+
+func (l *fileList) Next() (*StreamFile, error) {
+	if len(l.files) == 0 {
+		return nil, io.EOF
+	}
+	file := l.files[0]
+	l.files = l.files[1:]
+	return file, nil
+}
+
+// CreateFromStream creates a snapshot from files read from streams instead
+// of a directory
+// source names where the data comes from and stands in for the backup root,
+// so snapshots of the same source are listed, searched and retained
+// together. Directories are created for the parents of each file. Content
+// is chunked and deduplicated exactly as in Create; parentID is recorded but
+// every file is read, since streams carry nothing to compare against.
+func (m *Manager) CreateFromStream(source string, files StreamSource, description, parentID string) (*models.Snapshot, error) {
+	startTime := time.Now()
+	if source == "" {
+		return nil, fmt.Errorf("stream source name required")
+	}
+
+	tree := &models.FileTree{
+		Files: make(map[string]*models.FileNode),
+		Root: &models.FileNode{
+			Path:    source,
+			Name:    path.Base(source),
+			IsDir:   true,
+			Mode:    os.ModeDir | 0755,
+			ModTime: startTime,
+		},
+	}
+	tree.Files["."] = tree.Root
+	tree.DirCount = 1
+
+	snapshot, err := m.newSnapshot(tree, description, parentID)
+	if err != nil {
+		return nil, err
+	}
+
+	if m.filter == nil {
+		if m.filter, err = store.LoadBloom(m.repoPath, m.cas); err != nil {
+			return nil, err
+		}
+	}
+
+	m.failed = nil
+	m.progress.SetPhase("storing")
+	m.pipeline = m.startPipeline(m.parallel)
+	defer func() {
+		m.pipeline.close()
+		m.pipeline = nil
+	}()
+	var totals fileResult
+	for {
+		file, err := files.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read stream source: %w", err)
+		}
+
+		relPath, err := streamPath(file.Path)
+		if err != nil {
+			closeStream(file)
+			return nil, err
+		}
+		if err := addStreamDirs(tree, relPath, startTime); err != nil {
+			closeStream(file)
+			return nil, err
+		}
+		existing, exists := tree.Files[relPath]
+		if file.IsDir {
+			if err := addStreamDir(tree, relPath, file, existing, startTime); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if exists {
+			closeStream(file)
+			return nil, fmt.Errorf("duplicate path in stream: %s", file.Path)
+		}
+
+		node := &models.FileNode{
+			Path:    path.Join(source, filepath.ToSlash(relPath)),
+			Name:    filepath.Base(relPath),
+			Mode:    file.Mode.Perm(),
+			ModTime: file.ModTime,
+		}
+		if node.Mode == 0 {
+			node.Mode = 0644
+		}
+		if node.ModTime.IsZero() {
+			node.ModTime = startTime
+		}
+
+		m.progress.WorkerStarted(0, relPath)
+		result, err := m.storeStream(node, file.Reader)
+		closeStream(file)
+		if err != nil {
+			m.progress.Error(err)
+			return nil, fmt.Errorf("failed to store %s: %w", file.Path, err)
+		}
+		m.progress.FileDone(0, node.Size)
+
+		tree.Files[relPath] = node
+		tree.FileCount++
+		tree.TotalSize += node.Size
+		totals.add(result)
+	}
+
+	m.progress.SetPhase("saving")
+	if err := m.index.Save(); err != nil {
+		return nil, fmt.Errorf("failed to save file index: %w", err)
+	}
+	if err := m.filter.Save(); err != nil {
+		return nil, fmt.Errorf("failed to save chunk filter: %w", err)
+	}
+
+	snapshot.Stats = totals.stats(tree.TotalSize)
+	snapshot.Stats.FilesAdded = tree.FileCount
+	snapshot.Stats.Duration = time.Since(startTime)
+
+	if err := m.saveSnapshot(snapshot); err != nil {
+		return nil, fmt.Errorf("failed to save snapshot: %w", err)
+	}
+
+	if m.indexPaths {
+		if err := m.updatePathIndex(snapshot); err != nil {
+			m.dropPathIndex()
+		}
+	}
+
+	return snapshot, nil
+}
+
+// storeStream reads a file's content from r, hashing it as it goes, and
+// stores it inline or as chunks
+func (m *Manager) storeStream(node *models.FileNode, r io.Reader) (*fileResult, error) {
+	result := &fileResult{}
+	if r == nil {
+		return nil, fmt.Errorf("no reader")
+	}
+
+	// Read just past the inline threshold to tell tiny files apart
+	head := make([]byte, InlineThreshold+1)
+	n, err := io.ReadFull(r, head)
+	switch {
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		if n > 0 {
+			return result, m.inlineData(node, head[:n])
+		}
+		hash := sha256.Sum256(nil)
+		node.Hash = hex.EncodeToString(hash[:])
+		return result, nil
+	case err != nil:
+		return nil, err
+	}
+
+	hasher := sha256.New()
+	reader := io.TeeReader(io.MultiReader(bytes.NewReader(head), r), hasher)
+	split := func(fn func(*models.Chunk) error) error {
+		err := m.chunker.ChunkStream(reader, func(chunk *models.Chunk) error {
+			node.Size += chunk.Size
+			return fn(chunk)
+		})
+		if err != nil {
+			return err
+		}
+		node.Hash = hex.EncodeToString(hasher.Sum(nil))
+		return nil
+	}
+
+	if err := m.storeChunks(node, split, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// streamPath validates the path of a streamed file and converts it to a
+// path relative to the snapshot root
+func streamPath(p string) (string, error) {
+	clean := path.Clean(p)
+	if p == "" || clean == "." || path.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, "../") {
+		return "", fmt.Errorf("invalid path in stream: %q", p)
+	}
+	return filepath.FromSlash(clean), nil
+}
+
+// addStreamDirs adds the missing parent directories of relPath to tree
+func addStreamDirs(tree *models.FileTree, relPath string, modTime time.Time) error {
+	for dir := filepath.Dir(relPath); dir != "."; dir = filepath.Dir(dir) {
+		if node, exists := tree.Files[dir]; exists {
+			if !node.IsDir {
+				return fmt.Errorf("path in stream is both a file and a directory: %s", filepath.ToSlash(dir))
+			}
+			continue
+		}
+		tree.Files[dir] = &models.FileNode{
+			Path:    path.Join(tree.Root.Path, filepath.ToSlash(dir)),
+			Name:    filepath.Base(dir),
+			IsDir:   true,
+			Mode:    os.ModeDir | 0755,
+			ModTime: modTime,
+		}
+		tree.DirCount++
+	}
+	return nil
+}
+
+// addStreamDir adds a directory given by its own entry, or sets the mode
+// and time of one already created for a file inside it
+func addStreamDir(tree *models.FileTree, relPath string, file *StreamFile, existing *models.FileNode, now time.Time) error {
+	if existing != nil && !existing.IsDir {
+		return fmt.Errorf("path in stream is both a file and a directory: %s", file.Path)
+	}
+	if existing == nil {
+		existing = &models.FileNode{
+			Path:  path.Join(tree.Root.Path, filepath.ToSlash(relPath)),
+			Name:  filepath.Base(relPath),
+			IsDir: true,
+		}
+		tree.Files[relPath] = existing
+		tree.DirCount++
+	}
+
+	existing.Mode = os.ModeDir | file.Mode.Perm()
+	if file.Mode.Perm() == 0 {
+		existing.Mode = os.ModeDir | 0755
+	}
+	existing.ModTime = file.ModTime
+	if existing.ModTime.IsZero() {
+		existing.ModTime = now
+	}
+	return nil
+}
+
+// closeStream closes a streamed file's reader if it can be closed
+func closeStream(file *StreamFile) {
+	if closer, ok := file.Reader.(io.Closer); ok {
+		closer.Close()
+	}
+}
@@ -0,0 +1,107 @@
+package snapshot
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// TarSource supplies the entries of a tar archive to CreateFromStream
+// Regular files and directories are imported. Links, devices and other
+// special entries have no place in a snapshot and are skipped.
+type TarSource struct {
+	tr      *tar.Reader
+	strip   int
+	close   func()
+	Skipped []string // Entries left out, with the reason
+}
+
+// NewTarSource reads a tar archive, uncompressed or compressed with gzip or
+// zstd, dropping the first strip components of every entry's path
+func NewTarSource(r io.Reader, strip int) (*TarSource, error) {
+	br := bufio.NewReaderSize(r, 1<<20)
+	magic, _ := br.Peek(4)
+
+	src := &TarSource{strip: strip, close: func() {}}
+	switch {
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read gzip archive: %w", err)
+		}
+		src.tr = tar.NewReader(zr)
+	case bytes.Equal(magic, []byte{0x28, 0xb5, 0x2f, 0xfd}):
+		zr, err := zstd.NewReader(br)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read zstd archive: %w", err)
+		}
+		src.tr = tar.NewReader(zr)
+		src.close = zr.Close
+	default:
+		src.tr = tar.NewReader(br)
+	}
+	return src, nil
+}
+
+// Next implements StreamSource
+// A file's reader is only valid until the following call.
+func (s *TarSource) Next() (*StreamFile, error) {
+	for {
+		hdr, err := s.tr.Next()
+		if err == io.EOF {
+			s.close()
+			return nil, io.EOF
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read archive: %w", err)
+		}
+
+		name := s.stripPath(hdr.Name)
+		if name == "" {
+			continue
+		}
+
+		file := &StreamFile{
+			Path:    name,
+			Mode:    hdr.FileInfo().Mode().Perm(),
+			ModTime: hdr.ModTime,
+		}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			file.IsDir = true
+		case tar.TypeReg:
+			file.Reader = s.tr
+		case tar.TypeSymlink:
+			s.Skipped = append(s.Skipped, hdr.Name+": symbolic link to "+hdr.Linkname)
+			continue
+		case tar.TypeLink:
+			s.Skipped = append(s.Skipped, hdr.Name+": hard link to "+hdr.Linkname)
+			continue
+		default:
+			s.Skipped = append(s.Skipped, hdr.Name+": special file")
+			continue
+		}
+		return file, nil
+	}
+}
+
+// stripPath removes the leading components from an entry's path, returning
+// "" for the archive root and for entries with too few components
+func (s *TarSource) stripPath(name string) string {
+	name = strings.Trim(path.Clean("/"+name), "/")
+	if name == "" {
+		return ""
+	}
+	parts := strings.Split(name, "/")
+	if len(parts) <= s.strip {
+		return ""
+	}
+	return strings.Join(parts[s.strip:], "/")
+}
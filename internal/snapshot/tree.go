@@ -0,0 +1,194 @@
+package snapshot
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/snapsync/snapsync/pkg/models"
+)
+
+// treeEntry is one child of a directory object
+// It omits the absolute source path so identical directories hash the same
+// regardless of where or when they were backed up.
+type treeEntry struct {
+	Name    string      `json:"name"`
+	IsDir   bool        `json:"is_dir,omitempty"`
+	Mode    os.FileMode `json:"mode"`
+	Size    int64       `json:"size"`
+	ModTime time.Time   `json:"mod_time"`
+	Hash    string      `json:"hash,omitempty"`
+	ETag    string      `json:"etag,omitempty"`
+	Chunks  []string    `json:"chunks,omitempty"`
+	Inline  []byte      `json:"inline,omitempty"`
+	SQLite  bool        `json:"sqlite,omitempty"`
+	Subtree string      `json:"subtree,omitempty"` // Directory object of a child directory
+
+	CopyHash   string   `json:"copy_hash,omitempty"`
+	Capability []byte   `json:"capability,omitempty"`
+	Flags      []string `json:"flags,omitempty"`
+}
+
+// treeObject is the canonical serialization of a directory
+type treeObject struct {
+	Entries []treeEntry `json:"entries"`
+}
+
+// putObject stores an object and returns its hash
+type putObject func(data []byte) (string, error)
+
+// storeTree writes the directories of tree bottom-up with put and returns
+// the hash of the root directory object. Sealed trees are encrypted
+// deterministically, hiding names and structure while keeping dedup.
+func (m *Manager) storeTree(tree *models.FileTree, sealed bool, put putObject) (string, error) {
+	children := make(map[string][]string)
+	for relPath := range tree.Files {
+		if relPath == "." {
+			continue
+		}
+		parent := filepath.Dir(relPath)
+		children[parent] = append(children[parent], relPath)
+	}
+
+	return m.storeDir(tree, children, ".", sealed, put)
+}
+
+// storeDir stores the directory object for dir after its subdirectories
+func (m *Manager) storeDir(tree *models.FileTree, children map[string][]string, dir string, sealed bool, put putObject) (string, error) {
+	paths := children[dir]
+	sort.Strings(paths)
+
+	obj := treeObject{Entries: make([]treeEntry, 0, len(paths))}
+	for _, relPath := range paths {
+		node := tree.Files[relPath]
+		entry := treeEntry{
+			Name:    filepath.Base(relPath),
+			IsDir:   node.IsDir,
+			Mode:    node.Mode,
+			Size:    node.Size,
+			ModTime: node.ModTime.UTC(),
+			Hash:    node.Hash,
+			ETag:    node.ETag,
+			Chunks:  node.Chunks,
+			Inline:  node.Inline,
+			SQLite:  node.SQLite,
+
+			CopyHash:   node.CopyHash,
+			Capability: node.Capability,
+			Flags:      node.Flags,
+		}
+
+		if node.IsDir {
+			subtree, err := m.storeDir(tree, children, relPath, sealed, put)
+			if err != nil {
+				return "", err
+			}
+			entry.Subtree = subtree
+		}
+
+		obj.Entries = append(obj.Entries, entry)
+	}
+
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return "", err
+	}
+	if sealed {
+		if data, err = m.encryptor.SealDeterministic(data); err != nil {
+			return "", fmt.Errorf("failed to encrypt tree object: %w", err)
+		}
+	}
+
+	hash, err := put(data)
+	if err != nil {
+		return "", fmt.Errorf("failed to store tree object: %w", err)
+	}
+	return hash, nil
+}
+
+// loadTree rebuilds the file map of tree from the directory object at hash
+func (m *Manager) loadTree(tree *models.FileTree, hash string, sealed bool) error {
+	tree.Files = make(map[string]*models.FileNode)
+	if tree.Root != nil {
+		root := *tree.Root
+		tree.Files["."] = &root
+	}
+
+	return m.loadDir(tree, hash, ".", sealed)
+}
+
+// loadDir adds the entries of the directory object at hash under dir
+func (m *Manager) loadDir(tree *models.FileTree, hash, dir string, sealed bool) error {
+	obj, err := m.readTreeObject(hash, sealed)
+	if err != nil {
+		return err
+	}
+
+	var rootPath string
+	if tree.Root != nil {
+		rootPath = tree.Root.Path
+	}
+
+	for _, entry := range obj.Entries {
+		relPath := filepath.Join(dir, entry.Name)
+		tree.Files[relPath] = entryNode(entry, rootPath, relPath)
+
+		if entry.Subtree != "" {
+			if err := m.loadDir(tree, entry.Subtree, relPath, sealed); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// entryNode returns the file node a directory entry describes
+func entryNode(entry treeEntry, rootPath, relPath string) *models.FileNode {
+	return &models.FileNode{
+		Path:    filepath.Join(rootPath, relPath),
+		Name:    entry.Name,
+		IsDir:   entry.IsDir,
+		Mode:    entry.Mode,
+		Size:    entry.Size,
+		ModTime: entry.ModTime,
+		Hash:    entry.Hash,
+		ETag:    entry.ETag,
+		Chunks:  entry.Chunks,
+		Inline:  entry.Inline,
+		SQLite:  entry.SQLite,
+
+		CopyHash:   entry.CopyHash,
+		Capability: entry.Capability,
+		Flags:      entry.Flags,
+	}
+}
+
+// readTreeObject fetches and decodes a directory object
+// Directories are shared between snapshots, so decoded objects are cached.
+func (m *Manager) readTreeObject(hash string, sealed bool) (*treeObject, error) {
+	if obj, ok := m.trees[hash]; ok {
+		return obj, nil
+	}
+
+	data, err := m.cas.Get(hash)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tree object: %w", err)
+	}
+	if sealed {
+		if data, err = m.encryptor.OpenDeterministic(data); err != nil {
+			return nil, fmt.Errorf("failed to decrypt tree object %s: %w", hash, err)
+		}
+	}
+
+	var obj treeObject
+	if err := json.Unmarshal(data, &obj); err != nil {
+		return nil, fmt.Errorf("invalid tree object %s: %w", hash, err)
+	}
+
+	m.trees[hash] = &obj
+	return &obj, nil
+}
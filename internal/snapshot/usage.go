@@ -0,0 +1,175 @@
+package snapshot
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/snapsync/snapsync/pkg/models"
+)
+
+// SnapshotUsage is the space one snapshot accounts for in the store
+// Sizes are of objects as stored, after deduplication, compression and
+// encryption.
+type SnapshotUsage struct {
+	ID            string    `json:"id"`
+	Timestamp     time.Time `json:"timestamp"`
+	Tags          []string  `json:"tags,omitempty"`
+	LogicalSize   int64     `json:"logical_size"` // Size of the files backed up
+	Objects       int       `json:"objects"`
+	StoredSize    int64     `json:"stored_size"`
+	UniqueObjects int       `json:"unique_objects"` // Referenced by no other snapshot
+	UniqueSize    int64     `json:"unique_size"`    // Freed by deleting only this snapshot
+}
+
+// Usage summarizes how the repository's storage is used
+type Usage struct {
+	Objects           int             `json:"objects"`
+	StoredSize        int64           `json:"stored_size"`
+	ReferencedObjects int             `json:"referenced_objects"`
+	ReferencedSize    int64           `json:"referenced_size"`
+	LogicalSize       int64           `json:"logical_size"` // Summed over snapshots
+	Snapshots         []SnapshotUsage `json:"snapshots"`    // Newest first
+}
+
+// Usage walks every snapshot and attributes stored objects to them
+// Like CollectGarbage it fails on unreadable snapshots rather than
+// reporting their objects as unused.
+func (m *Manager) Usage() (*Usage, error) {
+	snapshots, err := m.records()
+	if err != nil {
+		return nil, err
+	}
+
+	usage, _, err := m.usage(snapshots)
+	return usage, err
+}
+
+// objectUsage records which objects each snapshot references, the number
+// of snapshots referencing each object and the stored size of each
+type objectUsage struct {
+	refs   map[string]map[string]bool // By snapshot ID
+	owners map[string]int
+	sizes  map[string]int64
+}
+
+// usage computes Usage for the given snapshots
+func (m *Manager) usage(snapshots []*models.Snapshot) (*Usage, *objectUsage, error) {
+	usage := &Usage{Snapshots: []SnapshotUsage{}}
+	var err error
+	if usage.Objects, usage.StoredSize, err = m.cas.Stats(); err != nil {
+		return nil, nil, fmt.Errorf("failed to read store: %w", err)
+	}
+
+	objects := &objectUsage{
+		refs:   make(map[string]map[string]bool, len(snapshots)),
+		owners: make(map[string]int),
+		sizes:  make(map[string]int64),
+	}
+	owners, sizes := objects.owners, objects.sizes
+	refsBySnapshot := make([]map[string]bool, len(snapshots))
+	for i, snap := range snapshots {
+		refs, err := m.References(snap)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to walk snapshot %s: %w", snap.ID, err)
+		}
+		refsBySnapshot[i] = refs
+		objects.refs[snap.ID] = refs
+
+		for hash := range refs {
+			owners[hash]++
+			if _, ok := sizes[hash]; !ok {
+				// Missing objects count as empty; check reports them
+				size, _ := m.cas.Size(hash)
+				sizes[hash] = size
+				usage.ReferencedObjects++
+				usage.ReferencedSize += size
+			}
+		}
+	}
+
+	for i, snap := range snapshots {
+		su := SnapshotUsage{
+			ID:          snap.ID,
+			Timestamp:   snap.Timestamp,
+			Tags:        snap.Tags,
+			LogicalSize: snap.Stats.TotalSize,
+			Objects:     len(refsBySnapshot[i]),
+		}
+		for hash := range refsBySnapshot[i] {
+			su.StoredSize += sizes[hash]
+			if owners[hash] == 1 {
+				su.UniqueObjects++
+				su.UniqueSize += sizes[hash]
+			}
+		}
+		usage.LogicalSize += su.LogicalSize
+		usage.Snapshots = append(usage.Snapshots, su)
+	}
+
+	sort.Slice(usage.Snapshots, func(i, j int) bool {
+		return usage.Snapshots[i].Timestamp.After(usage.Snapshots[j].Timestamp)
+	})
+	return usage, objects, nil
+}
+
+// RemovalForecast is the space deleting a set of snapshots and pruning
+// afterwards would reclaim
+type RemovalForecast struct {
+	Snapshots  []SnapshotUsage `json:"snapshots"`   // Snapshots removed, newest first; UniqueSize is freed by each alone
+	Objects    int             `json:"objects"`     // Objects no remaining snapshot references
+	Size       int64           `json:"size"`        // Their stored size
+	SharedSize int64           `json:"shared_size"` // Part of Size shared only among the removed snapshots
+	Garbage    int64           `json:"garbage"`     // Already unreferenced, freed by pruning alone
+}
+
+// ForecastRemoval works out what deleting the snapshots with the given IDs
+// would free, without deleting anything
+func (m *Manager) ForecastRemoval(ids []string) (*RemovalForecast, error) {
+	snapshots, err := m.records()
+	if err != nil {
+		return nil, err
+	}
+
+	usage, objects, err := m.usage(snapshots)
+	if err != nil {
+		return nil, err
+	}
+
+	remove := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		remove[id] = true
+	}
+
+	forecast := &RemovalForecast{
+		Snapshots: []SnapshotUsage{},
+		Garbage:   usage.StoredSize - usage.ReferencedSize,
+	}
+	for _, su := range usage.Snapshots {
+		if remove[su.ID] {
+			forecast.Snapshots = append(forecast.Snapshots, su)
+		}
+	}
+
+	// An object is freed once every snapshot referencing it is removed
+	removedOwners := make(map[string]int)
+	for id := range remove {
+		for hash := range objects.refs[id] {
+			removedOwners[hash]++
+		}
+	}
+	var unique int64
+	for hash, n := range removedOwners {
+		if n != objects.owners[hash] {
+			continue
+		}
+		forecast.Objects++
+		forecast.Size += objects.sizes[hash]
+		if n == 1 {
+			unique += objects.sizes[hash]
+		}
+	}
+	forecast.SharedSize = forecast.Size - unique
+
+	return forecast, nil
+}
@@ -0,0 +1,65 @@
+package snapshot
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// Verification is the outcome of the last integrity check of one kind
+type Verification struct {
+	Kind     string    `json:"kind"` // check, check --read-data or verify
+	Time     time.Time `json:"time"`
+	OK       bool      `json:"ok"`
+	Problems int       `json:"problems"`
+}
+
+// verificationsPath is where the latest result of each kind is kept
+func (m *Manager) verificationsPath() string {
+	return filepath.Join(m.repoPath, "index", "verifications.json")
+}
+
+// RecordVerification stores the result of a check, replacing the previous
+// result of the same kind
+func (m *Manager) RecordVerification(v Verification) error {
+	results := make(map[string]Verification)
+	if data, err := os.ReadFile(m.verificationsPath()); err == nil {
+		// A damaged file only loses the older results
+		_ = json.Unmarshal(data, &results)
+	}
+	results[v.Kind] = v
+
+	data, err := json.MarshalIndent(results, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(m.verificationsPath()), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(m.verificationsPath(), data, 0644)
+}
+
+// Verifications returns the latest result of each kind of check, by kind
+func (m *Manager) Verifications() ([]Verification, error) {
+	data, err := os.ReadFile(m.verificationsPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []Verification{}, nil
+		}
+		return nil, err
+	}
+
+	results := make(map[string]Verification)
+	if err := json.Unmarshal(data, &results); err != nil {
+		return nil, err
+	}
+
+	list := make([]Verification, 0, len(results))
+	for _, v := range results {
+		list = append(list, v)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Kind < list[j].Kind })
+	return list, nil
+}
@@ -0,0 +1,168 @@
+package sqlitesnap
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// WALSuffix and SHMSuffix name the files SQLite keeps next to a
+	// database in write-ahead-log mode
+	WALSuffix = "-wal"
+	SHMSuffix = "-shm"
+
+	// copyAttempts bounds retries when the database changes mid-copy
+	copyAttempts = 5
+)
+
+// header is the magic string at the start of every SQLite 3 database
+var header = []byte("SQLite format 3\x00")
+
+// MaybeDatabase reports whether a file of size bytes could be a database,
+// without opening it
+func MaybeDatabase(size int64) bool {
+	// Database files are always a whole number of pages of at least 512 bytes
+	return size >= 512 && size%512 == 0
+}
+
+// IsDatabase reports whether the file at path is a SQLite database
+func IsDatabase(path string, size int64) bool {
+	if !MaybeDatabase(size) {
+		return false
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+
+	buf := make([]byte, len(header))
+	if _, err := io.ReadFull(file, buf); err != nil {
+		return false
+	}
+	return bytes.Equal(buf, header)
+}
+
+// Capture is a consistent copy of a live SQLite database
+type Capture struct {
+	DBPath  string // Copy of the database file
+	WALPath string // Copy of the WAL taken with the database, empty if folded in
+	dir     string
+}
+
+// New captures a consistent copy of the database at dbPath in a new
+// directory under tempDir, or the system temporary directory if it is empty
+// The sqlite3 online backup is preferred since it folds the WAL into the
+// copy under a read transaction. Without it, the database and its WAL are
+// copied together and retried until neither changes during the copy.
+func New(dbPath, tempDir string) (*Capture, error) {
+	dir, err := os.MkdirTemp(tempDir, "snapsync-sqlite-")
+	if err != nil {
+		return nil, err
+	}
+	c := &Capture{dir: dir}
+
+	if err := c.onlineBackup(dbPath); err == nil {
+		return c, nil
+	}
+
+	if err := c.stableCopy(dbPath); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// Close removes the captured copies
+func (c *Capture) Close() error {
+	return os.RemoveAll(c.dir)
+}
+
+// onlineBackup uses the sqlite3 shell's .backup command
+func (c *Capture) onlineBackup(dbPath string) error {
+	tool, err := exec.LookPath("sqlite3")
+	if err != nil {
+		return err
+	}
+
+	dst := filepath.Join(c.dir, "db")
+	quoted := "'" + strings.ReplaceAll(dst, "'", "''") + "'"
+
+	var stderr bytes.Buffer
+	cmd := exec.Command(tool, "-readonly", dbPath, ".backup "+quoted)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		os.Remove(dst)
+		return fmt.Errorf("sqlite3 backup failed: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	c.DBPath = dst
+	return nil
+}
+
+// stableCopy copies the database and WAL, retrying if either changes
+func (c *Capture) stableCopy(dbPath string) error {
+	walPath := dbPath + WALSuffix
+
+	for attempt := 0; attempt < copyAttempts; attempt++ {
+		before := fingerprint(dbPath, walPath)
+
+		dbCopy := filepath.Join(c.dir, "db")
+		if err := copyFile(dbPath, dbCopy); err != nil {
+			return fmt.Errorf("failed to copy database: %w", err)
+		}
+
+		walCopy := ""
+		if _, err := os.Stat(walPath); err == nil {
+			walCopy = filepath.Join(c.dir, "db"+WALSuffix)
+			if err := copyFile(walPath, walCopy); err != nil {
+				return fmt.Errorf("failed to copy WAL: %w", err)
+			}
+		}
+
+		if fingerprint(dbPath, walPath) == before {
+			c.DBPath = dbCopy
+			c.WALPath = walCopy
+			return nil
+		}
+	}
+
+	return fmt.Errorf("database %s kept changing during copy", dbPath)
+}
+
+// fingerprint summarizes size and mtime of the database and its WAL
+func fingerprint(paths ...string) string {
+	var b strings.Builder
+	for _, p := range paths {
+		if info, err := os.Stat(p); err == nil {
+			fmt.Fprintf(&b, "%d:%d;", info.Size(), info.ModTime().UnixNano())
+		} else {
+			b.WriteString("-;")
+		}
+	}
+	return b.String()
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
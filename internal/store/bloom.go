@@ -0,0 +1,186 @@
+package store
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const (
+	bloomMagic       = "SSBF"
+	bloomBitsPerItem = 10 // About 1% false positives with bloomHashes
+	bloomHashes      = 7
+	bloomMinItems    = 1 << 16
+)
+
+// Bloom is a bloom filter over stored object hashes
+// A negative answer means the object is certainly absent, so backups only
+// consult the store for hashes the filter reports as possibly present.
+type Bloom struct {
+	path     string
+	mu       sync.RWMutex
+	bits     []uint64
+	count    uint64
+	capacity uint64
+	dirty    bool
+}
+
+// newBloom creates an empty filter sized for capacity items
+func newBloom(path string, capacity uint64) *Bloom {
+	if capacity < bloomMinItems {
+		capacity = bloomMinItems
+	}
+	words := (capacity*bloomBitsPerItem + 63) / 64
+	return &Bloom{
+		path:     path,
+		bits:     make([]uint64, words),
+		capacity: capacity,
+	}
+}
+
+// LoadBloom opens the chunk filter persisted with the repository index
+// The filter is rebuilt from the store when it is missing, unreadable or
+// holds more items than it was sized for.
+func LoadBloom(repoPath string, cas *CAS) (*Bloom, error) {
+	path := filepath.Join(repoPath, "index", "chunks.bloom")
+
+	if data, err := os.ReadFile(path); err == nil {
+		if b, err := decodeBloom(path, data); err == nil && b.count <= b.capacity {
+			return b, nil
+		}
+	}
+
+	hashes, err := cas.List()
+	if err != nil {
+		return nil, fmt.Errorf("failed to rebuild chunk filter: %w", err)
+	}
+
+	b := newBloom(path, uint64(len(hashes))*2)
+	for _, hash := range hashes {
+		b.Add(hash)
+	}
+	b.dirty = true
+	return b, nil
+}
+
+// Add records a hash in the filter
+func (b *Bloom) Add(hash string) {
+	h1, h2, ok := bloomKeys(hash)
+	if !ok {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	nbits := uint64(len(b.bits)) * 64
+	added := false
+	for i := uint64(0); i < bloomHashes; i++ {
+		bit := (h1 + i*h2) % nbits
+		if b.bits[bit/64]&(1<<(bit%64)) == 0 {
+			b.bits[bit/64] |= 1 << (bit % 64)
+			added = true
+		}
+	}
+	if added {
+		b.count++
+		b.dirty = true
+	}
+}
+
+// MayContain reports whether a hash is possibly in the filter
+func (b *Bloom) MayContain(hash string) bool {
+	h1, h2, ok := bloomKeys(hash)
+	if !ok {
+		return true
+	}
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	nbits := uint64(len(b.bits)) * 64
+	for i := uint64(0); i < bloomHashes; i++ {
+		bit := (h1 + i*h2) % nbits
+		if b.bits[bit/64]&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Save writes the filter to disk if it changed
+func (b *Bloom) Save() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !b.dirty {
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(b.path), 0755); err != nil {
+		return fmt.Errorf("failed to create index directory: %w", err)
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(bloomMagic)
+	binary.Write(&buf, binary.LittleEndian, b.count)
+	binary.Write(&buf, binary.LittleEndian, b.capacity)
+	binary.Write(&buf, binary.LittleEndian, b.bits)
+
+	tmpPath := b.path + ".tmp"
+	if err := os.WriteFile(tmpPath, buf.Bytes(), 0644); err != nil {
+		return fmt.Errorf("failed to write chunk filter: %w", err)
+	}
+	if err := os.Rename(tmpPath, b.path); err != nil {
+		return fmt.Errorf("failed to write chunk filter: %w", err)
+	}
+
+	b.dirty = false
+	return nil
+}
+
+// decodeBloom parses a filter written by Save
+func decodeBloom(path string, data []byte) (*Bloom, error) {
+	if len(data) < len(bloomMagic)+16 || string(data[:len(bloomMagic)]) != bloomMagic {
+		return nil, fmt.Errorf("invalid chunk filter")
+	}
+
+	reader := bytes.NewReader(data[len(bloomMagic):])
+	b := &Bloom{path: path}
+	if err := binary.Read(reader, binary.LittleEndian, &b.count); err != nil {
+		return nil, err
+	}
+	if err := binary.Read(reader, binary.LittleEndian, &b.capacity); err != nil {
+		return nil, err
+	}
+
+	words := uint64(reader.Len()) / 8
+	if words == 0 || words != (b.capacity*bloomBitsPerItem+63)/64 {
+		return nil, fmt.Errorf("invalid chunk filter")
+	}
+	b.bits = make([]uint64, words)
+	if err := binary.Read(reader, binary.LittleEndian, b.bits); err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+// bloomKeys derives the two base hashes for double hashing from a SHA-256
+// hex digest, which is already uniformly distributed
+func bloomKeys(hash string) (uint64, uint64, bool) {
+	if len(hash) < 32 {
+		return 0, 0, false
+	}
+	raw, err := hex.DecodeString(hash[:32])
+	if err != nil {
+		return 0, 0, false
+	}
+	h1 := binary.LittleEndian.Uint64(raw[:8])
+	h2 := binary.LittleEndian.Uint64(raw[8:]) | 1
+	return h1, h2, true
+}
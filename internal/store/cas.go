@@ -8,14 +8,18 @@ import (
 	"os"
 	"path/filepath"
 	"sync"
+	"time"
 )
 
 // CAS implements a Content-Addressable Storage system
-// Files are stored by their SHA-256 hash, enabling automatic deduplication
+// Files are stored by their SHA-256 hash, enabling automatic deduplication.
+// Objects are written loose, one file each, and Pack later moves them into
+// pack files; reads look for a loose copy first and then in the packs.
 type CAS struct {
 	basePath string
+	packs    *packSet
 	mu       sync.RWMutex
-	refCount map[string]int // Reference counting for garbage collection
+	writing  map[string]bool // Chunks PutChunk is writing
 }
 
 // NewCAS creates a new Content-Addressable Storage at the specified path
@@ -27,12 +31,13 @@ func NewCAS(basePath string) (*CAS, error) {
 
 	return &CAS{
 		basePath: objectsPath,
-		refCount: make(map[string]int),
+		packs:    newPackSet(filepath.Join(basePath, "packs")),
+		writing:  make(map[string]bool),
 	}, nil
 }
 
 // Put stores data and returns its hash
-// If the data already exists, it just increments the reference count
+// Data that already exists is not written again.
 func (c *CAS) Put(data []byte) (string, error) {
 	hash := sha256.Sum256(data)
 	hashStr := hex.EncodeToString(hash[:])
@@ -42,7 +47,6 @@ func (c *CAS) Put(data []byte) (string, error) {
 
 	// Check if already exists
 	if c.Has(hashStr) {
-		c.refCount[hashStr]++
 		return hashStr, nil
 	}
 
@@ -56,10 +60,82 @@ func (c *CAS) Put(data []byte) (string, error) {
 		return "", fmt.Errorf("failed to write object: %w", err)
 	}
 
-	c.refCount[hashStr] = 1
 	return hashStr, nil
 }
 
+// PutChunk stores encoded chunk data under the hash of its plaintext
+// Chunks are compressed and encrypted before storage, so the key cannot be
+// derived from the stored bytes. Returns false if the chunk already existed.
+// Different chunks are written concurrently; a chunk another call is still
+// writing counts as existing.
+func (c *CAS) PutChunk(hash string, data []byte) (bool, error) {
+	c.mu.Lock()
+	if c.writing[hash] || c.Has(hash) {
+		c.mu.Unlock()
+		return false, nil
+	}
+	c.writing[hash] = true
+	c.mu.Unlock()
+
+	defer func() {
+		c.mu.Lock()
+		delete(c.writing, hash)
+		c.mu.Unlock()
+	}()
+
+	objPath := c.objectPath(hash)
+	if err := os.MkdirAll(filepath.Dir(objPath), 0755); err != nil {
+		return false, fmt.Errorf("failed to create object directory: %w", err)
+	}
+
+	if err := os.WriteFile(objPath, data, 0644); err != nil {
+		return false, fmt.Errorf("failed to write object: %w", err)
+	}
+
+	return true, nil
+}
+
+// ReplaceChunk stores encoded chunk data whether or not the chunk exists,
+// for re-encoding chunks in place
+// The object is written to a temporary file and renamed over the old one,
+// so an interruption leaves either the old or the new encoding.
+func (c *CAS) ReplaceChunk(hash string, data []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	objPath := c.objectPath(hash)
+	if err := os.MkdirAll(filepath.Dir(objPath), 0755); err != nil {
+		return fmt.Errorf("failed to create object directory: %w", err)
+	}
+
+	tmpPath := objPath + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to write object: %w", err)
+	}
+	if err := os.Rename(tmpPath, objPath); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to replace object: %w", err)
+	}
+	return nil
+}
+
+// GetChunk retrieves encoded chunk data stored with PutChunk
+// The caller verifies the hash after decoding
+func (c *CAS) GetChunk(hash string) ([]byte, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	data, err := c.read(hash)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("object not found: %s", hash)
+		}
+		return nil, err
+	}
+	return data, nil
+}
+
 // PutReader stores data from a reader and returns its hash
 func (c *CAS) PutReader(reader io.Reader) (string, int64, error) {
 	data, err := io.ReadAll(reader)
@@ -76,8 +152,7 @@ func (c *CAS) Get(hash string) ([]byte, error) {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
 
-	objPath := c.objectPath(hash)
-	data, err := os.ReadFile(objPath)
+	data, err := c.read(hash)
 	if err != nil {
 		if os.IsNotExist(err) {
 			return nil, fmt.Errorf("object not found: %s", hash)
@@ -96,53 +171,129 @@ func (c *CAS) Get(hash string) ([]byte, error) {
 
 // GetReader returns a reader for the object
 func (c *CAS) GetReader(hash string) (io.ReadCloser, error) {
-	objPath := c.objectPath(hash)
-	file, err := os.Open(objPath)
+	file, err := os.Open(c.objectPath(hash))
+	if err == nil {
+		return file, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	e, ok := c.packs.lookup(hash)
+	if !ok {
+		return nil, fmt.Errorf("object not found: %s", hash)
+	}
+	pack, err := os.Open(e.pack)
 	if err != nil {
-		if os.IsNotExist(err) {
-			return nil, fmt.Errorf("object not found: %s", hash)
-		}
 		return nil, err
 	}
-	return file, nil
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.NewSectionReader(pack, e.offset, e.length), pack}, nil
 }
 
 // Has checks if an object exists in the store
 func (c *CAS) Has(hash string) bool {
-	objPath := c.objectPath(hash)
-	_, err := os.Stat(objPath)
+	if c.hasLoose(hash) {
+		return true
+	}
+	_, ok := c.packs.lookup(hash)
+	return ok
+}
+
+// hasLoose checks if an object is stored outside the packs
+func (c *CAS) hasLoose(hash string) bool {
+	_, err := os.Stat(c.objectPath(hash))
 	return err == nil
 }
 
-// Delete removes an object (decrements ref count, deletes when 0)
+// HasMany reports which of the hashes exist in the store
+func (c *CAS) HasMany(hashes []string) map[string]bool {
+	result := make(map[string]bool, len(hashes))
+	for _, hash := range hashes {
+		result[hash] = c.Has(hash)
+	}
+	return result
+}
+
+// Delete removes a loose object
+// Objects are shared between snapshots; use GC to find unreferenced ones.
+// Packed objects are only removed by GC rewriting their packs.
 func (c *CAS) Delete(hash string) error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	if count, exists := c.refCount[hash]; exists {
-		if count > 1 {
-			c.refCount[hash]--
-			return nil
-		}
-		delete(c.refCount, hash)
-	}
-
 	objPath := c.objectPath(hash)
 	return os.Remove(objPath)
 }
 
 // Size returns the size of an object
 func (c *CAS) Size(hash string) (int64, error) {
-	objPath := c.objectPath(hash)
-	info, err := os.Stat(objPath)
-	if err != nil {
-		return 0, err
+	size, _, err := c.stat(hash)
+	return size, err
+}
+
+// ModTime returns when an object was written, or for a packed object when
+// its pack was
+func (c *CAS) ModTime(hash string) (time.Time, error) {
+	_, modTime, err := c.stat(hash)
+	return modTime, err
+}
+
+// stat returns the size and modification time of an object
+func (c *CAS) stat(hash string) (int64, time.Time, error) {
+	info, err := os.Stat(c.objectPath(hash))
+	if err == nil {
+		return info.Size(), info.ModTime(), nil
+	}
+	if !os.IsNotExist(err) {
+		return 0, time.Time{}, err
 	}
-	return info.Size(), nil
+	e, ok := c.packs.lookup(hash)
+	if !ok {
+		return 0, time.Time{}, err
+	}
+	return e.length, c.packs.modTime(e.pack), nil
+}
+
+// read returns an object's stored bytes, loose or packed
+func (c *CAS) read(hash string) ([]byte, error) {
+	data, err := os.ReadFile(c.objectPath(hash))
+	if err == nil || !os.IsNotExist(err) {
+		return data, err
+	}
+	return c.packs.read(hash)
 }
 
-// List returns all object hashes in the store
+// List returns all object hashes in the store, loose and packed
 func (c *CAS) List() ([]string, error) {
+	hashes, err := c.listLoose()
+	if err != nil {
+		return nil, err
+	}
+
+	packed, err := c.packs.hashes()
+	if err != nil {
+		return nil, err
+	}
+	if len(packed) == 0 {
+		return hashes, nil
+	}
+	seen := make(map[string]bool, len(hashes))
+	for _, hash := range hashes {
+		seen[hash] = true
+	}
+	for _, hash := range packed {
+		if !seen[hash] {
+			hashes = append(hashes, hash)
+		}
+	}
+	return hashes, nil
+}
+
+// listLoose returns the hashes of the objects stored outside the packs
+func (c *CAS) listLoose() ([]string, error) {
 	var hashes []string
 
 	err := filepath.Walk(c.basePath, func(path string, info os.FileInfo, err error) error {
@@ -165,17 +316,42 @@ func (c *CAS) List() ([]string, error) {
 }
 
 // Stats returns storage statistics
+// An object both loose and packed counts once, at its loose size. Temporary
+// files are not objects, and objects deleted while the store is walked,
+// e.g. by a running prune, are skipped rather than failing the walk.
 func (c *CAS) Stats() (objectCount int, totalSize int64, err error) {
+	loose := make(map[string]bool)
 	err = filepath.Walk(c.basePath, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
 			return err
 		}
-		if !info.IsDir() {
+		if !info.IsDir() && len(info.Name()) == 64 {
 			objectCount++
 			totalSize += info.Size()
+			loose[info.Name()] = true
 		}
 		return nil
 	})
+	if err != nil {
+		return
+	}
+
+	packed, err := c.packs.hashes()
+	if err != nil {
+		return
+	}
+	for _, hash := range packed {
+		if loose[hash] {
+			continue
+		}
+		if e, ok := c.packs.lookup(hash); ok {
+			objectCount++
+			totalSize += e.length
+		}
+	}
 	return
 }
 
@@ -188,8 +364,11 @@ func (c *CAS) objectPath(hash string) string {
 	return filepath.Join(c.basePath, hash[:2], hash)
 }
 
-// Verify checks integrity of all objects
-func (c *CAS) Verify() ([]string, error) {
+// Verify checks integrity of all objects by re-hashing their contents
+// Chunks are stored encoded under the hash of their plaintext, so objects
+// whose stored bytes do not match are hashed again after decode, which
+// should decrypt and decompress them. A nil decode checks raw objects only.
+func (c *CAS) Verify(decode func(data []byte) ([]byte, error)) ([]string, error) {
 	var corrupted []string
 
 	hashes, err := c.List()
@@ -198,17 +377,35 @@ func (c *CAS) Verify() ([]string, error) {
 	}
 
 	for _, hash := range hashes {
-		data, err := os.ReadFile(c.objectPath(hash))
+		data, err := c.read(hash)
 		if err != nil {
 			corrupted = append(corrupted, hash)
 			continue
 		}
 
-		actualHash := sha256.Sum256(data)
-		if hex.EncodeToString(actualHash[:]) != hash {
+		if !Matches(hash, data, decode) {
 			corrupted = append(corrupted, hash)
 		}
 	}
 
 	return corrupted, nil
 }
+
+// Matches reports whether data is a valid object for hash: either its own
+// hash, as tree objects are stored, or the hash of what decode makes of it,
+// as chunks are. A nil decode accepts raw objects only.
+func Matches(hash string, data []byte, decode func(data []byte) ([]byte, error)) bool {
+	actualHash := sha256.Sum256(data)
+	if hex.EncodeToString(actualHash[:]) == hash {
+		return true
+	}
+	if decode == nil {
+		return false
+	}
+	plain, err := decode(data)
+	if err != nil {
+		return false
+	}
+	actualHash = sha256.Sum256(plain)
+	return hex.EncodeToString(actualHash[:]) == hash
+}
@@ -0,0 +1,102 @@
+package store
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// GC is a mark-and-sweep garbage collector for a CAS
+// The caller marks every object reachable from the snapshots it keeps, then
+// Sweep deletes everything else. Reachability is computed fresh each time,
+// so nothing depends on counts surviving between runs.
+type GC struct {
+	cas     *CAS
+	live    map[string]bool
+	started time.Time
+}
+
+// GCStats summarizes a collection
+type GCStats struct {
+	Marked     int      // Objects reachable from a snapshot
+	Scanned    int      // Objects found in the store
+	LiveBytes  int64    // Size of reachable objects found
+	Swept      []string // Unreachable objects deleted, or to delete in a dry run
+	SweptBytes int64
+	Skipped    int // Unreachable objects written after marking began
+	Repacked   int // Packs rewritten or deleted to drop unreachable objects
+	DryRun     bool
+	Duration   time.Duration
+}
+
+// NewGC starts a collection over cas
+func NewGC(cas *CAS) *GC {
+	return &GC{
+		cas:     cas,
+		live:    make(map[string]bool),
+		started: time.Now(),
+	}
+}
+
+// Mark records an object as reachable
+func (g *GC) Mark(hash string) {
+	g.live[hash] = true
+}
+
+// Marked reports whether an object has been marked
+func (g *GC) Marked(hash string) bool {
+	return g.live[hash]
+}
+
+// Sweep deletes every unmarked object, or only reports them in a dry run
+// Objects written after the collection started are kept: they may belong to
+// a backup whose snapshot was not yet saved when marking ran. Packs holding
+// unmarked objects are rewritten without them, which also drops copies
+// superseded by a newer one.
+func (g *GC) Sweep(dryRun bool) (*GCStats, error) {
+	stats := &GCStats{Marked: len(g.live), DryRun: dryRun}
+
+	hashes, err := g.cas.List()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list objects: %w", err)
+	}
+
+	for _, hash := range hashes {
+		size, written, err := g.cas.stat(hash)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return stats, err
+		}
+		stats.Scanned++
+
+		if g.live[hash] {
+			stats.LiveBytes += size
+			continue
+		}
+		if written.After(g.started) {
+			stats.Skipped++
+			continue
+		}
+
+		if !dryRun {
+			if err := g.cas.Delete(hash); err != nil && !os.IsNotExist(err) {
+				return stats, fmt.Errorf("failed to delete object %s: %w", hash, err)
+			}
+		}
+		stats.Swept = append(stats.Swept, hash)
+		stats.SweptBytes += size
+	}
+
+	if !dryRun {
+		repacked, err := g.cas.repack(g.Marked, g.started)
+		if err != nil {
+			return stats, fmt.Errorf("failed to repack: %w", err)
+		}
+		stats.Repacked = repacked
+	}
+
+	stats.Duration = time.Since(g.started)
+	return stats, nil
+}
@@ -0,0 +1,146 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// FileIndex maps whole-file hashes to the chunk lists that reproduce them
+// It lets a backup skip re-chunking content the repository already holds.
+// The index is a cache: a missing or unreadable index file starts empty.
+type FileIndex struct {
+	path    string
+	mu      sync.RWMutex
+	entries map[string][]string
+	dirty   bool
+}
+
+// LoadFileIndex opens the file index of the repository at repoPath
+func LoadFileIndex(repoPath string) *FileIndex {
+	idx := &FileIndex{
+		path:    filepath.Join(repoPath, "index", "files.json"),
+		entries: make(map[string][]string),
+	}
+
+	if data, err := os.ReadFile(idx.path); err == nil {
+		if err := json.Unmarshal(data, &idx.entries); err != nil {
+			idx.entries = make(map[string][]string)
+		}
+	}
+
+	return idx
+}
+
+// Lookup returns the chunk list recorded for a file hash
+func (idx *FileIndex) Lookup(fileHash string) ([]string, bool) {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	chunks, ok := idx.entries[fileHash]
+	return chunks, ok
+}
+
+// Add records the chunk list for a file hash
+func (idx *FileIndex) Add(fileHash string, chunks []string) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	if _, ok := idx.entries[fileHash]; ok {
+		return
+	}
+	idx.entries[fileHash] = chunks
+	idx.dirty = true
+}
+
+// Remove drops the entry for a file hash
+func (idx *FileIndex) Remove(fileHash string) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	if _, ok := idx.entries[fileHash]; ok {
+		delete(idx.entries, fileHash)
+		idx.dirty = true
+	}
+}
+
+// RemoveChunks drops every entry that uses one of the deleted chunks
+func (idx *FileIndex) RemoveChunks(deleted map[string]bool) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	for fileHash, chunks := range idx.entries {
+		for _, hash := range chunks {
+			if deleted[hash] {
+				delete(idx.entries, fileHash)
+				idx.dirty = true
+				break
+			}
+		}
+	}
+}
+
+// RemoveMissing drops every entry that uses a chunk the store no longer
+// holds and returns how many were dropped
+func (idx *FileIndex) RemoveMissing(has func(hashes []string) map[string]bool) int {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	seen := make(map[string]bool)
+	var hashes []string
+	for _, chunks := range idx.entries {
+		for _, hash := range chunks {
+			if !seen[hash] {
+				seen[hash] = true
+				hashes = append(hashes, hash)
+			}
+		}
+	}
+	present := has(hashes)
+
+	removed := 0
+	for fileHash, chunks := range idx.entries {
+		for _, hash := range chunks {
+			if !present[hash] {
+				delete(idx.entries, fileHash)
+				idx.dirty = true
+				removed++
+				break
+			}
+		}
+	}
+	return removed
+}
+
+// Save writes the index to disk if it changed
+func (idx *FileIndex) Save() error {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	if !idx.dirty {
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(idx.path), 0755); err != nil {
+		return fmt.Errorf("failed to create index directory: %w", err)
+	}
+
+	data, err := json.Marshal(idx.entries)
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file first so a crash never leaves a torn index
+	tmpPath := idx.path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write index: %w", err)
+	}
+	if err := os.Rename(tmpPath, idx.path); err != nil {
+		return fmt.Errorf("failed to write index: %w", err)
+	}
+
+	idx.dirty = false
+	return nil
+}
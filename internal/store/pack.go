@@ -0,0 +1,570 @@
+package store
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Pack file layout, all integers little-endian:
+//
+//	magic                                   8 bytes
+//	object data, back to back
+//	index: per object hash (32), offset (8), length (8)
+//	footer: object count (4), CRC-32 of the index (4), magic (8)
+//
+// The index travels with the data, so a pack can be read, checked or copied
+// on its own and the in-memory index is rebuilt from the packs alone.
+const (
+	// DefaultPackSize is the size a pack is closed at
+	DefaultPackSize = 64 * 1024 * 1024
+
+	packMagic      = "SSPACK01"
+	packExt        = ".pack"
+	packEntrySize  = sha256.Size + 8 + 8
+	packFooterSize = 4 + 4 + 8
+)
+
+// packEntry locates an object inside a pack file
+type packEntry struct {
+	hash   string
+	pack   string // Path of the pack file
+	offset int64
+	length int64
+}
+
+// packSet indexes the objects held in the pack files of a directory
+// It is loaded on first use. An object found in several packs, for instance
+// because packing was interrupted and ran again, is read from the newest;
+// the copies in older packs are dropped when those packs are next rewritten.
+type packSet struct {
+	dir string
+
+	mu      sync.RWMutex
+	loaded  bool
+	entries map[string]packEntry
+	packs   map[string]time.Time // Modification time, by path
+}
+
+// newPackSet returns the packs under dir, which need not exist yet
+func newPackSet(dir string) *packSet {
+	return &packSet{dir: dir}
+}
+
+// load reads the index of every pack, unless already done
+func (p *packSet) load() error {
+	p.mu.RLock()
+	loaded := p.loaded
+	p.mu.RUnlock()
+	if loaded {
+		return nil
+	}
+	return p.reload()
+}
+
+// reload reads the index of every pack again, picking up packs written or
+// removed by other processes
+func (p *packSet) reload() error {
+	type pack struct {
+		path    string
+		modTime time.Time
+	}
+	var packs []pack
+	err := filepath.Walk(p.dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if !info.IsDir() && strings.HasSuffix(path, packExt) {
+			packs = append(packs, pack{path, info.ModTime()})
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list packs: %w", err)
+	}
+
+	// Later packs take precedence over earlier ones
+	sort.Slice(packs, func(i, j int) bool {
+		if !packs[i].modTime.Equal(packs[j].modTime) {
+			return packs[i].modTime.Before(packs[j].modTime)
+		}
+		return packs[i].path < packs[j].path
+	})
+
+	entries := make(map[string]packEntry)
+	modTimes := make(map[string]time.Time, len(packs))
+	for _, pk := range packs {
+		index, err := readPackIndex(pk.path)
+		if err != nil {
+			return err
+		}
+		for _, e := range index {
+			entries[e.hash] = e
+		}
+		modTimes[pk.path] = pk.modTime
+	}
+
+	p.mu.Lock()
+	p.entries = entries
+	p.packs = modTimes
+	p.loaded = true
+	p.mu.Unlock()
+	return nil
+}
+
+// lookup returns where an object is packed
+func (p *packSet) lookup(hash string) (packEntry, bool) {
+	if p.load() != nil {
+		return packEntry{}, false
+	}
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	e, ok := p.entries[hash]
+	return e, ok
+}
+
+// read returns a packed object
+// A miss reloads the index once, in case another process repacked.
+func (p *packSet) read(hash string) ([]byte, error) {
+	if err := p.load(); err != nil {
+		return nil, err
+	}
+	for attempt := 0; ; attempt++ {
+		p.mu.RLock()
+		e, ok := p.entries[hash]
+		p.mu.RUnlock()
+		if ok {
+			data, err := e.read()
+			if err == nil || !os.IsNotExist(err) || attempt > 0 {
+				return data, err
+			}
+		} else if attempt > 0 {
+			return nil, os.ErrNotExist
+		}
+		if err := p.reload(); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// read returns the object's bytes from its pack
+func (e packEntry) read() ([]byte, error) {
+	file, err := os.Open(e.pack)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	data := make([]byte, e.length)
+	if _, err := file.ReadAt(data, e.offset); err != nil {
+		return nil, fmt.Errorf("failed to read %s from pack %s: %w", e.hash, filepath.Base(e.pack), err)
+	}
+	return data, nil
+}
+
+// modTime returns when a pack was written
+func (p *packSet) modTime(path string) time.Time {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.packs[path]
+}
+
+// hashes returns every packed object
+func (p *packSet) hashes() ([]string, error) {
+	if err := p.load(); err != nil {
+		return nil, err
+	}
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	hashes := make([]string, 0, len(p.entries))
+	for hash := range p.entries {
+		hashes = append(hashes, hash)
+	}
+	return hashes, nil
+}
+
+// list returns the pack files, oldest first
+func (p *packSet) list() ([]string, error) {
+	if err := p.load(); err != nil {
+		return nil, err
+	}
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	paths := make([]string, 0, len(p.packs))
+	for path := range p.packs {
+		paths = append(paths, path)
+	}
+	sort.Slice(paths, func(i, j int) bool {
+		ti, tj := p.packs[paths[i]], p.packs[paths[j]]
+		if !ti.Equal(tj) {
+			return ti.Before(tj)
+		}
+		return paths[i] < paths[j]
+	})
+	return paths, nil
+}
+
+// add records a pack that was just written
+func (p *packSet) add(path string, index []packEntry) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, e := range index {
+		p.entries[e.hash] = e
+	}
+	p.packs[path] = info.ModTime()
+	return nil
+}
+
+// remove deletes a pack and forgets the objects only it was read from
+func (p *packSet) remove(path string, index []packEntry) error {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove pack: %w", err)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, e := range index {
+		if p.entries[e.hash].pack == path {
+			delete(p.entries, e.hash)
+		}
+	}
+	delete(p.packs, path)
+	return nil
+}
+
+// readPackIndex reads the index embedded in a pack file
+func readPackIndex(path string) ([]packEntry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open pack: %w", err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	size := info.Size()
+	if size < int64(len(packMagic)+packFooterSize) {
+		return nil, fmt.Errorf("pack %s is truncated", path)
+	}
+
+	footer := make([]byte, packFooterSize)
+	if _, err := file.ReadAt(footer, size-packFooterSize); err != nil {
+		return nil, fmt.Errorf("failed to read pack %s: %w", path, err)
+	}
+	if string(footer[8:]) != packMagic {
+		return nil, fmt.Errorf("pack %s has no index", path)
+	}
+	count := int64(binary.LittleEndian.Uint32(footer[0:4]))
+	indexStart := size - packFooterSize - count*packEntrySize
+	if indexStart < int64(len(packMagic)) {
+		return nil, fmt.Errorf("pack %s has a damaged index", path)
+	}
+
+	raw := make([]byte, count*packEntrySize)
+	if _, err := file.ReadAt(raw, indexStart); err != nil {
+		return nil, fmt.Errorf("failed to read pack %s: %w", path, err)
+	}
+	if crc32.ChecksumIEEE(raw) != binary.LittleEndian.Uint32(footer[4:8]) {
+		return nil, fmt.Errorf("pack %s has a damaged index", path)
+	}
+
+	index := make([]packEntry, count)
+	for i := range index {
+		rec := raw[int64(i)*packEntrySize:]
+		e := packEntry{
+			hash:   hex.EncodeToString(rec[:sha256.Size]),
+			pack:   path,
+			offset: int64(binary.LittleEndian.Uint64(rec[sha256.Size:])),
+			length: int64(binary.LittleEndian.Uint64(rec[sha256.Size+8:])),
+		}
+		if e.offset < int64(len(packMagic)) || e.length < 0 || e.offset+e.length > indexStart {
+			return nil, fmt.Errorf("pack %s has a damaged index", path)
+		}
+		index[i] = e
+	}
+	return index, nil
+}
+
+// packWriter writes objects into a new pack file
+type packWriter struct {
+	dir    string
+	file   *os.File
+	offset int64
+	index  []packEntry
+}
+
+// newPackWriter starts a pack in dir
+func newPackWriter(dir string) (*packWriter, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create pack directory: %w", err)
+	}
+	file, err := os.CreateTemp(dir, "pack-*.tmp")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create pack: %w", err)
+	}
+	if _, err := io.WriteString(file, packMagic); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return nil, fmt.Errorf("failed to write pack: %w", err)
+	}
+	return &packWriter{dir: dir, file: file, offset: int64(len(packMagic))}, nil
+}
+
+// add appends an object
+func (w *packWriter) add(hash string, data []byte) error {
+	if _, err := hex.DecodeString(hash); err != nil || len(hash) != 2*sha256.Size {
+		return fmt.Errorf("invalid object hash %q", hash)
+	}
+	if _, err := w.file.Write(data); err != nil {
+		return fmt.Errorf("failed to write pack: %w", err)
+	}
+	w.index = append(w.index, packEntry{hash: hash, offset: w.offset, length: int64(len(data))})
+	w.offset += int64(len(data))
+	return nil
+}
+
+// size returns how many bytes have been written
+func (w *packWriter) size() int64 {
+	return w.offset
+}
+
+// finish writes the index and moves the pack into place, named after the
+// hash of its index, and returns its path and index
+func (w *packWriter) finish() (string, []packEntry, error) {
+	raw := make([]byte, 0, len(w.index)*packEntrySize+packFooterSize)
+	for _, e := range w.index {
+		sum, _ := hex.DecodeString(e.hash)
+		raw = append(raw, sum...)
+		raw = binary.LittleEndian.AppendUint64(raw, uint64(e.offset))
+		raw = binary.LittleEndian.AppendUint64(raw, uint64(e.length))
+	}
+	id := sha256.Sum256(raw)
+	name := hex.EncodeToString(id[:])
+
+	footer := binary.LittleEndian.AppendUint32(nil, uint32(len(w.index)))
+	footer = binary.LittleEndian.AppendUint32(footer, crc32.ChecksumIEEE(raw))
+	footer = append(footer, packMagic...)
+
+	if _, err := w.file.Write(append(raw, footer...)); err != nil {
+		w.abort()
+		return "", nil, fmt.Errorf("failed to write pack: %w", err)
+	}
+	// The loose copies are deleted next, so the pack must be on disk first
+	if err := w.file.Sync(); err != nil {
+		w.abort()
+		return "", nil, fmt.Errorf("failed to sync pack: %w", err)
+	}
+	if err := w.file.Close(); err != nil {
+		os.Remove(w.file.Name())
+		return "", nil, fmt.Errorf("failed to write pack: %w", err)
+	}
+
+	path := filepath.Join(w.dir, name[:2], name+packExt)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		os.Remove(w.file.Name())
+		return "", nil, fmt.Errorf("failed to create pack directory: %w", err)
+	}
+	if err := os.Rename(w.file.Name(), path); err != nil {
+		os.Remove(w.file.Name())
+		return "", nil, fmt.Errorf("failed to store pack: %w", err)
+	}
+
+	for i := range w.index {
+		w.index[i].pack = path
+	}
+	return path, w.index, nil
+}
+
+// abort discards the pack
+func (w *packWriter) abort() {
+	w.file.Close()
+	os.Remove(w.file.Name())
+}
+
+// PackStats summarizes a Pack run
+type PackStats struct {
+	Objects int   `json:"objects"` // Objects moved into packs
+	Bytes   int64 `json:"bytes"`   // Their size
+	Packs   int   `json:"packs"`   // Packs written
+}
+
+// Pack moves loose objects into pack files of about size bytes each
+// Objects are packed in the order they were written, which keeps the chunks
+// of a file together so a restore reads neighbouring ranges of one pack.
+// Each pack is synced before the loose copies it holds are deleted, so an
+// interruption at worst leaves an object both loose and packed.
+func (c *CAS) Pack(size int64) (*PackStats, error) {
+	if size <= 0 {
+		size = DefaultPackSize
+	}
+
+	type loose struct {
+		hash    string
+		modTime time.Time
+	}
+	var objects []loose
+	err := filepath.Walk(c.basePath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() && len(info.Name()) == 64 {
+			objects = append(objects, loose{info.Name(), info.ModTime()})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list objects: %w", err)
+	}
+	sort.SliceStable(objects, func(i, j int) bool {
+		return objects[i].modTime.Before(objects[j].modTime)
+	})
+
+	// Left behind by an interrupted run; packing holds the repository exclusively
+	if leftovers, err := filepath.Glob(filepath.Join(c.packs.dir, "pack-*.tmp")); err == nil {
+		for _, path := range leftovers {
+			os.Remove(path)
+		}
+	}
+
+	stats := &PackStats{}
+	var w *packWriter
+	flush := func() error {
+		if w == nil {
+			return nil
+		}
+		path, index, err := w.finish()
+		w = nil
+		if err != nil {
+			return err
+		}
+		if err := c.packs.load(); err != nil {
+			return err
+		}
+		if err := c.packs.add(path, index); err != nil {
+			return err
+		}
+
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		for _, e := range index {
+			if err := os.Remove(c.objectPath(e.hash)); err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("failed to remove packed object: %w", err)
+			}
+			stats.Objects++
+			stats.Bytes += e.length
+		}
+		stats.Packs++
+		return nil
+	}
+
+	for _, obj := range objects {
+		data, err := os.ReadFile(c.objectPath(obj.hash))
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			if w != nil {
+				w.abort()
+			}
+			return stats, fmt.Errorf("failed to read object: %w", err)
+		}
+
+		if w == nil {
+			if w, err = newPackWriter(c.packs.dir); err != nil {
+				return stats, err
+			}
+		}
+		if err := w.add(obj.hash, data); err != nil {
+			w.abort()
+			return stats, err
+		}
+		if w.size() >= size {
+			if err := flush(); err != nil {
+				return stats, err
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		return stats, err
+	}
+	return stats, nil
+}
+
+// repack rewrites the packs written before cutoff that hold objects keep
+// rejects or copies superseded by a newer one, leaving out those entries;
+// packs left with nothing are deleted. It returns how many packs it
+// rewrote or deleted.
+func (c *CAS) repack(keep func(hash string) bool, cutoff time.Time) (int, error) {
+	paths, err := c.packs.list()
+	if err != nil {
+		return 0, err
+	}
+
+	repacked := 0
+	for _, path := range paths {
+		if c.packs.modTime(path).After(cutoff) {
+			continue
+		}
+		index, err := readPackIndex(path)
+		if err != nil {
+			return repacked, err
+		}
+
+		var live []packEntry
+		for _, e := range index {
+			current, ok := c.packs.lookup(e.hash)
+			if ok && current.pack == path && current.offset == e.offset && keep(e.hash) && !c.hasLoose(e.hash) {
+				live = append(live, e)
+			}
+		}
+		if len(live) == len(index) {
+			continue
+		}
+
+		if len(live) > 0 {
+			w, err := newPackWriter(c.packs.dir)
+			if err != nil {
+				return repacked, err
+			}
+			for _, e := range live {
+				data, err := e.read()
+				if err == nil {
+					err = w.add(e.hash, data)
+				}
+				if err != nil {
+					w.abort()
+					return repacked, err
+				}
+			}
+			newPath, newIndex, err := w.finish()
+			if err != nil {
+				return repacked, err
+			}
+			if err := c.packs.add(newPath, newIndex); err != nil {
+				return repacked, err
+			}
+		}
+		if err := c.packs.remove(path, index); err != nil {
+			return repacked, err
+		}
+		repacked++
+	}
+	return repacked, nil
+}
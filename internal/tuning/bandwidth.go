@@ -0,0 +1,43 @@
+package tuning
+
+import (
+	"sync"
+	"time"
+)
+
+// Bandwidth paces a byte stream to a steady number of bytes per second,
+// shared by every goroutine that waits on it. Like Rate, unused time does
+// not build up into a burst. A nil Bandwidth is unlimited.
+type Bandwidth struct {
+	mu          sync.Mutex
+	bytesPerSec int64
+	next        time.Time
+}
+
+// NewBandwidth creates a limit of bytesPerSec; bytesPerSec <= 0 returns nil
+func NewBandwidth(bytesPerSec int64) *Bandwidth {
+	if bytesPerSec <= 0 {
+		return nil
+	}
+	return &Bandwidth{bytesPerSec: bytesPerSec}
+}
+
+// Wait blocks until n more bytes may be transferred
+func (b *Bandwidth) Wait(n int) {
+	if b == nil || n <= 0 {
+		return
+	}
+
+	b.mu.Lock()
+	now := time.Now()
+	if b.next.Before(now) {
+		b.next = now
+	}
+	wait := b.next.Sub(now)
+	b.next = b.next.Add(time.Duration(int64(n) * int64(time.Second) / b.bytesPerSec))
+	b.mu.Unlock()
+
+	if wait > 0 {
+		time.Sleep(wait)
+	}
+}
@@ -0,0 +1,65 @@
+package tuning
+
+import "sync"
+
+// Budget is a memory budget shared by the stages of a backup or restore
+// Callers reserve the bytes they are about to buffer and release them
+// when the data has been handed off, so the total held at once stays
+// under the budget. A nil Budget is unlimited.
+type Budget struct {
+	mu    sync.Mutex
+	cond  *sync.Cond
+	total int64
+	used  int64
+}
+
+// NewBudget creates a budget of total bytes; total <= 0 returns nil
+func NewBudget(total int64) *Budget {
+	if total <= 0 {
+		return nil
+	}
+	b := &Budget{total: total}
+	b.cond = sync.NewCond(&b.mu)
+	return b
+}
+
+// Reserve blocks until n bytes are available and returns the amount
+// actually reserved, which is capped at the budget so that a single
+// oversized request can still proceed on its own
+func (b *Budget) Reserve(n int64) int64 {
+	if b == nil || n <= 0 {
+		return 0
+	}
+	if n > b.total {
+		n = b.total
+	}
+
+	b.mu.Lock()
+	for b.used+n > b.total {
+		b.cond.Wait()
+	}
+	b.used += n
+	b.mu.Unlock()
+
+	return n
+}
+
+// Release returns bytes obtained from Reserve
+func (b *Budget) Release(n int64) {
+	if b == nil || n <= 0 {
+		return
+	}
+
+	b.mu.Lock()
+	b.used -= n
+	b.mu.Unlock()
+	b.cond.Broadcast()
+}
+
+// Total returns the size of the budget, 0 if unlimited
+func (b *Budget) Total() int64 {
+	if b == nil {
+		return 0
+	}
+	return b.total
+}
@@ -0,0 +1,11 @@
+//go:build !unix
+
+package tuning
+
+import "time"
+
+// processCPUTime is unavailable on this platform; CPU saturation is then
+// not considered when tuning
+func processCPUTime() time.Duration {
+	return 0
+}
@@ -0,0 +1,221 @@
+package tuning
+
+import (
+	"runtime"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultInterval is how often an adaptive limiter re-evaluates
+	DefaultInterval = 2 * time.Second
+
+	// cpuSaturated is the process CPU utilization above which adding
+	// workers only adds contention
+	cpuSaturated = 0.9
+
+	// latencyBackoff is how far mean operation latency may rise above the
+	// best observed before concurrency is reduced
+	latencyBackoff = 2.0
+
+	// minGain is the relative throughput improvement that justifies
+	// keeping an increase
+	minGain = 0.05
+)
+
+// Limiter bounds the number of concurrent workers. In adaptive mode it
+// hill-climbs between floor and ceiling: concurrency keeps growing while
+// throughput improves, and backs off when throughput drops, when the
+// process saturates the CPUs, or when per-operation latency (disk or
+// backend) balloons.
+type Limiter struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	limit   int
+	inUse   int
+	floor   int
+	ceiling int
+
+	// Observations since the last adjustment
+	bytes   int64
+	ops     int64
+	latency time.Duration
+
+	// Hill-climbing state
+	lastThroughput float64
+	bestLatency    time.Duration
+	direction      int
+	lastCPU        time.Duration
+	lastTick       time.Time
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewLimiter creates a fixed limiter allowing n concurrent workers
+// n <= 0 means one worker per CPU
+func NewLimiter(n int) *Limiter {
+	if n <= 0 {
+		n = runtime.NumCPU()
+	}
+	l := &Limiter{limit: n, floor: n, ceiling: n}
+	l.cond = sync.NewCond(&l.mu)
+	return l
+}
+
+// NewAdaptive creates a limiter that tunes itself between floor and ceiling
+// A ceiling <= 0 means one worker per CPU. Call Stop when done.
+func NewAdaptive(floor, ceiling int, interval time.Duration) *Limiter {
+	if ceiling <= 0 {
+		ceiling = runtime.NumCPU()
+	}
+	if floor <= 0 {
+		floor = 1
+	}
+	if floor > ceiling {
+		floor = ceiling
+	}
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+
+	l := &Limiter{
+		limit:     floor,
+		floor:     floor,
+		ceiling:   ceiling,
+		direction: 1,
+		lastCPU:   processCPUTime(),
+		lastTick:  time.Now(),
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	l.cond = sync.NewCond(&l.mu)
+
+	go l.run(interval)
+	return l
+}
+
+// Acquire blocks until a worker slot is available
+func (l *Limiter) Acquire() {
+	l.mu.Lock()
+	for l.inUse >= l.limit {
+		l.cond.Wait()
+	}
+	l.inUse++
+	l.mu.Unlock()
+}
+
+// Release frees a worker slot and records the work it completed
+func (l *Limiter) Release(bytes int64, elapsed time.Duration) {
+	l.mu.Lock()
+	l.inUse--
+	l.bytes += bytes
+	l.ops++
+	l.latency += elapsed
+	l.mu.Unlock()
+	l.cond.Signal()
+}
+
+// Limit returns the current concurrency limit
+func (l *Limiter) Limit() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.limit
+}
+
+// Ceiling returns the maximum concurrency the limiter will allow
+func (l *Limiter) Ceiling() int {
+	return l.ceiling
+}
+
+// Stop ends adaptive tuning
+func (l *Limiter) Stop() {
+	if l.stop == nil {
+		return
+	}
+	close(l.stop)
+	<-l.done
+	l.stop = nil
+}
+
+func (l *Limiter) run(interval time.Duration) {
+	defer close(l.done)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-ticker.C:
+			l.adjust()
+		}
+	}
+}
+
+// adjust moves the limit one step based on observations since the last call
+func (l *Limiter) adjust() {
+	now := time.Now()
+	cpu := processCPUTime()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	wall := now.Sub(l.lastTick)
+	cpuUtil := 0.0
+	if wall > 0 && cpu > 0 {
+		cpuUtil = float64(cpu-l.lastCPU) / float64(wall) / float64(runtime.NumCPU())
+	}
+	l.lastTick, l.lastCPU = now, cpu
+
+	bytes, ops, latency := l.bytes, l.ops, l.latency
+	l.bytes, l.ops, l.latency = 0, 0, 0
+
+	// Nothing completed; the workload is idle or a single slow operation
+	if ops == 0 || wall <= 0 {
+		return
+	}
+
+	throughput := float64(bytes) / wall.Seconds()
+	meanLatency := latency / time.Duration(ops)
+	if l.bestLatency == 0 || meanLatency < l.bestLatency {
+		l.bestLatency = meanLatency
+	}
+
+	switch {
+	case cpuUtil > cpuSaturated:
+		// More workers would only compete for CPU
+		l.direction = -1
+	case float64(meanLatency) > latencyBackoff*float64(l.bestLatency) && throughput < l.lastThroughput*(1+minGain):
+		// Storage is queueing requests without delivering more
+		l.direction = -1
+	case l.lastThroughput == 0:
+		l.direction = 1
+	case throughput > l.lastThroughput*(1+minGain):
+		// The last step helped; keep going the same way
+		if l.direction == 0 {
+			l.direction = 1
+		}
+	case throughput < l.lastThroughput*(1-minGain):
+		// The last step hurt, or the workload changed while holding
+		if l.direction == 0 {
+			l.direction = 1
+		} else {
+			l.direction = -l.direction
+		}
+	default:
+		// Plateau; hold until conditions change
+		l.direction = 0
+	}
+	l.lastThroughput = throughput
+
+	l.limit += l.direction
+	if l.limit < l.floor {
+		l.limit = l.floor
+	}
+	if l.limit > l.ceiling {
+		l.limit = l.ceiling
+	}
+	l.cond.Broadcast()
+}
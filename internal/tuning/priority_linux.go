@@ -0,0 +1,47 @@
+//go:build linux
+
+package tuning
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"syscall"
+)
+
+const (
+	// lowestNice is the nice value of the lowest CPU priority
+	lowestNice = 19
+
+	// ioprioLowest is the best-effort I/O class at its lowest level, as
+	// ionice -c2 -n7 sets it; the idle class could stall a restore for as
+	// long as anything else touches the disk
+	ioprioLowest = 2<<13 | 7
+
+	// ioprioWhoProcess makes ioprio_set apply to a thread ID
+	ioprioWhoProcess = 1
+)
+
+// LowerPriority moves the process to the lowest CPU and I/O priority
+// Linux keeps both per thread, so every existing thread is changed; threads
+// started later inherit the priority of the one that starts them.
+func LowerPriority() error {
+	tasks, err := os.ReadDir("/proc/self/task")
+	if err != nil {
+		return fmt.Errorf("failed to list threads: %w", err)
+	}
+	for _, task := range tasks {
+		tid, err := strconv.Atoi(task.Name())
+		if err != nil {
+			continue
+		}
+		if err := syscall.Setpriority(syscall.PRIO_PROCESS, tid, lowestNice); err != nil && err != syscall.ESRCH {
+			return fmt.Errorf("failed to lower CPU priority: %w", err)
+		}
+		_, _, errno := syscall.Syscall(syscall.SYS_IOPRIO_SET, ioprioWhoProcess, uintptr(tid), ioprioLowest)
+		if errno != 0 && errno != syscall.ESRCH {
+			return fmt.Errorf("failed to lower I/O priority: %w", errno)
+		}
+	}
+	return nil
+}
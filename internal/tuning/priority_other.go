@@ -0,0 +1,10 @@
+//go:build !unix && !windows
+
+package tuning
+
+import "errors"
+
+// LowerPriority is unavailable on this platform
+func LowerPriority() error {
+	return errors.New("lowering the priority is not supported on this platform")
+}
@@ -0,0 +1,21 @@
+//go:build unix && !linux
+
+package tuning
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// lowestNice is the nice value of the lowest CPU priority
+const lowestNice = 19
+
+// LowerPriority moves the process to the lowest CPU priority
+// These systems have no I/O priority that can be set the same way, so disk
+// access is left as it is.
+func LowerPriority() error {
+	if err := syscall.Setpriority(syscall.PRIO_PROCESS, 0, lowestNice); err != nil {
+		return fmt.Errorf("failed to lower CPU priority: %w", err)
+	}
+	return nil
+}
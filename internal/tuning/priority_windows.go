@@ -0,0 +1,27 @@
+//go:build windows
+
+package tuning
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// processModeBackgroundBegin lowers a process's CPU, I/O and memory
+// priority all at once
+const processModeBackgroundBegin = 0x00100000
+
+var setPriorityClass = syscall.NewLazyDLL("kernel32.dll").NewProc("SetPriorityClass")
+
+// LowerPriority moves the process into background processing mode, which
+// lowers its CPU, I/O and memory priority
+func LowerPriority() error {
+	process, err := syscall.GetCurrentProcess()
+	if err != nil {
+		return fmt.Errorf("failed to lower priority: %w", err)
+	}
+	if ok, _, err := setPriorityClass.Call(uintptr(process), processModeBackgroundBegin); ok == 0 {
+		return fmt.Errorf("failed to lower priority: %w", err)
+	}
+	return nil
+}
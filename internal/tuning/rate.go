@@ -0,0 +1,43 @@
+package tuning
+
+import (
+	"sync"
+	"time"
+)
+
+// Rate paces operations to a steady number per second, shared by every
+// goroutine that waits on it. Unused time does not build up into a burst,
+// so a slow stretch is never followed by a spike. A nil Rate is unlimited.
+type Rate struct {
+	mu       sync.Mutex
+	interval time.Duration
+	next     time.Time
+}
+
+// NewRate creates a rate of perSecond operations; perSecond <= 0 returns nil
+func NewRate(perSecond int) *Rate {
+	if perSecond <= 0 {
+		return nil
+	}
+	return &Rate{interval: time.Second / time.Duration(perSecond)}
+}
+
+// Wait blocks until the next operation may start
+func (r *Rate) Wait() {
+	if r == nil {
+		return
+	}
+
+	r.mu.Lock()
+	now := time.Now()
+	if r.next.Before(now) {
+		r.next = now
+	}
+	wait := r.next.Sub(now)
+	r.next = r.next.Add(r.interval)
+	r.mu.Unlock()
+
+	if wait > 0 {
+		time.Sleep(wait)
+	}
+}
@@ -21,14 +21,38 @@ type FileNode struct {
 	Mode    os.FileMode `json:"mode"`
 	Size    int64       `json:"size"`
 	ModTime time.Time   `json:"mod_time"`
-	Hash    string      `json:"hash"`   // Full file content hash
-	Chunks  []string    `json:"chunks"` // List of chunk hashes
+	Hash    string      `json:"hash"`             // Full file content hash
+	ETag    string      `json:"etag,omitempty"`   // Version tag of an object backed up from a bucket
+	Chunks  []string    `json:"chunks"`           // List of chunk hashes
+	Inline  []byte      `json:"inline,omitempty"` // Contents of tiny files, encrypted if the snapshot is
+	SQLite  bool        `json:"sqlite,omitempty"` // Live SQLite database captured consistently
+	// Content hash of a captured copy that differs from the live file; Hash
+	// stays the live file's so unchanged databases are not seen as modified
+	CopyHash string `json:"copy_hash,omitempty"`
+	// Linux file capabilities, the raw security.capability attribute
+	Capability []byte `json:"capability,omitempty"`
+	// Linux inode flags set with chattr: "immutable", "append"
+	Flags []string `json:"flags,omitempty"`
+}
+
+// ContentHash returns the hash of the content stored for the node
+func (n *FileNode) ContentHash() string {
+	if n.CopyHash != "" {
+		return n.CopyHash
+	}
+	return n.Hash
+}
+
+// IsBlockDevice reports whether the node is a block device whose contents
+// are backed up as a single raw stream
+func (n *FileNode) IsBlockDevice() bool {
+	return n.Mode&os.ModeDevice != 0 && n.Mode&os.ModeCharDevice == 0
 }
 
 // FileTree represents the hierarchical structure of files
 type FileTree struct {
 	Root      *FileNode            `json:"root"`
-	Files     map[string]*FileNode `json:"files"` // Path -> FileNode
+	Files     map[string]*FileNode `json:"files,omitempty"` // Path -> FileNode
 	TotalSize int64                `json:"total_size"`
 	FileCount int                  `json:"file_count"`
 	DirCount  int                  `json:"dir_count"`
@@ -40,18 +64,67 @@ type Snapshot struct {
 	Timestamp   time.Time     `json:"timestamp"`
 	Parent      string        `json:"parent,omitempty"` // Parent snapshot ID for incremental
 	Description string        `json:"description,omitempty"`
+	Hostname    string        `json:"hostname,omitempty"` // Machine the backup ran on
+	Tags        []string      `json:"tags,omitempty"`
 	Tree        *FileTree     `json:"tree"`
+	TreeHash    string        `json:"tree_hash,omitempty"` // Root directory object; Tree.Files is loaded from it
 	Stats       SnapshotStats `json:"stats"`
 	Encrypted   bool          `json:"encrypted"`
 	Compressed  bool          `json:"compressed"`
+	// File names and tree objects are encrypted with the repository key
+	EncryptedNames bool `json:"encrypted_names,omitempty"`
+	// Compliance lock: the snapshot cannot be deleted before this time
+	RetainUntil *time.Time `json:"retain_until,omitempty"`
+	// Retention tier the snapshot is pinned to, kept by that tier's rules
+	Tier string `json:"tier,omitempty"`
+	// Temporary backups: the snapshot is removed automatically after this time
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	// Tamper-evident history: each record commits to the one before it
+	ChainPrev     string `json:"chain_prev,omitempty"`      // ID of the previous snapshot
+	ChainPrevHash string `json:"chain_prev_hash,omitempty"` // Chain hash of the previous snapshot
+	// Expiry of the previous snapshot, so its removal once expired is expected
+	ChainPrevExpires *time.Time `json:"chain_prev_expires,omitempty"`
+	ChainHash        string     `json:"chain_hash,omitempty"` // Hash of this record
+	// Objects the snapshot needs were missing when the repository was repaired
+	Degraded *Degradation `json:"degraded,omitempty"`
+	// Files were recorded with their sizes and hashes but without their
+	// contents, so nothing in the snapshot can be restored
+	MetadataOnly bool `json:"metadata_only,omitempty"`
+	// A copy from another repository holding only the files that match
+	// these patterns, with the directories above them
+	PartialPaths []string `json:"partial_paths,omitempty"`
+}
+
+// Degradation records what a snapshot lost to missing objects
+type Degradation struct {
+	Since          time.Time `json:"since"`           // When repair first found the damage
+	MissingObjects int       `json:"missing_objects"` // Chunks and tree objects not in the store
+	Files          int       `json:"files"`           // Files that cannot be restored in full
+	MissingTrees   int       `json:"missing_trees"`   // Directories whose contents are lost
+}
+
+// RetentionLocked reports whether the snapshot is still under a
+// retention lock at the given time
+func (s *Snapshot) RetentionLocked(now time.Time) bool {
+	return s.RetainUntil != nil && now.Before(*s.RetainUntil)
+}
+
+// Expired reports whether the snapshot's expiry time has passed
+func (s *Snapshot) Expired(now time.Time) bool {
+	return s.ExpiresAt != nil && !now.Before(*s.ExpiresAt)
 }
 
 // SnapshotStats contains statistics about a snapshot
 type SnapshotStats struct {
-	TotalSize        int64         `json:"total_size"`        // Original data size
-	StoredSize       int64         `json:"stored_size"`       // Size after dedup/compression
-	ChunkCount       int           `json:"chunk_count"`       // Total chunks
-	NewChunks        int           `json:"new_chunks"`        // Chunks not in previous snapshots
+	TotalSize        int64         `json:"total_size"`               // Original data size
+	StoredSize       int64         `json:"stored_size"`              // Size after dedup/compression
+	ChunkCount       int           `json:"chunk_count"`              // Total chunks
+	NewChunks        int           `json:"new_chunks"`               // Chunks not in previous snapshots
+	NewChunkSize     int64         `json:"new_chunk_size,omitempty"` // Original size of the new chunks
+	ParentChunks     int           `json:"parent_chunks,omitempty"`  // Chunks reused from the parent snapshot
+	ParentSize       int64         `json:"parent_size,omitempty"`
+	ReusedChunks     int           `json:"reused_chunks,omitempty"` // Chunks already stored for other data
+	ReusedSize       int64         `json:"reused_size,omitempty"`
 	DeduplicatedSize int64         `json:"deduplicated_size"` // Bytes saved by dedup
 	CompressionRatio float64       `json:"compression_ratio"` // Compression ratio
 	Duration         time.Duration `json:"duration"`          // Time to create snapshot
@@ -59,6 +132,8 @@ type SnapshotStats struct {
 	FilesModified    int           `json:"files_modified"`
 	FilesDeleted     int           `json:"files_deleted"`
 	FilesUnchanged   int           `json:"files_unchanged"`
+	FilesRenamed     int           `json:"files_renamed,omitempty"`
+	FilesFailed      int           `json:"files_failed,omitempty"` // Left out after retries ran out
 }
 
 // DiffType represents the type of change between snapshots
@@ -69,11 +144,13 @@ const (
 	DiffModified  DiffType = "modified"
 	DiffDeleted   DiffType = "deleted"
 	DiffUnchanged DiffType = "unchanged"
+	DiffRenamed   DiffType = "renamed"
 )
 
 // FileDiff represents a difference between two file versions
 type FileDiff struct {
 	Path      string   `json:"path"`
+	OldPath   string   `json:"old_path,omitempty"` // Previous path of a renamed file
 	Type      DiffType `json:"type"`
 	OldHash   string   `json:"old_hash,omitempty"`
 	NewHash   string   `json:"new_hash,omitempty"`
@@ -89,20 +166,42 @@ type RestoreOptions struct {
 	TargetPath     string   // Where to restore files
 	IncludePattern []string // Glob patterns to include
 	ExcludePattern []string // Glob patterns to exclude
+	IncludePaths   []string // Exact paths to include; listed directories include their contents
 	Overwrite      bool     // Overwrite existing files
+	Delete         bool     // Move target files the snapshot does not contain to the trash
 	PreservePerms  bool     // Preserve file permissions
 	DryRun         bool     // Don't actually restore, just show what would happen
+	// Shell commands run before and after the restore, e.g. to stop and
+	// start the service whose data is restored
+	PreHooks    []string
+	PostHooks   []string
+	HookTimeout time.Duration // Per hook, 0 = no limit
 }
 
 // BackupOptions configures backup behavior
 type BackupOptions struct {
-	SourcePath     string   // Directory to backup
-	RepoPath       string   // Repository path
-	Description    string   // Snapshot description
-	ExcludePattern []string // Glob patterns to exclude
-	Encrypt        bool     // Enable encryption
-	Compress       bool     // Enable compression
-	CloudUpload    bool     // Upload to cloud after local backup
+	SourcePath      string   // Directory to backup
+	RepoPath        string   // Repository path
+	Description     string   // Snapshot description
+	ExcludePattern  []string // Glob patterns to exclude
+	ExcludePresets  []string // Built-in exclusion presets to apply
+	AppPresets      []string // App data presets to apply
+	Tags            []string // Tags recorded on the snapshot
+	Encrypt         bool     // Enable encryption
+	Compress        bool     // Enable compression
+	CloudUpload     bool     // Upload to cloud after local backup
+	FSSnapshot      bool     // Back up from a temporary btrfs/ZFS/APFS snapshot
+	LVMSnapshot     bool     // Back up from a temporary LVM snapshot
+	LVMSnapshotSize string   // Copy-on-write space reserved for the LVM snapshot
+	MMap            bool     // Read source files through memory mappings
+	RetainUntil     string   // Retention lock for the new snapshot (date or duration)
+	Tier            string   // Retention tier to pin the new snapshot to
+	Expire          string   // Expiry of the new snapshot (date or duration)
+	Retries         *int     // Passes over busy or vanished files, nil = config
+	ScanWorkers     int      // Directories listed and files hashed at once, 0 = config
+	ScanRate        int      // Scan stat/readdir/open calls per second, 0 = config
+	Parallel        int      // Workers per backup stage, 0 = config
+	MetadataOnly    bool     // Record the file tree without storing file contents
 }
 
 // RepositoryInfo contains metadata about a backup repository
@@ -114,4 +213,6 @@ type RepositoryInfo struct {
 	TotalSize     int64     `json:"total_size"`
 	ChunkCount    int       `json:"chunk_count"`
 	Encrypted     bool      `json:"encrypted"`
+	ID            string    `json:"id,omitempty"`          // Random identity, given at init or first copy
+	CopySource    string    `json:"copy_source,omitempty"` // ID of the repository snapshots are copied from
 }